@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/weaveworks/flux"
@@ -8,30 +10,67 @@ import (
 	"github.com/weaveworks/flux/platform"
 )
 
+// ClientService is what clients (e.g., fluxctl) can ask of the
+// service. Calls give up on waiting for the daemon, and return the
+// context's error, once the context given is done.
 type ClientService interface {
-	Status(inst flux.InstanceID) (flux.Status, error)
-	ListServices(inst flux.InstanceID, namespace string) ([]flux.ServiceStatus, error)
-	ListImages(flux.InstanceID, flux.ServiceSpec) ([]flux.ImageStatus, error)
-	PostRelease(flux.InstanceID, jobs.ReleaseJobParams) (jobs.JobID, error)
-	GetRelease(flux.InstanceID, jobs.JobID) (jobs.Job, error)
-	Automate(flux.InstanceID, flux.ServiceID) error
-	Deautomate(flux.InstanceID, flux.ServiceID) error
-	Lock(flux.InstanceID, flux.ServiceID) error
-	Unlock(flux.InstanceID, flux.ServiceID) error
-	History(flux.InstanceID, flux.ServiceSpec, time.Time, int64) ([]flux.HistoryEntry, error)
-	GetConfig(_ flux.InstanceID, fingerprint string) (flux.InstanceConfig, error)
-	SetConfig(flux.InstanceID, flux.UnsafeInstanceConfig) error
-	PatchConfig(flux.InstanceID, flux.ConfigPatch) error
-	GenerateDeployKey(flux.InstanceID) error
-	Export(inst flux.InstanceID) ([]byte, error)
+	Status(ctx context.Context, inst flux.InstanceID) (flux.Status, error)
+	ListServices(ctx context.Context, inst flux.InstanceID, namespace string) ([]flux.ServiceStatus, error)
+	ListImages(ctx context.Context, _ flux.InstanceID, _ flux.ServiceSpec, only []flux.ImageStatusFilter) ([]flux.ImageStatus, error)
+	PostRelease(context.Context, flux.InstanceID, jobs.ReleaseJobParams) (jobs.JobID, error)
+	ValidateRelease(context.Context, flux.InstanceID, flux.ReleaseSpec) ([]flux.ReleaseProblem, error)
+	GetRelease(ctx context.Context, _ flux.InstanceID, _ jobs.JobID, wait time.Duration) (jobs.Job, error)
+	ListFailedJobs(context.Context, flux.InstanceID) ([]jobs.Job, error)
+	RequeueJob(context.Context, flux.InstanceID, jobs.JobID) error
+	AbandonJob(context.Context, flux.InstanceID, jobs.JobID) error
+	JobLog(context.Context, flux.InstanceID, jobs.JobID) ([]string, error)
+	PurgeFailedJobs(context.Context, flux.InstanceID) (int64, error)
+	SyncNotify(context.Context, flux.InstanceID) error
+	Automate(context.Context, flux.InstanceID, flux.ServiceID) error
+	Deautomate(context.Context, flux.InstanceID, flux.ServiceID) error
+	Lock(context.Context, flux.InstanceID, flux.ServiceID) error
+	Unlock(context.Context, flux.InstanceID, flux.ServiceID) error
+	SetMinReleaseInterval(context.Context, flux.InstanceID, flux.ServiceID, time.Duration) error
+	SetTagFilter(ctx context.Context, _ flux.InstanceID, _ flux.ServiceID, pattern string) error
+	Pause(ctx context.Context, _ flux.InstanceID, user, reason string) error
+	Resume(ctx context.Context, _ flux.InstanceID, user string) error
+	History(context.Context, flux.InstanceID, flux.ServiceSpec, flux.HistoryQuery) (flux.HistoryPage, error)
+	HistoryAllClusters(context.Context, flux.InstanceID, flux.ServiceID, time.Time, int64) ([]flux.HistoryEntry, error)
+	ReleaseHistory(context.Context, flux.InstanceID, flux.ServiceID) ([]flux.ServiceVersion, error)
+	ServiceChanges(context.Context, flux.InstanceID, flux.ServiceID) ([]flux.ServiceChange, error)
+	Lint(context.Context, flux.InstanceID) (flux.LintReport, error)
+	GetConfig(ctx context.Context, _ flux.InstanceID, fingerprint string) (flux.InstanceConfig, error)
+	SetConfig(context.Context, flux.InstanceID, flux.UnsafeInstanceConfig) error
+	// ValidateConfig checks a config as SetConfig would, including
+	// that the git repo can be cloned, without saving it.
+	ValidateConfig(context.Context, flux.InstanceID, flux.UnsafeInstanceConfig) error
+	PatchConfig(context.Context, flux.InstanceID, flux.ConfigPatch) error
+	GenerateDeployKey(context.Context, flux.InstanceID) error
+	Export(ctx context.Context, inst flux.InstanceID) ([]byte, error)
+	// ExportTo writes the export as it comes, for exports too big to
+	// get in one go.
+	ExportTo(context.Context, flux.InstanceID, io.Writer) error
+	Trace(context.Context, flux.InstanceID, time.Duration) error
+	ListReleaseTemplates(context.Context, flux.InstanceID) ([]flux.ReleaseTemplate, error)
+	SetReleaseTemplate(context.Context, flux.InstanceID, flux.ReleaseTemplate) error
+	DeleteReleaseTemplate(ctx context.Context, _ flux.InstanceID, name string) error
+	// PostReleaseFromTemplate releases the spec saved in the template,
+	// as if it had been given to PostRelease, by the user given.
+	PostReleaseFromTemplate(ctx context.Context, _ flux.InstanceID, name, user string) (jobs.JobID, error)
 }
 
 type DaemonService interface {
+	// RegisterDaemon lasts as long as the daemon is connected, so
+	// takes no context.
 	RegisterDaemon(flux.InstanceID, platform.Platform) error
-	IsDaemonConnected(flux.InstanceID) error
+	IsDaemonConnected(context.Context, flux.InstanceID) error
 }
 
 type FluxService interface {
 	ClientService
 	DaemonService
+	// SetBranchProtection records what the GitHub integration found
+	// when it checked the config branch's protection. It's not for
+	// clients, which could otherwise change what Status reports.
+	SetBranchProtection(context.Context, flux.InstanceID, flux.BranchProtection) error
 }
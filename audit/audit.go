@@ -0,0 +1,86 @@
+// Package audit keeps a record of the API calls that change things:
+// who made each call, what it was, and when. Unlike the history of
+// events, which says what happened to services, the audit log says
+// what was asked for, and is only ever appended to.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+// UserHeaderKey is the header in which clients (or a proxy in front
+// of fluxsvc) may say which user a call is made for.
+const UserHeaderKey = "X-Flux-User"
+
+// Entry is the record of one call.
+type Entry struct {
+	Instance flux.InstanceID `json:"instance"`
+	Time     time.Time       `json:"time"`
+	// Route is the API route called, e.g., "PostRelease".
+	Route string `json:"route"`
+	// Target is what the call was about, as given in the query
+	// string; e.g., the service and image of a release.
+	Target string `json:"target,omitempty"`
+	// Token identifies the token the call was made with (though not
+	// by the token itself), if there was one.
+	Token string `json:"token,omitempty"`
+	// User is who the call said it was made for, if anyone.
+	User string `json:"user,omitempty"`
+	// BodyDigest is the digest of the request body, so a call can be
+	// matched with what was sent without the log keeping anything
+	// secret that was in it (e.g., in the instance config).
+	BodyDigest string `json:"bodyDigest,omitempty"`
+	StatusCode int    `json:"statusCode"`
+}
+
+// Query says which entries to give, most recent first.
+type Query struct {
+	// Instance, if not blank, is the only instance to give entries
+	// for.
+	Instance flux.InstanceID
+	// Route, if not blank, is the only route to give entries for.
+	Route string
+	// Before, if not zero, is the time before which entries must
+	// have been recorded.
+	Before time.Time
+	// Limit is how many entries to give at most, or -1 for no limit.
+	Limit int64
+}
+
+type Writer interface {
+	// Record appends an entry to the log.
+	Record(Entry) error
+}
+
+type Reader interface {
+	// Entries gives the entries asked for, in descending order of
+	// time.
+	Entries(Query) ([]Entry, error)
+}
+
+type DB interface {
+	Writer
+	Reader
+	io.Closer
+}
+
+// TokenFingerprint gives a short identifier for a token, which is
+// enough to tell which of a set of tokens it is, but not to use it.
+func TokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// Digest gives the digest of a request body.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,112 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/cznic/ql/driver"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+)
+
+// DB is an audit log kept in a SQL database. There's nothing here
+// to change or remove entries, only to add them.
+type DB struct {
+	conn *sql.DB
+}
+
+func New(driver, datasource string) (*DB, error) {
+	conn, err := sql.Open(driver, datasource)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		conn: conn,
+	}
+	return db, db.sanityCheck()
+}
+
+func (db *DB) Record(e audit.Entry) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO audit
+                      (instance_id, stamp, route, target, token, user_name, body_digest, status_code)
+                      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		string(e.Instance), e.Time.UTC(), e.Route, e.Target, e.Token, e.User, e.BodyDigest, e.StatusCode)
+	if err != nil {
+		if err2 := tx.Rollback(); err2 != nil {
+			return errors.Wrapf(err, "transaction rollback failed: %s", err2)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) Entries(q audit.Query) ([]audit.Entry, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(clause string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+	if q.Instance != "" {
+		arg("instance_id = $%d", string(q.Instance))
+	}
+	if q.Route != "" {
+		arg("route = $%d", q.Route)
+	}
+	if !q.Before.IsZero() {
+		arg("stamp < $%d", q.Before.UTC())
+	}
+
+	query := `SELECT instance_id, stamp, route, target, token, user_name, body_digest, status_code FROM audit`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY stamp DESC`
+	if q.Limit >= 0 {
+		args = append(args, q.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var (
+			e    audit.Entry
+			inst string
+		)
+		if err := rows.Scan(&inst, &e.Time, &e.Route, &e.Target, &e.Token, &e.User, &e.BodyDigest, &e.StatusCode); err != nil {
+			return nil, err
+		}
+		e.Instance = flux.InstanceID(inst)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// ---
+
+func (db *DB) sanityCheck() error {
+	_, err := db.conn.Query(`SELECT instance_id, stamp, route, target, token, user_name, body_digest, status_code FROM audit LIMIT 1`)
+	if err != nil {
+		return errors.Wrap(err, "failed sanity check for audit table")
+	}
+	return nil
+}
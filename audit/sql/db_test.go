@@ -0,0 +1,73 @@
+package sql
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+	"github.com/weaveworks/flux/db"
+)
+
+func newDB(t *testing.T) *DB {
+	f, err := ioutil.TempFile("", "fluxy-testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbsource := "file://" + f.Name()
+	if _, err = db.Migrate(dbsource, "../../db/migrations"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := New("ql", dbsource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRecordAndQuery(t *testing.T) {
+	db := newDB(t)
+	defer db.Close()
+
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	inst := flux.InstanceID("floaty-womble-abc123")
+	entries := []audit.Entry{
+		{Instance: inst, Time: start, Route: "SetConfig", Token: "sha256:abc", BodyDigest: "sha256:def", StatusCode: 200},
+		{Instance: inst, Time: start.Add(time.Minute), Route: "PostRelease", Target: "service=default/helloworld", User: "alice", StatusCode: 201},
+		{Instance: "other", Time: start.Add(2 * time.Minute), Route: "SetConfig", StatusCode: 403},
+		{Instance: inst, Time: start.Add(3 * time.Minute), Route: "SetConfig", StatusCode: 500},
+	}
+	for _, e := range entries {
+		if err := db.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		name  string
+		query audit.Query
+		want  []audit.Entry
+	}{
+		{"all", audit.Query{Limit: -1}, []audit.Entry{entries[3], entries[2], entries[1], entries[0]}},
+		{"instance", audit.Query{Instance: inst, Limit: -1}, []audit.Entry{entries[3], entries[1], entries[0]}},
+		{"route", audit.Query{Instance: inst, Route: "SetConfig", Limit: -1}, []audit.Entry{entries[3], entries[0]}},
+		{"before", audit.Query{Before: start.Add(2 * time.Minute), Limit: -1}, []audit.Entry{entries[1], entries[0]}},
+		{"limit", audit.Query{Limit: 1}, []audit.Entry{entries[3]}},
+	} {
+		got, err := db.Entries(c.query)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: expected %d entries, got %d: %+v", c.name, len(c.want), len(got), got)
+			continue
+		}
+		for i := range got {
+			got[i].Time = got[i].Time.UTC()
+			if got[i] != c.want[i] {
+				t.Errorf("%s: entry %d: expected %+v, got %+v", c.name, i, c.want[i], got[i])
+			}
+		}
+	}
+}
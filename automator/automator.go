@@ -9,8 +9,10 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
 	"github.com/weaveworks/flux/instance"
 	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/notifications"
 	"github.com/weaveworks/flux/release"
 )
 
@@ -47,10 +49,20 @@ func (a *Automator) checkAll(errorLogger log.Logger) {
 		errorLogger.Log("err", err)
 		return
 	}
+	now := time.Now().UTC()
 	for _, inst := range insts {
-		if !a.hasAutomatedServices(inst.Config.Services) {
+		// Config kept in the repo is read even if nothing is
+		// automated, so changes to it are picked up. Otherwise, the
+		// repo of a paused instance, or one with nothing automated,
+		// isn't read; drop its time since the last sync, rather than
+		// leave it where it stopped.
+		if inst.Config.Paused != nil || !a.hasAutomatedServices(inst.Config.Services) && !inst.Config.Settings.Git.RepoConfig {
+			syncAge.DeleteLabelValues(string(inst.ID))
 			continue
 		}
+		if err := a.checkSync(inst, now); err != nil {
+			errorLogger.Log("instance", inst.ID, "err", errors.Wrap(err, "checking last sync"))
+		}
 
 		_, err := a.cfg.Jobs.PutJob(inst.ID, automatedInstanceJob(inst.ID, time.Now()))
 		if err != nil && err != jobs.ErrJobAlreadyQueued {
@@ -68,6 +80,16 @@ func (a *Automator) hasAutomatedServices(services map[flux.ServiceID]instance.Se
 	return false
 }
 
+func automatedServices(config instance.Config) []flux.ServiceID {
+	automatedServiceIDs := []flux.ServiceID{}
+	for id, service := range config.Services {
+		if service.Policy() == flux.PolicyAutomated {
+			automatedServiceIDs = append(automatedServiceIDs, id)
+		}
+	}
+	return automatedServiceIDs
+}
+
 func (a *Automator) Handle(j *jobs.Job, updater jobs.JobUpdater) ([]jobs.Job, error) {
 	logger := log.NewContext(a.cfg.Logger).With("job", j.ID)
 	switch j.Method {
@@ -87,14 +109,16 @@ func (a *Automator) handleAutomatedInstanceJob(logger log.Logger, job *jobs.Job,
 		return followUps, errors.Wrap(err, "getting instance config")
 	}
 
-	automatedServiceIDs := []flux.ServiceID{}
-	for id, service := range config.Services {
-		if service.Policy() == flux.PolicyAutomated {
-			automatedServiceIDs = append(automatedServiceIDs, id)
-		}
+	// While automation is paused, nothing is done; checkAll will
+	// queue the job again once it's resumed.
+	if config.Paused != nil {
+		return nil, nil
 	}
 
-	if len(automatedServiceIDs) == 0 {
+	// If config is kept in the repo, we look at the repo even if
+	// nothing is automated, since that may be about to change.
+	automatedServiceIDs := automatedServices(config)
+	if len(automatedServiceIDs) == 0 && !config.Settings.Git.RepoConfig {
 		return nil, nil
 	}
 
@@ -117,13 +141,47 @@ func (a *Automator) handleAutomatedInstanceJob(logger log.Logger, job *jobs.Job,
 		updater.UpdateJob(*job)
 	}
 
+	if config.Settings.Git.RepoConfig {
+		if err := rc.SyncRepoConfig(); err != nil {
+			logInJob("error reading config from the repo: %s", err)
+			return followUps, errors.Wrap(err, "reading config from the repo")
+		}
+	}
+	if err := a.recordSync(params.InstanceID, rc); err != nil {
+		logger.Log("err", errors.Wrap(err, "recording sync"))
+	}
+
+	if config.Settings.Git.RepoConfig {
+		// The tag filters and namespaces may have changed
+		if config, err = a.cfg.InstanceDB.GetConfig(params.InstanceID); err != nil {
+			return followUps, errors.Wrap(err, "getting instance config")
+		}
+		if automatedServiceIDs = automatedServices(config); len(automatedServiceIDs) == 0 {
+			return nil, nil
+		}
+	}
+
+	// Releases would have to be pushed, so there are none while the
+	// repo is read-only; the repo is still read, above.
+	if config.Settings.Git.ReadOnly {
+		logInJob("git repo is read-only; not releasing automated services")
+		return followUps, nil
+	}
+
+	filters := []release.ServiceFilter{
+		&release.IncludeFilter{
+			IDs: automatedServiceIDs,
+		},
+	}
+	if len(config.Settings.Namespaces) > 0 {
+		filters = append(filters, &release.NamespaceFilter{Namespaces: config.Settings.Namespaces})
+	}
+
 	// Get the list of services that are automated, in the repo, and in the running service.
 	updates, err := rc.SelectServices(
 		results,
 		logInJob,
-		&release.IncludeFilter{
-			IDs: automatedServiceIDs,
-		},
+		filters...,
 	)
 	if err != nil {
 		logInJob("error finding services: %s", err)
@@ -142,6 +200,10 @@ func (a *Automator) handleAutomatedInstanceJob(logger log.Logger, job *jobs.Job,
 		logInJob("error fetching image updates: %s", err)
 		return followUps, errors.Wrap(err, "fetching image updates")
 	}
+	if err := inst.OnlySigned(images); err != nil {
+		logInJob("error checking image signatures: %s", err)
+		return followUps, errors.Wrap(err, "checking image signatures")
+	}
 
 	// At this point we have all the data we need to know precisely
 	// what needs updating. However, we want to break this down into
@@ -155,16 +217,31 @@ func (a *Automator) handleAutomatedInstanceJob(logger log.Logger, job *jobs.Job,
 	// already have a map of the available images.
 	imageServices := map[flux.ImageID][]flux.ServiceSpec{}
 	for _, update := range updates {
+		var candidates []flux.ImageID
 		for _, container := range update.Service.ContainersOrNil() {
 			currentImageID, err := flux.ParseImageID(container.Image)
 			if err != nil {
 				logInJob("error parsing image in service %s container %s (%q): %s", update.Service.ID, container.Name, container.Image, err)
 				return followUps, errors.Wrapf(err, "calculating image updates for %s", container.Name)
 			}
-			if latest := images.LatestImage(currentImageID.Repository()); latest != nil && latest.ID != currentImageID {
-				imageServices[latest.ID] = append(imageServices[latest.ID], flux.ServiceSpec(update.ServiceID))
+			latest := images.LatestImageMatching(currentImageID.Repository(), config.Services[update.ServiceID].TagFilter)
+			if latest != nil && latest.ID != currentImageID {
+				candidates = append(candidates, latest.ID)
 			}
 		}
+
+		throttled, err := throttle(inst, update.ServiceID, config.Services[update.ServiceID].MinReleaseInterval, candidates, time.Now().UTC())
+		if err != nil {
+			logInJob("error checking release interval for service %s: %s", update.ServiceID, err)
+			return followUps, errors.Wrapf(err, "checking release interval for %s", update.ServiceID)
+		}
+		if throttled {
+			logInJob("service %s was released too recently; not releasing %s", update.ServiceID, candidates)
+			continue
+		}
+		for _, image := range candidates {
+			imageServices[image] = append(imageServices[image], flux.ServiceSpec(update.ServiceID))
+		}
 	}
 
 	for imageID, services := range imageServices {
@@ -198,6 +275,83 @@ func (a *Automator) handleAutomatedInstanceJob(logger log.Logger, job *jobs.Job,
 	return followUps, nil
 }
 
+// recordSync records that the instance's config repo has been read
+// successfully, at the revision cloned.
+func (a *Automator) recordSync(instID flux.InstanceID, rc *release.ReleaseContext) error {
+	revision, err := rc.HeadRevision()
+	if err != nil {
+		return errors.Wrap(err, "getting revision")
+	}
+	if err := a.cfg.InstanceDB.UpdateConfig(instID, func(config instance.Config) (instance.Config, error) {
+		config.LastSync = &flux.LastSync{Revision: revision, At: time.Now().UTC()}
+		config.SyncStaleWarned = false
+		return config, nil
+	}); err != nil {
+		return err
+	}
+	syncAge.WithLabelValues(string(instID)).Set(0)
+	return nil
+}
+
+// checkSync reports how long it's been since the instance's config
+// repo was last read successfully, and warns about it, once, if it's
+// been longer than it should.
+func (a *Automator) checkSync(inst instance.NamedConfig, now time.Time) error {
+	last := inst.Config.LastSync
+	if last == nil {
+		return nil
+	}
+	age := now.Sub(last.At)
+	syncAge.WithLabelValues(string(inst.ID)).Set(age.Seconds())
+	if a.cfg.SyncWarnAfter <= 0 || age < a.cfg.SyncWarnAfter || inst.Config.SyncStaleWarned {
+		return nil
+	}
+
+	msg := fmt.Sprintf("No successful sync for %s; the config repo was last read at revision %s, at %s.", age/time.Second*time.Second, last.Revision, last.At.Format(time.RFC3339))
+	h, err := a.cfg.Instancer.Get(inst.ID)
+	if err != nil {
+		return errors.Wrap(err, "getting instance")
+	}
+	if err := h.LogEvent(flux.Event{
+		Type:      flux.EventSyncStale,
+		StartedAt: now,
+		EndedAt:   now,
+		LogLevel:  flux.LogLevelWarn,
+		Message:   msg,
+	}); err != nil {
+		return errors.Wrap(err, "logging event")
+	}
+	if err := notifications.Warning(inst.Config, msg); err != nil {
+		return errors.Wrap(err, "sending notification")
+	}
+	return a.cfg.InstanceDB.UpdateConfig(inst.ID, func(config instance.Config) (instance.Config, error) {
+		config.SyncStaleWarned = true
+		return config, nil
+	})
+}
+
+// SyncNotifyJob makes a job to check the automated services of the
+// instance, as soon as the window has passed. Its key is distinct from
+// that of the regular automation job, so it runs sooner; but any other
+// notifications before it has started are collapsed into it (see
+// JobStore.PutJobUnlessQueued).
+func SyncNotifyJob(instanceID flux.InstanceID, now time.Time, window time.Duration) jobs.Job {
+	return jobs.Job{
+		Queue: jobs.AutomatedInstanceJob,
+		Key: strings.Join([]string{
+			jobs.AutomatedInstanceJob,
+			"notify",
+			string(instanceID),
+		}, "|"),
+		Method:   jobs.AutomatedInstanceJob,
+		Priority: jobs.PriorityInteractive,
+		Params: jobs.AutomatedInstanceJobParams{
+			InstanceID: instanceID,
+		},
+		ScheduledAt: now.UTC().Add(window),
+	}
+}
+
 func automatedInstanceJob(instanceID flux.InstanceID, now time.Time) jobs.Job {
 	return jobs.Job{
 		Queue: jobs.AutomatedInstanceJob,
@@ -214,3 +368,52 @@ func automatedInstanceJob(instanceID flux.InstanceID, now time.Time) jobs.Job {
 		ScheduledAt: now.UTC().Add(automationCycle),
 	}
 }
+
+// throttle says whether the service was released more recently than
+// its minimum release interval allows. If so, it records an event for
+// each candidate image that is skipped, unless there's one already
+// since the last release.
+func throttle(inst *instance.Instance, service flux.ServiceID, interval time.Duration, candidates []flux.ImageID, now time.Time) (bool, error) {
+	if interval <= 0 || len(candidates) == 0 {
+		return false, nil
+	}
+	events, err := inst.EventsForService(service, now, -1)
+	if err != nil {
+		return false, err
+	}
+	versions := history.ServiceVersions(service, events)
+	if len(versions) == 0 {
+		return false, nil
+	}
+	last := versions[len(versions)-1].Since
+	if !now.Before(last.Add(interval)) {
+		return false, nil
+	}
+
+	recorded := map[flux.ImageID]bool{}
+	for _, e := range events {
+		if metadata, ok := e.Metadata.(flux.ThrottledEventMetadata); ok && !e.EndedAt.Before(last) {
+			recorded[metadata.Image] = true
+		}
+	}
+	for _, image := range candidates {
+		if recorded[image] {
+			continue
+		}
+		if err := inst.LogEvent(flux.Event{
+			ServiceIDs: []flux.ServiceID{service},
+			Type:       flux.EventThrottled,
+			StartedAt:  now,
+			EndedAt:    now,
+			LogLevel:   flux.LogLevelInfo,
+			Metadata: flux.ThrottledEventMetadata{
+				Image:       image,
+				LastRelease: last,
+				Interval:    interval,
+			},
+		}); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,214 @@
+package automator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+)
+
+type configDB map[flux.InstanceID]instance.Config
+
+func (db configDB) UpdateConfig(inst flux.InstanceID, update instance.UpdateFunc) error {
+	c, err := update(db[inst])
+	if err == nil {
+		db[inst] = c
+	}
+	return err
+}
+
+func (db configDB) GetConfig(inst flux.InstanceID) (instance.Config, error) {
+	return db[inst], nil
+}
+
+func (db configDB) All() ([]instance.NamedConfig, error) {
+	var all []instance.NamedConfig
+	for id, c := range db {
+		all = append(all, instance.NamedConfig{ID: id, Config: c})
+	}
+	return all, nil
+}
+
+type eventLog []flux.Event
+
+func (l *eventLog) LogEvent(e flux.Event) error {
+	*l = append(*l, e)
+	return nil
+}
+
+func TestCheckSync(t *testing.T) {
+	now := time.Now().UTC()
+	db := configDB{"inst": instance.Config{
+		LastSync: &flux.LastSync{Revision: "abc123", At: now.Add(-2 * time.Hour)},
+	}}
+	events := &eventLog{}
+	a := &Automator{cfg: Config{
+		InstanceDB:    db,
+		Instancer:     &instance.MockInstancer{Instance: &instance.Instance{EventWriter: events}},
+		Logger:        log.NewNopLogger(),
+		SyncWarnAfter: time.Hour,
+	}}
+
+	// Warned once, however many times it's checked
+	for i := 0; i < 2; i++ {
+		all, _ := db.All()
+		if err := a.checkSync(all[0], now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*events) != 1 {
+		t.Fatalf("expected one warning, got %d", len(*events))
+	}
+	if e := (*events)[0]; e.Type != flux.EventSyncStale || e.LogLevel != flux.LogLevelWarn {
+		t.Errorf("expected a %s warning, got %s at level %s", flux.EventSyncStale, e.Type, e.LogLevel)
+	}
+	if !db["inst"].SyncStaleWarned {
+		t.Error("expected the warning to be recorded")
+	}
+
+	// Not warned while in sync
+	db["inst"] = instance.Config{LastSync: &flux.LastSync{Revision: "def456", At: now.Add(-time.Minute)}}
+	all, _ := db.All()
+	if err := a.checkSync(all[0], now); err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 {
+		t.Errorf("expected no warning when recently synced, got %d", len(*events)-1)
+	}
+}
+
+// jobQueue records the instances jobs are put for.
+type jobQueue struct {
+	jobs.JobReadPusher
+	instances []flux.InstanceID
+}
+
+func (q *jobQueue) PutJob(inst flux.InstanceID, _ jobs.Job) (jobs.JobID, error) {
+	q.instances = append(q.instances, inst)
+	return jobs.JobID(inst), nil
+}
+
+func TestCheckAll(t *testing.T) {
+	automated := map[flux.ServiceID]instance.ServiceConfig{
+		"default/helloworld": {Automated: true},
+	}
+	db := configDB{
+		"automated": instance.Config{Services: automated},
+		"repo-config": instance.Config{Settings: flux.UnsafeInstanceConfig{
+			Git: flux.GitConfig{RepoConfig: true},
+		}},
+		"neither": instance.Config{},
+		"paused": instance.Config{
+			Services: automated,
+			Paused:   &flux.Pause{},
+		},
+	}
+	q := &jobQueue{}
+	a := &Automator{cfg: Config{
+		Jobs:       q,
+		InstanceDB: db,
+		Logger:     log.NewNopLogger(),
+	}}
+	a.checkAll(log.NewNopLogger())
+
+	queued := map[flux.InstanceID]bool{}
+	for _, inst := range q.instances {
+		queued[inst] = true
+	}
+	for inst, expected := range map[flux.InstanceID]bool{
+		"automated":   true,
+		"repo-config": true,
+		"neither":     false,
+		"paused":      false,
+	} {
+		if queued[inst] != expected {
+			t.Errorf("%s: expected queued=%v, got %v", inst, expected, queued[inst])
+		}
+	}
+}
+
+// serviceHistory gives the events logged, as the history of any
+// service.
+type serviceHistory struct {
+	history.EventReader
+	*eventLog
+}
+
+func (h serviceHistory) EventsForService(_ flux.ServiceID, _ time.Time, _ int64) ([]flux.Event, error) {
+	var events []flux.Event
+	for i := len(*h.eventLog) - 1; i >= 0; i-- {
+		events = append(events, (*h.eventLog)[i])
+	}
+	return events, nil
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Now().UTC()
+	service := flux.ServiceID("default/helloworld")
+	current := flux.ImageID{Host: "quay.io", Namespace: "weaveworks", Image: "helloworld", Tag: "v1"}
+	latest := flux.ImageID{Host: "quay.io", Namespace: "weaveworks", Image: "helloworld", Tag: "v2"}
+
+	released := func(ago time.Duration) flux.Event {
+		return flux.Event{
+			ServiceIDs: []flux.ServiceID{service},
+			Type:       flux.EventRelease,
+			EndedAt:    now.Add(-ago),
+			Metadata: flux.ReleaseEventMetadata{
+				Release: flux.Release{
+					EndedAt: now.Add(-ago),
+					Result: flux.ReleaseResult{service: flux.ServiceResult{
+						Status:       flux.ReleaseStatusSuccess,
+						PerContainer: []flux.ContainerUpdate{{Container: "greeter", Target: current}},
+					}},
+				},
+			},
+		}
+	}
+	throttled := func(ago time.Duration) flux.Event {
+		return flux.Event{
+			ServiceIDs: []flux.ServiceID{service},
+			Type:       flux.EventThrottled,
+			EndedAt:    now.Add(-ago),
+			Metadata:   flux.ThrottledEventMetadata{Image: latest},
+		}
+	}
+
+	for _, c := range []struct {
+		name       string
+		interval   time.Duration
+		history    []flux.Event
+		candidates []flux.ImageID
+		throttled  bool
+		newEvents  int
+	}{
+		{"no minimum interval", 0, []flux.Event{released(time.Minute)}, []flux.ImageID{latest}, false, 0},
+		{"nothing to release", time.Hour, []flux.Event{released(time.Minute)}, nil, false, 0},
+		{"never released", time.Hour, nil, []flux.ImageID{latest}, false, 0},
+		{"released before the interval", time.Hour, []flux.Event{released(2 * time.Hour)}, []flux.ImageID{latest}, false, 0},
+		{"released just the interval ago", time.Hour, []flux.Event{released(time.Hour)}, []flux.ImageID{latest}, false, 0},
+		{"released within the interval", time.Hour, []flux.Event{released(30 * time.Minute)}, []flux.ImageID{latest}, true, 1},
+		{"already recorded as throttled", time.Hour, []flux.Event{released(30 * time.Minute), throttled(10 * time.Minute)}, []flux.ImageID{latest}, true, 0},
+		{"recorded as throttled before the last release", time.Hour, []flux.Event{throttled(2 * time.Hour), released(30 * time.Minute)}, []flux.ImageID{latest}, true, 1},
+	} {
+		events := eventLog(append([]flux.Event(nil), c.history...))
+		inst := &instance.Instance{
+			EventReader: serviceHistory{eventLog: &events},
+			EventWriter: &events,
+		}
+		got, err := throttle(inst, service, c.interval, c.candidates, now)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got != c.throttled {
+			t.Errorf("%s: expected throttled=%v, got %v", c.name, c.throttled, got)
+		}
+		if n := len(events) - len(c.history); n != c.newEvents {
+			t.Errorf("%s: expected %d events recorded, got %d", c.name, c.newEvents, n)
+		}
+	}
+}
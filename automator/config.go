@@ -3,6 +3,7 @@ package automator
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 
@@ -16,6 +17,10 @@ type Config struct {
 	InstanceDB instance.DB
 	Instancer  instance.Instancer
 	Logger     log.Logger
+	// SyncWarnAfter, if not zero, is how long an instance can go
+	// without a successful sync before it's warned about. It's
+	// optional.
+	SyncWarnAfter time.Duration
 }
 
 // Validate returns an error if the config is underspecified.
@@ -0,0 +1,22 @@
+package automator
+
+import (
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+func init() {
+	stdprometheus.MustRegister(syncAge)
+}
+
+var (
+	// syncAge is a plain GaugeVec, rather than a go-kit gauge, so that
+	// the series for an instance can be removed when it's no longer
+	// synced.
+	syncAge = stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "fluxsvc",
+		Name:      "seconds_since_last_sync",
+		Help:      "Seconds since automation last read each instance's config repo successfully.",
+	}, []string{fluxmetrics.LabelInstanceID})
+)
@@ -0,0 +1,128 @@
+// +build chaos
+
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enabled says whether faults can be injected in this build.
+const Enabled = true
+
+// Fault is what to do at an injection point.
+type Fault struct {
+	// Delay is how long to wait before going ahead (or failing).
+	Delay duration `json:"delay,omitempty"`
+	// FailRate is the probability, from 0 to 1, of failing.
+	FailRate float64 `json:"failRate,omitempty"`
+}
+
+// duration is a time.Duration given in JSON as a string, e.g., "2s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(t)
+	return nil
+}
+
+var (
+	mu     sync.Mutex
+	faults = map[string]Fault{}
+)
+
+// Inject waits for the delay set for the point given, if any, then
+// returns ErrInjected if a failure is to be injected there.
+func Inject(point string) error {
+	mu.Lock()
+	fault, ok := faults[point]
+	fail := ok && rand.Float64() < fault.FailRate
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+	time.Sleep(time.Duration(fault.Delay))
+	if fail {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Set sets the fault to inject at a point.
+func Set(point string, fault Fault) error {
+	if !known(point) {
+		return fmt.Errorf("unknown injection point %q", point)
+	}
+	if fault.FailRate < 0 || fault.FailRate > 1 {
+		return fmt.Errorf("failRate must be between 0 and 1, got %v", fault.FailRate)
+	}
+	mu.Lock()
+	faults[point] = fault
+	mu.Unlock()
+	return nil
+}
+
+// Clear clears the fault at a point.
+func Clear(point string) {
+	mu.Lock()
+	delete(faults, point)
+	mu.Unlock()
+}
+
+func known(point string) bool {
+	for _, p := range Points {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+// Register adds the API for setting faults to the mux given.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc(HandlerPath, handle)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	point := strings.TrimPrefix(r.URL.Path, HandlerPath)
+	switch {
+	case point == "" && r.Method == "GET":
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(faults)
+	case point != "" && r.Method == "PUT":
+		var fault Fault
+		if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := Set(point, fault); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case point != "" && r.Method == "DELETE":
+		Clear(point)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
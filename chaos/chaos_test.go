@@ -0,0 +1,75 @@
+// +build chaos
+
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInject(t *testing.T) {
+	defer Clear(GitPush)
+
+	if err := Inject(GitPush); err != nil {
+		t.Fatalf("expected no fault before one is set, got %v", err)
+	}
+	if err := Set(GitPush, Fault{FailRate: 1, Delay: duration(10 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := Inject(GitPush); err != ErrInjected {
+		t.Errorf("expected %v, got %v", ErrInjected, err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Error("expected the delay before the fault")
+	}
+	if err := Inject(GitClone); err != nil {
+		t.Errorf("expected no fault at another point, got %v", err)
+	}
+
+	if err := Set("git.pull", Fault{}); err == nil {
+		t.Error("expected an error setting a fault at an unknown point")
+	}
+	if err := Set(GitPush, Fault{FailRate: 2}); err == nil {
+		t.Error("expected an error setting a fail rate over 1")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+HandlerPath+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := do("PUT", WebsocketWrite, `{"delay": "1ms", "failRate": 1}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d setting a fault, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if err := Inject(WebsocketWrite); err != ErrInjected {
+		t.Errorf("expected the fault set through the API, got %v", err)
+	}
+	if resp := do("PUT", WebsocketWrite, `{"delay": "soon"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for a bad delay, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp := do("DELETE", WebsocketWrite, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d clearing a fault, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if err := Inject(WebsocketWrite); err != nil {
+		t.Errorf("expected no fault once cleared, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Package chaos injects faults into flux -- delays, dropped websocket
+// frames, and rejected git pushes -- so that automation built on flux
+// can be tested against the ways flux fails in real life.
+//
+// Faults can only be injected in binaries built with the `chaos` build
+// tag (e.g., `go build -tags chaos ./cmd/fluxsvc`); otherwise, Inject
+// never fails, and there's no API for setting faults. In a chaos
+// build, faults are set through an HTTP API served by fluxsvc and
+// fluxd, at /internal/chaos/:
+//
+//	GET    /internal/chaos/         lists the faults set
+//	PUT    /internal/chaos/<point>  sets the fault at a point, e.g.
+//	                                {"delay": "2s", "failRate": 0.5}
+//	DELETE /internal/chaos/<point>  clears the fault at a point
+package chaos
+
+import (
+	"errors"
+)
+
+// The points at which faults can be injected.
+const (
+	// WebsocketWrite is each frame written to the websocket between
+	// fluxd and fluxsvc; a failure drops the frame.
+	WebsocketWrite = "websocket.write"
+	// GitPush is each push to a config repo; a failure is a rejected
+	// push.
+	GitPush = "git.push"
+	// GitClone is each clone of a config repo.
+	GitClone = "git.clone"
+)
+
+// Points lists the points at which faults can be injected.
+var Points = []string{WebsocketWrite, GitPush, GitClone}
+
+// ErrInjected is the error returned by Inject when a fault is
+// injected.
+var ErrInjected = errors.New("fault injected")
+
+// HandlerPath is where the API for setting faults is served.
+const HandlerPath = "/internal/chaos/"
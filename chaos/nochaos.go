@@ -0,0 +1,18 @@
+// +build !chaos
+
+package chaos
+
+import (
+	"net/http"
+)
+
+// Enabled says whether faults can be injected in this build.
+const Enabled = false
+
+// Inject never injects a fault, without the chaos build tag.
+func Inject(point string) error {
+	return nil
+}
+
+// Register does nothing, without the chaos build tag.
+func Register(mux *http.ServeMux) {}
@@ -0,0 +1,142 @@
+// Package checkpoint periodically asks a remote endpoint whether
+// there is a newer release of flux, or any security advisories that
+// apply to the running version.
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+// Announcer is told about check results that differ from the previous
+// one, e.g., because a new version has been released.
+type Announcer func(flux.CheckpointStatus)
+
+// Checker asks the endpoint about the version it was given.
+type Checker struct {
+	endpoint string
+	product  string
+	version  string
+	client   *http.Client
+	logger   log.Logger
+
+	mu     sync.RWMutex
+	latest *flux.CheckpointStatus
+}
+
+// response is what we expect back from the endpoint.
+type response struct {
+	CurrentVersion string                 `json:"current_version"`
+	Outdated       bool                   `json:"outdated"`
+	Alerts         []flux.CheckpointAlert `json:"alerts"`
+}
+
+func New(endpoint, product, version string, client *http.Client, logger log.Logger) *Checker {
+	return &Checker{
+		endpoint: endpoint,
+		product:  product,
+		version:  version,
+		client:   client,
+		logger:   logger,
+	}
+}
+
+// Start checks once immediately, and again each time tick fires. Any
+// result that is different from the last is passed to announce, if it
+// is not nil.
+func (c *Checker) Start(tick <-chan time.Time, announce Announcer) {
+	c.checkAndAnnounce(announce)
+	for range tick {
+		c.checkAndAnnounce(announce)
+	}
+}
+
+func (c *Checker) checkAndAnnounce(announce Announcer) {
+	status, err := c.Check()
+	if err != nil {
+		c.logger.Log("err", err)
+		return
+	}
+
+	c.mu.Lock()
+	changed := c.latest == nil ||
+		c.latest.LatestVersion != status.LatestVersion ||
+		!reflect.DeepEqual(c.latest.Alerts, status.Alerts)
+	c.latest = &status
+	c.mu.Unlock()
+
+	if changed && announce != nil && (status.Outdated || len(status.Alerts) > 0) {
+		announce(status)
+	}
+}
+
+// Check asks the endpoint about our version, and returns the result
+// without recording it.
+func (c *Checker) Check() (flux.CheckpointStatus, error) {
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		return flux.CheckpointStatus{}, errors.Wrapf(err, "parsing checkpoint endpoint %s", c.endpoint)
+	}
+	q := u.Query()
+	q.Set("product", c.product)
+	q.Set("version", c.version)
+	q.Set("os", runtime.GOOS)
+	q.Set("arch", runtime.GOARCH)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return flux.CheckpointStatus{}, errors.Wrap(err, "constructing checkpoint request")
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return flux.CheckpointStatus{}, errors.Wrap(err, "executing checkpoint request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return flux.CheckpointStatus{}, fmt.Errorf("%s from checkpoint endpoint (%s)", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return flux.CheckpointStatus{}, errors.Wrap(err, "decoding checkpoint response")
+	}
+	return flux.CheckpointStatus{
+		LatestVersion: r.CurrentVersion,
+		Outdated:      r.Outdated,
+		Alerts:        r.Alerts,
+		CheckedAt:     time.Now().UTC(),
+	}, nil
+}
+
+// Latest returns the most recent successful check, or nil if there
+// hasn't been one. It is safe to call on a nil Checker, which is what
+// you get when checking is disabled.
+func (c *Checker) Latest() *flux.CheckpointStatus {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.latest == nil {
+		return nil
+	}
+	latest := *c.latest
+	return &latest
+}
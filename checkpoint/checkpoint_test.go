@@ -0,0 +1,61 @@
+package checkpoint
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestCheckAndAnnounce(t *testing.T) {
+	latest := "1.0.0"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("version"); v != "0.1.0" {
+			t.Errorf("expected version 0.1.0 in query, got %q", v)
+		}
+		fmt.Fprintf(w, `{"current_version": %q, "outdated": true, "alerts": [{"level": "warn", "message": "upgrade soon"}]}`, latest)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "fluxsvc", "0.1.0", http.DefaultClient, log.NewNopLogger())
+	if c.Latest() != nil {
+		t.Fatal("expected no result before checking")
+	}
+
+	var announced []flux.CheckpointStatus
+	announce := func(s flux.CheckpointStatus) {
+		announced = append(announced, s)
+	}
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	close(tick)
+	c.Start(tick, announce)
+
+	// Checked twice, but the result was the same, so only announced once
+	if len(announced) != 1 {
+		t.Fatalf("expected one announcement, got %d", len(announced))
+	}
+	status := c.Latest()
+	if status == nil || status.LatestVersion != latest || !status.Outdated || len(status.Alerts) != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	latest = "1.0.1"
+	c.checkAndAnnounce(announce)
+	if len(announced) != 2 {
+		t.Errorf("expected a new version to be announced")
+	}
+}
+
+func TestNilChecker(t *testing.T) {
+	var c *Checker
+	if c.Latest() != nil {
+		t.Error("expected nil status from nil checker")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+// apiVersionWarning compares the API version fluxctl will use with
+// those the service supports (nil if the service didn't say), and
+// gives a warning to show the user, or blank if all is well.
+func apiVersionWarning(supported []string, wanted string) string {
+	latest := transport.APIVersions[len(transport.APIVersions)-1]
+	pinned := wanted != latest
+
+	if supported == nil {
+		if pinned {
+			return ""
+		}
+		return fmt.Sprintf(`Warning: the service does not report which API versions it supports,
+so is probably older than this fluxctl. If commands fail, try using an
+older API with --api-version (e.g., --api-version=%s).
+`, transport.APIVersions[len(transport.APIVersions)-2])
+	}
+
+	wantedN, err := transport.ParseAPIVersion(wanted)
+	if err != nil {
+		return ""
+	}
+	var (
+		found                  bool
+		min, max               int
+		minVersion, maxVersion string
+	)
+	for _, v := range supported {
+		n, err := transport.ParseAPIVersion(v)
+		if err != nil {
+			continue
+		}
+		found = found || n == wantedN
+		if min == 0 || n < min {
+			min, minVersion = n, v
+		}
+		if n > max {
+			max, maxVersion = n, v
+		}
+	}
+
+	switch {
+	case max == 0:
+		return ""
+	case found && !pinned && wantedN < max:
+		return fmt.Sprintf(`Note: the service supports API %s, which is newer than this fluxctl.
+You may want to upgrade fluxctl; see https://github.com/weaveworks/flux/releases
+`, maxVersion)
+	case found:
+		return ""
+	case wantedN > max:
+		return fmt.Sprintf(`Warning: this fluxctl uses API %s, but the service supports only up to %s.
+Some commands may fail; use --api-version=%s, or upgrade the service.
+`, wanted, maxVersion, maxVersion)
+	case wantedN < min:
+		return fmt.Sprintf(`Warning: this fluxctl uses API %s, but the service supports only %s and later.
+Please upgrade fluxctl; see https://github.com/weaveworks/flux/releases
+`, wanted, minVersion)
+	default:
+		return fmt.Sprintf("Warning: the service does not support API %s.\n", wanted)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestAPIVersionWarning(t *testing.T) {
+	n := len(transport.APIVersions)
+	latest, previous := transport.APIVersions[n-1], transport.APIVersions[n-2]
+	latestN, err := transport.ParseAPIVersion(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := fmt.Sprintf("v%d", latestN+1)
+	newest := fmt.Sprintf("v%d", latestN+2)
+
+	for _, c := range []struct {
+		name      string
+		supported []string
+		wanted    string
+		warning   string // a fragment of the expected warning, or blank
+	}{
+		{"same versions", transport.APIVersions, latest, ""},
+		{"pinned to older version", transport.APIVersions, previous, ""},
+		{"old service", nil, latest, "--api-version=" + previous},
+		{"old service, pinned", nil, previous, ""},
+		{"client too new", transport.APIVersions[:n-1], latest, "only up to " + previous},
+		{"client too old", []string{newer, newest}, latest, "upgrade fluxctl"},
+		{"service newer", append(append([]string{}, transport.APIVersions...), newer), latest, "newer than this fluxctl"},
+	} {
+		warning := apiVersionWarning(c.supported, c.wanted)
+		if c.warning == "" && warning != "" {
+			t.Errorf("%s: expected no warning, got %q", c.name, warning)
+		}
+		if !strings.Contains(warning, c.warning) {
+			t.Errorf("%s: expected warning containing %q, got %q", c.name, c.warning, warning)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api"
 )
 
 func parseServiceOption(s string) (flux.ServiceSpec, error) {
@@ -10,3 +15,75 @@ func parseServiceOption(s string) (flux.ServiceSpec, error) {
 	}
 	return flux.ParseServiceSpec(s)
 }
+
+// resolveServiceOption gives the services a --service option is for:
+// the service named, or, given a selector (e.g.,
+// `selector:app=frontend`), each of the services with those labels.
+func resolveServiceOption(client api.ClientService, s string) ([]flux.ServiceID, error) {
+	spec, err := flux.ParseServiceSpec(s)
+	if err != nil {
+		return nil, err
+	}
+	selector, ok := spec.AsSelector()
+	if !ok {
+		id, err := flux.ParseServiceID(s)
+		if err != nil {
+			return nil, err
+		}
+		return []flux.ServiceID{id}, nil
+	}
+
+	services, err := client.ListServices(context.Background(), noInstanceID, "")
+	if err != nil {
+		return nil, err
+	}
+	var ids []flux.ServiceID
+	for _, service := range services {
+		if selector.Matches(service.Labels) {
+			ids = append(ids, service.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no services match %s", spec)
+	}
+	return ids, nil
+}
+
+// joinSelectorTerms puts back together selectors that were split at
+// their commas by a flag that takes a comma-separated list; e.g.,
+// `--service=selector:app=frontend,tier=web`. A `key=value` following
+// a selector is part of it, since service IDs can't contain `=`.
+func joinSelectorTerms(services []string) []string {
+	var res []string
+	for _, s := range services {
+		n := len(res)
+		if n > 0 && strings.HasPrefix(res[n-1], flux.ServiceSpecSelectorPrefix) &&
+			strings.Contains(s, "=") && !strings.HasPrefix(s, flux.ServiceSpecSelectorPrefix) {
+			res[n-1] += "," + s
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// stringsFlag is the value of a flag that may be given more than once,
+// keeping each value whole. Unlike a StringSlice flag, it doesn't split
+// values at commas, which may be part of them (e.g., in
+// `--set-env=JAVA_OPTS=-Xmx1g,-Xms1g`).
+type stringsFlag struct {
+	values *[]string
+}
+
+func (f stringsFlag) String() string {
+	return strings.Join(*f.values, " ")
+}
+
+func (f stringsFlag) Set(value string) error {
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+func (f stringsFlag) Type() string {
+	return "string"
+}
@@ -1,6 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
 	"github.com/spf13/cobra"
 
 	"github.com/weaveworks/flux"
@@ -8,11 +16,14 @@ import (
 
 type serviceAutomateOpts struct {
 	*serviceOpts
-	service string
+	service   string
+	tagFilter string
+	yes       bool
+	in        io.Reader
 }
 
 func newServiceAutomate(parent *serviceOpts) *serviceAutomateOpts {
-	return &serviceAutomateOpts{serviceOpts: parent}
+	return &serviceAutomateOpts{serviceOpts: parent, in: os.Stdin}
 }
 
 func (opts *serviceAutomateOpts) Command() *cobra.Command {
@@ -20,26 +31,88 @@ func (opts *serviceAutomateOpts) Command() *cobra.Command {
 		Use:   "automate",
 		Short: "Turn on automatic deployment for a service.",
 		Example: makeExample(
-			"fluxctl automate --service=helloworld",
+			"fluxctl automate --service=helloworld --tag-filter='master-*'",
+			"fluxctl automate --service=helloworld --yes",
+			"fluxctl automate --service=selector:app=frontend --tag-filter='master-*'",
 		),
 		RunE: opts.RunE,
 	}
-	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to automate")
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to automate, or selector:<label>=<value>,... for the services with those labels")
+	cmd.Flags().StringVar(&opts.tagFilter, "tag-filter", "", "Only release images with tags matching this glob pattern (e.g., 'v1.*')")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation when the service has no tag filter")
 	return cmd
 }
 
-func (opts *serviceAutomateOpts) RunE(_ *cobra.Command, args []string) error {
+func (opts *serviceAutomateOpts) RunE(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		return errorWantedNoArgs
 	}
 	if opts.service == "" {
 		return newUsageError("-s, --service is required")
 	}
+	if _, err := path.Match(opts.tagFilter, ""); err != nil {
+		return newUsageError(fmt.Sprintf("invalid --tag-filter %q: %s", opts.tagFilter, err))
+	}
 
-	serviceID, err := flux.ParseServiceID(opts.service)
+	serviceIDs, err := resolveServiceOption(opts.API, opts.service)
 	if err != nil {
 		return err
 	}
 
-	return opts.API.Automate(noInstanceID, serviceID)
+	for _, serviceID := range serviceIDs {
+		if err := opts.automate(cmd, serviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts *serviceAutomateOpts) automate(cmd *cobra.Command, serviceID flux.ServiceID) error {
+	if opts.tagFilter != "" {
+		if err := opts.API.SetTagFilter(context.Background(), noInstanceID, serviceID, opts.tagFilter); err != nil {
+			return err
+		}
+	} else if !opts.yes {
+		ok, err := opts.confirmUnfiltered(cmd, serviceID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not automating %s", serviceID)
+		}
+	}
+
+	return opts.API.Automate(context.Background(), noInstanceID, serviceID)
+}
+
+// confirmUnfiltered asks whether to go ahead and automate a service
+// that has no tag filter, since automation will then release any new
+// tag at all.
+func (opts *serviceAutomateOpts) confirmUnfiltered(cmd *cobra.Command, serviceID flux.ServiceID) (bool, error) {
+	namespace, _ := serviceID.Components()
+	services, err := opts.API.ListServices(context.Background(), noInstanceID, namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, service := range services {
+		if service.ID == serviceID && service.TagFilter != "" {
+			return true, nil
+		}
+	}
+
+	out := cmd.OutOrStderr()
+	fmt.Fprintf(out, "Warning: %s has no tag filter, so automation will release every new\n", serviceID)
+	fmt.Fprintf(out, "image pushed for it, whatever its tag. Use --tag-filter to limit\n")
+	fmt.Fprintf(out, "the tags released, or --yes to skip this question.\n")
+	fmt.Fprintf(out, "Automate %s anyway? [y/N] ", serviceID)
+
+	answer, err := bufio.NewReader(opts.in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+func mockAutomateService(tagFilter string) *genericMockRoundTripper {
+	return &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("ListServices"): []flux.ServiceStatus{{
+				ID:        flux.ServiceID("default/helloworld"),
+				TagFilter: tagFilter,
+			}},
+			transport.NewRouter().Get("SetTagFilter"): nil,
+			transport.NewRouter().Get("Automate"):     nil,
+		},
+	}
+}
+
+func TestAutomateCommand(t *testing.T) {
+	for _, c := range []struct {
+		args      []string
+		input     string
+		tagFilter string // of the service already
+		fails     bool
+		calls     []string
+	}{
+		{[]string{"--tag-filter=master-*"}, "", "", false, []string{"SetTagFilter", "Automate"}},
+		{[]string{}, "y\n", "", false, []string{"ListServices", "Automate"}},
+		{[]string{}, "\n", "", true, []string{"ListServices"}},
+		{[]string{}, "", "", true, []string{"ListServices"}},
+		{[]string{}, "", "v1.*", false, []string{"ListServices", "Automate"}},
+		{[]string{"--yes"}, "", "", false, []string{"Automate"}},
+		{[]string{"--tag-filter=["}, "", "", true, nil},
+	} {
+		svc := mockAutomateService(c.tagFilter)
+		opts := newServiceAutomate(mockServiceOpts(svc))
+		opts.in = strings.NewReader(c.input)
+		cmd := opts.Command()
+		cmd.SetOutput(ioutil.Discard)
+		cmd.SetArgs(append([]string{"--service=default/helloworld"}, c.args...))
+
+		if err := cmd.Execute(); (err != nil) != c.fails {
+			t.Errorf("%v %q: expected failure=%v, got %v", c.args, c.input, c.fails, err)
+		}
+		var calls []string
+		for _, r := range svc.requestHistory {
+			calls = append(calls, r.Route.GetName())
+		}
+		if strings.Join(calls, ",") != strings.Join(c.calls, ",") {
+			t.Errorf("%v %q: expected calls %v, got %v", c.args, c.input, c.calls, calls)
+		}
+	}
+
+	svc := mockAutomateService("")
+	opts := newServiceAutomate(mockServiceOpts(svc))
+	cmd := opts.Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--service=default/helloworld", "--tag-filter=master-*"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "master-*", calledRequest("SetTagFilter", svc.requestHistory).Vars["pattern"])
+}
+
+func TestAutomateCommand_Selector(t *testing.T) {
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("ListServices"): []flux.ServiceStatus{
+				{ID: "default/frontend", Labels: map[string]string{"app": "frontend"}, TagFilter: "v*"},
+				{ID: "default/backend", Labels: map[string]string{"app": "backend"}},
+				{ID: "prod/frontend", Labels: map[string]string{"app": "frontend"}, TagFilter: "v*"},
+			},
+			transport.NewRouter().Get("Automate"): nil,
+		},
+	}
+	cmd := newServiceAutomate(mockServiceOpts(svc)).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--service=selector:app=frontend"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var automated []string
+	for _, r := range svc.requestHistory {
+		if r.Route.GetName() == "Automate" {
+			automated = append(automated, r.Vars["service"])
+		}
+	}
+	if strings.Join(automated, ",") != "default/frontend,prod/frontend" {
+		t.Errorf("expected the services matching the selector to be automated, got %v", automated)
+	}
+
+	cmd = newServiceAutomate(mockServiceOpts(svc)).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--service=selector:app=db", "--yes"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected error when no services match the selector")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+)
+
+type serviceBlameOpts struct {
+	*serviceOpts
+	service string
+}
+
+func newServiceBlame(parent *serviceOpts) *serviceBlameOpts {
+	return &serviceBlameOpts{serviceOpts: parent}
+}
+
+func (opts *serviceBlameOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "blame",
+		Short: "Show who last changed each policy and container image of a service",
+		Example: makeExample(
+			"fluxctl blame --service=default/foo",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to show changes for")
+	return cmd
+}
+
+func (opts *serviceBlameOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.service == "" {
+		return newUsageError("-s, --service is required")
+	}
+
+	service, err := flux.ParseServiceID(opts.service)
+	if err != nil {
+		return err
+	}
+
+	changes, err := opts.API.ServiceChanges(context.Background(), noInstanceID, service)
+	if err != nil {
+		return err
+	}
+
+	out := newTabwriter(cmd.OutOrStdout())
+
+	fmt.Fprintln(out, "WHAT\tVALUE\tCHANGED\tUSER\tRELEASE\tCOMMIT")
+	for _, c := range changes {
+		revision := c.Revision
+		if len(revision) > 7 {
+			revision = revision[:7]
+		}
+		fmt.Fprintf(out, "%s %s\t%s\t%s\t%s\t%s\t%s\n", c.Kind, c.Name, c.Value, c.Time.Format(time.RFC822), c.User, c.ReleaseID, revision)
+	}
+
+	out.Flush()
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,6 +23,10 @@ import (
 const largestHeartbeatDelta = 5 * time.Second
 const retryTimeout = 2 * time.Minute
 
+// releaseWait is how long to ask fluxsvc to wait for a release to move
+// on, each time we look at it.
+const releaseWait = 10 * time.Second
+
 type serviceReleaseOutputOpts struct {
 	noFollow bool
 	noTty    bool
@@ -64,7 +69,7 @@ func (opts *serviceCheckReleaseOpts) RunE(cmd *cobra.Command, args []string) err
 	}
 
 	if opts.noFollow {
-		job, err := opts.API.GetRelease(noInstanceID, jobs.JobID(opts.releaseID))
+		job, err := opts.API.GetRelease(context.Background(), noInstanceID, jobs.JobID(opts.releaseID), 0)
 		if err != nil {
 			return err
 		}
@@ -105,7 +110,10 @@ func (opts *serviceCheckReleaseOpts) RunE(cmd *cobra.Command, args []string) err
 			fmt.Fprintf(w, "Service unavailable. Retrying (#%d) ...\n", retryCount)
 		}
 
-		job, err = opts.API.GetRelease(noInstanceID, jobs.JobID(opts.releaseID))
+		// fluxsvc holds the request until the release has moved on;
+		// older versions answer straight away, so we still ask no
+		// more than once a second.
+		job, err = opts.API.GetRelease(context.Background(), noInstanceID, jobs.JobID(opts.releaseID), releaseWait)
 		if err != nil {
 			if err, ok := errors.Cause(err).(*httperror.APIError); ok && err.IsUnavailable() {
 				if time.Since(lastSucceeded) > retryTimeout {
@@ -167,6 +175,9 @@ func (opts *serviceCheckReleaseOpts) RunE(cmd *cobra.Command, args []string) err
 		for i, msg := range job.Log {
 			fmt.Fprintf(cmd.OutOrStdout(), " %d) %s\n", i+1, msg)
 		}
+		if job.Conflict != nil {
+			printConflict(cmd.OutOrStdout(), opts.releaseID, job.Conflict)
+		}
 	} else if spec.Kind == flux.ReleaseKindPlan {
 		fmt.Fprintf(cmd.OutOrStdout(), "Here's the plan:\n")
 		release.PrintResults(cmd.OutOrStdout(), job.Result.(flux.ReleaseResult), opts.verbose)
@@ -175,7 +186,7 @@ func (opts *serviceCheckReleaseOpts) RunE(cmd *cobra.Command, args []string) err
 		release.PrintResults(cmd.OutOrStdout(), job.Result.(flux.ReleaseResult), opts.verbose)
 	}
 
-	if spec.Kind == flux.ReleaseKindExecute {
+	if spec.Kind != flux.ReleaseKindPlan {
 		fmt.Fprintf(cmd.OutOrStdout(), "Took %s\n", job.Finished.Sub(job.Submitted))
 	}
 
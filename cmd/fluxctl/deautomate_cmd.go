@@ -1,9 +1,9 @@
 package main
 
 import (
-	"github.com/spf13/cobra"
+	"context"
 
-	"github.com/weaveworks/flux"
+	"github.com/spf13/cobra"
 )
 
 type serviceDeautomateOpts struct {
@@ -21,10 +21,11 @@ func (opts *serviceDeautomateOpts) Command() *cobra.Command {
 		Short: "Turn off automatic deployment for a service.",
 		Example: makeExample(
 			"fluxctl deautomate --service=helloworld",
+			"fluxctl deautomate --service=selector:app=frontend",
 		),
 		RunE: opts.RunE,
 	}
-	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to deautomate")
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to deautomate, or selector:<label>=<value>,... for the services with those labels")
 	return cmd
 }
 
@@ -36,10 +37,15 @@ func (opts *serviceDeautomateOpts) RunE(_ *cobra.Command, args []string) error {
 		return newUsageError("-s, --service is required")
 	}
 
-	serviceID, err := flux.ParseServiceID(opts.service)
+	serviceIDs, err := resolveServiceOption(opts.API, opts.service)
 	if err != nil {
 		return err
 	}
 
-	return opts.API.Deautomate(noInstanceID, serviceID)
+	for _, serviceID := range serviceIDs {
+		if err := opts.API.Deautomate(context.Background(), noInstanceID, serviceID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+)
+
+// promptForSecret is written in place of a secret to be asked for it
+// instead.
+const promptForSecret = "<prompt>"
+
+const editConfigHeader = `# Edit the configuration of the instance, then save and quit to
+# update it. Quit without saving, or empty the file, to leave it as it
+# is.
+#
+# Secrets (tokens and passwords) are hidden, and only the public part
+# of the deploy key is shown. Leave them as they are to keep them. To
+# change a token or password, write ` + promptForSecret + ` in its place, to be
+# asked for the new value, which isn't echoed.
+`
+
+type editConfigOpts struct {
+	*rootOpts
+	in io.Reader
+	// edit opens the file in the user's editor, and returns when
+	// they're done with it
+	edit func(path string) error
+
+	lines *bufio.Reader
+}
+
+func newEditConfig(parent *rootOpts) *editConfigOpts {
+	return &editConfigOpts{rootOpts: parent, in: os.Stdin, edit: runEditor}
+}
+
+func (opts *editConfigOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit-config",
+		Short: "edit configuration values for an instance in $EDITOR",
+		Example: makeExample(
+			"fluxctl edit-config",
+			"EDITOR=nano fluxctl edit-config",
+		),
+		RunE: opts.RunE,
+	}
+	return cmd
+}
+
+func (opts *editConfigOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+
+	config, err := opts.API.GetConfig(context.Background(), noInstanceID, "")
+	if err != nil {
+		return err
+	}
+	// Secrets come hidden, so the config can be written out as it is
+	original := flux.UnsafeInstanceConfig(config)
+	inherited := original.Inherited
+	original.Inherited = nil
+	bytes, err := yaml.Marshal(original)
+	if err != nil {
+		return errors.Wrap(err, "marshalling config to YAML")
+	}
+	header := editConfigHeader
+	if len(inherited) > 0 {
+		header += "#\n# Inherited from the defaults: " + strings.Join(inherited, ", ") + "\n"
+	}
+
+	f, err := ioutil.TempFile("", "fluxctl-config")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	content := header + "\n" + string(bytes)
+	for {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			return err
+		}
+		if err := opts.edit(path); err != nil {
+			return errors.Wrap(err, "running editor")
+		}
+		edited, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if string(edited) == content {
+			if strings.HasPrefix(content, "# Error: ") {
+				return errors.New("config is not valid; no changes made")
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Edit cancelled; no changes made.")
+			return nil
+		}
+
+		updated, empty, err := parseConfig(edited, original)
+		if empty {
+			fmt.Fprintln(cmd.OutOrStdout(), "Edit cancelled; no changes made.")
+			return nil
+		}
+		if err != nil {
+			// Go round again, with the error at the top of the file
+			content = fmt.Sprintf("# Error: %s\n#\n%s", strings.Replace(err.Error(), "\n", " ", -1), withoutError(string(edited)))
+			continue
+		}
+
+		if err := opts.promptForSecrets(cmd, &updated); err != nil {
+			return err
+		}
+		patch, err := updated.PatchFrom(original)
+		if err != nil {
+			return err
+		}
+		if len(patch) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No changes made.")
+			return nil
+		}
+		return opts.API.PatchConfig(context.Background(), noInstanceID, patch)
+	}
+}
+
+// withoutError takes off the error put at the top of the file, if
+// it's still there.
+func withoutError(content string) string {
+	if !strings.HasPrefix(content, "# Error: ") {
+		return content
+	}
+	if i := strings.Index(content, "\n#\n"); i >= 0 {
+		return content[i+3:]
+	}
+	return content
+}
+
+// parseConfig reads and checks the edited config, and reports whether
+// it's empty (i.e., the user has cleared the file).
+func parseConfig(edited []byte, original flux.UnsafeInstanceConfig) (flux.UnsafeInstanceConfig, bool, error) {
+	var config flux.UnsafeInstanceConfig
+	var fields interface{}
+	if err := yaml.Unmarshal(edited, &fields); err != nil {
+		return config, false, errors.Wrap(err, "parsing config")
+	}
+	if fields == nil {
+		return config, true, nil
+	}
+	if err := checkFields(fields, reflect.TypeOf(config), ""); err != nil {
+		return config, false, err
+	}
+	if err := yaml.Unmarshal(edited, &config); err != nil {
+		return config, false, errors.Wrap(err, "parsing config")
+	}
+	config.Inherited = nil
+
+	// Check what the service would, other than secrets that are
+	// still hidden, or are to be asked for
+	if err := git.CheckKnownHosts(config.Git.KnownHosts); err != nil {
+		return config, false, errors.Wrap(err, "invalid git known hosts")
+	}
+	ssh := config.Git.SSH
+	if err := git.CheckSSHOptions(git.SSHOptions{Port: ssh.Port, JumpHost: ssh.JumpHost, Options: ssh.Options}); err != nil {
+		return config, false, errors.Wrap(err, "invalid git SSH options")
+	}
+	for host, auth := range config.Registry.Auths {
+		if auth.Auth == promptForSecret || auth == original.Registry.Auths[host] {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil || !strings.Contains(string(decoded), ":") {
+			return config, false, fmt.Errorf("invalid registry credentials for %s; expected base64-encoded username:password", host)
+		}
+	}
+	return config, false, nil
+}
+
+// checkFields checks that the fields given, as parsed from YAML, are
+// all in the type given, so that misspelt fields aren't silently
+// ignored.
+func checkFields(fields interface{}, t reflect.Type, path string) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkFields(fields, t.Elem(), path)
+	case reflect.Struct:
+		m, ok := fields.(map[interface{}]interface{})
+		if !ok {
+			return nil // the type is checked when unmarshalling
+		}
+		known := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+			known[name] = t.Field(i).Type
+		}
+		for k, v := range m {
+			key := fmt.Sprint(k)
+			field, ok := known[key]
+			if !ok {
+				return fmt.Errorf("unknown field %s", path+key)
+			}
+			if err := checkFields(v, field, path+key+"."); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if m, ok := fields.(map[interface{}]interface{}); ok {
+			for k, v := range m {
+				if err := checkFields(v, t.Elem(), fmt.Sprintf("%s%v.", path, k)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice:
+		if items, ok := fields.([]interface{}); ok {
+			for _, item := range items {
+				if err := checkFields(item, t.Elem(), path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// promptForSecrets asks for each secret given as promptForSecret.
+func (opts *editConfigOpts) promptForSecrets(cmd *cobra.Command, config *flux.UnsafeInstanceConfig) error {
+	for _, secret := range []struct {
+		path  string
+		value *string
+	}{
+		{"git.token", &config.Git.Token},
+		{"git.pullRequest.token", &config.Git.PullRequest.Token},
+		{"slack.secret", &config.Slack.Secret},
+	} {
+		if *secret.value != promptForSecret {
+			continue
+		}
+		value, err := opts.readSecret(cmd, secret.path)
+		if err != nil {
+			return err
+		}
+		*secret.value = value
+	}
+
+	var hosts []string
+	for host, auth := range config.Registry.Auths {
+		if auth.Auth == promptForSecret {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		value, err := opts.readSecret(cmd, fmt.Sprintf("registry.auths.%s (username:password)", host))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(value, ":") {
+			return fmt.Errorf("registry credentials for %s must be given as username:password", host)
+		}
+		config.Registry.Auths[host] = flux.Auth{Auth: base64.StdEncoding.EncodeToString([]byte(value))}
+	}
+	return nil
+}
+
+// readSecret asks for a secret, without echoing it if it's typed at a
+// terminal.
+func (opts *editConfigOpts) readSecret(cmd *cobra.Command, name string) (string, error) {
+	fmt.Fprintf(cmd.OutOrStderr(), "%s: ", name)
+	if f, ok := opts.in.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		secret, err := terminal.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(cmd.OutOrStderr())
+		return string(secret), err
+	}
+	if opts.lines == nil {
+		opts.lines = bufio.NewReader(opts.in)
+	}
+	line, err := opts.lines.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrapf(err, "reading %s", name)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runEditor opens the file in $VISUAL or $EDITOR, or vi if neither is
+// set.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may be given with arguments, e.g., `code --wait`
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "editor", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
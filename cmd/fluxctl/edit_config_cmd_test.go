@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/http/client"
+)
+
+// patchRecorder keeps the body of each config patch sent.
+type patchRecorder struct {
+	*genericMockRoundTripper
+	patches []flux.ConfigPatch
+}
+
+func (r *patchRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "PATCH" {
+		var patch flux.ConfigPatch
+		if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+			return nil, err
+		}
+		r.patches = append(r.patches, patch)
+	}
+	return r.genericMockRoundTripper.RoundTrip(req)
+}
+
+func TestEditConfigCommand(t *testing.T) {
+	existing := flux.InstanceConfig{
+		Git: flux.GitConfig{
+			URL:   "https://github.com/weaveworks/flux-example",
+			Token: "secret-token",
+		},
+		Slack: flux.NotifierConfig{
+			HookURL: "https://hooks.slack.com/example",
+		},
+	}
+
+	for _, c := range []struct {
+		name    string
+		edits   []func(string) string // each time the editor is opened
+		input   string
+		patches []flux.ConfigPatch
+		fails   bool
+	}{
+		{
+			name:  "unchanged",
+			edits: []func(string) string{func(s string) string { return s }},
+		},
+		{
+			name:  "emptied",
+			edits: []func(string) string{func(string) string { return "# nothing\n" }},
+		},
+		{
+			name: "changed",
+			edits: []func(string) string{func(s string) string {
+				return strings.Replace(s, "flux-example", "flux-example-2", 1)
+			}},
+			// The hidden token isn't sent back
+			patches: []flux.ConfigPatch{{"git": map[string]interface{}{"URL": "https://github.com/weaveworks/flux-example-2"}}},
+		},
+		{
+			name: "secret prompted for",
+			edits: []func(string) string{func(s string) string {
+				return strings.Replace(s, "token: '******'", "token: "+promptForSecret, 1)
+			}},
+			input:   "new-token\n",
+			patches: []flux.ConfigPatch{{"git": map[string]interface{}{"token": "new-token"}}},
+		},
+		{
+			name: "invalid, then fixed",
+			edits: []func(string) string{
+				func(s string) string { return s + "gti:\n  URL: x\n" },
+				func(s string) string {
+					if !strings.HasPrefix(s, "# Error: unknown field gti") {
+						t.Errorf("expected the error at the top of the file, got:\n%s", s)
+					}
+					return strings.Replace(s, "gti:\n  URL: x\n", "cluster: prod\n", 1)
+				},
+			},
+			patches: []flux.ConfigPatch{{"cluster": "prod"}},
+		},
+		{
+			name: "invalid, then given up",
+			edits: []func(string) string{
+				func(s string) string { return s + "slack:\n  hookurl: x\n" },
+				func(s string) string { return s },
+			},
+			fails: true,
+		},
+	} {
+		svc := &patchRecorder{genericMockRoundTripper: &genericMockRoundTripper{
+			mockResponses: map[*mux.Route]interface{}{
+				transport.NewRouter().Get("GetConfig"):   existing,
+				transport.NewRouter().Get("PatchConfig"): nil,
+			},
+		}}
+		opts := newEditConfig(&rootOpts{
+			API: client.New(&http.Client{Transport: svc}, transport.NewRouter(), "", ""),
+		})
+		opts.in = strings.NewReader(c.input)
+		edits := c.edits
+		opts.edit = func(path string) error {
+			if len(edits) == 0 {
+				t.Fatalf("%s: editor opened too many times", c.name)
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			edit := edits[0]
+			edits = edits[1:]
+			return ioutil.WriteFile(path, []byte(edit(string(content))), 0600)
+		}
+
+		cmd := opts.Command()
+		cmd.SetOutput(ioutil.Discard)
+		cmd.SetArgs(nil)
+		if err := cmd.Execute(); (err != nil) != c.fails {
+			t.Errorf("%s: expected failure=%v, got %v", c.name, c.fails, err)
+		}
+		if !reflect.DeepEqual(c.patches, svc.patches) {
+			t.Errorf("%s: expected patches %#v, got %#v", c.name, c.patches, svc.patches)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/pkg/errors"
@@ -51,7 +52,7 @@ func (opts *getConfigOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errors.New("unknown output format " + opts.output)
 	}
 
-	config, err := opts.API.GetConfig(noInstanceID, opts.fingerprint)
+	config, err := opts.API.GetConfig(context.Background(), noInstanceID, opts.fingerprint)
 	if err != nil {
 		return err
 	}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
 )
 
 type serviceHistoryOpts struct {
 	*serviceOpts
-	service string
+	service     string
+	allClusters bool
+	limit       int64
+	before      string
+	after       string
+	types       []string
 }
 
 func newServiceHistory(parent *serviceOpts) *serviceHistoryOpts {
@@ -23,10 +31,17 @@ func (opts *serviceHistoryOpts) Command() *cobra.Command {
 		Example: makeExample(
 			"fluxctl history --service=default/foo",
 			"fluxctl history",
+			"fluxctl history --service=default/foo --all-clusters",
+			"fluxctl history --service=default/foo --limit=20 --type=release",
 		),
 		RunE: opts.RunE,
 	}
 	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service for which to show history; if left empty, history for all services is shown")
+	cmd.Flags().BoolVar(&opts.allClusters, "all-clusters", false, "Show the history of the service in every cluster grouped with this one by the service, rather than just this one")
+	cmd.Flags().Int64Var(&opts.limit, "limit", -1, "Show at most this many entries, newest first; -1 for all of them")
+	cmd.Flags().StringVar(&opts.before, "before", "", "Show the entries before this cursor, as given for the next page")
+	cmd.Flags().StringVar(&opts.after, "after", "", "Show the entries after this cursor, as given for the previous page")
+	cmd.Flags().StringSliceVar(&opts.types, "type", nil, "Show only events of these types (e.g., release,automate)")
 	return cmd
 }
 
@@ -35,12 +50,24 @@ func (opts *serviceHistoryOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errorWantedNoArgs
 	}
 
+	if opts.allClusters {
+		if opts.limit != -1 || opts.before != "" || opts.after != "" || len(opts.types) > 0 {
+			return newUsageError("--all-clusters cannot be used with --limit, --before, --after or --type")
+		}
+		return opts.allClustersRunE(cmd)
+	}
+
 	service, err := parseServiceOption(opts.service)
 	if err != nil {
 		return err
 	}
 
-	events, err := opts.API.History(noInstanceID, service, time.Time{}, -1)
+	page, err := opts.API.History(context.Background(), noInstanceID, service, flux.HistoryQuery{
+		BeforeCursor: opts.before,
+		AfterCursor:  opts.after,
+		Types:        opts.types,
+		Limit:        opts.limit,
+	})
 	if err != nil {
 		return err
 	}
@@ -48,10 +75,41 @@ func (opts *serviceHistoryOpts) RunE(cmd *cobra.Command, args []string) error {
 	out := newTabwriter(cmd.OutOrStdout())
 
 	fmt.Fprintln(out, "TIME\tTYPE\tMESSAGE")
-	for _, event := range events {
+	for _, event := range page.Entries {
 		fmt.Fprintf(out, "%s\t%s\t%s\n", event.Stamp.Format(time.RFC822), event.Type, event.Data)
 	}
 
+	out.Flush()
+
+	// If there may be more, say how to see them.
+	if opts.limit >= 0 && int64(len(page.Entries)) == opts.limit && page.Total > opts.limit {
+		last := page.Entries[len(page.Entries)-1]
+		fmt.Fprintf(cmd.OutOrStderr(), "\nShowing %d of %d entries. For older entries, run again with\n\n\t--before=%s\n\n", len(page.Entries), page.Total, last.Cursor)
+	}
+	return nil
+}
+
+func (opts *serviceHistoryOpts) allClustersRunE(cmd *cobra.Command) error {
+	if opts.service == "" {
+		return newUsageError("--all-clusters needs a service, given with --service")
+	}
+	service, err := flux.ParseServiceID(opts.service)
+	if err != nil {
+		return err
+	}
+
+	events, err := opts.API.HistoryAllClusters(context.Background(), noInstanceID, service, time.Time{}, -1)
+	if err != nil {
+		return err
+	}
+
+	out := newTabwriter(cmd.OutOrStdout())
+
+	fmt.Fprintln(out, "TIME\tCLUSTER\tTYPE\tMESSAGE")
+	for _, event := range events {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", event.Stamp.Format(time.RFC822), event.Cluster, event.Type, event.Data)
+	}
+
 	out.Flush()
 	return nil
 }
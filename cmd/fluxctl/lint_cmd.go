@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+type lintOpts struct {
+	*rootOpts
+}
+
+func newLint(parent *rootOpts) *lintOpts {
+	return &lintOpts{rootOpts: parent}
+}
+
+func (opts *lintOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the resource definitions in the config repo for common problems",
+		Example: makeExample(
+			"fluxctl lint",
+		),
+		RunE: opts.RunE,
+	}
+	return cmd
+}
+
+func (opts *lintOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+
+	report, err := opts.API.Lint(context.Background(), noInstanceID)
+	if err != nil {
+		return err
+	}
+
+	if len(report.Problems) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No problems found at revision %s.\n", report.Revision)
+		return nil
+	}
+
+	out := newTabwriter(cmd.OutOrStdout())
+	fmt.Fprintln(out, "FILE\tRESOURCE\tRULE\tMESSAGE")
+	for _, p := range report.Problems {
+		resource := p.Kind
+		if p.Name != "" {
+			resource = fmt.Sprintf("%s/%s", p.Kind, p.Name)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", p.File, resource, p.Rule, p.Message)
+	}
+	out.Flush()
+	return nil
+}
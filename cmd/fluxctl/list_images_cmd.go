@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -14,6 +17,9 @@ type serviceShowOpts struct {
 	*serviceOpts
 	service string
 	limit   int
+	only    []string
+	digests bool
+	groupBy string
 }
 
 func newServiceShow(parent *serviceOpts) *serviceShowOpts {
@@ -22,13 +28,21 @@ func newServiceShow(parent *serviceOpts) *serviceShowOpts {
 
 func (opts *serviceShowOpts) Command() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "list-images",
-		Short:   "Show the deployed and available images for a service.",
-		Example: makeExample("fluxctl list-images --service=default/foo"),
-		RunE:    opts.RunE,
+		Use:   "list-images",
+		Short: "Show the deployed and available images for a service.",
+		Example: makeExample(
+			"fluxctl list-images --service=default/foo",
+			"fluxctl list-images --only automated --only stale",
+			"fluxctl list-images --service=default/foo --digests",
+			"fluxctl list-images --group-by=repository",
+		),
+		RunE: opts.RunE,
 	}
 	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Show images for this service")
 	cmd.Flags().IntVarP(&opts.limit, "limit", "n", 10, "Number of images to show (0 for all)")
+	cmd.Flags().StringSliceVar(&opts.only, "only", []string{}, `Only show services that are "automated", "locked", or "stale" (not running the latest image); give more than once to require all`)
+	cmd.Flags().BoolVarP(&opts.digests, "digests", "d", false, "Show the digest of each image, and whether it is signed (for registries with trust configured), and the digest for each platform of the newest image, if it is multi-arch")
+	cmd.Flags().StringVar(&opts.groupBy, "group-by", groupByService, `Show images for each "service", or show each image "repository" with the latest tag and the tags running, and which services run each`)
 	return cmd
 }
 
@@ -42,7 +56,20 @@ func (opts *serviceShowOpts) RunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	services, err := opts.API.ListImages(noInstanceID, service)
+	if opts.groupBy != groupByService && opts.groupBy != groupByRepository {
+		return newUsageError(fmt.Sprintf("--group-by must be %q or %q", groupByService, groupByRepository))
+	}
+
+	var only []flux.ImageStatusFilter
+	for _, o := range opts.only {
+		f, err := flux.ParseImageStatusFilter(o)
+		if err != nil {
+			return newUsageError(err.Error())
+		}
+		only = append(only, f)
+	}
+
+	services, err := opts.API.ListImages(context.Background(), noInstanceID, service, only)
 	if err != nil {
 		return err
 	}
@@ -51,7 +78,17 @@ func (opts *serviceShowOpts) RunE(cmd *cobra.Command, args []string) error {
 
 	out := newTabwriter(cmd.OutOrStdout())
 
-	fmt.Fprintln(out, "SERVICE\tCONTAINER\tIMAGE\tCREATED")
+	if opts.groupBy == groupByRepository {
+		printByRepository(out, services)
+		out.Flush()
+		return nil
+	}
+
+	if opts.digests {
+		fmt.Fprintln(out, "SERVICE\tCONTAINER\tIMAGE\tCREATED\tDIGEST\tSIGNED")
+	} else {
+		fmt.Fprintln(out, "SERVICE\tCONTAINER\tIMAGE\tCREATED")
+	}
 	for _, service := range services {
 		if len(service.Containers) == 0 {
 			fmt.Fprintf(out, "%s\t\t\t\n", service.ID)
@@ -89,11 +126,15 @@ func (opts *serviceShowOpts) RunE(cmd *cobra.Command, args []string) error {
 					fmt.Fprintf(out, "\t\t%s\t\n", ":")
 				}
 				if printLine {
-					createdAt := ""
-					if available.CreatedAt != nil {
-						createdAt = available.CreatedAt.Format(time.RFC822)
+					createdAt := formatCreatedAt(available.CreatedAt)
+					if opts.digests {
+						fmt.Fprintf(out, "\t\t%s %s\t%s\t%s\t%s\n", running, tag, createdAt, available.Digest, signedStatus(available.Signed))
+						for _, p := range available.Platforms {
+							fmt.Fprintf(out, "\t\t      %s\t\t%s\t\n", platformName(p), p.Digest)
+						}
+					} else {
+						fmt.Fprintf(out, "\t\t%s %s\t%s\n", running, tag, createdAt)
 					}
-					fmt.Fprintf(out, "\t\t%s %s\t%s\n", running, tag, createdAt)
 				}
 			}
 			serviceName = ""
@@ -103,6 +144,164 @@ func (opts *serviceShowOpts) RunE(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+const (
+	groupByService    = "service"
+	groupByRepository = "repository"
+)
+
+// repositoryUsage is the tags of an image repository being run, and
+// by which services.
+type repositoryUsage struct {
+	name   string
+	latest *flux.ImageDescription
+	tags   []*tagUsage
+}
+
+type tagUsage struct {
+	tag       string
+	createdAt *time.Time
+	order     int // where it is in the available images, newest first; -1 if not there
+	services  []flux.ServiceID
+}
+
+// groupImagesByRepository pivots the images of services to give, for each
+// repository, the tags running, newest first, and the services
+// running each.
+func groupImagesByRepository(services []flux.ImageStatus) []*repositoryUsage {
+	repos := map[string]*repositoryUsage{}
+	for _, service := range services {
+		for _, container := range service.Containers {
+			repo, currentTag := container.Current.ID.Repository(), container.Current.ID.Tag
+			usage, ok := repos[repo]
+			if !ok {
+				usage = &repositoryUsage{name: repo}
+				repos[repo] = usage
+			}
+			if usage.latest == nil && len(container.Available) > 0 {
+				usage.latest = &container.Available[0]
+			}
+
+			var tag *tagUsage
+			for _, t := range usage.tags {
+				if t.tag == currentTag {
+					tag = t
+					break
+				}
+			}
+			if tag == nil {
+				tag = &tagUsage{tag: currentTag, createdAt: container.Current.CreatedAt, order: -1}
+				for i, available := range container.Available {
+					if available.ID.Tag == currentTag {
+						tag.createdAt, tag.order = available.CreatedAt, i
+						break
+					}
+				}
+				usage.tags = append(usage.tags, tag)
+			}
+			// A service may run the image in more than one container
+			if n := len(tag.services); n == 0 || tag.services[n-1] != service.ID {
+				tag.services = append(tag.services, service.ID)
+			}
+		}
+	}
+
+	var result []*repositoryUsage
+	for _, usage := range repos {
+		sort.Sort(tagsNewestFirst(usage.tags))
+		result = append(result, usage)
+	}
+	sort.Sort(repositoriesByName(result))
+	return result
+}
+
+// printByRepository prints each repository, with its latest tag if
+// no service is running it, so it's plain which services are behind.
+func printByRepository(out io.Writer, services []flux.ImageStatus) {
+	fmt.Fprintln(out, "REPOSITORY\tTAG\tCREATED\tSERVICES")
+	for _, repo := range groupImagesByRepository(services) {
+		name := repo.name
+		if repo.latest != nil && (len(repo.tags) == 0 || repo.tags[0].tag != repo.latest.ID.Tag) {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", name, repo.latest.ID.Tag, formatCreatedAt(repo.latest.CreatedAt), "(latest)")
+			name = ""
+		}
+		for _, tag := range repo.tags {
+			ids := make([]string, len(tag.services))
+			for i, id := range tag.services {
+				ids[i] = string(id)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", name, tag.tag, formatCreatedAt(tag.createdAt), strings.Join(ids, ", "))
+			name = ""
+		}
+	}
+}
+
+func formatCreatedAt(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC822)
+}
+
+type repositoriesByName []*repositoryUsage
+
+func (s repositoriesByName) Len() int {
+	return len(s)
+}
+
+func (s repositoriesByName) Less(a, b int) bool {
+	return s[a].name < s[b].name
+}
+
+func (s repositoriesByName) Swap(a, b int) {
+	s[a], s[b] = s[b], s[a]
+}
+
+// tagsNewestFirst puts tags that aren't among the available images
+// (e.g., because they've been deleted from the registry) last.
+type tagsNewestFirst []*tagUsage
+
+func (s tagsNewestFirst) Len() int {
+	return len(s)
+}
+
+func (s tagsNewestFirst) Less(a, b int) bool {
+	switch {
+	case s[a].order == -1 && s[b].order == -1:
+		return s[a].tag < s[b].tag
+	case s[a].order == -1:
+		return false
+	case s[b].order == -1:
+		return true
+	}
+	return s[a].order < s[b].order
+}
+
+func (s tagsNewestFirst) Swap(a, b int) {
+	s[a], s[b] = s[b], s[a]
+}
+
+// platformName gives the platform of one image in a multi-arch image,
+// as it's usually written, e.g., linux/arm/v7.
+func platformName(p flux.ImagePlatform) string {
+	name := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		name += "/" + p.Variant
+	}
+	return name
+}
+
+// signedStatus says whether an image is signed, if that's known.
+func signedStatus(signed *bool) string {
+	switch {
+	case signed == nil:
+		return ""
+	case *signed:
+		return "signed"
+	default:
+		return "unsigned"
+	}
+}
+
 type imageStatusByName []flux.ImageStatus
 
 func (s imageStatusByName) Len() int {
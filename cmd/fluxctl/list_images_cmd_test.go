@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+func mustParseImageID(t *testing.T, s string) flux.ImageID {
+	id, err := flux.ParseImageID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestListImagesGroupByRepository(t *testing.T) {
+	var (
+		old    = flux.ImageDescription{ID: mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000001")}
+		newer  = flux.ImageDescription{ID: mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000002")}
+		newest = flux.ImageDescription{ID: mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000003")}
+		gone   = flux.ImageDescription{ID: mustParseImageID(t, "quay.io/weaveworks/helloworld:deleted")}
+		memcd  = flux.ImageDescription{ID: mustParseImageID(t, "memcached:1.4.25")}
+
+		available = []flux.ImageDescription{newest, newer, old}
+	)
+	services := []flux.ImageStatus{
+		{ID: "default/a", Containers: []flux.Container{
+			{Name: "hello", Current: old, Available: available},
+			{Name: "cache", Current: memcd, Available: []flux.ImageDescription{memcd}},
+		}},
+		{ID: "default/b", Containers: []flux.Container{
+			{Name: "hello", Current: newer, Available: available},
+			{Name: "hello2", Current: newer, Available: available},
+		}},
+		{ID: "default/c", Containers: []flux.Container{
+			{Name: "hello", Current: old, Available: available},
+		}},
+		{ID: "default/d", Containers: []flux.Container{
+			{Name: "hello", Current: gone, Available: available},
+		}},
+	}
+
+	repos := groupImagesByRepository(services)
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 repositories, got %d", len(repos))
+	}
+	assertString(t, "memcached", repos[0].name)
+	hello := repos[1]
+	assertString(t, "quay.io/weaveworks/helloworld", hello.name)
+	assertString(t, "master-a000003", hello.latest.ID.Tag)
+
+	var got []string
+	for _, tag := range hello.tags {
+		var ids []string
+		for _, id := range tag.services {
+			ids = append(ids, string(id))
+		}
+		got = append(got, tag.tag+"="+strings.Join(ids, ","))
+	}
+	assertString(t, "master-a000002=default/b master-a000001=default/a,default/c deleted=default/d", strings.Join(got, " "))
+}
+
+func TestListImagesGroupByFlag(t *testing.T) {
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("ListImages"): []flux.ImageStatus{
+				{ID: "default/a", Containers: []flux.Container{
+					{Name: "hello", Current: flux.ImageDescription{ID: mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000001")}},
+				}},
+			},
+		},
+	}
+	var out bytes.Buffer
+	cmd := newServiceShow(mockServiceOpts(svc)).Command()
+	cmd.SetOutput(&out)
+	cmd.SetArgs([]string{"--group-by=repository"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "REPOSITORY") || !strings.Contains(out.String(), "default/a") {
+		t.Errorf("expected images grouped by repository, got:\n%s", out.String())
+	}
+
+	cmd.SetArgs([]string{"--group-by=container"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error grouping by something unknown")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sort"
 
@@ -34,7 +35,7 @@ func (opts *serviceListOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errorWantedNoArgs
 	}
 
-	services, err := opts.API.ListServices(noInstanceID, opts.namespace)
+	services, err := opts.API.ListServices(context.Background(), noInstanceID, opts.namespace)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"github.com/spf13/cobra"
+	"context"
 
-	"github.com/weaveworks/flux"
+	"github.com/spf13/cobra"
 )
 
 type serviceLockOpts struct {
@@ -21,10 +21,11 @@ func (opts *serviceLockOpts) Command() *cobra.Command {
 		Short: "Lock a service, so it cannot be deployed.",
 		Example: makeExample(
 			"fluxctl lock --service=helloworld",
+			"fluxctl lock --service=selector:app=frontend",
 		),
 		RunE: opts.RunE,
 	}
-	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to lock")
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to lock, or selector:<label>=<value>,... for the services with those labels")
 	return cmd
 }
 
@@ -36,10 +37,15 @@ func (opts *serviceLockOpts) RunE(_ *cobra.Command, args []string) error {
 		return newUsageError("-s, --service is required")
 	}
 
-	serviceID, err := flux.ParseServiceID(opts.service)
+	serviceIDs, err := resolveServiceOption(opts.API, opts.service)
 	if err != nil {
 		return err
 	}
 
-	return opts.API.Lock(noInstanceID, serviceID)
+	for _, serviceID := range serviceIDs {
+		if err := opts.API.Lock(context.Background(), noInstanceID, serviceID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/jobs"
+)
+
+type logsOpts struct {
+	*rootOpts
+}
+
+func newLogs(parent *rootOpts) *logsOpts {
+	return &logsOpts{rootOpts: parent}
+}
+
+func (opts *logsOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <job ID>",
+		Short: "Show the log of a job, e.g., a release, including the git and apply steps",
+		Example: makeExample(
+			"fluxctl logs 12345678-1234-5678-1234-567812345678",
+		),
+		RunE: opts.RunE,
+	}
+	return cmd
+}
+
+func (opts *logsOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return newUsageError("please supply the ID of the job, e.g., as given by `fluxctl release`")
+	}
+
+	lines, err := opts.API.JobLog(context.Background(), noInstanceID, jobs.JobID(args[0]))
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Fprintln(cmd.OutOrStdout(), line)
+	}
+	return nil
+}
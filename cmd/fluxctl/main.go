@@ -9,7 +9,9 @@ import (
 )
 
 func run(args []string) int {
-	rootCmd := newRoot().Command()
+	opts := newRoot()
+	defer opts.Close()
+	rootCmd := opts.Command()
 	rootCmd.SetArgs(args)
 	if cmd, err := rootCmd.ExecuteC(); err != nil {
 		err = errors.Cause(err)
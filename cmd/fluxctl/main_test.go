@@ -28,6 +28,9 @@ func mockServiceOpts(trip *genericMockRoundTripper) *serviceOpts {
 	}
 }
 
+// rawResponse is a mock response sent as it is, rather than as JSON.
+type rawResponse string
+
 type genericMockRoundTripper struct {
 	mockResponses  map[*mux.Route]interface{}
 	requestHistory []mux.RouteMatch
@@ -45,7 +48,11 @@ func (t *genericMockRoundTripper) RoundTrip(req *http.Request) (*http.Response,
 			}
 			matched.Vars = queryParamsWithArrays
 			t.requestHistory = append(t.requestHistory, matched)
-			b, _ = json.Marshal(v)
+			if raw, ok := v.(rawResponse); ok {
+				b = []byte(raw)
+			} else {
+				b, _ = json.Marshal(v)
+			}
 			status = 200
 			break
 		}
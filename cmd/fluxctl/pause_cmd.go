@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+
+	"github.com/spf13/cobra"
+)
+
+type pauseOpts struct {
+	*rootOpts
+	user   string
+	reason string
+}
+
+func newPause(parent *rootOpts) *pauseOpts {
+	return &pauseOpts{rootOpts: parent}
+}
+
+func (opts *pauseOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pause",
+		Short: "Stop all automated releases and syncs, until resumed.",
+		Example: makeExample(
+			`fluxctl pause --reason="investigating outage"`,
+		),
+		RunE: opts.RunE,
+	}
+
+	username := ""
+	if user, err := user.Current(); err == nil {
+		username = user.Username
+	}
+
+	cmd.Flags().StringVarP(&opts.reason, "reason", "m", "", "why automation is paused, for the history and status")
+	cmd.Flags().StringVar(&opts.user, "user", username, "override the user reported as pausing automation")
+	return cmd
+}
+
+func (opts *pauseOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if err := opts.API.Pause(context.Background(), noInstanceID, opts.user, opts.reason); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Automation paused; run `fluxctl resume` to carry on.")
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestPauseCommand(t *testing.T) {
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("Pause"): nil,
+		},
+	}
+	cmd := newPause(mockServiceOpts(svc).rootOpts).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--user=alice", "--reason=outage in eu-west"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	vars := calledRequest("Pause", svc.requestHistory).Vars
+	assertString(t, "alice", vars["user"])
+	assertString(t, "outage in eu-west", vars["reason"])
+}
+
+func TestResumeCommand(t *testing.T) {
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("Resume"): nil,
+		},
+	}
+	cmd := newResume(mockServiceOpts(svc).rootOpts).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--user=alice"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "alice", calledRequest("Resume", svc.requestHistory).Vars["user"])
+
+	cmd.SetArgs([]string{"now"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected error for unexpected argument")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The annotation marking the Kubernetes service that fronts the flux
+// API. Its value is the name or number of the port to use; if blank,
+// the service's first port is used.
+const fluxAPIAnnotation = "flux.weave.works/api"
+
+const portForwardTimeout = 10 * time.Second
+
+// Just the bits of the Kubernetes API objects we need, as output by
+// `kubectl get -o json`.
+
+type kubeMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type kubeServiceList struct {
+	Items []kubeService `json:"items"`
+}
+
+type kubeService struct {
+	Metadata kubeMetadata `json:"metadata"`
+	Spec     struct {
+		Selector map[string]string `json:"selector"`
+		Ports    []struct {
+			Name       string          `json:"name"`
+			Port       int             `json:"port"`
+			TargetPort json.RawMessage `json:"targetPort"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type kubePodList struct {
+	Items []kubePod `json:"items"`
+}
+
+type kubePod struct {
+	Metadata kubeMetadata `json:"metadata"`
+	Spec     struct {
+		Containers []struct {
+			Ports []struct {
+				Name          string `json:"name"`
+				ContainerPort int    `json:"containerPort"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// portForward finds the flux API in the current kubectl context, and
+// forwards a local port to it. It returns the URL to use, and a
+// function to stop forwarding.
+func portForward() (string, func(), error) {
+	var services kubeServiceList
+	if err := kubectlJSON(&services, "get", "services", "--all-namespaces"); err != nil {
+		return "", nil, err
+	}
+	svc, err := findFluxService(services.Items)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var selector []string
+	for k, v := range svc.Spec.Selector {
+		selector = append(selector, k+"="+v)
+	}
+	if len(selector) == 0 {
+		return "", nil, fmt.Errorf("service %s/%s has no selector, so cannot find a pod to forward to", svc.Metadata.Namespace, svc.Metadata.Name)
+	}
+	var pods kubePodList
+	if err := kubectlJSON(&pods, "get", "pods", "--namespace", svc.Metadata.Namespace, "--selector", strings.Join(selector, ",")); err != nil {
+		return "", nil, err
+	}
+	pod, port, err := podAndPort(svc, pods.Items)
+	if err != nil {
+		return "", nil, err
+	}
+
+	localPort, err := freePort()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "finding a free local port")
+	}
+	cmd := exec.Command("kubectl", "port-forward", "--namespace", svc.Metadata.Namespace, pod.Metadata.Name, fmt.Sprintf("%d:%d", localPort, port))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return "", nil, errors.Wrap(err, "running kubectl port-forward")
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", localPort)
+	deadline := time.Now().Add(portForwardTimeout)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return "", nil, fmt.Errorf("timed out waiting for port-forward to %s/%s", svc.Metadata.Namespace, pod.Metadata.Name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "http://" + addr + "/api/flux", stop, nil
+}
+
+// findFluxService picks the single service annotated as the flux API.
+func findFluxService(services []kubeService) (kubeService, error) {
+	var found []kubeService
+	for _, s := range services {
+		if _, ok := s.Metadata.Annotations[fluxAPIAnnotation]; ok {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return kubeService{}, fmt.Errorf("no service with the annotation %s found in the current kubectl context", fluxAPIAnnotation)
+	case 1:
+		return found[0], nil
+	default:
+		var names []string
+		for _, s := range found {
+			names = append(names, s.Metadata.Namespace+"/"+s.Metadata.Name)
+		}
+		return kubeService{}, fmt.Errorf("more than one service has the annotation %s: %s", fluxAPIAnnotation, strings.Join(names, ", "))
+	}
+}
+
+// podAndPort picks a running pod behind the service, and the port on
+// that pod which the annotated service port targets.
+func podAndPort(svc kubeService, pods []kubePod) (kubePod, int, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return kubePod{}, 0, fmt.Errorf("service %s/%s has no ports", svc.Metadata.Namespace, svc.Metadata.Name)
+	}
+	want := svc.Metadata.Annotations[fluxAPIAnnotation]
+	port := svc.Spec.Ports[0]
+	if want != "" {
+		var ok bool
+		for _, p := range svc.Spec.Ports {
+			if p.Name == want || strconv.Itoa(p.Port) == want {
+				port, ok = p, true
+				break
+			}
+		}
+		if !ok {
+			return kubePod{}, 0, fmt.Errorf("service %s/%s has no port %q", svc.Metadata.Namespace, svc.Metadata.Name, want)
+		}
+	}
+
+	for _, pod := range pods {
+		if pod.Status.Phase != "Running" {
+			continue
+		}
+		// The target port may be a number, a name for a container
+		// port, or absent, in which case it's the same as the port.
+		var number int
+		var name string
+		switch {
+		case len(port.TargetPort) == 0:
+			number = port.Port
+		case json.Unmarshal(port.TargetPort, &number) == nil:
+		case json.Unmarshal(port.TargetPort, &name) == nil:
+			for _, c := range pod.Spec.Containers {
+				for _, p := range c.Ports {
+					if p.Name == name {
+						number = p.ContainerPort
+					}
+				}
+			}
+		}
+		if number == 0 {
+			return kubePod{}, 0, fmt.Errorf("cannot find the port targeted by service %s/%s in pod %s", svc.Metadata.Namespace, svc.Metadata.Name, pod.Metadata.Name)
+		}
+		return pod, number, nil
+	}
+	return kubePod{}, 0, fmt.Errorf("no running pods found for service %s/%s", svc.Metadata.Namespace, svc.Metadata.Name)
+}
+
+func kubectlJSON(dest interface{}, args ...string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("kubectl", append(args, "--output", "json")...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "running kubectl %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return errors.Wrap(json.Unmarshal(stdout.Bytes(), dest), "decoding kubectl output")
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
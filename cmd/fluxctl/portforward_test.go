@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const servicesJSON = `{"items": [
+  {"metadata": {"name": "other", "namespace": "default"}, "spec": {"ports": [{"port": 80}]}},
+  {"metadata": {"name": "fluxsvc", "namespace": "flux", "annotations": {"flux.weave.works/api": "api"}},
+   "spec": {"selector": {"name": "fluxsvc"},
+            "ports": [{"name": "metrics", "port": 8080, "targetPort": 8080},
+                      {"name": "api", "port": 80, "targetPort": "http"}]}}
+]}`
+
+const podsJSON = `{"items": [
+  {"metadata": {"name": "fluxsvc-1"}, "status": {"phase": "Pending"}},
+  {"metadata": {"name": "fluxsvc-2"}, "status": {"phase": "Running"},
+   "spec": {"containers": [{"ports": [{"name": "http", "containerPort": 3030}]}]}}
+]}`
+
+func TestFindFluxAPI(t *testing.T) {
+	var services kubeServiceList
+	if err := json.Unmarshal([]byte(servicesJSON), &services); err != nil {
+		t.Fatal(err)
+	}
+	var pods kubePodList
+	if err := json.Unmarshal([]byte(podsJSON), &pods); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := findFluxService(services.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Metadata.Name != "fluxsvc" {
+		t.Fatalf("expected to find service fluxsvc, got %q", svc.Metadata.Name)
+	}
+
+	pod, port, err := podAndPort(svc, pods.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Name != "fluxsvc-2" || port != 3030 {
+		t.Fatalf("expected running pod fluxsvc-2 on port 3030, got %s on %d", pod.Metadata.Name, port)
+	}
+
+	if _, err := findFluxService(services.Items[:1]); err == nil {
+		t.Fatal("expected an error when no service is annotated")
+	}
+}
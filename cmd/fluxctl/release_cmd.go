@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/user"
+	"time"
 
+	"github.com/gosuri/uilive"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 
 	"github.com/weaveworks/flux"
@@ -12,15 +18,24 @@ import (
 
 type serviceReleaseOpts struct {
 	*serviceOpts
-	services    []string
-	allServices bool
-	image       string
-	allImages   bool
-	noUpdate    bool
-	exclude     []string
-	dryRun      bool
-	user        string
-	message     string
+	services     []string
+	allServices  bool
+	allAutomated bool
+	image        string
+	allImages    bool
+	noUpdate     bool
+	setEnv       []string
+	setConfig    []string
+	exclude      []string
+	dryRun       bool
+	atomic       bool
+	blueGreen    bool
+	validate     bool
+	user         string
+	message      string
+	watch        bool
+	template     string
+	saveTemplate string
 	serviceReleaseOutputOpts
 }
 
@@ -35,8 +50,18 @@ func (opts *serviceReleaseOpts) Command() *cobra.Command {
 		Example: makeExample(
 			"fluxctl release --service=default/foo --update-image=library/hello:v2",
 			"fluxctl release --all --update-image=library/hello:v2",
+			"fluxctl release --all-automated --exclude=default/fragile --update-all-images",
 			"fluxctl release --service=default/foo --update-all-images",
+			"fluxctl release --service=selector:app=frontend,tier=web --update-all-images",
 			"fluxctl release --service=default/foo --no-update",
+			"fluxctl release --service=default/foo --set-env=LOG_LEVEL=debug",
+			"fluxctl release --service=default/foo --set-config=foo-config:log.level=debug",
+			"fluxctl release --atomic --service=default/foo --service=default/bar --update-image=library/hello:v2",
+			"fluxctl release --blue-green --service=default/foo --update-image=library/hello:v2",
+			"fluxctl release --validate --service=default/foo --update-image=library/hello:v2",
+			"fluxctl release --watch --service=default/foo --update-all-images",
+			`fluxctl release --save-template=frontend --service=selector:tier=frontend --update-all-images --message="Weekly frontend release by {{.User}}"`,
+			"fluxctl release --template=frontend",
 		),
 		RunE: opts.RunE,
 	}
@@ -47,18 +72,27 @@ func (opts *serviceReleaseOpts) Command() *cobra.Command {
 		username = user.Username
 	}
 
-	cmd.Flags().StringSliceVarP(&opts.services, "service", "s", []string{}, "service to release")
+	cmd.Flags().StringSliceVarP(&opts.services, "service", "s", []string{}, "service to release, or selector:<label>=<value>,... for the services with those labels")
 	cmd.Flags().BoolVar(&opts.allServices, "all", false, "release all services")
+	cmd.Flags().BoolVar(&opts.allAutomated, "all-automated", false, "release all automated services (and any given with --service)")
 	cmd.Flags().StringVarP(&opts.image, "update-image", "i", "", "update a specific image")
 	cmd.Flags().BoolVar(&opts.allImages, "update-all-images", false, "update all images to latest versions")
 	cmd.Flags().BoolVar(&opts.noUpdate, "no-update", false, "don't update images; just deploy the service(s) as configured in the git repo")
+	cmd.Flags().Var(stringsFlag{&opts.setEnv}, "set-env", "set an environment variable, as [container:]NAME=value (may be given more than once)")
+	cmd.Flags().Var(stringsFlag{&opts.setConfig}, "set-config", "set a ConfigMap value, as configmap:KEY=value (may be given more than once)")
 	cmd.Flags().StringSliceVar(&opts.exclude, "exclude", []string{}, "exclude a service")
 	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "do not release anything; just report back what would have been done")
+	cmd.Flags().BoolVar(&opts.validate, "validate", false, "do not submit a release; just check the services and image given, and report any problems")
+	cmd.Flags().BoolVar(&opts.atomic, "atomic", false, "release all the services given, or if any of them cannot be updated, none of them")
+	cmd.Flags().BoolVar(&opts.blueGreen, "blue-green", false, "run a copy of each service's workload with the new images, and switch over once the copies are ready")
 	cmd.Flags().BoolVar(&opts.noFollow, "no-follow", false, "just submit the release job, don't invoke check-release afterwards")
 	cmd.Flags().BoolVar(&opts.noTty, "no-tty", false, "if not --no-follow, forces simpler, non-TTY status output")
 	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "include ignored services in output")
+	cmd.Flags().BoolVarP(&opts.watch, "watch", "w", false, fmt.Sprintf("after the release, show the rollout status of the services released until they are ready (for up to %s)", rolloutTimeout))
 	cmd.Flags().StringVarP(&opts.message, "message", "m", "", "attach a message to the release job")
 	cmd.Flags().StringVar(&opts.user, "user", username, "override the user reported as initating the release job")
+	cmd.Flags().StringVar(&opts.template, "template", "", "release as saved in the release template of this name, rather than as given by the other flags")
+	cmd.Flags().StringVar(&opts.saveTemplate, "save-template", "", "do not release anything; save the release as a template of this name, to release from later with --template (the message may use {{.Name}}, {{.User}} and {{.Time}})")
 	return cmd
 }
 
@@ -67,23 +101,53 @@ func (opts *serviceReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errorWantedNoArgs
 	}
 
-	if err := checkExactlyOne("--update-image=<image>, --update-all-images, or --no-update", opts.image != "", opts.allImages, opts.noUpdate); err != nil {
+	if opts.template != "" {
+		return opts.releaseFromTemplate(cmd)
+	}
+
+	var values []flux.ValueUpdate
+	for _, s := range opts.setEnv {
+		v, err := flux.ParseValueUpdate(flux.ValueKindEnv, s)
+		if err != nil {
+			return newUsageError(err.Error())
+		}
+		values = append(values, v)
+	}
+	for _, s := range opts.setConfig {
+		v, err := flux.ParseValueUpdate(flux.ValueKindConfigMap, s)
+		if err != nil {
+			return newUsageError(err.Error())
+		}
+		values = append(values, v)
+	}
+
+	// Setting values without updating images is fine; it's the same
+	// as --no-update.
+	noUpdate := opts.noUpdate || (len(values) > 0 && opts.image == "" && !opts.allImages)
+	if err := checkExactlyOne("--update-image=<image>, --update-all-images, or --no-update", opts.image != "", opts.allImages, noUpdate); err != nil {
 		return err
 	}
 
-	if len(opts.services) <= 0 && !opts.allServices {
-		return newUsageError("please supply either --all, or at least one --service=<service>")
+	if len(opts.services) <= 0 && !opts.allServices && !opts.allAutomated {
+		return newUsageError("please supply either --all, --all-automated, or at least one --service=<service>")
+	}
+	if opts.allServices && opts.allAutomated {
+		return newUsageError("please supply only one of --all and --all-automated")
 	}
 
 	var services []flux.ServiceSpec
 	if opts.allServices {
 		services = []flux.ServiceSpec{flux.ServiceSpecAll}
 	} else {
-		for _, service := range opts.services {
-			if _, err := flux.ParseServiceID(service); err != nil {
+		if opts.allAutomated {
+			services = append(services, flux.ServiceSpecAutomated)
+		}
+		for _, service := range joinSelectorTerms(opts.services) {
+			spec, err := flux.ParseServiceSpec(service)
+			if err != nil {
 				return err
 			}
-			services = append(services, flux.ServiceSpec(service))
+			services = append(services, spec)
 		}
 	}
 
@@ -99,13 +163,24 @@ func (opts *serviceReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
 		}
 	case opts.allImages:
 		image = flux.ImageSpecLatest
-	case opts.noUpdate:
+	case noUpdate:
 		image = flux.ImageSpecNone
 	}
 
 	var kind flux.ReleaseKind = flux.ReleaseKindExecute
-	if opts.dryRun {
+	switch {
+	case opts.dryRun && opts.atomic:
+		return newUsageError("--dry-run and --atomic cannot be used together")
+	case opts.blueGreen && (opts.dryRun || opts.atomic):
+		return newUsageError("--blue-green cannot be used with --dry-run or --atomic")
+	case opts.watch && (opts.dryRun || opts.validate || opts.noFollow):
+		return newUsageError("--watch cannot be used with --dry-run, --validate or --no-follow")
+	case opts.dryRun:
 		kind = flux.ReleaseKindPlan
+	case opts.atomic:
+		kind = flux.ReleaseKindAtomic
+	case opts.blueGreen:
+		kind = flux.ReleaseKindBlueGreen
 	}
 
 	var excludes []flux.ServiceID
@@ -117,19 +192,52 @@ func (opts *serviceReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
 		excludes = append(excludes, s)
 	}
 
+	spec := flux.ReleaseSpec{
+		ServiceSpecs: services,
+		ImageSpec:    image,
+		Kind:         kind,
+		Excludes:     excludes,
+		ValueUpdates: values,
+	}
+
+	if opts.saveTemplate != "" {
+		if opts.validate || opts.watch {
+			return newUsageError("--save-template cannot be used with --validate or --watch")
+		}
+		if err := opts.API.SetReleaseTemplate(context.Background(), noInstanceID, flux.ReleaseTemplate{
+			Name:    opts.saveTemplate,
+			Spec:    spec,
+			Message: opts.message,
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Release template %s saved; release from it with\n\n\tfluxctl release --template=%s\n\n", opts.saveTemplate, opts.saveTemplate)
+		return nil
+	}
+
+	if opts.validate {
+		problems, err := opts.API.ValidateRelease(context.Background(), noInstanceID, spec)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(cmd.OutOrStdout(), problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problem(s) with the release", len(problems))
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "No problems found.")
+		return nil
+	}
+
 	if opts.dryRun {
 		fmt.Fprintf(cmd.OutOrStdout(), "Submitting dry-run release job...\n")
 	} else {
 		fmt.Fprintf(cmd.OutOrStdout(), "Submitting release job...\n")
 	}
 
-	id, err := opts.API.PostRelease(noInstanceID, jobs.ReleaseJobParams{
-		ReleaseSpec: flux.ReleaseSpec{
-			ServiceSpecs: services,
-			ImageSpec:    image,
-			Kind:         kind,
-			Excludes:     excludes,
-		},
+	id, err := opts.API.PostRelease(context.Background(), noInstanceID, jobs.ReleaseJobParams{
+		ReleaseSpec: spec,
 		Cause: flux.ReleaseCause{
 			User:    opts.user,
 			Message: opts.message,
@@ -139,6 +247,31 @@ func (opts *serviceReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	return opts.follow(cmd, id)
+}
+
+// releaseFromTemplate submits a release from the template given, which
+// has everything but the user.
+func (opts *serviceReleaseOpts) releaseFromTemplate(cmd *cobra.Command) error {
+	if len(opts.services) > 0 || opts.allServices || opts.allAutomated || opts.image != "" || opts.allImages || opts.noUpdate ||
+		len(opts.setEnv) > 0 || len(opts.setConfig) > 0 || len(opts.exclude) > 0 || opts.dryRun || opts.atomic || opts.blueGreen ||
+		opts.validate || opts.message != "" || opts.saveTemplate != "" {
+		return newUsageError("--template cannot be used with flags that say what to release; they are in the template")
+	}
+	if opts.watch && opts.noFollow {
+		return newUsageError("--watch cannot be used with --no-follow")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Submitting release job from template %s...\n", opts.template)
+	id, err := opts.API.PostReleaseFromTemplate(context.Background(), noInstanceID, opts.template, opts.user)
+	if err != nil {
+		return err
+	}
+	return opts.follow(cmd, id)
+}
+
+// follow reports on the release job submitted, and, if asked, watches
+// the rollout of the services it released.
+func (opts *serviceReleaseOpts) follow(cmd *cobra.Command, id jobs.JobID) error {
 	fmt.Fprintf(cmd.OutOrStdout(), "Release job submitted, ID %s\n", id)
 	if opts.noFollow {
 		fmt.Fprintf(cmd.OutOrStdout(), "To check the status of this release job, run\n")
@@ -149,9 +282,97 @@ func (opts *serviceReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
 	}
 
 	// This is a bit funny, but works.
-	return (&serviceCheckReleaseOpts{
+	err := (&serviceCheckReleaseOpts{
 		serviceOpts:              opts.serviceOpts,
 		releaseID:                string(id),
 		serviceReleaseOutputOpts: opts.serviceReleaseOutputOpts,
 	}).RunE(cmd, nil)
+	if err != nil || !opts.watch {
+		return err
+	}
+
+	job, err := opts.API.GetRelease(context.Background(), noInstanceID, id, 0)
+	if err != nil {
+		return err
+	}
+	result, _ := job.Result.(flux.ReleaseResult)
+	var released []flux.ServiceID
+	for _, id := range result.ServiceIDs() {
+		if result[flux.ServiceID(id)].Status == flux.ReleaseStatusSuccess {
+			released = append(released, flux.ServiceID(id))
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\nWatching the rollout:\n")
+	return opts.watchRollout(cmd, released)
+}
+
+// rolloutTimeout is how long --watch waits for the services released
+// to be ready.
+const rolloutTimeout = 5 * time.Minute
+
+// The statuses of services --watch looks for, as the platform gives
+// them. They're copied, rather than imported, so that fluxctl doesn't
+// depend on the Kubernetes client for two strings.
+const (
+	serviceStatusUnknown = "unknown"
+	serviceStatusReady   = "ready"
+)
+
+// watchRollout shows the status of each of the services given, as it
+// changes, until they are all ready. With a terminal, the statuses are
+// updated in place; otherwise, each change is printed.
+func (opts *serviceReleaseOpts) watchRollout(cmd *cobra.Command, services []flux.ServiceID) error {
+	var (
+		w    io.Writer = cmd.OutOrStdout()
+		live           = !opts.noTty && isatty.IsTerminal(os.Stdout.Fd())
+		stop           = func() {}
+	)
+	if live {
+		liveWriter := uilive.New()
+		liveWriter.Out = cmd.OutOrStdout()
+		liveWriter.Start()
+		w, stop = liveWriter, liveWriter.Stop
+	}
+	defer stop()
+
+	prev := map[flux.ServiceID]string{}
+	deadline := time.Now().Add(rolloutTimeout)
+	for {
+		statuses, err := opts.API.ListServices(context.Background(), noInstanceID, "")
+		if err != nil {
+			return err
+		}
+		current := map[flux.ServiceID]string{}
+		for _, s := range statuses {
+			current[s.ID] = s.Status
+		}
+
+		ready := true
+		tw := newTabwriter(w)
+		for _, id := range services {
+			status, ok := current[id]
+			if !ok {
+				status = serviceStatusUnknown
+			}
+			if status != serviceStatusReady {
+				ready = false
+			}
+			if live || status != prev[id] {
+				fmt.Fprintf(tw, "%s\t%s\n", id, status)
+			}
+		}
+		tw.Flush()
+		prev = current
+
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("services not ready after %s; check them with fluxctl list-services", rolloutTimeout)
+		}
+		time.Sleep(time.Second)
+	}
 }
@@ -1,6 +1,8 @@
 package main //+integration
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -46,6 +48,26 @@ func TestReleaseCommand_CLIConversion(t *testing.T) {
 			"kind":    string(flux.ReleaseKindExecute),
 			"exclude": "default/test,default/yeah",
 		}},
+		{[]string{"--update-all-images", "--service=default/flux,default/helloworld", "--atomic"}, map[string]string{
+			"service": "default/flux,default/helloworld",
+			"image":   string(flux.ImageSpecLatest),
+			"kind":    string(flux.ReleaseKindAtomic),
+		}},
+		{[]string{"--update-all-images", "--service=selector:tier=web,app=frontend,default/flux"}, map[string]string{
+			"service": "selector:app=frontend,tier=web,default/flux",
+			"image":   string(flux.ImageSpecLatest),
+			"kind":    string(flux.ReleaseKindExecute),
+		}},
+		{[]string{"--service=default/flux", "--set-env=LOG_LEVEL=debug"}, map[string]string{
+			"service": "default/flux",
+			"image":   string(flux.ImageSpecNone),
+			"set-env": "LOG_LEVEL=debug",
+		}},
+		{[]string{"--service=default/flux", "--set-env=JAVA_OPTS=-Xmx1g,-Xms1g"}, map[string]string{
+			"service": "default/flux",
+			"image":   string(flux.ImageSpecNone),
+			"set-env": "JAVA_OPTS=-Xmx1g,-Xms1g",
+		}},
 	} {
 		svc := testArgs(t, v.args, false, "")
 
@@ -77,6 +99,86 @@ func TestReleaseCommand_NoFollow(t *testing.T) {
 
 }
 
+func TestReleaseCommand_Watch(t *testing.T) {
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("PostRelease"): transport.PostReleaseResponse{
+				Status:    "ok",
+				ReleaseID: "1",
+			},
+			transport.NewRouter().Get("GetRelease"): jobs.Job{
+				Done:    true,
+				Success: true,
+				ID:      "1",
+				Params: jobs.ReleaseJobParams{
+					ReleaseSpec: flux.ReleaseSpec{
+						Kind: flux.ReleaseKindExecute,
+					},
+				},
+				Method: jobs.ReleaseJob,
+				Result: flux.ReleaseResult{
+					"default/foo": {Status: flux.ReleaseStatusSuccess},
+					"default/bar": {Status: flux.ReleaseStatusSkipped},
+				},
+			},
+			transport.NewRouter().Get("ListServices"): []flux.ServiceStatus{
+				{ID: "default/foo", Status: "ready"},
+				{ID: "default/bar", Status: "updating"},
+			},
+		},
+	}
+	cmd := newServiceRelease(mockServiceOpts(svc)).Command()
+	out := &bytes.Buffer{}
+	cmd.SetOutput(out)
+	cmd.SetArgs([]string{"--all", "--update-all-images", "--no-tty", "--watch"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if calledURL("ListServices", svc.requestHistory) == nil {
+		t.Fatalf("Expecting fluxctl to request \"ListServices\", but did not. Output:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "default/foo  ready") || strings.Contains(out.String(), "default/bar  updating") {
+		t.Errorf("expected only the released service to be watched, got:\n%s", out.String())
+	}
+}
+
+func TestReleaseCommand_Validate(t *testing.T) {
+	svc := testArgs(t, []string{"--update-all-images", "--all", "--validate"}, false, "")
+	if calledURL("ValidateRelease", svc.requestHistory) == nil {
+		t.Fatal("Expecting fluxctl to request \"ValidateRelease\", but did not.")
+	}
+	for _, method := range []string{"PostRelease", "GetRelease"} {
+		if calledURL(method, svc.requestHistory) != nil {
+			t.Fatalf("Only validating so shouldn't have called %q", method)
+		}
+	}
+}
+
+func TestReleaseCommand_Template(t *testing.T) {
+	svc := testArgs(t, []string{"--template=frontend", "--user=alice"}, false, "")
+	// The path variables aren't kept by the mock, so look for the
+	// route rather than the URL
+	if calledRequest("PostReleaseFromTemplate", svc.requestHistory).Route == nil {
+		t.Fatal("Expecting fluxctl to request \"PostReleaseFromTemplate\", but did not.")
+	}
+	assertString(t, "alice", calledRequest("PostReleaseFromTemplate", svc.requestHistory).Vars["user"])
+	if calledURL("GetRelease", svc.requestHistory) == nil {
+		t.Fatal("Expecting fluxctl to request \"GetRelease\", but did not.")
+	}
+}
+
+func TestReleaseCommand_SaveTemplate(t *testing.T) {
+	svc := testArgs(t, []string{"--update-all-images", "--service=selector:tier=frontend", "--save-template=frontend"}, false, "")
+	if calledURL("SetReleaseTemplate", svc.requestHistory) == nil {
+		t.Fatal("Expecting fluxctl to request \"SetReleaseTemplate\", but did not.")
+	}
+	for _, method := range []string{"PostRelease", "GetRelease"} {
+		if calledURL(method, svc.requestHistory) != nil {
+			t.Fatalf("Only saving a template so shouldn't have called %q", method)
+		}
+	}
+}
+
 func TestReleaseCommand_InputFailures(t *testing.T) {
 	for _, v := range []struct {
 		args []string
@@ -88,6 +190,10 @@ func TestReleaseCommand_InputFailures(t *testing.T) {
 		{[]string{"--update-all-images"}, "Should error when not specifying service spec"},
 		{[]string{"--service=invalid&service", "--update-all-images"}, "Should error with invalid service"},
 		{[]string{"subcommand"}, "Should error when given subcommand"},
+		{[]string{"--all", "--update-all-images", "--dry-run", "--atomic"}, "Should error when asked for a dry run and an atomic release"},
+		{[]string{"--all", "--update-all-images", "--dry-run", "--watch"}, "Should error when asked to watch a dry run"},
+		{[]string{"--template=frontend", "--all"}, "Should error when given a template and what to release"},
+		{[]string{"--all", "--update-all-images", "--save-template=frontend", "--validate"}, "Should error when asked to save a template and validate"},
 	} {
 		testArgs(t, v.args, true, v.msg)
 	}
@@ -102,6 +208,12 @@ func newMockService() *genericMockRoundTripper {
 				Status:    "ok",
 				ReleaseID: "1",
 			},
+			transport.NewRouter().Get("ValidateRelease"): []flux.ReleaseProblem{},
+			transport.NewRouter().Get("PostReleaseFromTemplate"): transport.PostReleaseResponse{
+				Status:    "ok",
+				ReleaseID: "1",
+			},
+			transport.NewRouter().Get("SetReleaseTemplate"): nil,
 			transport.NewRouter().Get("GetRelease"): jobs.Job{
 				Done: true,
 				ID:   "1",
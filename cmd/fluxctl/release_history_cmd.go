@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+)
+
+type serviceReleaseHistoryOpts struct {
+	*serviceOpts
+	service string
+}
+
+func newServiceReleaseHistory(parent *serviceOpts) *serviceReleaseHistoryOpts {
+	return &serviceReleaseHistoryOpts{serviceOpts: parent}
+}
+
+func (opts *serviceReleaseHistoryOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "release-history",
+		Short: "Show the images a service has run, and how long each was live",
+		Example: makeExample(
+			"fluxctl release-history --service=default/foo",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service for which to show release history")
+	return cmd
+}
+
+func (opts *serviceReleaseHistoryOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.service == "" {
+		return newUsageError("-s, --service is required")
+	}
+
+	service, err := flux.ParseServiceID(opts.service)
+	if err != nil {
+		return err
+	}
+
+	versions, err := opts.API.ReleaseHistory(context.Background(), noInstanceID, service)
+	if err != nil {
+		return err
+	}
+
+	out := newTabwriter(cmd.OutOrStdout())
+
+	fmt.Fprintln(out, "SINCE\tCONTAINER\tIMAGE\tLIVE FOR\tRELEASE\tCOMMIT\tUSER")
+	for _, v := range versions {
+		until, current := time.Now(), " (current)"
+		if v.Until != nil {
+			until, current = *v.Until, ""
+		}
+		live := (until.Sub(v.Since) / time.Second * time.Second).String() + current
+		revision := v.Revision
+		if len(revision) > 7 {
+			revision = revision[:7]
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Since.Format(time.RFC822), v.Container, v.Image, live, v.ReleaseID, revision, v.User)
+	}
+
+	out.Flush()
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+)
+
+type listReleaseTemplatesOpts struct {
+	*rootOpts
+}
+
+func newListReleaseTemplates(parent *rootOpts) *listReleaseTemplatesOpts {
+	return &listReleaseTemplatesOpts{rootOpts: parent}
+}
+
+func (opts *listReleaseTemplatesOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list-release-templates",
+		Short: "List the release templates saved with `fluxctl release --save-template`.",
+		RunE:  opts.RunE,
+	}
+	return cmd
+}
+
+func (opts *listReleaseTemplatesOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	templates, err := opts.API.ListReleaseTemplates(context.Background(), noInstanceID)
+	if err != nil {
+		return err
+	}
+	out := newTabwriter(cmd.OutOrStdout())
+	fmt.Fprintln(out, "NAME\tSERVICES\tIMAGE\tKIND\tMESSAGE")
+	for _, t := range templates {
+		services := make([]string, len(t.Spec.ServiceSpecs))
+		for i, s := range t.Spec.ServiceSpecs {
+			services[i] = string(s)
+		}
+		for _, ex := range t.Spec.Excludes {
+			services = append(services, "-"+string(ex))
+		}
+		image := string(t.Spec.ImageSpec)
+		if t.Spec.ImageSpec == flux.ImageSpecNone {
+			image = "(no update)"
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", t.Name, strings.Join(services, ","), image, t.Spec.Kind, t.Message)
+	}
+	out.Flush()
+	return nil
+}
+
+type deleteReleaseTemplateOpts struct {
+	*rootOpts
+	name string
+}
+
+func newDeleteReleaseTemplate(parent *rootOpts) *deleteReleaseTemplateOpts {
+	return &deleteReleaseTemplateOpts{rootOpts: parent}
+}
+
+func (opts *deleteReleaseTemplateOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete-release-template",
+		Short: "Delete a release template.",
+		Example: makeExample(
+			"fluxctl delete-release-template --name=frontend",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVar(&opts.name, "name", "", "name of the release template to delete")
+	return cmd
+}
+
+func (opts *deleteReleaseTemplateOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.name == "" {
+		return newUsageError("please supply the name of the template with --name")
+	}
+	if err := opts.API.DeleteReleaseTemplate(context.Background(), noInstanceID, opts.name); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Release template %s deleted.\n", opts.name)
+	return nil
+}
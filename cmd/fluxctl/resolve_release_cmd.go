@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/jobs"
+)
+
+type serviceResolveReleaseOpts struct {
+	*serviceOpts
+	releaseID string
+	retry     bool
+	abandon   bool
+	serviceReleaseOutputOpts
+}
+
+func newServiceResolveRelease(parent *serviceOpts) *serviceResolveReleaseOpts {
+	return &serviceResolveReleaseOpts{serviceOpts: parent}
+}
+
+func (opts *serviceResolveReleaseOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resolve-release",
+		Short: "Retry or abandon a release that failed, e.g., because it conflicted with changes in git.",
+		Example: makeExample(
+			"fluxctl resolve-release --release-id=12345678-1234-5678-1234-567812345678 --retry",
+			"fluxctl resolve-release --release-id=12345678-1234-5678-1234-567812345678 --abandon",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.releaseID, "release-id", "r", "", "release ID to resolve")
+	cmd.Flags().BoolVar(&opts.retry, "retry", false, "run the release again, on top of what's in git now")
+	cmd.Flags().BoolVar(&opts.abandon, "abandon", false, "give up on the release")
+	cmd.Flags().BoolVar(&opts.noFollow, "no-follow", false, "with --retry, just submit the release job, don't wait for it")
+	cmd.Flags().BoolVar(&opts.noTty, "no-tty", false, "forces simpler, non-TTY status output")
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "include ignored services in output")
+	return cmd
+}
+
+func (opts *serviceResolveReleaseOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if opts.releaseID == "" {
+		return newUsageError("-r, --release-id is required")
+	}
+	if opts.retry == opts.abandon {
+		return newUsageError("please supply one of --retry and --abandon")
+	}
+
+	id := jobs.JobID(opts.releaseID)
+	if opts.abandon {
+		if err := opts.API.AbandonJob(context.Background(), noInstanceID, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Release %s abandoned.\n", id)
+		return nil
+	}
+
+	if err := opts.API.RequeueJob(context.Background(), noInstanceID, id); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Release %s requeued.\n", id)
+	if opts.noFollow {
+		return nil
+	}
+	return (&serviceCheckReleaseOpts{
+		serviceOpts:              opts.serviceOpts,
+		releaseID:                opts.releaseID,
+		serviceReleaseOutputOpts: opts.serviceReleaseOutputOpts,
+	}).RunE(cmd, nil)
+}
+
+// printConflict shows the files (and lines in them) in which a release's
+// changes conflict with those in git, and how to carry on.
+func printConflict(out io.Writer, id string, conflict *flux.Conflict) {
+	fmt.Fprintf(out, "\nThe release's changes conflict with changes made in git meanwhile:\n")
+	for _, file := range conflict.Files {
+		fmt.Fprintf(out, "\n%s:\n", file.Path)
+		for _, hunk := range file.Hunks {
+			fmt.Fprintf(out, "%s", hunk)
+		}
+	}
+	fmt.Fprintf(out, "\nOnce the files in git are as they should be, run the release again with\n")
+	fmt.Fprintf(out, "\n\tfluxctl resolve-release --release-id=%s --retry\n", id)
+	fmt.Fprintf(out, "\nor give up on it with\n")
+	fmt.Fprintf(out, "\n\tfluxctl resolve-release --release-id=%s --abandon\n\n", id)
+}
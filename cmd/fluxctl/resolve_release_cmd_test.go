@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestResolveReleaseCommand(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		fails bool
+		calls []string
+	}{
+		{[]string{"--release-id=1", "--retry", "--no-follow"}, false, []string{"RequeueJob"}},
+		{[]string{"--release-id=1", "--abandon"}, false, []string{"AbandonJob"}},
+		{[]string{"--release-id=1"}, true, nil},
+		{[]string{"--release-id=1", "--retry", "--abandon"}, true, nil},
+		{[]string{"--abandon"}, true, nil},
+	} {
+		svc := &genericMockRoundTripper{
+			mockResponses: map[*mux.Route]interface{}{
+				transport.NewRouter().Get("RequeueJob"): nil,
+				transport.NewRouter().Get("AbandonJob"): nil,
+			},
+		}
+		cmd := newServiceResolveRelease(mockServiceOpts(svc)).Command()
+		cmd.SetOutput(ioutil.Discard)
+		cmd.SetArgs(c.args)
+
+		if err := cmd.Execute(); (err != nil) != c.fails {
+			t.Errorf("%v: expected failure=%v, got %v", c.args, c.fails, err)
+		}
+		var calls []string
+		for _, r := range svc.requestHistory {
+			calls = append(calls, r.Route.GetName())
+			if id := r.Vars["id"]; id != "1" {
+				t.Errorf("%v: expected release ID 1, got %q", c.args, id)
+			}
+		}
+		if strings.Join(calls, ",") != strings.Join(c.calls, ",") {
+			t.Errorf("%v: expected calls %v, got %v", c.args, c.calls, calls)
+		}
+	}
+}
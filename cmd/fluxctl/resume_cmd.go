@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+
+	"github.com/spf13/cobra"
+)
+
+type resumeOpts struct {
+	*rootOpts
+	user string
+}
+
+func newResume(parent *rootOpts) *resumeOpts {
+	return &resumeOpts{rootOpts: parent}
+}
+
+func (opts *resumeOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Carry on with automated releases and syncs, after a pause.",
+		Example: makeExample(
+			"fluxctl resume",
+		),
+		RunE: opts.RunE,
+	}
+
+	username := ""
+	if user, err := user.Current(); err == nil {
+		username = user.Username
+	}
+
+	cmd.Flags().StringVar(&opts.user, "user", username, "override the user reported as resuming automation")
+	return cmd
+}
+
+func (opts *resumeOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if err := opts.API.Resume(context.Background(), noInstanceID, opts.user); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Automation resumed.")
+	return nil
+}
@@ -18,9 +18,13 @@ import (
 )
 
 type rootOpts struct {
-	URL   string
-	Token string
-	API   api.ClientService
+	URL        string
+	Token      string
+	APIVersion string
+	K8sFwd     bool
+	API        api.ClientService
+
+	stopForward func() // if port-forwarding, stops it
 }
 
 // fluxctl never sends an instance ID directly; it's always blank, and
@@ -64,6 +68,10 @@ func (opts *rootOpts) Command() *cobra.Command {
 		fmt.Sprintf("base URL of the flux service; you can also set the environment variable %s", envVariableURL))
 	cmd.PersistentFlags().StringVarP(&opts.Token, "token", "t", "",
 		fmt.Sprintf("Weave Cloud service token; you can also set the environment variable %s", envVariableToken))
+	cmd.PersistentFlags().BoolVar(&opts.K8sFwd, "k8s-fwd", false,
+		fmt.Sprintf("find the flux API in the current kubectl context (a service annotated with %s), and port-forward to it", fluxAPIAnnotation))
+	cmd.PersistentFlags().StringVar(&opts.APIVersion, "api-version", "",
+		fmt.Sprintf("version of the flux service API to use (e.g., %q), for talking to older services; defaults to the latest", transport.APIVersions[0]))
 
 	svcopts := newService(opts)
 
@@ -74,14 +82,26 @@ func (opts *rootOpts) Command() *cobra.Command {
 		newServiceList(svcopts).Command(),
 		newServiceRelease(svcopts).Command(),
 		newServiceCheckRelease(svcopts).Command(),
+		newServiceResolveRelease(svcopts).Command(),
+		newListReleaseTemplates(opts).Command(),
+		newDeleteReleaseTemplate(opts).Command(),
 		newServiceHistory(svcopts).Command(),
+		newServiceReleaseHistory(svcopts).Command(),
+		newServiceBlame(svcopts).Command(),
+		newLint(opts).Command(),
+		newLogs(opts).Command(),
 		newServiceAutomate(svcopts).Command(),
 		newServiceDeautomate(svcopts).Command(),
 		newServiceLock(svcopts).Command(),
 		newServiceUnlock(svcopts).Command(),
+		newServiceThrottle(svcopts).Command(),
+		newPause(opts).Command(),
+		newResume(opts).Command(),
 		newGetConfig(opts).Command(),
 		newSetConfig(opts).Command(),
+		newEditConfig(opts).Command(),
 		newSave(opts).Command(),
+		newTrace(opts).Command(),
 	)
 
 	return cmd
@@ -93,10 +113,51 @@ func (opts *rootOpts) PersistentPreRunE(cmd *cobra.Command, _ []string) error {
 		return errors.Wrapf(err, "parsing URL")
 	}
 	opts.Token = getFromEnvIfNotSet(cmd.Flags(), "token", envVariableToken, opts.Token)
-	opts.API = client.New(http.DefaultClient, transport.NewRouter(), opts.URL, flux.Token(opts.Token))
+
+	if opts.K8sFwd && cmd.Name() != "version" {
+		if cmd.Flags().Changed("url") {
+			return newUsageError("please supply only one of --url and --k8s-fwd")
+		}
+		u, stop, err := portForward()
+		if err != nil {
+			return errors.Wrap(err, "port-forwarding to flux API")
+		}
+		opts.URL, opts.stopForward = u, stop
+	}
+
+	router := transport.NewRouter()
+	if opts.APIVersion == "" {
+		opts.API = client.New(http.DefaultClient, router, opts.URL, flux.Token(opts.Token))
+	} else {
+		c, err := client.NewWithAPIVersion(http.DefaultClient, router, opts.URL, flux.Token(opts.Token), opts.APIVersion)
+		if err != nil {
+			return newUsageError(err.Error())
+		}
+		opts.API = c
+	}
+
+	// The version command doesn't talk to the service, so there's no
+	// need to check it. Otherwise, a failure here will show up again
+	// when the command runs, so ignore it.
+	if cmd.Name() != "version" {
+		wanted := opts.APIVersion
+		if wanted == "" {
+			wanted = transport.APIVersions[len(transport.APIVersions)-1]
+		}
+		if supported, err := client.APIVersions(http.DefaultClient, router, opts.URL, flux.Token(opts.Token)); err == nil {
+			fmt.Fprint(cmd.OutOrStderr(), apiVersionWarning(supported, wanted))
+		}
+	}
 	return nil
 }
 
+// Close stops port-forwarding, if it was started.
+func (opts *rootOpts) Close() {
+	if opts.stopForward != nil {
+		opts.stopForward()
+	}
+}
+
 func getFromEnvIfNotSet(flags *pflag.FlagSet, flagName, envName, value string) string {
 	if flags.Changed(flagName) {
 		return value
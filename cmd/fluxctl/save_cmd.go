@@ -1,21 +1,29 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux/layout"
 )
 
 type saveOpts struct {
 	*rootOpts
-	path string
+	path   string
+	layout string
 }
 
 func newSave(parent *rootOpts) *saveOpts {
@@ -28,10 +36,13 @@ func (opts *saveOpts) Command() *cobra.Command {
 		Short: "save service definitions to local files in platform-native format",
 		Example: makeExample(
 			"fluxctl save",
+			"fluxctl save --out config/",
+			"fluxctl save --out config.tar.gz",
 		),
 		RunE: opts.RunE,
 	}
-	cmd.Flags().StringVarP(&opts.path, "out", "o", "-", "output path for exported config; the default. '-' indicates stdout; if a directory is given, each item will be saved in a file under the directory")
+	cmd.Flags().StringVarP(&opts.path, "out", "o", "-", "output path for exported config; the default. '-' indicates stdout; if a directory is given, each item will be saved in a file under the directory; if a file ending .tar.gz or .tgz is given, each item will be saved in a file in that archive")
+	cmd.Flags().StringVar(&opts.layout, "layout", layout.Namespaces, fmt.Sprintf("how to lay out the files in a directory or archive; %s (a directory for each namespace) or %s (all at the top)", layout.Namespaces, layout.Flat))
 	return cmd
 }
 
@@ -54,16 +65,35 @@ func (opts *saveOpts) RunE(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		return errorWantedNoArgs
 	}
-
-	config, err := opts.API.Export(noInstanceID)
+	repoLayout, err := layout.Get(opts.layout)
 	if err != nil {
-		return errors.Wrap(err, "exporting config")
+		return err
 	}
 
-	yamls := bufio.NewScanner(bytes.NewReader(config))
-	yamls.Split(splitYAMLDocument)
-
-	if opts.path != "-" {
+	var (
+		archive       *tar.Writer
+		finishArchive func() error
+	)
+	switch {
+	case opts.path == "-":
+	case isArchive(opts.path):
+		file, err := os.Create(opts.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		gz := gzip.NewWriter(file)
+		archive = tar.NewWriter(gz)
+		finishArchive = func() error {
+			if err := archive.Close(); err != nil {
+				return err
+			}
+			if err := gz.Close(); err != nil {
+				return err
+			}
+			return file.Close()
+		}
+	default:
 		// check supplied path is a directory
 		if info, err := os.Stat(opts.path); err != nil {
 			return err
@@ -72,6 +102,22 @@ func (opts *saveOpts) RunE(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Each item is saved as it arrives, rather than once the whole
+	// export has
+	exported, w := io.Pipe()
+	defer exported.Close()
+	exportErr := make(chan error, 1)
+	go func() {
+		err := opts.API.ExportTo(context.Background(), noInstanceID, w)
+		// The error is there to be had by the time the reading
+		// side sees the pipe close
+		exportErr <- err
+		w.CloseWithError(err)
+	}()
+
+	yamls := bufio.NewScanner(exported)
+	yamls.Split(splitYAMLDocument)
+
 	for yamls.Scan() {
 		var object saveObject
 		// Most unwanted fields are ignored at this point
@@ -83,18 +129,44 @@ func (opts *saveOpts) RunE(cmd *cobra.Command, args []string) error {
 		// e.g. .Spec and .Metadata.Annotations
 		filterObject(object)
 
-		if err := saveYAML(cmd.OutOrStdout(), object, opts.path); err != nil {
+		path := repoLayout.Path(layout.Resource{
+			Namespace: object.Metadata.Namespace,
+			Kind:      object.Kind,
+			Name:      object.Metadata.Name,
+		})
+		if err := saveYAML(cmd.OutOrStdout(), object, path, opts.path, archive); err != nil {
 			return errors.Wrap(err, "saving yaml object")
 		}
 	}
 
 	if yamls.Err() != nil {
+		select {
+		case err := <-exportErr:
+			if err != nil {
+				return errors.Wrap(err, "exporting config")
+			}
+		default:
+			// The export is still going; it's stopped when the
+			// pipe is closed
+		}
 		return errors.Wrap(yamls.Err(), "splitting exported yaml")
 	}
+	if err := <-exportErr; err != nil {
+		return errors.Wrap(err, "exporting config")
+	}
 
+	if finishArchive != nil {
+		if err := finishArchive(); err != nil {
+			return errors.Wrap(err, "writing archive")
+		}
+	}
 	return nil
 }
 
+func isArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
 // Remove any data that should not be version controlled
 func filterObject(object saveObject) {
 	delete(object.Metadata.Annotations, "deployment.kubernetes.io/revision")
@@ -145,18 +217,11 @@ func deleteEmptyMapValues(i interface{}) bool {
 	return false
 }
 
-func outputFile(stdout io.Writer, object saveObject, out string) (string, error) {
-	var path string
-	if object.Kind == "Namespace" {
-		path = fmt.Sprintf("%s-ns.yaml", object.Metadata.Name)
-	} else {
-		dir := object.Metadata.Namespace
+func outputFile(stdout io.Writer, object saveObject, path, out string) (string, error) {
+	if dir := filepath.Dir(path); dir != "." {
 		if err := os.MkdirAll(filepath.Join(out, dir), os.ModePerm); err != nil {
 			return "", errors.Wrap(err, "making directory for namespace")
 		}
-
-		shortKind := abbreviateKind(object.Kind)
-		path = filepath.Join(dir, fmt.Sprintf("%s-%s.yaml", object.Metadata.Name, shortKind))
 	}
 
 	path = filepath.Join(out, path)
@@ -164,8 +229,9 @@ func outputFile(stdout io.Writer, object saveObject, out string) (string, error)
 	return path, nil
 }
 
-// Save YAML to directory structure
-func saveYAML(stdout io.Writer, object saveObject, out string) error {
+// Save YAML to directory structure, or to the archive if there is
+// one, at the path given (relative to the top of either)
+func saveYAML(stdout io.Writer, object saveObject, path, out string, archive *tar.Writer) error {
 	buf, err := yaml.Marshal(object)
 	if err != nil {
 		return errors.Wrap(err, "marshalling yaml")
@@ -178,8 +244,28 @@ func saveYAML(stdout io.Writer, object saveObject, out string) error {
 		return nil
 	}
 
+	// to an archive
+	if archive != nil {
+		path = filepath.ToSlash(path)
+		fmt.Fprintf(stdout, "Saving %s '%s' to %s in %s\n", object.Kind, object.Metadata.Name, path, out)
+		content := append([]byte("---\n"), buf...)
+		header := &tar.Header{
+			Name:    path,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return errors.Wrap(err, "writing archive")
+		}
+		if _, err := archive.Write(content); err != nil {
+			return errors.Wrap(err, "writing archive")
+		}
+		return nil
+	}
+
 	// to a directory
-	path, err := outputFile(stdout, object, out)
+	path, err = outputFile(stdout, object, path, out)
 	if err != nil {
 		return err
 	}
@@ -200,19 +286,6 @@ func saveYAML(stdout io.Writer, object saveObject, out string) error {
 	return nil
 }
 
-func abbreviateKind(kind string) string {
-	switch kind {
-	case "Service":
-		return "svc"
-	case "ReplicationController":
-		return "rc"
-	case "Deployment":
-		return "dep"
-	default:
-		return kind
-	}
-}
-
 // Copied from k8s.io/client-go/1.5/pkg/util/yaml/decoder.go
 
 const yamlSeparator = "\n---"
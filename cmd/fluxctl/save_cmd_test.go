@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+const exportedConfig = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+  namespace: default
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+spec:
+  replicas: 2
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: helloworld
+  namespace: default
+spec:
+  ports:
+  - port: 80
+`
+
+func TestSaveArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxctl-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.tar.gz")
+
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("ExportStream"): rawResponse(exportedConfig),
+		},
+	}
+	cmd := newSave(mockServiceOpts(svc).rootOpts).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--out", path})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(content)
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"default-ns.yaml", "default/helloworld-dep.yaml", "default/helloworld-svc.yaml"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected files %v, got %v", expected, names)
+	}
+	dep := files["default/helloworld-dep.yaml"]
+	if dep != "---\napiVersion: extensions/v1beta1\nkind: Deployment\nmetadata:\n  name: helloworld\n  namespace: default\nspec:\n  replicas: 2\n" {
+		t.Errorf("unexpected deployment file:\n%s", dep)
+	}
+}
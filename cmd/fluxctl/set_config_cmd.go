@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 
 	"github.com/pkg/errors"
@@ -12,8 +14,9 @@ import (
 
 type setConfigOpts struct {
 	*rootOpts
-	file string
-	key  bool
+	file         string
+	key          bool
+	validateOnly bool
 }
 
 func newSetConfig(parent *rootOpts) *setConfigOpts {
@@ -26,11 +29,13 @@ func (opts *setConfigOpts) Command() *cobra.Command {
 		Short: "set configuration values for an instance",
 		Example: makeExample(
 			"fluxctl set-config --file=./dev/flux-conf.yaml --generate-deploy-key",
+			"fluxctl set-config --file=./dev/flux-conf.yaml --validate-only",
 		),
 		RunE: opts.RunE,
 	}
 	cmd.Flags().StringVarP(&opts.file, "file", "f", "", "A file to upload as configuration; this will overwrite all values.")
 	cmd.Flags().BoolVarP(&opts.key, "generate-deploy-key", "k", false, "Generate and replace Git deploy key")
+	cmd.Flags().BoolVar(&opts.validateOnly, "validate-only", false, "Check the configuration, including cloning the git repo with it, without saving it")
 	return cmd
 }
 
@@ -43,6 +48,15 @@ func (opts *setConfigOpts) RunE(cmd *cobra.Command, args []string) error {
 		return newUsageError("a flag is required")
 	}
 
+	if opts.validateOnly {
+		if opts.file == "" {
+			return newUsageError("--validate-only needs a config file given with --file")
+		}
+		if opts.key {
+			return newUsageError("--validate-only can't be used with --generate-deploy-key")
+		}
+	}
+
 	if opts.key {
 		err := opts.GitGenerateKey()
 		if err != nil {
@@ -61,7 +75,15 @@ func (opts *setConfigOpts) RunE(cmd *cobra.Command, args []string) error {
 			return errors.Wrapf(err, "reading config from file")
 		}
 
-		err = opts.API.SetConfig(noInstanceID, config)
+		if opts.validateOnly {
+			if err := opts.API.ValidateConfig(context.Background(), noInstanceID, config); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid.")
+			return nil
+		}
+
+		err = opts.API.SetConfig(context.Background(), noInstanceID, config)
 		if err != nil {
 			return err
 		}
@@ -70,5 +92,5 @@ func (opts *setConfigOpts) RunE(cmd *cobra.Command, args []string) error {
 }
 
 func (opts *setConfigOpts) GitGenerateKey() error {
-	return opts.API.GenerateDeployKey(noInstanceID)
+	return opts.API.GenerateDeployKey(context.Background(), noInstanceID)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestSetConfigValidateOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxctl-set-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "flux-conf.yaml")
+	if err := ioutil.WriteFile(file, []byte("git:\n  URL: git@github.com:example/conf\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &genericMockRoundTripper{
+		mockResponses: map[*mux.Route]interface{}{
+			transport.NewRouter().Get("ValidateConfig"): nil,
+			transport.NewRouter().Get("SetConfig"):      nil,
+		},
+	}
+	out := &bytes.Buffer{}
+	cmd := newSetConfig(mockServiceOpts(svc).rootOpts).Command()
+	cmd.SetOutput(out)
+	cmd.SetArgs([]string{"--file=" + file, "--validate-only"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if calledURL("ValidateConfig", svc.requestHistory) == nil {
+		t.Error("expected fluxctl to request \"ValidateConfig\", but it did not")
+	}
+	if calledURL("SetConfig", svc.requestHistory) != nil {
+		t.Error("expected fluxctl not to set the config when only validating")
+	}
+	assertString(t, "Configuration is valid.\n", out.String())
+}
+
+func TestSetConfigValidateOnlyNeedsFile(t *testing.T) {
+	svc := &genericMockRoundTripper{}
+	cmd := newSetConfig(mockServiceOpts(svc).rootOpts).Command()
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs([]string{"--validate-only"})
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error validating without a config file")
+	}
+	if len(svc.requestHistory) > 0 {
+		t.Errorf("expected no requests, got %d", len(svc.requestHistory))
+	}
+}
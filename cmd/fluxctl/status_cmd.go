@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/pkg/errors"
@@ -47,7 +48,7 @@ func (opts *statusOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errors.New("unknown output format " + opts.output)
 	}
 
-	status, err := opts.API.Status(noInstanceID)
+	status, err := opts.API.Status(context.Background(), noInstanceID)
 
 	if err != nil {
 		return err
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+)
+
+type serviceThrottleOpts struct {
+	*serviceOpts
+	service  string
+	interval time.Duration
+}
+
+func newServiceThrottle(parent *serviceOpts) *serviceThrottleOpts {
+	return &serviceThrottleOpts{serviceOpts: parent}
+}
+
+func (opts *serviceThrottleOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "throttle",
+		Short: "Limit how often automation releases a service.",
+		Example: makeExample(
+			"fluxctl throttle --service=helloworld --min-release-interval=30m",
+			"fluxctl throttle --service=helloworld --min-release-interval=0",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to throttle")
+	cmd.Flags().DurationVar(&opts.interval, "min-release-interval", 0, "Shortest time between automated releases of the service; zero to remove the limit")
+	return cmd
+}
+
+func (opts *serviceThrottleOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.service == "" {
+		return newUsageError("-s, --service is required")
+	}
+	if opts.interval < 0 {
+		return newUsageError("--min-release-interval must not be negative")
+	}
+
+	serviceID, err := flux.ParseServiceID(opts.service)
+	if err != nil {
+		return err
+	}
+
+	if err := opts.API.SetMinReleaseInterval(context.Background(), noInstanceID, serviceID, opts.interval); err != nil {
+		return err
+	}
+	if opts.interval == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed the minimum release interval for %s\n", serviceID)
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Automation will release %s at most once every %s\n", serviceID, opts.interval)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/platform"
+)
+
+type traceOpts struct {
+	*rootOpts
+	duration time.Duration
+	off      bool
+}
+
+func newTrace(parent *rootOpts) *traceOpts {
+	return &traceOpts{rootOpts: parent}
+}
+
+func (opts *traceOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trace",
+		Short: "Make the daemon log each operation it does, with timings, for a while.",
+		Example: makeExample(
+			"fluxctl trace --duration=10m",
+			"fluxctl trace --off",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().DurationVar(&opts.duration, "duration", 10*time.Minute, fmt.Sprintf("How long to trace for, up to %s", platform.MaxTraceDuration))
+	cmd.Flags().BoolVar(&opts.off, "off", false, "Stop tracing now")
+	return cmd
+}
+
+func (opts *traceOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.off {
+		opts.duration = 0
+	} else if opts.duration <= 0 {
+		return newUsageError("--duration must be positive; use --off to stop tracing")
+	}
+	if opts.duration > platform.MaxTraceDuration {
+		opts.duration = platform.MaxTraceDuration
+	}
+
+	if err := opts.API.Trace(context.Background(), noInstanceID, opts.duration); err != nil {
+		return err
+	}
+	if opts.duration == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "Stopped tracing")
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "The daemon will log each operation for the next %s\n", opts.duration)
+	return nil
+}
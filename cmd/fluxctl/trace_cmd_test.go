@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestTraceCommand(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		duration string // sent to the service; empty for none
+	}{
+		{[]string{}, "10m0s"},
+		{[]string{"--duration=30s"}, "30s"},
+		{[]string{"--duration=24h"}, "1h0m0s"},
+		{[]string{"--off"}, "0s"},
+		{[]string{"--duration=0"}, ""},
+	} {
+		svc := &genericMockRoundTripper{
+			mockResponses: map[*mux.Route]interface{}{
+				transport.NewRouter().Get("Trace"): nil,
+			},
+		}
+		cmd := newTrace(mockServiceOpts(svc).rootOpts).Command()
+		cmd.SetOutput(ioutil.Discard)
+		cmd.SetArgs(c.args)
+		err := cmd.Execute()
+		if c.duration == "" {
+			if err == nil || len(svc.requestHistory) > 0 {
+				t.Errorf("%v: expected usage error and no request, got %v, %v", c.args, err, svc.requestHistory)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", c.args, err)
+			continue
+		}
+		assertString(t, c.duration, calledRequest("Trace", svc.requestHistory).Vars["duration"])
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"github.com/spf13/cobra"
+	"context"
 
-	"github.com/weaveworks/flux"
+	"github.com/spf13/cobra"
 )
 
 type serviceUnlockOpts struct {
@@ -21,10 +21,11 @@ func (opts *serviceUnlockOpts) Command() *cobra.Command {
 		Short: "Unlock a service, so it can be deployed.",
 		Example: makeExample(
 			"fluxctl unlock --service=helloworld",
+			"fluxctl unlock --service=selector:app=frontend",
 		),
 		RunE: opts.RunE,
 	}
-	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to unlock")
+	cmd.Flags().StringVarP(&opts.service, "service", "s", "", "Service to unlock, or selector:<label>=<value>,... for the services with those labels")
 	return cmd
 }
 
@@ -36,10 +37,15 @@ func (opts *serviceUnlockOpts) RunE(_ *cobra.Command, args []string) error {
 		return newUsageError("-s, --service is required")
 	}
 
-	serviceID, err := flux.ParseServiceID(opts.service)
+	serviceIDs, err := resolveServiceOption(opts.API, opts.service)
 	if err != nil {
 		return err
 	}
 
-	return opts.API.Unlock(noInstanceID, serviceID)
+	for _, serviceID := range serviceIDs {
+		if err := opts.API.Unlock(context.Background(), noInstanceID, serviceID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
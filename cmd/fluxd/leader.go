@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"k8s.io/client-go/1.5/rest"
+
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/leader"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// Where Kubernetes puts the namespace of the pod's service account,
+// which is the pod's namespace.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// elect campaigns for this replica to lead, using a ConfigMap as the
+// lock, and connects to fluxsvc while it's the leader. Closing the
+// stop channel returned disconnects and gives up the lease; the done
+// channel is closed once that's over. It exits if the election can't
+// be set up, as for other problems with flags.
+func elect(logger log.Logger, config *rest.Config, election leader.Config, namespace, name string, connect func() (*transport.Daemon, error)) (stop, done chan struct{}) {
+	logger = log.NewContext(logger).With("component", "leader")
+
+	identity, err := os.Hostname()
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	if namespace == "" {
+		namespace = "default"
+		if bytes, err := ioutil.ReadFile(namespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(bytes))
+		}
+	}
+	lock, err := kubernetes.NewConfigMapLock(config, namespace, name, identity)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	election.Lock = lock
+	election.Identity = identity
+	election.Events = lock
+	election.Logger = logger
+	elector, err := leader.NewElector(election)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	logger.Log("identity", identity, "lock", lock.Describe())
+
+	stop, done = make(chan struct{}), make(chan struct{})
+	var daemon *transport.Daemon
+	go func() {
+		defer close(done)
+		elector.Run(stop, func() {
+			if daemon, err = connect(); err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}, func() {
+			daemon.Close()
+			daemon = nil
+		})
+	}()
+	return stop, done
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,9 +17,14 @@ import (
 	"k8s.io/client-go/1.5/rest"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/chaos"
+	"github.com/weaveworks/flux/checkpoint"
+	"github.com/weaveworks/flux/git"
 	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/leader"
 	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/platform/kubernetes"
+	"github.com/weaveworks/flux/tracing"
 )
 
 var version string
@@ -38,7 +45,46 @@ func main() {
 		fluxsvcAddress    = fs.String("fluxsvc-address", "wss://cloud.weave.works/api/flux", "Address of the fluxsvc to connect to.")
 		token             = fs.String("token", "", "Token to use to authenticate with flux service")
 		kubernetesKubectl = fs.String("kubernetes-kubectl", "", "Optional, explicit path to kubectl tool")
+		serverSideApply   = fs.Bool("kubernetes-server-side-apply", false, "Apply resources server-side, so fields set by flux are owned by it and conflicts with other controllers are reported (falls back to client-side apply for clusters that don't support it)")
+		fieldManager      = fs.String("kubernetes-field-manager", "flux", "With --kubernetes-server-side-apply, the field manager to apply resources as")
+		missingNamespaces = fs.String("kubernetes-missing-namespaces", "", `Optional, what to do when resources are in a namespace that doesn't exist: "create" the namespace, or "fail" those resources without applying them`)
+		substitute        = fs.StringSlice("kubernetes-substitute", nil, "Optional, NAME=value to substitute for ${NAME} in resources when they're applied (may be given more than once)")
+		substituteFrom    = fs.String("kubernetes-substitute-configmap", "", "Optional, namespace/name of a ConfigMap whose data are values to substitute for variables in resources when they're applied")
+		pullSecrets       = fs.StringSlice("registry-pull-secret-namespaces", nil, `Optional, namespaces (or "*" for all of them) in which to look for registry credentials in the image pull secrets of workloads and service accounts, for fluxsvc to use along with those in its config (may be given more than once)`)
+		logSpans          = fs.Bool("log-spans", false, "Log the span of each call from fluxsvc that's part of a trace, with its trace ID")
+		checkpointEnable  = fs.Bool("checkpoint", false, "Check periodically for newer versions of flux and security advisories, and log them; this sends the version, OS and architecture of fluxd to --checkpoint-url")
+		checkpointURL     = fs.String("checkpoint-url", "https://checkpoint-api.weave.works/v1/check/flux", "With --checkpoint, endpoint to check for newer versions of flux and security advisories")
+		checkpointPeriod  = fs.Duration("checkpoint-interval", 6*time.Hour, "With --checkpoint, period at which to check for newer versions of flux")
 		versionFlag       = fs.Bool("version", false, "Get version number")
+
+		// For running more than one replica
+		leaderElect         = fs.Bool("leader-elect", false, "Elect a leader among the replicas of fluxd, so that only one connects to fluxsvc (and so syncs and applies changes) at a time, while the others stand by")
+		leaderNamespace     = fs.String("leader-elect-namespace", "", "With --leader-elect, the namespace of the ConfigMap used as a lock (by default, fluxd's own namespace)")
+		leaderName          = fs.String("leader-elect-name", "fluxd", "With --leader-elect, the name of the ConfigMap used as a lock; it's created if it doesn't exist")
+		leaderLease         = fs.Duration("leader-elect-lease-duration", 15*time.Second, "With --leader-elect, how long standbys wait, after the leader last renewed its lease, before taking over")
+		leaderRenewDeadline = fs.Duration("leader-elect-renew-deadline", 10*time.Second, "With --leader-elect, how long the leader keeps trying to renew its lease before it stops leading; must be less than the lease duration")
+		leaderRetryPeriod   = fs.Duration("leader-elect-retry-period", 2*time.Second, "With --leader-elect, how long to wait between tries at taking or renewing the lease")
+
+		// For syncing once, rather than connecting to fluxsvc
+		once      = fs.Bool("once", false, "Clone the config repo, apply everything defined in it, and exit; the exit code says whether it all succeeded")
+		gitURL    = fs.String("git-url", "", "With --once, URL of the config repo")
+		gitBranch = fs.String("git-branch", "master", "With --once, branch of the config repo")
+		gitPath   = fs.String("git-path", "", "With --once, path within the config repo of the resource definition files")
+		gitKey    = fs.String("git-key", "", "With --once, optional path to a private key (e.g., a deploy key) for cloning the config repo")
+		gitPhrase = fs.String("git-key-passphrase-file", "", "With --once and an encrypted --git-key, path to a file with the key's passphrase")
+		gitUser   = fs.String("git-user", "", "With --once and an HTTPS config repo URL, optional username to clone with, along with --git-token-file")
+		gitToken  = fs.String("git-token-file", "", "With --once and an HTTPS config repo URL, optional path to a file with a token (e.g., a personal access token) to clone with")
+		gitTime   = fs.Duration("git-timeout", git.DefaultTimeout, "With --once, how long cloning the config repo may take before it's abandoned")
+		gitHosts  = fs.String("git-known-hosts", "", "With --once and an SSH config repo URL, optional path to a file in the format of an ssh known_hosts file, with the remote's host key; otherwise, it must be in ssh's own known_hosts")
+		gitSubs   = fs.Bool("git-submodules", false, "With --once, also check out the config repo's submodules, so resource definitions in them are applied")
+		gitSparse = fs.Bool("git-sparse", false, "With --once, check out only the files under --git-path (and those at the top of the config repo), e.g., for a very large repo; needs git 2.25 or later")
+		gitCreate = fs.Bool("git-create-branch", false, "With --once, if the branch of the config repo doesn't exist, create it from the default branch (and push it) rather than failing")
+		sshPort   = fs.Int("git-ssh-port", 0, "With --once and an SSH config repo URL, the port to connect to, if not 22 and not given in the URL")
+		sshJump   = fs.String("git-ssh-jump-host", "", "With --once and an SSH config repo URL, optional [user@]host[:port] of a host to connect through; its host key must be known, like the remote's")
+		sshProxy  = fs.String("git-ssh-proxy-command", "", "With --once and an SSH config repo URL, optional command to connect to the remote with, as for ssh's ProxyCommand")
+		sshOpts   = fs.StringSlice("git-ssh-option", nil, "With --once and an SSH config repo URL, an ssh option as Name=value, as for ssh -o (may be given more than once)")
+		gitVerify = fs.Bool("git-verify-signatures", false, "With --once, apply nothing unless the commit synced is signed by a key in gpg's keyring (see --git-gpg-key-import)")
+		gitGPGKey = fs.String("git-gpg-key-import", "", "With --once, import the GPG public key(s) in this file, or in each file in this directory, before syncing, as the keys trusted to sign commits")
 	)
 	fs.Parse(os.Args)
 
@@ -57,11 +103,18 @@ func main() {
 		logger = log.NewContext(logger).With("ts", log.DefaultTimestampUTC)
 		logger = log.NewContext(logger).With("caller", log.DefaultCaller)
 	}
+	if *logSpans {
+		tracing.SetReporter(tracing.LogReporter{Logger: log.NewContext(logger).With("component", "tracing")})
+	}
 
 	// Platform component.
-	var k8s platform.Platform
+	var (
+		k8s              platform.Platform
+		restClientConfig *rest.Config
+	)
 	{
-		restClientConfig, err := rest.InClusterConfig()
+		var err error
+		restClientConfig, err = rest.InClusterConfig()
 		if err != nil {
 			logger.Log("err", err)
 			os.Exit(1)
@@ -88,38 +141,191 @@ func main() {
 		}
 		logger.Log("kubectl", kubectl)
 
+		namespaces, err := kubernetes.ParseNamespacePolicy(*missingNamespaces)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+
 		kubectlApplier := kubernetes.NewKubectl(kubectl, restClientConfig, os.Stdout, os.Stderr)
-		cluster, err := kubernetes.NewCluster(restClientConfig, kubectlApplier, version, logger)
+		if *serverSideApply {
+			if *fieldManager == "" {
+				logger.Log("err", "--kubernetes-field-manager must not be empty with --kubernetes-server-side-apply")
+				os.Exit(1)
+			}
+			kubectlApplier.ServerSideApply(*fieldManager)
+		}
+		cluster, err := kubernetes.NewCluster(restClientConfig, kubectlApplier, namespaces, version, logger)
 		if err != nil {
 			logger.Log("err", err)
 			os.Exit(1)
 		}
 
+		if len(*substitute) > 0 || *substituteFrom != "" {
+			vars, err := kubernetes.ParseVars(*substitute)
+			if err == nil && *substituteFrom != "" {
+				_, _, err = kubernetes.ParseConfigMap(*substituteFrom)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			cluster.SetSubstitutions(kubernetes.Substitutions{Vars: vars, ConfigMap: *substituteFrom})
+			logger.Log("substitute", len(vars), "configmap", *substituteFrom)
+		}
+
+		if len(*pullSecrets) > 0 {
+			cluster.SetPullSecretNamespaces(*pullSecrets)
+			logger.Log("pull-secret-namespaces", strings.Join(*pullSecrets, ","))
+		}
+
 		if services, err := cluster.AllServices("", nil); err != nil {
 			logger.Log("services", err)
 		} else {
 			logger.Log("services", len(services))
 		}
 
-		k8s = cluster
+		// Trace mode can be turned on through fluxsvc, to log each
+		// operation fluxd does.
+		k8s = platform.Traced(cluster, log.NewContext(logger).With("component", "trace"))
+	}
+
+	if *once {
+		var key []byte
+		if *gitKey != "" {
+			var err error
+			if key, err = ioutil.ReadFile(*gitKey); err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		var passphrase string
+		if *gitPhrase != "" {
+			bytes, err := ioutil.ReadFile(*gitPhrase)
+			if err == nil {
+				passphrase = strings.TrimRight(string(bytes), "\r\n")
+				err = git.CheckKeyPassphrase(string(key), passphrase)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		var token []byte
+		if *gitToken != "" {
+			var err error
+			if token, err = ioutil.ReadFile(*gitToken); err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		var knownHosts []byte
+		if *gitHosts != "" {
+			var err error
+			if knownHosts, err = ioutil.ReadFile(*gitHosts); err == nil {
+				err = git.CheckKnownHosts(string(knownHosts))
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		sshOptions, err := git.ParseSSHOptions(*sshOpts)
+		if err == nil {
+			sshOptions.Port, sshOptions.JumpHost, sshOptions.ProxyCommand = *sshPort, *sshJump, *sshProxy
+			err = sshOptions.Validate()
+		}
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		repo := git.Repo{
+			URL:           *gitURL,
+			Branch:        *gitBranch,
+			Path:          *gitPath,
+			Key:           string(key),
+			KeyPassphrase: passphrase,
+			User:          *gitUser,
+			Token:         strings.TrimSpace(string(token)),
+			KnownHosts:    string(knownHosts),
+			SSH:           sshOptions,
+			Submodules:    *gitSubs,
+			CreateBranch:  *gitCreate,
+			Sparse:        *gitSparse,
+			Timeout:       *gitTime,
+			Queue:         git.NewQueue(),
+		}
+		repo.BranchCreated = func(branch, revision string) {
+			logger.Log("component", "sync", "created", branch, "revision", revision)
+		}
+		if *gitGPGKey != "" {
+			imported, err := git.ImportGPGKeys(*gitGPGKey)
+			for _, file := range imported {
+				logger.Log("component", "gpg", "imported", file)
+			}
+			if err != nil {
+				logger.Log("component", "gpg", "err", err)
+				os.Exit(1)
+			}
+		}
+		if err := syncOnce(logger, k8s, repo, *gitVerify); err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
 	// Connect to fluxsvc
 	daemonLogger := log.NewContext(logger).With("component", "client")
-	daemon, err := transport.NewDaemon(
-		&http.Client{Timeout: 10 * time.Second},
-		fmt.Sprintf("fluxd/%v", version),
-		flux.Token(*token),
-		transport.NewRouter(),
-		*fluxsvcAddress,
-		k8s,
-		daemonLogger,
-	)
-	if err != nil {
-		logger.Log("err", err)
-		os.Exit(1)
+	connect := func() (*transport.Daemon, error) {
+		return transport.NewDaemon(
+			&http.Client{Timeout: 10 * time.Second},
+			fmt.Sprintf("fluxd/%v", version),
+			flux.Token(*token),
+			transport.NewRouter(),
+			*fluxsvcAddress,
+			k8s,
+			daemonLogger,
+		)
+	}
+	if !*leaderElect {
+		daemon, err := connect()
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		defer daemon.Close()
+	} else {
+		// Only the leader connects; the others have the platform
+		// ready, so they can take over as soon as they're elected.
+		stop, done := elect(logger, restClientConfig, leader.Config{
+			LeaseDuration: *leaderLease,
+			RenewDeadline: *leaderRenewDeadline,
+			RetryPeriod:   *leaderRetryPeriod,
+		}, *leaderNamespace, *leaderName, connect)
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	// Version checker. The daemon has no history of its own to
+	// announce in, so it logs what it finds.
+	if *checkpointEnable && *checkpointURL != "" {
+		logger := log.NewContext(logger).With("component", "checkpoint")
+		checker := checkpoint.New(*checkpointURL, "fluxd", version, &http.Client{Timeout: 10 * time.Second}, logger)
+		checkTicker := time.NewTicker(*checkpointPeriod)
+		defer checkTicker.Stop()
+		go checker.Start(checkTicker.C, func(status flux.CheckpointStatus) {
+			if status.Outdated {
+				logger.Log("latest-version", status.LatestVersion, "outdated", true)
+			}
+			for _, alert := range status.Alerts {
+				logger.Log("alert", alert.Message, "level", alert.Level, "url", alert.URL)
+			}
+		})
+		logger.Log("checkpoint", "enabled", "url", *checkpointURL)
 	}
-	defer daemon.Close()
 
 	// Mechanical components.
 	errc := make(chan error)
@@ -129,11 +335,13 @@ func main() {
 		errc <- fmt.Errorf("%s", <-c)
 	}()
 
-	// HTTP transport component, for metrics
+	// HTTP transport component, for metrics (and, in chaos builds,
+	// injecting faults)
 	go func() {
 		logger.Log("addr", *listenAddr)
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", promhttp.Handler())
+		chaos.Register(mux)
 		errc <- http.ListenAndServe(*listenAddr, mux)
 	}()
 
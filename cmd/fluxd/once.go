@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/embedded"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/platform"
+)
+
+// syncOnce clones the config repo and applies everything defined in
+// it, for running fluxd as a one-off job (e.g., in CI, or from cron)
+// rather than as an agent of fluxsvc. If verify is true, nothing is
+// applied unless the commit checked out is signed by a key in gpg's
+// keyring.
+func syncOnce(logger log.Logger, k8s platform.Platform, repo git.Repo, verify bool) error {
+	if repo.URL == "" {
+		return errors.New("--git-url must be given with --once")
+	}
+
+	d, err := embedded.New(embedded.Config{
+		Platform:         k8s,
+		Repo:             repo,
+		VerifySignatures: verify,
+		Logger:           logger,
+	})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	switch result, err := d.Sync(); err := err.(type) {
+	case nil:
+		return nil
+	case platform.SyncError:
+		for id, resourceErr := range err {
+			logger.Log("component", "sync", "resource", id, "err", resourceErr)
+		}
+		return fmt.Errorf("%d of %d resources failed to sync", len(err), result.Resources)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/1.5/rest"
+
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// In demo mode, fluxsvc keeps everything in an in-memory database, and
+// runs a daemon itself, for a cluster from the user's kubeconfig;
+// so that flux can be tried out without setting up a database or
+// running fluxd in the cluster.
+
+// demoDatabaseSource is the in-memory database used in demo mode.
+const demoDatabaseSource = "memory://flux-demo"
+
+// holdDemoDatabase keeps a connection to the in-memory database open,
+// until closed. ql throws away a memory database when its last
+// connection is closed, and the migrations close theirs before
+// anything else opens the database.
+func holdDemoDatabase() (*sql.DB, error) {
+	db, err := sql.Open("ql-mem", demoDatabaseSource)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Just the bits of a kubeconfig we need, as output by `kubectl config
+// view --minify --raw -o json`; --minify means there's only the
+// cluster and user of the context.
+type kubeConfig struct {
+	Clusters []struct {
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			CertificateAuthority     string `json:"certificate-authority"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		User struct {
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientKeyData         string `json:"client-key-data"`
+			ClientKey             string `json:"client-key"`
+			Token                 string `json:"token"`
+			Username              string `json:"username"`
+			Password              string `json:"password"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// demoRestConfig gives the client config for a kubeconfig context (or
+// the current context, if blank), as kubectl sees it. Certificates
+// and keys given inline are written to files in dir, since that's how
+// kubectl is given them when applying resources.
+func demoRestConfig(kubectl, context, dir string) (*rest.Config, error) {
+	args := []string{"config", "view", "--minify", "--raw", "-o", "json"}
+	if context != "" {
+		args = append(args, "--context", context)
+	}
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(kubectl, args...)
+	cmd.Stdout, cmd.Stderr = out, errOut
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "kubectl config view: %s", errOut.String())
+	}
+	var kc kubeConfig
+	if err := json.Unmarshal(out.Bytes(), &kc); err != nil {
+		return nil, errors.Wrap(err, "parsing kubeconfig")
+	}
+	if len(kc.Clusters) != 1 || len(kc.Users) != 1 {
+		return nil, fmt.Errorf("expected one cluster and user for the context, got %d and %d", len(kc.Clusters), len(kc.Users))
+	}
+	cluster, user := kc.Clusters[0].Cluster, kc.Users[0].User
+
+	file := func(path, data, name string) (string, error) {
+		if data == "" {
+			return path, nil
+		}
+		bytes, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return "", errors.Wrapf(err, "decoding %s", name)
+		}
+		path = filepath.Join(dir, name)
+		return path, ioutil.WriteFile(path, bytes, 0600)
+	}
+	config := &rest.Config{
+		Host:        cluster.Server,
+		BearerToken: user.Token,
+		Username:    user.Username,
+		Password:    user.Password,
+	}
+	var err error
+	if config.TLSClientConfig.CAFile, err = file(cluster.CertificateAuthority, cluster.CertificateAuthorityData, "ca.crt"); err != nil {
+		return nil, err
+	}
+	if config.TLSClientConfig.CertFile, err = file(user.ClientCertificate, user.ClientCertificateData, "client.crt"); err != nil {
+		return nil, err
+	}
+	if config.TLSClientConfig.KeyFile, err = file(user.ClientKey, user.ClientKeyData, "client.key"); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// demoPlatform gives the platform for the kubeconfig context given,
+// as fluxd would run it in the cluster. Namespaces missing from the
+// cluster are created, since a demo cluster is likely to be empty.
+func demoPlatform(kubectl, context, dir string, logger log.Logger) (platform.Platform, error) {
+	if kubectl == "" {
+		var err error
+		if kubectl, err = exec.LookPath("kubectl"); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(kubectl); err != nil {
+		return nil, err
+	}
+	config, err := demoRestConfig(kubectl, context, dir)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("host", config.Host, "kubectl", kubectl)
+	cluster, err := kubernetes.NewCluster(config, kubernetes.NewKubectl(kubectl, config, os.Stdout, os.Stderr), kubernetes.NamespaceCreate, version, logger)
+	if err != nil {
+		return nil, err
+	}
+	return platform.Traced(cluster, log.NewContext(logger).With("component", "trace")), nil
+}
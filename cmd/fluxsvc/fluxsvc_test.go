@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -14,6 +17,8 @@ import (
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/api"
+	"github.com/weaveworks/flux/audit"
+	auditsql "github.com/weaveworks/flux/audit/sql"
 	"github.com/weaveworks/flux/db"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/history"
@@ -46,6 +51,12 @@ var (
 
 	// API Client
 	apiClient api.ClientService
+
+	// For operators, listing instances
+	instances *server.Instances
+
+	// The audit log of calls that change things
+	auditDB *auditsql.DB
 )
 
 const (
@@ -127,10 +138,13 @@ func setup() {
 		}
 	}
 
+	instances = server.NewInstances(instanceDB, messageBus, jobStore, historyDB)
+	auditDB, _ = auditsql.New(dbDriver, databaseSource)
+
 	// Server
-	apiServer := server.New(ver, instancer, instanceDB, messageBus, jobStore, log.NewNopLogger())
+	apiServer := server.New(ver, instancer, instanceDB, messageBus, jobStore, nil, nil, nil, 0, log.NewNopLogger())
 	router = transport.NewRouter()
-	handler := httpserver.NewHandler(apiServer, router, log.NewNopLogger())
+	handler := httpserver.NewHandler(apiServer, router, nil, nil, auditDB, log.NewNopLogger())
 	ts = httptest.NewServer(handler)
 	apiClient = client.New(http.DefaultClient, router, ts.URL, "")
 }
@@ -144,7 +158,7 @@ func TestFluxsvc_ListServices(t *testing.T) {
 	defer teardown()
 
 	// Test ListServices
-	svcs, err := apiClient.ListServices("", "default")
+	svcs, err := apiClient.ListServices(context.Background(), "", "default")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -174,7 +188,7 @@ func TestFluxsvc_ListImages(t *testing.T) {
 	defer teardown()
 
 	// Test ListImages
-	imgs, err := apiClient.ListImages("", flux.ServiceSpecAll)
+	imgs, err := apiClient.ListImages(context.Background(), "", flux.ServiceSpecAll, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -186,7 +200,7 @@ func TestFluxsvc_ListImages(t *testing.T) {
 	}
 
 	// Test ListImages for specific service
-	imgs, err = apiClient.ListImages("", helloWorldSvc)
+	imgs, err = apiClient.ListImages(context.Background(), "", helloWorldSvc, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,6 +211,18 @@ func TestFluxsvc_ListImages(t *testing.T) {
 		t.Fatal("Should have been lots of containers")
 	}
 
+	// Test ListImages filtered by automation
+	if err = apiClient.Automate(context.Background(), "", helloWorldSvc); err != nil {
+		t.Fatal(err)
+	}
+	imgs, err = apiClient.ListImages(context.Background(), "", flux.ServiceSpecAll, []flux.ImageStatusFilter{flux.ImageStatusAutomated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || imgs[0].ID != helloWorldSvc {
+		t.Fatalf("Expected only the automated service, got %v", imgs)
+	}
+
 	// Test no service error
 	u, _ := transport.MakeURL(ts.URL, router, "ListImages")
 	resp, err := http.Get(u.String())
@@ -214,7 +240,7 @@ func TestFluxsvc_Release(t *testing.T) {
 	defer teardown()
 
 	// Test PostRelease
-	r, err := apiClient.PostRelease("", jobs.ReleaseJobParams{
+	r, err := apiClient.PostRelease(context.Background(), "", jobs.ReleaseJobParams{
 		ReleaseSpec: flux.ReleaseSpec{
 			ImageSpec:    "alpine:latest",
 			Kind:         "execute",
@@ -237,7 +263,7 @@ func TestFluxsvc_Release(t *testing.T) {
 	}
 
 	// Test GetRelease
-	res, err := apiClient.GetRelease("", r)
+	res, err := apiClient.GetRelease(context.Background(), "", r, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -246,11 +272,34 @@ func TestFluxsvc_Release(t *testing.T) {
 	}
 
 	// Test GetRelease doesn't exist
-	_, err = apiClient.GetRelease("", "does-not-exist")
+	_, err = apiClient.GetRelease(context.Background(), "", "does-not-exist", 0)
 	if err == nil {
 		t.Fatal("Should have errored due to not existing")
 	}
 
+	// Test GetRelease waiting for the job to move on; nothing is
+	// working on it, so it waits the whole time, and is still queued
+	begin := time.Now()
+	res, err = apiClient.GetRelease(context.Background(), "", r, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) < time.Second {
+		t.Fatalf("Should have waited for the job to move on")
+	}
+	if res.Status != jobs.StatusQueued {
+		t.Fatalf("Job should still have been queued but was %q", res.Status)
+	}
+
+	// Test JobLog; a queued job has only its status so far
+	lines, err := apiClient.JobLog(context.Background(), "", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != jobs.StatusQueued {
+		t.Fatalf("Expected log of just %q, got %q", jobs.StatusQueued, lines)
+	}
+
 	// Test PostRelease without parameters
 	u, _ := transport.MakeURL(ts.URL, router, "PostRelease", "service", "default/service")
 	resp, err := http.Post(u.String(), "application/json", nil)
@@ -263,12 +312,35 @@ func TestFluxsvc_Release(t *testing.T) {
 	}
 }
 
+func TestFluxsvc_SyncNotify(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// A burst of notifications should result in a single job
+	for i := 0; i < 3; i++ {
+		if err := apiClient.SyncNotify(context.Background(), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j, err := jobStore.NextJob([]string{jobs.AutomatedInstanceJob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Method != jobs.AutomatedInstanceJob {
+		t.Fatalf("Job should have been of type %q, but was %q", jobs.AutomatedInstanceJob, j.Method)
+	}
+	if _, err := jobStore.NextJob([]string{jobs.AutomatedInstanceJob}); err != jobs.ErrNoJobAvailable {
+		t.Fatalf("Expected only one job to be queued, but got %v", err)
+	}
+}
+
 func TestFluxsvc_Automate(t *testing.T) {
 	setup()
 	defer teardown()
 
 	// Test Automate
-	err := apiClient.Automate("", helloWorldSvc)
+	err := apiClient.Automate(context.Background(), "", helloWorldSvc)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -294,7 +366,7 @@ func TestFluxsvc_Deautomate(t *testing.T) {
 	defer teardown()
 
 	// Test Deautomate
-	err := apiClient.Deautomate("", helloWorldSvc)
+	err := apiClient.Deautomate(context.Background(), "", helloWorldSvc)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -320,7 +392,7 @@ func TestFluxsvc_Lock(t *testing.T) {
 	defer teardown()
 
 	// Test Lock
-	err := apiClient.Lock("", helloWorldSvc)
+	err := apiClient.Lock(context.Background(), "", helloWorldSvc)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -328,6 +400,13 @@ func TestFluxsvc_Lock(t *testing.T) {
 	if !cfg.Services[helloWorldSvc].Locked {
 		t.Fatal("Expected DB to record that it is locked. %#v", cfg.Services[helloWorldSvc])
 	}
+	entries, err := auditDB.Entries(audit.Query{Route: "Lock", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "service="+url.QueryEscape(helloWorldSvc) || entries[0].StatusCode != http.StatusOK {
+		t.Fatalf("Expected the lock to be recorded in the audit log, got %+v", entries)
+	}
 
 	// Test no service error
 	u, _ := transport.MakeURL(ts.URL, router, "Lock")
@@ -346,7 +425,7 @@ func TestFluxsvc_Unlock(t *testing.T) {
 	defer teardown()
 
 	// Test Unlock
-	err := apiClient.Unlock("", helloWorldSvc)
+	err := apiClient.Unlock(context.Background(), "", helloWorldSvc)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -372,14 +451,15 @@ func TestFluxsvc_History(t *testing.T) {
 	defer teardown()
 
 	// Do something that will appear in the history
-	apiClient.Lock("", helloWorldSvc)
+	apiClient.Lock(context.Background(), "", helloWorldSvc)
 
 	// Test History
-	hist, err := apiClient.History("", helloWorldSvc, time.Now().UTC(), -1)
+	page, err := apiClient.History(context.Background(), "", helloWorldSvc, flux.HistoryQuery{Before: time.Now().UTC(), Limit: -1})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(hist) == 0 {
+	hist := page.Entries
+	if len(hist) == 0 || page.Total != int64(len(hist)) {
 		t.Fatal("History should be longer than this: ", hist)
 	}
 	var hasLock bool
@@ -393,6 +473,27 @@ func TestFluxsvc_History(t *testing.T) {
 		t.Fatal("History hasn't recorded a lock", hist)
 	}
 
+	// Test paging, and filtering by type
+	apiClient.Unlock(context.Background(), "", helloWorldSvc)
+	page, err = apiClient.History(context.Background(), "", helloWorldSvc, flux.HistoryQuery{Types: []string{flux.EventLock, flux.EventUnlock}, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other tests lock and unlock too
+	if len(page.Entries) != 1 || page.Total < 2 || page.Entries[0].Event.Type != flux.EventUnlock {
+		t.Fatalf("Expected the unlock, of at least 2 entries, got %+v", page)
+	}
+	page, err = apiClient.History(context.Background(), "", helloWorldSvc, flux.HistoryQuery{Types: []string{flux.EventLock, flux.EventUnlock}, BeforeCursor: page.Entries[0].Cursor, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Event.Type != flux.EventLock {
+		t.Fatalf("Expected the lock on the next page, got %+v", page)
+	}
+	if _, err = apiClient.History(context.Background(), "", helloWorldSvc, flux.HistoryQuery{BeforeCursor: "bogus", Limit: -1}); err == nil {
+		t.Fatal("Expected an error for a bogus cursor")
+	}
+
 	// Test no service error
 	u, _ := transport.MakeURL(ts.URL, router, "History")
 	resp, err := http.Get(u.String())
@@ -410,7 +511,7 @@ func TestFluxsvc_Status(t *testing.T) {
 	defer teardown()
 
 	// Test Status
-	status, err := apiClient.Status("")
+	status, err := apiClient.Status(context.Background(), "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -424,7 +525,7 @@ func TestFluxsvc_Config(t *testing.T) {
 	defer teardown()
 
 	// Test that config is written
-	err := apiClient.SetConfig("", flux.UnsafeInstanceConfig{
+	err := apiClient.SetConfig(context.Background(), "", flux.UnsafeInstanceConfig{
 		Git: flux.GitConfig{
 			Key:    "exampleKey",
 			Branch: "exampleBranch",
@@ -433,7 +534,7 @@ func TestFluxsvc_Config(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	conf, err := apiClient.GetConfig("", "")
+	conf, err := apiClient.GetConfig(context.Background(), "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -450,7 +551,7 @@ func TestFluxsvc_DeployKeys(t *testing.T) {
 	defer teardown()
 
 	// Ensure empty key
-	err := apiClient.SetConfig("", flux.UnsafeInstanceConfig{
+	err := apiClient.SetConfig(context.Background(), "", flux.UnsafeInstanceConfig{
 		Git: flux.GitConfig{
 			Key: "",
 		},
@@ -460,13 +561,13 @@ func TestFluxsvc_DeployKeys(t *testing.T) {
 	}
 
 	// Generate key
-	err = apiClient.GenerateDeployKey("")
+	err = apiClient.GenerateDeployKey(context.Background(), "")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Get new key
-	conf, err := apiClient.GetConfig("", "")
+	conf, err := apiClient.GetConfig(context.Background(), "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -519,3 +620,79 @@ func TestFluxsvc_Register(t *testing.T) {
 		t.Fatal("Request should have been ok but got %q, body:\n%v", resp.Status, body)
 	}
 }
+
+func TestFluxsvc_AdminHandler(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := httptest.NewServer(adminHandler(instances, auditDB, nil))
+	defer admin.Close()
+
+	for _, path := range []string{"/metrics", "/healthz", "/debug/pprof/", "/instances", "/audit"} {
+		resp, err := http.Get(admin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d", path, http.StatusOK, resp.StatusCode)
+		}
+	}
+}
+
+func TestFluxsvc_ListInstances(t *testing.T) {
+	setup()
+	defer teardown()
+
+	for _, id := range []flux.InstanceID{flux.DefaultInstanceID, "other-instance"} {
+		if err := instanceDB.UpdateConfig(id, func(c instance.Config) (instance.Config, error) {
+			return c, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := httptest.NewServer(adminHandler(instances, auditDB, nil))
+	defer admin.Close()
+
+	get := func(query string) flux.InstanceList {
+		resp, err := http.Get(admin.URL + "/instances?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected %d, got %d", query, http.StatusOK, resp.StatusCode)
+		}
+		var list flux.InstanceList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	// Only the default instance has a daemon
+	list := get("connected=true")
+	if list.Total != 1 || len(list.Instances) != 1 || list.Instances[0].ID != flux.DefaultInstanceID {
+		t.Errorf("expected the default instance to be the only one connected, got %+v", list)
+	}
+	list = get("match=other&connected=false")
+	if list.Total != 1 || len(list.Instances) != 1 || list.Instances[0].ID != "other-instance" {
+		t.Errorf("expected the other instance not to be connected, got %+v", list)
+	}
+
+	// Pages give the total of all pages
+	all := get("")
+	list = get("offset=1&limit=1")
+	if list.Total != all.Total || len(list.Instances) != 1 || list.Instances[0].ID != all.Instances[1].ID {
+		t.Errorf("expected the second of %+v, got %+v", all, list)
+	}
+
+	resp, err := http.Get(admin.URL + "/instances?limit=lots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for a bad limit, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
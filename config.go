@@ -4,6 +4,8 @@ import (
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"reflect"
+	"sort"
 	"strings"
 
 	"golang.org/x/crypto/ssh"
@@ -20,6 +22,58 @@ type GitConfig struct {
 	Path   string `json:"path" yaml:"path"`
 	Branch string `json:"branch" yaml:"branch"`
 	Key    string `json:"key" yaml:"key"`
+	// KeyPassphrase is the passphrase for Key, if it's encrypted.
+	KeyPassphrase string `json:"keyPassphrase,omitempty" yaml:"keyPassphrase,omitempty"`
+	// User and Token are for HTTPS remotes, instead of a key.
+	User  string `json:"user,omitempty" yaml:"user,omitempty"`
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+	// KnownHosts has the host keys of the remote, for an SSH URL, in
+	// the format of an ssh known_hosts file (e.g., the output of
+	// ssh-keyscan). The remote's host key must be here, or in those
+	// given to fluxsvc, or it's refused.
+	KnownHosts string `json:"knownHosts,omitempty" yaml:"knownHosts,omitempty"`
+	// SSH says how to connect to an SSH remote, e.g., on a port other
+	// than 22, or through a jump host.
+	SSH GitSSHConfig `json:"ssh,omitempty" yaml:"ssh,omitempty"`
+	// SigningKey is the ID of a GPG key with which to sign commits.
+	// The key must have been imported into fluxsvc's keyring.
+	SigningKey string `json:"signingKey,omitempty" yaml:"signingKey,omitempty"`
+	// Submodules says whether to check out the repo's submodules, so
+	// that resource definitions in them are included.
+	Submodules bool `json:"submodules,omitempty" yaml:"submodules,omitempty"`
+	// Sparse says to check out only the files under Path (and those
+	// at the top of the repo), e.g., for a very large repo.
+	Sparse bool `json:"sparse,omitempty" yaml:"sparse,omitempty"`
+	// SkipLFS says not to fetch files kept in git LFS, checking out
+	// their pointers instead, so git-lfs isn't needed.
+	SkipLFS bool `json:"skipLFS,omitempty" yaml:"skipLFS,omitempty"`
+	// CreateBranch says to create the branch from the repo's default
+	// branch, if it doesn't exist, rather than failing to clone.
+	CreateBranch bool `json:"createBranch,omitempty" yaml:"createBranch,omitempty"`
+	// PullRequest, if it has a provider, says to propose the changes
+	// each release makes in a pull request against the branch,
+	// rather than pushing them to it; or, with OnlyRefused, those of
+	// automated releases that can't be pushed.
+	PullRequest PullRequestConfig `json:"pullRequest,omitempty" yaml:"pullRequest,omitempty"`
+	// Lockfile says whether to record the exact images released, in
+	// a file alongside the resource definitions.
+	Lockfile bool `json:"lockfile" yaml:"lockfile"`
+	// RepoConfig says whether to read some of the config (the Slack
+	// settings, namespaces and tag filters) from a file in the config
+	// repo, so that changes to them go through git.
+	RepoConfig bool `json:"repoConfig,omitempty" yaml:"repoConfig,omitempty"`
+	// ReadOnly says that flux mustn't write to the repo; releases,
+	// which must commit changes, fail, and nothing is released
+	// automatically.
+	ReadOnly bool `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+	// Layout names the way the resource definitions under Path are
+	// laid out (see the layout package); if blank, "namespaces".
+	Layout string `json:"layout,omitempty" yaml:"layout,omitempty"`
+	// WebhookSecret, if not blank, is the secret given to the git
+	// host for its push webhook, so that pushes to the branch are
+	// synced straight away, rather than when next polled. Webhook
+	// requests that aren't signed with it are refused.
+	WebhookSecret string `json:"webhookSecret,omitempty" yaml:"webhookSecret,omitempty"`
 }
 
 // NotifierConfig is the config used to set up a notifier.
@@ -27,6 +81,17 @@ type NotifierConfig struct {
 	HookURL         string `json:"hookURL" yaml:"hookURL"`
 	Username        string `json:"username" yaml:"username"`
 	ReleaseTemplate string `json:"releaseTemplate" yaml:"releaseTemplate"`
+	// Secret, if not blank, is shared with the receiver of the hook,
+	// which can use it to check that requests come from flux (see
+	// the webhook package).
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+func (n NotifierConfig) HideSecret() NotifierConfig {
+	if n.Secret != "" {
+		n.Secret = secretReplacement
+	}
+	return n
 }
 
 type RegistryConfig struct {
@@ -34,6 +99,78 @@ type RegistryConfig struct {
 	// username:password), to make it easy to copypasta from docker
 	// config.
 	Auths map[string]Auth `json:"auths" yaml:"auths"`
+	// Map of index host to the keys that sign its images, for those
+	// registries whose images should be checked for signatures.
+	Trust map[string]Trust `json:"trust,omitempty" yaml:"trust,omitempty"`
+}
+
+// Trust says how to check that the images from a registry are signed,
+// using Docker Content Trust.
+type Trust struct {
+	// The Notary server holding the signatures; if blank, the
+	// Docker Hub's (for images from the Docker Hub).
+	Server string `json:"server,omitempty" yaml:"server,omitempty"`
+	// PEM-encoded ECDSA public keys or certificates; an image is
+	// signed if its tag is signed by any one of them.
+	Keys []string `json:"keys" yaml:"keys"`
+	// SignedOnly stops automation releasing images from the
+	// registry unless they are signed.
+	SignedOnly bool `json:"signedOnly,omitempty" yaml:"signedOnly,omitempty"`
+}
+
+// GitSSHConfig says how to connect to an SSH remote, other than
+// directly on port 22.
+type GitSSHConfig struct {
+	// Port is used when the URL doesn't give one; e.g., for a remote
+	// given as `git@host:repo`.
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// JumpHost is `[user@]host[:port]` of a host to connect through.
+	// Its host key must be known, like the remote's, and it must
+	// accept the deploy key.
+	JumpHost string `json:"jumpHost,omitempty" yaml:"jumpHost,omitempty"`
+	// Options are other ssh options, as for `ssh -o Name=value`; only
+	// those that don't run commands or weaken host key checking may be
+	// given (e.g., ConnectTimeout or HostKeyAlias).
+	Options map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// PullRequestConfig says how to open pull requests (merge requests,
+// on GitLab) for the changes flux makes.
+type PullRequestConfig struct {
+	// Provider is "github" or "gitlab"; the host is that of the
+	// config repo, so GitHub Enterprise and self-hosted GitLab work
+	// too.
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	// Token is for the provider's API. If blank, the git token is
+	// used.
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+	// OnlyRefused says to push releases to the branch as usual, and
+	// to open a pull request only for an automated release whose push
+	// the branch refuses (e.g., because it's protected, or a hook
+	// rejects the change), so the update isn't lost.
+	OnlyRefused bool `json:"onlyRefused,omitempty" yaml:"onlyRefused,omitempty"`
+}
+
+// ProposeAll says whether every release is proposed in a pull
+// request, rather than pushed to the branch.
+func (c PullRequestConfig) ProposeAll() bool {
+	return c.Provider != "" && !c.OnlyRefused
+}
+
+const (
+	PullRequestGithub = "github"
+	PullRequestGitlab = "gitlab"
+)
+
+// HTTPConfig is for getting requests through proxies that want them
+// to carry particular headers.
+type HTTPConfig struct {
+	// UserAgent, if not blank, is given as the User-Agent of requests
+	// to registries and to HTTPS git remotes.
+	UserAgent string `json:"userAgent,omitempty" yaml:"userAgent,omitempty"`
+	// Headers are added to requests to registries and to HTTPS git
+	// remotes.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
 }
 
 type Auth struct {
@@ -44,6 +181,24 @@ type InstanceConfig struct {
 	Git      GitConfig      `json:"git" yaml:"git"`
 	Slack    NotifierConfig `json:"slack" yaml:"slack"`
 	Registry RegistryConfig `json:"registry" yaml:"registry"`
+	HTTP     HTTPConfig     `json:"http,omitempty" yaml:"http,omitempty"`
+	// Cluster is the name given to the instance's cluster in its
+	// events, so that events from several clusters can be told
+	// apart. If blank, the instance ID is used.
+	Cluster string `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	// Namespaces, if not empty, are the only namespaces whose
+	// services flux will release.
+	Namespaces []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// Architecture is that of the cluster's nodes, as named in
+	// multi-arch images (e.g., "arm64"). If blank, it's taken to be
+	// DefaultArchitecture.
+	Architecture string `json:"architecture,omitempty" yaml:"architecture,omitempty"`
+
+	// Inherited lists the fields whose values come from the
+	// service-wide defaults, rather than being set for the instance
+	// (e.g., "slack.hookURL", or "registry.auths.quay.io"). It's
+	// filled in when reading the config, and ignored when writing it.
+	Inherited []string `json:"inherited,omitempty" yaml:"inherited,omitempty"`
 }
 
 // As a safeguard, we make the default behaviour to hide secrets when
@@ -52,12 +207,21 @@ type InstanceConfig struct {
 type SafeInstanceConfig InstanceConfig
 type UnsafeInstanceConfig InstanceConfig
 
+// ClusterArchitecture gives the architecture of the cluster's nodes.
+func (c UnsafeInstanceConfig) ClusterArchitecture() string {
+	if c.Architecture == "" {
+		return DefaultArchitecture
+	}
+	return c.Architecture
+}
+
 func (c InstanceConfig) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.HideSecrets())
 }
 
 func (c InstanceConfig) HideSecrets() SafeInstanceConfig {
-	c.Git = c.Git.HideKey()
+	c.Git = c.Git.HideKey().HideToken()
+	c.Slack = c.Slack.HideSecret()
 	for host, auth := range c.Registry.Auths {
 		c.Registry.Auths[host] = auth.HidePassword()
 	}
@@ -76,12 +240,28 @@ func (a Auth) HidePassword() Auth {
 	return Auth{parts[0] + ":" + secretReplacement}
 }
 
+// HideKey replaces the passphrase with a placeholder, and a private
+// key with its public key. The public key of an encrypted key can
+// only be found with the passphrase, so if that's not given (or is
+// the placeholder), the key is replaced with the placeholder too.
 func (g GitConfig) HideKey() GitConfig {
+	passphrase, given := g.KeyPassphrase, g.HasKeyPassphrase()
+	if passphrase != "" {
+		g.KeyPassphrase = secretReplacement
+	}
 	if g.Key == "" {
 		return g
 	}
 	key, err := ssh.ParseRawPrivateKey([]byte(g.Key))
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted && given {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(g.Key), []byte(passphrase))
+	}
 	if err != nil {
+		// A public key, e.g., when the private key is kept
+		// elsewhere, needn't be hidden.
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(g.Key)); err == nil {
+			return g
+		}
 		g.Key = secretReplacement
 		return g
 	}
@@ -102,6 +282,101 @@ func (g GitConfig) HideKey() GitConfig {
 	return g
 }
 
+// HasKeyPassphrase says whether a passphrase for the key is given,
+// rather than none, or the placeholder shown in its place.
+func (g GitConfig) HasKeyPassphrase() bool {
+	return g.KeyPassphrase != "" && g.KeyPassphrase != secretReplacement
+}
+
+func (g GitConfig) HideToken() GitConfig {
+	if g.Token != "" {
+		g.Token = secretReplacement
+	}
+	if g.PullRequest.Token != "" {
+		g.PullRequest.Token = secretReplacement
+	}
+	if g.WebhookSecret != "" {
+		g.WebhookSecret = secretReplacement
+	}
+	return g
+}
+
+// The fields that can be given service-wide defaults, other than
+// registry credentials (which are inherited per host).
+var inheritableFields = []struct {
+	path  string
+	field func(*UnsafeInstanceConfig) *string
+}{
+	{"slack.hookURL", func(c *UnsafeInstanceConfig) *string { return &c.Slack.HookURL }},
+	{"slack.username", func(c *UnsafeInstanceConfig) *string { return &c.Slack.Username }},
+	{"slack.releaseTemplate", func(c *UnsafeInstanceConfig) *string { return &c.Slack.ReleaseTemplate }},
+	{"slack.secret", func(c *UnsafeInstanceConfig) *string { return &c.Slack.Secret }},
+	{"http.userAgent", func(c *UnsafeInstanceConfig) *string { return &c.HTTP.UserAgent }},
+}
+
+const inheritedAuthPrefix = "registry.auths."
+
+// WithDefaults fills in the fields that aren't set with the values
+// from the defaults given, and records which those are in Inherited.
+func (c UnsafeInstanceConfig) WithDefaults(defaults UnsafeInstanceConfig) UnsafeInstanceConfig {
+	c.Inherited = nil
+	for _, f := range inheritableFields {
+		if value := f.field(&c); *value == "" && *f.field(&defaults) != "" {
+			*value = *f.field(&defaults)
+			c.Inherited = append(c.Inherited, f.path)
+		}
+	}
+
+	if len(defaults.Registry.Auths) > 0 {
+		auths := map[string]Auth{}
+		for host, auth := range c.Registry.Auths {
+			auths[host] = auth
+		}
+		var hosts []string
+		for host := range defaults.Registry.Auths {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			if _, ok := auths[host]; !ok {
+				auths[host] = defaults.Registry.Auths[host]
+				c.Inherited = append(c.Inherited, inheritedAuthPrefix+host)
+			}
+		}
+		c.Registry.Auths = auths
+	}
+	return c
+}
+
+// WithoutDefaults is the reverse of WithDefaults: it clears the fields
+// listed in Inherited, unless they have been changed from the
+// defaults, so that only the instance's own values are kept.
+func (c UnsafeInstanceConfig) WithoutDefaults(defaults UnsafeInstanceConfig) UnsafeInstanceConfig {
+	inherited := map[string]bool{}
+	for _, path := range c.Inherited {
+		inherited[path] = true
+	}
+	c.Inherited = nil
+
+	for _, f := range inheritableFields {
+		if value := f.field(&c); inherited[f.path] && *value == *f.field(&defaults) {
+			*value = ""
+		}
+	}
+
+	if len(c.Registry.Auths) > 0 {
+		auths := map[string]Auth{}
+		for host, auth := range c.Registry.Auths {
+			if def, ok := defaults.Registry.Auths[host]; ok && inherited[inheritedAuthPrefix+host] && auth == def {
+				continue
+			}
+			auths[host] = auth
+		}
+		c.Registry.Auths = auths
+	}
+	return c
+}
+
 type untypedConfig map[string]interface{}
 
 func (uc untypedConfig) toUnsafeInstanceConfig() (UnsafeInstanceConfig, error) {
@@ -164,3 +439,48 @@ func applyPatch(uc untypedConfig, cp ConfigPatch) {
 		}
 	}
 }
+
+// PatchFrom gives the patch that makes this config out of the one
+// given, i.e., from.Patch(c.PatchFrom(from)) is c. Fields that are
+// the same in both are left out of the patch, so that, for instance,
+// secrets hidden in both aren't overwritten.
+func (uic UnsafeInstanceConfig) PatchFrom(from UnsafeInstanceConfig) (ConfigPatch, error) {
+	to, err := uic.toUntypedConfig()
+	if err != nil {
+		return nil, err
+	}
+	original, err := from.toUntypedConfig()
+	if err != nil {
+		return nil, err
+	}
+	return ConfigPatch(diffConfig(original, to)), nil
+}
+
+func diffConfig(from, to map[string]interface{}) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range to {
+		fromMap, fromOK := from[key].(map[string]interface{})
+		toMap, toOK := value.(map[string]interface{})
+		// A null map is the same as an empty one
+		if fromOK && value == nil {
+			toMap, toOK = map[string]interface{}{}, true
+		}
+		if toOK && from[key] == nil {
+			fromMap, fromOK = map[string]interface{}{}, true
+		}
+		switch {
+		case fromOK && toOK:
+			if sub := diffConfig(fromMap, toMap); len(sub) > 0 {
+				patch[key] = sub
+			}
+		case !reflect.DeepEqual(from[key], value):
+			patch[key] = value
+		}
+	}
+	return patch
+}
@@ -2,6 +2,7 @@ package flux
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -52,3 +53,119 @@ func TestConfig_Patch(t *testing.T) {
 		t.Fatal("auth config not patched")
 	}
 }
+
+func TestConfig_Defaults(t *testing.T) {
+	defaults := UnsafeInstanceConfig{
+		Slack: NotifierConfig{
+			HookURL:  "https://hooks.slack.com/default",
+			Username: "flux",
+		},
+		Registry: RegistryConfig{
+			Auths: map[string]Auth{
+				"quay.io": {Auth: "defaultauth"},
+			},
+		},
+	}
+	local := UnsafeInstanceConfig{
+		Slack: NotifierConfig{
+			Username: "my-flux",
+		},
+	}
+
+	effective := local.WithDefaults(defaults)
+	if effective.Slack.HookURL != defaults.Slack.HookURL || effective.Slack.Username != "my-flux" {
+		t.Errorf("expected hook URL inherited and username kept, got %+v", effective.Slack)
+	}
+	if effective.Registry.Auths["quay.io"].Auth != "defaultauth" {
+		t.Errorf("expected registry auth inherited, got %+v", effective.Registry)
+	}
+	expected := []string{"slack.hookURL", "registry.auths.quay.io"}
+	if !reflect.DeepEqual(expected, effective.Inherited) {
+		t.Errorf("expected inherited %v, got %v", expected, effective.Inherited)
+	}
+
+	// Writing back an unchanged config doesn't store inherited values
+	stored := effective.WithoutDefaults(defaults)
+	if stored.Slack.HookURL != "" || len(stored.Registry.Auths) != 0 || stored.Inherited != nil {
+		t.Errorf("expected inherited values not to be stored, got %+v", stored)
+	}
+	if stored.Slack.Username != "my-flux" {
+		t.Errorf("expected local value to be stored, got %+v", stored.Slack)
+	}
+
+	// Overriding an inherited value stores it; patching it to null
+	// resets it to the default
+	var cf ConfigPatch
+	if err := json.Unmarshal([]byte(`{"slack": {"hookURL": "https://hooks.slack.com/mine", "username": null}}`), &cf); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := effective.Patch(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored = patched.WithoutDefaults(defaults)
+	if stored.Slack.HookURL != "https://hooks.slack.com/mine" || stored.Slack.Username != "" {
+		t.Errorf("expected overridden hook URL and reset username, got %+v", stored.Slack)
+	}
+	if effective = stored.WithDefaults(defaults); effective.Slack.Username != "flux" {
+		t.Errorf("expected username reset to default, got %+v", effective.Slack)
+	}
+}
+
+func TestConfig_PatchFrom(t *testing.T) {
+	from := UnsafeInstanceConfig{
+		Git: GitConfig{
+			URL:   "git@github.com:weaveworks/flux-example",
+			Key:   secretReplacement,
+			Token: secretReplacement,
+		},
+		Registry: RegistryConfig{
+			Auths: map[string]Auth{
+				"quay.io":   {Auth: "quayauth"},
+				"docker.io": {Auth: "dockerauth"},
+			},
+		},
+		Namespaces: []string{"default"},
+	}
+	to := from
+	to.Git.URL = "git@github.com:weaveworks/flux-example-2"
+	to.Git.Token = ""
+	to.Registry.Auths = map[string]Auth{"quay.io": {Auth: "quayauth"}}
+	to.Namespaces = []string{"default", "prod"}
+
+	patch, err := to.PatchFrom(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ConfigPatch{
+		"git": map[string]interface{}{
+			"URL":   "git@github.com:weaveworks/flux-example-2",
+			"token": nil,
+		},
+		"registry": map[string]interface{}{
+			"auths": map[string]interface{}{"docker.io": nil},
+		},
+		"namespaces": []interface{}{"default", "prod"},
+	}
+	if !reflect.DeepEqual(expected, patch) {
+		t.Errorf("expected patch %#v, got %#v", expected, patch)
+	}
+
+	patched, err := from.Patch(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(to, patched) {
+		t.Errorf("expected patch to give %+v, got %+v", to, patched)
+	}
+
+	if patch, err := from.PatchFrom(from); err != nil || len(patch) > 0 {
+		t.Errorf("expected no patch for the same config, got %#v (%v)", patch, err)
+	}
+	empty := from
+	empty.Registry.Auths = map[string]Auth{}
+	from.Registry.Auths = nil
+	if patch, err := empty.PatchFrom(from); err != nil || len(patch) > 0 {
+		t.Errorf("expected no patch for an empty map in place of null, got %#v (%v)", patch, err)
+	}
+}
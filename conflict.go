@@ -0,0 +1,40 @@
+package flux
+
+// Conflict is what stopped a change flux made to the config repo (e.g.,
+// in a release) from being pushed: someone else pushed changes to the
+// same lines meanwhile, so it couldn't be put on top of them.
+type Conflict struct {
+	Files []ConflictFile `json:"files"`
+}
+
+// ConflictFile is a file in which the changes conflict. The path is
+// relative to the top of the repo; each hunk is a part of the file
+// that conflicts, with git's conflict markers around and between
+// their version (first) and flux's.
+type ConflictFile struct {
+	Path  string   `json:"path"`
+	Hunks []string `json:"hunks,omitempty"`
+}
+
+// ConflictOf gives the conflict that led to the error, if that's what
+// it was, looking through errors that wrap or explain others.
+func ConflictOf(err error) *Conflict {
+	for err != nil {
+		switch e := err.(type) {
+		case interface {
+			Conflict() Conflict
+		}:
+			conflict := e.Conflict()
+			return &conflict
+		case HelpfulError:
+			err = e.Base().Err
+		case interface {
+			Cause() error
+		}:
+			err = e.Cause()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
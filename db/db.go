@@ -41,13 +41,19 @@ func DriverForScheme(scheme string) string {
 
 // Make sure the database at the URL is up to date with respect to
 // migrations, or return an error. The migration scripts are taken
-// from `basedir/{scheme}`, with the scheme coming from the URL.
+// from `basedir/{driver}`, with the driver coming from the URL's
+// scheme.
 func Migrate(dburl, basedir string) (uint64, error) {
 	u, err := url.Parse(dburl)
 	if err != nil {
 		return 0, errors.Wrap(err, "parsing database URL")
 	}
-	migrationsPath := filepath.Join(basedir, DriverForScheme(u.Scheme))
+	// ql's in-memory databases have the same schema as its files.
+	driver := DriverForScheme(u.Scheme)
+	if driver == "ql-mem" {
+		driver = "ql"
+	}
+	migrationsPath := filepath.Join(basedir, driver)
 	if _, err := os.Stat(migrationsPath); err != nil {
 		if os.IsNotExist(err) {
 			return 0, errors.Wrapf(err, "migrations dir %s does not exist; driver %s not supported", migrationsPath, u.Scheme)
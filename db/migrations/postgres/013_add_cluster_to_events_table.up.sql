@@ -0,0 +1,2 @@
+ALTER TABLE events
+  ADD cluster text;
@@ -0,0 +1,6 @@
+-- When each event was recorded, by the database's clock, as opposed to
+-- the clock of whatever logged it. Earlier events are taken to have
+-- been recorded when they started.
+ALTER TABLE events
+  ADD received_at timestamp with time zone NOT NULL DEFAULT now();
+UPDATE events SET received_at = started_at;
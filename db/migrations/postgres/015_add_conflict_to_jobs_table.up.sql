@@ -0,0 +1,2 @@
+ALTER TABLE jobs
+  ADD conflict jsonb default NULL;
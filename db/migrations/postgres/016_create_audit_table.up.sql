@@ -0,0 +1,14 @@
+-- The audit log of API calls that change things. Entries are only
+-- ever added; nothing updates or deletes them.
+CREATE TABLE IF NOT EXISTS audit (
+    instance_id  text                      NOT NULL,
+    stamp        timestamp with time zone  NOT NULL,
+    route        text                      NOT NULL,
+    target       text                      NOT NULL DEFAULT '',
+    token        text                      NOT NULL DEFAULT '',
+    user_name    text                      NOT NULL DEFAULT '',
+    body_digest  text                      NOT NULL DEFAULT '',
+    status_code  integer                   NOT NULL
+);
+
+CREATE INDEX audit_instance_stamp_idx ON audit (instance_id, stamp);
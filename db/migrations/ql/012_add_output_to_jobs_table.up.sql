@@ -0,0 +1,2 @@
+ALTER TABLE jobs
+  ADD output string;
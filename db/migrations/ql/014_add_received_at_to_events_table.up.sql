@@ -0,0 +1,4 @@
+ALTER TABLE events
+  ADD received_at time;
+UPDATE events
+  received_at = started_at;
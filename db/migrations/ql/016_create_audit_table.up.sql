@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS audit (
+    instance_id  string  NOT NULL,
+    stamp        time    NOT NULL,
+    route        string  NOT NULL,
+    target       string  NOT NULL DEFAULT "",
+    token        string  NOT NULL DEFAULT "",
+    user_name    string  NOT NULL DEFAULT "",
+    body_digest  string  NOT NULL DEFAULT "",
+    status_code  int     NOT NULL,
+);
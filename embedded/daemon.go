@@ -0,0 +1,314 @@
+// Package embedded runs flux's GitOps engine in-process, for programs
+// (e.g., controllers of their own) that want to sync a cluster from a
+// config repo, and release into it, without running fluxsvc and fluxd.
+//
+// It's the engine without the service around it: there's a single
+// instance, whose config is kept in memory; there's no job queue, so
+// a release runs to the end before Release returns; and there's no
+// automation. History events go to the logger, unless given somewhere
+// to go.
+package embedded
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/history"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes"
+	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/release"
+)
+
+// DefaultSyncInterval is how often a started daemon syncs, if its
+// config doesn't say.
+const DefaultSyncInterval = 5 * time.Minute
+
+// InstanceID is the instance an embedded daemon is, e.g., in the
+// events it records.
+const InstanceID flux.InstanceID = "embedded"
+
+var (
+	ErrStarted = errors.New("daemon already started")
+	ErrClosed  = errors.New("daemon closed")
+)
+
+// Config is what an embedded daemon works with. Platform and Repo
+// must be given; the rest are optional.
+type Config struct {
+	// Platform is the cluster synced and released to.
+	Platform platform.Platform
+	// Repo is the config repo. The resource definitions are those
+	// under its Path.
+	Repo git.Repo
+	// Settings are the rest of the instance's config: e.g., the
+	// layout of the repo, whether releases make a pull request
+	// rather than pushing to the branch, and the Slack webhook for
+	// notifications. The repo itself is Repo, not Settings.Git.URL.
+	Settings flux.UnsafeInstanceConfig
+	// Registry finds images, for releases that change them; without
+	// it, only releases that don't (e.g., of values) can be done.
+	Registry registry.Registry
+	// Events, if not nil, records the history (releases, and the
+	// like); otherwise, events are logged.
+	Events history.EventWriter
+	// VerifySignatures, if true, stops a sync applying anything
+	// unless the commit is signed by a key in gpg's keyring.
+	VerifySignatures bool
+	// SyncInterval is how often a started daemon syncs; the default
+	// is DefaultSyncInterval.
+	SyncInterval time.Duration
+	Logger       log.Logger
+}
+
+// Daemon is an embedded flux. It's safe to use from more than one
+// goroutine; syncs and releases are done one at a time, so a sync
+// doesn't apply what was in the repo before a release on top of it.
+type Daemon struct {
+	config   Config
+	settings instance.Configurer
+	releaser *release.Releaser
+	logger   log.Logger
+
+	mu sync.Mutex // held while syncing or releasing
+
+	loop    sync.Mutex // guards the rest
+	started bool
+	closed  bool
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// SyncResult is what a sync did.
+type SyncResult struct {
+	// Revision is the commit synced.
+	Revision string
+	// Resources is how many resources are defined, all of which are
+	// applied (or deleted, if they were defined before and aren't
+	// now).
+	Resources int
+}
+
+// New makes a daemon, which does nothing until it's started or asked
+// to sync or release.
+func New(config Config) (*Daemon, error) {
+	if config.Platform == nil {
+		return nil, errors.New("no platform given")
+	}
+	if config.Repo.URL == "" {
+		return nil, errors.New("no config repo URL given")
+	}
+	if config.SyncInterval == 0 {
+		config.SyncInterval = DefaultSyncInterval
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Registry == nil {
+		config.Registry = noRegistry{}
+	}
+	if config.Events == nil {
+		config.Events = loggedEvents{log.NewContext(config.Logger).With("component", "history")}
+	}
+
+	settings := instance.MakeConfig()
+	settings.Settings = config.Settings
+	d := &Daemon{
+		config:   config,
+		settings: &memoryConfig{config: settings},
+		logger:   config.Logger,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	d.releaser = release.NewReleaser(singleInstance{d}, false)
+	return d, nil
+}
+
+// Start syncs now, and then every SyncInterval, until the daemon is
+// closed. Problems syncing are logged, since there's no one to give
+// them to; call Sync to see them.
+func (d *Daemon) Start() error {
+	d.loop.Lock()
+	defer d.loop.Unlock()
+	switch {
+	case d.closed:
+		return ErrClosed
+	case d.started:
+		return ErrStarted
+	}
+	d.started = true
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.config.SyncInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := d.Sync(); err != nil {
+				d.logger.Log("component", "sync", "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Sync clones the config repo and applies everything defined in it
+// to the platform. If some resources couldn't be applied, the error
+// is a platform.SyncError saying which, and why.
+func (d *Daemon) Sync() (SyncResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	logger := log.NewContext(d.logger).With("component", "sync")
+	repo := d.config.Repo
+	path, err := repo.Clone()
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer git.Clean(path)
+
+	revision, err := repo.HeadRevision(path)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result := SyncResult{Revision: revision}
+	if d.config.VerifySignatures {
+		if err := repo.VerifyRevision(path, revision); err != nil {
+			logger.Log("revision", revision, "verified", false)
+			return result, err
+		}
+		logger.Log("revision", revision, "verified", true)
+	}
+	def, err := kubernetes.SyncDefFromFiles(filepath.Join(path, repo.Path))
+	if err != nil {
+		return result, err
+	}
+	result.Resources = len(def.Actions)
+	logger.Log("revision", revision, "resources", result.Resources)
+
+	if err := d.config.Platform.Sync(def); err != nil {
+		return result, err
+	}
+	logger.Log("synced", result.Resources)
+	return result, nil
+}
+
+// Release does a release, as fluxctl release would, and gives the
+// result for each service considered. Blue/green releases can't be
+// done, since they carry on after the copies have started.
+func (d *Daemon) Release(spec flux.ReleaseSpec, cause flux.ReleaseCause) (flux.ReleaseResult, error) {
+	if problems := spec.Problems(); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid release: %s", problems[0])
+	}
+	if spec.Kind == flux.ReleaseKindBlueGreen {
+		return nil, errors.New("blue/green releases need fluxsvc, to carry them on")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UTC()
+	job := jobs.Job{
+		Instance:  InstanceID,
+		ID:        jobs.NewJobID(),
+		Method:    jobs.ReleaseJob,
+		Params:    jobs.ReleaseJobParams{ReleaseSpec: spec, Cause: cause},
+		Submitted: now,
+		Claimed:   now,
+	}
+	updates := &jobLogger{logger: log.NewContext(d.logger).With("component", "release", "release-id", job.ID)}
+	_, err := d.releaser.Handle(&job, updates)
+	result, _ := job.Result.(flux.ReleaseResult)
+	return result, err
+}
+
+// Close stops the daemon syncing, waiting for a sync underway to
+// finish. The daemon can't be used after.
+func (d *Daemon) Close() error {
+	d.loop.Lock()
+	defer d.loop.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	close(d.quit)
+	if d.started {
+		<-d.done
+	}
+	return nil
+}
+
+// singleInstance gives the daemon as the only instance there is.
+type singleInstance struct {
+	d *Daemon
+}
+
+func (s singleInstance) Get(inst flux.InstanceID) (*instance.Instance, error) {
+	if inst != InstanceID {
+		return nil, fmt.Errorf("no instance %q", inst)
+	}
+	return instance.New(
+		s.d.config.Platform,
+		s.d.config.Registry,
+		s.d.settings,
+		s.d.config.Repo,
+		log.NewContext(s.d.logger).With("instanceID", inst),
+		noHistory{},
+		s.d.config.Events,
+	), nil
+}
+
+// memoryConfig keeps the instance config in memory.
+type memoryConfig struct {
+	mu     sync.Mutex
+	config instance.Config
+}
+
+func (c *memoryConfig) Get() (instance.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config, nil
+}
+
+func (c *memoryConfig) Update(update instance.UpdateFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config, err := update(c.config)
+	if err != nil {
+		return err
+	}
+	c.config = config
+	return nil
+}
+
+// jobLogger logs the status of a release as it changes, in place of
+// recording it in the job queue.
+type jobLogger struct {
+	logger log.Logger
+	status string
+}
+
+func (l *jobLogger) UpdateJob(job jobs.Job) error {
+	if job.Status != l.status {
+		l.status = job.Status
+		l.logger.Log("status", job.Status)
+	}
+	return nil
+}
+
+func (l *jobLogger) Heartbeat(jobs.JobID) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package embedded
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
+)
+
+func setupRepo(t *testing.T) (git.Repo, func()) {
+	dir, cleanup := testfiles.TempDir(t)
+	filesDir := filepath.Join(dir, "files")
+	gitDir := filepath.Join(dir, "git")
+	for _, cmd := range [][]string{
+		{"mkdir", filesDir},
+		{"git", "-C", filesDir, "init"},
+		{"write files"},
+		{"git", "-C", filesDir, "add", "--all"},
+		{"git", "-C", filesDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-m", "Initial revision"},
+		{"git", "clone", "--bare", filesDir, gitDir},
+	} {
+		var err error
+		if cmd[0] == "write files" {
+			err = testfiles.WriteTestFiles(filesDir)
+		} else {
+			c := exec.Command(cmd[0], cmd[1:]...)
+			c.Stdout, c.Stderr = ioutil.Discard, ioutil.Discard
+			err = c.Run()
+		}
+		if err != nil {
+			cleanup()
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	return git.Repo{URL: gitDir, Branch: "master"}, cleanup
+}
+
+func TestSync(t *testing.T) {
+	repo, cleanup := setupRepo(t)
+	defer cleanup()
+
+	var synced platform.SyncDef
+	p := &platform.MockPlatform{
+		SyncArgTest: func(def platform.SyncDef) error {
+			synced = def
+			return nil
+		},
+	}
+	d, err := New(Config{Platform: p, Repo: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	result, err := d.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Revision == "" {
+		t.Error("expected the revision synced")
+	}
+	if result.Resources == 0 || result.Resources != len(synced.Actions) {
+		t.Errorf("expected %d resources, got %d", len(synced.Actions), result.Resources)
+	}
+
+	p.SyncError = platform.SyncError{"default/helloworld": platform.ErrEmptySelector}
+	if _, err := d.Sync(); err == nil {
+		t.Error("expected the sync error")
+	}
+}
+
+func TestStartAndClose(t *testing.T) {
+	repo, cleanup := setupRepo(t)
+	defer cleanup()
+
+	syncs := make(chan struct{}, 10)
+	p := &platform.MockPlatform{
+		SyncArgTest: func(platform.SyncDef) error {
+			syncs <- struct{}{}
+			return nil
+		},
+	}
+	d, err := New(Config{Platform: p, Repo: repo, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != ErrStarted {
+		t.Errorf("expected ErrStarted starting again, got %v", err)
+	}
+	select {
+	case <-syncs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a sync on starting")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != ErrClosed {
+		t.Errorf("expected ErrClosed starting after closing, got %v", err)
+	}
+}
+
+// Releases that go through to the releaser are tested in package
+// release; these are the ones refused before getting there.
+func TestReleaseRefused(t *testing.T) {
+	d, err := New(Config{Platform: &platform.MockPlatform{}, Repo: git.Repo{URL: "git@example.com:config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	spec := flux.ReleaseSpec{
+		ServiceSpecs: []flux.ServiceSpec{"default/helloworld"},
+		ImageSpec:    "quay.io/weaveworks/helloworld:master-a000002",
+		Kind:         flux.ReleaseKindBlueGreen,
+	}
+	if _, err := d.Release(spec, flux.ReleaseCause{}); err == nil {
+		t.Error("expected a blue/green release to be refused")
+	}
+	spec.Kind = flux.ReleaseKindExecute
+	spec.ServiceSpecs = nil
+	if _, err := d.Release(spec, flux.ReleaseCause{}); err == nil {
+		t.Error("expected an invalid release to be refused")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Repo: git.Repo{URL: "git@example.com:config"}}); err == nil {
+		t.Error("expected an error with no platform")
+	}
+	if _, err := New(Config{Platform: &platform.MockPlatform{}}); err == nil {
+		t.Error("expected an error with no repo")
+	}
+}
@@ -0,0 +1,67 @@
+package embedded
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
+	"github.com/weaveworks/flux/registry"
+)
+
+var errNoRegistry = errors.New("no registry given, so images can't be looked up")
+
+// noRegistry stands in for a registry when none is given.
+type noRegistry struct{}
+
+func (noRegistry) GetRepository(registry.Repository) ([]flux.Image, error) {
+	return nil, errNoRegistry
+}
+
+func (noRegistry) GetImage(registry.Repository, string) (flux.Image, error) {
+	return flux.Image{}, errNoRegistry
+}
+
+func (noRegistry) GetImageDigest(registry.Repository, string) (string, error) {
+	return "", errNoRegistry
+}
+
+func (noRegistry) GetImagePlatforms(registry.Repository, string) ([]flux.ImagePlatform, error) {
+	return nil, errNoRegistry
+}
+
+// noHistory is the history kept by an embedded daemon: none. Events
+// are recorded elsewhere, if anywhere.
+type noHistory struct{}
+
+func (noHistory) AllEvents(time.Time, int64) ([]flux.Event, error) {
+	return nil, nil
+}
+
+func (noHistory) EventsForService(flux.ServiceID, time.Time, int64) ([]flux.Event, error) {
+	return nil, nil
+}
+
+func (noHistory) Events(history.EventQuery) ([]flux.Event, error) {
+	return nil, nil
+}
+
+func (noHistory) CountEvents(history.EventQuery) (int64, error) {
+	return 0, nil
+}
+
+func (noHistory) GetEvent(id flux.EventID) (flux.Event, error) {
+	return flux.Event{}, fmt.Errorf("no event %d", id)
+}
+
+// loggedEvents records events by logging them.
+type loggedEvents struct {
+	logger log.Logger
+}
+
+func (l loggedEvents) LogEvent(e flux.Event) error {
+	return l.logger.Log("type", e.Type, "event", e.String())
+}
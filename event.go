@@ -14,6 +14,16 @@ const (
 	EventDeautomate = "deautomate"
 	EventLock       = "lock"
 	EventUnlock     = "unlock"
+	EventCheckpoint = "checkpoint"
+	EventLint       = "lint"
+	EventThrottle   = "throttle"
+	EventThrottled  = "throttled"
+	EventTagFilter  = "tag_filter"
+	EventPause      = "pause"
+	EventResume     = "resume"
+	EventBranch     = "branch"
+	EventSyncStale  = "sync_stale"
+	EventBlueGreen  = "bluegreen"
 
 	LogLevelDebug = "debug"
 	LogLevelInfo  = "info"
@@ -21,6 +31,23 @@ const (
 	LogLevelError = "error"
 )
 
+var eventTypes = []string{
+	EventRelease, EventAutomate, EventDeautomate, EventLock, EventUnlock,
+	EventCheckpoint, EventLint, EventThrottle, EventThrottled, EventTagFilter,
+	EventPause, EventResume, EventBranch, EventSyncStale, EventBlueGreen,
+}
+
+// IsEventType says whether the string given is one of the types of
+// events.
+func IsEventType(s string) bool {
+	for _, t := range eventTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
 type EventID int64
 
 type Event struct {
@@ -30,6 +57,10 @@ type Event struct {
 	// ServiceIDs affected by this event.
 	ServiceIDs []ServiceID `json:"serviceIDs"`
 
+	// Cluster names the cluster the event happened in. It's the
+	// instance ID, unless the instance is configured with a name.
+	Cluster string `json:"cluster,omitempty"`
+
 	// Type is the type of event, usually "release" for now, but could be other
 	// things later
 	Type string `json:"type"`
@@ -41,6 +72,17 @@ type Event struct {
 	// be the same as StartedAt.
 	EndedAt time.Time `json:"endedAt"`
 
+	// ReceivedAt is when the event was recorded. StartedAt and EndedAt
+	// are as given by whatever logged the event, whose clock may be
+	// off; ReceivedAt is always by the service's (or its database's)
+	// clock.
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// Sequence orders the events of an instance by when they were
+	// recorded: an event recorded later has a greater sequence,
+	// whatever its timestamps say. Events are listed in this order.
+	Sequence int64 `json:"sequence"`
+
 	// LogLevel for this event. Used to indicate how important it is.
 	// `debug|info|warn|error`
 	LogLevel string `json:"logLevel"`
@@ -82,6 +124,10 @@ func (e Event) String() string {
 				strServiceIDs = []string{"all services"}
 				break
 			}
+			if spec == ServiceSpecAutomated {
+				strServiceIDs = []string{"all automated services"}
+				break
+			}
 		}
 		if len(strServiceIDs) == 0 {
 			strServiceIDs = []string{"no services"}
@@ -109,6 +155,46 @@ func (e Event) String() string {
 		return fmt.Sprintf("Locked: %s", strings.Join(strServiceIDs, ", "))
 	case EventUnlock:
 		return fmt.Sprintf("Unlocked: %s", strings.Join(strServiceIDs, ", "))
+	case EventCheckpoint:
+		if metadata, ok := e.Metadata.(CheckpointStatus); ok && metadata.Outdated {
+			return fmt.Sprintf("New flux version available: %s", metadata.LatestVersion)
+		}
+		return "Flux advisory"
+	case EventLint:
+		if metadata, ok := e.Metadata.(LintReport); ok {
+			revision := metadata.Revision
+			if len(revision) > 7 {
+				revision = revision[:7]
+			}
+			return fmt.Sprintf("Lint: %d problem(s) in config repo at %s", len(metadata.Problems), revision)
+		}
+		return "Lint problems in config repo"
+	case EventThrottled:
+		if metadata, ok := e.Metadata.(ThrottledEventMetadata); ok {
+			return fmt.Sprintf("Throttled: not releasing %s to %s before %s", metadata.Image, strings.Join(strServiceIDs, ", "), metadata.LastRelease.Add(metadata.Interval).Format(time.RFC822))
+		}
+		return fmt.Sprintf("Throttled: %s", strings.Join(strServiceIDs, ", "))
+	case EventBranch:
+		if metadata, ok := e.Metadata.(BranchEventMetadata); ok {
+			revision := metadata.Revision
+			if len(revision) > 7 {
+				revision = revision[:7]
+			}
+			return fmt.Sprintf("Created branch %s of config repo, at %s", metadata.Branch, revision)
+		}
+		return "Created branch of config repo"
+	case EventBlueGreen:
+		if metadata, ok := e.Metadata.(BlueGreenEventMetadata); ok {
+			switch metadata.Phase {
+			case BlueGreenStarted:
+				return fmt.Sprintf("Blue/green release %s: started copies of %s", metadata.ReleaseID, strings.Join(strServiceIDs, ", "))
+			case BlueGreenSwitched:
+				return fmt.Sprintf("Blue/green release %s: switched %s to new images", metadata.ReleaseID, strings.Join(strServiceIDs, ", "))
+			case BlueGreenAborted:
+				return fmt.Sprintf("Blue/green release %s: aborted for %s: %s", metadata.ReleaseID, strings.Join(strServiceIDs, ", "), metadata.Error)
+			}
+		}
+		return fmt.Sprintf("Blue/green release: %s", strings.Join(strServiceIDs, ", "))
 	default:
 		return "Unknown event"
 	}
@@ -121,3 +207,37 @@ type ReleaseEventMetadata struct {
 	// Message of the error if there was one.
 	Error string `json:"error,omitempty"`
 }
+
+// BranchEventMetadata is the metadata for when the branch of the
+// config repo didn't exist, and was created from the default branch.
+type BranchEventMetadata struct {
+	Branch   string `json:"branch"`
+	Revision string `json:"revision"`
+}
+
+// The phases of a blue/green release, each of which has an event.
+const (
+	BlueGreenStarted  = "started"
+	BlueGreenSwitched = "switched"
+	BlueGreenAborted  = "aborted"
+)
+
+// BlueGreenEventMetadata is the metadata for each phase of a
+// blue/green release: when the copies of the services' workloads are
+// started, and when the services are switched to the new images (or
+// the copies are removed because they didn't become ready).
+type BlueGreenEventMetadata struct {
+	ReleaseID ReleaseID `json:"releaseID"`
+	Phase     string    `json:"phase"`
+	Revision  string    `json:"revision,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ThrottledEventMetadata is the metadata for when an automated
+// release of a service is skipped, because the service was released
+// more recently than its minimum release interval allows.
+type ThrottledEventMetadata struct {
+	Image       ImageID       `json:"image"`
+	LastRelease time.Time     `json:"lastRelease"`
+	Interval    time.Duration `json:"interval"`
+}
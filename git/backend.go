@@ -0,0 +1,92 @@
+package git
+
+import (
+	"context"
+)
+
+// Backend does the git operations on a repo and its clones: cloning
+// it, committing and pushing changes, and reading history. The
+// default runs the git binary; another could do the work in-process
+// (e.g., with go-git), so the binary, ssh and their configuration
+// needn't be installed.
+//
+// The operations take the repo's credentials, which aren't exported,
+// so backends are implemented in this package. Mirrors (see Mirror)
+// always use the git binary.
+type Backend interface {
+	// clone makes a clone of the branch of the repo in workingDir,
+	// and gives its path. If sparsePath isn't blank, only it (and
+	// the files at the top) are checked out.
+	clone(ctx context.Context, workingDir string, a auth, repoURL, repoBranch, sparsePath string, submodules bool) (string, error)
+	// check says whether there are changes in the clone, under
+	// subdir.
+	check(ctx context.Context, workingDir, subdir string) bool
+	add(ctx context.Context, workingDir string, paths ...string) error
+	commit(ctx context.Context, workingDir, commitMessage, author, signingKey string) error
+	push(ctx context.Context, a auth, refspec, workingDir string) error
+	// rebase rebases what's been committed in the clone onto the
+	// branch as it is in the repo now.
+	rebase(ctx context.Context, a auth, workingDir, branch, signingKey string) error
+	revision(ctx context.Context, workingDir string) (string, error)
+	tree(ctx context.Context, workingDir, subdir string) (string, error)
+	branchExists(ctx context.Context, a auth, repoURL, branch string) (bool, error)
+	unshallow(ctx context.Context, a auth, workingDir string) error
+	blame(ctx context.Context, workingDir, file string) ([]BlameLine, error)
+}
+
+// ExecBackend runs the git binary. It's the backend for repos which
+// don't give one.
+var ExecBackend Backend = execBackend{}
+
+func (r Repo) backend() Backend {
+	if r.Backend == nil {
+		return ExecBackend
+	}
+	return r.Backend
+}
+
+type execBackend struct{}
+
+func (execBackend) clone(ctx context.Context, workingDir string, a auth, repoURL, repoBranch, sparsePath string, submodules bool) (string, error) {
+	return clone(ctx, workingDir, a, repoURL, repoBranch, sparsePath, submodules)
+}
+
+func (execBackend) check(ctx context.Context, workingDir, subdir string) bool {
+	return check(ctx, workingDir, subdir)
+}
+
+func (execBackend) add(ctx context.Context, workingDir string, paths ...string) error {
+	return add(ctx, workingDir, paths...)
+}
+
+func (execBackend) commit(ctx context.Context, workingDir, commitMessage, author, signingKey string) error {
+	return commit(ctx, workingDir, commitMessage, author, signingKey)
+}
+
+func (execBackend) push(ctx context.Context, a auth, refspec, workingDir string) error {
+	return push(ctx, a, refspec, workingDir)
+}
+
+func (execBackend) rebase(ctx context.Context, a auth, workingDir, branch, signingKey string) error {
+	return rebase(ctx, a, workingDir, branch, signingKey)
+}
+
+func (execBackend) revision(ctx context.Context, workingDir string) (string, error) {
+	return revision(ctx, workingDir)
+}
+
+func (execBackend) tree(ctx context.Context, workingDir, subdir string) (string, error) {
+	return tree(ctx, workingDir, subdir)
+}
+
+func (execBackend) branchExists(ctx context.Context, a auth, repoURL, branch string) (bool, error) {
+	return branchExists(ctx, a, repoURL, branch)
+}
+
+func (execBackend) unshallow(ctx context.Context, a auth, workingDir string) error {
+	return unshallow(ctx, a, workingDir)
+}
+
+func (execBackend) blame(ctx context.Context, workingDir, file string) ([]BlameLine, error) {
+	return blame(ctx, workingDir, file)
+}
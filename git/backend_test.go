@@ -0,0 +1,52 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingBackend runs the git binary, counting the commits and
+// pushes it's asked to do.
+type countingBackend struct {
+	execBackend
+	commits, pushes int
+}
+
+func (b *countingBackend) commit(ctx context.Context, workingDir, commitMessage, author, signingKey string) error {
+	b.commits++
+	return b.execBackend.commit(ctx, workingDir, commitMessage, author, signingKey)
+}
+
+func (b *countingBackend) push(ctx context.Context, a auth, refspec, workingDir string) error {
+	b.pushes++
+	return b.execBackend.push(ctx, a, refspec, workingDir)
+}
+
+func TestRepoBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-backend-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := &countingBackend{}
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master", Backend: backend}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPush(working, "Scale up"); err != nil {
+		t.Fatal(err)
+	}
+	if backend.commits != 1 || backend.pushes != 1 {
+		t.Errorf("expected the repo's backend to commit and push once, got %d commits and %d pushes", backend.commits, backend.pushes)
+	}
+}
@@ -1,11 +1,164 @@
 package git
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/weaveworks/flux"
 )
 
+// ErrorKind is the class of an error from git, so that callers can
+// deal with e.g., a timeout differently from a key without access.
+type ErrorKind string
+
+const (
+	// UnknownError is any error not in one of the classes below.
+	UnknownError ErrorKind = ""
+	// AuthFailed is for when the remote refused the key or token,
+	// or its host key wasn't known.
+	AuthFailed ErrorKind = "auth-failed"
+	// RefNotFound is for when the branch (or other ref) asked for
+	// isn't in the repo.
+	RefNotFound ErrorKind = "ref-not-found"
+	// NonFastForward is for a push rejected because the branch had
+	// moved on since it was cloned.
+	NonFastForward ErrorKind = "non-fast-forward"
+	// Conflict is for commits that couldn't be put on top of the
+	// branch after it moved on, because they change the same lines.
+	Conflict ErrorKind = "conflict"
+	// NetworkTimeout is for when the remote couldn't be reached, or
+	// the operation took longer than it's allowed.
+	NetworkTimeout ErrorKind = "network-timeout"
+	// LFSFailed is for when files kept in git LFS couldn't be
+	// fetched, e.g., because git-lfs isn't installed.
+	LFSFailed ErrorKind = "lfs-failed"
+	// PushRejected is for a push the remote refused by its own rules,
+	// e.g., because the branch is protected, or a hook rejected the
+	// change.
+	PushRejected ErrorKind = "push-rejected"
+)
+
+// Error is an error from running git. It reads as the message git
+// gave, and has its class, exit code, and everything it said.
+type Error struct {
+	Kind     ErrorKind
+	Message  string
+	ExitCode int // -1 if git didn't exit by itself (e.g., was killed)
+	Stderr   string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// KindOf gives the class of the error, which may have been wrapped,
+// or given as the cause of e.g., a CloningError.
+func KindOf(err error) ErrorKind {
+	for err != nil {
+		if err == context.DeadlineExceeded {
+			return NetworkTimeout
+		}
+		switch e := err.(type) {
+		case *Error:
+			return e.Kind
+		case *ConflictError:
+			return Conflict
+		case flux.HelpfulError:
+			err = e.Base().Err
+		case interface {
+			Cause() error
+		}:
+			err = e.Cause()
+		default:
+			return UnknownError
+		}
+	}
+	return UnknownError
+}
+
+// ConflictError is the error from rebasing onto a branch that has
+// moved on, when the commits rebased change the same lines as those
+// pushed meanwhile. It has the conflicting files, as they were when
+// the rebase stopped.
+type ConflictError struct {
+	Files []flux.ConflictFile
+	Err   error
+}
+
+func (e *ConflictError) Error() string {
+	paths := make([]string, len(e.Files))
+	for i, f := range e.Files {
+		paths[i] = f.Path
+	}
+	return fmt.Sprintf("%s: conflicts in %s", e.Err, strings.Join(paths, ", "))
+}
+
+// Conflict gives the conflicting files, for reporting.
+func (e *ConflictError) Conflict() flux.Conflict {
+	return flux.Conflict{Files: e.Files}
+}
+
+// What git (or ssh, or curl) says for each class of error.
+var errorPatterns = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{PushRejected, []string{
+		"protected branch hook declined",
+		"protected branch update failed",
+		"not allowed to push code to protected branches",
+		"pre-receive hook declined",
+		"push declined",
+	}},
+	{AuthFailed, []string{
+		"permission denied",
+		"authentication failed",
+		"could not read username",
+		"could not read password",
+		"host key verification failed",
+		"the requested url returned error: 401",
+		"the requested url returned error: 403",
+	}},
+	{RefNotFound, []string{
+		"not found in upstream",
+		"couldn't find remote ref",
+		"unknown revision",
+		"not a valid object name",
+	}},
+	{NetworkTimeout, []string{
+		"timed out",
+		"i/o timeout",
+		"could not resolve host",
+		"could not resolve hostname",
+		"connection refused",
+		"failed to connect",
+		"network is unreachable",
+		"the remote end hung up unexpectedly",
+	}},
+	{LFSFailed, []string{
+		"smudge filter lfs failed",
+		"git-lfs",
+	}},
+}
+
+// classify gives the class of error from what git said.
+func classify(stderr string) ErrorKind {
+	if isNonFastForward(stderr) {
+		return NonFastForward
+	}
+	lower := strings.ToLower(stderr)
+	for _, class := range errorPatterns {
+		for _, pattern := range class.patterns {
+			if strings.Contains(lower, pattern) {
+				return class.kind
+			}
+		}
+	}
+	return UnknownError
+}
+
 var NoRepoError = flux.UserConfigProblem{&flux.BaseError{
 	Err: errors.New("no repo in user config"),
 	Help: `No Git repository URL in your config
@@ -19,17 +172,67 @@ described in
 `,
 }}
 
+// ErrReadOnly is returned for any attempt to commit and push to a repo
+// that flux has been told is read-only.
+var ErrReadOnly = flux.UserConfigProblem{BaseError: &flux.BaseError{
+	Err: errors.New("git repo is read-only"),
+	Help: `The git repository is read-only
+
+Flux is configured not to write to your git repository (readOnly is
+set in the git config), so it can't commit and push the changes asked
+for. It still reads the repository, e.g., to apply what's in it.
+
+To let flux make changes, unset readOnly in the git config, e.g., with
+
+    fluxctl edit-config
+
+and make sure the deploy key (or token) can push to the repository.
+
+`,
+}}
+
+// ErrKeyPassphraseMissing is returned when the deploy key is
+// encrypted, and there's no passphrase for it.
+var ErrKeyPassphraseMissing = flux.UserConfigProblem{BaseError: &flux.BaseError{
+	Err: errors.New("deploy key is encrypted, and no passphrase was given"),
+	Help: `The deploy key needs a passphrase
+
+The private key given for your git repository is encrypted with a
+passphrase, and flux hasn't been given it, so can't use the key. Either
+supply the passphrase as keyPassphrase in the git config, e.g., with
+
+    fluxctl edit-config
+
+or give a key without a passphrase.
+
+`,
+}}
+
+// ErrKeyPassphraseWrong is returned when the passphrase given for the
+// deploy key doesn't open it.
+var ErrKeyPassphraseWrong = flux.UserConfigProblem{BaseError: &flux.BaseError{
+	Err: errors.New("wrong passphrase for deploy key"),
+	Help: `The deploy key's passphrase is wrong
+
+The passphrase given (as keyPassphrase in the git config) doesn't open
+the private key given for your git repository. Please check it, and
+update it with
+
+    fluxctl edit-config
+
+`,
+}}
+
 func CloningError(url string, actual error) error {
-	return flux.UserConfigProblem{&flux.BaseError{
-		Err: actual,
-		Help: `Problem cloning your git repository
+	help := `Problem cloning your git repository
 
 There was a problem cloning your git repository,
 
     ` + url + `
 
-This may be because you have not supplied a valid deploy key, or
-because the repository has been moved, deleted, or never existed.
+This may be because you have not supplied a valid deploy key (or, for
+an HTTPS URL, username and token), or because the repository has been
+moved, deleted, or never existed.
 
 Please check that there is a repository at the address above, and that
 there is a deploy key with write permissions to the repository. In
@@ -38,14 +241,58 @@ cross-check with the fingerprint given by
 
     fluxctl get-config --fingerprint=md5
 
-`,
-	}}
+`
+	switch KindOf(actual) {
+	case RefNotFound:
+		help = `Branch not found in your git repository
+
+The branch flux is configured to use isn't in your git repository,
+
+    ` + url + `
+
+Please check the branch in your config, or push the branch. If flux
+should create it from the repository's default branch, set
+createBranch in the git config.
+
+`
+	case NetworkTimeout:
+		help = `Timed out cloning your git repository
+
+Cloning your git repository,
+
+    ` + url + `
+
+took too long, or the server couldn't be reached. This is usually
+temporary; please try again. If it keeps happening, check that the
+server is up and reachable, and whether the repository is large enough
+to need a longer timeout.
+
+`
+	case LFSFailed:
+		help = `Problem fetching git LFS files
+
+Your git repository,
+
+    ` + url + `
+
+keeps some files in git LFS, and they couldn't be fetched. Flux needs
+git-lfs installed to fetch them.
+
+If the resource definitions aren't kept in LFS, set skipLFS in the git
+config, e.g., with
+
+    fluxctl edit-config
+
+and flux will check out the LFS files as pointers, without fetching
+them.
+
+`
+	}
+	return flux.UserConfigProblem{&flux.BaseError{Err: actual, Help: help}}
 }
 
 func PushError(url string, actual error) error {
-	return flux.UserConfigProblem{&flux.BaseError{
-		Err: actual,
-		Help: `Problem committing and pushing to git repository.
+	help := `Problem committing and pushing to git repository.
 
 There was a problem with committing changes and pushing to the git
 repository. Since the repository had to be cloned to get to this
@@ -58,6 +305,10 @@ given by
 
     fluxctl get-config --fingerprint=md5
 
+If you use an HTTPS URL with a username and token, check that the
+token is allowed to push to the repository (e.g., for GitHub, that it
+has the "repo" scope).
+
 If the key is present but read-only, you will need to delete it and
 create a new deploy key. To create a new one, use
 
@@ -68,6 +319,60 @@ create a new deploy key. To create a new one, use
 The public key this outputs can then be given to GitHub; make sure you
 check the box to allow write access.
 
-`,
-	}}
+`
+	switch KindOf(actual) {
+	case NonFastForward:
+		help = `Git repository changed while pushing
+
+The branch of your git repository,
+
+    ` + url + `
+
+moved on while flux was making its changes, and they couldn't be put
+on top of what's there now. This usually means someone else pushed at
+the same time; please try again.
+
+`
+	case Conflict:
+		help = `Changes conflict with those in your git repository
+
+The branch of your git repository,
+
+    ` + url + `
+
+moved on while flux was making its changes, and someone else's changes
+are to the same lines, so flux's couldn't be put on top of them. The
+files (and lines) in conflict are given with the error. Once you've
+reconciled the files, try again; or abandon the change.
+
+`
+	case PushRejected:
+		help = `Push refused by your git repository
+
+The branch of your git repository,
+
+    ` + url + `
+
+wouldn't accept flux's changes. This usually means the branch is
+protected (e.g., it needs pull requests to be reviewed, or status
+checks to pass), or a hook on the server rejected the change; what the
+server said is given with the error.
+
+Relax the rules for the branch, or set git.pullRequest in the config
+so that flux proposes its changes in pull requests.
+
+`
+	case NetworkTimeout:
+		help = `Timed out pushing to your git repository
+
+Pushing to your git repository,
+
+    ` + url + `
+
+took too long, or the server couldn't be reached. This is usually
+temporary; please try again.
+
+`
+	}
+	return flux.UserConfigProblem{&flux.BaseError{Err: actual, Help: help}}
 }
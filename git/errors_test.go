@@ -0,0 +1,73 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestClassify(t *testing.T) {
+	for stderr, expected := range map[string]ErrorKind{
+		"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.":                AuthFailed,
+		"fatal: Authentication failed for 'https://github.com/myorg/conf/'":                                            AuthFailed,
+		"Host key verification failed.\nfatal: Could not read from remote repository.":                                 AuthFailed,
+		"warning: Could not find remote branch flux to clone.\nfatal: Remote branch flux not found in upstream origin": RefNotFound,
+		"fatal: couldn't find remote ref refs/heads/flux":                                                              RefNotFound,
+		"ssh: connect to host github.com port 22: Connection timed out":                                                NetworkTimeout,
+		"fatal: unable to access 'https://example.com/conf/': Could not resolve host: example.com":                     NetworkTimeout,
+		"error: external filter 'git-lfs filter-process' failed\nfatal: assets/logo.png: smudge filter lfs failed":     LFSFailed,
+		" ! [rejected]        master -> master (fetch first)\nerror: failed to push some refs":                         NonFastForward,
+		" ! [remote rejected] master -> master (protected branch hook declined)\nerror: failed to push some refs":      PushRejected,
+		"fatal: not a git repository (or any of the parent directories): .git":                                         UnknownError,
+	} {
+		if kind := classify(stderr); kind != expected {
+			t.Errorf("expected %q for %q, got %q", expected, stderr, kind)
+		}
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	err := &Error{Kind: AuthFailed, Message: "fatal: Authentication failed"}
+	for _, wrapped := range []error{
+		err,
+		errors.Wrap(err, "git clone"),
+		CloningError("https://example.com/conf", errors.Wrap(err, "git clone")),
+		PushError("https://example.com/conf", err),
+	} {
+		if kind := KindOf(wrapped); kind != AuthFailed {
+			t.Errorf("expected %q for %v, got %q", AuthFailed, wrapped, kind)
+		}
+	}
+	if kind := KindOf(errors.Wrap(context.DeadlineExceeded, "git clone")); kind != NetworkTimeout {
+		t.Errorf("expected a timeout to be %q, got %q", NetworkTimeout, kind)
+	}
+	if kind := KindOf(errors.New("something else")); kind != UnknownError {
+		t.Errorf("expected %q for an error not from git, got %q", UnknownError, kind)
+	}
+}
+
+func TestCloneMissingBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-errors-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "nonexistent"}
+	_, err = repo.Clone()
+	if kind := KindOf(err); kind != RefNotFound {
+		t.Fatalf("expected %q cloning a branch that doesn't exist, got %q (%v)", RefNotFound, kind, err)
+	}
+	gitErr, ok := errors.Cause(err.(flux.UserConfigProblem).Err).(*Error)
+	if !ok {
+		t.Fatalf("expected the cause to be a git error, got %T", errors.Cause(err.(flux.UserConfigProblem).Err))
+	}
+	if gitErr.ExitCode != 128 || gitErr.Stderr == "" {
+		t.Errorf("expected git's exit code (128) and output, got %d and %q", gitErr.ExitCode, gitErr.Stderr)
+	}
+}
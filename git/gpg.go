@@ -0,0 +1,71 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImportGPGKeys imports the keys in the file given, or in each file in
+// the directory given, into gpg's keyring, so that commits can be
+// signed with them, or (for public keys) have their signatures
+// verified. The keyring is that in $GNUPGHOME, if set, which
+// is also passed on to git. It returns the files imported.
+func ImportGPGKeys(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				files = append(files, filepath.Join(path, info.Name()))
+			}
+		}
+	}
+
+	var imported []string
+	for _, file := range files {
+		c := exec.Command("gpg", "--batch", "--import", file)
+		errOut := &bytes.Buffer{}
+		c.Stderr = errOut
+		if err := c.Run(); err != nil {
+			return imported, errors.Wrapf(err, "importing GPG key from %s: %s", file, errOut.String())
+		}
+		imported = append(imported, file)
+	}
+	return imported, nil
+}
+
+// VerifyRevision checks that the commit given, in the clone at path,
+// is signed by a key in gpg's keyring. It returns an error, with what
+// gpg had to say, if the commit is unsigned, or its signature is bad
+// or by a key that isn't in the keyring.
+func (r Repo) VerifyRevision(path, rev string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	c := exec.CommandContext(ctx, "git", "verify-commit", rev)
+	c.Dir = path
+	c.Env = env(authFiles{})
+	errOut := &bytes.Buffer{}
+	c.Stderr = errOut
+	if err := c.Run(); err != nil {
+		msg := strings.TrimSpace(errOut.String())
+		if msg == "" {
+			msg = "no signature"
+		}
+		return errors.Errorf("commit %s failed verification: %s", rev, msg)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSigner = "Flux Test <signer@example.com>"
+
+func gpgCmd(t *testing.T, home string, args ...string) string {
+	c := exec.Command("gpg", append([]string{"--homedir", home, "--batch"}, args...)...)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		t.Fatalf("gpg %v: %s\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestSignedCommit(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+	dir, err := ioutil.TempDir("", "flux-gpg-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Make a key in one keyring, and export it to a file, as someone
+	// would before giving it to fluxsvc.
+	genHome := filepath.Join(dir, "gen")
+	if err := os.Mkdir(genHome, 0700); err != nil {
+		t.Fatal(err)
+	}
+	gpgCmd(t, genHome, "--passphrase", "", "--quick-gen-key", testSigner, "default", "default", "never")
+	keys := filepath.Join(dir, "keys")
+	if err := os.Mkdir(keys, 0700); err != nil {
+		t.Fatal(err)
+	}
+	gpgCmd(t, genHome, "--armor", "--output", filepath.Join(keys, "signer.asc"), "--export-secret-keys", testSigner)
+
+	home := filepath.Join(dir, "home")
+	if err := os.Mkdir(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GNUPGHOME", os.Getenv("GNUPGHOME"))
+	os.Setenv("GNUPGHOME", home)
+
+	imported, err := ImportGPGKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 {
+		t.Fatalf("expected one key file imported, got %v", imported)
+	}
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master", SigningKey: "signer@example.com"}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPush(working, "Scale up"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(context.Background(), working, authFiles{}, out, "log", "-1", "--format=%G? %GS"); err != nil {
+		t.Fatal(err)
+	}
+	// A good signature is "G", or "U" if the key isn't trusted, as an
+	// imported key isn't until someone says so.
+	got := strings.TrimSpace(out.String())
+	if !(strings.HasPrefix(got, "G ") || strings.HasPrefix(got, "U ")) || !strings.Contains(got, "signer@example.com") {
+		t.Errorf("expected a good signature by the imported key, got %q", got)
+	}
+
+	rev, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.VerifyRevision(working, rev); err != nil {
+		t.Errorf("expected the signed commit to verify, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 3\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := commit(context.Background(), working, "Unsigned", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.VerifyRevision(working, unsigned); err == nil {
+		t.Error("expected the unsigned commit not to verify")
+	}
+
+	// Someone without the key in their keyring doesn't trust the
+	// signature
+	other := filepath.Join(dir, "other")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GNUPGHOME", other)
+	if err := repo.VerifyRevision(working, rev); err == nil {
+		t.Error("expected a commit signed by an unknown key not to verify")
+	}
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+// cloneDirPrefix is the prefix of the temporary directories working
+// clones are made in.
+const cloneDirPrefix = "flux-gitclone"
+
+// liveClones are the directories of the working clones this process
+// has made and not yet cleaned up, which the janitor leaves alone
+// however old they are.
+var liveClones = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: map[string]bool{}}
+
+// register records the working clone in the directory given as in
+// use, until it's cleaned up with Clean.
+func register(workingDir string) {
+	liveClones.Lock()
+	defer liveClones.Unlock()
+	liveClones.dirs[filepath.Clean(workingDir)] = true
+}
+
+func unregister(workingDir string) {
+	liveClones.Lock()
+	defer liveClones.Unlock()
+	delete(liveClones.dirs, filepath.Clean(workingDir))
+}
+
+func live(workingDir string) bool {
+	liveClones.Lock()
+	defer liveClones.Unlock()
+	return liveClones.dirs[filepath.Clean(workingDir)]
+}
+
+// Clean removes the working clone at path, as given by Clone, along
+// with the temporary directory it's in.
+func Clean(path string) error {
+	workingDir := filepath.Dir(path)
+	unregister(workingDir)
+	return os.RemoveAll(workingDir)
+}
+
+// Janitor removes working clones left behind in the temporary
+// directory, e.g., by a process that crashed in the middle of an
+// operation, and so never got to remove them.
+//
+// The clones this process is using, i.e., those given by Clone and not
+// yet cleaned up with Clean, are never removed. Other clones (e.g.,
+// those of another process sharing the directory) are taken to be
+// left behind once they're older than the age given, which should be
+// well beyond how long an operation takes. The mirrors, if there are
+// any, are never removed.
+type Janitor struct {
+	dir    string
+	maxAge time.Duration
+	mirror *Mirror
+	logger log.Logger
+}
+
+// NewJanitor returns a Janitor that removes working clones older than
+// maxAge. The mirror may be nil.
+func NewJanitor(maxAge time.Duration, mirror *Mirror, logger log.Logger) *Janitor {
+	return &Janitor{
+		dir:    os.TempDir(),
+		maxAge: maxAge,
+		mirror: mirror,
+		logger: logger,
+	}
+}
+
+// Clean sweeps for working clones left behind at each tick.
+func (j *Janitor) Clean(tick <-chan time.Time) {
+	for range tick {
+		if _, err := j.Sweep(); err != nil {
+			j.logger.Log("err", err)
+		}
+	}
+}
+
+// Sweep removes the working clones left behind, and says how many it
+// removed.
+func (j *Janitor) Sweep() (removed int, err error) {
+	defer func(begin time.Time) {
+		sweepDuration.With(
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	infos, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return 0, err
+	}
+	var remaining int
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), cloneDirPrefix) {
+			continue
+		}
+		path := filepath.Join(j.dir, info.Name())
+		if j.mirror != nil && (path == j.mirror.dir || strings.HasPrefix(j.mirror.dir, path+string(filepath.Separator))) {
+			continue
+		}
+		if live(path) || time.Since(info.ModTime()) < j.maxAge {
+			remaining++
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			j.logger.Log("dir", path, "err", err)
+			remaining++
+			continue
+		}
+		removed++
+	}
+	clonesRemoved.Add(float64(removed))
+	workingClones.Set(float64(remaining))
+	if removed > 0 {
+		j.logger.Log("removed", removed, "remaining", remaining)
+	}
+	return removed, nil
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-janitor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for name, modTime := range map[string]time.Time{
+		"flux-gitclone123": old,
+		"flux-gitclone456": time.Now(),
+		"flux-gitclone789": old, // the mirrors
+		"something-else":   old,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(path, "repo"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := NewJanitor(time.Hour, NewMirror(filepath.Join(dir, "flux-gitclone789"), time.Minute), log.NewNopLogger())
+	j.dir = dir
+	removed, err := j.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected one clone to be removed, got %d", removed)
+	}
+	for name, kept := range map[string]bool{
+		"flux-gitclone123": false,
+		"flux-gitclone456": true,
+		"flux-gitclone789": true,
+		"something-else":   true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("expected %s to be kept: %v, but it exists: %v", name, kept, exists)
+		}
+	}
+}
+
+func TestJanitorKeepsLiveClones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-janitor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A clone still in use, however long it's taken
+	workingDir := filepath.Join(dir, "flux-gitclone123")
+	path := filepath.Join(workingDir, "repo")
+	if err := os.MkdirAll(path, 0777); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(workingDir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	register(workingDir)
+
+	j := NewJanitor(time.Hour, nil, log.NewNopLogger())
+	j.dir = dir
+	if removed, err := j.Sweep(); err != nil || removed != 0 {
+		t.Fatalf("expected the live clone to be kept, got %d removed (%v)", removed, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the live clone to be kept, got %v", err)
+	}
+
+	if err := Clean(path); err != nil {
+		t.Fatal(err)
+	}
+	if live(workingDir) {
+		t.Error("expected the clone to be forgotten once cleaned up")
+	}
+	if _, err := os.Stat(workingDir); !os.IsNotExist(err) {
+		t.Errorf("expected the clone's directory to be removed, got %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+
+	"golang.org/x/crypto/ssh"
 )
 
 // KeySize is the size of generated private keys.
@@ -33,3 +35,34 @@ func (k *key) Generate() (privateKeyB []byte, err error) {
 	privateKeyB = pem.EncodeToMemory(privateKeyPEM)
 	return
 }
+
+// CheckKeyPassphrase checks that the passphrase given opens the
+// private key given, if it's encrypted; a passphrase given for a key
+// that isn't is ignored. Anything that isn't a private key is left for
+// ssh to complain about.
+func CheckKeyPassphrase(key, passphrase string) error {
+	if key == "" {
+		return nil
+	}
+	_, err := parsePrivateKey(key, passphrase)
+	switch err.(type) {
+	case nil:
+		return nil
+	case *ssh.PassphraseMissingError:
+		return ErrKeyPassphraseMissing
+	}
+	if err == x509.IncorrectPasswordError {
+		return ErrKeyPassphraseWrong
+	}
+	return nil
+}
+
+// parsePrivateKey parses a private key, with the passphrase if it's
+// encrypted.
+func parsePrivateKey(key, passphrase string) (interface{}, error) {
+	k, err := ssh.ParseRawPrivateKey([]byte(key))
+	if _, ok := err.(*ssh.PassphraseMissingError); ok && passphrase != "" {
+		return ssh.ParseRawPrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	}
+	return k, err
+}
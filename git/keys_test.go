@@ -1,6 +1,10 @@
 package git
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"strings"
 	"testing"
 )
@@ -20,3 +24,38 @@ func TestKey_Generate(t *testing.T) {
 		t.Fatal("should be priv type", string(priv))
 	}
 }
+
+func TestCheckKeyPassphrase(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(privateKey)
+	plain := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}))
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", der, []byte("open sesame"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := string(pem.EncodeToMemory(block))
+
+	for _, c := range []struct {
+		key, passphrase string
+		expected        error
+	}{
+		{"", "", nil},
+		{plain, "", nil},
+		{plain, "ignored", nil},
+		{"ssh-rsa AAAA... a public key", "", nil},
+		{encrypted, "open sesame", nil},
+		{encrypted, "", ErrKeyPassphraseMissing},
+		{encrypted, "open barley", ErrKeyPassphraseWrong},
+	} {
+		if err := CheckKeyPassphrase(c.key, c.passphrase); err != c.expected {
+			t.Errorf("key %.20q, passphrase %q: expected %v, got %v", c.key, c.passphrase, c.expected, err)
+		}
+	}
+
+	if _, err := (auth{key: encrypted}).write(); err != ErrKeyPassphraseMissing {
+		t.Errorf("expected auth for an encrypted key without a passphrase to fail, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package git
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// CheckKnownHosts checks that hosts is in the format of an ssh
+// known_hosts file, as given in sshd(8): each line, other than blank
+// lines and comments, has the host patterns, the key type and the
+// (base64-encoded) key, optionally preceded by a marker such as
+// @cert-authority.
+func CheckKnownHosts(hosts string) error {
+	sc := bufio.NewScanner(strings.NewReader(hosts))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if strings.HasPrefix(fields[0], "@") {
+			if fields[0] != "@cert-authority" && fields[0] != "@revoked" {
+				return fmt.Errorf("known hosts line %d: unknown marker %s", n, fields[0])
+			}
+			fields = fields[1:]
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("known hosts line %d: expected hosts, key type and key", n)
+		}
+		if _, err := base64.StdEncoding.DecodeString(fields[2]); err != nil {
+			return fmt.Errorf("known hosts line %d: key for %s is not base64", n, fields[0])
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const githubHostKey = "github.com ssh-rsa AAAAB3NzaC1yc2EAAAABIwAAAQEAq2A7hRGmdnm9tUDbO9IDSwBK6TbQa+PXYPCPy6rbTrTtw7PHkccKrpp0yVhp5HdEIcKr6pLlVDBfOLX9QUsyCOV0wzfjIJNlGEYsdlLJizHhbn2mUjvSAHQqZETYP81eFzLQNnPHt4EVVUh7VfDESU84KezmD5QlWpXLmvU31/yMf+Se8xhHTvKSCZIFImWwoG6mbUoWf9nzpIoaSjB+weqqUUmpaaasXVal72J+UX2B+2RPW3RcT0eOzQgqlJL3RKrTJvdsjE3JEAvGq3lGHSZXy28G3skua2SmVi/w4yCE6gbODqnTWlg7+wC604ydGXA8VJiS5ap43JXiUFFAaQ=="
+
+func TestCheckKnownHosts(t *testing.T) {
+	for _, hosts := range []string{
+		"",
+		githubHostKey,
+		"# github\n\n" + githubHostKey + "\n",
+		"@cert-authority *.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE8sYb0R",
+	} {
+		if err := CheckKnownHosts(hosts); err != nil {
+			t.Errorf("%q: unexpected error %s", hosts, err)
+		}
+	}
+	for _, hosts := range []string{
+		"github.com",
+		"github.com ssh-rsa",
+		"github.com ssh-rsa not-base64!",
+		"@trusted github.com ssh-rsa AAAA",
+	} {
+		if err := CheckKnownHosts(hosts); err == nil {
+			t.Errorf("%q: expected an error", hosts)
+		}
+	}
+}
+
+func TestKnownHostsFile(t *testing.T) {
+	files, err := auth{key: "key", knownHosts: githubHostKey}.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	written, err := ioutil.ReadFile(files.knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != githubHostKey {
+		t.Errorf("expected the known hosts to be written, got %q", written)
+	}
+
+	var sshCommand string
+	for _, v := range env(files) {
+		if strings.HasPrefix(v, "GIT_SSH_COMMAND=") {
+			sshCommand = v
+		}
+	}
+	for _, opt := range []string{
+		"-o StrictHostKeyChecking=yes",
+		fmt.Sprintf("-o UserKnownHostsFile=%q", files.knownHostsPath),
+	} {
+		if !strings.Contains(sshCommand, opt) {
+			t.Errorf("expected %s in %q", opt, sshCommand)
+		}
+	}
+
+	// Without known hosts given, ssh's own are used, and still
+	// checked
+	files, err = auth{key: "key"}.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	for _, v := range env(files) {
+		if strings.HasPrefix(v, "GIT_SSH_COMMAND=") && (strings.Contains(v, "UserKnownHostsFile") || !strings.Contains(v, "StrictHostKeyChecking=yes")) {
+			t.Errorf("expected strict checking against ssh's own known hosts, got %q", v)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// usesLFS says whether the clone at the path given keeps any files in
+// git LFS, according to its .gitattributes files.
+func usesLFS(repoDir string) bool {
+	errFound := errors.New("found")
+	err := filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case info.IsDir() && info.Name() == ".git":
+			return filepath.SkipDir
+		case !info.IsDir() && info.Name() == ".gitattributes" && attributesUseLFS(path):
+			return errFound
+		}
+		return nil
+	})
+	return err == errFound
+}
+
+// attributesUseLFS says whether the .gitattributes file given puts
+// any paths through the LFS filter.
+func attributesUseLFS(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if attr == "filter=lfs" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lfsInstalled says whether git-lfs is there for git to use.
+func lfsInstalled() bool {
+	_, err := exec.LookPath("git-lfs")
+	return err == nil
+}
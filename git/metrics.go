@@ -0,0 +1,42 @@
+package git
+
+import (
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+var (
+	sweepDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "janitor_sweep_duration_seconds",
+		Help:      "Duration in seconds of sweeps for working clones left behind.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, []string{fluxmetrics.LabelSuccess})
+	clonesRemoved = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "janitor_removed_clones_total",
+		Help:      "Count of working clones removed after being left behind.",
+	}, []string{})
+	workingClones = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "working_clones_count",
+		Help:      "Gauge of the number of working clones too new to be removed, as of the last sweep.",
+	}, []string{})
+	queuedOperations = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "queued_operations_count",
+		Help:      "Gauge of the number of operations waiting for others on the same branch to finish.",
+	}, []string{})
+	queueWaitDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "queue_wait_duration_seconds",
+		Help:      "Duration in seconds operations waited for others on the same branch to finish.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, []string{})
+)
@@ -0,0 +1,183 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/chaos"
+)
+
+// Mirror keeps a bare mirror of each config repo on disk, and hands
+// out working clones of it; so that each operation fetches only what
+// has changed since the last, rather than cloning the repo again.
+//
+// The working clones are local clones that borrow the mirror's
+// objects, rather than git worktrees, since two operations on the
+// same branch (e.g., releases) can't have it checked out in worktrees
+// at the same time. Their origin is the repo itself, so commits are
+// pushed there directly.
+type Mirror struct {
+	dir string
+	// fetchInterval is how recently a mirror must have been fetched
+	// for a working clone to be given without fetching again.
+	fetchInterval time.Duration
+
+	mu      sync.Mutex
+	mirrors map[string]*mirror
+}
+
+// mirror is fetched into under its write lock, and cloned from under
+// its read lock, so clones can be made at the same time.
+type mirror struct {
+	sync.RWMutex
+	path    string
+	fetched time.Time
+}
+
+// NewMirror returns a Mirror that keeps its mirrors under dir. Working
+// clones are given from a mirror as it was when last fetched, if that
+// was within fetchInterval; otherwise, the mirror is fetched first.
+func NewMirror(dir string, fetchInterval time.Duration) *Mirror {
+	return &Mirror{
+		dir:           dir,
+		fetchInterval: fetchInterval,
+		mirrors:       map[string]*mirror{},
+	}
+}
+
+// mirrorFor gives the mirror of the repo. Mirrors are kept apart by
+// credentials as well as by URL, so that one instance can't get at a
+// repo through the mirror made with another instance's key.
+func (m *Mirror) mirrorFor(r Repo) *mirror {
+	h := sha256.New()
+	for _, s := range []string{r.URL, r.Key, r.User, r.Token} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	name := hex.EncodeToString(h.Sum(nil))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mir, ok := m.mirrors[name]
+	if !ok {
+		mir = &mirror{path: filepath.Join(m.dir, name+".git")}
+		m.mirrors[name] = mir
+	}
+	return mir
+}
+
+// WorkingClone fetches the repo into its mirror, if it hasn't been
+// fetched recently, and gives the path of a new working clone of the
+// repo's branch. The caller should remove the working clone with
+// Clean when done with it, as with Repo.Clone.
+func (m *Mirror) WorkingClone(r Repo) (path string, err error) {
+	if r.URL == "" {
+		return "", NoRepoError
+	}
+
+	if err := chaos.Inject(chaos.GitClone); err != nil {
+		return "", CloningError(r.URL, err)
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	mir := m.mirrorFor(r)
+	mir.Lock()
+	err = mir.update(ctx, r, m.fetchInterval)
+	mir.Unlock()
+	if err != nil {
+		return "", CloningError(r.URL, err)
+	}
+	mir.RLock()
+	defer mir.RUnlock()
+
+	workingDir, err := ioutil.TempDir(os.TempDir(), cloneDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	repoDir := filepath.Join(workingDir, "repo")
+	args := []string{"clone", "--shared"}
+	if r.sparsePath() != "" {
+		args = append(args, "--no-checkout")
+	}
+	if r.Branch != "" {
+		args = append(args, "--branch", r.Branch)
+	}
+	args = append(args, mir.path, repoDir)
+	// LFS files aren't in the mirror, so if they're to be skipped,
+	// they're skipped here.
+	local := authFiles{skipLFS: r.SkipLFS}
+	if err := execGitCmd(ctx, workingDir, local, args...); err != nil {
+		os.RemoveAll(workingDir)
+		return "", CloningError(r.URL, errors.Wrap(err, "git clone from mirror"))
+	}
+	// The mirror has all the files, so none need fetching for a
+	// sparse checkout
+	if path := r.sparsePath(); path != "" {
+		if err := sparseCheckout(ctx, local, repoDir, path); err != nil {
+			os.RemoveAll(workingDir)
+			return "", err
+		}
+	}
+	if err := execGitCmd(ctx, repoDir, authFiles{}, "remote", "set-url", "origin", r.URL); err != nil {
+		os.RemoveAll(workingDir)
+		return "", errors.Wrap(err, "git remote set-url")
+	}
+	// The mirror has only the repo itself; submodules are fetched
+	// from their own remotes.
+	if r.Submodules {
+		if err := updateSubmodules(ctx, r.auth(), repoDir); err != nil {
+			os.RemoveAll(workingDir)
+			return "", CloningError(r.URL, err)
+		}
+	}
+	if err := r.checkLFS(repoDir); err != nil {
+		os.RemoveAll(workingDir)
+		return "", err
+	}
+	register(workingDir)
+	return repoDir, nil
+}
+
+// invalidate makes sure the repo's mirror is fetched before the next
+// working clone is given, e.g., because we've just pushed to the repo.
+func (m *Mirror) invalidate(r Repo) {
+	mir := m.mirrorFor(r)
+	mir.Lock()
+	mir.fetched = time.Time{}
+	mir.Unlock()
+}
+
+// update makes the mirror if it's not there yet, or fetches into it if
+// it's not been fetched within the interval given.
+func (mir *mirror) update(ctx context.Context, r Repo, interval time.Duration) error {
+	if !mir.fetched.IsZero() && time.Since(mir.fetched) < interval {
+		return nil
+	}
+	files, err := r.auth().write()
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+
+	if _, err := os.Stat(mir.path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(mir.path), 0700); err != nil {
+			return err
+		}
+		if err := execGitCmd(ctx, filepath.Dir(mir.path), files, "clone", "--mirror", r.URL, mir.path); err != nil {
+			os.RemoveAll(mir.path)
+			return errors.Wrap(err, "git clone --mirror")
+		}
+	} else if err := execGitCmd(ctx, mir.path, files, "fetch", "--prune", "origin"); err != nil {
+		return errors.Wrap(err, "git fetch")
+	}
+	mir.fetched = time.Now()
+	return nil
+}
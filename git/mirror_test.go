@@ -0,0 +1,92 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupRemote makes a bare repo with one commit on master, to act as
+// the remote.
+func setupRemote(t *testing.T, dir string) string {
+	files := filepath.Join(dir, "files")
+	remote := filepath.Join(dir, "remote.git")
+	if err := os.Mkdir(files, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(files, "deploy.yaml"), []byte("replicas: 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", "master"},
+		{"add", "--all"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Initial revision"},
+		{"clone", "-q", "--bare", files, remote},
+	} {
+		if err := execGitCmd(context.Background(), files, authFiles{}, args...); err != nil {
+			t.Fatalf("git %v: %s", args, err)
+		}
+	}
+	return remote
+}
+
+func TestMirrorWorkingClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-mirror-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror := NewMirror(filepath.Join(dir, "mirrors"), time.Hour)
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master", Mirror: mirror}
+
+	// Two working clones can be had at once, and changes pushed from
+	// one are in the next, even though the mirror was fetched
+	// recently.
+	first, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(first))
+	second, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(second))
+
+	if err := ioutil.WriteFile(filepath.Join(first, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPush(first, "Scale up"); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := repo.HeadRevision(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(third))
+	if rev, err := repo.HeadRevision(third); err != nil || rev != pushed {
+		t.Errorf("expected the pushed commit %s in a new working clone, got %s (%v)", pushed, rev, err)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "mirrors"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected one mirror, got %d", len(entries))
+	}
+
+	if _, err := (Repo{URL: filepath.Join(dir, "nonexistent"), Mirror: mirror}).Clone(); err == nil {
+		t.Error("expected an error cloning a repo that doesn't exist")
+	}
+}
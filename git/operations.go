@@ -3,98 +3,570 @@ package git
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/chaos"
 )
 
 // Do a shallow clone of the repo. We only need the files, and not the
 // history. A shallow clone is marginally quicker, and takes less
-// space, than a full clone.
-func clone(workingDir, keyData, repoURL, repoBranch string) (path string, err error) {
-	keyPath, err := writeKey(keyData)
+// space, than a full clone. If submodules is true, the submodules are
+// cloned too (also shallowly), so their files are in the clone. If
+// sparsePath is not blank, only the files under it (and those at the
+// top of the repo) are checked out; see sparseCheckout.
+func clone(ctx context.Context, workingDir string, a auth, repoURL, repoBranch, sparsePath string, submodules bool) (path string, err error) {
+	files, err := a.write()
 	if err != nil {
 		return "", err
 	}
-	defer os.Remove(keyPath)
+	defer files.remove()
+	if err := chaos.Inject(chaos.GitClone); err != nil {
+		return "", errors.Wrap(err, "git clone")
+	}
 	repoPath := filepath.Join(workingDir, "repo")
 	// --single-branch is also useful, but is implied by --depth=1
 	args := []string{"clone", "--depth=1"}
+	if sparsePath != "" {
+		// The files are checked out once the sparse checkout is set
+		// up; until then, only the commit and trees are fetched, if
+		// the remote will filter out the rest.
+		args = append(args, "--no-checkout", "--filter=blob:none")
+	}
 	if repoBranch != "" {
 		args = append(args, "--branch", repoBranch)
 	}
+	if submodules && sparsePath == "" {
+		args = append(args, "--recurse-submodules", "--shallow-submodules")
+	}
 	args = append(args, repoURL, repoPath)
-	if err := execGitCmd(workingDir, keyPath, args...); err != nil {
+	if err := execGitCmd(ctx, workingDir, files, args...); err != nil {
 		return "", errors.Wrap(err, "git clone")
 	}
+	if sparsePath != "" {
+		// The files checked out are fetched now, so this needs the
+		// auth files too
+		if err := sparseCheckout(ctx, files, repoPath, sparsePath); err != nil {
+			return "", err
+		}
+		if submodules {
+			if err := updateSubmodules(ctx, a, repoPath); err != nil {
+				return "", err
+			}
+		}
+	}
 	return repoPath, nil
 }
 
-func commit(workingDir, commitMessage string) error {
-	if err := execGitCmd(
-		workingDir, "",
+// sparseCheckout checks out, in a clone made without checking out, the
+// files under path, and those at the top of the repo (e.g., the repo
+// config file); i.e., a sparse checkout in cone mode. Changes to those
+// files are committed as usual, and the rest of the repo is left as
+// it is.
+func sparseCheckout(ctx context.Context, files authFiles, workingDir, path string) error {
+	for _, args := range [][]string{
+		{"sparse-checkout", "init", "--cone"},
+		{"sparse-checkout", "set", "--", path},
+		{"checkout"},
+	} {
+		if err := execGitCmd(ctx, workingDir, files, args...); err != nil {
+			return errors.Wrap(err, "git "+strings.Join(args[:2], " "))
+		}
+	}
+	return nil
+}
+
+// updateSubmodules checks out the submodules of the clone, at the
+// commits recorded in it, fetching them as necessary. Relative
+// submodule URLs are relative to the clone's origin.
+func updateSubmodules(ctx context.Context, a auth, workingDir string) error {
+	files, err := a.write()
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+	if err := execGitCmd(ctx, workingDir, files, "submodule", "update", "--init", "--recursive", "--depth=1"); err != nil {
+		return errors.Wrap(err, "git submodule update")
+	}
+	return nil
+}
+
+// commit makes a commit with flux as the committer. If author is not
+// blank, it's used as the author of the commit (in the form `Name
+// <email>`); otherwise flux is the author too. If signingKey is not
+// blank, the commit is signed with that GPG key, which must be in the
+// keyring (see ImportGPGKeys).
+func commit(ctx context.Context, workingDir, commitMessage, author, signingKey string) error {
+	args := []string{
 		"-c", "user.name=Weave Flux", "-c", "user.email=support@weave.works",
 		"commit",
 		"--no-verify", "-a", "-m", commitMessage,
-	); err != nil {
+	}
+	if author != "" {
+		args = append(args, "--author", author)
+	}
+	if signingKey != "" {
+		args = append(args, "-S"+signingKey)
+	}
+	if err := execGitCmd(ctx, workingDir, authFiles{}, args...); err != nil {
 		return errors.Wrap(err, "git commit")
 	}
 	return nil
 }
 
-func push(keyData, repoBranch, workingDir string) error {
-	keyPath, err := writeKey(keyData)
+// push pushes to the remote; refspec is a branch, or a refspec (e.g.,
+// `HEAD:refs/heads/<branch>`).
+func push(ctx context.Context, a auth, refspec, workingDir string) error {
+	files, err := a.write()
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+	if err := chaos.Inject(chaos.GitPush); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("git push origin %s: rejected", refspec))
+	}
+	if err := execGitCmd(ctx, workingDir, files, "push", "origin", refspec); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("git push origin %s", refspec))
+	}
+	return nil
+}
+
+// errNonFastForward is the error from a push that was rejected because
+// the branch has moved on since it was cloned.
+var errNonFastForward = &Error{
+	Kind:     NonFastForward,
+	Message:  "rejected, since the branch has moved on; fetch first",
+	ExitCode: -1,
+}
+
+// isNonFastForward says whether the output of `git push` says a ref
+// was rejected for not being a fast-forward. A ref rejected by the
+// remote itself (e.g., by a hook) is "[remote rejected]" instead.
+func isNonFastForward(stderr string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "! [rejected]") &&
+			(strings.Contains(line, "(fetch first)") || strings.Contains(line, "(non-fast-forward)")) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejected says whether the error is from a push that was rejected
+// for not being a fast-forward.
+func rejected(err error) bool {
+	return KindOf(err) == NonFastForward
+}
+
+// rebase fetches the branch from the remote, and replays the commits
+// made locally on top of it, e.g., so they can be pushed after a push
+// was rejected for not being a fast-forward. If the commits don't
+// apply cleanly, the rebase is abandoned and the clone left as it was;
+// if that's because of conflicts, the error is a *ConflictError.
+func rebase(ctx context.Context, a auth, workingDir, branch, signingKey string) error {
+	files, err := a.write()
 	if err != nil {
 		return err
 	}
-	defer os.Remove(keyPath)
-	if err := execGitCmd(workingDir, keyPath, "push", "origin", repoBranch); err != nil {
-		return errors.Wrap(err, fmt.Sprintf("git push origin %s", repoBranch))
+	defer files.remove()
+	if err := execGitCmd(ctx, workingDir, files, "fetch", "origin", branch); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("git fetch origin %s", branch))
+	}
+	args := []string{
+		"-c", "user.name=Weave Flux", "-c", "user.email=support@weave.works",
+		"rebase",
+	}
+	if signingKey != "" {
+		args = append(args, "--gpg-sign="+signingKey)
+	}
+	if err := execGitCmd(ctx, workingDir, authFiles{}, append(args, "FETCH_HEAD")...); err != nil {
+		files, _ := conflicts(ctx, workingDir)
+		execGitCmd(ctx, workingDir, authFiles{}, "rebase", "--abort")
+		if len(files) > 0 {
+			return &ConflictError{Files: files, Err: errors.Wrap(err, "git rebase")}
+		}
+		return errors.Wrap(err, "git rebase")
 	}
 	return nil
 }
 
-func execGitCmd(dir, keyPath string, args ...string) error {
-	c := exec.Command("git", args...)
+// conflicts gives the files left in conflict by a rebase that stopped,
+// with the parts of each that conflict.
+func conflicts(ctx context.Context, workingDir string) ([]flux.ConflictFile, error) {
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(ctx, workingDir, authFiles{}, out, "diff", "--name-only", "--diff-filter=U"); err != nil {
+		return nil, err
+	}
+	var files []flux.ConflictFile
+	for _, path := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if path == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(workingDir, path))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, flux.ConflictFile{Path: path, Hunks: conflictHunks(string(contents))})
+	}
+	return files, nil
+}
+
+// conflictHunks gives each part of a file that git has marked as
+// conflicting, markers and all.
+func conflictHunks(contents string) []string {
+	var hunks []string
+	var hunk []string
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+			hunk = []string{line}
+		case hunk != nil:
+			hunk = append(hunk, line)
+			if strings.HasPrefix(line, ">>>>>>> ") {
+				hunks = append(hunks, strings.Join(hunk, ""))
+				hunk = nil
+			}
+		}
+	}
+	return hunks
+}
+
+func add(ctx context.Context, workingDir string, paths ...string) error {
+	if err := execGitCmd(ctx, workingDir, authFiles{}, append([]string{"add", "--"}, paths...)...); err != nil {
+		return errors.Wrap(err, "git add")
+	}
+	return nil
+}
+
+func revision(ctx context.Context, workingDir string) (string, error) {
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(ctx, workingDir, authFiles{}, out, "rev-parse", "HEAD"); err != nil {
+		return "", errors.Wrap(err, "git rev-parse")
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// tree gives the hash of the tree at subdir in HEAD, unless there are
+// changes under subdir, including files that aren't tracked, in which
+// case it gives "".
+func tree(ctx context.Context, workingDir, subdir string) (string, error) {
+	subdir = strings.Trim(filepath.ToSlash(subdir), "/")
+	if subdir == "." {
+		subdir = ""
+	}
+	pathspec := subdir
+	if pathspec == "" {
+		pathspec = "."
+	}
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(ctx, workingDir, authFiles{}, out, "status", "--porcelain", "--ignore-submodules", "--", pathspec); err != nil {
+		return "", errors.Wrap(err, "git status")
+	}
+	if strings.TrimSpace(out.String()) != "" {
+		return "", nil
+	}
+	out.Reset()
+	if err := execGitCmdOut(ctx, workingDir, authFiles{}, out, "rev-parse", "HEAD:"+subdir); err != nil {
+		return "", errors.Wrap(err, "git rev-parse")
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// branchExists asks the remote whether it has the branch.
+func branchExists(ctx context.Context, a auth, repoURL, branch string) (bool, error) {
+	files, err := a.write()
+	if err != nil {
+		return false, err
+	}
+	defer files.remove()
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(ctx, "", files, out, "ls-remote", "--heads", repoURL, "refs/heads/"+branch); err != nil {
+		return false, errors.Wrap(err, "git ls-remote")
+	}
+	return strings.TrimSpace(out.String()) != "", nil
+}
+
+// unshallow fetches the rest of the history, if the clone is shallow.
+func unshallow(ctx context.Context, a auth, workingDir string) error {
+	if _, err := os.Stat(filepath.Join(workingDir, ".git", "shallow")); os.IsNotExist(err) {
+		return nil
+	}
+	files, err := a.write()
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+	if err := execGitCmd(ctx, workingDir, files, "fetch", "--unshallow"); err != nil {
+		return errors.Wrap(err, "git fetch --unshallow")
+	}
+	return nil
+}
+
+func blame(ctx context.Context, workingDir, file string) ([]BlameLine, error) {
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(ctx, workingDir, authFiles{}, out, "blame", "--line-porcelain", "--", file); err != nil {
+		return nil, errors.Wrap(err, "git blame")
+	}
+	return parseBlame(out)
+}
+
+// parseBlame reads the output of `git blame --line-porcelain`, in
+// which each line of the file is given as a header with the commit,
+// then fields about the commit, then the line itself, after a tab.
+func parseBlame(out io.Reader) ([]BlameLine, error) {
+	var (
+		lines       []BlameLine
+		line        BlameLine
+		name, email string
+		inHeader    bool
+	)
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		text := sc.Text()
+		if !inHeader {
+			fields := strings.Fields(text)
+			if len(fields) < 3 || len(fields[0]) != 40 {
+				return nil, fmt.Errorf("unexpected line in git blame output: %q", text)
+			}
+			line, name, email, inHeader = BlameLine{Revision: fields[0]}, "", "", true
+			continue
+		}
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			line.Author = strings.TrimSpace(name + " " + email)
+			lines = append(lines, line)
+			inHeader = false
+		case strings.HasPrefix(text, "author "):
+			name = strings.TrimPrefix(text, "author ")
+		case strings.HasPrefix(text, "author-mail "):
+			email = strings.TrimPrefix(text, "author-mail ")
+		case strings.HasPrefix(text, "author-time "):
+			secs, err := strconv.ParseInt(strings.TrimPrefix(text, "author-time "), 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing git blame author time")
+			}
+			line.Time = time.Unix(secs, 0).UTC()
+		}
+	}
+	return lines, sc.Err()
+}
+
+func execGitCmd(ctx context.Context, dir string, files authFiles, args ...string) error {
+	return execGitCmdOut(ctx, dir, files, ioutil.Discard, args...)
+}
+
+func execGitCmdOut(ctx context.Context, dir string, files authFiles, out io.Writer, args ...string) error {
+	var config []string
+	for _, setting := range files.http {
+		config = append(config, "-c", setting)
+	}
+	// Without git-lfs, the filter fails; if it's not required, the
+	// pointer is checked out as it is, as it would be when skipping.
+	if files.skipLFS {
+		config = append(config, "-c", "filter.lfs.required=false")
+	}
+	// The command is killed if the context is done before it is,
+	// e.g., because the remote has stopped responding.
+	c := exec.CommandContext(ctx, "git", append(config, args...)...)
 	if dir != "" {
 		c.Dir = dir
 	}
-	c.Env = env(keyPath)
-	c.Stdout = ioutil.Discard
+	c.Env = env(files)
+	c.Stdout = out
 	errOut := &bytes.Buffer{}
 	c.Stderr = errOut
 	err := c.Run()
-	if err != nil {
-		msg := findFatalMessage(errOut)
-		if msg != "" {
-			err = errors.New(msg)
+	if err == nil {
+		return nil
+	}
+	stderr := errOut.String()
+	if ctx.Err() != nil {
+		gitErr := &Error{Message: ctx.Err().Error(), ExitCode: -1, Stderr: stderr}
+		if ctx.Err() == context.DeadlineExceeded {
+			gitErr.Kind = NetworkTimeout
 		}
+		return gitErr
+	}
+	gitErr := &Error{Kind: classify(stderr), Message: err.Error(), ExitCode: exitCode(err), Stderr: stderr}
+	if msg := findFatalMessage(strings.NewReader(stderr)); msg != "" {
+		gitErr.Message = msg
+	} else if gitErr.Kind == NonFastForward {
+		gitErr.Message = errNonFastForward.Message
 	}
-	return err
+	return gitErr
 }
 
-func env(keyPath string) []string {
-	base := `GIT_SSH_COMMAND=ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no`
-	if keyPath == "" {
-		return []string{base}
+// exitCode gives the exit code from the error from running a command,
+// or -1 if it didn't exit by itself (or didn't start).
+func exitCode(err error) int {
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
 	}
-	return []string{fmt.Sprintf("%s -i %q", base, keyPath), "GIT_TERMINAL_PROMPT=0"}
+	return -1
+}
+
+func env(files authFiles) []string {
+	// The remote's host key must be known; either it's in the
+	// known_hosts file written for the command, or in ssh's own.
+	base := `GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=yes`
+	if files.knownHostsPath != "" {
+		base = fmt.Sprintf("%s -o UserKnownHostsFile=%q", base, files.knownHostsPath)
+	}
+	// Options given later don't override those above, for ssh.
+	if args := files.sshArgs(); len(args) > 0 {
+		base += " " + shellJoin(args)
+	}
+	// gpg needs to find its keyring, to sign commits.
+	var extra []string
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		extra = []string{"GNUPGHOME=" + home}
+	}
+	// Files kept in git LFS are checked out as pointers, if they're
+	// not wanted.
+	if files.skipLFS {
+		extra = append(extra, "GIT_LFS_SKIP_SMUDGE=1")
+	}
+	if files.keyPath == "" && files.askPassPath == "" {
+		return append([]string{base}, extra...)
+	}
+	vars := append([]string{base, "GIT_TERMINAL_PROMPT=0"}, extra...)
+	if files.keyPath != "" {
+		vars[0] = fmt.Sprintf("%s -i %q", base, files.keyPath)
+	}
+	if files.askPassPath != "" {
+		// The token is given to the script in its environment,
+		// rather than written to a file.
+		vars = append(vars,
+			"GIT_ASKPASS="+files.askPassPath,
+			"FLUX_GIT_USER="+files.user,
+			"FLUX_GIT_TOKEN="+files.token,
+		)
+	}
+	if files.passphrase != "" {
+		// ssh asks for the key's passphrase with the same script;
+		// it only uses it when told to, or when it has no
+		// terminal but (older versions) a display.
+		vars = append(vars,
+			"SSH_ASKPASS="+files.askPassPath,
+			"SSH_ASKPASS_REQUIRE=force",
+			"FLUX_GIT_KEY_PASSPHRASE="+files.passphrase,
+		)
+		if os.Getenv("DISPLAY") == "" {
+			vars = append(vars, "DISPLAY=none")
+		}
+	}
+	return vars
 }
 
 // check returns true if there are changes locally.
-func check(workingDir, subdir string) bool {
+func check(ctx context.Context, workingDir, subdir string) bool {
 	// `--quiet` means "exit with 1 if there are changes"
-	return execGitCmd(workingDir, "", "diff", "--quiet", "--", subdir) != nil
+	// Compare with HEAD, so that new files that have been added count.
+	// Changes within submodules can't be committed here, so they
+	// don't count.
+	return execGitCmd(ctx, workingDir, authFiles{}, "diff", "--quiet", "--ignore-submodules", "HEAD", "--", subdir) != nil
+}
+
+// auth is how to authenticate to the remote: with an SSH key, or, for
+// HTTPS remotes, with a username and token. It also has the HTTP
+// settings (user agent and extra headers) for talking to the remote,
+// as git config settings, and how to connect to SSH remotes.
+type auth struct {
+	key         string
+	passphrase  string
+	user, token string
+	http        []string
+	knownHosts  string
+	ssh         SSHOptions
+	skipLFS     bool
+}
+
+// authFiles are the files written for a git command that talks to the
+// remote, so that it can authenticate, along with its HTTP settings.
+type authFiles struct {
+	keyPath        string
+	askPassPath    string
+	knownHostsPath string
+	passphrase     string
+	user, token    string
+	http           []string
+	ssh            SSHOptions
+	skipLFS        bool
+}
+
+// askPassScript answers git's prompts for a username and password
+// (which will be for an HTTPS remote) with the username and token, and
+// ssh's prompt for the key's passphrase with the passphrase.
+const askPassScript = `#!/bin/sh
+case "$1" in
+Enter\ passphrase*) echo "$FLUX_GIT_KEY_PASSPHRASE" ;;
+Username*) echo "$FLUX_GIT_USER" ;;
+*) echo "$FLUX_GIT_TOKEN" ;;
+esac
+`
+
+func (a auth) write() (authFiles, error) {
+	var files authFiles
+	// Otherwise ssh would only say the key was refused.
+	if err := CheckKeyPassphrase(a.key, a.passphrase); err != nil {
+		return files, err
+	}
+	keyPath, err := writeTempFile("flux-key", a.key, 0400)
+	if err != nil {
+		return files, err
+	}
+	files.keyPath = keyPath
+	files.http = a.http
+	files.ssh = a.ssh
+	files.skipLFS = a.skipLFS
+	if a.token != "" || a.passphrase != "" {
+		askPassPath, err := writeTempFile("flux-askpass", askPassScript, 0500)
+		if err != nil {
+			files.remove()
+			return authFiles{}, err
+		}
+		files.askPassPath, files.user, files.token = askPassPath, a.user, a.token
+		files.passphrase = a.passphrase
+	}
+	if a.knownHosts != "" {
+		knownHostsPath, err := writeTempFile("flux-known-hosts", a.knownHosts, 0400)
+		if err != nil {
+			files.remove()
+			return authFiles{}, err
+		}
+		files.knownHostsPath = knownHostsPath
+	}
+	return files, nil
 }
 
-func writeKey(keyData string) (string, error) {
-	f, err := ioutil.TempFile("", "flux-key")
+func (f authFiles) remove() {
+	if f.keyPath != "" {
+		os.Remove(f.keyPath)
+	}
+	if f.askPassPath != "" {
+		os.Remove(f.askPassPath)
+	}
+	if f.knownHostsPath != "" {
+		os.Remove(f.knownHostsPath)
+	}
+}
+
+func writeTempFile(prefix, data string, mode os.FileMode) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
 	if err != nil {
 		return "", err
 	}
@@ -102,7 +574,13 @@ func writeKey(keyData string) (string, error) {
 		os.Remove(f.Name())
 		return "", err
 	}
-	if err := ioutil.WriteFile(f.Name(), []byte(keyData), 0400); err != nil {
+	// WriteFile only uses the mode when creating the file, and
+	// TempFile has already done that.
+	if err := ioutil.WriteFile(f.Name(), []byte(data), mode); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
 		os.Remove(f.Name())
 		return "", err
 	}
@@ -0,0 +1,699 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+const blameOutput = `0aa95c348c1cf5322a4b6ea6b1cdd3590f97ee12 1 1 1
+author Weave Flux
+author-mail <support@weave.works>
+author-time 1490000000
+author-tz +0000
+committer Weave Flux
+committer-mail <support@weave.works>
+committer-time 1490000000
+committer-tz +0000
+summary Release alpine:1 to default/helloworld
+boundary
+filename helloworld-deploy.yaml
+	image: alpine:1
+e5872b73ef439d3bd1c5ea9492c2bf1a4093abfc 2 2 1
+author Bob
+author-mail <bob@example.com>
+author-time 1490000600
+author-tz +0000
+committer Bob
+committer-mail <bob@example.com>
+committer-time 1490000600
+committer-tz +0000
+summary Bump the replicas
+previous 0aa95c348c1cf5322a4b6ea6b1cdd3590f97ee12 helloworld-deploy.yaml
+filename helloworld-deploy.yaml
+	replicas: 3
+`
+
+func TestParseBlame(t *testing.T) {
+	lines, err := parseBlame(strings.NewReader(blameOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BlameLine{
+		{"0aa95c348c1cf5322a4b6ea6b1cdd3590f97ee12", "Weave Flux <support@weave.works>", time.Unix(1490000000, 0).UTC()},
+		{"e5872b73ef439d3bd1c5ea9492c2bf1a4093abfc", "Bob <bob@example.com>", time.Unix(1490000600, 0).UTC()},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d: %+v", len(expected), len(lines), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d: expected %+v, got %+v", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestAskPass(t *testing.T) {
+	files, err := auth{user: "flux", token: "s3cr3t"}.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	if files.askPassPath == "" {
+		t.Fatal("expected an askpass script to be written for a token")
+	}
+
+	for prompt, expected := range map[string]string{
+		"Username for 'https://github.com': ":      "flux",
+		"Password for 'https://flux@github.com': ": "s3cr3t",
+	} {
+		c := exec.Command(files.askPassPath, prompt)
+		c.Env = env(files)
+		out, err := c.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(out)); got != expected {
+			t.Errorf("%q: expected %q, got %q", prompt, expected, got)
+		}
+	}
+
+	files, err = auth{key: "key"}.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	for _, v := range env(files) {
+		if strings.HasPrefix(v, "GIT_ASKPASS=") {
+			t.Errorf("expected no askpass script without a token, got %s", v)
+		}
+	}
+
+	// ssh asks the same script for the key's passphrase
+	files, err = auth{key: "key", passphrase: "open sesame"}.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	c := exec.Command(files.askPassPath, "Enter passphrase for key '/tmp/flux-key123': ")
+	c.Env = env(files)
+	out, err := c.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "open sesame" {
+		t.Errorf("expected the passphrase, got %q", got)
+	}
+	var askPass bool
+	for _, v := range env(files) {
+		askPass = askPass || v == "SSH_ASKPASS="+files.askPassPath
+	}
+	if !askPass {
+		t.Error("expected ssh to be given the askpass script for a passphrase")
+	}
+}
+
+func TestCommitAndPushToBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-branch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master"}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	before, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPushToBranch(working, "Scale up", "", "flux-release-1"); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commit is on the new branch, and the repo's own branch is
+	// left as it was
+	for branch, expected := range map[string]string{"flux-release-1": pushed, "master": before} {
+		out := &bytes.Buffer{}
+		if err := execGitCmdOut(context.Background(), repo.URL, authFiles{}, out, "rev-parse", branch); err != nil {
+			t.Fatal(err)
+		}
+		if rev := strings.TrimSpace(out.String()); rev != expected {
+			t.Errorf("expected %s at %s, got %s", branch, expected, rev)
+		}
+	}
+}
+
+// protectMaster makes the remote refuse pushes to master, as a git
+// host does for a protected branch.
+const protectMaster = `#!/bin/sh
+while read old new ref; do
+  if [ "$ref" = refs/heads/master ]; then
+    echo "master is protected" >&2
+    exit 1
+  fi
+done
+`
+
+func TestPushToBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-refused-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master"}
+	if err := ioutil.WriteFile(filepath.Join(repo.URL, "hooks", "pre-receive"), []byte(protectMaster), 0777); err != nil {
+		t.Fatal(err)
+	}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.CommitAndPush(working, "Scale up")
+	if kind := KindOf(err); kind != PushRejected {
+		t.Fatalf("expected the push to master to be %q, got %q (%v)", PushRejected, kind, err)
+	}
+
+	if err := repo.PushToBranch(working, "flux-refused"); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(context.Background(), repo.URL, authFiles{}, out, "rev-parse", "flux-refused"); err != nil {
+		t.Fatal(err)
+	}
+	if rev := strings.TrimSpace(out.String()); rev != pushed {
+		t.Errorf("expected flux-refused at %s, got %s", pushed, rev)
+	}
+
+	if err := repo.PushToBranch(working, "flux-refused"); err != ErrBranchExisted {
+		t.Errorf("expected ErrBranchExisted pushing to the branch again, got %v", err)
+	}
+}
+
+func TestCloneCreateBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-create-branch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := setupRemote(t, dir)
+	mirror := NewMirror(filepath.Join(dir, "mirrors"), time.Hour)
+	for _, m := range []*Mirror{nil, mirror} {
+		branch := "flux-sync"
+		if m != nil {
+			branch = "flux-sync-mirrored"
+		}
+		repo := Repo{URL: remote, Branch: branch, Mirror: m}
+		if _, err := repo.Clone(); err == nil {
+			t.Errorf("expected an error cloning branch %s, which doesn't exist", branch)
+		}
+
+		var created []string
+		repo.CreateBranch = true
+		repo.BranchCreated = func(branch, revision string) {
+			created = append(created, branch+"@"+revision)
+		}
+		for i := 0; i < 2; i++ {
+			working, err := repo.Clone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(filepath.Dir(working))
+		}
+
+		// The branch was created once, from master
+		out := &bytes.Buffer{}
+		if err := execGitCmdOut(context.Background(), remote, authFiles{}, out, "rev-parse", "master"); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{branch + "@" + strings.TrimSpace(out.String())}
+		if !reflect.DeepEqual(created, expected) {
+			t.Errorf("expected branch created as %v, got %v", expected, created)
+		}
+	}
+}
+
+func TestSparseClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-sparse-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A repo with the resource definitions in one directory, and
+	// other things elsewhere
+	remote := setupRemote(t, dir)
+	working, err := (Repo{URL: remote, Branch: "master"}).Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	for _, file := range []string{"deploy/prod/app.yaml", "src/main.go", "docs/README"} {
+		if err := os.MkdirAll(filepath.Join(working, filepath.Dir(file)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(working, file), []byte(file+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (Repo{}).Add(working, "."); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Repo{URL: remote, Branch: "master"}).CommitAndPush(working, "Add files"); err != nil {
+		t.Fatal(err)
+	}
+
+	mirror := NewMirror(filepath.Join(dir, "mirrors"), time.Hour)
+	for i, m := range []*Mirror{nil, mirror} {
+		repo := Repo{URL: remote, Branch: "master", Path: "deploy/prod/", Sparse: true, Mirror: m}
+		sparse, err := repo.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(filepath.Dir(sparse))
+
+		// Only the files under the path, and at the top, are there
+		for file, expected := range map[string]bool{
+			"deploy.yaml":          true,
+			"deploy/prod/app.yaml": true,
+			"src/main.go":          false,
+			"docs/README":          false,
+		} {
+			if _, err := os.Stat(filepath.Join(sparse, file)); (err == nil) != expected {
+				t.Errorf("expected %s to be checked out: %v; got %v", file, expected, err)
+			}
+		}
+
+		// Changes are committed and pushed as usual, and leave the
+		// files not checked out as they were
+		if err := ioutil.WriteFile(filepath.Join(sparse, "deploy/prod/app.yaml"), []byte(fmt.Sprintf("replicas: %d\n", i+2)), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.CommitAndPush(sparse, "Scale up"); err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if err := execGitCmdOut(context.Background(), remote, authFiles{}, out, "ls-tree", "-r", "--name-only", "master"); err != nil {
+			t.Fatal(err)
+		}
+		if files := strings.Fields(out.String()); len(files) != 4 {
+			t.Errorf("expected all four files still in the repo, got %v", files)
+		}
+	}
+}
+
+func TestCommitAndPushAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-author-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master"}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPushAs(working, "Scale up", "Bob <bob@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user is the author, and flux stays the committer
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(context.Background(), repo.URL, authFiles{}, out, "log", "-1", "--format=%an <%ae>|%cn <%ce>", "master"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Bob <bob@example.com>|Weave Flux <support@weave.works>"
+	if got := strings.TrimSpace(out.String()); got != expected {
+		t.Errorf("expected author|committer %q, got %q", expected, got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-read-only-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master", ReadOnly: true}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	before, err := repo.HeadRevision(working)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(working, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPush(working, "Scale up"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly committing, got %v", err)
+	}
+	if err := repo.CommitAndPushToBranch(working, "Scale up", "", "flux-release"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly committing to a branch, got %v", err)
+	}
+	out := &bytes.Buffer{}
+	if err := execGitCmdOut(context.Background(), repo.URL, authFiles{}, out, "rev-parse", "master"); err != nil {
+		t.Fatal(err)
+	}
+	if after := strings.TrimSpace(out.String()); after != before {
+		t.Errorf("expected master to stay at %s, got %s", before, after)
+	}
+
+	// A missing branch isn't created, even if asked
+	repo.Branch, repo.CreateBranch = "flux-sync", true
+	if _, err := repo.Clone(); KindOf(err) != RefNotFound {
+		t.Errorf("expected the branch not to be found, got %v", err)
+	}
+}
+
+func TestCloneTimeout(t *testing.T) {
+	// A remote that takes the connection, then never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	repo := Repo{URL: "git://" + l.Addr().String() + "/conf", Branch: "master", Timeout: 200 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		path, err := repo.Clone()
+		if err == nil {
+			os.RemoveAll(filepath.Dir(path))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Errorf("expected the clone to time out, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("clone still hanging after its timeout")
+	}
+}
+
+func TestCommitAndPushRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-race-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A file:// URL, so the clones are shallow, as they would be
+	// from a real remote
+	repo := Repo{URL: "file://" + setupRemote(t, dir), Branch: "master", PushRetries: 1}
+
+	// change clones the repo, and returns a func that writes the
+	// file given in the clone, then commits and pushes it.
+	change := func(r Repo, file, content string) func() error {
+		working, err := r.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return func() error {
+			defer os.RemoveAll(filepath.Dir(working))
+			if err := ioutil.WriteFile(filepath.Join(working, file), []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Add(working, file); err != nil {
+				t.Fatal(err)
+			}
+			return r.CommitAndPush(working, "Change "+file)
+		}
+	}
+
+	// The second push is rejected, but the change doesn't conflict
+	// with the first, so it's rebased and pushed again.
+	first, second := change(repo, "deploy.yaml", "replicas: 2\n"), change(repo, "service.yaml", "port: 80\n")
+	if err := first(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second(); err != nil {
+		t.Fatalf("expected the second push to succeed after rebasing, got %v", err)
+	}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	for file, expected := range map[string]string{"deploy.yaml": "replicas: 2\n", "service.yaml": "port: 80\n"} {
+		got, err := ioutil.ReadFile(filepath.Join(working, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Errorf("%s: expected %q, got %q", file, expected, got)
+		}
+	}
+
+	// Conflicting changes can't both be pushed
+	first, second = change(repo, "deploy.yaml", "replicas: 3\n"), change(repo, "deploy.yaml", "replicas: 4\n")
+	if err := first(); err != nil {
+		t.Fatal(err)
+	}
+	err = second()
+	if KindOf(err) != Conflict {
+		t.Fatalf("expected a conflicting push to fail with a conflict, got %v", err)
+	}
+	conflict := flux.ConflictOf(err)
+	if conflict == nil || len(conflict.Files) != 1 || conflict.Files[0].Path != "deploy.yaml" {
+		t.Fatalf("expected a conflict in deploy.yaml, got %+v", conflict)
+	}
+	if hunks := conflict.Files[0].Hunks; len(hunks) != 1 ||
+		!strings.Contains(hunks[0], "replicas: 3\n=======\nreplicas: 4\n") {
+		t.Errorf("expected the conflicting lines, got %q", hunks)
+	}
+
+	// Nor can any change, without retries
+	noRetries := repo
+	noRetries.PushRetries = 0
+	first, second = change(noRetries, "deploy.yaml", "replicas: 5\n"), change(noRetries, "other.yaml", "port: 81\n")
+	if err := first(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second(); err == nil || !rejected(err.(flux.UserConfigProblem).Err) {
+		t.Errorf("expected the push to be rejected, got %v", err)
+	}
+}
+
+func TestCloneSubmodules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-submodule-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "shared"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	shared := setupRemote(t, filepath.Join(dir, "shared"))
+	remote := setupRemote(t, dir)
+
+	// Add the shared repo to the remote as a submodule. git refuses
+	// local submodules unless told otherwise.
+	allowFile := auth{http: []string{"protocol.file.allow=always"}}
+	files, err := allowFile.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	working := filepath.Join(dir, "files")
+	for _, args := range [][]string{
+		{"submodule", "add", "-q", shared, "templates"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Add templates"},
+		{"push", "-q", remote, "master"},
+	} {
+		if err := execGitCmd(context.Background(), working, files, args...); err != nil {
+			t.Fatalf("git %v: %s", args, err)
+		}
+	}
+
+	for _, submodules := range []bool{false, true} {
+		cloneDir, err := ioutil.TempDir(dir, "clone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := clone(context.Background(), cloneDir, allowFile, "file://"+remote, "master", "", submodules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(filepath.Join(path, "templates", "deploy.yaml"))
+		if submodules && err != nil {
+			t.Errorf("expected the submodule's files in the clone, got %v", err)
+		}
+		if !submodules && err == nil {
+			t.Error("expected the submodule to be left out of the clone")
+		}
+		if !submodules {
+			// As in a working clone from a mirror
+			if err := updateSubmodules(context.Background(), allowFile, path); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(path, "templates", "deploy.yaml")); err != nil {
+				t.Errorf("expected the submodule's files after updating, got %v", err)
+			}
+		}
+		// Changes in a submodule aren't changes to the repo
+		if err := ioutil.WriteFile(filepath.Join(path, "templates", "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if check(context.Background(), path, ".") {
+			t.Error("expected changes in a submodule not to count")
+		}
+	}
+}
+
+func TestCloneLFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-lfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master"}
+	working, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(working))
+	const pointer = "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+	for name, content := range map[string]string{
+		".gitattributes": "# assets are big\n*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"logo.bin":       pointer,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(working, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Add(working, ".gitattributes", "logo.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAndPush(working, "Add assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without git-lfs, a clone that would have pointers is refused
+	_, err = repo.Clone()
+	switch {
+	case lfsInstalled() && err != nil:
+		t.Errorf("expected a clone with git-lfs installed, got %v", err)
+	case !lfsInstalled() && KindOf(err) != LFSFailed:
+		t.Errorf("expected an LFS error without git-lfs installed, got %v", err)
+	}
+
+	// When skipping, the pointers are checked out as they are
+	repo.SkipLFS = true
+	skipped, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(skipped))
+	if content, err := ioutil.ReadFile(filepath.Join(skipped, "logo.bin")); err != nil || string(content) != pointer {
+		t.Errorf("expected the LFS pointer, got %q (%v)", content, err)
+	}
+}
+
+func TestConfigTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-tree-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Repo{URL: setupRemote(t, dir), Branch: "master"}
+	first, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(first))
+	second, err := repo.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(second))
+
+	// Clones of the same commit have the same tree
+	tree, err := repo.ConfigTree(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree == "" {
+		t.Fatal("expected a tree for a clean clone")
+	}
+	if other, err := repo.ConfigTree(second); err != nil || other != tree {
+		t.Fatalf("expected the same tree %q for another clone, got %q (%v)", tree, other, err)
+	}
+
+	// New files, and changed files, mean there's no tree to go by
+	if err := ioutil.WriteFile(filepath.Join(second, "new.yaml"), []byte("replicas: 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if other, err := repo.ConfigTree(second); err != nil || other != "" {
+		t.Fatalf("expected no tree with a new file, got %q (%v)", other, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(first, "deploy.yaml"), []byte("replicas: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if other, err := repo.ConfigTree(first); err != nil || other != "" {
+		t.Fatalf("expected no tree with a changed file, got %q (%v)", other, err)
+	}
+
+	// Once committed, the tree is a different one
+	if err := repo.CommitAndPush(first, "Scale up"); err != nil {
+		t.Fatal(err)
+	}
+	if other, err := repo.ConfigTree(first); err != nil || other == "" || other == tree {
+		t.Fatalf("expected a new tree after committing, got %q (%v)", other, err)
+	}
+}
@@ -0,0 +1,125 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueTimeout is returned when an operation has waited so long
+// for others on the same branch that it's run out of time.
+var ErrQueueTimeout = errors.New("timed out waiting for other operations on the repo to finish")
+
+// Queue serialises the writes (commits and pushes) to each branch of
+// each repo, so that concurrent operations for an instance (e.g., a
+// release and an automated release) take turns rather than racing to
+// push. Reads don't go through the queue: each operation has its own
+// working clone, made from the pristine mirror (or the remote), so
+// reading can go ahead while another operation is writing.
+//
+// Operations are let through in the order they arrived.
+type Queue struct {
+	mu        sync.Mutex
+	checkouts map[string]*checkoutQueue
+	waiting   int
+}
+
+// checkoutQueue is the queue for one branch of one repo. There's a
+// ticket for each operation in the queue, the first of which is
+// closed when it's that operation's turn.
+type checkoutQueue struct {
+	tickets []chan struct{}
+}
+
+func NewQueue() *Queue {
+	return &Queue{checkouts: map[string]*checkoutQueue{}}
+}
+
+func queueKey(r Repo) string {
+	return r.URL + "\x00" + r.Branch
+}
+
+// Acquire waits for the operations on the repo's branch already in
+// the queue to finish, or until the context is done, and gives a func
+// to call when the operation has finished with the branch.
+func (q *Queue) Acquire(ctx context.Context, r Repo) (release func(), err error) {
+	key := queueKey(r)
+	ticket := make(chan struct{})
+
+	q.mu.Lock()
+	c, ok := q.checkouts[key]
+	if !ok {
+		c = &checkoutQueue{}
+		q.checkouts[key] = c
+	}
+	c.tickets = append(c.tickets, ticket)
+	queued := len(c.tickets) > 1
+	if queued {
+		q.waiting++
+		queuedOperations.Set(float64(q.waiting))
+	} else {
+		close(ticket)
+	}
+	q.mu.Unlock()
+
+	begin := time.Now()
+	select {
+	case <-ticket:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if queued {
+		q.waiting--
+		queuedOperations.Set(float64(q.waiting))
+	}
+	// The turn may have come just as the context was done; if so, it's
+	// taken.
+	select {
+	case <-ticket:
+	default:
+		q.remove(key, c, ticket)
+		queueWaitDuration.Observe(time.Since(begin).Seconds())
+		return nil, ErrQueueTimeout
+	}
+	queueWaitDuration.Observe(time.Since(begin).Seconds())
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.remove(key, c, ticket)
+		})
+	}, nil
+}
+
+// remove takes the ticket out of the queue, letting the next
+// operation through if it was at the front.
+func (q *Queue) remove(key string, c *checkoutQueue, ticket chan struct{}) {
+	for i, t := range c.tickets {
+		if t != ticket {
+			continue
+		}
+		c.tickets = append(c.tickets[:i], c.tickets[i+1:]...)
+		if i == 0 && len(c.tickets) > 0 {
+			close(c.tickets[0])
+		}
+		break
+	}
+	if len(c.tickets) == 0 {
+		delete(q.checkouts, key)
+	}
+}
+
+// Len gives the number of operations in the queue for the repo's
+// branch, including the one whose turn it is.
+func (q *Queue) Len(r Repo) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if c, ok := q.checkouts[queueKey(r)]; ok {
+		return len(c.tickets)
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package git
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	q := NewQueue()
+	repo := Repo{URL: "git@example.com:org/config", Branch: "master"}
+
+	release, err := q.Acquire(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another branch doesn't wait
+	other, err := q.Acquire(context.Background(), Repo{URL: repo.URL, Branch: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	turns := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			release, err := q.Acquire(context.Background(), repo)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			turns <- i
+			release()
+		}(i)
+		// Make sure they're queued in order
+		for q.Len(repo) != i+2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case i := <-turns:
+		t.Fatalf("expected no turn before release, got %d", i)
+	default:
+	}
+	release()
+	release() // a second time does nothing
+	for i := 0; i < 3; i++ {
+		if turn := <-turns; turn != i {
+			t.Errorf("expected turn %d, got %d", i, turn)
+		}
+	}
+	for q.Len(repo) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if len(q.checkouts) != 0 {
+		t.Errorf("expected no queues left, got %d", len(q.checkouts))
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	q := NewQueue()
+	repo := Repo{URL: "git@example.com:org/config", Branch: "master"}
+
+	release, err := q.Acquire(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, repo); err != ErrQueueTimeout {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if n := q.Len(repo); n != 1 {
+		t.Errorf("expected the timed out operation to have left the queue, got %d", n)
+	}
+	release()
+	next, err := q.Acquire(context.Background(), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next()
+	if q.waiting != 0 {
+		t.Errorf("expected none waiting, got %d", q.waiting)
+	}
+}
@@ -1,15 +1,26 @@
 package git
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
-	ErrNoChanges = errors.New("no changes made in repo")
+	ErrNoChanges     = errors.New("no changes made in repo")
+	ErrBranchExisted = errors.New("branch already exists in repo")
 )
 
+// DefaultTimeout is how long an operation on a repo (e.g., a clone,
+// or a commit and push) may take, if the repo doesn't say.
+const DefaultTimeout = 2 * time.Minute
+
 // Repo represents a remote git repo
 type Repo struct {
 	// The URL to the config repo that holds the resource definition files. For
@@ -23,36 +34,357 @@ type Repo struct {
 	// permissions to clone and push to the config repo.
 	Key string
 
+	// KeyPassphrase is the passphrase for Key, if it's encrypted.
+	KeyPassphrase string
+
+	// For HTTPS remotes, the username and token (e.g., a GitHub
+	// personal access token) with permissions to clone and push to the
+	// config repo. These are used instead of a key, for people who
+	// can't add a deploy key.
+	User  string
+	Token string
+
 	// The path within the config repo where files are stored.
 	Path string
+
+	// KnownHosts, in the format of an ssh known_hosts file, has the
+	// host keys of SSH remotes. Remotes whose host key is neither here
+	// nor in ssh's own known_hosts files are refused.
+	KnownHosts string
+
+	// SSH is how to connect to SSH remotes, if not directly on port
+	// 22.
+	SSH SSHOptions
+
+	// SigningKey, if not blank, is the ID of the GPG key to sign
+	// commits with, e.g., for branches that only accept signed
+	// commits.
+	SigningKey string
+
+	// UserAgent and Headers, if given, are sent with requests to HTTPS
+	// remotes, e.g., for a proxy which wants them.
+	UserAgent string
+	Headers   map[string]string
+
+	// PushRetries is how many times to try again, having rebased onto
+	// the branch, when a push to the branch is rejected because the
+	// branch has moved on (e.g., because of another release).
+	PushRetries int
+
+	// Submodules says whether to check out the repo's submodules in
+	// clones, e.g., because the resource definitions include files
+	// from a shared repo. Files in submodules are read like any
+	// others, but changes to them aren't committed.
+	Submodules bool
+
+	// Sparse says to check out only the files under Path (and those
+	// at the top of the repo) in clones, e.g., because Path is a small
+	// part of a very large repo. Where the remote allows, the other
+	// files aren't even fetched. It needs git 2.25 or later.
+	Sparse bool
+
+	// SkipLFS says not to fetch the files kept in git LFS when
+	// cloning; they're checked out as LFS pointers. It's for repos
+	// which keep other things (e.g., binary assets) in LFS alongside
+	// the resource definitions, so git-lfs isn't needed.
+	SkipLFS bool
+
+	// Timeout is how long each operation on the repo may take before
+	// the git commands are killed; DefaultTimeout if zero.
+	Timeout time.Duration
+
+	// Mirror, if not nil, is where clones are made from, so that the
+	// repo isn't cloned from scratch each time.
+	Mirror *Mirror
+
+	// Queue, if not nil, is where commits and pushes to the branch
+	// wait their turn, so that concurrent operations (e.g., releases)
+	// don't race to push. Clones, and pushes to other branches, aren't
+	// queued.
+	Queue *Queue
+
+	// CreateBranch says to create the branch, if it doesn't exist
+	// when the repo is cloned, from the repo's default branch, and
+	// push it.
+	CreateBranch bool
+
+	// BranchCreated, if not nil, is called when the branch has been
+	// created, with the commit it was created at; e.g., to record an
+	// event, so that it's clear where the branch came from.
+	BranchCreated func(branch, revision string)
+
+	// ReadOnly says never to write to the repo: commits and pushes
+	// fail with ErrReadOnly, and a missing branch isn't created. The
+	// repo can still be cloned, and read.
+	ReadOnly bool
+
+	// Backend does the git operations on the repo; if nil, it's
+	// ExecBackend, which runs the git binary.
+	Backend Backend
 }
 
+// Clone makes a working clone of the repo's branch, and gives its
+// path. The caller should remove it with Clean when done with it.
 func (r Repo) Clone() (path string, err error) {
 	if r.URL == "" {
 		return "", NoRepoError
 	}
+	path, err = r.clone()
+	if KindOf(err) == RefNotFound && r.CreateBranch && !r.ReadOnly && r.Branch != "" {
+		switch created, cerr := r.createBranch(); {
+		case cerr != nil:
+			return "", cerr
+		case created:
+			return r.clone()
+		}
+	}
+	return path, err
+}
 
-	workingDir, err := ioutil.TempDir(os.TempDir(), "flux-gitclone")
+func (r Repo) clone() (path string, err error) {
+	if r.Mirror != nil {
+		return r.Mirror.WorkingClone(r)
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+
+	workingDir, err := ioutil.TempDir(os.TempDir(), cloneDirPrefix)
 	if err != nil {
 		return "", err
 	}
 
-	repoDir, err := clone(workingDir, r.Key, r.URL, r.Branch)
+	repoDir, err := r.backend().clone(ctx, workingDir, r.auth(), r.URL, r.Branch, r.sparsePath(), r.Submodules)
 	if err != nil {
 		return "", CloningError(r.URL, err)
 	}
+	if err := r.checkLFS(repoDir); err != nil {
+		os.RemoveAll(workingDir)
+		return "", err
+	}
+	register(workingDir)
 	return repoDir, nil
 }
 
+// checkLFS makes sure that, if the clone uses git LFS, the LFS files
+// could have been fetched; otherwise they'd silently be pointers. Git
+// itself doesn't complain when git-lfs isn't installed, unless it's
+// been told the filter is required.
+func (r Repo) checkLFS(repoDir string) error {
+	if r.SkipLFS || !usesLFS(repoDir) {
+		return nil
+	}
+	if lfsInstalled() {
+		return nil
+	}
+	return CloningError(r.URL, &Error{Kind: LFSFailed, Message: "repo uses git LFS, but git-lfs is not installed", ExitCode: -1})
+}
+
+// createBranch creates the repo's branch from the default branch, if
+// it doesn't exist, and pushes it. It says whether the branch was
+// missing; if so, it can be cloned now (even if someone else got
+// there first and created it).
+func (r Repo) createBranch() (bool, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	exists, err := r.backend().branchExists(ctx, r.auth(), r.URL, r.Branch)
+	if err != nil {
+		return false, CloningError(r.URL, err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	workingDir, err := ioutil.TempDir(os.TempDir(), cloneDirPrefix)
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(workingDir)
+	repoDir, err := r.backend().clone(ctx, workingDir, r.auth(), r.URL, "", "", false)
+	if err != nil {
+		return false, CloningError(r.URL, err)
+	}
+	revision, err := r.backend().revision(ctx, repoDir)
+	if err != nil {
+		return false, err
+	}
+	err = r.backend().push(ctx, r.auth(), "HEAD:refs/heads/"+r.Branch, repoDir)
+	if rejected(err) {
+		// Someone else created the branch in the meantime
+		return true, nil
+	}
+	if err != nil {
+		return false, PushError(r.URL, err)
+	}
+	if r.Mirror != nil {
+		r.Mirror.invalidate(r)
+	}
+	if r.BranchCreated != nil {
+		r.BranchCreated(r.Branch, revision)
+	}
+	return true, nil
+}
+
 func (r Repo) CommitAndPush(path, commitMessage string) error {
-	if !check(path, r.Path) {
+	return r.CommitAndPushAs(path, commitMessage, "")
+}
+
+// CommitAndPushAs is like CommitAndPush, but records author (in the
+// form `Name <email>`) as the author of the commit. Flux is still the
+// committer.
+func (r Repo) CommitAndPushAs(path, commitMessage, author string) error {
+	return r.commitAndPush(path, commitMessage, author, r.Branch)
+}
+
+// CommitAndPushToBranch is like CommitAndPushAs, but pushes the commit
+// to a new branch of the repo, e.g., to open a pull request from,
+// rather than to the repo's own branch.
+func (r Repo) CommitAndPushToBranch(path, commitMessage, author, branch string) error {
+	return r.commitAndPush(path, commitMessage, author, "HEAD:refs/heads/"+branch)
+}
+
+// PushToBranch pushes what's been committed in the clone at path to
+// a new branch of the repo, e.g., to open a pull request from, when a
+// push to the repo's own branch was refused. If the branch is already
+// there, it's left as it is, and the error is ErrBranchExisted.
+func (r Repo) PushToBranch(path, branch string) error {
+	if r.ReadOnly {
+		return ErrReadOnly
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	exists, err := r.backend().branchExists(ctx, r.auth(), r.URL, branch)
+	if err != nil {
+		return PushError(r.URL, err)
+	}
+	if exists {
+		return ErrBranchExisted
+	}
+	if err := r.backend().push(ctx, r.auth(), "HEAD:refs/heads/"+branch, path); err != nil {
+		return PushError(r.URL, err)
+	}
+	return nil
+}
+
+func (r Repo) commitAndPush(path, commitMessage, author, refspec string) error {
+	if r.ReadOnly {
+		return ErrReadOnly
+	}
+	// Only pushes to the branch itself contend; branches for pull
+	// requests are new each time.
+	if r.Queue != nil && refspec == r.Branch {
+		ctx, cancel := r.context()
+		release, err := r.Queue.Acquire(ctx, r)
+		cancel()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	if !r.backend().check(ctx, path, r.Path) {
 		return ErrNoChanges
 	}
-	if err := commit(path, commitMessage); err != nil {
+	if err := r.backend().commit(ctx, path, commitMessage, author, r.SigningKey); err != nil {
 		return err
 	}
-	if err := push(r.Key, r.Branch, path); err != nil {
+	err := r.backend().push(ctx, r.auth(), refspec, path)
+	for tries := 0; refspec == r.Branch && rejected(err) && tries < r.PushRetries; tries++ {
+		if err = r.backend().rebase(ctx, r.auth(), path, r.Branch, r.SigningKey); err != nil {
+			break
+		}
+		err = r.backend().push(ctx, r.auth(), refspec, path)
+	}
+	if err != nil {
 		return PushError(r.URL, err)
 	}
+	// The mirror is now behind; the next clone had better fetch.
+	if r.Mirror != nil {
+		r.Mirror.invalidate(r)
+	}
 	return nil
 }
+
+// Add stages files (given relative to the top of the clone at path),
+// so that new files are included in the next commit.
+func (r Repo) Add(path string, files ...string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.backend().add(ctx, path, files...)
+}
+
+// HeadRevision returns the commit checked out in the clone at path.
+func (r Repo) HeadRevision(path string) (string, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.backend().revision(ctx, path)
+}
+
+// ConfigTree gives the hash of the git tree at the repo's Path in the
+// commit checked out in the clone at path. Since a tree's hash changes
+// with its content, it can be used as a key for what's been worked out
+// from the files. If the clone has changes under Path, the tree
+// doesn't say what's in the files, so it gives "".
+func (r Repo) ConfigTree(path string) (string, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.backend().tree(ctx, path, r.Path)
+}
+
+// BlameLine says which commit last changed a line of a file.
+type BlameLine struct {
+	Revision string
+	Author   string // in the form `Name <email>`
+	Time     time.Time
+}
+
+// Blame gives, for each line of the file given (relative to the top of
+// the clone at path, or absolute), the commit that last changed it.
+// Clones are shallow, so this first fetches the rest of the history.
+func (r Repo) Blame(path, file string) ([]BlameLine, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := r.backend().unshallow(ctx, r.auth(), path); err != nil {
+		return nil, err
+	}
+	return r.backend().blame(ctx, path, file)
+}
+
+// sparsePath gives the path to check out in a sparse checkout, or
+// blank to check out everything.
+func (r Repo) sparsePath() string {
+	if !r.Sparse {
+		return ""
+	}
+	path := strings.Trim(filepath.ToSlash(filepath.Clean(r.Path)), "/")
+	if path == "." {
+		return ""
+	}
+	return path
+}
+
+// context gives a context for an operation on the repo, which is done
+// when the repo's timeout is up.
+func (r Repo) context() (context.Context, context.CancelFunc) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (r Repo) auth() auth {
+	var http []string
+	if r.UserAgent != "" {
+		http = append(http, "http.userAgent="+r.UserAgent)
+	}
+	var names []string
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		http = append(http, fmt.Sprintf("http.extraHeader=%s: %s", name, r.Headers[name]))
+	}
+	return auth{key: r.Key, passphrase: r.KeyPassphrase, user: r.User, token: r.Token, http: http, knownHosts: r.KnownHosts, ssh: r.SSH, skipLFS: r.SkipLFS}
+}
@@ -0,0 +1,181 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SSHOptions are how to connect to an SSH remote, beyond its host key
+// and the private key; e.g., for a git server on a port other than
+// 22, or behind a jump host.
+type SSHOptions struct {
+	// Port is the port to connect to, if not 22. A port given in the
+	// URL (`ssh://host:port/...`) takes precedence.
+	Port int
+
+	// JumpHost, if not blank, is a host to connect through, as
+	// `[user@]host[:port]`. Its host key is checked like the
+	// remote's, and the same private key is used for it.
+	JumpHost string
+
+	// ProxyCommand, if not blank, is a command connected to the
+	// remote's SSH server, as for ssh's ProxyCommand; `%h` and `%p`
+	// are the remote's host and port. It runs wherever git runs, so
+	// it's for whoever runs flux to give, rather than instance
+	// config (which CheckSSHOptions refuses it in).
+	ProxyCommand string
+
+	// Options are other ssh options, as for `ssh -o Name=value`.
+	Options map[string]string
+}
+
+// allowedSSHOptions are the ssh options that may be given in an
+// instance's config. Others may run commands, read or write files,
+// or weaken the checking of host keys.
+var allowedSSHOptions = map[string]bool{
+	"addressfamily":          true,
+	"ciphers":                true,
+	"compression":            true,
+	"connectionattempts":     true,
+	"connecttimeout":         true,
+	"hostkeyalgorithms":      true,
+	"hostkeyalias":           true,
+	"identitiesonly":         true,
+	"ipqos":                  true,
+	"kexalgorithms":          true,
+	"loglevel":               true,
+	"macs":                   true,
+	"pubkeyacceptedkeytypes": true,
+	"serveralivecountmax":    true,
+	"serveraliveinterval":    true,
+	"tcpkeepalive":           true,
+	"user":                   true,
+}
+
+var (
+	sshOptionNameRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+	jumpHostRE      = regexp.MustCompile(`^(?:([A-Za-z0-9_][A-Za-z0-9._-]*)@)?([A-Za-z0-9][A-Za-z0-9.-]*)(?::([0-9]+))?$`)
+)
+
+// ParseSSHOptions parses ssh options given as `Name=value`, e.g., as
+// flags.
+func ParseSSHOptions(defs []string) (SSHOptions, error) {
+	options := map[string]string{}
+	for _, def := range defs {
+		i := strings.Index(def, "=")
+		if i < 0 {
+			return SSHOptions{}, fmt.Errorf("invalid ssh option %q; expected Name=value", def)
+		}
+		options[def[:i]] = def[i+1:]
+	}
+	return SSHOptions{Options: options}, nil
+}
+
+// CheckSSHOptions checks SSH options given in an instance's config:
+// they must be well-formed, have no ProxyCommand, and have only the
+// ssh options that are safe to let users give.
+func CheckSSHOptions(o SSHOptions) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.ProxyCommand != "" {
+		return fmt.Errorf("a proxy command cannot be given in config; use a jump host")
+	}
+	for name := range o.Options {
+		if !allowedSSHOptions[strings.ToLower(name)] {
+			return fmt.Errorf("ssh option %s cannot be given in config", name)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the options are well-formed.
+func (o SSHOptions) Validate() error {
+	if o.Port < 0 || o.Port > 65535 {
+		return fmt.Errorf("invalid ssh port %d", o.Port)
+	}
+	if o.JumpHost != "" && o.ProxyCommand != "" {
+		return fmt.Errorf("only one of a jump host and a proxy command can be given")
+	}
+	if o.JumpHost != "" && !jumpHostRE.MatchString(o.JumpHost) {
+		return fmt.Errorf("invalid jump host %q; expected [user@]host[:port]", o.JumpHost)
+	}
+	if strings.ContainsAny(o.ProxyCommand, "\r\n") {
+		return fmt.Errorf("proxy command has a line break")
+	}
+	for name, value := range o.Options {
+		if !sshOptionNameRE.MatchString(name) {
+			return fmt.Errorf("invalid ssh option name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of ssh option %s has a line break", name)
+		}
+	}
+	return nil
+}
+
+// sshArgs gives the arguments to ssh for the options, for a command
+// using the files given.
+func (f authFiles) sshArgs() []string {
+	o := f.ssh
+	var args []string
+	if o.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(o.Port))
+	}
+	var names []string
+	for name := range o.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-o", name+"="+o.Options[name])
+	}
+
+	proxy := o.ProxyCommand
+	if m := jumpHostRE.FindStringSubmatch(o.JumpHost); o.JumpHost != "" && m != nil {
+		// Rather than `ssh -J`, which doesn't pass on the options
+		// for checking host keys, connect through the jump host
+		// with a command that does.
+		jump := []string{"ssh", "-o", "StrictHostKeyChecking=yes"}
+		if f.knownHostsPath != "" {
+			jump = append(jump, "-o", "UserKnownHostsFile="+f.knownHostsPath)
+		}
+		if f.keyPath != "" {
+			jump = append(jump, "-i", f.keyPath)
+		}
+		if m[1] != "" {
+			jump = append(jump, "-l", m[1])
+		}
+		if m[3] != "" {
+			jump = append(jump, "-p", m[3])
+		}
+		jump = append(jump, "-W", "%h:%p", m[2])
+		proxy = shellJoin(jump)
+	}
+	if proxy != "" {
+		args = append(args, "-o", "ProxyCommand="+proxy)
+	}
+	return args
+}
+
+// shellJoin quotes each argument for the shell, where it needs it,
+// and joins them into a command.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+var shellSafeRE = regexp.MustCompile(`^[A-Za-z0-9_./:@%=+-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafeRE.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
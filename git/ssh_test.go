@@ -0,0 +1,80 @@
+package git
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sshCommand gives the arguments of the ssh command in the
+// environment, as the shell would split them.
+func sshCommand(t *testing.T, vars []string) []string {
+	for _, v := range vars {
+		if !strings.HasPrefix(v, "GIT_SSH_COMMAND=") {
+			continue
+		}
+		out, err := exec.Command("sh", "-c", `for a in `+strings.TrimPrefix(v, "GIT_SSH_COMMAND=")+`; do echo "$a"; done`).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	}
+	t.Fatal("no GIT_SSH_COMMAND in environment")
+	return nil
+}
+
+func TestSSHCommand(t *testing.T) {
+	files := authFiles{
+		knownHostsPath: "/tmp/known hosts",
+		keyPath:        "/tmp/key",
+		ssh: SSHOptions{
+			Port:     2222,
+			JumpHost: "deploy@bastion.example.com:2200",
+			Options:  map[string]string{"HostKeyAlias": "git.internal", "ConnectTimeout": "10"},
+		},
+	}
+	expected := []string{
+		"ssh", "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/tmp/known hosts",
+		"-p", "2222", "-o", "ConnectTimeout=10", "-o", "HostKeyAlias=git.internal",
+		"-o", "ProxyCommand=ssh -o StrictHostKeyChecking=yes -o 'UserKnownHostsFile=/tmp/known hosts' -i /tmp/key -l deploy -p 2200 -W %h:%p bastion.example.com",
+		"-i", "/tmp/key",
+	}
+	if got := sshCommand(t, env(files)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected ssh command\n%q\ngot\n%q", expected, got)
+	}
+
+	files.ssh = SSHOptions{ProxyCommand: "nc -X connect -x proxy:3128 %h %p"}
+	got := sshCommand(t, env(files))
+	if last := got[len(got)-3]; last != "ProxyCommand=nc -X connect -x proxy:3128 %h %p" {
+		t.Errorf("expected proxy command option, got %q", got)
+	}
+}
+
+func TestCheckSSHOptions(t *testing.T) {
+	for _, o := range []SSHOptions{
+		{},
+		{Port: 2222, JumpHost: "bastion.example.com"},
+		{JumpHost: "user@10.0.0.1:22", Options: map[string]string{"ServerAliveInterval": "30"}},
+	} {
+		if err := CheckSSHOptions(o); err != nil {
+			t.Errorf("%+v: %s", o, err)
+		}
+	}
+	for _, o := range []SSHOptions{
+		{Port: 70000},
+		{JumpHost: "-oProxyCommand=evil"},
+		{JumpHost: "bastion; rm -rf /"},
+		{JumpHost: "-oLocalCommand"},
+		{JumpHost: "-l@bastion"},
+		{ProxyCommand: "nc %h %p"},
+		{Options: map[string]string{"ProxyCommand": "nc %h %p"}},
+		{Options: map[string]string{"StrictHostKeyChecking": "no"}},
+		{Options: map[string]string{"Connect Timeout": "10"}},
+		{Options: map[string]string{"ConnectTimeout": "10\nProxyCommand nc %h %p"}},
+	} {
+		if err := CheckSSHOptions(o); err == nil {
+			t.Errorf("%+v: expected error", o)
+		}
+	}
+}
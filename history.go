@@ -0,0 +1,94 @@
+package flux
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryQuery says which entries to give from a history. Entries are
+// given newest first, a page at a time: the cursor of the last entry
+// of a page is given as BeforeCursor to get the next (older) page, and
+// the cursor of the first as AfterCursor to get the previous one.
+type HistoryQuery struct {
+	// Before, if not zero, is the time before which entries must
+	// have been recorded.
+	Before time.Time
+	// BeforeCursor and AfterCursor, if not blank, are the cursors of
+	// entries the entries given must come before (or after).
+	BeforeCursor string
+	AfterCursor  string
+	// Types, if not empty, are the only types of event to give.
+	Types []string
+	// Limit is how many entries to give at most, or -1 for no limit.
+	Limit int64
+}
+
+// Problems gives the reasons the query can't be answered.
+func (q HistoryQuery) Problems() []string {
+	var problems []string
+	for _, cursor := range []string{q.BeforeCursor, q.AfterCursor} {
+		if cursor == "" {
+			continue
+		}
+		if _, err := ParseHistoryCursor(cursor); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, t := range q.Types {
+		if !IsEventType(t) {
+			problems = append(problems, fmt.Sprintf("unknown event type %q", t))
+		}
+	}
+	if q.Limit < -1 {
+		problems = append(problems, fmt.Sprintf("invalid limit %d", q.Limit))
+	}
+	return problems
+}
+
+// HistoryPage is a page of entries from a history, along with the
+// total number of entries of the kind asked for (i.e., of the types,
+// and before the time, given), on every page.
+type HistoryPage struct {
+	Entries []HistoryEntry
+	Total   int64
+}
+
+// Cursors are opaque to clients, so that what they mark can change.
+const historyCursorPrefix = "seq:"
+
+// HistoryCursor gives the cursor of the event with the sequence number
+// given.
+func HistoryCursor(sequence int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(historyCursorPrefix + strconv.FormatInt(sequence, 10)))
+}
+
+// ParseHistoryCursor gives the sequence number of the event the
+// cursor marks.
+func ParseHistoryCursor(cursor string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), historyCursorPrefix) {
+		sequence, err := strconv.ParseInt(strings.TrimPrefix(string(b), historyCursorPrefix), 10, 64)
+		if err == nil && sequence > 0 {
+			return sequence, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid history cursor %q", cursor)
+}
+
+// InvalidHistoryQuery is the error for asking for a part of a history
+// that can't be given.
+func InvalidHistoryQuery(problems []string) error {
+	return UserConfigProblem{BaseError: &BaseError{
+		Help: fmt.Sprintf(`The history can't be given, because of these problems:
+
+    %s
+
+Cursors must be taken from the entries of an earlier page. The types of
+event are %s.
+`, strings.Join(problems, "\n    "), strings.Join(eventTypes, ", ")),
+		Err: fmt.Errorf("invalid history query: %s", strings.Join(problems, "; ")),
+	}}
+}
@@ -0,0 +1,73 @@
+package history
+
+import (
+	"github.com/weaveworks/flux"
+)
+
+var policyEvents = map[string]struct {
+	policy flux.Policy
+	value  string
+}{
+	flux.EventAutomate:   {flux.PolicyAutomated, "true"},
+	flux.EventDeautomate: {flux.PolicyAutomated, "false"},
+	flux.EventLock:       {flux.PolicyLocked, "true"},
+	flux.EventUnlock:     {flux.PolicyLocked, "false"},
+}
+
+// PolicyChanges gives the last change to each policy of a service,
+// from its automate, deautomate, lock and unlock events. Events may
+// be given in any order.
+func PolicyChanges(events []flux.Event) []flux.ServiceChange {
+	last := map[flux.Policy]flux.ServiceChange{}
+	for _, e := range events {
+		p, ok := policyEvents[e.Type]
+		if !ok {
+			continue
+		}
+		if change, ok := last[p.policy]; ok && !change.Time.Before(e.EndedAt) {
+			continue
+		}
+		last[p.policy] = flux.ServiceChange{
+			Kind:    flux.ServiceChangePolicy,
+			Name:    string(p.policy),
+			Value:   p.value,
+			Time:    e.EndedAt,
+			EventID: e.ID,
+		}
+	}
+
+	var changes []flux.ServiceChange
+	for _, p := range []flux.Policy{flux.PolicyAutomated, flux.PolicyLocked} {
+		if change, ok := last[p]; ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// AttributeToReleases fills in, for changes made by a commit that a
+// release made, the release and the user that asked for it.
+func AttributeToReleases(changes []flux.ServiceChange, events []flux.Event) {
+	releases := map[string]flux.Release{}
+	for _, e := range events {
+		if e.Type != flux.EventRelease {
+			continue
+		}
+		metadata, ok := e.Metadata.(flux.ReleaseEventMetadata)
+		if !ok || metadata.Release.Revision == "" {
+			continue
+		}
+		releases[metadata.Release.Revision] = metadata.Release
+	}
+
+	for i, change := range changes {
+		r, ok := releases[change.Revision]
+		if !ok {
+			continue
+		}
+		changes[i].ReleaseID = r.ID
+		if r.Cause.User != "" {
+			changes[i].User = r.Cause.User
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestPolicyChanges(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	t1 := t0.Add(10 * time.Minute)
+
+	events := []flux.Event{
+		{ID: 3, Type: flux.EventUnlock, EndedAt: t1},
+		{ID: 1, Type: flux.EventAutomate, EndedAt: t0},
+		{ID: 2, Type: flux.EventLock, EndedAt: t0},
+	}
+	expected := []flux.ServiceChange{
+		{Kind: flux.ServiceChangePolicy, Name: "automated", Value: "true", Time: t0, EventID: 1},
+		{Kind: flux.ServiceChangePolicy, Name: "locked", Value: "false", Time: t1, EventID: 3},
+	}
+	if changes := PolicyChanges(events); !reflect.DeepEqual(expected, changes) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, changes)
+	}
+}
+
+func TestAttributeToReleases(t *testing.T) {
+	service := flux.ServiceID("default/helloworld")
+	release := releaseEvent(t, "1", time.Now(), flux.ReleaseStatusSuccess, service, "alpine:1")
+	metadata := release.Metadata.(flux.ReleaseEventMetadata)
+	metadata.Release.Cause.User = "alice"
+	release.Metadata = metadata
+
+	changes := []flux.ServiceChange{
+		{Kind: flux.ServiceChangeImage, Name: "app", Revision: "rev-1", User: "Weave Flux <support@weave.works>"},
+		{Kind: flux.ServiceChangeImage, Name: "sidecar", Revision: "rev-0", User: "Bob <bob@example.com>"},
+	}
+	AttributeToReleases(changes, []flux.Event{release})
+	if changes[0].ReleaseID != "1" || changes[0].User != "alice" {
+		t.Errorf("expected change to be attributed to release 1 by alice, got %+v", changes[0])
+	}
+	if changes[1].ReleaseID != "" || changes[1].User != "Bob <bob@example.com>" {
+		t.Errorf("expected change not made by a release to be left alone, got %+v", changes[1])
+	}
+}
@@ -0,0 +1,38 @@
+package history
+
+import (
+	"github.com/weaveworks/flux"
+)
+
+// Groups names sets of instances that belong together -- for example,
+// the clusters of one organisation -- and so may see each other's
+// history. It's given by whoever runs the service, not by instances.
+type Groups map[string][]flux.InstanceID
+
+// Peers gives the instances in any group with the instance given,
+// including the instance itself; or none, if it's not in a group.
+func (g Groups) Peers(inst flux.InstanceID) []flux.InstanceID {
+	var peers []flux.InstanceID
+	seen := map[flux.InstanceID]bool{}
+	for _, members := range g {
+		if !contains(members, inst) {
+			continue
+		}
+		for _, member := range members {
+			if !seen[member] {
+				seen[member] = true
+				peers = append(peers, member)
+			}
+		}
+	}
+	return peers
+}
+
+func contains(instances []flux.InstanceID, inst flux.InstanceID) bool {
+	for _, i := range instances {
+		if i == inst {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package history
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestGroupsPeers(t *testing.T) {
+	groups := Groups{
+		"acme":    {"acme-staging", "acme-prod"},
+		"shared":  {"acme-prod", "partner"},
+		"initech": {"initech-prod"},
+	}
+	for inst, expected := range map[flux.InstanceID][]string{
+		"acme-staging": {"acme-prod", "acme-staging"},
+		"acme-prod":    {"acme-prod", "acme-staging", "partner"},
+		"initech-prod": {"initech-prod"},
+		"loner":        nil,
+	} {
+		var peers []string
+		for _, p := range groups.Peers(inst) {
+			peers = append(peers, string(p))
+		}
+		sort.Strings(peers)
+		if len(peers) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", inst, expected, peers)
+			continue
+		}
+		for i := range peers {
+			if peers[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", inst, expected, peers)
+				break
+			}
+		}
+	}
+}
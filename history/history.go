@@ -18,22 +18,64 @@ type EventWriter interface {
 }
 
 type EventReader interface {
-	// AllEvents returns a history for every service. Events must be
-	// returned in descending timestamp order.
+	// AllEvents returns a history for every service, of the events
+	// recorded before the time given. Events must be returned in
+	// descending order of sequence.
 	AllEvents(time.Time, int64) ([]flux.Event, error)
 
 	// EventsForService returns the history for a particular
-	// service. Events must be returned in descending timestamp order.
+	// service. Events must be returned in descending order of
+	// sequence.
 	EventsForService(flux.ServiceID, time.Time, int64) ([]flux.Event, error)
 
+	// Events returns the events asked for, in descending order of
+	// sequence.
+	Events(EventQuery) ([]flux.Event, error)
+
+	// CountEvents counts the events asked for, regardless of the
+	// query's limit.
+	CountEvents(EventQuery) (int64, error)
+
 	// GetEvent finds a single event, by ID.
 	GetEvent(flux.EventID) (flux.Event, error)
 }
 
+// EventQuery says which events to give from a history, so that it can
+// be looked through a page at a time.
+type EventQuery struct {
+	// Service, if not blank, is the only service to give events for.
+	Service flux.ServiceID
+	// Before, if not zero, is the time before which events must have
+	// been recorded.
+	Before time.Time
+	// BeforeSequence and AfterSequence, if not zero, say to give only
+	// the events before (or after) the one with that sequence number.
+	BeforeSequence int64
+	AfterSequence  int64
+	// Types, if not empty, are the only types of event to give.
+	Types []string
+	// Limit is how many events to give at most, or -1 for no limit.
+	Limit int64
+}
+
+// Forwards says whether the query pages forwards from AfterSequence,
+// in which case the events given are those nearest it, rather than
+// the most recent.
+func (q EventQuery) Forwards() bool {
+	return q.AfterSequence != 0 && q.BeforeSequence == 0 && q.Limit >= 0
+}
+
 type DB interface {
 	LogEvent(flux.InstanceID, flux.Event) error
 	AllEvents(flux.InstanceID, time.Time, int64) ([]flux.Event, error)
 	EventsForService(flux.InstanceID, flux.ServiceID, time.Time, int64) ([]flux.Event, error)
+	// EventsForServiceInstances returns the history for a
+	// particular service in each of the instances given, in
+	// descending order of when they were recorded, each event saying
+	// which cluster it came from.
+	EventsForServiceInstances([]flux.InstanceID, flux.ServiceID, time.Time, int64) ([]flux.Event, error)
+	Events(flux.InstanceID, EventQuery) ([]flux.Event, error)
+	CountEvents(flux.InstanceID, EventQuery) (int64, error)
 	GetEvent(flux.EventID) (flux.Event, error)
 	io.Closer
 }
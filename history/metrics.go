@@ -63,6 +63,36 @@ func (i *instrumentedDB) EventsForService(inst flux.InstanceID, s flux.ServiceID
 	return i.db.EventsForService(inst, s, before, limit)
 }
 
+func (i *instrumentedDB) EventsForServiceInstances(insts []flux.InstanceID, s flux.ServiceID, before time.Time, limit int64) (e []flux.Event, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			LabelMethod, "EventsForServiceInstances",
+			LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.db.EventsForServiceInstances(insts, s, before, limit)
+}
+
+func (i *instrumentedDB) Events(inst flux.InstanceID, q EventQuery) (e []flux.Event, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			LabelMethod, "Events",
+			LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.db.Events(inst, q)
+}
+
+func (i *instrumentedDB) CountEvents(inst flux.InstanceID, q EventQuery) (n int64, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			LabelMethod, "CountEvents",
+			LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.db.CountEvents(inst, q)
+}
+
 func (i *instrumentedDB) GetEvent(id flux.EventID) (e flux.Event, err error) {
 	defer func(begin time.Time) {
 		requestDuration.With(
@@ -23,6 +23,14 @@ func (m mock) EventsForService(_ flux.ServiceID, _ time.Time, _ int64) ([]flux.E
 	return nil, nil
 }
 
+func (m mock) Events(_ EventQuery) ([]flux.Event, error) {
+	return nil, nil
+}
+
+func (m mock) CountEvents(_ EventQuery) (int64, error) {
+	return 0, nil
+}
+
 func (m mock) GetEvent(_ flux.EventID) (flux.Event, error) {
 	return flux.Event{}, nil
 }
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
 )
 
 // A history DB that uses a postgres database
@@ -18,12 +20,39 @@ type pgDB struct {
 }
 
 func (db *pgDB) eventsQuery() squirrel.SelectBuilder {
+	return db.eventsSelect().
+		// Events are recorded in order of id, whatever their timestamps
+		OrderBy("id desc")
+}
+
+func (db *pgDB) eventsSelect() squirrel.SelectBuilder {
 	return db.Select(
-		"id", "service_ids", "type", "started_at", "ended_at", "log_level",
-		"message", "metadata",
+		"id", "id", "service_ids", "type", "started_at", "ended_at",
+		"received_at", "log_level", "message", "metadata", "instance_id",
+		"cluster",
 	).
-		From("events").
-		OrderBy("started_at desc")
+		From("events")
+}
+
+// whereQuery restricts the select to the events asked for.
+func (db *pgDB) whereQuery(q squirrel.SelectBuilder, inst flux.InstanceID, query history.EventQuery) squirrel.SelectBuilder {
+	q = q.Where("instance_id = ?", string(inst))
+	if query.Service != "" {
+		q = q.Where("service_ids @> ?", pq.StringArray{string(query.Service)})
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("received_at < ?", query.Before)
+	}
+	if query.BeforeSequence != 0 {
+		q = q.Where("id < ?", query.BeforeSequence)
+	}
+	if query.AfterSequence != 0 {
+		q = q.Where("id > ?", query.AfterSequence)
+	}
+	if len(query.Types) > 0 {
+		q = q.Where(squirrel.Eq{"type": query.Types})
+	}
+	return q
 }
 
 func (db *pgDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
@@ -39,19 +68,31 @@ func (db *pgDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
 			h             flux.Event
 			serviceIDs    pq.StringArray
 			metadataBytes []byte
+			instanceID    string
+			cluster       sql.NullString
 		)
 		if err := rows.Scan(
 			&h.ID,
+			&h.Sequence,
 			&serviceIDs,
 			&h.Type,
 			&h.StartedAt,
 			&h.EndedAt,
+			&h.ReceivedAt,
 			&h.LogLevel,
 			&h.Message,
 			&metadataBytes,
+			&instanceID,
+			&cluster,
 		); err != nil {
 			return nil, err
 		}
+		// Events from before clusters were named belong to the
+		// instance's cluster
+		h.Cluster = cluster.String
+		if h.Cluster == "" {
+			h.Cluster = instanceID
+		}
 		for _, id := range serviceIDs {
 			h.ServiceIDs = append(h.ServiceIDs, flux.ServiceID(id))
 		}
@@ -64,6 +105,36 @@ func (db *pgDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
 					return nil, err
 				}
 				h.Metadata = m
+			case flux.EventCheckpoint:
+				var m flux.CheckpointStatus
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventLint:
+				var m flux.LintReport
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventThrottled:
+				var m flux.ThrottledEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventBranch:
+				var m flux.BranchEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventBlueGreen:
+				var m flux.BlueGreenEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
 			}
 		}
 		events = append(events, h)
@@ -75,7 +146,18 @@ func (db *pgDB) EventsForService(inst flux.InstanceID, service flux.ServiceID, b
 	q := db.eventsQuery().
 		Where("instance_id = ?", string(inst)).
 		Where("service_ids @> ?", pq.StringArray{string(service)}).
-		Where("started_at < ?", before)
+		Where("received_at < ?", before)
+	if limit >= 0 {
+		q = q.Limit(uint64(limit))
+	}
+	return db.scanEvents(q)
+}
+
+func (db *pgDB) EventsForServiceInstances(instances []flux.InstanceID, service flux.ServiceID, before time.Time, limit int64) ([]flux.Event, error) {
+	q := db.eventsQuery().
+		Where(squirrel.Eq{"instance_id": instanceIDStrings(instances)}).
+		Where("service_ids @> ?", pq.StringArray{string(service)}).
+		Where("received_at < ?", before)
 	if limit >= 0 {
 		q = q.Limit(uint64(limit))
 	}
@@ -85,13 +167,45 @@ func (db *pgDB) EventsForService(inst flux.InstanceID, service flux.ServiceID, b
 func (db *pgDB) AllEvents(inst flux.InstanceID, before time.Time, limit int64) ([]flux.Event, error) {
 	q := db.eventsQuery().
 		Where("instance_id = ?", string(inst)).
-		Where("started_at < ?", before)
+		Where("received_at < ?", before)
 	if limit >= 0 {
 		q = q.Limit(uint64(limit))
 	}
 	return db.scanEvents(q)
 }
 
+func (db *pgDB) Events(inst flux.InstanceID, query history.EventQuery) ([]flux.Event, error) {
+	q := db.whereQuery(db.eventsSelect(), inst, query)
+	if query.Forwards() {
+		q = q.OrderBy("id asc")
+	} else {
+		q = q.OrderBy("id desc")
+	}
+	if query.Limit >= 0 {
+		q = q.Limit(uint64(query.Limit))
+	}
+	events, err := db.scanEvents(q)
+	if err != nil {
+		return nil, err
+	}
+	if query.Forwards() {
+		reverse(events)
+	}
+	return events, nil
+}
+
+func (db *pgDB) CountEvents(inst flux.InstanceID, query history.EventQuery) (int64, error) {
+	// The builder's runner is the sqlx.DB, which squirrel can't
+	// QueryRow with, so run the SQL directly.
+	q, args, err := db.whereQuery(db.Select("count(*)").From("events"), inst, query).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = db.driver.QueryRow(q, args...).Scan(&n)
+	return n, err
+}
+
 func (db *pgDB) GetEvent(id flux.EventID) (flux.Event, error) {
 	es, err := db.scanEvents(db.eventsQuery().Where("id = ?", string(id)))
 	if err != nil {
@@ -118,9 +232,10 @@ func (db *pgDB) LogEvent(inst flux.InstanceID, e flux.Event) error {
 	}
 	_, err = db.driver.Exec(
 		`INSERT INTO events
-		(instance_id, service_ids, type, log_level, metadata, started_at, ended_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		(instance_id, cluster, service_ids, type, log_level, metadata, started_at, ended_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		string(inst),
+		e.Cluster,
 		serviceIDs,
 		e.Type,
 		e.LogLevel,
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
 )
 
 // A history DB that uses a ql database
@@ -18,11 +20,38 @@ type qlDB struct {
 }
 
 func (db *qlDB) eventsQuery() squirrel.SelectBuilder {
+	return db.eventsSelect().
+		// Events are recorded in order of id, whatever their timestamps
+		OrderBy("id(events) desc")
+}
+
+func (db *qlDB) eventsSelect() squirrel.SelectBuilder {
 	return db.Select(
-		"id(events)", "type", "started_at", "ended_at", "log_level", "message", "metadata",
+		"id(events)", "id(events)", "type", "started_at", "ended_at", "received_at",
+		"log_level", "message", "metadata", "instance_id", "cluster",
 	).
-		From("events").
-		OrderBy("started_at desc")
+		From("events")
+}
+
+// whereQuery restricts the select to the events asked for.
+func (db *qlDB) whereQuery(q squirrel.SelectBuilder, inst flux.InstanceID, query history.EventQuery) squirrel.SelectBuilder {
+	q = q.Where("instance_id = ?", string(inst))
+	if query.Service != "" {
+		q = q.Where("id(e) IN (select event_id from event_service_ids WHERE service_id = ?)", string(query.Service))
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("received_at < ?", query.Before)
+	}
+	if query.BeforeSequence != 0 {
+		q = q.Where("id(events) < ?", query.BeforeSequence)
+	}
+	if query.AfterSequence != 0 {
+		q = q.Where("id(events) > ?", query.AfterSequence)
+	}
+	if len(query.Types) > 0 {
+		q = q.Where(squirrel.Eq{"type": query.Types})
+	}
+	return q
 }
 
 func (db *qlDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
@@ -37,18 +66,30 @@ func (db *qlDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
 		var (
 			h             flux.Event
 			metadataBytes []byte
+			instanceID    string
+			cluster       sql.NullString
 		)
 		if err := rows.Scan(
 			&h.ID,
+			&h.Sequence,
 			&h.Type,
 			&h.StartedAt,
 			&h.EndedAt,
+			&h.ReceivedAt,
 			&h.LogLevel,
 			&h.Message,
 			&metadataBytes,
+			&instanceID,
+			&cluster,
 		); err != nil {
 			return nil, err
 		}
+		// Events from before clusters were named belong to the
+		// instance's cluster
+		h.Cluster = cluster.String
+		if h.Cluster == "" {
+			h.Cluster = instanceID
+		}
 
 		if len(metadataBytes) > 0 {
 			switch h.Type {
@@ -58,6 +99,36 @@ func (db *qlDB) scanEvents(query squirrel.Sqlizer) ([]flux.Event, error) {
 					return nil, err
 				}
 				h.Metadata = m
+			case flux.EventCheckpoint:
+				var m flux.CheckpointStatus
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventLint:
+				var m flux.LintReport
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventThrottled:
+				var m flux.ThrottledEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventBranch:
+				var m flux.BranchEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
+			case flux.EventBlueGreen:
+				var m flux.BlueGreenEventMetadata
+				if err := json.Unmarshal(metadataBytes, &m); err != nil {
+					return nil, err
+				}
+				h.Metadata = m
 			}
 		}
 		events = append(events, h)
@@ -69,7 +140,22 @@ func (db *qlDB) EventsForService(inst flux.InstanceID, service flux.ServiceID, b
 	q := db.eventsQuery().
 		Where("instance_id = ?", string(inst)).
 		Where("id(e) IN (select event_id from event_service_ids WHERE service_id = ?)", string(service)).
-		Where("started_at < ?", before)
+		Where("received_at < ?", before)
+	if limit >= 0 {
+		q = q.Limit(uint64(limit))
+	}
+	events, err := db.scanEvents(q)
+	if err != nil {
+		return nil, err
+	}
+	return db.loadServiceIDs(events)
+}
+
+func (db *qlDB) EventsForServiceInstances(instances []flux.InstanceID, service flux.ServiceID, before time.Time, limit int64) ([]flux.Event, error) {
+	q := db.eventsQuery().
+		Where(squirrel.Eq{"instance_id": instanceIDStrings(instances)}).
+		Where("id(e) IN (select event_id from event_service_ids WHERE service_id = ?)", string(service)).
+		Where("received_at < ?", before)
 	if limit >= 0 {
 		q = q.Limit(uint64(limit))
 	}
@@ -83,7 +169,7 @@ func (db *qlDB) EventsForService(inst flux.InstanceID, service flux.ServiceID, b
 func (db *qlDB) AllEvents(inst flux.InstanceID, before time.Time, limit int64) ([]flux.Event, error) {
 	q := db.eventsQuery().
 		Where("instance_id = ?", string(inst)).
-		Where("started_at < ?", before)
+		Where("received_at < ?", before)
 	if limit >= 0 {
 		q = q.Limit(uint64(limit))
 	}
@@ -94,6 +180,38 @@ func (db *qlDB) AllEvents(inst flux.InstanceID, before time.Time, limit int64) (
 	return db.loadServiceIDs(events)
 }
 
+func (db *qlDB) Events(inst flux.InstanceID, query history.EventQuery) ([]flux.Event, error) {
+	q := db.whereQuery(db.eventsSelect(), inst, query)
+	if query.Forwards() {
+		q = q.OrderBy("id(events) asc")
+	} else {
+		q = q.OrderBy("id(events) desc")
+	}
+	if query.Limit >= 0 {
+		q = q.Limit(uint64(query.Limit))
+	}
+	events, err := db.scanEvents(q)
+	if err != nil {
+		return nil, err
+	}
+	if query.Forwards() {
+		reverse(events)
+	}
+	return db.loadServiceIDs(events)
+}
+
+func (db *qlDB) CountEvents(inst flux.InstanceID, query history.EventQuery) (int64, error) {
+	// The builder's runner is the sqlx.DB, which squirrel can't
+	// QueryRow with, so run the SQL directly.
+	q, args, err := db.whereQuery(db.Select("count(*)").From("events"), inst, query).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = db.driver.QueryRow(q, args...).Scan(&n)
+	return n, err
+}
+
 func (db *qlDB) GetEvent(id flux.EventID) (flux.Event, error) {
 	es, err := db.scanEvents(db.eventsQuery().Where("id(events) = ?", string(id)))
 	if err != nil {
@@ -131,9 +249,10 @@ func (db *qlDB) LogEvent(inst flux.InstanceID, e flux.Event) (err error) {
 	if err != nil {
 		return err
 	}
+	receivedAt := time.Now().UTC()
 	startedAt := e.StartedAt
 	if startedAt.IsZero() {
-		startedAt = time.Now().UTC()
+		startedAt = receivedAt
 	}
 	tx, err := db.driver.Begin()
 	if err != nil {
@@ -149,14 +268,16 @@ func (db *qlDB) LogEvent(inst flux.InstanceID, e flux.Event) (err error) {
 
 	result, err := tx.Exec(
 		`INSERT INTO events
-		(instance_id, type, log_level, metadata, started_at, ended_at)
-		VALUES ($1, $2, $3, $4, $5, $6)`,
+		(instance_id, cluster, type, log_level, metadata, started_at, ended_at, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		string(inst),
+		e.Cluster,
 		e.Type,
 		e.LogLevel,
 		string(metadata),
 		startedAt,
 		pq.NullTime{Time: e.EndedAt.UTC(), Valid: !e.EndedAt.IsZero()},
+		receivedAt,
 	)
 	if err != nil {
 		return err
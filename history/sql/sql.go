@@ -6,6 +6,7 @@ import (
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 
+	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/history"
 )
 
@@ -40,6 +41,22 @@ func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	return db.driver.Query(query, args...)
 }
 
+// reverse reverses the order of the events, e.g., when they've been
+// found oldest first, so they can be given newest first.
+func reverse(events []flux.Event) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
+
+func instanceIDStrings(instances []flux.InstanceID) []string {
+	ids := make([]string, len(instances))
+	for i, inst := range instances {
+		ids[i] = string(inst)
+	}
+	return ids
+}
+
 func (db *DB) Close() error {
 	return db.driver.Close()
 }
@@ -2,8 +2,10 @@ package sql
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -100,3 +102,172 @@ func checkInDescOrder(t *testing.T, events []flux.Event) {
 		last = event.StartedAt
 	}
 }
+
+func TestHistoryInstances(t *testing.T) {
+	db := newSQL(t)
+	defer db.Close()
+
+	service := flux.ServiceID("namespace/everywhere")
+	bailIfErr(t, db.LogEvent(flux.InstanceID("instance-a"), flux.Event{
+		ServiceIDs: []flux.ServiceID{service},
+		Type:       "test",
+		Cluster:    "prod",
+	}))
+	bailIfErr(t, db.LogEvent(flux.InstanceID("instance-b"), flux.Event{
+		ServiceIDs: []flux.ServiceID{service},
+		Type:       "test",
+	}))
+	bailIfErr(t, db.LogEvent(flux.InstanceID("instance-b"), flux.Event{
+		ServiceIDs: []flux.ServiceID{flux.ServiceID("namespace/elsewhere")},
+		Type:       "test",
+	}))
+	// Not one of the instances asked about
+	bailIfErr(t, db.LogEvent(flux.InstanceID("instance-c"), flux.Event{
+		ServiceIDs: []flux.ServiceID{service},
+		Type:       "test",
+	}))
+
+	instances := []flux.InstanceID{"instance-a", "instance-b"}
+	es, err := db.EventsForServiceInstances(instances, service, time.Now().UTC(), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("Expected 2 events, got %#v\n", es)
+	}
+	checkInDescOrder(t, es)
+
+	clusters := map[string]bool{}
+	for _, e := range es {
+		clusters[e.Cluster] = true
+	}
+	// An event without a cluster is from the instance's cluster
+	if !clusters["prod"] || !clusters["instance-b"] {
+		t.Errorf("Expected events from prod and instance-b, got %v", clusters)
+	}
+}
+
+func TestHistorySkewedClocks(t *testing.T) {
+	instance := flux.InstanceID("skewed")
+	db := newSQL(t)
+	defer db.Close()
+
+	// Events logged with timestamps from clocks that are off, in
+	// both directions
+	now := time.Now().UTC()
+	for _, e := range []struct {
+		message string
+		stamp   time.Time
+	}{
+		{"first", now.Add(time.Hour)},
+		{"second", now.Add(-time.Hour)},
+		{"third", now},
+	} {
+		bailIfErr(t, db.LogEvent(instance, flux.Event{
+			ServiceIDs: []flux.ServiceID{flux.ServiceID("namespace/service")},
+			Type:       "test",
+			Message:    e.message,
+			StartedAt:  e.stamp,
+			EndedAt:    e.stamp,
+		}))
+	}
+
+	es, err := db.AllEvents(instance, time.Now().UTC(), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 3 {
+		t.Fatalf("Expected 3 events, got %#v\n", es)
+	}
+	// In the order recorded, most recent first, whatever their
+	// timestamps
+	if !es[0].StartedAt.Equal(now) || !es[1].StartedAt.Equal(now.Add(-time.Hour)) || !es[2].StartedAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected events in the order recorded, got %#v", es)
+	}
+	for i, e := range es {
+		if e.ReceivedAt.IsZero() || e.ReceivedAt.After(time.Now()) {
+			t.Errorf("Expected a time recorded for event %d, got %s", i, e.ReceivedAt)
+		}
+		if i > 0 && e.Sequence >= es[i-1].Sequence {
+			t.Errorf("Expected descending sequence, got %d after %d", e.Sequence, es[i-1].Sequence)
+		}
+	}
+}
+
+func TestHistoryPages(t *testing.T) {
+	instance := flux.InstanceID("paged")
+	db := newSQL(t)
+	defer db.Close()
+
+	// Five events, alternating between releases and others, all of
+	// the one service
+	service := flux.ServiceID("namespace/paged")
+	for i := 0; i < 5; i++ {
+		typ := flux.EventRelease
+		if i%2 == 1 {
+			typ = flux.EventLock
+		}
+		bailIfErr(t, db.LogEvent(instance, flux.Event{
+			ServiceIDs: []flux.ServiceID{service},
+			Type:       typ,
+			Cluster:    fmt.Sprintf("event %d", i),
+		}))
+	}
+	bailIfErr(t, db.LogEvent(instance, flux.Event{
+		ServiceIDs: []flux.ServiceID{flux.ServiceID("namespace/other")},
+		Type:       flux.EventRelease,
+		Cluster:    "other",
+	}))
+
+	// Messages aren't stored, so the events are told apart by cluster
+	messages := func(es []flux.Event) []string {
+		var ms []string
+		for _, e := range es {
+			ms = append(ms, e.Cluster)
+		}
+		return ms
+	}
+
+	// The first page, then the next from the last of it
+	first, err := db.Events(instance, history.EventQuery{Service: service, Limit: 2})
+	bailIfErr(t, err)
+	if got := messages(first); !reflect.DeepEqual(got, []string{"event 4", "event 3"}) {
+		t.Fatalf("Expected the newest two events, got %v", got)
+	}
+	next, err := db.Events(instance, history.EventQuery{Service: service, BeforeSequence: first[1].Sequence, Limit: 2})
+	bailIfErr(t, err)
+	if got := messages(next); !reflect.DeepEqual(got, []string{"event 2", "event 1"}) {
+		t.Fatalf("Expected the next two events, got %v", got)
+	}
+
+	// Paging forwards gives the events nearest, still newest first
+	prev, err := db.Events(instance, history.EventQuery{Service: service, AfterSequence: next[1].Sequence, Limit: 2})
+	bailIfErr(t, err)
+	if got := messages(prev); !reflect.DeepEqual(got, []string{"event 3", "event 2"}) {
+		t.Fatalf("Expected the two events after, got %v", got)
+	}
+
+	// Only the types asked for
+	releases, err := db.Events(instance, history.EventQuery{Service: service, Types: []string{flux.EventRelease}, Limit: -1})
+	bailIfErr(t, err)
+	if got := messages(releases); !reflect.DeepEqual(got, []string{"event 4", "event 2", "event 0"}) {
+		t.Fatalf("Expected only releases, got %v", got)
+	}
+
+	// Counts ignore the limit
+	for _, c := range []struct {
+		query    history.EventQuery
+		expected int64
+	}{
+		{history.EventQuery{Limit: 1}, 6},
+		{history.EventQuery{Service: service, Limit: 1}, 5},
+		{history.EventQuery{Service: service, Types: []string{flux.EventRelease}, Limit: 1}, 3},
+		{history.EventQuery{Service: service, BeforeSequence: first[1].Sequence, Limit: 1}, 3},
+	} {
+		n, err := db.CountEvents(instance, c.query)
+		bailIfErr(t, err)
+		if n != c.expected {
+			t.Errorf("%+v: expected a count of %d, got %d", c.query, c.expected, n)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"sort"
+
+	"github.com/weaveworks/flux"
+)
+
+// ServiceVersions works out, from release events, which image each
+// container of the service has run and for how long. Only successful
+// releases are considered. Events may be given in any order; the
+// result is in chronological order.
+func ServiceVersions(service flux.ServiceID, events []flux.Event) []flux.ServiceVersion {
+	var releases []flux.Release
+	for _, e := range events {
+		if e.Type != flux.EventRelease {
+			continue
+		}
+		metadata, ok := e.Metadata.(flux.ReleaseEventMetadata)
+		if !ok || metadata.Error != "" {
+			continue
+		}
+		result, ok := metadata.Release.Result[service]
+		if !ok || result.Status != flux.ReleaseStatusSuccess || len(result.PerContainer) == 0 {
+			continue
+		}
+		releases = append(releases, metadata.Release)
+	}
+	sort.Sort(releasesByEnded(releases))
+
+	var versions []flux.ServiceVersion
+	current := map[string]int{} // container -> index of its current version
+	for _, r := range releases {
+		for _, update := range r.Result[service].PerContainer {
+			since := r.EndedAt
+			if i, ok := current[update.Container]; ok {
+				versions[i].Until = &since
+			}
+			current[update.Container] = len(versions)
+			versions = append(versions, flux.ServiceVersion{
+				Container: update.Container,
+				Image:     update.Target,
+				Since:     since,
+				ReleaseID: r.ID,
+				Revision:  r.Revision,
+				User:      r.Cause.User,
+			})
+		}
+	}
+	return versions
+}
+
+type releasesByEnded []flux.Release
+
+func (rs releasesByEnded) Len() int           { return len(rs) }
+func (rs releasesByEnded) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+func (rs releasesByEnded) Less(i, j int) bool { return rs[i].EndedAt.Before(rs[j].EndedAt) }
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+func releaseEvent(t *testing.T, id string, ended time.Time, status flux.ServiceReleaseStatus, service flux.ServiceID, image string) flux.Event {
+	target, err := flux.ParseImageID(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flux.Event{
+		Type:    flux.EventRelease,
+		EndedAt: ended,
+		Metadata: flux.ReleaseEventMetadata{
+			Release: flux.Release{
+				ID:       flux.ReleaseID(id),
+				EndedAt:  ended,
+				Revision: "rev-" + id,
+				Result: flux.ReleaseResult{
+					service: flux.ServiceResult{
+						Status: status,
+						PerContainer: []flux.ContainerUpdate{
+							{Container: "app", Target: target},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestServiceVersions(t *testing.T) {
+	service := flux.ServiceID("default/helloworld")
+	t0 := time.Now().Add(-time.Hour)
+	t1 := t0.Add(10 * time.Minute)
+	t2 := t0.Add(20 * time.Minute)
+
+	// Given newest first, as they would come from the history DB
+	events := []flux.Event{
+		releaseEvent(t, "3", t2, flux.ReleaseStatusSuccess, service, "alpine:3"),
+		releaseEvent(t, "failed", t1.Add(time.Minute), flux.ReleaseStatusFailed, service, "alpine:broken"),
+		releaseEvent(t, "2", t1, flux.ReleaseStatusSuccess, service, "alpine:2"),
+		releaseEvent(t, "other", t1, flux.ReleaseStatusSuccess, "default/other", "alpine:9"),
+		releaseEvent(t, "1", t0, flux.ReleaseStatusSuccess, service, "alpine:1"),
+	}
+
+	versions := ServiceVersions(service, events)
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d: %+v", len(versions), versions)
+	}
+	for i, tag := range []string{"1", "2", "3"} {
+		if versions[i].Image.Tag != tag || versions[i].Revision != "rev-"+tag {
+			t.Errorf("expected version %d to be tag %s, got %+v", i, tag, versions[i])
+		}
+	}
+	if versions[0].Until == nil || !versions[0].Until.Equal(t1) {
+		t.Errorf("expected first version to end when the second started, got %v", versions[0].Until)
+	}
+	if versions[2].Until != nil {
+		t.Errorf("expected latest version to still be running, got %v", versions[2].Until)
+	}
+}
@@ -0,0 +1,39 @@
+package flux
+
+import (
+	"testing"
+)
+
+func TestHistoryCursor(t *testing.T) {
+	for _, sequence := range []int64{1, 42, 1 << 40} {
+		got, err := ParseHistoryCursor(HistoryCursor(sequence))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != sequence {
+			t.Errorf("expected cursor for %d to give %d, got %d", sequence, sequence, got)
+		}
+	}
+	for _, cursor := range []string{"", "42", "not a cursor", HistoryCursor(0), HistoryCursor(-1)} {
+		if _, err := ParseHistoryCursor(cursor); err == nil {
+			t.Errorf("expected %q to be an invalid cursor", cursor)
+		}
+	}
+}
+
+func TestHistoryQueryProblems(t *testing.T) {
+	for _, c := range []struct {
+		query    HistoryQuery
+		problems int
+	}{
+		{HistoryQuery{Limit: -1}, 0},
+		{HistoryQuery{BeforeCursor: HistoryCursor(2), AfterCursor: HistoryCursor(1), Types: []string{EventRelease}, Limit: 10}, 0},
+		{HistoryQuery{BeforeCursor: "bogus", Limit: -1}, 1},
+		{HistoryQuery{Types: []string{EventRelease, "bogus"}, Limit: -1}, 1},
+		{HistoryQuery{AfterCursor: "bogus", Limit: -2}, 2},
+	} {
+		if problems := c.query.Problems(); len(problems) != c.problems {
+			t.Errorf("%+v: expected %d problems, got %v", c.query, c.problems, problems)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,13 +19,15 @@ import (
 	"github.com/weaveworks/flux/api"
 	transport "github.com/weaveworks/flux/http"
 	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/tracing"
 )
 
 type client struct {
-	client   *http.Client
-	token    flux.Token
-	router   *mux.Router
-	endpoint string
+	client     *http.Client
+	token      flux.Token
+	router     *mux.Router
+	endpoint   string
+	apiVersion int // if non-zero, the latest version of the API to use
 }
 
 func New(c *http.Client, router *mux.Router, endpoint string, t flux.Token) api.ClientService {
@@ -34,19 +39,78 @@ func New(c *http.Client, router *mux.Router, endpoint string, t flux.Token) api.
 	}
 }
 
-func (c *client) ListServices(_ flux.InstanceID, namespace string) ([]flux.ServiceStatus, error) {
+// NewWithAPIVersion is like New, but refuses to call endpoints from
+// versions of the API later than that given (e.g., "v4"), which is
+// useful for talking to a service older than the client.
+func NewWithAPIVersion(c *http.Client, router *mux.Router, endpoint string, t flux.Token, version string) (api.ClientService, error) {
+	n, err := transport.ParseAPIVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		client:     c,
+		token:      t,
+		router:     router,
+		endpoint:   endpoint,
+		apiVersion: n,
+	}, nil
+}
+
+// APIVersions asks the service at endpoint which versions of the API
+// it supports. Services from before this was possible give nil, and
+// no error.
+func APIVersions(c *http.Client, router *mux.Router, endpoint string, t flux.Token) ([]string, error) {
+	cl := &client{
+		client:   c,
+		token:    t,
+		router:   router,
+		endpoint: endpoint,
+	}
+	u, err := transport.MakeURL(cl.endpoint, cl.router, "APIVersions")
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing URL")
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "constructing request %s", u)
+	}
+	cl.token.Set(req)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cl.executeRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res transport.APIVersionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "decoding response from server")
+	}
+	return res.Versions, nil
+}
+
+func (c *client) ListServices(ctx context.Context, _ flux.InstanceID, namespace string) ([]flux.ServiceStatus, error) {
 	var res []flux.ServiceStatus
-	err := c.get(&res, "ListServices", "namespace", namespace)
+	err := c.get(ctx, &res, "ListServices", "namespace", namespace)
 	return res, err
 }
 
-func (c *client) ListImages(_ flux.InstanceID, s flux.ServiceSpec) ([]flux.ImageStatus, error) {
+func (c *client) ListImages(ctx context.Context, _ flux.InstanceID, s flux.ServiceSpec, only []flux.ImageStatusFilter) ([]flux.ImageStatus, error) {
+	params := []string{"service", string(s)}
+	for _, f := range only {
+		params = append(params, "only", string(f))
+	}
 	var res []flux.ImageStatus
-	err := c.get(&res, "ListImages", "service", string(s))
+	err := c.get(ctx, &res, "ListImages", params...)
 	return res, err
 }
 
-func (c *client) PostRelease(_ flux.InstanceID, s jobs.ReleaseJobParams) (jobs.JobID, error) {
+func (c *client) PostRelease(ctx context.Context, _ flux.InstanceID, s jobs.ReleaseJobParams) (jobs.JobID, error) {
 	args := []string{
 		"image", string(s.ImageSpec),
 		"kind", string(s.Kind),
@@ -58,39 +122,141 @@ func (c *client) PostRelease(_ flux.InstanceID, s jobs.ReleaseJobParams) (jobs.J
 	for _, ex := range s.Excludes {
 		args = append(args, "exclude", string(ex))
 	}
+	for _, v := range s.ValueUpdates {
+		switch v.Kind {
+		case flux.ValueKindEnv:
+			args = append(args, "set-env", v.String())
+		case flux.ValueKindConfigMap:
+			args = append(args, "set-config", v.String())
+		}
+	}
 	if s.Cause.Message != "" {
 		args = append(args, "message", s.Cause.Message)
 	}
 
 	var resp transport.PostReleaseResponse
-	err := c.methodWithResp("POST", &resp, "PostRelease", nil, args...)
+	err := c.methodWithResp(ctx, "POST", &resp, "PostRelease", nil, args...)
 	return resp.ReleaseID, err
 }
 
-func (c *client) GetRelease(_ flux.InstanceID, id jobs.JobID) (jobs.Job, error) {
+func (c *client) ValidateRelease(ctx context.Context, _ flux.InstanceID, spec flux.ReleaseSpec) ([]flux.ReleaseProblem, error) {
+	var res []flux.ReleaseProblem
+	err := c.methodWithResp(ctx, "POST", &res, "ValidateRelease", spec)
+	return res, err
+}
+
+func (c *client) GetRelease(ctx context.Context, _ flux.InstanceID, id jobs.JobID, wait time.Duration) (jobs.Job, error) {
 	var res jobs.Job
-	err := c.get(&res, "GetRelease", "id", string(id))
+	params := []string{"id", string(id)}
+	if wait > 0 {
+		params = append(params, "wait", wait.String())
+	}
+	err := c.get(ctx, &res, "GetRelease", params...)
 	return res, err
 }
 
-func (c *client) Automate(_ flux.InstanceID, id flux.ServiceID) error {
-	return c.post("Automate", "service", string(id))
+func (c *client) ListFailedJobs(ctx context.Context, _ flux.InstanceID) ([]jobs.Job, error) {
+	var res []jobs.Job
+	err := c.get(ctx, &res, "ListFailedJobs")
+	return res, err
+}
+
+func (c *client) RequeueJob(ctx context.Context, _ flux.InstanceID, id jobs.JobID) error {
+	return c.post(ctx, "RequeueJob", "id", string(id))
+}
+
+func (c *client) AbandonJob(ctx context.Context, _ flux.InstanceID, id jobs.JobID) error {
+	return c.post(ctx, "AbandonJob", "id", string(id))
+}
+
+func (c *client) JobLog(ctx context.Context, _ flux.InstanceID, id jobs.JobID) ([]string, error) {
+	var res []string
+	err := c.get(ctx, &res, "JobLog", "id", string(id))
+	return res, err
+}
+
+func (c *client) PurgeFailedJobs(ctx context.Context, _ flux.InstanceID) (int64, error) {
+	var resp transport.PurgeFailedJobsResponse
+	err := c.methodWithResp(ctx, "DELETE", &resp, "PurgeFailedJobs", nil)
+	return resp.Purged, err
+}
+
+func (c *client) SyncNotify(ctx context.Context, _ flux.InstanceID) error {
+	return c.post(ctx, "SyncNotify")
+}
+
+func (c *client) Automate(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) error {
+	return c.post(ctx, "Automate", "service", string(id))
+}
+
+func (c *client) Deautomate(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) error {
+	return c.post(ctx, "Deautomate", "service", string(id))
 }
 
-func (c *client) Deautomate(_ flux.InstanceID, id flux.ServiceID) error {
-	return c.post("Deautomate", "service", string(id))
+func (c *client) Lock(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) error {
+	return c.post(ctx, "Lock", "service", string(id))
 }
 
-func (c *client) Lock(_ flux.InstanceID, id flux.ServiceID) error {
-	return c.post("Lock", "service", string(id))
+func (c *client) Unlock(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) error {
+	return c.post(ctx, "Unlock", "service", string(id))
 }
 
-func (c *client) Unlock(_ flux.InstanceID, id flux.ServiceID) error {
-	return c.post("Unlock", "service", string(id))
+func (c *client) SetMinReleaseInterval(ctx context.Context, _ flux.InstanceID, id flux.ServiceID, interval time.Duration) error {
+	return c.post(ctx, "SetMinReleaseInterval", "service", string(id), "interval", interval.String())
 }
 
-func (c *client) History(_ flux.InstanceID, s flux.ServiceSpec, before time.Time, limit int64) ([]flux.HistoryEntry, error) {
+func (c *client) Trace(ctx context.Context, _ flux.InstanceID, duration time.Duration) error {
+	return c.post(ctx, "Trace", "duration", duration.String())
+}
+
+func (c *client) Pause(ctx context.Context, _ flux.InstanceID, user, reason string) error {
+	return c.post(ctx, "Pause", "user", user, "reason", reason)
+}
+
+func (c *client) Resume(ctx context.Context, _ flux.InstanceID, user string) error {
+	return c.post(ctx, "Resume", "user", user)
+}
+
+func (c *client) SetTagFilter(ctx context.Context, _ flux.InstanceID, id flux.ServiceID, pattern string) error {
+	return c.post(ctx, "SetTagFilter", "service", string(id), "pattern", pattern)
+}
+
+func (c *client) History(ctx context.Context, _ flux.InstanceID, s flux.ServiceSpec, query flux.HistoryQuery) (flux.HistoryPage, error) {
 	params := []string{"service", string(s)}
+	// The API takes a time or a cursor as `before`; a cursor is more
+	// precise, so it wins.
+	switch {
+	case query.BeforeCursor != "":
+		params = append(params, "before", query.BeforeCursor)
+	case !query.Before.IsZero():
+		params = append(params, "before", query.Before.Format(time.RFC3339Nano))
+	}
+	if query.AfterCursor != "" {
+		params = append(params, "after", query.AfterCursor)
+	}
+	if len(query.Types) > 0 {
+		params = append(params, "type", strings.Join(query.Types, ","))
+	}
+	if query.Limit >= 0 {
+		params = append(params, "limit", fmt.Sprint(query.Limit))
+	}
+	var page flux.HistoryPage
+	header, err := c.getWithHeader(ctx, &page.Entries, "History", params...)
+	if err != nil {
+		return page, err
+	}
+	// Servers from before paging don't give a total
+	page.Total = int64(len(page.Entries))
+	if total := header.Get(transport.HistoryTotalHeader); total != "" {
+		if page.Total, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return page, errors.Wrapf(err, "parsing %s header", transport.HistoryTotalHeader)
+		}
+	}
+	return page, nil
+}
+
+func (c *client) HistoryAllClusters(ctx context.Context, _ flux.InstanceID, id flux.ServiceID, before time.Time, limit int64) ([]flux.HistoryEntry, error) {
+	params := []string{"service", string(id)}
 	if !before.IsZero() {
 		params = append(params, "before", before.Format(time.RFC3339Nano))
 	}
@@ -98,63 +264,150 @@ func (c *client) History(_ flux.InstanceID, s flux.ServiceSpec, before time.Time
 		params = append(params, "limit", fmt.Sprint(limit))
 	}
 	var res []flux.HistoryEntry
-	err := c.get(&res, "History", params...)
+	err := c.get(ctx, &res, "HistoryAllClusters", params...)
+	return res, err
+}
+
+func (c *client) ReleaseHistory(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) ([]flux.ServiceVersion, error) {
+	var res []flux.ServiceVersion
+	err := c.get(ctx, &res, "ReleaseHistory", "service", string(id))
+	return res, err
+}
+
+func (c *client) ServiceChanges(ctx context.Context, _ flux.InstanceID, id flux.ServiceID) ([]flux.ServiceChange, error) {
+	var res []flux.ServiceChange
+	err := c.get(ctx, &res, "ServiceChanges", "service", string(id))
+	return res, err
+}
+
+func (c *client) Lint(ctx context.Context, _ flux.InstanceID) (flux.LintReport, error) {
+	var res flux.LintReport
+	err := c.get(ctx, &res, "Lint")
 	return res, err
 }
 
-func (c *client) GetConfig(_ flux.InstanceID, fingerprint string) (flux.InstanceConfig, error) {
+func (c *client) GetConfig(ctx context.Context, _ flux.InstanceID, fingerprint string) (flux.InstanceConfig, error) {
 	var params []string
 	if fingerprint != "" {
 		params = append(params, "fingerprint", fingerprint)
 	}
 	var res flux.InstanceConfig
-	err := c.get(&res, "GetConfig", params...)
+	err := c.get(ctx, &res, "GetConfig", params...)
 	return res, err
 }
 
-func (c *client) SetConfig(_ flux.InstanceID, config flux.UnsafeInstanceConfig) error {
-	return c.postWithBody("SetConfig", config)
+func (c *client) SetConfig(ctx context.Context, _ flux.InstanceID, config flux.UnsafeInstanceConfig) error {
+	return c.postWithBody(ctx, "SetConfig", config)
 }
 
-func (c *client) PatchConfig(_ flux.InstanceID, patch flux.ConfigPatch) error {
-	return c.patchWithBody("PatchConfig", patch)
+func (c *client) ValidateConfig(ctx context.Context, _ flux.InstanceID, config flux.UnsafeInstanceConfig) error {
+	return c.postWithBody(ctx, "ValidateConfig", config)
 }
 
-func (c *client) GenerateDeployKey(_ flux.InstanceID) error {
-	return c.post("GenerateDeployKeys")
+func (c *client) PatchConfig(ctx context.Context, _ flux.InstanceID, patch flux.ConfigPatch) error {
+	return c.patchWithBody(ctx, "PatchConfig", patch)
 }
 
-func (c *client) Status(_ flux.InstanceID) (flux.Status, error) {
+func (c *client) GenerateDeployKey(ctx context.Context, _ flux.InstanceID) error {
+	return c.post(ctx, "GenerateDeployKeys")
+}
+
+func (c *client) Status(ctx context.Context, _ flux.InstanceID) (flux.Status, error) {
 	var res flux.Status
-	err := c.get(&res, "Status")
+	err := c.get(ctx, &res, "Status")
 	return res, err
 }
 
-func (c *client) Export(_ flux.InstanceID) ([]byte, error) {
+func (c *client) Export(ctx context.Context, _ flux.InstanceID) ([]byte, error) {
 	var res []byte
-	err := c.get(&res, "Export")
+	err := c.get(ctx, &res, "Export")
 	return res, err
 }
 
+// ExportTo writes the export as it's downloaded. The transport asks
+// for it compressed, and decompresses it. Services from before
+// streamed exports send it in one go.
+func (c *client) ExportTo(ctx context.Context, inst flux.InstanceID, w io.Writer) error {
+	if err := c.checkAPIVersion("ExportStream"); err != nil {
+		config, err := c.Export(ctx, inst)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(config)
+		return err
+	}
+	u, err := transport.MakeURL(c.endpoint, c.router, "ExportStream")
+	if err != nil {
+		return errors.Wrap(err, "constructing URL")
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "constructing request %s", u)
+	}
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+	c.token.Set(req)
+	req.Header.Set("Accept", "application/x-yaml")
+
+	resp, err := c.executeRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return errors.Wrap(err, "executing HTTP request")
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return errors.Wrap(err, "reading export")
+	}
+	// The trailer is only there once the body has been read
+	if msg := resp.Trailer.Get(transport.ExportErrorTrailer); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (c *client) ListReleaseTemplates(ctx context.Context, _ flux.InstanceID) ([]flux.ReleaseTemplate, error) {
+	var res []flux.ReleaseTemplate
+	err := c.get(ctx, &res, "ListReleaseTemplates")
+	return res, err
+}
+
+func (c *client) SetReleaseTemplate(ctx context.Context, _ flux.InstanceID, template flux.ReleaseTemplate) error {
+	return c.postWithBody(ctx, "SetReleaseTemplate", template)
+}
+
+func (c *client) DeleteReleaseTemplate(ctx context.Context, _ flux.InstanceID, name string) error {
+	return c.methodWithResp(ctx, "DELETE", nil, "DeleteReleaseTemplate", nil, "name", name)
+}
+
+func (c *client) PostReleaseFromTemplate(ctx context.Context, _ flux.InstanceID, name, user string) (jobs.JobID, error) {
+	var resp transport.PostReleaseResponse
+	err := c.methodWithResp(ctx, "POST", &resp, "PostReleaseFromTemplate", nil, "name", name, "user", user)
+	return resp.ReleaseID, err
+}
+
 // post is a simple query-param only post request
-func (c *client) post(route string, queryParams ...string) error {
-	return c.postWithBody(route, nil, queryParams...)
+func (c *client) post(ctx context.Context, route string, queryParams ...string) error {
+	return c.postWithBody(ctx, route, nil, queryParams...)
 }
 
 // postWithBody is a more complex post request, which includes a json-ified body.
 // If body is not nil, it is encoded to json before sending
-func (c *client) postWithBody(route string, body interface{}, queryParams ...string) error {
-	return c.methodWithResp("POST", nil, route, body, queryParams...)
+func (c *client) postWithBody(ctx context.Context, route string, body interface{}, queryParams ...string) error {
+	return c.methodWithResp(ctx, "POST", nil, route, body, queryParams...)
 }
 
-func (c *client) patchWithBody(route string, body interface{}, queryParams ...string) error {
-	return c.methodWithResp("PATCH", nil, route, body, queryParams...)
+func (c *client) patchWithBody(ctx context.Context, route string, body interface{}, queryParams ...string) error {
+	return c.methodWithResp(ctx, "PATCH", nil, route, body, queryParams...)
 }
 
 // methodWithResp is the full enchilada, it handles body and query-param
 // encoding, as well as decoding the response into the provided destination.
 // Note, the response will only be decoded into the dest if the len is > 0.
-func (c *client) methodWithResp(method string, dest interface{}, route string, body interface{}, queryParams ...string) error {
+func (c *client) methodWithResp(ctx context.Context, method string, dest interface{}, route string, body interface{}, queryParams ...string) error {
+	if err := c.checkAPIVersion(route); err != nil {
+		return err
+	}
 	u, err := transport.MakeURL(c.endpoint, c.router, route, queryParams...)
 	if err != nil {
 		return errors.Wrap(err, "constructing URL")
@@ -172,6 +425,8 @@ func (c *client) methodWithResp(method string, dest interface{}, route string, b
 	if err != nil {
 		return errors.Wrapf(err, "constructing request %s", u)
 	}
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
 	c.token.Set(req)
 	req.Header.Set("Accept", "application/json")
 
@@ -195,27 +450,55 @@ func (c *client) methodWithResp(method string, dest interface{}, route string, b
 }
 
 // get executes a get request against the flux server. it unmarshals the response into dest.
-func (c *client) get(dest interface{}, route string, queryParams ...string) error {
+func (c *client) get(ctx context.Context, dest interface{}, route string, queryParams ...string) error {
+	_, err := c.getWithHeader(ctx, dest, route, queryParams...)
+	return err
+}
+
+// getWithHeader is get, for responses which say more in their
+// headers.
+func (c *client) getWithHeader(ctx context.Context, dest interface{}, route string, queryParams ...string) (http.Header, error) {
+	if err := c.checkAPIVersion(route); err != nil {
+		return nil, err
+	}
 	u, err := transport.MakeURL(c.endpoint, c.router, route, queryParams...)
 	if err != nil {
-		return errors.Wrap(err, "constructing URL")
+		return nil, errors.Wrap(err, "constructing URL")
 	}
 
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
-		return errors.Wrapf(err, "constructing request %s", u)
+		return nil, errors.Wrapf(err, "constructing request %s", u)
 	}
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
 	c.token.Set(req)
 	req.Header.Set("Accept", "application/json")
 
 	resp, err := c.executeRequest(req)
 	if err != nil {
-		return errors.Wrap(err, "executing HTTP request")
+		return nil, errors.Wrap(err, "executing HTTP request")
 	}
 	defer resp.Body.Close()
 
 	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
-		return errors.Wrap(err, "decoding response from server")
+		return nil, errors.Wrap(err, "decoding response from server")
+	}
+	return resp.Header, nil
+}
+
+// checkAPIVersion returns an error if the route is from a later
+// version of the API than the client has been limited to.
+func (c *client) checkAPIVersion(route string) error {
+	if c.apiVersion == 0 {
+		return nil
+	}
+	n, err := transport.RouteVersion(c.router, route)
+	if err != nil {
+		return err
+	}
+	if n > c.apiVersion {
+		return errors.Errorf("%s needs API version v%d, but API version v%d was requested", route, n, c.apiVersion)
 	}
 	return nil
 }
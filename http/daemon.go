@@ -129,7 +129,9 @@ func (a *Daemon) connect() error {
 		return errors.Wrap(err, "initializing rpc client")
 	}
 	rpcserver.ServeConn(ws)
-	a.logger.Log("disconnected", true)
+	// If the service went away (e.g., it's being redeployed), this
+	// reconnects straight away, to another replica.
+	a.logger.Log("disconnected", true, "service-going-away", ws.GoneAway())
 	return nil
 }
 
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+	transport "github.com/weaveworks/flux/http"
+)
+
+// auditedRoutes are the routes whose calls are recorded in the audit
+// log: those that release (or queue a check that might), change
+// policies or jobs, or change the instance config (including its keys
+// and release templates).
+var auditedRoutes = map[string]bool{
+	"PostRelease":             true,
+	"PostReleaseFromTemplate": true,
+	"ReleaseTemplateWebhook":  true,
+	"SyncNotify":              true,
+	"Automate":                true,
+	"Deautomate":              true,
+	"Lock":                    true,
+	"Unlock":                  true,
+	"SetMinReleaseInterval":   true,
+	"SetTagFilter":            true,
+	"Pause":                   true,
+	"Resume":                  true,
+	"SetConfig":               true,
+	"PatchConfig":             true,
+	"GenerateDeployKeys":      true,
+	"SetReleaseTemplate":      true,
+	"DeleteReleaseTemplate":   true,
+	"RequeueJob":              true,
+	"AbandonJob":              true,
+	"PurgeFailedJobs":         true,
+}
+
+// maxAuditedBody is the most that's read of the body of an audited
+// call, to digest it; larger requests are refused.
+const maxAuditedBody = 10 << 20
+
+// audited records each call to the route in the audit log, once it's
+// been answered, whether or not it succeeded. It goes inside
+// authenticate, so only calls that were allowed are recorded, and the
+// user is the one the token belongs to if that's known. The response
+// has already gone by then, so a failure to record is logged.
+func audited(next http.Handler, auditLog audit.Writer, route string, logger log.Logger) http.Handler {
+	if auditLog == nil || !auditedRoutes[route] {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := audit.Entry{
+			Instance: getInstanceID(r),
+			Time:     time.Now().UTC(),
+			Route:    route,
+			Target:   r.URL.RawQuery,
+			Token:    audit.TokenFingerprint(requestToken(r)),
+			User:     r.Header.Get(audit.UserHeaderKey),
+		}
+		if user, ok := flux.UserFromContext(r.Context()); ok {
+			entry.User = user
+		} else if entry.User == "" {
+			entry.User = r.URL.Query().Get("user")
+		}
+		if r.Body != nil {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAuditedBody+1))
+			r.Body.Close()
+			if err != nil {
+				transport.WriteError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if len(body) > maxAuditedBody {
+				transport.WriteError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxAuditedBody))
+				return
+			}
+			if len(body) > 0 {
+				entry.BodyDigest = audit.Digest(body)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		cw := &codeWriter{w, http.StatusOK}
+		next.ServeHTTP(cw, r)
+		entry.StatusCode = cw.code
+		if err := auditLog.Record(entry); err != nil {
+			logger.Log("component", "audit", "route", route, "instance", entry.Instance, "err", err)
+		}
+	})
+}
+
+// NewAuditHandler serves the audit log, most recent first, with the
+// query given in the parameters `instance`, `route`, `before` (a time
+// in RFC3339 format) and `limit` (by default, 100). Like the list of
+// instances, it covers every instance, so it's for operators.
+func NewAuditHandler(r audit.Reader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := auditQuery(req)
+		if err != nil {
+			transport.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		entries, err := r.Entries(q)
+		if err != nil {
+			errorResponse(w, req, err)
+			return
+		}
+		jsonResponse(w, req, entries)
+	})
+}
+
+const defaultAuditLimit = 100
+
+func auditQuery(r *http.Request) (audit.Query, error) {
+	params := r.URL.Query()
+	q := audit.Query{
+		Instance: flux.InstanceID(params.Get("instance")),
+		Route:    params.Get("route"),
+		Limit:    defaultAuditLimit,
+	}
+	if param := params.Get("before"); param != "" {
+		before, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return q, fmt.Errorf("invalid before parameter %q", param)
+		}
+		q.Before = before
+	}
+	if param := params.Get("limit"); param != "" {
+		limit, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("invalid limit parameter %q", param)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api"
+	"github.com/weaveworks/flux/audit"
+	transport "github.com/weaveworks/flux/http"
+)
+
+type auditService struct {
+	api.FluxService
+	config flux.UnsafeInstanceConfig
+}
+
+func (s *auditService) SetConfig(_ context.Context, _ flux.InstanceID, config flux.UnsafeInstanceConfig) error {
+	s.config = config
+	return nil
+}
+
+func (s *auditService) Lock(context.Context, flux.InstanceID, flux.ServiceID) error {
+	return nil
+}
+
+type auditRecorder []audit.Entry
+
+func (r *auditRecorder) Record(e audit.Entry) error {
+	*r = append(*r, e)
+	return nil
+}
+
+func TestAudited(t *testing.T) {
+	svc := &auditService{}
+	var entries auditRecorder
+	handler := NewHandler(svc, transport.NewRouter(), nil, nil, &entries, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, path, body string, header http.Header) {
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, "inst")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+	}
+
+	config := `{"slack":{"username":"flux"}}`
+	do("POST", "/v4/config", config, http.Header{
+		"Authorization":     {"Bearer secret"},
+		audit.UserHeaderKey: {"alice"},
+	})
+	do("POST", "/v3/lock?service="+url.QueryEscape("default/helloworld"), "", nil)
+	do("GET", "/versions", "", nil)
+
+	if svc.config.Slack.Username != "flux" {
+		t.Errorf("expected the config to reach the service intact, got %+v", svc.config)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected an entry for each call that changes things, got %+v", entries)
+	}
+	setConfig := entries[0]
+	if setConfig.Route != "SetConfig" || setConfig.Instance != "inst" || setConfig.StatusCode != http.StatusOK {
+		t.Errorf("unexpected entry for SetConfig: %+v", setConfig)
+	}
+	if setConfig.User != "alice" || setConfig.Token != audit.TokenFingerprint("secret") || setConfig.Token == "secret" {
+		t.Errorf("expected the user and a fingerprint of the token, got %q, %q", setConfig.User, setConfig.Token)
+	}
+	if setConfig.BodyDigest != audit.Digest([]byte(config)) {
+		t.Errorf("expected the digest of the body, got %q", setConfig.BodyDigest)
+	}
+	if lock := entries[1]; lock.Route != "Lock" || lock.Target != "service=default%2Fhelloworld" || lock.BodyDigest != "" {
+		t.Errorf("unexpected entry for Lock: %+v", lock)
+	}
+}
+
+func TestAuditedAfterAuthentication(t *testing.T) {
+	var entries auditRecorder
+	tokens := StaticTokens{"tok": {Scopes: []Scope{ScopeRelease}, User: "bob"}}
+	handler := NewHandler(&auditService{}, transport.NewRouter(), tokens, nil, &entries, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	lock := func(token flux.Token, body io.Reader) int {
+		req, err := http.NewRequest("POST", server.URL+"/v3/lock?service="+url.QueryEscape("default/helloworld"), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, "inst")
+		token.Set(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		return resp.StatusCode
+	}
+
+	if code := lock("", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d without a token, got %d", http.StatusUnauthorized, code)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing recorded for a call that wasn't allowed, got %+v", entries)
+	}
+	if code := lock("tok", nil); code != http.StatusOK {
+		t.Fatalf("expected %d with a token, got %d", http.StatusOK, code)
+	}
+	if len(entries) != 1 || entries[0].User != "bob" {
+		t.Fatalf("expected an entry with the token's user, got %+v", entries)
+	}
+
+	big := io.LimitReader(zeros{}, maxAuditedBody+1)
+	if code := lock("tok", big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for a body that's too big, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+}
+
+// zeros reads as many zeros as are asked for.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestAuditedRoutes(t *testing.T) {
+	router := transport.NewRouter()
+	for route := range auditedRoutes {
+		if router.Get(route) == nil {
+			t.Errorf("unknown route %q is audited", route)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+// A Scope is what a token allows: looking (read), changing what's
+// running (release), or changing an instance's config (config-admin).
+// Scopes don't imply one another, so a token for releasing will
+// usually have read as well.
+type Scope string
+
+const (
+	ScopeRead        Scope = "read"
+	ScopeRelease     Scope = "release"
+	ScopeConfigAdmin Scope = "config-admin"
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeRead, ScopeRelease, ScopeConfigAdmin:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown scope %q; expected %q, %q or %q", s, ScopeRead, ScopeRelease, ScopeConfigAdmin)
+}
+
+// routeScopes is the scope each route needs. Daemons connect with a
+// token having release scope, since they apply releases. A route
+// that's neither here nor in publicRoutes is refused, so that a new
+// route isn't served to anyone until it's been given a scope.
+var routeScopes = map[string]Scope{
+	"ListServices":            ScopeRead,
+	"ListImages":              ScopeRead,
+	"ValidateRelease":         ScopeRead,
+	"GetRelease":              ScopeRead,
+	"History":                 ScopeRead,
+	"HistoryAllClusters":      ScopeRead,
+	"ReleaseHistory":          ScopeRead,
+	"ServiceChanges":          ScopeRead,
+	"Lint":                    ScopeRead,
+	"Status":                  ScopeRead,
+	"GetConfig":               ScopeRead,
+	"ListFailedJobs":          ScopeRead,
+	"JobLog":                  ScopeRead,
+	"IsConnected":             ScopeRead,
+	"Export":                  ScopeRead,
+	"ExportStream":            ScopeRead,
+	"Trace":                   ScopeRelease,
+	"PostRelease":             ScopeRelease,
+	"SyncNotify":              ScopeRelease,
+	"Automate":                ScopeRelease,
+	"Deautomate":              ScopeRelease,
+	"Lock":                    ScopeRelease,
+	"Unlock":                  ScopeRelease,
+	"SetMinReleaseInterval":   ScopeRelease,
+	"SetTagFilter":            ScopeRelease,
+	"Pause":                   ScopeRelease,
+	"Resume":                  ScopeRelease,
+	"RequeueJob":              ScopeRelease,
+	"AbandonJob":              ScopeRelease,
+	"PurgeFailedJobs":         ScopeRelease,
+	"RegisterDaemonV4":        ScopeRelease,
+	"RegisterDaemonV5":        ScopeRelease,
+	"SetConfig":               ScopeConfigAdmin,
+	"ValidateConfig":          ScopeConfigAdmin,
+	"PatchConfig":             ScopeConfigAdmin,
+	"GenerateDeployKeys":      ScopeConfigAdmin,
+	"PostIntegrationsGithub":  ScopeConfigAdmin,
+	"ListReleaseTemplates":    ScopeRead,
+	"SetReleaseTemplate":      ScopeRelease,
+	"DeleteReleaseTemplate":   ScopeRelease,
+	"PostReleaseFromTemplate": ScopeRelease,
+}
+
+// publicRoutes need no token: the webhooks are checked with their
+// own secret, and the API versions and the OpenAPI description are
+// there for clients to find out what to ask for.
+var publicRoutes = map[string]bool{
+	"GitWebhook":             true,
+	"ReleaseTemplateWebhook": true,
+	"APIVersions":            true,
+	"OpenAPI":                true,
+}
+
+var (
+	// ErrNoCredentials is for requests without a token.
+	ErrNoCredentials = errors.New("no token given")
+	// ErrBadCredentials is for requests with a token that isn't known.
+	ErrBadCredentials = errors.New("token not recognised")
+)
+
+// Grant is what a token allows, for which instance, and who it
+// belongs to.
+type Grant struct {
+	// Instance is the only instance the token may be used for; if
+	// blank, it may be used for any.
+	Instance flux.InstanceID
+	Scopes   []Scope
+	// User is who the token belongs to, if it's anyone in particular.
+	// Releases asked for with the token are made as this user.
+	User string
+}
+
+func (g Grant) allows(inst flux.InstanceID, scope Scope) bool {
+	if g.Instance != "" && g.Instance != inst {
+		return false
+	}
+	for _, s := range g.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator says what the credentials in a request allow, or
+// returns ErrNoCredentials or ErrBadCredentials if there aren't any
+// or they aren't any good.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Grant, error)
+}
+
+// StaticTokens is an Authenticator with a fixed set of tokens.
+type StaticTokens map[string]Grant
+
+// ParseStaticTokens reads tokens, one per line, in the form
+//
+//	<token> <scope>[,<scope>...] [<instance>] [user=<user>]
+//
+// Blank lines and lines starting with `#` are skipped.
+func ParseStaticTokens(r io.Reader) (StaticTokens, error) {
+	tokens := StaticTokens{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected a token, scopes, and optionally an instance and user", line)
+		}
+		var grant Grant
+		for _, s := range strings.Split(fields[1], ",") {
+			scope, err := ParseScope(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			grant.Scopes = append(grant.Scopes, scope)
+		}
+		for _, field := range fields[2:] {
+			switch {
+			case strings.HasPrefix(field, "user="):
+				if grant.User != "" {
+					return nil, fmt.Errorf("line %d: user given more than once", line)
+				}
+				grant.User = strings.TrimPrefix(field, "user=")
+			case grant.Instance == "":
+				grant.Instance = flux.InstanceID(field)
+			default:
+				return nil, fmt.Errorf("line %d: instance given more than once", line)
+			}
+		}
+		if _, ok := tokens[fields[0]]; ok {
+			return nil, fmt.Errorf("line %d: token given more than once", line)
+		}
+		tokens[fields[0]] = grant
+	}
+	return tokens, scanner.Err()
+}
+
+func (ts StaticTokens) Authenticate(r *http.Request) (Grant, error) {
+	token := requestToken(r)
+	if token == "" {
+		return Grant{}, ErrNoCredentials
+	}
+	for t, grant := range ts {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return grant, nil
+		}
+	}
+	return Grant{}, ErrBadCredentials
+}
+
+// requestToken gives the token from the Authorization header, as sent
+// by fluxctl and fluxd (`Scope-Probe token=<token>`) or as a bearer
+// token.
+func requestToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(header, "Scope-Probe token="):
+		return strings.TrimPrefix(header, "Scope-Probe token=")
+	case strings.HasPrefix(header, "Bearer "):
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// ErrorForbidden is for requests whose token doesn't allow what's
+// asked.
+func ErrorForbidden(scope Scope) *flux.BaseError {
+	return &flux.BaseError{
+		Help: fmt.Sprintf(`The request was not allowed
+
+The token given doesn't allow this operation, which needs the %q scope,
+or doesn't allow operations on this instance. Please use a token which
+does.
+`, scope),
+		Err: fmt.Errorf("token does not have %q scope for this instance", scope),
+	}
+}
+
+// authenticate lets requests through only if their token has the
+// scope the route needs for the instance asked about, with the user
+// the token belongs to (if any) in their context. If auth is nil, or
+// the route is public, requests are let through as they are; requests
+// for a route with no scope are refused.
+func authenticate(next http.Handler, auth Authenticator, route string) http.Handler {
+	if auth == nil || publicRoutes[route] {
+		return next
+	}
+	scope, ok := routeScopes[route]
+	if !ok {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			transport.WriteError(w, r, http.StatusForbidden, fmt.Errorf("no scope is given for %s, so it can't be used", route))
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grant, err := auth.Authenticate(r)
+		switch {
+		case err == ErrNoCredentials || err == ErrBadCredentials:
+			transport.WriteError(w, r, http.StatusUnauthorized, transport.ErrorUnauthorized)
+			return
+		case err != nil:
+			errorResponse(w, r, err)
+			return
+		case !grant.allows(getInstanceID(r), scope):
+			transport.WriteError(w, r, http.StatusForbidden, ErrorForbidden(scope))
+			return
+		}
+		ctx := context.WithValue(r.Context(), instanceKey{}, getInstanceID(r))
+		if grant.User != "" {
+			ctx = flux.WithUser(ctx, grant.User)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type instanceKey struct{}
+
+// authenticatedInstance gives the instance a request's token was
+// checked against, if it was; or, if requests aren't authenticated
+// here (e.g., because a proxy in front does it), the instance given.
+func authenticatedInstance(r *http.Request, auth Authenticator) (flux.InstanceID, bool) {
+	if auth == nil {
+		return getInstanceID(r), true
+	}
+	inst, ok := r.Context().Value(instanceKey{}).(flux.InstanceID)
+	return inst, ok
+}
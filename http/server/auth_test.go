@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+const tokensFile = `# tokens for testing
+reader read
+releaser read,release inst1 user=alice
+admin read,release,config-admin
+`
+
+func TestParseStaticTokens(t *testing.T) {
+	tokens, err := ParseStaticTokens(strings.NewReader(tokensFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("expected three tokens, got %v", tokens)
+	}
+	if g := tokens["releaser"]; g.Instance != "inst1" || len(g.Scopes) != 2 || g.Scopes[1] != ScopeRelease || g.User != "alice" {
+		t.Errorf("unexpected grant for releaser: %+v", g)
+	}
+
+	for _, bad := range []string{
+		"reader",
+		"reader write",
+		"reader read inst1 extra",
+		"reader read user=alice user=bob",
+		"reader read\nreader release",
+	} {
+		if _, err := ParseStaticTokens(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokens, err := ParseStaticTokens(strings.NewReader(tokensFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(exportService{}, transport.NewRouter(), tokens, nil, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, example := range []struct {
+		method, path string
+		token        flux.Token
+		header       string
+		instance     string
+		code         int
+	}{
+		// No or unknown token
+		{"GET", "/v3/status", "", "", "inst1", http.StatusUnauthorized},
+		{"GET", "/v3/status", "nope", "", "inst1", http.StatusUnauthorized},
+		// Not enough scope
+		{"POST", "/v4/release?service=default/helloworld&image=all&kind=execute", "reader", "", "inst1", http.StatusForbidden},
+		{"POST", "/v4/config", "releaser", "", "inst1", http.StatusForbidden},
+		// Wrong instance
+		{"POST", "/v4/release?service=default/helloworld&image=all&kind=execute", "releaser", "", "inst2", http.StatusForbidden},
+		// Bearer tokens work as well
+		{"GET", "/v5/export", "", "Bearer nope", "inst1", http.StatusUnauthorized},
+		// No token needed
+		{"GET", "/versions", "", "", "inst1", http.StatusOK},
+	} {
+		req, err := http.NewRequest(example.method, server.URL+example.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		example.token.Set(req)
+		if example.header != "" {
+			req.Header.Set("Authorization", example.header)
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, example.instance)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != example.code {
+			t.Errorf("%s %s with %q: expected %d, got %d", example.method, example.path, example.token, example.code, resp.StatusCode)
+		}
+	}
+}
+
+func TestAuthenticateAllowed(t *testing.T) {
+	tokens := StaticTokens{"tok": {Scopes: []Scope{ScopeRead}, User: "alice"}}
+	called := false
+	handler := authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if user, _ := flux.UserFromContext(r.Context()); user != "alice" {
+			t.Errorf("expected the token's user in the context, got %q", user)
+		}
+	}), tokens, "Status")
+	req := httptest.NewRequest("GET", "/v3/status", nil)
+	flux.Token("tok").Set(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !called || rec.Code != http.StatusOK {
+		t.Errorf("expected the request to be let through, got %d", rec.Code)
+	}
+}
+
+func TestAuthenticateUnknownRoute(t *testing.T) {
+	tokens := StaticTokens{"tok": {Scopes: []Scope{ScopeRead, ScopeRelease, ScopeConfigAdmin}}}
+	handler := authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected a route without a scope to be refused")
+	}), tokens, "NoSuchRoute")
+	req := httptest.NewRequest("GET", "/v6/nosuchroute", nil)
+	flux.Token("tok").Set(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestRouteScopes(t *testing.T) {
+	router := transport.NewRouter()
+	for route := range routeScopes {
+		if router.Get(route) == nil {
+			t.Errorf("scope given for unknown route %q", route)
+		}
+		if publicRoutes[route] {
+			t.Errorf("route %q is both scoped and public", route)
+		}
+	}
+	for route := range publicRoutes {
+		if router.Get(route) == nil {
+			t.Errorf("unknown route %q is public", route)
+		}
+	}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		name := route.GetName()
+		// These are answered by the router itself, and say there's
+		// nothing there.
+		if name == "NotFound" || strings.HasPrefix(name, "Deprecated:") {
+			return nil
+		}
+		if _, ok := routeScopes[name]; !ok && !publicRoutes[name] {
+			t.Errorf("route %q is neither scoped nor public", name)
+		}
+		return nil
+	})
+}
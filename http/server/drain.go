@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/http/websocket"
+	"github.com/weaveworks/flux/platform"
+)
+
+// ErrDraining is the error for requests that arrive while the server
+// is shutting down.
+var ErrDraining = errors.New("fluxsvc is shutting down; try again")
+
+// Drainer shuts the API down gracefully, so that fluxsvc can be
+// redeployed without dropping requests. Once it's draining, it
+// refuses new requests, so the load balancer sends them elsewhere;
+// it stops whatever else uses the daemons (e.g., job workers); it
+// tells each connected daemon to go away once the calls underway to
+// it have returned, so the daemon connects to another fluxsvc; and it
+// waits for the requests underway to finish.
+type Drainer struct {
+	mu       sync.Mutex
+	draining chan struct{}
+	inflight int
+	idle     chan struct{} // closed once draining and none in flight
+	stops    []func(time.Duration) error
+	stopped  chan struct{} // closed once the stops have returned
+	// how long a daemon's calls underway may take, once draining
+	callTimeout time.Duration
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{
+		draining: make(chan struct{}),
+		idle:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// StopFirst registers a stop to be called once draining, before any
+// daemon is told to go away, so that e.g. job workers can finish the
+// jobs they're running against the daemons. The stop is given how
+// long it may take. Call it before Drain.
+func (d *Drainer) StopFirst(stop func(timeout time.Duration) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stops = append(d.stops, stop)
+}
+
+type drainerKey struct{}
+
+// drainRequest is a request underway, as the drainer sees it. A
+// daemon's connection counts as underway until the daemon's been
+// told to go away, since the request itself may last a while longer.
+type drainRequest struct {
+	drainer *Drainer
+	once    sync.Once
+}
+
+func (r *drainRequest) end() {
+	r.once.Do(r.drainer.end)
+}
+
+// Handler counts the requests underway, and refuses those made once
+// draining.
+func (d *Drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.begin() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			transport.WriteError(w, r, http.StatusServiceUnavailable, ErrDraining)
+			return
+		}
+		req := &drainRequest{drainer: d}
+		defer req.end()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), drainerKey{}, req)))
+	})
+}
+
+// Draining says whether the drainer has started draining, e.g., so a
+// health check can fail.
+func (d *Drainer) Draining() bool {
+	select {
+	case <-d.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain starts draining, and waits for the requests underway to
+// finish, up to the timeout given. The stops registered with
+// StopFirst are given half of that, and daemons the other half for
+// the calls underway to them.
+func (d *Drainer) Drain(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	d.mu.Lock()
+	first := !d.Draining()
+	if first {
+		d.callTimeout = timeout / 2
+		close(d.draining)
+		if d.inflight == 0 {
+			close(d.idle)
+		}
+	}
+	stops := d.stops
+	d.mu.Unlock()
+
+	var stopErr error
+	if first {
+		stopErr = stopAll(stops, timeout/2)
+		close(d.stopped)
+	}
+
+	select {
+	case <-d.idle:
+		return stopErr
+	case <-deadline:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return fmt.Errorf("%d requests still underway after %s", d.inflight, timeout)
+	}
+}
+
+// stopAll calls the stops given at once, and waits for them to
+// return, reporting the first error.
+func stopAll(stops []func(time.Duration) error, timeout time.Duration) error {
+	errs := make(chan error, len(stops))
+	for _, stop := range stops {
+		go func(stop func(time.Duration) error) {
+			errs <- stop(timeout)
+		}(stop)
+	}
+	var first error
+	for range stops {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (d *Drainer) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.Draining() {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *Drainer) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.inflight == 0 && d.Draining() {
+		close(d.idle)
+	}
+}
+
+// watchDaemon tells the daemon connected on the websocket given to
+// go away, once the server is draining, the stops registered with
+// StopFirst have returned, and there are no calls underway to it (or
+// they've taken too long), unless it's gone before then (when done is
+// closed). It's a no-op if the request
+// didn't come through a drainer.
+func watchDaemon(ctx context.Context, ws websocket.Websocket, calls *platform.InFlight, done <-chan struct{}) {
+	req, ok := ctx.Value(drainerKey{}).(*drainRequest)
+	if !ok {
+		return
+	}
+	d := req.drainer
+	go func() {
+		select {
+		case <-d.draining:
+		case <-done:
+			return
+		}
+		select {
+		case <-d.stopped:
+		case <-done:
+			return
+		}
+		d.mu.Lock()
+		timeout := d.callTimeout
+		d.mu.Unlock()
+		select {
+		case <-calls.Idle():
+		case <-time.After(timeout):
+		case <-done:
+			return
+		}
+		ws.GoAway("fluxsvc is shutting down")
+		req.end()
+	}()
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/http/websocket"
+	"github.com/weaveworks/flux/platform"
+)
+
+func TestDrainRefusesNewRequests(t *testing.T) {
+	drainer := NewDrainer()
+	srv := httptest.NewServer(drainer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer srv.Close()
+
+	if err := drainer.Drain(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !drainer.Draining() {
+		t.Error("expected to be draining")
+	}
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected %d once draining, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestDrainWaitsForRequests(t *testing.T) {
+	drainer := NewDrainer()
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(drainer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer srv.Close()
+
+	respc := make(chan int)
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			respc <- 0
+			return
+		}
+		resp.Body.Close()
+		respc <- resp.StatusCode
+	}()
+	<-started
+
+	drained := make(chan error)
+	go func() { drained <- drainer.Drain(time.Second) }()
+	select {
+	case <-drained:
+		t.Fatal("expected Drain to wait for the request underway")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-respc; code != http.StatusOK {
+		t.Errorf("expected the request underway to finish with %d, got %d", http.StatusOK, code)
+	}
+	if err := <-drained; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	drainer := NewDrainer()
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(drainer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})))
+	defer srv.Close()
+	defer close(release)
+
+	go func() {
+		if resp, err := http.Get(srv.URL); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	if err := drainer.Drain(10 * time.Millisecond); err == nil {
+		t.Error("expected an error, with a request still underway")
+	}
+}
+
+func TestDrainTellsDaemonToGoAway(t *testing.T) {
+	drainer := NewDrainer()
+	srv := httptest.NewServer(drainer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		defer close(done)
+		watchDaemon(r.Context(), ws, platform.NewInFlight(&platform.MockPlatform{}), done)
+		// Stand in for the daemon's RPC connection, which lasts
+		// until the websocket is closed.
+		io.Copy(ioutil.Discard, ws)
+	})))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+	ws, err := websocket.Dial(http.DefaultClient, "fluxd/test", flux.Token(""), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := drainer.Drain(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF once the service has gone away, got %v", err)
+	}
+	if !ws.GoneAway() {
+		t.Error("expected the daemon to have been told to go away")
+	}
+}
+
+// goAwayRecorder is a websocket that notes when it's told to go away.
+type goAwayRecorder struct {
+	websocket.Websocket
+	goneAway chan struct{}
+}
+
+func (ws *goAwayRecorder) GoAway(reason string) error {
+	close(ws.goneAway)
+	return nil
+}
+
+func TestDrainStopsFirst(t *testing.T) {
+	drainer := NewDrainer()
+	stopping, release := make(chan struct{}), make(chan struct{})
+	drainer.StopFirst(func(timeout time.Duration) error {
+		close(stopping)
+		<-release
+		return nil
+	})
+
+	if !drainer.begin() {
+		t.Fatal("expected to begin a request before draining")
+	}
+	ctx := context.WithValue(context.Background(), drainerKey{}, &drainRequest{drainer: drainer})
+	ws := &goAwayRecorder{goneAway: make(chan struct{})}
+	done := make(chan struct{})
+	defer close(done)
+	watchDaemon(ctx, ws, platform.NewInFlight(&platform.MockPlatform{}), done)
+
+	drained := make(chan error)
+	go func() { drained <- drainer.Drain(time.Second) }()
+	<-stopping
+	select {
+	case <-ws.goneAway:
+		t.Fatal("expected the daemon to be told to go away only once stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-ws.goneAway:
+	case <-time.After(time.Second):
+		t.Fatal("expected the daemon to be told to go away once stopped")
+	}
+	if err := <-drained; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+// Git hosts limit the size of webhook payloads (GitHub's to 25MB);
+// anything bigger isn't from one.
+const maxWebhookBody = 25 << 20
+
+const branchRefPrefix = "refs/heads/"
+
+var (
+	errUnknownWebhook   = errors.New("request is not a GitHub, GitLab or Bitbucket webhook")
+	errWebhookSignature = errors.New("webhook is not signed with the secret")
+)
+
+// GitWebhook is the receiver for push webhooks from the git host of
+// the config repo. A push to the branch flux uses is as good as
+// `fluxctl sync`; other pushes, and other events, are ignored.
+func (s HTTPService) GitWebhook(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrap(err, "reading webhook"))
+		return
+	}
+
+	cfg, err := s.service.GetConfig(r.Context(), inst, "")
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	if cfg.Git.WebhookSecret == "" {
+		transport.WriteError(w, r, http.StatusForbidden, errors.New("no webhook secret is configured"))
+		return
+	}
+
+	branches, err := pushedBranches(r.Header, body, cfg.Git.WebhookSecret)
+	switch {
+	case err == errWebhookSignature:
+		transport.WriteError(w, r, http.StatusForbidden, err)
+		return
+	case err != nil:
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	branch := cfg.Git.Branch
+	if branch == "" {
+		branch = "master"
+	}
+	for _, pushed := range branches {
+		if pushed == branch {
+			if err := s.service.SyncNotify(r.Context(), inst); err != nil {
+				errorResponse(w, r, err)
+				return
+			}
+			break
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// pushedBranches checks that the webhook, with the body given, comes
+// from a git host that has the secret, and gives the branches it says
+// were pushed to. Events other than pushes (e.g., GitHub's ping when
+// the webhook is added) have none.
+//
+// GitHub and Bitbucket sign the body with the secret, in
+// X-Hub-Signature-256 or X-Hub-Signature (see hubSignature); GitLab
+// sends the secret itself, in X-Gitlab-Token.
+func pushedBranches(header http.Header, body []byte, secret string) ([]string, error) {
+	switch {
+	case header.Get("X-GitHub-Event") != "":
+		if err := checkHubSignature(hubSignature(header), body, secret); err != nil {
+			return nil, err
+		}
+		if header.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+		return refBranches(body)
+
+	case header.Get("X-Gitlab-Event") != "":
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return nil, errWebhookSignature
+		}
+		if header.Get("X-Gitlab-Event") != "Push Hook" {
+			return nil, nil
+		}
+		return refBranches(body)
+
+	case header.Get("X-Event-Key") != "":
+		if err := checkHubSignature(hubSignature(header), body, secret); err != nil {
+			return nil, err
+		}
+		switch header.Get("X-Event-Key") {
+		case "repo:push": // Bitbucket Cloud
+			var push struct {
+				Push struct {
+					Changes []struct {
+						New *struct {
+							Type string `json:"type"`
+							Name string `json:"name"`
+						} `json:"new"`
+					} `json:"changes"`
+				} `json:"push"`
+			}
+			if err := json.Unmarshal(body, &push); err != nil {
+				return nil, errors.Wrap(err, "decoding Bitbucket push")
+			}
+			var branches []string
+			for _, change := range push.Push.Changes {
+				// A deleted branch has no new state
+				if change.New != nil && change.New.Type == "branch" {
+					branches = append(branches, change.New.Name)
+				}
+			}
+			return branches, nil
+		case "repo:refs_changed": // Bitbucket Server
+			var push struct {
+				Changes []struct {
+					Ref struct {
+						ID string `json:"id"`
+					} `json:"ref"`
+				} `json:"changes"`
+			}
+			if err := json.Unmarshal(body, &push); err != nil {
+				return nil, errors.Wrap(err, "decoding Bitbucket push")
+			}
+			var branches []string
+			for _, change := range push.Changes {
+				if strings.HasPrefix(change.Ref.ID, branchRefPrefix) {
+					branches = append(branches, strings.TrimPrefix(change.Ref.ID, branchRefPrefix))
+				}
+			}
+			return branches, nil
+		}
+		return nil, nil
+	}
+	return nil, errUnknownWebhook
+}
+
+// refBranches gives the branch pushed to, in a GitHub or GitLab push
+// payload, if it was a branch (and not, e.g., a tag).
+func refBranches(body []byte) ([]string, error) {
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		return nil, errors.Wrap(err, "decoding push")
+	}
+	if !strings.HasPrefix(push.Ref, branchRefPrefix) {
+		return nil, nil
+	}
+	return []string{strings.TrimPrefix(push.Ref, branchRefPrefix)}, nil
+}
+
+// hubSignature gives the signature of the body from the headers. It's
+// the HMAC-SHA256 in X-Hub-Signature-256 if there is one; GitHub sends
+// that alongside the legacy HMAC-SHA1 in X-Hub-Signature, which is
+// used only when it's the only one sent.
+func hubSignature(header http.Header) string {
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return signature
+	}
+	return header.Get("X-Hub-Signature")
+}
+
+// checkHubSignature checks a signature as sent by GitHub and
+// Bitbucket: the hex-encoded HMAC of the body, prefixed with the hash
+// used (e.g., "sha1=").
+func checkHubSignature(signature string, body []byte, secret string) error {
+	var newHash func() hash.Hash
+	switch {
+	case strings.HasPrefix(signature, "sha1="):
+		newHash = sha1.New
+	case strings.HasPrefix(signature, "sha256="):
+		newHash = sha256.New
+	default:
+		return errWebhookSignature
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	given := signature[strings.Index(signature, "=")+1:]
+	if !hmac.Equal([]byte(given), []byte(expected)) {
+		return errWebhookSignature
+	}
+	return nil
+}
+
+// webhookUser is the user of releases from webhooks.
+const webhookUser = "webhook"
+
+// ReleaseTemplateWebhook releases from the template named, for
+// webhooks from CI systems and the like, which can't be given an API
+// token. They're checked with the instance's webhook secret, as for
+// push webhooks: either the body is signed with it (in
+// X-Hub-Signature-256 or X-Hub-Signature, as GitHub does), or it's given as is (in
+// X-Flux-Webhook-Secret, or X-Gitlab-Token). The release is always
+// made as "webhook": a signature covers only the body, so a user given
+// alongside it could be changed by anyone who'd seen the request.
+func (s HTTPService) ReleaseTemplateWebhook(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrap(err, "reading webhook"))
+		return
+	}
+
+	cfg, err := s.service.GetConfig(r.Context(), inst, "")
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	if cfg.Git.WebhookSecret == "" {
+		transport.WriteError(w, r, http.StatusForbidden, errors.New("no webhook secret is configured"))
+		return
+	}
+	if err := checkWebhookSecret(r.Header, body, cfg.Git.WebhookSecret); err != nil {
+		transport.WriteError(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	id, err := s.service.PostReleaseFromTemplate(r.Context(), inst, mux.Vars(r)["name"], webhookUser)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	jsonResponse(w, r, transport.PostReleaseResponse{
+		Status:    "Queued.",
+		ReleaseID: id,
+	})
+}
+
+// checkWebhookSecret checks that a webhook that isn't a push has the
+// secret, either as a signature of the body, or as is.
+func checkWebhookSecret(header http.Header, body []byte, secret string) error {
+	if signature := hubSignature(header); signature != "" {
+		return checkHubSignature(signature, body, secret)
+	}
+	for _, name := range []string{"X-Flux-Webhook-Secret", "X-Gitlab-Token"} {
+		if given := header.Get(name); given != "" {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+				return errWebhookSignature
+			}
+			return nil
+		}
+	}
+	return errWebhookSignature
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api"
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/jobs"
+)
+
+// webhookService has the config given, and counts sync notifications
+// and releases from templates.
+type webhookService struct {
+	api.FluxService
+	config   flux.InstanceConfig
+	notified int
+	released []string // template:user
+}
+
+func (s *webhookService) GetConfig(_ context.Context, _ flux.InstanceID, _ string) (flux.InstanceConfig, error) {
+	return s.config, nil
+}
+
+func (s *webhookService) SyncNotify(_ context.Context, _ flux.InstanceID) error {
+	s.notified++
+	return nil
+}
+
+func (s *webhookService) PostReleaseFromTemplate(_ context.Context, _ flux.InstanceID, name, user string) (jobs.JobID, error) {
+	if name != "frontend" {
+		return "", flux.NoSuchReleaseTemplate(name)
+	}
+	s.released = append(s.released, name+":"+user)
+	return "1", nil
+}
+
+func githubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func githubSignature256(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGitWebhook(t *testing.T) {
+	const secret = "s3cr3t"
+	pushMaster := []byte(`{"ref":"refs/heads/master"}`)
+	for _, c := range []struct {
+		name     string
+		secret   string
+		header   http.Header
+		body     []byte
+		status   int
+		notified int
+	}{
+		{
+			name:     "github push",
+			secret:   secret,
+			header:   http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature": {githubSignature(secret, pushMaster)}},
+			body:     pushMaster,
+			status:   http.StatusOK,
+			notified: 1,
+		},
+		{
+			name:     "github push signed with SHA-256",
+			secret:   secret,
+			header:   http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {githubSignature256(secret, pushMaster)}},
+			body:     pushMaster,
+			status:   http.StatusOK,
+			notified: 1,
+		},
+		{
+			name:   "github push with a wrong SHA-256 signature",
+			secret: secret,
+			header: http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {githubSignature256("guess", pushMaster)}},
+			body:   pushMaster,
+			status: http.StatusForbidden,
+		},
+		{
+			name:     "github push signed both ways",
+			secret:   secret,
+			header:   http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {githubSignature256(secret, pushMaster)}, "X-Hub-Signature": {githubSignature(secret, pushMaster)}},
+			body:     pushMaster,
+			status:   http.StatusOK,
+			notified: 1,
+		},
+		{
+			name:   "github push with a wrong SHA-256 signature, and a right SHA-1 one",
+			secret: secret,
+			header: http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {githubSignature256("guess", pushMaster)}, "X-Hub-Signature": {githubSignature(secret, pushMaster)}},
+			body:   pushMaster,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "github push to another branch",
+			secret: secret,
+			header: http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature": {githubSignature(secret, []byte(`{"ref":"refs/heads/dev"}`))}},
+			body:   []byte(`{"ref":"refs/heads/dev"}`),
+			status: http.StatusOK,
+		},
+		{
+			name:   "github ping",
+			secret: secret,
+			header: http.Header{"X-Github-Event": {"ping"}, "X-Hub-Signature": {githubSignature(secret, []byte(`{}`))}},
+			body:   []byte(`{}`),
+			status: http.StatusOK,
+		},
+		{
+			name:   "github wrong secret",
+			secret: secret,
+			header: http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature": {githubSignature("guess", pushMaster)}},
+			body:   pushMaster,
+			status: http.StatusForbidden,
+		},
+		{
+			name:     "gitlab push",
+			secret:   secret,
+			header:   http.Header{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {secret}},
+			body:     pushMaster,
+			status:   http.StatusOK,
+			notified: 1,
+		},
+		{
+			name:   "gitlab wrong token",
+			secret: secret,
+			header: http.Header{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {"guess"}},
+			body:   pushMaster,
+			status: http.StatusForbidden,
+		},
+		{
+			name:     "bitbucket push",
+			secret:   secret,
+			header:   http.Header{"X-Event-Key": {"repo:push"}, "X-Hub-Signature": {githubSignature(secret, []byte(`{"push":{"changes":[{"new":null},{"new":{"type":"branch","name":"master"}}]}}`))}},
+			body:     []byte(`{"push":{"changes":[{"new":null},{"new":{"type":"branch","name":"master"}}]}}`),
+			status:   http.StatusOK,
+			notified: 1,
+		},
+		{
+			name:   "no secret configured",
+			header: http.Header{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {""}},
+			body:   pushMaster,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "not a webhook",
+			secret: secret,
+			body:   pushMaster,
+			status: http.StatusBadRequest,
+		},
+	} {
+		svc := &webhookService{config: flux.InstanceConfig{Git: flux.GitConfig{WebhookSecret: c.secret}}}
+		server := httptest.NewServer(NewHandler(svc, transport.NewRouter(), nil, nil, nil, log.NewNopLogger()))
+		req, err := http.NewRequest("POST", server.URL+"/v6/integrations/git/webhook", bytes.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, resp.StatusCode)
+		}
+		if svc.notified != c.notified {
+			t.Errorf("%s: expected %d sync notifications, got %d", c.name, c.notified, svc.notified)
+		}
+	}
+}
+
+func TestReleaseTemplateWebhook(t *testing.T) {
+	const secret = "s3cr3t"
+	body := []byte(`{"build":42}`)
+	for _, c := range []struct {
+		name     string
+		path     string
+		secret   string
+		header   http.Header
+		status   int
+		released []string
+	}{
+		{
+			name:     "signed",
+			path:     "/v6/release-templates/frontend/webhook",
+			secret:   secret,
+			header:   http.Header{"X-Hub-Signature": {githubSignature(secret, body)}},
+			status:   http.StatusOK,
+			released: []string{"frontend:webhook"},
+		},
+		{
+			name:     "signed with SHA-256",
+			path:     "/v6/release-templates/frontend/webhook",
+			secret:   secret,
+			header:   http.Header{"X-Hub-Signature-256": {githubSignature256(secret, body)}},
+			status:   http.StatusOK,
+			released: []string{"frontend:webhook"},
+		},
+		{
+			name:     "secret given, with user, which isn't used",
+			path:     "/v6/release-templates/frontend/webhook?user=ci",
+			secret:   secret,
+			header:   http.Header{"X-Flux-Webhook-Secret": {secret}},
+			status:   http.StatusOK,
+			released: []string{"frontend:webhook"},
+		},
+		{
+			name:   "wrong secret",
+			path:   "/v6/release-templates/frontend/webhook",
+			secret: secret,
+			header: http.Header{"X-Flux-Webhook-Secret": {"guess"}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no secret given",
+			path:   "/v6/release-templates/frontend/webhook",
+			secret: secret,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no secret configured",
+			path:   "/v6/release-templates/frontend/webhook",
+			header: http.Header{"X-Flux-Webhook-Secret": {""}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no such template",
+			path:   "/v6/release-templates/backend/webhook",
+			secret: secret,
+			header: http.Header{"X-Flux-Webhook-Secret": {secret}},
+			status: http.StatusNotFound,
+		},
+	} {
+		svc := &webhookService{config: flux.InstanceConfig{Git: flux.GitConfig{WebhookSecret: c.secret}}}
+		server := httptest.NewServer(NewHandler(svc, transport.NewRouter(), nil, nil, nil, log.NewNopLogger()))
+		req, err := http.NewRequest("POST", server.URL+c.path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, resp.StatusCode)
+		}
+		if len(svc.released) != len(c.released) || (len(c.released) > 0 && svc.released[0] != c.released[0]) {
+			t.Errorf("%s: expected releases %v, got %v", c.name, c.released, svc.released)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+// InstanceLister lists instances for operators; see
+// server.Instances.
+type InstanceLister interface {
+	ListInstances(flux.InstanceQuery) (flux.InstanceList, error)
+}
+
+// NewInstancesHandler serves the list of instances, with the query
+// given in the parameters `match`, `connected`, `troubled`, `offset`
+// and `limit`. It lists every instance, so it belongs with the other
+// operational endpoints, rather than with the API.
+func NewInstancesHandler(l InstanceLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := instanceQuery(r)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		list, err := l.ListInstances(q)
+		if err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+		jsonResponse(w, r, list)
+	})
+}
+
+func instanceQuery(r *http.Request) (flux.InstanceQuery, error) {
+	params := r.URL.Query()
+	q := flux.InstanceQuery{Match: params.Get("match")}
+	if param := params.Get("connected"); param != "" {
+		connected, err := strconv.ParseBool(param)
+		if err != nil {
+			return q, fmt.Errorf("invalid connected parameter %q", param)
+		}
+		q.Connected = &connected
+	}
+	if param := params.Get("troubled"); param != "" {
+		troubled, err := strconv.ParseBool(param)
+		if err != nil {
+			return q, fmt.Errorf("invalid troubled parameter %q", param)
+		}
+		q.Troubled = troubled
+	}
+	for name, n := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if param := params.Get(name); param != "" {
+			i, err := strconv.Atoi(param)
+			if err != nil || i < 0 {
+				return q, fmt.Errorf("invalid %s parameter %q", name, param)
+			}
+			*n = i
+		}
+	}
+	return q, nil
+}
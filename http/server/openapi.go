@@ -0,0 +1,427 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/jobs"
+)
+
+// routeDoc is what the router can't tell us about a route: what it's
+// for, its query parameters, and the types of its request body and
+// response, given as (zero) values of those types.
+type routeDoc struct {
+	summary     string
+	params      []paramDoc
+	request     interface{}
+	response    interface{}
+	contentType string // of the response, if it's not JSON
+}
+
+type paramDoc struct {
+	name        string
+	description string
+	repeated    bool
+}
+
+var (
+	serviceParam = paramDoc{name: "service", description: "a service ID, e.g., default/helloworld"}
+	userParam    = paramDoc{name: "user", description: "who's doing it, for the history"}
+	idParam      = paramDoc{name: "id", description: "the release (job) ID"}
+	beforeParam  = paramDoc{name: "before", description: "only events before this time (RFC3339) or cursor"}
+	limitParam   = paramDoc{name: "limit", description: "the most events to return"}
+	simpleParam  = paramDoc{name: "simple", description: "if true, leave out each event's details"}
+)
+
+// routeDocs documents each named route, for the OpenAPI description.
+var routeDocs = map[string]routeDoc{
+	"ListServices": {
+		summary:  "List the services running",
+		params:   []paramDoc{{name: "namespace", description: "the namespace to list; blank for all"}},
+		response: []flux.ServiceStatus{},
+	},
+	"ListImages": {
+		summary: "List the images available for services",
+		params: []paramDoc{
+			{name: "service", description: "a service ID, or <all>"},
+			{name: "only", description: "a service ID to include; may be given more than once", repeated: true},
+		},
+		response: []flux.ImageStatus{},
+	},
+	"PostRelease": {
+		summary: "Start a release",
+		params: []paramDoc{
+			{name: "service", description: "a service spec: a service ID, a label selector, or <all>", repeated: true},
+			{name: "image", description: "an image, or <all_latest>"},
+			{name: "kind", description: "plan or execute"},
+			{name: "exclude", description: "a service ID to leave out", repeated: true},
+			{name: "set-env", description: "an environment variable to set, as CONTAINER:NAME=VALUE", repeated: true},
+			{name: "set-config", description: "a config map value to set, as NAME:KEY=VALUE", repeated: true},
+			userParam,
+			{name: "message", description: "why, for the history"},
+		},
+		response: transport.PostReleaseResponse{},
+	},
+	"ValidateRelease": {
+		summary:  "Check a release without starting it",
+		request:  flux.ReleaseSpec{},
+		response: []flux.ReleaseProblem{},
+	},
+	"GetRelease": {
+		summary: "Get the status of a release",
+		params: []paramDoc{
+			idParam,
+			{name: "wait", description: "how long to wait (a duration, e.g., 30s) for the release to finish"},
+		},
+		response: jobs.Job{},
+	},
+	"SyncNotify":            {summary: "Tell the daemon there are new commits to sync"},
+	"Automate":              {summary: "Automate a service", params: []paramDoc{serviceParam}},
+	"Deautomate":            {summary: "Stop automating a service", params: []paramDoc{serviceParam}},
+	"Lock":                  {summary: "Lock a service", params: []paramDoc{serviceParam}},
+	"Unlock":                {summary: "Unlock a service", params: []paramDoc{serviceParam}},
+	"SetMinReleaseInterval": {summary: "Set the least time between automated releases of a service", params: []paramDoc{serviceParam, {name: "interval", description: "a duration, e.g., 1h; 0 for none"}}},
+	"SetTagFilter":          {summary: "Set the image tags a service may be automated to", params: []paramDoc{serviceParam, {name: "pattern", description: "a glob pattern; blank for any tag"}}},
+	"Pause":                 {summary: "Pause all automation", params: []paramDoc{userParam, {name: "reason", description: "why, for the history"}}},
+	"Resume":                {summary: "Resume automation", params: []paramDoc{userParam}},
+	"History": {
+		summary: "Get the history of a service, newest first",
+		params: []paramDoc{
+			{name: "service", description: "a service ID, or <all>"},
+			beforeParam,
+			{name: "after", description: "only events after this cursor"},
+			{name: "type", description: "a comma-separated list of event types"},
+			limitParam,
+			simpleParam,
+		},
+		response: []flux.HistoryEntry{},
+	},
+	"HistoryAllClusters": {
+		summary:  "Get the history of a service in every cluster",
+		params:   []paramDoc{{name: "service", description: "a service ID, or <all>"}, beforeParam, limitParam, simpleParam},
+		response: []flux.HistoryEntry{},
+	},
+	"ReleaseHistory":     {summary: "Get the versions a service has been released at", params: []paramDoc{serviceParam}, response: []flux.ServiceVersion{}},
+	"ServiceChanges":     {summary: "Get the changes made to a service's definition", params: []paramDoc{serviceParam}, response: []flux.ServiceChange{}},
+	"Lint":               {summary: "Check the service definitions in the config repo", response: flux.LintReport{}},
+	"Status":             {summary: "Get the status of the instance", response: flux.Status{}},
+	"GetConfig":          {summary: "Get the instance's config, without secrets", params: []paramDoc{{name: "fingerprint", description: "md5 or sha256, to give the deploy key's fingerprint rather than the key"}}, response: flux.InstanceConfig{}},
+	"SetConfig":          {summary: "Set the instance's config", request: flux.UnsafeInstanceConfig{}},
+	"ValidateConfig":     {summary: "Check an instance config without setting it", request: flux.UnsafeInstanceConfig{}},
+	"PatchConfig":        {summary: "Change part of the instance's config", request: flux.ConfigPatch{}},
+	"GenerateDeployKeys": {summary: "Make a new deploy key for the config repo"},
+	"GitWebhook":         {summary: "Receive a push event from the git host; checked with the instance's webhook secret"},
+	"PostIntegrationsGithub": {
+		summary: "Add the deploy key to a GitHub repo, with the token in the GithubToken header",
+		params:  []paramDoc{{name: "owner", description: "the repo's owner"}, {name: "repository", description: "the repo's name"}},
+	},
+	"ListFailedJobs":   {summary: "List the jobs that failed", response: []jobs.Job{}},
+	"PurgeFailedJobs":  {summary: "Forget the jobs that failed", response: transport.PurgeFailedJobsResponse{}},
+	"JobLog":           {summary: "Get the log of a job", response: []string{}},
+	"RequeueJob":       {summary: "Run a failed job again", params: []paramDoc{idParam}},
+	"AbandonJob":       {summary: "Give up on a failed job", params: []paramDoc{idParam}},
+	"RegisterDaemonV4": {summary: "Connect a daemon, over a websocket"},
+	"RegisterDaemonV5": {summary: "Connect a daemon, over a websocket"},
+	"Trace": {
+		summary: "Log the daemon's RPCs in detail for a while",
+		params:  []paramDoc{{name: "duration", description: "how long, e.g., 5m; 0 to stop"}},
+	},
+	"ListReleaseTemplates":    {summary: "List the release templates", response: []flux.ReleaseTemplate{}},
+	"SetReleaseTemplate":      {summary: "Add or replace a release template", request: flux.ReleaseTemplate{}},
+	"DeleteReleaseTemplate":   {summary: "Remove a release template"},
+	"PostReleaseFromTemplate": {summary: "Start a release from a template", params: []paramDoc{userParam}, response: transport.PostReleaseResponse{}},
+	"ReleaseTemplateWebhook": {
+		summary:  "Start a release from a template, as the user \"webhook\"; checked with the instance's webhook secret",
+		response: transport.PostReleaseResponse{},
+	},
+	"IsConnected":  {summary: "Check the daemon is connected", response: flux.FluxdStatus{}},
+	"Export":       {summary: "Export the cluster's resources, as base64-encoded YAML", response: []byte{}},
+	"ExportStream": {summary: "Export the cluster's resources, as YAML", contentType: "application/x-yaml"},
+	"APIVersions":  {summary: "List the API versions served", response: transport.APIVersionsResponse{}},
+	"OpenAPI":      {summary: "Get this description of the API"},
+}
+
+var pathVarRE = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+// OpenAPI describes the API served by the router, as an OpenAPI 3
+// document. The paths, methods and which query parameters are
+// required come from the router; the rest from routeDocs. Routes
+// that aren't documented (the deprecated versions, and the catch-all)
+// are left out.
+func OpenAPI(r *mux.Router) (map[string]interface{}, error) {
+	schemas := schemaSet{}
+	paths := map[string]map[string]interface{}{}
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		doc, ok := routeDocs[route.GetName()]
+		if !ok {
+			return nil
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, required := probeRoute(route, tmpl, doc.params)
+		if len(methods) == 0 {
+			return fmt.Errorf("route %s matches no request with the documented parameters", route.GetName())
+		}
+		if paths[tmpl] == nil {
+			paths[tmpl] = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			paths[tmpl][strings.ToLower(method)] = operation(route.GetName(), doc, tmpl, required, schemas)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "Flux",
+			"version":     transport.APIVersions[len(transport.APIVersions)-1],
+			"description": "The API of the Flux service, as used by fluxctl and the daemon.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}, nil
+}
+
+// probeRoute finds the methods a route accepts, and which of the
+// query parameters it must have, by trying requests against it. HEAD
+// isn't given separately for routes that accept GET.
+func probeRoute(route *mux.Route, tmpl string, params []paramDoc) (methods []string, required map[string]bool) {
+	p := pathVarRE.ReplaceAllString(tmpl, "x")
+	query := url.Values{}
+	for _, param := range params {
+		query.Set(param.name, "x")
+	}
+	matches := func(method string, query url.Values) bool {
+		req, err := http.NewRequest(method, p+"?"+query.Encode(), nil)
+		return err == nil && route.Match(req, &mux.RouteMatch{})
+	}
+
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"} {
+		if method == "HEAD" && len(methods) > 0 && methods[0] == "GET" {
+			continue
+		}
+		if matches(method, query) {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	required = map[string]bool{}
+	for _, param := range params {
+		without := url.Values{}
+		for k, v := range query {
+			if k != param.name {
+				without[k] = v
+			}
+		}
+		required[param.name] = !matches(methods[0], without)
+	}
+	return methods, required
+}
+
+func operation(name string, doc routeDoc, tmpl string, required map[string]bool, schemas schemaSet) map[string]interface{} {
+	var params []interface{}
+	for _, v := range pathVarRE.FindAllStringSubmatch(tmpl, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     v[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range doc.params {
+		schema := map[string]interface{}{"type": "string"}
+		if param.repeated {
+			schema = map[string]interface{}{"type": "array", "items": schema}
+		}
+		params = append(params, map[string]interface{}{
+			"name":        param.name,
+			"in":          "query",
+			"description": param.description,
+			"required":    required[param.name],
+			"schema":      schema,
+		})
+	}
+
+	ok := map[string]interface{}{"description": "OK"}
+	switch {
+	case doc.response != nil:
+		ok["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(doc.response))},
+		}
+	case doc.contentType != "":
+		ok["content"] = map[string]interface{}{
+			doc.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	}
+	op := map[string]interface{}{
+		"operationId": name,
+		"summary":     doc.summary,
+		"responses": map[string]interface{}{
+			"200": ok,
+			"default": map[string]interface{}{
+				"description": "An error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(flux.BaseError{}))},
+				},
+			},
+		},
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if doc.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(doc.request))},
+			},
+		}
+	}
+	if scope, ok := routeScopes[name]; ok {
+		op["description"] = fmt.Sprintf("Needs a token with %s scope.", scope)
+		op["security"] = []interface{}{map[string]interface{}{"token": []string{}}}
+	}
+	return op
+}
+
+// schemaSet is the named schemas (components) of an OpenAPI document,
+// filled in as the types are come across.
+type schemaSet map[string]interface{}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	imageIDType   = reflect.TypeOf(flux.ImageID{})
+	baseErrorType = reflect.TypeOf(flux.BaseError{})
+)
+
+// of gives the schema for values of type t, as they are marshalled to
+// JSON. Named structs are referred to by name, e.g.,
+// "flux.ServiceStatus".
+func (s schemaSet) of(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case imageIDType:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := s[name]; !ok {
+			s[name] = nil // so types referring to themselves stop here
+			if t == baseErrorType {
+				// This has its own MarshalJSON
+				s[name] = map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"help":  map[string]interface{}{"type": "string"},
+						"error": map[string]interface{}{"type": "string"},
+					},
+				}
+			} else {
+				s[name] = s.structSchema(t)
+			}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{}, and anything else, could be anything
+	return map[string]interface{}{}
+}
+
+func (s schemaSet) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	s.addFields(props, t)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// addFields adds the properties for the fields of struct type t,
+// following encoding/json: embedded structs' fields are promoted,
+// unless the embedded field is named in its tag.
+func (s schemaSet) addFields(props map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag[0] == "" && ft.Kind() == reflect.Struct {
+			s.addFields(props, ft)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.of(f.Type)
+		for _, opt := range tag[1:] {
+			if opt == "string" {
+				props[name] = map[string]interface{}{"type": "string"}
+			}
+		}
+	}
+}
+
+func serveOpenAPI(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := OpenAPI(router)
+		if err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+		jsonResponse(w, r, doc)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	router := transport.NewRouter()
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		name := route.GetName()
+		if _, ok := routeDocs[name]; !ok && name != "NotFound" && !strings.HasPrefix(name, "Deprecated:") {
+			t.Errorf("route %s is not documented in routeDocs", name)
+		}
+		return nil
+	})
+	for name := range routeDocs {
+		if router.Get(name) == nil {
+			t.Errorf("routeDocs documents %s, which is not a route", name)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := OpenAPI(transport.NewRouter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name     string
+				In       string
+				Required bool
+			}
+			Security []interface{}
+		}
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(bytes, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	release, ok := parsed.Paths["/v4/release"]["post"]
+	if !ok || release.OperationID != "PostRelease" {
+		t.Fatalf("expected POST /v4/release to be PostRelease, got %+v", parsed.Paths["/v4/release"])
+	}
+	required := map[string]bool{}
+	for _, p := range release.Parameters {
+		required[p.Name] = p.Required
+	}
+	if !required["image"] || !required["kind"] || required["user"] {
+		t.Errorf("expected image and kind (only) to be required, got %v", required)
+	}
+	if len(release.Security) == 0 {
+		t.Errorf("expected PostRelease to need a token")
+	}
+
+	if get, ok := parsed.Paths["/v4/release"]["get"]; !ok || get.OperationID != "GetRelease" {
+		t.Errorf("expected GET /v4/release to be GetRelease, got %+v", get)
+	}
+	if _, ok := parsed.Paths["/v4/ping"]["head"]; ok {
+		t.Errorf("expected HEAD to be left out where there's GET")
+	}
+	log := parsed.Paths["/v6/jobs/{id}/log"]["get"]
+	if len(log.Parameters) != 1 || log.Parameters[0].In != "path" || log.Parameters[0].Name != "id" {
+		t.Errorf("expected the job ID as a path parameter, got %+v", log.Parameters)
+	}
+	if _, ok := parsed.Paths["/versions"]["get"]; !ok {
+		t.Errorf("expected /versions to be described")
+	}
+	for _, name := range []string{"flux.ServiceStatus", "jobs.Job", "flux.BaseError"} {
+		if _, ok := parsed.Components.Schemas[name]; !ok {
+			t.Errorf("expected a schema for %s", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+// unlimitedRoutes are never rate limited: daemons connect once, and
+// stay connected.
+var unlimitedRoutes = map[string]bool{
+	"RegisterDaemonV4": true,
+	"RegisterDaemonV5": true,
+}
+
+// How often buckets that have filled up again are thrown away, so
+// that instances that have stopped calling (or, if requests aren't
+// authenticated here, never existed) aren't kept.
+const rateLimitSweepInterval = 10 * time.Minute
+
+var throttledRequests = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Namespace: "flux",
+	Name:      "requests_throttled_total",
+	Help:      "Number of HTTP requests refused because the instance was over its rate limit.",
+}, []string{fluxmetrics.LabelMethod})
+
+// RateLimit is a token bucket: it's refilled at Rate requests a
+// second, and holds at most Burst of them.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiter limits the requests each instance makes, either to all
+// routes together, or to each route separately.
+type RateLimiter struct {
+	limit    RateLimit
+	perRoute bool
+
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[rateKey]*bucket
+	lastSweep time.Time
+}
+
+// The route is empty unless each route is limited separately.
+type rateKey struct {
+	instance flux.InstanceID
+	route    string
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter gives a limiter allowing each instance the rate
+// given, for its requests to each route if perRoute is true, or to
+// all routes together otherwise.
+func NewRateLimiter(limit RateLimit, perRoute bool) (*RateLimiter, error) {
+	if limit.Rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be more than zero requests a second, got %v", limit.Rate)
+	}
+	if limit.Burst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least one request, got %d", limit.Burst)
+	}
+	return &RateLimiter{
+		limit:    limit,
+		perRoute: perRoute,
+		now:      time.Now,
+		buckets:  map[rateKey]*bucket{},
+	}, nil
+}
+
+// Allow takes a request from the instance's bucket for the route. If
+// the bucket is empty, it says how long until it won't be.
+func (l *RateLimiter) Allow(inst flux.InstanceID, route string) (bool, time.Duration) {
+	key := rateKey{instance: inst}
+	if l.perRoute {
+		key.route = route
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *RateLimiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*l.limit.Rate
+	return math.Min(tokens, float64(l.limit.Burst))
+}
+
+// sweep removes the buckets that are full, since they'd be the same
+// if made afresh.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func ErrorTooManyRequests(retryAfter time.Duration) error {
+	return &flux.BaseError{
+		Help: fmt.Sprintf(`Too many requests
+
+This instance has made more requests than it's allowed to in a short
+time. Please wait %s before trying again.
+
+If you have scripts or tools calling the API in a loop, they may need
+to slow down.
+`, retryAfter),
+		Err: fmt.Errorf("rate limit exceeded; retry after %s", retryAfter),
+	}
+}
+
+// rateLimit refuses requests over the instance's limit with 429 Too
+// Many Requests, saying when to try again in the Retry-After header.
+// It goes inside authenticate, so the instance is one the request's
+// token is for. Requests that weren't authenticated (to public routes)
+// aren't limited, since counting them against the instance they name
+// would let anyone use up its requests.
+func rateLimit(next http.Handler, limiter *RateLimiter, auth Authenticator, route string) http.Handler {
+	if limiter == nil || unlimitedRoutes[route] {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inst, ok := authenticatedInstance(r, auth)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.Allow(inst, route); !ok {
+			throttledRequests.With(fluxmetrics.LabelMethod, route).Add(1)
+			// Retry-After is in whole seconds
+			retryAfter := time.Duration(math.Ceil(wait.Seconds())) * time.Second
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+			transport.WriteError(w, r, http.StatusTooManyRequests, ErrorTooManyRequests(retryAfter))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l, err := NewRateLimiter(RateLimit{Rate: 2, Burst: 2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("inst", "ListServices"); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("inst", "ListImages")
+	if ok {
+		t.Fatal("expected a request over the burst to be refused, for any route")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, got %s", wait)
+	}
+	if ok, _ := l.Allow("other", "ListServices"); !ok {
+		t.Error("expected another instance's request to be allowed")
+	}
+
+	now = now.Add(wait)
+	if ok, _ := l.Allow("inst", "ListServices"); !ok {
+		t.Error("expected a request to be allowed after waiting")
+	}
+
+	now = now.Add(rateLimitSweepInterval + time.Second)
+	l.Allow("inst", "ListServices")
+	if len(l.buckets) != 1 {
+		t.Errorf("expected full buckets to be swept, leaving 1, got %d", len(l.buckets))
+	}
+}
+
+func TestRateLimiterPerRoute(t *testing.T) {
+	l, err := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return time.Unix(0, 0) }
+	if ok, _ := l.Allow("inst", "ListServices"); !ok {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if ok, _ := l.Allow("inst", "ListImages"); !ok {
+		t.Error("expected a request to another route to be allowed")
+	}
+	if ok, _ := l.Allow("inst", "ListServices"); ok {
+		t.Error("expected a second request to the same route to be refused")
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	for _, limit := range []RateLimit{{Rate: 0, Burst: 1}, {Rate: 1, Burst: 0}} {
+		if _, err := NewRateLimiter(limit, false); err == nil {
+			t.Errorf("expected %+v to be refused", limit)
+		}
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimit{Rate: 0.1, Burst: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := func(w io.Writer) error {
+		_, err := io.WriteString(w, exportedConfig)
+		return err
+	}
+	handler := NewHandler(exportService{export: export}, transport.NewRouter(), nil, limiter, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func() *http.Response {
+		req, err := http.NewRequest("GET", server.URL+"/v6/export", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, "inst")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		return resp
+	}
+
+	if resp := get(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the first request to succeed, got %d", resp.StatusCode)
+	}
+	resp := get()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 response, got %d", resp.StatusCode)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("expected Retry-After of 10 seconds, got %q", retryAfter)
+	}
+}
+
+func TestRateLimitAfterAuthentication(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimit{Rate: 0.1, Burst: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := func(w io.Writer) error {
+		_, err := io.WriteString(w, exportedConfig)
+		return err
+	}
+	tokens := StaticTokens{"tok": {Instance: "inst", Scopes: []Scope{ScopeRead}}}
+	handler := NewHandler(exportService{export: export}, transport.NewRouter(), tokens, limiter, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(token flux.Token) int {
+		req, err := http.NewRequest("GET", server.URL+"/v6/export", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, "inst")
+		token.Set(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		return resp.StatusCode
+	}
+
+	// Requests without a good token don't count against the instance
+	for _, token := range []flux.Token{"", "guess"} {
+		if code := get(token); code != http.StatusUnauthorized {
+			t.Fatalf("expected %d without a good token, got %d", http.StatusUnauthorized, code)
+		}
+	}
+	if code := get("tok"); code != http.StatusOK {
+		t.Fatalf("expected the first authenticated request to succeed, got %d", code)
+	}
+	if code := get("tok"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second authenticated request to be limited, got %d", code)
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
@@ -12,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 	"time"
 
@@ -23,6 +26,7 @@ import (
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/api"
+	"github.com/weaveworks/flux/audit"
 	transport "github.com/weaveworks/flux/http"
 	"github.com/weaveworks/flux/http/httperror"
 	"github.com/weaveworks/flux/http/websocket"
@@ -30,32 +34,68 @@ import (
 	"github.com/weaveworks/flux/jobs"
 	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/platform/rpc"
+	"github.com/weaveworks/flux/tracing"
 )
 
-func NewHandler(s api.FluxService, r *mux.Router, logger log.Logger) http.Handler {
+// NewHandler serves the API. If auth is not nil, requests must have a
+// token with the scope each route needs; see routeScopes. If limiter
+// is not nil, each instance's requests are rate limited. If auditLog
+// is not nil, calls that change things are recorded in it; see
+// auditedRoutes.
+func NewHandler(s api.FluxService, r *mux.Router, auth Authenticator, limiter *RateLimiter, auditLog audit.Writer, logger log.Logger) http.Handler {
 	handle := HTTPService{s}
 	for method, handlerMethod := range map[string]http.HandlerFunc{
-		"ListServices":           handle.ListServices,
-		"ListImages":             handle.ListImages,
-		"PostRelease":            handle.PostRelease,
-		"GetRelease":             handle.GetRelease,
-		"Automate":               handle.Automate,
-		"Deautomate":             handle.Deautomate,
-		"Lock":                   handle.Lock,
-		"Unlock":                 handle.Unlock,
-		"History":                handle.History,
-		"Status":                 handle.Status,
-		"GetConfig":              handle.GetConfig,
-		"SetConfig":              handle.SetConfig,
-		"PatchConfig":            handle.PatchConfig,
-		"GenerateDeployKeys":     handle.GenerateKeys,
-		"PostIntegrationsGithub": handle.PostIntegrationsGithub,
-		"RegisterDaemonV4":       handle.RegisterV4,
-		"RegisterDaemonV5":       handle.RegisterV5,
-		"IsConnected":            handle.IsConnected,
-		"Export":                 handle.Export,
+		"ListServices":            handle.ListServices,
+		"ListImages":              handle.ListImages,
+		"PostRelease":             handle.PostRelease,
+		"ValidateRelease":         handle.ValidateRelease,
+		"GetRelease":              handle.GetRelease,
+		"SyncNotify":              handle.SyncNotify,
+		"Automate":                handle.Automate,
+		"Deautomate":              handle.Deautomate,
+		"Lock":                    handle.Lock,
+		"Unlock":                  handle.Unlock,
+		"SetMinReleaseInterval":   handle.SetMinReleaseInterval,
+		"SetTagFilter":            handle.SetTagFilter,
+		"Pause":                   handle.Pause,
+		"Resume":                  handle.Resume,
+		"History":                 handle.History,
+		"HistoryAllClusters":      handle.HistoryAllClusters,
+		"ReleaseHistory":          handle.ReleaseHistory,
+		"ServiceChanges":          handle.ServiceChanges,
+		"Lint":                    handle.Lint,
+		"Status":                  handle.Status,
+		"GetConfig":               handle.GetConfig,
+		"SetConfig":               handle.SetConfig,
+		"ValidateConfig":          handle.ValidateConfig,
+		"PatchConfig":             handle.PatchConfig,
+		"GenerateDeployKeys":      handle.GenerateKeys,
+		"PostIntegrationsGithub":  handle.PostIntegrationsGithub,
+		"GitWebhook":              handle.GitWebhook,
+		"ListFailedJobs":          handle.ListFailedJobs,
+		"RequeueJob":              handle.RequeueJob,
+		"AbandonJob":              handle.AbandonJob,
+		"JobLog":                  handle.JobLog,
+		"PurgeFailedJobs":         handle.PurgeFailedJobs,
+		"RegisterDaemonV4":        handle.RegisterV4,
+		"RegisterDaemonV5":        handle.RegisterV5,
+		"ListReleaseTemplates":    handle.ListReleaseTemplates,
+		"SetReleaseTemplate":      handle.SetReleaseTemplate,
+		"DeleteReleaseTemplate":   handle.DeleteReleaseTemplate,
+		"PostReleaseFromTemplate": handle.PostReleaseFromTemplate,
+		"ReleaseTemplateWebhook":  handle.ReleaseTemplateWebhook,
+		"IsConnected":             handle.IsConnected,
+		"Export":                  handle.Export,
+		"ExportStream":            handle.ExportStream,
+		"Trace":                   handle.Trace,
+		"APIVersions":             handle.APIVersions,
+		"OpenAPI":                 serveOpenAPI(r),
 	} {
-		handler := logging(handlerMethod, log.NewContext(logger).With("method", method))
+		handler := audited(handlerMethod, auditLog, method, logger)
+		handler = rateLimit(handler, limiter, auth, method)
+		handler = recordUsage(handler, auth, method)
+		handler = withTimeout(authenticate(handler, auth, method), routeTimeout(method))
+		handler = logging(traced(handler, method), log.NewContext(logger).With("method", method))
 		r.Get(method).Handler(handler)
 	}
 
@@ -72,7 +112,7 @@ type HTTPService struct {
 func (s HTTPService) ListServices(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
 	namespace := mux.Vars(r)["namespace"]
-	res, err := s.service.ListServices(inst, namespace)
+	res, err := s.service.ListServices(r.Context(), inst, namespace)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -89,7 +129,17 @@ func (s HTTPService) ListImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := s.service.ListImages(inst, spec)
+	var only []flux.ImageStatusFilter
+	for _, o := range r.URL.Query()["only"] {
+		f, err := flux.ParseImageStatusFilter(o)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing filter %q", o))
+			return
+		}
+		only = append(only, f)
+	}
+
+	d, err := s.service.ListImages(r.Context(), inst, spec, only)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -139,12 +189,31 @@ func (s HTTPService) PostRelease(w http.ResponseWriter, r *http.Request) {
 		excludes = append(excludes, s)
 	}
 
-	id, err := s.service.PostRelease(inst, jobs.ReleaseJobParams{
+	var values []flux.ValueUpdate
+	for _, param := range []struct {
+		name string
+		kind flux.ValueKind
+	}{
+		{"set-env", flux.ValueKindEnv},
+		{"set-config", flux.ValueKindConfigMap},
+	} {
+		for _, v := range r.URL.Query()[param.name] {
+			u, err := flux.ParseValueUpdate(param.kind, v)
+			if err != nil {
+				transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing value update %q", v))
+				return
+			}
+			values = append(values, u)
+		}
+	}
+
+	id, err := s.service.PostRelease(r.Context(), inst, jobs.ReleaseJobParams{
 		ReleaseSpec: flux.ReleaseSpec{
 			ServiceSpecs: serviceSpecs,
 			ImageSpec:    imageSpec,
 			Kind:         releaseKind,
 			Excludes:     excludes,
+			ValueUpdates: values,
 		},
 		Cause: flux.ReleaseCause{
 			User:    r.FormValue("user"),
@@ -162,10 +231,39 @@ func (s HTTPService) PostRelease(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s HTTPService) ValidateRelease(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+
+	var spec flux.ReleaseSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	problems, err := s.service.ValidateRelease(r.Context(), inst, spec)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	if problems == nil {
+		problems = []flux.ReleaseProblem{}
+	}
+
+	jsonResponse(w, r, problems)
+}
+
 func (s HTTPService) GetRelease(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
 	id := mux.Vars(r)["id"]
-	job, err := s.service.GetRelease(inst, jobs.JobID(id))
+	var wait time.Duration
+	if param := r.URL.Query().Get("wait"); param != "" {
+		var err error
+		if wait, err = time.ParseDuration(param); err != nil || wait < 0 {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Errorf("invalid wait %q", param))
+			return
+		}
+	}
+	job, err := s.service.GetRelease(r.Context(), inst, jobs.JobID(id), wait)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -174,16 +272,21 @@ func (s HTTPService) GetRelease(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, r, job)
 }
 
-func (s HTTPService) Automate(w http.ResponseWriter, r *http.Request) {
+func (s HTTPService) ListFailedJobs(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	service := mux.Vars(r)["service"]
-	id, err := flux.ParseServiceID(service)
+	res, err := s.service.ListFailedJobs(r.Context(), inst)
 	if err != nil {
-		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", id))
+		errorResponse(w, r, err)
 		return
 	}
 
-	if err = s.service.Automate(inst, id); err != nil {
+	jsonResponse(w, r, res)
+}
+
+func (s HTTPService) RequeueJob(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	id := mux.Vars(r)["id"]
+	if err := s.service.RequeueJob(r.Context(), inst, jobs.JobID(id)); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -191,16 +294,45 @@ func (s HTTPService) Automate(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s HTTPService) Deautomate(w http.ResponseWriter, r *http.Request) {
+func (s HTTPService) AbandonJob(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	service := mux.Vars(r)["service"]
-	id, err := flux.ParseServiceID(service)
+	id := mux.Vars(r)["id"]
+	if err := s.service.AbandonJob(r.Context(), inst, jobs.JobID(id)); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) JobLog(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	id := mux.Vars(r)["id"]
+	lines, err := s.service.JobLog(r.Context(), inst, jobs.JobID(id))
 	if err != nil {
-		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", id))
+		errorResponse(w, r, err)
 		return
 	}
 
-	if err = s.service.Deautomate(inst, id); err != nil {
+	jsonResponse(w, r, lines)
+}
+
+func (s HTTPService) PurgeFailedJobs(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	n, err := s.service.PurgeFailedJobs(r.Context(), inst)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	jsonResponse(w, r, transport.PurgeFailedJobsResponse{
+		Purged: n,
+	})
+}
+
+func (s HTTPService) SyncNotify(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	if err := s.service.SyncNotify(r.Context(), inst); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -208,16 +340,77 @@ func (s HTTPService) Deautomate(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s HTTPService) Lock(w http.ResponseWriter, r *http.Request) {
+// setPolicy applies a policy change to the service given, or to each
+// of the services matched, if given a selector spec.
+func (s HTTPService) setPolicy(w http.ResponseWriter, r *http.Request, set func(context.Context, flux.InstanceID, flux.ServiceID) error) {
 	inst := getInstanceID(r)
 	service := mux.Vars(r)["service"]
-	id, err := flux.ParseServiceID(service)
+	spec, err := flux.ParseServiceSpec(service)
 	if err != nil {
-		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", id))
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", service))
 		return
 	}
 
-	if err = s.service.Lock(inst, id); err != nil {
+	var ids []flux.ServiceID
+	if selector, ok := spec.AsSelector(); ok {
+		services, err := s.service.ListServices(r.Context(), inst, "")
+		if err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+		for _, service := range services {
+			if selector.Matches(service.Labels) {
+				ids = append(ids, service.ID)
+			}
+		}
+		if len(ids) == 0 {
+			transport.WriteError(w, r, http.StatusNotFound, errors.Errorf("no services match %s", spec))
+			return
+		}
+	} else if id, err := spec.AsID(); err == nil {
+		ids = append(ids, id)
+	} else {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Errorf("expected a service ID or selector, got %q", service))
+		return
+	}
+
+	for _, id := range ids {
+		if err = set(r.Context(), inst, id); err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) Automate(w http.ResponseWriter, r *http.Request) {
+	s.setPolicy(w, r, s.service.Automate)
+}
+
+func (s HTTPService) Deautomate(w http.ResponseWriter, r *http.Request) {
+	s.setPolicy(w, r, s.service.Deautomate)
+}
+
+func (s HTTPService) Lock(w http.ResponseWriter, r *http.Request) {
+	s.setPolicy(w, r, s.service.Lock)
+}
+
+func (s HTTPService) SetMinReleaseInterval(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	vars := mux.Vars(r)
+	id, err := flux.ParseServiceID(vars["service"])
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", vars["service"]))
+		return
+	}
+	interval, err := time.ParseDuration(vars["interval"])
+	if err != nil || interval < 0 {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Errorf("invalid interval %q", vars["interval"]))
+		return
+	}
+
+	if err = s.service.SetMinReleaseInterval(r.Context(), inst, id, interval); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -225,16 +418,32 @@ func (s HTTPService) Lock(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s HTTPService) Unlock(w http.ResponseWriter, r *http.Request) {
+func (s HTTPService) SetTagFilter(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	service := mux.Vars(r)["service"]
-	id, err := flux.ParseServiceID(service)
+	vars := mux.Vars(r)
+	id, err := flux.ParseServiceID(vars["service"])
 	if err != nil {
-		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", id))
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", vars["service"]))
+		return
+	}
+	pattern := vars["pattern"]
+	if _, err := path.Match(pattern, ""); err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "invalid tag filter %q", pattern))
 		return
 	}
 
-	if err = s.service.Unlock(inst, id); err != nil {
+	if err = s.service.SetTagFilter(r.Context(), inst, id, pattern); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) Pause(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	vars := mux.Vars(r)
+	if err := s.service.Pause(r.Context(), inst, vars["user"], vars["reason"]); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -242,6 +451,20 @@ func (s HTTPService) Unlock(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s HTTPService) Resume(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	if err := s.service.Resume(r.Context(), inst, mux.Vars(r)["user"]); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) Unlock(w http.ResponseWriter, r *http.Request) {
+	s.setPolicy(w, r, s.service.Unlock)
+}
+
 func (s HTTPService) History(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
 	service := mux.Vars(r)["service"]
@@ -251,42 +474,164 @@ func (s HTTPService) History(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query, err := historyQuery(r)
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := s.service.History(r.Context(), inst, spec, query)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	h := page.Entries
+	if h == nil {
+		h = []flux.HistoryEntry{}
+	}
+	if r.FormValue("simple") == "true" {
+		// Remove all the individual event data, just return the timestamps and messages
+		for i := range h {
+			h[i].Event = nil
+		}
+	}
+
+	w.Header().Set(transport.HistoryTotalHeader, fmt.Sprint(page.Total))
+	jsonResponse(w, r, h)
+}
+
+func (s HTTPService) HistoryAllClusters(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	service := mux.Vars(r)["service"]
+	id, err := flux.ParseServiceID(service)
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", service))
+		return
+	}
+
+	before, limit, err := historyRange(r)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	h, err := s.service.HistoryAllClusters(r.Context(), inst, id, before, limit)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	if r.FormValue("simple") == "true" {
+		for i := range h {
+			h[i].Event = nil
+		}
+	}
+
+	jsonResponse(w, r, h)
+}
+
+// historyQuery gives the part of the history asked for, from the
+// optional parameters of a history request: `before`, a time or the
+// cursor of an entry, `after`, the cursor of an entry, `type`, a
+// comma-separated list of event types, and `limit`.
+func historyQuery(r *http.Request) (flux.HistoryQuery, error) {
+	query := flux.HistoryQuery{Before: time.Now().UTC(), Limit: -1}
+	if before := r.FormValue("before"); before != "" {
+		if t, err := time.Parse(time.RFC3339Nano, before); err == nil {
+			query.Before = t
+		} else {
+			query.BeforeCursor = before
+		}
+	}
+	query.AfterCursor = r.FormValue("after")
+	if types := r.FormValue("type"); types != "" {
+		query.Types = strings.Split(types, ",")
+	}
+	if r.FormValue("limit") != "" {
+		if _, err := fmt.Sscan(r.FormValue("limit"), &query.Limit); err != nil {
+			return query, errors.Wrapf(err, "parsing limit %q", r.FormValue("limit"))
+		}
+	}
+	return query, nil
+}
+
+// historyRange gives the time before which, and the number of, events
+// to return, from the optional parameters of a history request.
+func historyRange(r *http.Request) (time.Time, int64, error) {
 	before := time.Now().UTC()
 	if r.FormValue("before") != "" {
-		before, err = time.Parse(time.RFC3339Nano, r.FormValue("before"))
-		if err != nil {
-			errorResponse(w, r, err)
-			return
+		var err error
+		if before, err = time.Parse(time.RFC3339Nano, r.FormValue("before")); err != nil {
+			return before, 0, err
 		}
 	}
 	limit := int64(-1)
 	if r.FormValue("limit") != "" {
 		if _, err := fmt.Sscan(r.FormValue("limit"), &limit); err != nil {
-			errorResponse(w, r, err)
-			return
+			return before, 0, err
 		}
 	}
+	return before, limit, nil
+}
 
-	h, err := s.service.History(inst, spec, before, limit)
+func (s HTTPService) ReleaseHistory(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	service := mux.Vars(r)["service"]
+	id, err := flux.ParseServiceID(service)
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", service))
+		return
+	}
+
+	versions, err := s.service.ReleaseHistory(r.Context(), inst, id)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
 	}
 
-	if r.FormValue("simple") == "true" {
-		// Remove all the individual event data, just return the timestamps and messages
-		for i := range h {
-			h[i].Event = nil
-		}
+	jsonResponse(w, r, versions)
+}
+
+func (s HTTPService) ServiceChanges(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	service := mux.Vars(r)["service"]
+	id, err := flux.ParseServiceID(service)
+	if err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing service ID %q", service))
+		return
 	}
 
-	jsonResponse(w, r, h)
+	changes, err := s.service.ServiceChanges(r.Context(), inst, id)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	jsonResponse(w, r, changes)
+}
+
+func (s HTTPService) Lint(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	report, err := s.service.Lint(r.Context(), inst)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	jsonResponse(w, r, report)
+}
+
+func (s HTTPService) APIVersions(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, r, transport.APIVersionsResponse{
+		Versions: transport.APIVersions,
+	})
 }
 
 func (s HTTPService) GetConfig(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
 	fingerprint := r.FormValue("fingerprint")
-	config, err := s.service.GetConfig(inst, fingerprint)
+	config, err := s.service.GetConfig(r.Context(), inst, fingerprint)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -330,7 +675,24 @@ func (s HTTPService) SetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.service.SetConfig(inst, config); err != nil {
+	if err := s.service.SetConfig(r.Context(), inst, config); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) ValidateConfig(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+
+	var config flux.UnsafeInstanceConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.service.ValidateConfig(r.Context(), inst, config); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -347,7 +709,7 @@ func (s HTTPService) PatchConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.service.PatchConfig(inst, patch); err != nil {
+	if err := s.service.PatchConfig(r.Context(), inst, patch); err != nil {
 		errorResponse(w, r, err)
 		return
 	}
@@ -357,15 +719,64 @@ func (s HTTPService) PatchConfig(w http.ResponseWriter, r *http.Request) {
 
 func (s HTTPService) GenerateKeys(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	err := s.service.GenerateDeployKey(inst)
+	err := s.service.GenerateDeployKey(r.Context(), inst)
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) ListReleaseTemplates(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	templates, err := s.service.ListReleaseTemplates(r.Context(), inst)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
 	}
+	jsonResponse(w, r, templates)
+}
+
+func (s HTTPService) SetReleaseTemplate(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+
+	var template flux.ReleaseTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		transport.WriteError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.service.SetReleaseTemplate(r.Context(), inst, template); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s HTTPService) DeleteReleaseTemplate(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	if err := s.service.DeleteReleaseTemplate(r.Context(), inst, mux.Vars(r)["name"]); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s HTTPService) PostReleaseFromTemplate(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	id, err := s.service.PostReleaseFromTemplate(r.Context(), inst, mux.Vars(r)["name"], r.FormValue("user"))
+	if err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+	jsonResponse(w, r, transport.PostReleaseResponse{
+		Status:    "Queued.",
+		ReleaseID: id,
+	})
+}
+
 func (s HTTPService) PostIntegrationsGithub(w http.ResponseWriter, r *http.Request) {
 	var (
 		inst  = getInstanceID(r)
@@ -381,14 +792,14 @@ func (s HTTPService) PostIntegrationsGithub(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Generate deploy key
-	err := s.service.GenerateDeployKey(inst)
+	err := s.service.GenerateDeployKey(r.Context(), inst)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
 	}
 
 	// Obtain the generated key
-	cfg, err := s.service.GetConfig(inst, "")
+	cfg, err := s.service.GetConfig(r.Context(), inst, "")
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -411,12 +822,32 @@ func (s HTTPService) PostIntegrationsGithub(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Check whether flux will be able to push to the config branch,
+	// so that any problem shows up in the status. Not being able to
+	// check (e.g., because the token doesn't have admin rights to
+	// the repository) doesn't stop the integration.
+	branch := cfg.Git.Branch
+	if branch == "" {
+		branch = "master"
+	}
+	if conflicts, err := gh.BranchProtectionConflicts(owner, repo, branch); err == nil {
+		protection := flux.BranchProtection{
+			Branch:    branch,
+			Conflicts: conflicts,
+			CheckedAt: time.Now().UTC(),
+		}
+		if err := s.service.SetBranchProtection(r.Context(), inst, protection); err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
 func (s HTTPService) Status(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	status, err := s.service.Status(inst)
+	status, err := s.service.Status(r.Context(), inst)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -462,10 +893,17 @@ func (s HTTPService) doRegister(w http.ResponseWriter, r *http.Request, newRPCFn
 	// _client_.
 	rpcClient := newRPCFn(ws)
 
+	// When the server is shutting down, the daemon is told to go
+	// away, between calls.
+	calls := platform.NewInFlight(rpcClient)
+	done := make(chan struct{})
+	defer close(done)
+	watchDaemon(r.Context(), ws, calls, done)
+
 	// Make platform available to clients
 	// This should block until the daemon disconnects
 	// TODO: Handle the error here
-	s.service.RegisterDaemon(inst, rpcClient)
+	s.service.RegisterDaemon(inst, calls)
 
 	// Clean up
 	// TODO: Handle the error here
@@ -475,14 +913,20 @@ func (s HTTPService) doRegister(w http.ResponseWriter, r *http.Request, newRPCFn
 func (s HTTPService) IsConnected(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
 
-	err := s.service.IsDaemonConnected(inst)
+	err := s.service.IsDaemonConnected(r.Context(), inst)
 	if err == nil {
 		jsonResponse(w, r, flux.FluxdStatus{
 			Connected: true,
 		})
 		return
 	}
-	switch err.(type) {
+	switch err := err.(type) {
+	case platform.BreakerOpen:
+		jsonResponse(w, r, flux.FluxdStatus{
+			Connected:        false,
+			Unavailable:      true,
+			UnavailableUntil: &err.Until,
+		})
 	case flux.UserConfigProblem:
 		// NB this has a specific contract for "cannot contact" -> // "404 not found"
 		transport.WriteError(w, r, http.StatusNotFound, err)
@@ -501,7 +945,7 @@ func (s HTTPService) IsConnected(w http.ResponseWriter, r *http.Request) {
 
 func (s HTTPService) Export(w http.ResponseWriter, r *http.Request) {
 	inst := getInstanceID(r)
-	status, err := s.service.Export(inst)
+	status, err := s.service.Export(r.Context(), inst)
 	if err != nil {
 		errorResponse(w, r, err)
 		return
@@ -510,6 +954,132 @@ func (s HTTPService) Export(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, r, status)
 }
 
+// ExportStream sends the export as YAML, as it comes from the daemon,
+// compressed if the client accepts gzip. A request for a range gets
+// the whole export first, and is served like a file; since resources
+// are exported in a stable order, an interrupted download can be
+// resumed, so long as nothing has changed meanwhile (which the ETag
+// says).
+func (s HTTPService) ExportStream(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	if r.Header.Get("Range") != "" {
+		var config bytes.Buffer
+		if err := s.service.ExportTo(r.Context(), inst, &config); err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+		sum := sha256.Sum256(config.Bytes())
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(config.Bytes()))
+		return
+	}
+
+	ew := &exportWriter{w: w, gzip: acceptsGzip(r)}
+	err := s.service.ExportTo(r.Context(), inst, ew)
+	if !ew.started {
+		// Nothing has been sent yet, so the error can be the response
+		if err != nil {
+			errorResponse(w, r, err)
+			return
+		}
+		ew.start()
+	}
+	if closeErr := ew.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		w.Header().Set(transport.ExportErrorTrailer, err.Error())
+	}
+}
+
+// exportWriter sends the response to a streamed export, starting it
+// only once there's something to send, so that an error before then
+// can still be the response.
+type exportWriter struct {
+	w       http.ResponseWriter
+	gzip    bool
+	gz      *gzip.Writer
+	started bool
+}
+
+func (e *exportWriter) start() {
+	e.started = true
+	h := e.w.Header()
+	h.Set("Content-Type", "application/x-yaml")
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Trailer", transport.ExportErrorTrailer)
+	h.Add("Vary", "Accept-Encoding")
+	if e.gzip {
+		h.Set("Content-Encoding", "gzip")
+		e.gz = gzip.NewWriter(e.w)
+	}
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.start()
+	}
+	var (
+		n   int
+		err error
+	)
+	if e.gz != nil {
+		if n, err = e.gz.Write(p); err == nil {
+			err = e.gz.Flush()
+		}
+	} else {
+		n, err = e.w.Write(p)
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func (e *exportWriter) close() error {
+	if e.gz != nil {
+		return e.gz.Close()
+	}
+	return nil
+}
+
+// acceptsGzip says whether the client accepts gzip encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			coding = strings.TrimSpace(coding)
+			if i := strings.Index(coding, ";"); i >= 0 {
+				if q := strings.Replace(coding[i+1:], " ", "", -1); q == "q=0" || q == "q=0.0" {
+					continue
+				}
+				coding = strings.TrimSpace(coding[:i])
+			}
+			if coding == "gzip" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s HTTPService) Trace(w http.ResponseWriter, r *http.Request) {
+	inst := getInstanceID(r)
+	duration, err := time.ParseDuration(mux.Vars(r)["duration"])
+	if err != nil || duration < 0 {
+		transport.WriteError(w, r, http.StatusBadRequest, errors.Errorf("invalid duration %q", mux.Vars(r)["duration"]))
+		return
+	}
+
+	if err = s.service.Trace(r.Context(), inst, duration); err != nil {
+		errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // --- end handlers
 
 func logging(next http.Handler, logger log.Logger) http.Handler {
@@ -525,6 +1095,9 @@ func logging(next http.Handler, logger log.Logger) http.Handler {
 			"took", time.Since(begin).String(),
 			"status_code", cw.code,
 		)
+		if traceID := cw.Header().Get(tracing.TraceIDHeader); traceID != "" {
+			requestLogger = requestLogger.With("trace_id", traceID)
+		}
 		if cw.code != http.StatusOK {
 			requestLogger = requestLogger.With("error", strings.TrimSpace(tw.buf.String()))
 		}
@@ -532,6 +1105,22 @@ func logging(next http.Handler, logger log.Logger) http.Handler {
 	})
 }
 
+// traced gives each request a span, continuing the caller's trace if
+// the request has one, and says which trace in the response.
+func traced(next http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.ContextWithSpan(r.Context(), tracing.Extract(r.Header))
+		span, ctx := tracing.StartSpan(ctx, "http."+route)
+		defer span.Finish()
+		span.SetTag("instance", getInstanceID(r))
+		w.Header().Set(tracing.TraceIDHeader, span.TraceID)
+
+		cw := &codeWriter{w, http.StatusOK}
+		next.ServeHTTP(cw, r.WithContext(ctx))
+		span.SetTag("status_code", cw.code)
+	})
+}
+
 func getInstanceID(req *http.Request) flux.InstanceID {
 	s := req.Header.Get(flux.InstanceIDHeaderKey)
 	if s == "" {
@@ -556,6 +1145,16 @@ func errorResponse(w http.ResponseWriter, r *http.Request, apiError error) {
 	var outErr *flux.BaseError
 	var code int
 	err := errors.Cause(apiError)
+	if err == context.DeadlineExceeded {
+		transport.WriteError(w, r, http.StatusGatewayTimeout, &flux.BaseError{
+			Help: `The request timed out waiting for the daemon, or for git.
+
+Check that the daemon is connected, with 'fluxctl status', and try again.
+`,
+			Err: apiError,
+		})
+		return
+	}
 	switch err := err.(type) {
 	case flux.Missing:
 		code = http.StatusNotFound
@@ -587,6 +1186,12 @@ func (w *codeWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *codeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (w *codeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hj, ok := w.ResponseWriter.(http.Hijacker)
 	if !ok {
@@ -595,17 +1200,32 @@ func (w *codeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return hj.Hijack()
 }
 
-// teeWriter intercepts and stores the HTTP response.
+// teeWriter intercepts and stores the start of the HTTP response; it's
+// only logged if it's an error, so the rest (e.g., of an export)
+// needn't be kept.
 type teeWriter struct {
 	http.ResponseWriter
 	buf bytes.Buffer
 }
 
+const teeLimit = 4096
+
 func (w *teeWriter) Write(p []byte) (int, error) {
-	w.buf.Write(p) // best-effort
+	if room := teeLimit - w.buf.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.buf.Write(p[:room]) // best-effort
+	}
 	return w.ResponseWriter.Write(p)
 }
 
+func (w *teeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (w *teeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hj, ok := w.ResponseWriter.(http.Hijacker)
 	if !ok {
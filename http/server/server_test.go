@@ -0,0 +1,171 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api"
+	transport "github.com/weaveworks/flux/http"
+)
+
+// exportService only exports, with the func given.
+type exportService struct {
+	api.FluxService
+	export func(io.Writer) error
+}
+
+func (s exportService) ExportTo(_ context.Context, _ flux.InstanceID, w io.Writer) error {
+	return s.export(w)
+}
+
+const exportedConfig = "---\nkind: Namespace\nmetadata:\n  name: default\n---\nkind: Service\nmetadata:\n  name: helloworld\n"
+
+func exportRequest(t *testing.T, export func(io.Writer) error, header http.Header) *http.Response {
+	handler := NewHandler(exportService{export: export}, transport.NewRouter(), nil, nil, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/v6/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// Ask for it as it is, unless asked otherwise, so it's not
+	// decompressed behind our back
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	bytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+func TestExportStream(t *testing.T) {
+	export := func(w io.Writer) error {
+		// In pieces, as it comes from the daemon
+		for _, doc := range strings.SplitAfter(exportedConfig, "helloworld") {
+			if _, err := io.WriteString(w, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resp := exportRequest(t, export, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("expected unencoded 200 response, got %d with encoding %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	if body := readBody(t, resp); body != exportedConfig {
+		t.Errorf("expected export %q, got %q", exportedConfig, body)
+	}
+	if msg := resp.Trailer.Get(transport.ExportErrorTrailer); msg != "" {
+		t.Errorf("expected no error, got %q", msg)
+	}
+
+	resp = exportRequest(t, export, http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected gzip encoding, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if body := readBody(t, resp); body != exportedConfig {
+		t.Errorf("expected export %q, got %q", exportedConfig, body)
+	}
+}
+
+func TestExportStreamRange(t *testing.T) {
+	export := func(w io.Writer) error {
+		_, err := io.WriteString(w, exportedConfig)
+		return err
+	}
+	resp := exportRequest(t, export, http.Header{"Range": {"bytes=4-"}})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected 206 response, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == "" {
+		t.Error("expected ETag, got none")
+	}
+	if body := readBody(t, resp); body != exportedConfig[4:] {
+		t.Errorf("expected %q, got %q", exportedConfig[4:], body)
+	}
+}
+
+func TestExportStreamErrors(t *testing.T) {
+	resp := exportRequest(t, func(io.Writer) error {
+		return errors.New("daemon not connected")
+	}, nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(body, "daemon not connected") {
+		t.Errorf("expected 500 response with error, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp = exportRequest(t, func(w io.Writer) error {
+		io.WriteString(w, exportedConfig)
+		return errors.New("lost connection to daemon")
+	}, nil)
+	readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 response, once the export has begun, got %d", resp.StatusCode)
+	}
+	if msg := resp.Trailer.Get(transport.ExportErrorTrailer); msg != "lost connection to daemon" {
+		t.Errorf("expected error in trailer, got %q", msg)
+	}
+}
+
+// stuckService waits for requests to time out.
+type stuckService struct {
+	api.FluxService
+}
+
+func (stuckService) ListServices(ctx context.Context, _ flux.InstanceID, _ string) ([]flux.ServiceStatus, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeout(t *testing.T) {
+	routeTimeouts["ListServices"] = 10 * time.Millisecond
+	defer delete(routeTimeouts, "ListServices")
+
+	handler := NewHandler(stuckService{}, transport.NewRouter(), nil, nil, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v3/services?namespace=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 response, got %d: %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/weaveworks/flux/git"
+)
+
+// defaultRouteTimeout is how long a request is given, unless it's in
+// routeTimeouts. When it runs out, calls to the daemon give up and
+// the request gets a 504 Gateway Timeout.
+const defaultRouteTimeout = 30 * time.Second
+
+// routeTimeouts is how long requests to each route are given, when
+// that's different from defaultRouteTimeout. Zero means no limit.
+var routeTimeouts = map[string]time.Duration{
+	// These clone the config repo before doing anything else
+	"Status":         git.DefaultTimeout + defaultRouteTimeout,
+	"Lint":           git.DefaultTimeout + defaultRouteTimeout,
+	"ServiceChanges": git.DefaultTimeout + defaultRouteTimeout,
+	"ReleaseHistory": git.DefaultTimeout + defaultRouteTimeout,
+	"SetConfig":      git.DefaultTimeout + defaultRouteTimeout,
+	"ValidateConfig": git.DefaultTimeout + defaultRouteTimeout,
+	"PatchConfig":    git.DefaultTimeout + defaultRouteTimeout,
+	// Waits for the release to finish, up to a time given by the client
+	"GetRelease": 2 * time.Minute,
+	// Exports can be large, and are written out as they come
+	"Export":       10 * time.Minute,
+	"ExportStream": 10 * time.Minute,
+	// Daemons stay connected for as long as they can
+	"RegisterDaemonV4": 0,
+	"RegisterDaemonV5": 0,
+}
+
+func routeTimeout(method string) time.Duration {
+	if timeout, ok := routeTimeouts[method]; ok {
+		return timeout
+	}
+	return defaultRouteTimeout
+}
+
+// withTimeout gives requests a context that's done after the timeout,
+// or when the client goes away.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+// The API calls made by each instance are summarised over the last
+// day, for support people wanting to know whether an instance's
+// tooling is misbehaving (e.g., calling in a tight loop, or getting
+// errors). Prometheus has the same and more, but not by instance.
+const (
+	usageWindow = 24 * time.Hour
+	usageSlot   = time.Hour
+	usageSlots  = int(usageWindow / usageSlot)
+	// The most instance and route pairs kept track of. Without
+	// authentication, the instance is whatever the client says, so
+	// there could otherwise be any number.
+	maxUsageKeys = 10000
+)
+
+// The upper bounds of the latency buckets, from which the 95th
+// percentile is estimated; anything slower is in a last bucket, for
+// which the slowest call is given.
+var usageLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// DefaultUsage is where the API handler records calls, and what the
+// usage handler reports on, unless given another.
+var DefaultUsage = NewUsage()
+
+// Usage records API calls, by instance and route, for the usage
+// window.
+type Usage struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	routes    map[usageKey]*routeUsage
+	lastSweep time.Time
+}
+
+type usageKey struct {
+	instance flux.InstanceID
+	route    string
+}
+
+// routeUsage has a slot for each hour of the window, used in turn.
+type routeUsage [usageSlots]usageCounts
+
+type usageCounts struct {
+	start        time.Time
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+	latencies    []int64 // by bucket, with one over for slower calls
+	slowest      time.Duration
+}
+
+func NewUsage() *Usage {
+	return &Usage{now: time.Now, routes: map[usageKey]*routeUsage{}}
+}
+
+// Record records a call to the route given, by the instance, with
+// the status code it got and how long it took. Routes with no calls in
+// the window are forgotten as it goes; and if there are already
+// maxUsageKeys being kept track of, calls by new instances or to new
+// routes aren't recorded.
+func (u *Usage) Record(inst flux.InstanceID, route string, code int, took time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.now()
+	if now.Sub(u.lastSweep) > usageSlot {
+		u.sweep(now)
+	}
+	key := usageKey{inst, route}
+	r, ok := u.routes[key]
+	if !ok {
+		if len(u.routes) >= maxUsageKeys {
+			return
+		}
+		r = &routeUsage{}
+		u.routes[key] = r
+	}
+	start := now.Truncate(usageSlot)
+	c := &r[int(start.Unix()/int64(usageSlot/time.Second))%usageSlots]
+	if !c.start.Equal(start) {
+		*c = usageCounts{start: start, latencies: make([]int64, len(usageLatencyBuckets)+1)}
+	}
+	c.requests++
+	switch {
+	case code >= 500:
+		c.serverErrors++
+	case code >= 400:
+		c.clientErrors++
+	}
+	i := sort.Search(len(usageLatencyBuckets), func(i int) bool { return took <= usageLatencyBuckets[i] })
+	c.latencies[i]++
+	if took > c.slowest {
+		c.slowest = took
+	}
+}
+
+// windowStart gives the start of the window ending now. The slot for
+// the current hour is partly in the window.
+func windowStart(now time.Time) time.Time {
+	return now.Truncate(usageSlot).Add(usageSlot - usageWindow)
+}
+
+// sweep forgets the routes with no calls in the window.
+func (u *Usage) sweep(now time.Time) {
+	since := windowStart(now)
+	for key, r := range u.routes {
+		if !r.active(since) {
+			delete(u.routes, key)
+		}
+	}
+	u.lastSweep = now
+}
+
+func (r *routeUsage) active(since time.Time) bool {
+	for _, c := range r {
+		if c.requests > 0 && !c.start.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceUsage is the summary of an instance's API calls over the
+// window.
+type InstanceUsage struct {
+	ID           flux.InstanceID `json:"id"`
+	Requests     int64           `json:"requests"`
+	ClientErrors int64           `json:"clientErrors"`
+	ServerErrors int64           `json:"serverErrors"`
+	Routes       []RouteUsage    `json:"routes"`
+}
+
+// RouteUsage is the summary of the calls to one route. The error rate
+// counts both client and server errors; the 95th percentile latency
+// is the upper bound of the bucket it's in, so it's an overestimate.
+type RouteUsage struct {
+	Route        string  `json:"route"`
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"clientErrors"`
+	ServerErrors int64   `json:"serverErrors"`
+	ErrorRate    float64 `json:"errorRate"`
+	P95Seconds   float64 `json:"p95Seconds"`
+}
+
+// UsageSummary is the usage of the instances asked about, busiest
+// first, over the window from Since to Until.
+type UsageSummary struct {
+	Since     time.Time       `json:"since"`
+	Until     time.Time       `json:"until"`
+	Instances []InstanceUsage `json:"instances"`
+}
+
+// Summary summarises the usage over the window, of the instance
+// given, or if it's blank, of every instance that's made calls. Routes
+// with no calls in the window are forgotten.
+func (u *Usage) Summary(only flux.InstanceID) UsageSummary {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.now()
+	since := windowStart(now)
+
+	instances := map[flux.InstanceID]*InstanceUsage{}
+	for key, r := range u.routes {
+		route := RouteUsage{Route: key.route}
+		latencies := make([]int64, len(usageLatencyBuckets)+1)
+		var slowest time.Duration
+		for _, c := range r {
+			if c.start.Before(since) {
+				continue
+			}
+			route.Requests += c.requests
+			route.ClientErrors += c.clientErrors
+			route.ServerErrors += c.serverErrors
+			for i, n := range c.latencies {
+				latencies[i] += n
+			}
+			if c.slowest > slowest {
+				slowest = c.slowest
+			}
+		}
+		if route.Requests == 0 {
+			delete(u.routes, key)
+			continue
+		}
+		if only != "" && key.instance != only {
+			continue
+		}
+		route.ErrorRate = float64(route.ClientErrors+route.ServerErrors) / float64(route.Requests)
+		route.P95Seconds = percentile(latencies, route.Requests, 0.95, slowest).Seconds()
+
+		inst, ok := instances[key.instance]
+		if !ok {
+			inst = &InstanceUsage{ID: key.instance}
+			instances[key.instance] = inst
+		}
+		inst.Requests += route.Requests
+		inst.ClientErrors += route.ClientErrors
+		inst.ServerErrors += route.ServerErrors
+		inst.Routes = append(inst.Routes, route)
+	}
+
+	summary := UsageSummary{Since: since, Until: now, Instances: []InstanceUsage{}}
+	for _, inst := range instances {
+		sort.Sort(routesByRequests(inst.Routes))
+		summary.Instances = append(summary.Instances, *inst)
+	}
+	sort.Sort(instancesByRequests(summary.Instances))
+	return summary
+}
+
+// percentile gives the upper bound of the latency bucket the quantile
+// falls in, or the slowest call if it's beyond the last bucket.
+func percentile(latencies []int64, total int64, q float64, slowest time.Duration) time.Duration {
+	rank := int64(q * float64(total))
+	var seen int64
+	for i, n := range latencies[:len(usageLatencyBuckets)] {
+		seen += n
+		if seen > rank || seen == total {
+			return usageLatencyBuckets[i]
+		}
+	}
+	return slowest
+}
+
+type routesByRequests []RouteUsage
+
+func (rs routesByRequests) Len() int      { return len(rs) }
+func (rs routesByRequests) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs routesByRequests) Less(i, j int) bool {
+	if rs[i].Requests != rs[j].Requests {
+		return rs[i].Requests > rs[j].Requests
+	}
+	return rs[i].Route < rs[j].Route
+}
+
+type instancesByRequests []InstanceUsage
+
+func (is instancesByRequests) Len() int      { return len(is) }
+func (is instancesByRequests) Swap(i, j int) { is[i], is[j] = is[j], is[i] }
+func (is instancesByRequests) Less(i, j int) bool {
+	if is[i].Requests != is[j].Requests {
+		return is[i].Requests > is[j].Requests
+	}
+	return is[i].ID < is[j].ID
+}
+
+// recordUsage records each call to the route in DefaultUsage. It goes
+// inside authenticate, so that only calls by the instance a token is
+// for are recorded; calls to public routes, when requests are
+// authenticated, aren't.
+func recordUsage(next http.Handler, auth Authenticator, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		cw := &codeWriter{w, http.StatusOK}
+		next.ServeHTTP(cw, r)
+		if inst, ok := authenticatedInstance(r, auth); ok {
+			DefaultUsage.Record(inst, route, cw.code, time.Since(begin))
+		}
+	})
+}
+
+// NewUsageHandler serves the summary of API usage over the last day,
+// for the instance given in the parameter `instance`, or for all
+// instances (up to `limit` of them, busiest first). Like the list of
+// instances, it's an operational endpoint.
+func NewUsageHandler(u *Usage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		params := r.URL.Query()
+		summary := u.Summary(flux.InstanceID(params.Get("instance")))
+		if param := params.Get("limit"); param != "" {
+			limit, err := strconv.Atoi(param)
+			if err != nil || limit < 0 {
+				transport.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid limit parameter %q", param))
+				return
+			}
+			if limit < len(summary.Instances) {
+				summary.Instances = summary.Instances[:limit]
+			}
+		}
+		jsonResponse(w, r, summary)
+	})
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	transport "github.com/weaveworks/flux/http"
+)
+
+func TestUsageSummary(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 30, 0, 0, time.UTC)
+	u := NewUsage()
+	u.now = func() time.Time { return now }
+
+	// Two days ago; should be forgotten
+	now = now.Add(-48 * time.Hour)
+	u.Record("old", "ListServices", 200, time.Millisecond)
+	now = now.Add(48 * time.Hour)
+
+	for i := 0; i < 100; i++ {
+		code, took := 200, 20*time.Millisecond
+		if i < 10 {
+			code = 500
+		} else if i < 30 {
+			code = 404
+		}
+		if i == 99 {
+			took = 2 * time.Minute
+		}
+		u.Record("busy", "ListServices", code, took)
+	}
+	// Earlier in the window
+	now = now.Add(-20 * time.Hour)
+	u.Record("busy", "Export", 200, 3*time.Second)
+	u.Record("quiet", "Export", 200, time.Millisecond)
+	now = now.Add(20 * time.Hour)
+
+	summary := u.Summary("")
+	if len(summary.Instances) != 2 {
+		t.Fatalf("expected two instances, got %+v", summary.Instances)
+	}
+	busy := summary.Instances[0]
+	if busy.ID != "busy" || busy.Requests != 101 || busy.ClientErrors != 20 || busy.ServerErrors != 10 {
+		t.Errorf("unexpected totals for busy instance: %+v", busy)
+	}
+	if len(busy.Routes) != 2 || busy.Routes[0].Route != "ListServices" {
+		t.Fatalf("expected ListServices then Export, got %+v", busy.Routes)
+	}
+	list := busy.Routes[0]
+	if list.ErrorRate != 0.3 {
+		t.Errorf("expected error rate 0.3, got %v", list.ErrorRate)
+	}
+	if list.P95Seconds != 0.025 {
+		t.Errorf("expected p95 of 0.025s, got %v", list.P95Seconds)
+	}
+	if export := busy.Routes[1]; export.P95Seconds != 5 {
+		t.Errorf("expected p95 of 5s, got %v", export.P95Seconds)
+	}
+	if _, ok := u.routes[usageKey{"old", "ListServices"}]; ok {
+		t.Error("expected usage outside the window to be forgotten")
+	}
+
+	quiet := u.Summary("quiet")
+	if len(quiet.Instances) != 1 || quiet.Instances[0].ID != "quiet" {
+		t.Errorf("expected only the quiet instance, got %+v", quiet.Instances)
+	}
+}
+
+func TestUsagePercentileBeyondBuckets(t *testing.T) {
+	u := NewUsage()
+	u.Record("slow", "Export", 200, 90*time.Second)
+	route := u.Summary("").Instances[0].Routes[0]
+	if route.P95Seconds != 90 {
+		t.Errorf("expected the slowest call as p95, got %v", route.P95Seconds)
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	u := NewUsage()
+	u.Record("a", "Export", 200, time.Millisecond)
+	u.Record("b", "Export", 200, time.Millisecond)
+	u.Record("b", "Export", 200, time.Millisecond)
+	handler := NewUsageHandler(u)
+
+	for _, example := range []struct {
+		query     string
+		code      int
+		instances []string
+	}{
+		{"", http.StatusOK, []string{"b", "a"}},
+		{"?limit=1", http.StatusOK, []string{"b"}},
+		{"?instance=a", http.StatusOK, []string{"a"}},
+		{"?limit=x", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/usage"+example.query, nil))
+		if rec.Code != example.code {
+			t.Errorf("%q: expected status %d, got %d", example.query, example.code, rec.Code)
+			continue
+		}
+		if example.code != http.StatusOK {
+			continue
+		}
+		var summary UsageSummary
+		if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, inst := range summary.Instances {
+			got = append(got, string(inst.ID))
+		}
+		if len(got) != len(example.instances) {
+			t.Errorf("%q: expected %v, got %v", example.query, example.instances, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != example.instances[i] {
+				t.Errorf("%q: expected %v, got %v", example.query, example.instances, got)
+				break
+			}
+		}
+	}
+}
+
+func TestUsageRecordForgets(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 30, 0, 0, time.UTC)
+	u := NewUsage()
+	u.now = func() time.Time { return now }
+
+	u.Record("gone", "ListServices", 200, time.Millisecond)
+	now = now.Add(48 * time.Hour)
+	u.Record("here", "ListServices", 200, time.Millisecond)
+	if _, ok := u.routes[usageKey{"gone", "ListServices"}]; ok {
+		t.Error("expected usage outside the window to be forgotten when recording")
+	}
+
+	for i := 0; len(u.routes) < maxUsageKeys; i++ {
+		u.Record(flux.InstanceID(fmt.Sprintf("inst-%d", i)), "ListServices", 200, time.Millisecond)
+	}
+	u.Record("one-too-many", "ListServices", 200, time.Millisecond)
+	if len(u.routes) != maxUsageKeys {
+		t.Errorf("expected at most %d kept track of, got %d", maxUsageKeys, len(u.routes))
+	}
+	u.Record("here", "ListServices", 200, time.Millisecond)
+	if here := u.Summary("here"); len(here.Instances) != 1 || here.Instances[0].Requests != 2 {
+		t.Errorf("expected calls by an instance already kept track of to be recorded, got %+v", here.Instances)
+	}
+}
+
+func TestUsageOnlyAuthenticated(t *testing.T) {
+	tokens := StaticTokens{"tok": {Instance: "usage-inst", Scopes: []Scope{ScopeRead}}}
+	handler := NewHandler(exportService{}, transport.NewRouter(), tokens, nil, nil, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, inst := range []string{"usage-inst", "usage-made-up"} {
+		req, err := http.NewRequest("GET", server.URL+"/v3/status", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(flux.InstanceIDHeaderKey, inst)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readBody(t, resp)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected %d without a token, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+	for _, inst := range []flux.InstanceID{"usage-inst", "usage-made-up"} {
+		if summary := DefaultUsage.Summary(inst); len(summary.Instances) != 0 {
+			t.Errorf("expected calls without a token not to be recorded, got %+v", summary.Instances)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -14,6 +15,10 @@ import (
 	"github.com/weaveworks/flux/jobs"
 )
 
+// APIVersions are the versions of the API served, oldest first. The
+// last is the version a client built from this code expects.
+var APIVersions = []string{"v3", "v4", "v5", "v6"}
+
 func NewRouter() *mux.Router {
 	r := mux.NewRouter()
 	// Any versions not represented in the routes below are
@@ -28,22 +33,48 @@ func NewRouter() *mux.Router {
 	r.NewRoute().Name("ListServices").Methods("GET").Path("/v3/services").Queries("namespace", "{namespace}") // optional namespace!
 	r.NewRoute().Name("ListImages").Methods("GET").Path("/v3/images").Queries("service", "{service}")
 	r.NewRoute().Name("PostRelease").Methods("POST").Path("/v4/release").Queries("service", "{service}", "image", "{image}", "kind", "{kind}")
+	r.NewRoute().Name("ValidateRelease").Methods("POST").Path("/v6/release/validate")
 	r.NewRoute().Name("GetRelease").Methods("GET").Path("/v4/release").Queries("id", "{id}")
+	r.NewRoute().Name("SyncNotify").Methods("POST").Path("/v5/sync/notify")
 	r.NewRoute().Name("Automate").Methods("POST").Path("/v3/automate").Queries("service", "{service}")
 	r.NewRoute().Name("Deautomate").Methods("POST").Path("/v3/deautomate").Queries("service", "{service}")
 	r.NewRoute().Name("Lock").Methods("POST").Path("/v3/lock").Queries("service", "{service}")
 	r.NewRoute().Name("Unlock").Methods("POST").Path("/v3/unlock").Queries("service", "{service}")
+	r.NewRoute().Name("SetMinReleaseInterval").Methods("POST").Path("/v6/min-release-interval").Queries("service", "{service}", "interval", "{interval}")
+	r.NewRoute().Name("SetTagFilter").Methods("POST").Path("/v6/tag-filter").Queries("service", "{service}", "pattern", "{pattern}")
+	r.NewRoute().Name("Pause").Methods("POST").Path("/v6/automation/pause").Queries("user", "{user}", "reason", "{reason}")
+	r.NewRoute().Name("Resume").Methods("POST").Path("/v6/automation/resume").Queries("user", "{user}")
 	r.NewRoute().Name("History").Methods("GET").Path("/v3/history").Queries("service", "{service}")
+	r.NewRoute().Name("HistoryAllClusters").Methods("GET").Path("/v6/history/clusters").Queries("service", "{service}")
+	r.NewRoute().Name("ReleaseHistory").Methods("GET").Path("/v5/history/releases").Queries("service", "{service}")
+	r.NewRoute().Name("ServiceChanges").Methods("GET").Path("/v6/history/changes").Queries("service", "{service}")
+	r.NewRoute().Name("Lint").Methods("GET").Path("/v5/lint")
 	r.NewRoute().Name("Status").Methods("GET").Path("/v3/status")
 	r.NewRoute().Name("GetConfig").Methods("GET").Path("/v4/config")
 	r.NewRoute().Name("SetConfig").Methods("POST").Path("/v4/config")
+	r.NewRoute().Name("ValidateConfig").Methods("POST").Path("/v6/config/validate")
 	r.NewRoute().Name("PatchConfig").Methods("PATCH").Path("/v4/config")
 	r.NewRoute().Name("GenerateDeployKeys").Methods("POST").Path("/v5/config/deploy-keys")
+	r.NewRoute().Name("GitWebhook").Methods("POST").Path("/v6/integrations/git/webhook")
 	r.NewRoute().Name("PostIntegrationsGithub").Methods("POST").Path("/v5/integrations/github").Queries("owner", "{owner}", "repository", "{repository}")
+	r.NewRoute().Name("ListFailedJobs").Methods("GET").Path("/v5/jobs/failed")
+	r.NewRoute().Name("PurgeFailedJobs").Methods("DELETE").Path("/v5/jobs/failed")
+	r.NewRoute().Name("JobLog").Methods("GET").Path("/v6/jobs/{id}/log")
+	r.NewRoute().Name("RequeueJob").Methods("POST").Path("/v5/jobs/requeue").Queries("id", "{id}")
+	r.NewRoute().Name("AbandonJob").Methods("POST").Path("/v6/jobs/abandon").Queries("id", "{id}")
 	r.NewRoute().Name("RegisterDaemonV4").Methods("GET").Path("/v4/daemon")
 	r.NewRoute().Name("RegisterDaemonV5").Methods("GET").Path("/v5/daemon")
+	r.NewRoute().Name("Trace").Methods("POST").Path("/v6/daemon/trace").Queries("duration", "{duration}")
+	r.NewRoute().Name("ListReleaseTemplates").Methods("GET").Path("/v6/release-templates")
+	r.NewRoute().Name("SetReleaseTemplate").Methods("POST").Path("/v6/release-templates")
+	r.NewRoute().Name("DeleteReleaseTemplate").Methods("DELETE").Path("/v6/release-templates/{name}")
+	r.NewRoute().Name("PostReleaseFromTemplate").Methods("POST").Path("/v6/release-templates/{name}/release") // optional user
+	r.NewRoute().Name("ReleaseTemplateWebhook").Methods("POST").Path("/v6/release-templates/{name}/webhook")
 	r.NewRoute().Name("IsConnected").Methods("HEAD", "GET").Path("/v4/ping")
 	r.NewRoute().Name("Export").Methods("HEAD", "GET").Path("/v5/export")
+	r.NewRoute().Name("ExportStream").Methods("HEAD", "GET").Path("/v6/export")
+	r.NewRoute().Name("APIVersions").Methods("GET").Path("/versions")
+	r.NewRoute().Name("OpenAPI").Methods("GET").Path("/v6/openapi.json")
 
 	// We assume every request that doesn't match a route is a client
 	// calling an old or hitherto unsupported API.
@@ -54,11 +85,51 @@ func NewRouter() *mux.Router {
 	return r
 }
 
+// ExportErrorTrailer is the trailer of a streamed export that says
+// what went wrong, if the export failed after it had begun to be
+// sent, and so after the status was sent.
+const ExportErrorTrailer = "X-Flux-Export-Error"
+
+// HistoryTotalHeader is the response header giving the total number of
+// history entries of the kind asked for, on every page.
+const HistoryTotalHeader = "X-Total-Count"
+
 type PostReleaseResponse struct {
 	Status    string     `json:"status"`
 	ReleaseID jobs.JobID `json:"release_id"`
 }
 
+type PurgeFailedJobsResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+type APIVersionsResponse struct {
+	Versions []string `json:"versions"`
+}
+
+// ParseAPIVersion gives the number of an API version like "v5".
+func ParseAPIVersion(version string) (int, error) {
+	var n int
+	if _, err := fmt.Sscanf(version, "v%d", &n); err != nil {
+		return 0, errors.Errorf("invalid API version %q; expected e.g., %q", version, APIVersions[len(APIVersions)-1])
+	}
+	return n, nil
+}
+
+// RouteVersion gives the API version of the named route, which is
+// the first element of its path. Routes that aren't versioned give 0.
+func RouteVersion(router *mux.Router, routeName string) (int, error) {
+	routeURL, err := router.Get(routeName).URL()
+	if err != nil {
+		return 0, errors.Wrapf(err, "retrieving route path %s", routeName)
+	}
+	first := strings.SplitN(strings.TrimPrefix(routeURL.Path, "/"), "/", 2)[0]
+	if n, err := ParseAPIVersion(first); err == nil {
+		return n, nil
+	}
+	return 0, nil
+}
+
 func MakeURL(endpoint string, router *mux.Router, routeName string, urlParams ...string) (*url.URL, error) {
 	if len(urlParams)%2 != 0 {
 		panic("urlParams must be even!")
@@ -69,13 +140,21 @@ func MakeURL(endpoint string, router *mux.Router, routeName string, urlParams ..
 		return nil, errors.Wrapf(err, "parsing endpoint %s", endpoint)
 	}
 
-	routeURL, err := router.Get(routeName).URL()
+	route := router.Get(routeName)
+	routeURL, err := route.URL(urlParams...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "retrieving route path %s", routeName)
 	}
 
+	// Parameters that are variables in the route's path are used up
+	// there -- they're the ones the path can't be made without. The
+	// rest go in the query.
 	v := url.Values{}
 	for i := 0; i < len(urlParams); i += 2 {
+		others := append(append([]string{}, urlParams[:i]...), urlParams[i+2:]...)
+		if _, err := route.URL(others...); err != nil {
+			continue
+		}
 		v.Add(urlParams[i], urlParams[i+1])
 	}
 
@@ -3,9 +3,12 @@ package websocket
 import (
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/flux/chaos"
 )
 
 const (
@@ -31,6 +34,7 @@ type pingingWebsocket struct {
 	writeLock sync.Mutex
 	reader    io.Reader
 	conn      *websocket.Conn
+	goneAway  int32 // atomic
 }
 
 // Ping adds a periodic ping to a websocket connection.
@@ -64,6 +68,9 @@ func (p *pingingWebsocket) Read(b []byte) (int, error) {
 	for p.reader == nil {
 		msgType, r, err := p.conn.NextReader()
 		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				atomic.StoreInt32(&p.goneAway, 1)
+			}
 			if IsExpectedWSCloseError(err) {
 				return 0, io.EOF
 			}
@@ -88,6 +95,10 @@ func (p *pingingWebsocket) Read(b []byte) (int, error) {
 func (p *pingingWebsocket) Write(b []byte) (int, error) {
 	p.writeLock.Lock()
 	defer p.writeLock.Unlock()
+	if err := chaos.Inject(chaos.WebsocketWrite); err != nil {
+		// Drop the frame, as a flaky connection might
+		return len(b), nil
+	}
 	if err := p.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 		return 0, err
 	}
@@ -103,10 +114,22 @@ func (p *pingingWebsocket) Write(b []byte) (int, error) {
 }
 
 func (p *pingingWebsocket) Close() error {
+	return p.closeWith(websocket.CloseNormalClosure, "ok")
+}
+
+func (p *pingingWebsocket) GoAway(reason string) error {
+	return p.closeWith(websocket.CloseGoingAway, reason)
+}
+
+func (p *pingingWebsocket) GoneAway() bool {
+	return atomic.LoadInt32(&p.goneAway) == 1
+}
+
+func (p *pingingWebsocket) closeWith(code int, text string) error {
 	p.writeLock.Lock()
 	defer p.writeLock.Unlock()
 	p.pinger.Stop()
-	if err := p.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "ok"), time.Now().Add(writeWait)); err != nil {
+	if err := p.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeWait)); err != nil {
 		p.conn.Close()
 		return err
 	}
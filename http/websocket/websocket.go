@@ -13,6 +13,13 @@ type Websocket interface {
 	io.Reader
 	io.Writer
 	Close() error
+	// GoAway closes the connection, telling the peer it's because
+	// this end is going away (e.g., shutting down), so it should
+	// connect again, to somewhere else.
+	GoAway(reason string) error
+	// GoneAway says whether the peer closed the connection because it
+	// was going away.
+	GoneAway() bool
 }
 
 // IsExpectedWSCloseError returns boolean indicating whether the error is a
@@ -83,3 +83,35 @@ func TestByteStream(t *testing.T) {
 		t.Fatalf("did not collect message as expected, got %s", buf.String())
 	}
 }
+
+func TestGoAway(t *testing.T) {
+	for _, goAway := range []bool{true, false} {
+		upgrade := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := Upgrade(w, r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if goAway {
+				ws.GoAway("shutting down")
+			} else {
+				ws.Close()
+			}
+		})
+		srv := httptest.NewServer(upgrade)
+
+		url, _ := url.Parse(srv.URL)
+		url.Scheme = "ws"
+		ws, err := Dial(http.DefaultClient, "fluxd/test", flux.Token(""), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("expected EOF once the server has closed the connection, got %v", err)
+		}
+		if ws.GoneAway() != goAway {
+			t.Errorf("expected GoneAway to be %v", goAway)
+		}
+		ws.Close()
+		srv.Close()
+	}
+}
@@ -126,6 +126,31 @@ type Image struct {
 	CreatedAt *time.Time `json:",omitempty"`
 }
 
+// DefaultArchitecture is the architecture a cluster's nodes are taken
+// to have, if it's not given.
+const DefaultArchitecture = "amd64"
+
+// ImagePlatform is one of the images in a multi-arch image (i.e., one
+// whose tag refers to a manifest list), built for a particular
+// platform.
+type ImagePlatform struct {
+	OS           string
+	Architecture string
+	Variant      string `json:",omitempty"`
+	Digest       string
+}
+
+// PlatformFor gives the image built for the architecture given, if
+// there's one for Linux among the platforms.
+func PlatformFor(platforms []ImagePlatform, arch string) (ImagePlatform, bool) {
+	for _, p := range platforms {
+		if p.OS == "linux" && p.Architecture == arch {
+			return p, true
+		}
+	}
+	return ImagePlatform{}, false
+}
+
 func ParseImage(s string, createdAt *time.Time) (Image, error) {
 	id, err := ParseImageID(s)
 	if err != nil {
@@ -1,12 +1,21 @@
 package instance
 
 import (
+	"time"
+
 	"github.com/weaveworks/flux"
 )
 
 type ServiceConfig struct {
 	Automated bool `json:"automation"`
 	Locked    bool `json:"locked"`
+	// MinReleaseInterval, if not zero, stops automation releasing the
+	// service again until that long after the last release.
+	MinReleaseInterval time.Duration `json:"minReleaseInterval,omitempty"`
+	// TagFilter, if not blank, is a glob pattern (as for path.Match)
+	// that the tag of an image must match for automation to release
+	// it.
+	TagFilter string `json:"tagFilter,omitempty"`
 }
 
 func (c ServiceConfig) Policy() flux.Policy {
@@ -22,6 +31,20 @@ func (c ServiceConfig) Policy() flux.Policy {
 type Config struct {
 	Services map[flux.ServiceID]ServiceConfig `json:"services"`
 	Settings flux.UnsafeInstanceConfig        `json:"settings"`
+	// BranchProtection is what was found when the GitHub
+	// integration last checked the config branch, if it has.
+	BranchProtection *flux.BranchProtection `json:"branchProtection,omitempty"`
+	// Paused, if not nil, stops all automation of the instance until
+	// it's resumed.
+	Paused *flux.Pause `json:"paused,omitempty"`
+	// LastSync is when automation last read the config repo
+	// successfully; and SyncStaleWarned whether it's been warned
+	// that it's been too long since.
+	LastSync        *flux.LastSync `json:"lastSync,omitempty"`
+	SyncStaleWarned bool           `json:"syncStaleWarned,omitempty"`
+	// ReleaseTemplates are the release specs saved for releasing
+	// from by name, keyed by name.
+	ReleaseTemplates map[string]flux.ReleaseTemplate `json:"releaseTemplates,omitempty"`
 }
 
 type NamedConfig struct {
@@ -0,0 +1,50 @@
+package instance
+
+import (
+	"github.com/weaveworks/flux"
+)
+
+type defaultingDB struct {
+	DB
+	defaults flux.UnsafeInstanceConfig
+}
+
+// DefaultingDB gives instances' configs with the service-wide defaults
+// filled in for any fields they don't set. Inherited values are not
+// stored with the instance's config, unless they are changed; so,
+// removing a field from an instance's config resets it to the default.
+func DefaultingDB(db DB, defaults flux.UnsafeInstanceConfig) DB {
+	return &defaultingDB{db, defaults}
+}
+
+func (d *defaultingDB) GetConfig(inst flux.InstanceID) (Config, error) {
+	config, err := d.DB.GetConfig(inst)
+	if err != nil {
+		return config, err
+	}
+	config.Settings = config.Settings.WithDefaults(d.defaults)
+	return config, nil
+}
+
+func (d *defaultingDB) UpdateConfig(inst flux.InstanceID, update UpdateFunc) error {
+	return d.DB.UpdateConfig(inst, func(config Config) (Config, error) {
+		config.Settings = config.Settings.WithDefaults(d.defaults)
+		config, err := update(config)
+		if err != nil {
+			return config, err
+		}
+		config.Settings = config.Settings.WithoutDefaults(d.defaults)
+		return config, nil
+	})
+}
+
+func (d *defaultingDB) All() ([]NamedConfig, error) {
+	configs, err := d.DB.All()
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		configs[i].Config.Settings = configs[i].Config.Settings.WithDefaults(d.defaults)
+	}
+	return configs, nil
+}
@@ -8,11 +8,17 @@ import (
 )
 
 type EventReadWriter struct {
-	inst flux.InstanceID
-	db   history.DB
+	inst    flux.InstanceID
+	cluster string
+	db      history.DB
 }
 
+// LogEvent records the event, with the name of the instance's cluster
+// if it's not already been given one.
 func (rw EventReadWriter) LogEvent(e flux.Event) error {
+	if e.Cluster == "" {
+		e.Cluster = rw.cluster
+	}
 	return rw.db.LogEvent(rw.inst, e)
 }
 
@@ -24,6 +30,14 @@ func (rw EventReadWriter) EventsForService(service flux.ServiceID, before time.T
 	return rw.db.EventsForService(rw.inst, service, before, limit)
 }
 
+func (rw EventReadWriter) Events(q history.EventQuery) ([]flux.Event, error) {
+	return rw.db.Events(rw.inst, q)
+}
+
+func (rw EventReadWriter) CountEvents(q history.EventQuery) (int64, error) {
+	return rw.db.CountEvents(rw.inst, q)
+}
+
 func (rw EventReadWriter) GetEvent(id flux.EventID) (flux.Event, error) {
 	return rw.db.GetEvent(id)
 }
@@ -2,6 +2,8 @@ package instance
 
 import (
 	"fmt"
+	"io"
+	"path"
 	"strings"
 	"time"
 
@@ -20,11 +22,20 @@ type Instancer interface {
 	Get(inst flux.InstanceID) (*Instance, error)
 }
 
+// RepoChecker is implemented by instancers that can check, before
+// an instance's config is saved, that its config repo can be reached.
+type RepoChecker interface {
+	CheckRepo(flux.InstanceID, flux.UnsafeInstanceConfig) error
+}
+
 type Instance struct {
 	Platform platform.Platform
 	Registry registry.Registry
 	Config   Configurer
 	Repo     git.Repo
+	// Trust, if not nil, checks the signatures of images from the
+	// registries it has keys for.
+	Trust *registry.Notary
 
 	log.Logger
 	history.EventReader
@@ -69,6 +80,25 @@ func (m ImageMap) LatestImage(repo string) *flux.ImageDescription {
 	return nil
 }
 
+// LatestImageMatching returns the latest releasable image for a
+// repository whose tag matches the glob pattern given. A blank
+// pattern matches any tag.
+func (m ImageMap) LatestImageMatching(repo, pattern string) *flux.ImageDescription {
+	if pattern == "" {
+		return m.LatestImage(repo)
+	}
+	for _, image := range m[repo] {
+		_, _, tag := image.ID.Components()
+		if strings.EqualFold(tag, "latest") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, tag); ok {
+			return &image
+		}
+	}
+	return nil
+}
+
 func (h *Instance) ConfigRepo() git.Repo {
 	return h.Repo
 }
@@ -90,6 +120,22 @@ func (h *Instance) GetServices(ids []flux.ServiceID) ([]platform.Service, error)
 	return h.Platform.SomeServices(ids)
 }
 
+// Get the services, in any namespace, with the labels the selector
+// gives.
+func (h *Instance) GetServicesMatching(selector flux.LabelSelector) ([]platform.Service, error) {
+	all, err := h.Platform.AllServices("", flux.ServiceIDSet{})
+	if err != nil {
+		return nil, err
+	}
+	var res []platform.Service
+	for _, service := range all {
+		if selector.Matches(service.Labels) {
+			res = append(res, service)
+		}
+	}
+	return res, nil
+}
+
 // Get the images available for the services given. An image may be
 // mentioned more than once in the services, but will only be fetched
 // once.
@@ -131,6 +177,106 @@ func (h *Instance) CollectAvailableImages(services []platform.Service) (ImageMap
 	return images, nil
 }
 
+// CheckSignatures fills in the digest of each of the images given from
+// the repository, and whether it is signed by a trusted key, if there
+// is trust configured for the repository's registry.
+func (h *Instance) CheckSignatures(repo string, images []flux.ImageDescription) error {
+	r, err := registry.ParseRepository(repo)
+	if err != nil {
+		return err
+	}
+	if h.Trust == nil || !h.Trust.Trusts(r.Host()) {
+		return nil
+	}
+	signed, err := h.Trust.SignedTargets(r)
+	if err != nil {
+		return err
+	}
+	for i, image := range images {
+		digest, err := h.Registry.GetImageDigest(r, image.ID.Tag)
+		if err != nil {
+			return errors.Wrapf(err, "getting digest of %s", image.ID)
+		}
+		ok := signed[image.ID.Tag] == digest
+		images[i].Digest = digest
+		images[i].Signed = &ok
+	}
+	return nil
+}
+
+// CheckPlatforms fills in the platforms of the newest of the images
+// given from the repository (which come newest first), if it's a
+// multi-arch image. It's only the newest, since that's the one that
+// would be released; looking up every tag would mean a request each.
+func (h *Instance) CheckPlatforms(repo string, images []flux.ImageDescription) error {
+	if len(images) == 0 {
+		return nil
+	}
+	r, err := registry.ParseRepository(repo)
+	if err != nil {
+		return err
+	}
+	platforms, err := h.Registry.GetImagePlatforms(r, images[0].ID.Tag)
+	if err != nil {
+		return errors.Wrapf(err, "getting platforms of %s", images[0].ID)
+	}
+	images[0].Platforms = platforms
+	return nil
+}
+
+// OnlySigned removes, from the images of each repository whose
+// registry has automation restricted to signed images, those that are
+// not signed by a trusted key. Only the tags that are signed are
+// looked up in the registry, to compare digests. If the signatures or
+// a digest can't be got, all the repository's images are removed
+// (and the error logged), rather than holding up the automation of
+// other repositories.
+func (h *Instance) OnlySigned(images ImageMap) error {
+	if h.Trust == nil {
+		return nil
+	}
+	for repo, available := range images {
+		r, err := registry.ParseRepository(repo)
+		if err != nil {
+			return err
+		}
+		if !h.Trust.SignedOnly(r.Host()) {
+			continue
+		}
+		signed, err := h.onlySigned(r, available)
+		if err != nil {
+			h.Log("repository", repo, "err", err)
+		}
+		images[repo] = signed
+	}
+	return nil
+}
+
+func (h *Instance) onlySigned(r registry.Repository, available []flux.ImageDescription) ([]flux.ImageDescription, error) {
+	targets, err := h.Trust.SignedTargets(r)
+	if err != nil {
+		return nil, err
+	}
+	var signed []flux.ImageDescription
+	for _, image := range available {
+		target, ok := targets[image.ID.Tag]
+		if !ok {
+			continue
+		}
+		digest, err := h.Registry.GetImageDigest(r, image.ID.Tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting digest of %s", image.ID)
+		}
+		if digest != target {
+			continue
+		}
+		image.Digest = digest
+		image.Signed = &ok
+		signed = append(signed, image)
+	}
+	return signed, nil
+}
+
 // GetRepository exposes this instance's registry's GetRepository method directly.
 func (h *Instance) GetRepository(repo string) (res []flux.ImageDescription, err error) {
 	r, err := registry.ParseRepository(repo)
@@ -189,6 +335,26 @@ func (h *Instance) imageExists(imageID flux.ImageID) (bool, error) {
 	return true, nil
 }
 
+// ImageDigest gives the content digest of the image, which identifies
+// it exactly even if its tag is moved.
+func (h *Instance) ImageDigest(imageID flux.ImageID) (string, error) {
+	img, err := flux.ParseImage(imageID.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	return h.Registry.GetImageDigest(registry.RepositoryFromImage(img), img.Tag)
+}
+
+// ImagePlatforms gives the platforms of a multi-arch image, or none if
+// the image isn't multi-arch.
+func (h *Instance) ImagePlatforms(imageID flux.ImageID) ([]flux.ImagePlatform, error) {
+	img, err := flux.ParseImage(imageID.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.Registry.GetImagePlatforms(registry.RepositoryFromImage(img), img.Tag)
+}
+
 func (h *Instance) PlatformApply(defs []platform.ServiceDefinition) (err error) {
 	defer func(begin time.Time) {
 		releaseHelperDuration.With(
@@ -200,6 +366,19 @@ func (h *Instance) PlatformApply(defs []platform.ServiceDefinition) (err error)
 	return h.Platform.Apply(defs)
 }
 
+// PlatformSync performs the actions given on the platform, e.g., to
+// delete resources.
+func (h *Instance) PlatformSync(def platform.SyncDef) (err error) {
+	defer func(begin time.Time) {
+		releaseHelperDuration.With(
+			fluxmetrics.LabelMethod, "PlatformSync",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return h.Platform.Sync(def)
+}
+
 func (h *Instance) Ping() error {
 	return h.Platform.Ping()
 }
@@ -219,3 +398,7 @@ func (h *Instance) UpdateConfig(update UpdateFunc) error {
 func (h *Instance) Export() ([]byte, error) {
 	return h.Platform.Export()
 }
+
+func (h *Instance) ExportTo(w io.Writer) error {
+	return h.Platform.ExportTo(w)
+}
@@ -1,9 +1,19 @@
 package instance
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/registry"
-	"testing"
 )
 
 var (
@@ -47,3 +57,92 @@ func TestInstance_ErrWhenBlank(t *testing.T) {
 		t.Fatal("Was expecting error")
 	}
 }
+
+func TestImageMap_LatestImageMatching(t *testing.T) {
+	var images []flux.ImageDescription
+	for _, s := range []string{"owner/repo:latest", "owner/repo:debug", "owner/repo:master-a000002", "owner/repo:master-a000001"} {
+		id, _ := flux.ParseImageID(s)
+		images = append(images, flux.ImageDescription{ID: id})
+	}
+	m := ImageMap{"owner/repo": images}
+
+	for pattern, expected := range map[string]string{
+		"":         "debug",
+		"master-*": "master-a000002",
+		"v*":       "",
+	} {
+		var tag string
+		if latest := m.LatestImageMatching("owner/repo", pattern); latest != nil {
+			tag = latest.ID.Tag
+		}
+		if tag != expected {
+			t.Errorf("pattern %q: expected %q, got %q", pattern, expected, tag)
+		}
+	}
+}
+
+// digestRegistry fails the test if any digest is looked up.
+type digestRegistry struct {
+	registry.Registry
+	t *testing.T
+}
+
+func (r digestRegistry) GetImageDigest(repo registry.Repository, tag string) (string, error) {
+	r.t.Errorf("unexpected digest lookup for %s:%s", repo, tag)
+	return "", nil
+}
+
+func TestInstance_OnlySigned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+
+	// Nothing's signed at all in one registry; the other's Notary
+	// server is broken.
+	unsigned := httptest.NewServer(http.NotFoundHandler())
+	defer unsigned.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	var config flux.UnsafeInstanceConfig
+	config.Registry.Trust = map[string]flux.Trust{
+		"quay.io":    {Server: unsigned.URL, Keys: keys, SignedOnly: true},
+		"gcr.io":     {Server: broken.URL, Keys: keys, SignedOnly: true},
+		"example.io": {Server: broken.URL, Keys: keys},
+	}
+	notary, err := registry.NewNotary(config, registry.Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := Instance{
+		Registry: digestRegistry{t: t},
+		Trust:    notary,
+		Logger:   log.NewNopLogger(),
+	}
+
+	images := ImageMap{}
+	for _, repo := range []string{"quay.io/test/app", "gcr.io/test/app", "example.io/test/app"} {
+		id, _ := flux.ParseImageID(repo + ":v1")
+		images[repo] = []flux.ImageDescription{{ID: id}}
+	}
+	if err := i.OnlySigned(images); err != nil {
+		t.Fatal(err)
+	}
+	for repo, expected := range map[string]int{
+		"quay.io/test/app":    0,
+		"gcr.io/test/app":     0,
+		"example.io/test/app": 1,
+	} {
+		if len(images[repo]) != expected {
+			t.Errorf("%s: expected %d images, got %+v", repo, expected, images[repo])
+		}
+	}
+}
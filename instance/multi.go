@@ -1,6 +1,8 @@
 package instance
 
 import (
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -11,6 +13,7 @@ import (
 	"github.com/weaveworks/flux/history"
 	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/secrets"
 )
 
 type MultitenantInstancer struct {
@@ -20,6 +23,40 @@ type MultitenantInstancer struct {
 	History             history.DB
 	MemcacheClient      registry.MemcacheClient
 	RegistryCacheExpiry time.Duration
+	// Secrets, if not nil, is where to look for deploy keys before
+	// looking in the config.
+	Secrets secrets.Store
+	// GitMirror, if not nil, keeps mirrors of the config repos, so
+	// they needn't be cloned from scratch for each operation.
+	GitMirror *git.Mirror
+	// GitQueue, if not nil, serialises commits and pushes to each
+	// config repo branch.
+	GitQueue *git.Queue
+	// GitPushRetries is how many times to rebase and push again when
+	// a push is rejected because the branch has moved on.
+	GitPushRetries int
+	// GitTimeout is how long each operation on a config repo may
+	// take; git.DefaultTimeout if zero.
+	GitTimeout time.Duration
+	// KnownHosts, in the format of an ssh known_hosts file, are host
+	// keys trusted for every instance's config repo, along with any
+	// in the instance's own config.
+	KnownHosts string
+
+	pullMu sync.Mutex
+	pulled map[flux.InstanceID]*pulledCredentials
+}
+
+// pullCredentialsTTL is how long registry credentials got from an
+// instance's daemon are used before they're got again.
+const pullCredentialsTTL = 5 * time.Minute
+
+// pulledCredentials are the registry credentials an instance's daemon
+// found in its image pull secrets.
+type pulledCredentials struct {
+	auths    map[string]flux.Auth
+	fetched  time.Time
+	fetching bool
 }
 
 func (m *MultitenantInstancer) Get(instanceID flux.InstanceID) (*Instance, error) {
@@ -37,27 +74,45 @@ func (m *MultitenantInstancer) Get(instanceID flux.InstanceID) (*Instance, error
 	// Logger specialised to this instance
 	instanceLogger := log.NewContext(m.Logger).With("instanceID", instanceID)
 
-	// Registry client with instance's config
-	creds, err := registry.CredentialsFromConfig(c.Settings)
+	// Registry client with instance's config, and with the
+	// credentials the daemon has found, for registries the config
+	// doesn't have credentials for
+	creds, err := registry.CredentialsFromConfig(withPulledCredentials(c.Settings, m.pullCredentials(instanceID, platform, instanceLogger)))
 	if err != nil {
 		return nil, errors.Wrap(err, "decoding registry credentials")
 	}
 	registryLogger := log.NewContext(instanceLogger).With("component", "registry")
-	reg := registry.NewRegistry(
-		registry.NewRemoteClientFactory(creds, registryLogger, m.MemcacheClient, m.RegistryCacheExpiry),
+	var index registry.CreatedIndex
+	if m.MemcacheClient != nil {
+		index = registry.NewMemcacheCreatedIndex(m.MemcacheClient, c.Settings.ClusterArchitecture())
+	}
+	reg := registry.NewIndexedRegistry(
+		registry.NewRemoteClientFactory(creds, c.Settings.HTTP, registryLogger, m.MemcacheClient, m.RegistryCacheExpiry),
+		index,
+		c.Settings.ClusterArchitecture(),
 		registryLogger,
 	)
 	reg = registry.NewInstrumentedRegistry(reg)
 
-	repo := gitRepoFromSettings(c.Settings)
+	repo, err := m.configRepo(instanceID, c.Settings)
+	if err != nil {
+		return nil, err
+	}
+	repo.Mirror = m.GitMirror
+	repo.Queue = m.GitQueue
+	repo.PushRetries = m.GitPushRetries
 
 	// Events for this instance
-	eventRW := EventReadWriter{instanceID, m.History}
+	cluster := c.Settings.Cluster
+	if cluster == "" {
+		cluster = string(instanceID)
+	}
+	eventRW := EventReadWriter{inst: instanceID, cluster: cluster, db: m.History}
 
 	// Configuration for this instance
 	config := configurer{instanceID, m.DB}
 
-	return New(
+	inst := New(
 		platform,
 		reg,
 		config,
@@ -65,18 +120,149 @@ func (m *MultitenantInstancer) Get(instanceID flux.InstanceID) (*Instance, error
 		instanceLogger,
 		eventRW,
 		eventRW,
-	), nil
+	)
+	// Say so, if the branch had to be created, since otherwise it
+	// would appear from nowhere
+	inst.Repo.BranchCreated = func(branch, revision string) {
+		now := time.Now().UTC()
+		if err := inst.LogEvent(flux.Event{
+			Type:      flux.EventBranch,
+			StartedAt: now,
+			EndedAt:   now,
+			LogLevel:  flux.LogLevelInfo,
+			Metadata:  flux.BranchEventMetadata{Branch: branch, Revision: revision},
+		}); err != nil {
+			instanceLogger.Log("branch", branch, "err", err)
+		}
+	}
+	if len(c.Settings.Registry.Trust) > 0 {
+		if inst.Trust, err = registry.NewNotary(c.Settings, creds); err != nil {
+			return nil, errors.Wrap(err, "decoding registry trust")
+		}
+	}
+	return inst, nil
 }
 
-func gitRepoFromSettings(settings flux.UnsafeInstanceConfig) git.Repo {
+// pullCredentials gives the registry credentials the instance's daemon
+// last gave, and if they're out of date, gets them again in the
+// background (so that a daemon that's slow to answer, or doesn't know
+// how, doesn't hold things up).
+func (m *MultitenantInstancer) pullCredentials(instanceID flux.InstanceID, p platform.Platform, logger log.Logger) map[string]flux.Auth {
+	m.pullMu.Lock()
+	defer m.pullMu.Unlock()
+	if m.pulled == nil {
+		m.pulled = map[flux.InstanceID]*pulledCredentials{}
+	}
+	pulled, ok := m.pulled[instanceID]
+	if !ok {
+		pulled = &pulledCredentials{}
+		m.pulled[instanceID] = pulled
+	}
+	if !pulled.fetching && time.Since(pulled.fetched) > pullCredentialsTTL {
+		pulled.fetching = true
+		go func() {
+			auths, err := p.RegistryCredentials()
+			if err != nil {
+				logger.Log("registry-credentials", "daemon", "err", err)
+			}
+			m.pullMu.Lock()
+			defer m.pullMu.Unlock()
+			pulled.auths = auths
+			pulled.fetched = time.Now()
+			pulled.fetching = false
+		}()
+	}
+	return pulled.auths
+}
+
+// withPulledCredentials gives the settings with the credentials
+// pulled from the daemon added, for the registries the settings
+// don't already have credentials for.
+func withPulledCredentials(settings flux.UnsafeInstanceConfig, pulled map[string]flux.Auth) flux.UnsafeInstanceConfig {
+	if len(pulled) == 0 {
+		return settings
+	}
+	auths := map[string]flux.Auth{}
+	for host, auth := range pulled {
+		auths[host] = auth
+	}
+	for host, auth := range settings.Registry.Auths {
+		auths[host] = auth
+	}
+	settings.Registry.Auths = auths
+	return settings
+}
+
+// CheckRepo checks that the config repo in the settings given can be
+// cloned, with the deploy key the instance would use. A branch that
+// doesn't exist is fine if the settings say to create it.
+func (m *MultitenantInstancer) CheckRepo(instanceID flux.InstanceID, settings flux.UnsafeInstanceConfig) error {
+	if settings.Git.URL == "" {
+		return nil
+	}
+	repo, err := m.configRepo(instanceID, settings)
+	if err != nil {
+		return err
+	}
+	// Clone afresh, and don't create the branch; this is only a check
+	repo.CreateBranch = false
+	path, err := repo.Clone()
+	if err != nil {
+		if git.KindOf(err) == git.RefNotFound && settings.Git.CreateBranch && !settings.Git.ReadOnly {
+			return nil
+		}
+		return err
+	}
+	return git.Clean(path)
+}
+
+// configRepo gives the config repo for the settings, as it would be
+// for operations other than cloning from the mirror.
+func (m *MultitenantInstancer) configRepo(instanceID flux.InstanceID, settings flux.UnsafeInstanceConfig) (git.Repo, error) {
+	key, err := deployKey(m.Secrets, instanceID, settings)
+	if err != nil {
+		return git.Repo{}, errors.Wrap(err, "getting deploy key")
+	}
+	passphrase, err := deployKeyPassphrase(m.Secrets, instanceID, settings)
+	if err != nil {
+		return git.Repo{}, errors.Wrap(err, "getting deploy key passphrase")
+	}
+	repo := gitRepoFromSettings(settings, key)
+	repo.KeyPassphrase = passphrase
+	repo.Timeout = m.GitTimeout
+	if m.KnownHosts != "" {
+		repo.KnownHosts = strings.TrimRight(m.KnownHosts, "\n") + "\n" + repo.KnownHosts
+	}
+	return repo, nil
+}
+
+func gitRepoFromSettings(settings flux.UnsafeInstanceConfig, key string) git.Repo {
 	branch := settings.Git.Branch
 	if branch == "" {
 		branch = "master"
 	}
 	return git.Repo{
-		URL:    settings.Git.URL,
-		Branch: branch,
-		Key:    settings.Git.Key,
-		Path:   settings.Git.Path,
+		URL:          settings.Git.URL,
+		Branch:       branch,
+		Key:          key,
+		User:         settings.Git.User,
+		Token:        settings.Git.Token,
+		Path:         settings.Git.Path,
+		KnownHosts:   settings.Git.KnownHosts,
+		SSH:          GitSSHOptions(settings.Git.SSH),
+		SigningKey:   settings.Git.SigningKey,
+		Submodules:   settings.Git.Submodules,
+		CreateBranch: settings.Git.CreateBranch,
+		Sparse:       settings.Git.Sparse,
+		SkipLFS:      settings.Git.SkipLFS,
+		UserAgent:    settings.HTTP.UserAgent,
+		Headers:      settings.HTTP.Headers,
+		ReadOnly:     settings.Git.ReadOnly,
 	}
 }
+
+// GitSSHOptions gives the options for connecting to an SSH remote, as
+// given in an instance's config.
+func GitSSHOptions(c flux.GitSSHConfig) git.SSHOptions {
+	return git.SSHOptions{Port: c.Port, JumpHost: c.JumpHost, Options: c.Options}
+}
@@ -0,0 +1,47 @@
+package instance
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform"
+)
+
+func TestPullCredentials(t *testing.T) {
+	pulled := map[string]flux.Auth{
+		"quay.io":   {Auth: "cHVsbGVkOnNlY3JldA=="},
+		"gcr.io":    {Auth: "cHVsbGVkOnNlY3JldA=="},
+		"docker.io": {Auth: "cHVsbGVkOnNlY3JldA=="},
+	}
+	m := &MultitenantInstancer{}
+	p := &platform.MockPlatform{RegistryCredentialsAnswer: pulled}
+
+	// The first time, they're fetched in the background
+	if auths := m.pullCredentials("instance", p, log.NewNopLogger()); auths != nil {
+		t.Errorf("expected no credentials before they're fetched, got %+v", auths)
+	}
+	var auths map[string]flux.Auth
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if auths = m.pullCredentials("instance", p, log.NewNopLogger()); auths != nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(auths, pulled) {
+		t.Fatalf("expected the daemon's credentials, got %+v", auths)
+	}
+
+	// Those in the config win
+	var settings flux.UnsafeInstanceConfig
+	settings.Registry.Auths = map[string]flux.Auth{"quay.io": {Auth: "Y29uZmlnOnNlY3JldA=="}}
+	merged := withPulledCredentials(settings, auths)
+	if len(merged.Registry.Auths) != 3 || merged.Registry.Auths["quay.io"] != settings.Registry.Auths["quay.io"] {
+		t.Errorf("expected the config's credentials along with the daemon's, got %+v", merged.Registry.Auths)
+	}
+	if len(settings.Registry.Auths) != 1 {
+		t.Error("expected the config's own credentials to be left alone")
+	}
+}
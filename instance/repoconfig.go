@@ -0,0 +1,79 @@
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+)
+
+// RepoConfigFile is the name of the file, at the top of the config
+// path in the repo, that config is read from when the instance is set
+// up to keep config in git.
+const RepoConfigFile = ".flux.yaml"
+
+// RepoConfig is the part of the instance config that can be kept in
+// the config repo. Each part given replaces what's in the instance
+// config; parts left out are left as they are.
+type RepoConfig struct {
+	Slack      *flux.NotifierConfig                 `yaml:"slack"`
+	Namespaces []string                             `yaml:"namespaces"`
+	Services   map[flux.ServiceID]RepoServiceConfig `yaml:"services"`
+}
+
+type RepoServiceConfig struct {
+	TagFilter string `yaml:"tagFilter"`
+}
+
+// ReadRepoConfig reads the config file in the directory given. If
+// there's no such file, it returns nil.
+func ReadRepoConfig(dir string) (*RepoConfig, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, RepoConfigFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var conf RepoConfig
+	if err := yaml.Unmarshal(bytes, &conf); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", RepoConfigFile)
+	}
+	for id, service := range conf.Services {
+		if _, err := path.Match(service.TagFilter, ""); err != nil {
+			return nil, errors.Wrapf(err, "%s: tag filter for %s", RepoConfigFile, id)
+		}
+	}
+	return &conf, nil
+}
+
+// Apply gives the config with the parts from the repo in place. If
+// services are given, the tag filters of other services are removed,
+// so that the file says what all the tag filters are.
+func (rc RepoConfig) Apply(c Config) Config {
+	if rc.Slack != nil {
+		c.Settings.Slack = *rc.Slack
+	}
+	if rc.Namespaces != nil {
+		c.Settings.Namespaces = rc.Namespaces
+	}
+	if rc.Services != nil {
+		services := map[flux.ServiceID]ServiceConfig{}
+		for id, service := range c.Services {
+			service.TagFilter = ""
+			services[id] = service
+		}
+		for id, repoService := range rc.Services {
+			service := services[id]
+			service.TagFilter = repoService.TagFilter
+			services[id] = service
+		}
+		c.Services = services
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+const repoConfig = `slack:
+  hookURL: https://hooks.slack.com/services/abc
+  username: flux
+namespaces: [default, prod]
+services:
+  default/helloworld:
+    tagFilter: master-*
+`
+
+func TestRepoConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-repoconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if conf, err := ReadRepoConfig(dir); conf != nil || err != nil {
+		t.Fatalf("expected nothing without a file, got %v, %v", conf, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(repoConfig), 0666); err != nil {
+		t.Fatal(err)
+	}
+	repoConf, err := ReadRepoConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helloworld := flux.ServiceID("default/helloworld")
+	other := flux.ServiceID("default/other")
+	conf := MakeConfig()
+	conf.Settings.Git.URL = "git@github.com:example/config"
+	conf.Services[helloworld] = ServiceConfig{Automated: true}
+	conf.Services[other] = ServiceConfig{Locked: true, TagFilter: "v*"}
+
+	conf = repoConf.Apply(conf)
+	if conf.Settings.Slack.HookURL != "https://hooks.slack.com/services/abc" || conf.Settings.Slack.Username != "flux" {
+		t.Errorf("expected Slack settings from the repo, got %+v", conf.Settings.Slack)
+	}
+	if !reflect.DeepEqual([]string{"default", "prod"}, conf.Settings.Namespaces) {
+		t.Errorf("expected namespaces from the repo, got %v", conf.Settings.Namespaces)
+	}
+	if conf.Settings.Git.URL != "git@github.com:example/config" {
+		t.Errorf("expected other settings to be left alone, got %+v", conf.Settings.Git)
+	}
+	expected := map[flux.ServiceID]ServiceConfig{
+		helloworld: {Automated: true, TagFilter: "master-*"},
+		other:      {Locked: true},
+	}
+	if !reflect.DeepEqual(expected, conf.Services) {
+		t.Errorf("expected services %+v, got %+v", expected, conf.Services)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, RepoConfigFile), []byte("services:\n  default/helloworld:\n    tagFilter: '['\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRepoConfig(dir); err == nil {
+		t.Error("expected error for invalid tag filter")
+	}
+}
@@ -0,0 +1,124 @@
+package instance
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/secrets"
+)
+
+type secretStoringDB struct {
+	DB
+	store secrets.Store
+}
+
+// SecretStoringDB moves any private deploy key (and its passphrase)
+// given in config updates into the secret store, and records only the
+// public key in the config; so, reading the config never gives the
+// private key.
+func SecretStoringDB(db DB, store secrets.Store) DB {
+	return &secretStoringDB{db, store}
+}
+
+func (s *secretStoringDB) UpdateConfig(inst flux.InstanceID, update UpdateFunc) error {
+	return s.DB.UpdateConfig(inst, func(config Config) (Config, error) {
+		config, err := update(config)
+		if err != nil {
+			return config, err
+		}
+		git := config.Settings.Git
+		// Check the key can be read before storing anything, so a
+		// wrong passphrase doesn't replace the right one.
+		_, err = ssh.ParseRawPrivateKey([]byte(git.Key))
+		// If it's not a private key, it's most likely the public
+		// key we put there before.
+		privateKey := err == nil || isPassphraseMissing(err)
+		var publicKey string
+		if privateKey {
+			if publicKey, err = s.publicKey(inst, git, isPassphraseMissing(err)); err != nil {
+				return config, err
+			}
+		}
+		if git.HasKeyPassphrase() {
+			if err := s.store.Set(inst, secrets.DeployKeyPassphrase, []byte(git.KeyPassphrase)); err != nil {
+				return config, errors.Wrap(err, "storing deploy key passphrase")
+			}
+			config.Settings.Git.KeyPassphrase = git.HideKey().KeyPassphrase
+		}
+		if !privateKey {
+			return config, nil
+		}
+		if err := s.store.Set(inst, secrets.DeployKey, []byte(git.Key)); err != nil {
+			return config, errors.Wrap(err, "storing deploy key")
+		}
+		config.Settings.Git.Key = publicKey
+		return config, nil
+	})
+}
+
+// publicKey gives the public key of the deploy key, to show in the
+// config in its place. The passphrase is needed to find the public
+// key of an encrypted key; if the config has only the placeholder for
+// it, it's the one stored before.
+func (s *secretStoringDB) publicKey(inst flux.InstanceID, git flux.GitConfig, encrypted bool) (string, error) {
+	if !encrypted {
+		return git.HideKey().Key, nil
+	}
+	if !git.HasKeyPassphrase() {
+		if git.KeyPassphrase == "" {
+			return "", errors.New("deploy key is encrypted, but no passphrase was given")
+		}
+		passphrase, err := s.store.Get(inst, secrets.DeployKeyPassphrase)
+		if err != nil {
+			return "", errors.Wrap(err, "getting deploy key passphrase")
+		}
+		git.KeyPassphrase = string(passphrase)
+	}
+	publicKey := git.HideKey().Key
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err != nil {
+		return "", errors.New("deploy key could not be decrypted with the passphrase given")
+	}
+	return publicKey, nil
+}
+
+func isPassphraseMissing(err error) bool {
+	_, ok := err.(*ssh.PassphraseMissingError)
+	return ok
+}
+
+// deployKey gets the private key for the config repo: from the secret
+// store, if there is one and it has the key, otherwise from the
+// config.
+func deployKey(store secrets.Store, inst flux.InstanceID, settings flux.UnsafeInstanceConfig) (string, error) {
+	if store == nil {
+		return settings.Git.Key, nil
+	}
+	key, err := store.Get(inst, secrets.DeployKey)
+	switch err {
+	case nil:
+		return string(key), nil
+	case secrets.ErrNotFound:
+		return settings.Git.Key, nil
+	default:
+		return "", err
+	}
+}
+
+// deployKeyPassphrase gets the passphrase for the deploy key, if it
+// has one: from the secret store, if there is one and it has the
+// passphrase, otherwise from the config.
+func deployKeyPassphrase(store secrets.Store, inst flux.InstanceID, settings flux.UnsafeInstanceConfig) (string, error) {
+	if store == nil || settings.Git.HasKeyPassphrase() {
+		return settings.Git.KeyPassphrase, nil
+	}
+	passphrase, err := store.Get(inst, secrets.DeployKeyPassphrase)
+	switch err {
+	case nil:
+		return string(passphrase), nil
+	case secrets.ErrNotFound:
+		return settings.Git.KeyPassphrase, nil
+	default:
+		return "", err
+	}
+}
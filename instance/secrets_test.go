@@ -0,0 +1,157 @@
+package instance
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/secrets"
+)
+
+// memDB keeps configs in memory.
+type memDB struct {
+	DB
+	configs map[flux.InstanceID]Config
+}
+
+func (db *memDB) UpdateConfig(inst flux.InstanceID, update UpdateFunc) error {
+	config, err := update(db.configs[inst])
+	if err != nil {
+		return err
+	}
+	db.configs[inst] = config
+	return nil
+}
+
+func (db *memDB) GetConfig(inst flux.InstanceID) (Config, error) {
+	return db.configs[inst], nil
+}
+
+// memStore keeps secrets in memory.
+type memStore map[string][]byte
+
+func (s memStore) Get(inst flux.InstanceID, name string) ([]byte, error) {
+	value, ok := s[string(inst)+"/"+name]
+	if !ok {
+		return nil, secrets.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s memStore) Set(inst flux.InstanceID, name string, value []byte) error {
+	s[string(inst)+"/"+name] = value
+	return nil
+}
+
+// privateKey makes a private key, and the same key encrypted with the
+// passphrase given.
+func privateKey(t *testing.T, passphrase string) (plain, encrypted string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", der, []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})), string(pem.EncodeToMemory(block))
+}
+
+func setGit(db DB, inst flux.InstanceID, key, passphrase string) error {
+	return db.UpdateConfig(inst, func(c Config) (Config, error) {
+		c.Settings.Git.Key = key
+		c.Settings.Git.KeyPassphrase = passphrase
+		return c, nil
+	})
+}
+
+func TestSecretStoringDB(t *testing.T) {
+	const inst = flux.InstanceID("instance")
+	store := memStore{}
+	db := SecretStoringDB(&memDB{configs: map[flux.InstanceID]Config{}}, store)
+	plain, _ := privateKey(t, "")
+
+	if err := setGit(db, inst, plain, ""); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := db.GetConfig(inst)
+	if !strings.HasPrefix(config.Settings.Git.Key, "ssh-rsa ") {
+		t.Errorf("expected the public key in the config, got %q", config.Settings.Git.Key)
+	}
+	key, err := deployKey(store, inst, config.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != plain {
+		t.Errorf("expected the private key from the store, got %q", key)
+	}
+
+	// Other updates leave the key where it is
+	if err := db.UpdateConfig(inst, func(c Config) (Config, error) {
+		c.Settings.Git.Branch = "other"
+		return c, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := deployKey(store, inst, config.Settings); key != plain {
+		t.Errorf("expected the private key to be left in the store, got %q", key)
+	}
+}
+
+func TestSecretStoringDBEncryptedKey(t *testing.T) {
+	const inst = flux.InstanceID("instance")
+	store := memStore{}
+	db := SecretStoringDB(&memDB{configs: map[flux.InstanceID]Config{}}, store)
+	_, encrypted := privateKey(t, "open sesame")
+
+	if err := setGit(db, inst, encrypted, "open sesame"); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := db.GetConfig(inst)
+	git := config.Settings.Git
+	if git.HasKeyPassphrase() || git.KeyPassphrase == "" {
+		t.Errorf("expected the placeholder for the passphrase in the config, got %q", git.KeyPassphrase)
+	}
+	if !strings.HasPrefix(git.Key, "ssh-rsa ") {
+		t.Errorf("expected the public key in the config, got %q", git.Key)
+	}
+	passphrase, err := deployKeyPassphrase(store, inst, config.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passphrase != "open sesame" {
+		t.Errorf("expected the passphrase from the store, got %q", passphrase)
+	}
+
+	// A new key with the same passphrase, given as the placeholder
+	_, another := privateKey(t, "open sesame")
+	if err := setGit(db, inst, another, git.KeyPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	config, _ = db.GetConfig(inst)
+	if !strings.HasPrefix(config.Settings.Git.Key, "ssh-rsa ") || config.Settings.Git.Key == git.Key {
+		t.Errorf("expected the new public key in the config, got %q", config.Settings.Git.Key)
+	}
+	if key, _ := deployKey(store, inst, config.Settings); key != another {
+		t.Errorf("expected the new private key in the store, got %q", key)
+	}
+
+	// Without a passphrase, or with the wrong one, the key's refused
+	if err := setGit(db, inst, encrypted, ""); err == nil {
+		t.Error("expected an error for an encrypted key without a passphrase")
+	}
+	if err := setGit(db, inst, encrypted, "open barley"); err == nil {
+		t.Error("expected an error for an encrypted key with the wrong passphrase")
+	}
+	if key, _ := deployKey(store, inst, config.Settings); key != another {
+		t.Errorf("expected the key in the store to be left as it was, got %q", key)
+	}
+	if passphrase, _ := deployKeyPassphrase(store, inst, config.Settings); passphrase != "open sesame" {
+		t.Errorf("expected the passphrase in the store to be left as it was, got %q", passphrase)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/weaveworks/flux/http/httperror"
 	"golang.org/x/oauth2"
 	"net/http"
+	"net/url"
 )
 
 var (
@@ -37,6 +38,19 @@ func NewGithubClient(token string) *github {
 	}
 }
 
+// NewGithubEnterpriseClient instantiates a client for a GitHub
+// Enterprise server, given the URL of its API (usually
+// https://<host>/api/v3/).
+func NewGithubEnterpriseClient(token, apiURL string) (*github, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	g := NewGithubClient(token)
+	g.client.BaseURL = u
+	return g, nil
+}
+
 // InsertDeployKey will create a new deploy key for the given owner,
 // repo, token using the key deployKey.
 // If a key already exists with that name it will be deleted.
@@ -69,6 +83,52 @@ func (g *github) InsertDeployKey(ownerName string, repoName string, deployKey st
 	return nil
 }
 
+// BranchProtectionConflicts checks the protection rules of the given
+// branch, and explains each of those that will stop flux pushing
+// commits to it. A branch that isn't protected has no conflicts.
+func (g *github) BranchProtectionConflicts(ownerName string, repoName string, branch string) ([]string, error) {
+	protection, resp, err := g.client.Repositories.GetBranchProtection(ownerName, repoName, branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, parseError(resp, err)
+	}
+
+	var conflicts []string
+	if protection.RequiredPullRequestReviews != nil {
+		conflicts = append(conflicts, fmt.Sprintf("Branch %q requires pull request reviews, so flux cannot push commits to it.", branch))
+	}
+	if protection.RequiredStatusChecks != nil {
+		conflicts = append(conflicts, fmt.Sprintf("Branch %q requires status checks to pass before commits are pushed, so flux cannot push to it.", branch))
+	}
+	if protection.Restrictions != nil {
+		conflicts = append(conflicts, fmt.Sprintf("Only some users and teams can push to branch %q, and the flux deploy key is not one of them.", branch))
+	}
+	if len(conflicts) > 0 {
+		conflicts = append(conflicts, fmt.Sprintf("To fix this, relax the protection of branch %q in the repository settings on GitHub; or set git.pullRequest in the flux config, so that flux opens pull requests against %q rather than pushing to it.", branch, branch))
+	}
+	return conflicts, nil
+}
+
+// OpenPullRequest opens a pull request to merge the branch head into
+// the branch base, and returns its URL.
+func (g *github) OpenPullRequest(ownerName, repoName, head, base, title, body string) (string, error) {
+	pr, resp, err := g.client.PullRequests.Create(ownerName, repoName, &gh.NewPullRequest{
+		Title: &title,
+		Head:  &head,
+		Base:  &base,
+		Body:  &body,
+	})
+	if err != nil {
+		return "", parseError(resp, err)
+	}
+	if pr.HTMLURL == nil {
+		return "", fmt.Errorf("no URL given for pull request from %s to %s", head, base)
+	}
+	return *pr.HTMLURL, nil
+}
+
 func populateError(err httperror.APIError, resp *gh.Response) *httperror.APIError {
 	err.StatusCode = resp.StatusCode
 	err.Status = resp.Status
@@ -1,6 +1,7 @@
 package github
 
 import (
+	"encoding/json"
 	"fmt"
 	gh "github.com/google/go-github/github"
 	"net/http"
@@ -108,6 +109,70 @@ func TestInsertDeployKey_KeyDoesExist(t *testing.T) {
 	}
 }
 
+func TestBranchProtectionConflicts(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/repos/o/r/branches/master/protection", func(w http.ResponseWriter, r *http.Request) {
+		testMethod(t, r, "GET")
+		fmt.Fprint(w, `{"required_pull_request_reviews":{"include_admins":false}}`)
+	})
+	mux.HandleFunc("/repos/o/r/branches/flux/protection", func(w http.ResponseWriter, r *http.Request) {
+		testMethod(t, r, "GET")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"Branch not protected"}`)
+	})
+
+	g := github{
+		client: client,
+	}
+
+	conflicts, err := g.BranchProtectionConflicts("o", "r", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One for the required reviews, and the advice on fixing it
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected required reviews to conflict, got %v", conflicts)
+	}
+
+	conflicts, err = g.BranchProtectionConflicts("o", "r", "flux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts for unprotected branch, got %v", conflicts)
+	}
+}
+
+func TestOpenPullRequest(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/repos/o/r/pulls", func(w http.ResponseWriter, r *http.Request) {
+		testMethod(t, r, "POST")
+		var pr map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			t.Fatal(err)
+		}
+		if pr["head"] != "flux-release-1" || pr["base"] != "master" {
+			t.Errorf("Expected a pull request from flux-release-1 to master, got %v", pr)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"number":1,"html_url":"https://github.com/o/r/pull/1"}`)
+	})
+
+	g := github{
+		client: client,
+	}
+
+	u, err := g.OpenPullRequest("o", "r", "flux-release-1", "master", "Release", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://github.com/o/r/pull/1" {
+		t.Fatalf("Expected the pull request's URL, got %q", u)
+	}
+}
+
 func testMethod(t *testing.T, r *http.Request, want string) {
 	if got := r.Method; got != want {
 		t.Errorf("Request method: %v, want %v", got, want)
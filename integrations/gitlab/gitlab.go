@@ -0,0 +1,101 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/flux/http/httperror"
+)
+
+const DefaultURL = "https://gitlab.com"
+
+var (
+	errUnauthorized = httperror.APIError{
+		Body: "Permission denied by GitLab. Check the token.",
+	}
+	errNotFound = httperror.APIError{
+		Body: "Cannot find project on GitLab. Check spelling.",
+	}
+	errGeneric = httperror.APIError{
+		Body: "Unable to perform GitLab action. Check error message.",
+	}
+)
+
+type gitlab struct {
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+// NewGitlabClient instantiates a GitLab client from a private (or
+// personal access) token, for the GitLab at baseURL (e.g.,
+// DefaultURL).
+func NewGitlabClient(token, baseURL string) *gitlab {
+	return &gitlab{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+	}
+}
+
+// OpenMergeRequest opens a merge request (GitLab's pull request) to
+// merge the branch source into the branch target, in the project with
+// the path given (e.g., "myorg/conf"), and returns its URL.
+func (g *gitlab) OpenMergeRequest(project, source, target, title, description string) (string, error) {
+	form := url.Values{
+		"source_branch": {source},
+		"target_branch": {target},
+		"title":         {title},
+		"description":   {description},
+	}
+	u := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests", g.baseURL, url.QueryEscape(project))
+	req, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("PRIVATE-TOKEN", g.token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", parseError(resp, string(body))
+	}
+	var mr struct {
+		WebURL string `json:"web_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+		return "", err
+	}
+	if mr.WebURL == "" {
+		return "", fmt.Errorf("no URL given for merge request from %s to %s", source, target)
+	}
+	return mr.WebURL, nil
+}
+
+func populateError(err httperror.APIError, resp *http.Response) *httperror.APIError {
+	err.StatusCode = resp.StatusCode
+	err.Status = resp.Status
+	return &err
+}
+
+func parseError(resp *http.Response, body string) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return populateError(errUnauthorized, resp)
+	case http.StatusNotFound:
+		return populateError(errNotFound, resp)
+	default:
+		e := populateError(errGeneric, resp)
+		e.Body = fmt.Sprintf("%s - %s", e.Body, strings.TrimSpace(body))
+		return e
+	}
+}
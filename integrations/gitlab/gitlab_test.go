@@ -0,0 +1,47 @@
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenMergeRequest(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Request method: %v, want POST", r.Method)
+		}
+		// The project path is escaped in the URL
+		if r.URL.RawPath != "/api/v4/projects/myorg%2Fconf/merge_requests" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"404 Project Not Found"}`)
+			return
+		}
+		if r.Header.Get("PRIVATE-TOKEN") != "tok" {
+			t.Error("Expected the token in the request")
+		}
+		if r.FormValue("source_branch") != "flux-release-1" || r.FormValue("target_branch") != "master" {
+			t.Errorf("Expected a merge request from flux-release-1 to master, got %v", r.Form)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"iid":1,"web_url":"https://gitlab.example.com/myorg/conf/merge_requests/1"}`)
+	})
+
+	g := NewGitlabClient("tok", server.URL)
+	u, err := g.OpenMergeRequest("myorg/conf", "flux-release-1", "master", "Release", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://gitlab.example.com/myorg/conf/merge_requests/1" {
+		t.Fatalf("Expected the merge request's URL, got %q", u)
+	}
+
+	if _, err := NewGitlabClient("tok", server.URL).OpenMergeRequest("myorg/missing", "a", "b", "c", ""); err == nil {
+		t.Fatal("Expected an error for a project that isn't there")
+	}
+}
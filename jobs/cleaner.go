@@ -4,11 +4,15 @@ import (
 	"time"
 
 	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
 )
 
 type Cleaner struct {
 	store  JobStore
 	logger log.Logger
+	failed map[flux.InstanceID]int // last reported failed job counts
 }
 
 func NewCleaner(store JobStore, logger log.Logger) *Cleaner {
@@ -23,5 +27,27 @@ func (c *Cleaner) Clean(tick <-chan time.Time) {
 		if err := c.store.GC(); err != nil {
 			c.logger.Log("err", err)
 		}
+		if err := c.reportFailed(); err != nil {
+			c.logger.Log("err", err)
+		}
+	}
+}
+
+// reportFailed updates the failed jobs gauge for each instance,
+// including resetting those which no longer have any failed jobs.
+func (c *Cleaner) reportFailed() error {
+	counts, err := c.store.CountFailedJobs()
+	if err != nil {
+		return err
+	}
+	for inst := range c.failed {
+		if _, ok := counts[inst]; !ok {
+			failedJobs.With(fluxmetrics.LabelInstanceID, string(inst)).Set(0)
+		}
+	}
+	for inst, count := range counts {
+		failedJobs.With(fluxmetrics.LabelInstanceID, string(inst)).Set(float64(count))
 	}
+	c.failed = counts
+	return nil
 }
@@ -13,7 +13,16 @@ import (
 	"github.com/weaveworks/flux"
 )
 
-const StatusQueued = "Queued."
+const (
+	StatusQueued = "Queued."
+
+	// Failed jobs are kept for longer than others, so they're there to
+	// be looked at and requeued, but not forever.
+	failedJobsKept = 7 * 24 * time.Hour
+
+	// The most failed jobs FailedJobs will return.
+	maxFailedJobsListed = 100
+)
 
 // DatabaseStore is a job store backed by a sql.DB.
 type DatabaseStore struct {
@@ -45,33 +54,56 @@ func NewDatabaseStore(driver, datasource string, oldest time.Duration) (*Databas
 }
 
 func (s *DatabaseStore) GetJob(inst flux.InstanceID, id JobID) (Job, error) {
+	job, err := s.scanJob(s.conn.QueryRow(`
+		SELECT `+jobColumns+`
+		  FROM jobs
+		 WHERE id = $1
+		   AND instance_id = $2
+	`, string(id), string(inst)))
+	if err == sql.ErrNoRows {
+		return Job{}, ErrNoSuchJob
+	} else if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// jobColumns are the columns scanJob expects, in the order it expects
+// them.
+const jobColumns = `queue, method, params, scheduled_at, priority, key, submitted_at, claimed_at, heartbeat_at, finished_at, result, log, status, done, success, error, output, conflict`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob reads a job from a row selecting jobColumns, preceded by
+// anything to be scanned into dest. A failed scan returns the error
+// unwrapped, so that sql.ErrNoRows can be checked for.
+func (s *DatabaseStore) scanJob(row rowScanner, dest ...interface{}) (Job, error) {
 	var (
 		job Job
 		err error
 
 		// these all need special treatment, either because they can
 		// be null, or because they need decoding
-		paramsBytes []byte
-		claimedAt   nullTime
-		heartbeatAt nullTime
-		finishedAt  nullTime
-		resultBytes []byte
-		logBytes    []byte
-		done        sql.NullBool
-		success     sql.NullBool
-		errorBytes  []byte
+		paramsBytes   []byte
+		claimedAt     nullTime
+		heartbeatAt   nullTime
+		finishedAt    nullTime
+		resultBytes   []byte
+		logBytes      []byte
+		done          sql.NullBool
+		success       sql.NullBool
+		errorBytes    []byte
+		outputBytes   []byte
+		conflictBytes []byte
 	)
 
-	if err = s.conn.QueryRow(`
-		SELECT queue, method, params, scheduled_at, priority, key, submitted_at, claimed_at, heartbeat_at, finished_at, result, log, status, done, success, error
-		  FROM jobs
-		 WHERE id = $1
-		   AND instance_id = $2
-	`, string(id), string(inst)).Scan(
+	if err = row.Scan(append(dest,
 		&job.Queue, &job.Method, &paramsBytes, &job.ScheduledAt, &job.Priority, &job.Key, &job.Submitted,
-		&claimedAt, &heartbeatAt, &finishedAt, &resultBytes, &logBytes, &job.Status, &done, &success, &errorBytes,
-	); err == sql.ErrNoRows {
-		return Job{}, ErrNoSuchJob
+		&claimedAt, &heartbeatAt, &finishedAt, &resultBytes, &logBytes, &job.Status, &done, &success, &errorBytes, &outputBytes, &conflictBytes,
+	)...); err == sql.ErrNoRows {
+		return Job{}, err
 	} else if err != nil {
 		return Job{}, errors.Wrap(err, "error getting job")
 	}
@@ -100,6 +132,18 @@ func (s *DatabaseStore) GetJob(inst flux.InstanceID, id JobID) (Job, error) {
 		return Job{}, errors.Wrap(err, "unmarshaling log")
 	}
 
+	if outputBytes != nil {
+		if err = json.Unmarshal(outputBytes, &job.Output); err != nil {
+			return Job{}, errors.Wrap(err, "unmarshaling output")
+		}
+	}
+
+	if conflictBytes != nil {
+		if err = json.Unmarshal(conflictBytes, &job.Conflict); err != nil {
+			return Job{}, errors.Wrap(err, "unmarshaling conflict")
+		}
+	}
+
 	return job, nil
 }
 
@@ -182,6 +226,32 @@ func (s *DatabaseStore) PutJob(inst flux.InstanceID, job Job) (JobID, error) {
 	return jobID, err
 }
 
+// PutJobUnlessQueued schedules a job to run, unless a job with the
+// same key is waiting to be taken. Unlike PutJob, a job with the same
+// key that is already running doesn't stop it; so a job that must
+// see everything that happened before it was put (e.g., a sync after
+// a push) isn't lost for arriving while the last one runs.
+func (s *DatabaseStore) PutJobUnlessQueued(inst flux.InstanceID, job Job) (JobID, error) {
+	var jobID JobID
+	err := s.Transaction(func(s *DatabaseStore) (err error) {
+		if job.Key != "" {
+			var count int
+			err = s.conn.QueryRow(`
+				SELECT count(1) FROM jobs WHERE instance_id = $1 AND key = $2 AND claimed_at IS NULL AND finished_at IS NULL
+			`, string(inst), job.Key).Scan(&count)
+			if err != nil {
+				return errors.Wrap(err, "looking for queued job")
+			}
+			if count > 0 {
+				return ErrJobAlreadyQueued
+			}
+		}
+		jobID, err = s.PutJobIgnoringDuplicates(inst, job)
+		return err
+	})
+	return jobID, err
+}
+
 // Take the next job from specified queues. If queues is nil, all queues are
 // used.
 func (s *DatabaseStore) NextJob(queues []string) (Job, error) {
@@ -339,6 +409,13 @@ func (s *DatabaseStore) scanParams(method string, params []byte) (interface{}, e
 		}
 		err := json.Unmarshal(params, &p)
 		return p, err
+	case BlueGreenJob:
+		var p BlueGreenJobParams
+		if params == nil {
+			return p, nil
+		}
+		err := json.Unmarshal(params, &p)
+		return p, err
 	default:
 		return nil, ErrUnknownJobMethod
 	}
@@ -346,7 +423,7 @@ func (s *DatabaseStore) scanParams(method string, params []byte) (interface{}, e
 
 func (s *DatabaseStore) scanResult(method string, result []byte) (interface{}, error) {
 	switch method {
-	case ReleaseJob:
+	case ReleaseJob, BlueGreenJob:
 		var r flux.ReleaseResult
 		if result == nil {
 			return r, nil
@@ -378,14 +455,22 @@ func (s *DatabaseStore) UpdateJob(job Job) error {
 	if err != nil {
 		return errors.Wrap(err, "marshaling error")
 	}
+	outputBytes, err := json.Marshal(job.Output)
+	if err != nil {
+		return errors.Wrap(err, "marshaling output")
+	}
+	conflictBytes, err := json.Marshal(job.Conflict)
+	if err != nil {
+		return errors.Wrap(err, "marshaling conflict")
+	}
 
 	return s.Transaction(func(s *DatabaseStore) error {
 		if res, err := s.conn.Exec(`
 			UPDATE jobs
-				 SET params = $1, result = $2, log = $3, status = $4, error = $5
-			 WHERE id = $6
-				 AND instance_id = $7
-		`, string(paramsBytes), string(resultBytes), string(logBytes), job.Status, string(errBytes), string(job.ID), string(job.Instance)); err != nil {
+				 SET params = $1, result = $2, log = $3, status = $4, error = $5, output = $6, conflict = $7
+			 WHERE id = $8
+				 AND instance_id = $9
+		`, string(paramsBytes), string(resultBytes), string(logBytes), job.Status, string(errBytes), string(outputBytes), string(conflictBytes), string(job.ID), string(job.Instance)); err != nil {
 			return errors.Wrap(err, "updating job in database")
 		} else if n, err := res.RowsAffected(); err != nil {
 			return errors.Wrap(err, "after update, checking affected rows")
@@ -440,6 +525,177 @@ func (s *DatabaseStore) Heartbeat(id JobID) error {
 	})
 }
 
+// FailedJobs returns the jobs for the instance which have finished
+// unsuccessfully, most recently submitted first, up to
+// maxFailedJobsListed of them.
+func (s *DatabaseStore) FailedJobs(inst flux.InstanceID) ([]Job, error) {
+	rows, err := s.conn.Query(`
+		SELECT id, `+jobColumns+`
+		  FROM jobs
+		 WHERE instance_id = $1
+		   AND done = true
+		   AND success = false
+		 ORDER BY submitted_at DESC
+		 LIMIT $2
+	`, string(inst), maxFailedJobsListed)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing failed jobs")
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var id string
+		job, err := s.scanJob(rows, &id)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning failed job")
+		}
+		job.Instance = inst
+		job.ID = JobID(id)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// RequeueJob puts a failed job back on its queue, to be run again as
+// soon as possible, e.g., once a conflict with changes in the config
+// repo has been resolved. The error (and any conflict) from the failed
+// run is cleared, but the log is kept.
+func (s *DatabaseStore) RequeueJob(inst flux.InstanceID, id JobID) error {
+	return s.Transaction(func(s *DatabaseStore) error {
+		job, err := s.GetJob(inst, id)
+		if err != nil {
+			return err
+		}
+		if !job.Done || job.Success {
+			return ErrJobNotFailed
+		}
+
+		// As with PutJob, don't let a job run alongside another with
+		// the same key, e.g., an automation run queued since this one
+		// failed.
+		if job.Key != "" {
+			var count int
+			if err := s.conn.QueryRow(`
+				SELECT count(1)
+				  FROM jobs
+				 WHERE instance_id = $1
+				   AND key = $2
+				   AND finished_at IS NULL
+			`, string(inst), job.Key).Scan(&count); err != nil {
+				return errors.Wrap(err, "checking for existing job")
+			} else if count != 0 {
+				return ErrJobAlreadyRunning
+			}
+		}
+
+		now, err := s.now(s.conn)
+		if err != nil {
+			return errors.Wrap(err, "getting current time")
+		}
+		status := StatusQueued
+		logBytes, err := json.Marshal(append(job.Log, "Requeued.", status))
+		if err != nil {
+			return errors.Wrap(err, "marshaling log")
+		}
+
+		if res, err := s.conn.Exec(`
+			UPDATE jobs
+				 SET scheduled_at = $1, claimed_at = NULL, heartbeat_at = NULL, finished_at = NULL,
+						 done = NULL, success = NULL, error = NULL, conflict = NULL, log = $2, status = $3
+			 WHERE id = $4
+				 AND instance_id = $5
+		`, now, string(logBytes), status, string(id), string(inst)); err != nil {
+			return errors.Wrap(err, "requeueing job in database")
+		} else if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "after requeue, checking affected rows")
+		} else if n != 1 {
+			return errors.Errorf("requeueing job affected %d rows; wanted 1", n)
+		}
+		return nil
+	})
+}
+
+// AbandonJob deletes a failed job, e.g., one whose changes conflict
+// with changes in the config repo, rather than run it again.
+func (s *DatabaseStore) AbandonJob(inst flux.InstanceID, id JobID) error {
+	return s.Transaction(func(s *DatabaseStore) error {
+		job, err := s.GetJob(inst, id)
+		if err != nil {
+			return err
+		}
+		if !job.Done || job.Success {
+			return ErrJobNotFailed
+		}
+		if res, err := s.conn.Exec(`
+			DELETE FROM jobs
+			 WHERE id = $1
+			   AND instance_id = $2
+		`, string(id), string(inst)); err != nil {
+			return errors.Wrap(err, "abandoning job in database")
+		} else if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "after abandoning, checking affected rows")
+		} else if n != 1 {
+			return errors.Errorf("abandoning job affected %d rows; wanted 1", n)
+		}
+		return nil
+	})
+}
+
+// PurgeFailedJobs deletes all the failed jobs for the instance, and
+// returns the number deleted.
+func (s *DatabaseStore) PurgeFailedJobs(inst flux.InstanceID) (int64, error) {
+	var n int64
+	err := s.Transaction(func(s *DatabaseStore) error {
+		res, err := s.conn.Exec(`
+			DELETE FROM jobs
+			 WHERE instance_id = $1
+			   AND done = true
+			   AND success = false
+		`, string(inst))
+		if err != nil {
+			return errors.Wrap(err, "purging failed jobs")
+		}
+		n, err = res.RowsAffected()
+		return errors.Wrap(err, "after purge, checking affected rows")
+	})
+	return n, err
+}
+
+// CountFailedJobs returns the number of failed jobs for each instance
+// that has any.
+func (s *DatabaseStore) CountFailedJobs() (map[flux.InstanceID]int, error) {
+	rows, err := s.conn.Query(`
+		SELECT instance_id, count(1)
+		  FROM jobs
+		 WHERE done = true
+		   AND success = false
+		 GROUP BY instance_id
+	`)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting failed jobs")
+	}
+	defer rows.Close()
+
+	counts := map[flux.InstanceID]int{}
+	for rows.Next() {
+		var (
+			inst  string
+			count int
+		)
+		if err := rows.Scan(&inst, &count); err != nil {
+			return nil, errors.Wrap(err, "scanning failed job count")
+		}
+		counts[flux.InstanceID(inst)] = count
+	}
+	return counts, rows.Err()
+}
+
+// GC deletes jobs that finished, or were claimed and stopped
+// heartbeating, longer ago than the oldest age the store was given.
+// Failed jobs are kept for failedJobsKept instead, or until they're
+// requeued, abandoned or purged, so that they're there to be looked
+// at.
 func (s *DatabaseStore) GC() error {
 	// Take current time from the DB. Use the helper function to accommodate
 	// for non-portable time functions/queries across different DBs :(
@@ -449,15 +705,26 @@ func (s *DatabaseStore) GC() error {
 			return errors.Wrap(err, "getting current time")
 		}
 
+		// success is set only once a job is done, so failed jobs are
+		// those with it false.
 		if _, err := s.conn.Exec(`
 			DELETE FROM jobs
-						WHERE (finished_at IS NOT NULL AND submitted_at < $1)
-						   OR (claimed_at IS NOT NULL
-							 AND claimed_at < $1
-							 AND (heartbeat_at IS NULL OR heartbeat_at < $1))
+						WHERE ((finished_at IS NOT NULL AND submitted_at < $1)
+						    OR (claimed_at IS NOT NULL
+							  AND claimed_at < $1
+							  AND (heartbeat_at IS NULL OR heartbeat_at < $1)))
+						  AND (success IS NULL OR success = true)
 		`, now.Add(-s.oldest)); err != nil {
 			return errors.Wrap(err, "deleting old jobs")
 		}
+		if _, err := s.conn.Exec(`
+			DELETE FROM jobs
+						WHERE done = true
+						  AND success = false
+						  AND finished_at < $1
+		`, now.Add(-failedJobsKept)); err != nil {
+			return errors.Wrap(err, "deleting old failed jobs")
+		}
 		return nil
 	})
 }
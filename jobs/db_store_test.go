@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -186,6 +187,7 @@ func TestDatabaseStore(t *testing.T) {
 	interactiveJob.Log = append(interactiveJob.Log, newStatus)
 	jobError := flux.BaseError{Err: errors.New("underlying error"), Help: "helpful text goes here"}
 	interactiveJob.Error = &jobError
+	interactiveJob.Output = []string{"git clone", "kubectl apply"}
 	bailIfErr(t, db.UpdateJob(interactiveJob))
 	// - It should have saved the changes
 	interactiveJob, err = db.GetJob(instance, interactiveJobID)
@@ -196,6 +198,9 @@ func TestDatabaseStore(t *testing.T) {
 	if interactiveJob.Error == nil || interactiveJob.Error.Help != jobError.Help {
 		t.Errorf("expected job to have error with same help text, got %v", interactiveJob.Error)
 	}
+	if len(interactiveJob.Output) != 2 || interactiveJob.Output[1] != "kubectl apply" {
+		t.Errorf("expected job to have output, got %v", interactiveJob.Output)
+	}
 
 	// Heartbeat the job
 	oldHeartbeat := interactiveJob.Heartbeat
@@ -513,3 +518,214 @@ func TestDatabaseStoreExpiresHeartbeatedButCrashedJobs(t *testing.T) {
 		t.Errorf("expected ErrNoSuchJob, got %q", err)
 	}
 }
+
+func TestDatabaseStoreKeepsFailedJobs(t *testing.T) {
+	instance := flux.InstanceID("instance")
+	db := Setup(t)
+	defer Cleanup(t, db)
+
+	// Mock time, so we can mess around with it
+	now := time.Now()
+	db.now = func(_ dbProxy) (time.Time, error) {
+		return now, nil
+	}
+
+	// Put a job and fail it
+	jobID, err := db.PutJob(instance, Job{
+		Method:   ReleaseJob,
+		Params:   ReleaseJobParams{},
+		Priority: PriorityInteractive,
+	})
+	bailIfErr(t, err)
+	job, err := db.NextJob(nil)
+	bailIfErr(t, err)
+	job.Done = true
+	job.Success = false
+	bailIfErr(t, db.UpdateJob(job))
+
+	// GC should not remove it along with other old jobs
+	now = now.Add(2 * time.Minute)
+	bailIfErr(t, db.GC())
+	failed, err := db.FailedJobs(instance)
+	bailIfErr(t, err)
+	if len(failed) != 1 || failed[0].ID != jobID {
+		t.Fatalf("expected the failed job to be kept, got %+v", failed)
+	}
+
+	// .. but should once it's older than failed jobs are kept for
+	now = now.Add(failedJobsKept)
+	bailIfErr(t, db.GC())
+	if _, err = db.GetJob(instance, jobID); err != ErrNoSuchJob {
+		t.Errorf("expected ErrNoSuchJob, got %q", err)
+	}
+}
+
+func TestDatabaseStoreRequeueJobWithKey(t *testing.T) {
+	instance := flux.InstanceID("instance")
+	db := Setup(t)
+	defer Cleanup(t, db)
+
+	put := func() (JobID, error) {
+		return db.PutJob(instance, Job{
+			Key:    "automation",
+			Method: AutomatedInstanceJob,
+		})
+	}
+
+	// Put a job and fail it
+	jobID, err := put()
+	bailIfErr(t, err)
+	job, err := db.NextJob(nil)
+	bailIfErr(t, err)
+	job.Done = true
+	job.Success = false
+	bailIfErr(t, db.UpdateJob(job))
+
+	// Queue another with the same key; requeueing the failed one
+	// would have them both running
+	_, err = put()
+	bailIfErr(t, err)
+	if err := db.RequeueJob(instance, jobID); err != ErrJobAlreadyRunning {
+		t.Errorf("expected ErrJobAlreadyRunning, got %q", err)
+	}
+
+	// Once the other has finished, it can be requeued
+	next, err := db.NextJob(nil)
+	bailIfErr(t, err)
+	next.Done = true
+	next.Success = true
+	bailIfErr(t, db.UpdateJob(next))
+	bailIfErr(t, db.RequeueJob(instance, jobID))
+}
+
+func TestDatabaseStoreFailedJobs(t *testing.T) {
+	instance := flux.InstanceID("instance")
+	db := Setup(t)
+	defer Cleanup(t, db)
+
+	// Put a job and fail it
+	jobID, err := db.PutJob(instance, Job{
+		Method:   ReleaseJob,
+		Params:   ReleaseJobParams{},
+		Priority: PriorityInteractive,
+	})
+	bailIfErr(t, err)
+	job, err := db.NextJob(nil)
+	bailIfErr(t, err)
+	job.Done = true
+	job.Success = false
+	job.Error = &flux.BaseError{Err: errors.New("underlying error"), Help: "helpful text goes here"}
+	job.Conflict = &flux.Conflict{Files: []flux.ConflictFile{{Path: "deploy.yaml", Hunks: []string{"<<<<<<< HEAD\n"}}}}
+	bailIfErr(t, db.UpdateJob(job))
+
+	// Requeueing (or abandoning) a job which hasn't failed is an error
+	okID, err := db.PutJob(instance, Job{
+		Method: ReleaseJob,
+		Params: ReleaseJobParams{},
+	})
+	bailIfErr(t, err)
+	if err := db.RequeueJob(instance, okID); err != ErrJobNotFailed {
+		t.Errorf("expected ErrJobNotFailed, got %q", err)
+	}
+	if err := db.AbandonJob(instance, okID); err != ErrJobNotFailed {
+		t.Errorf("expected ErrJobNotFailed, got %q", err)
+	}
+
+	// - It should be listed, with its error and conflict
+	failed, err := db.FailedJobs(instance)
+	bailIfErr(t, err)
+	if len(failed) != 1 || failed[0].ID != jobID || failed[0].Error == nil {
+		t.Fatalf("expected the failed job with its error, got %+v", failed)
+	}
+	if !reflect.DeepEqual(failed[0].Conflict, job.Conflict) {
+		t.Errorf("expected conflict %+v, got %+v", job.Conflict, failed[0].Conflict)
+	}
+	counts, err := db.CountFailedJobs()
+	bailIfErr(t, err)
+	if counts[instance] != 1 {
+		t.Errorf("expected a count of 1 failed job, got %d", counts[instance])
+	}
+
+	// Requeue it
+	bailIfErr(t, db.RequeueJob(instance, jobID))
+	// - It should no longer be failed
+	failed, err = db.FailedJobs(instance)
+	bailIfErr(t, err)
+	if len(failed) != 0 {
+		t.Errorf("expected no failed jobs after requeue, got %d", len(failed))
+	}
+	// - It should be available to run again
+	job, err = db.NextJob(nil)
+	bailIfErr(t, err)
+	if job.ID != jobID {
+		t.Fatalf("expected the requeued job, got %q", job.ID)
+	}
+	if requeued, err := db.GetJob(instance, jobID); err != nil || requeued.Conflict != nil {
+		t.Errorf("expected the conflict to be cleared on requeue, got %+v (%v)", requeued.Conflict, err)
+	}
+
+	// Fail it again, and purge
+	job.Done = true
+	job.Success = false
+	bailIfErr(t, db.UpdateJob(job))
+	n, err := db.PurgeFailedJobs(instance)
+	bailIfErr(t, err)
+	if n != 1 {
+		t.Errorf("expected 1 job to be purged, got %d", n)
+	}
+	if _, err = db.GetJob(instance, jobID); err != ErrNoSuchJob {
+		t.Errorf("expected ErrNoSuchJob, got %q", err)
+	}
+
+	// A failed job can be abandoned on its own
+	job, err = db.NextJob(nil)
+	bailIfErr(t, err)
+	if job.ID != okID {
+		t.Fatalf("expected the other job, got %q", job.ID)
+	}
+	job.Done = true
+	job.Success = false
+	bailIfErr(t, db.UpdateJob(job))
+	bailIfErr(t, db.AbandonJob(instance, okID))
+	if _, err = db.GetJob(instance, okID); err != ErrNoSuchJob {
+		t.Errorf("expected ErrNoSuchJob after abandoning, got %q", err)
+	}
+}
+
+func TestDatabaseStorePutJobUnlessQueued(t *testing.T) {
+	instance := flux.InstanceID("instance")
+	db := Setup(t)
+	defer Cleanup(t, db)
+
+	put := func() (JobID, error) {
+		return db.PutJobUnlessQueued(instance, Job{
+			Key:      "notify",
+			Method:   AutomatedInstanceJob,
+			Params:   AutomatedInstanceJobParams{InstanceID: instance},
+			Priority: PriorityInteractive,
+		})
+	}
+
+	firstID, err := put()
+	bailIfErr(t, err)
+	// - A duplicate while the first is queued is collapsed into it
+	if _, err := put(); err != ErrJobAlreadyQueued {
+		t.Errorf("Expected duplicate of a queued job to return ErrJobAlreadyQueued, got: %q", err)
+	}
+
+	// - Once the first is running, a duplicate is queued after it
+	running, err := db.NextJob(nil)
+	bailIfErr(t, err)
+	if running.ID != firstID {
+		t.Fatalf("Expected to take job %q, got %q", firstID, running.ID)
+	}
+	secondID, err := put()
+	bailIfErr(t, err)
+	if secondID == "" || secondID == firstID {
+		t.Errorf("Expected a new job to be queued, got %q", secondID)
+	}
+	// - ... and further duplicates are collapsed into that
+	if _, err := put(); err != ErrJobAlreadyQueued {
+		t.Errorf("Expected duplicate of a queued job to return ErrJobAlreadyQueued, got: %q", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/guid"
+	"github.com/weaveworks/flux/tracing"
 )
 
 const (
@@ -19,6 +20,10 @@ const (
 	// AutomatedInstanceJob is the method for a check automated instance job
 	AutomatedInstanceJob = "automated_instance"
 
+	// BlueGreenJob is the method for a job that checks on, and
+	// finishes, a blue/green release. It goes on the release queue.
+	BlueGreenJob = "bluegreen"
+
 	// PriorityBackground is priority for background jobs
 	PriorityBackground = 100
 
@@ -36,6 +41,21 @@ release ID.`,
 		Err: errors.New("no such release job found"),
 	}}
 
+	ErrJobNotFailed = flux.UserConfigProblem{&flux.BaseError{
+		Help: `Only jobs that have finished unsuccessfully can be requeued, or abandoned.
+
+Check the job ID against the list of failed jobs.`,
+		Err: errors.New("job has not failed"),
+	}}
+
+	ErrJobAlreadyRunning = flux.UserConfigProblem{&flux.BaseError{
+		Help: `Another job of the same kind is queued or running, so this one can't be
+requeued alongside it.
+
+Wait for the other job to finish, then try again, or abandon this job.`,
+		Err: errors.New("a job with the same key is already queued or running"),
+	}}
+
 	ErrNoJobAvailable   = errors.New("no job available")
 	ErrUnknownJobMethod = errors.New("unknown job method")
 	ErrJobAlreadyQueued = errors.New("job is already queued")
@@ -45,6 +65,7 @@ release ID.`,
 type JobStore interface {
 	JobReadPusher
 	JobWritePopper
+	JobAdmin
 	GC() error
 }
 
@@ -52,6 +73,7 @@ type JobReadPusher interface {
 	GetJob(flux.InstanceID, JobID) (Job, error)
 	PutJob(flux.InstanceID, Job) (JobID, error)
 	PutJobIgnoringDuplicates(flux.InstanceID, Job) (JobID, error)
+	PutJobUnlessQueued(flux.InstanceID, Job) (JobID, error)
 }
 
 type JobWritePopper interface {
@@ -68,6 +90,16 @@ type JobPopper interface {
 	NextJob(queues []string) (Job, error)
 }
 
+// JobAdmin is for inspecting and recovering jobs that have failed,
+// i.e., the dead-letter set.
+type JobAdmin interface {
+	FailedJobs(flux.InstanceID) ([]Job, error)
+	RequeueJob(flux.InstanceID, JobID) error
+	AbandonJob(flux.InstanceID, JobID) error
+	PurgeFailedJobs(flux.InstanceID) (int64, error)
+	CountFailedJobs() (map[flux.InstanceID]int, error)
+}
+
 type JobID string
 
 func NewJobID() JobID {
@@ -96,11 +128,15 @@ type Job struct {
 	Heartbeat time.Time       `json:"heartbeat,omitempty"`
 	Finished  time.Time       `json:"finished,omitempty"`
 	Log       []string        `json:"log,omitempty"`
-	Result    interface{}     `json:"result"` // may be updated to reflect progress
+	Output    []string        `json:"output,omitempty"` // detailed log, for debugging
+	Result    interface{}     `json:"result"`           // may be updated to reflect progress
 	Status    string          `json:"status"`
 	Done      bool            `json:"done"`
 	Success   bool            `json:"success"` // only makes sense after done is true
 	Error     *flux.BaseError `json:"error,omitempty"`
+	// Conflict is set if the job failed because its changes to the
+	// config repo conflicted with changes pushed meanwhile.
+	Conflict *flux.Conflict `json:"conflict,omitempty"`
 }
 
 func (j *Job) UnmarshalJSON(data []byte) error {
@@ -131,6 +167,29 @@ func (j *Job) UnmarshalJSON(data []byte) error {
 			}
 		}
 		j.Result = r
+	case AutomatedInstanceJob:
+		var p AutomatedInstanceJobParams
+		if wireJob.Params != nil {
+			if err := json.Unmarshal(wireJob.Params, &p); err != nil {
+				return err
+			}
+		}
+		j.Params = p
+	case BlueGreenJob:
+		var p BlueGreenJobParams
+		if wireJob.Params != nil {
+			if err := json.Unmarshal(wireJob.Params, &p); err != nil {
+				return err
+			}
+		}
+		j.Params = p
+		var r flux.ReleaseResult
+		if wireJob.Result != nil {
+			if err := json.Unmarshal(wireJob.Result, &r); err != nil {
+				return err
+			}
+		}
+		j.Result = r
 	}
 	return nil
 }
@@ -139,6 +198,9 @@ func (j *Job) UnmarshalJSON(data []byte) error {
 type ReleaseJobParams struct {
 	flux.ReleaseSpec
 	Cause flux.ReleaseCause
+	// Trace is the trace of the request that asked for the release,
+	// if any, so that the release is part of it.
+	Trace *tracing.SpanContext `json:",omitempty"`
 }
 
 func (params ReleaseJobParams) Spec() flux.ReleaseSpec {
@@ -149,3 +211,27 @@ func (params ReleaseJobParams) Spec() flux.ReleaseSpec {
 type AutomatedInstanceJobParams struct {
 	InstanceID flux.InstanceID
 }
+
+// BlueGreenJobParams are the params for a bluegreen job: the release
+// it's part of, the copies of workloads that release started, and
+// when to give up waiting for them to be ready. Once the release is
+// being called off, AbortReason says why, and AbortAttempts counts
+// the tries at removing the copies.
+type BlueGreenJobParams struct {
+	ReleaseJobParams
+	ReleaseID     flux.ReleaseID
+	Copies        []BlueGreenCopy
+	Deadline      time.Time
+	AbortReason   string
+	AbortAttempts int
+}
+
+// BlueGreenCopy is a copy of a service's workload, started by a
+// blue/green release with the updates given. The paths are relative
+// to the top of a clone of the config repo.
+type BlueGreenCopy struct {
+	ServiceID    flux.ServiceID
+	ManifestPath string
+	CopyPath     string
+	Updates      []flux.ContainerUpdate
+}
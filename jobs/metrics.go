@@ -30,6 +30,12 @@ var (
 		Help:      "Job duration in seconds.",
 		Buckets:   stdprometheus.DefBuckets,
 	}, []string{fluxmetrics.LabelMethod, fluxmetrics.LabelSuccess})
+	failedJobs = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "jobs",
+		Name:      "failed_jobs_count",
+		Help:      "Gauge of the number of failed jobs, per instance.",
+	}, []string{fluxmetrics.LabelInstanceID})
 )
 
 func InstrumentedJobStore(js JobStore) JobStore {
@@ -68,6 +74,16 @@ func (i *instrumentedJobStore) PutJobIgnoringDuplicates(inst flux.InstanceID, j
 	return i.js.PutJobIgnoringDuplicates(inst, j)
 }
 
+func (i *instrumentedJobStore) PutJobUnlessQueued(inst flux.InstanceID, j Job) (jobID JobID, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "PutJobUnlessQueued",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.PutJobUnlessQueued(inst, j)
+}
+
 func (i *instrumentedJobStore) UpdateJob(j Job) (err error) {
 	defer func(begin time.Time) {
 		requestDuration.With(
@@ -107,3 +123,53 @@ func (i *instrumentedJobStore) GC() (err error) {
 	}(time.Now())
 	return i.js.GC()
 }
+
+func (i *instrumentedJobStore) FailedJobs(inst flux.InstanceID) (jobs []Job, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "FailedJobs",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.FailedJobs(inst)
+}
+
+func (i *instrumentedJobStore) RequeueJob(inst flux.InstanceID, jobID JobID) (err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "RequeueJob",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.RequeueJob(inst, jobID)
+}
+
+func (i *instrumentedJobStore) AbandonJob(inst flux.InstanceID, jobID JobID) (err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "AbandonJob",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.AbandonJob(inst, jobID)
+}
+
+func (i *instrumentedJobStore) PurgeFailedJobs(inst flux.InstanceID) (n int64, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "PurgeFailedJobs",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.PurgeFailedJobs(inst)
+}
+
+func (i *instrumentedJobStore) CountFailedJobs() (counts map[flux.InstanceID]int, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "CountFailedJobs",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.js.CountFailedJobs()
+}
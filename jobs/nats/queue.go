@@ -0,0 +1,324 @@
+// Package nats hands jobs to workers in other processes over NATS, so
+// that executing jobs (releases, in particular) can be scaled apart
+// from the service, which then only accepts jobs and keeps track of
+// them.
+//
+// The service's workers still take jobs from the job store, and
+// record their progress and outcome there; but rather than executing
+// each job, they send it on the queue (see Queue.Dispatcher). A
+// worker elsewhere (see Worker) takes it, and sends back its progress,
+// then the job as it finished, with any follow-up jobs.
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/jobs"
+)
+
+const (
+	// Jobs are sent on this subject, followed by the method
+	subjectPrefix = "flux.jobs."
+	// Workers taking jobs are in this queue group, so that each job
+	// goes to just one of them
+	workerGroup = "workers"
+	encoderType = nats.JSON_ENCODER
+
+	// How long to wait for a worker to take a job
+	acceptTimeout = 10 * time.Second
+	// How often a worker says it's still executing a job, and how
+	// long to wait to hear from it before giving up on the job
+	keepaliveInterval = 10 * time.Second
+	workerTimeout     = time.Minute
+	// How often a worker that's waiting for a job checks whether it's
+	// been stopped
+	pollingPeriod = time.Second
+
+	kindAccepted  = "accepted"
+	kindProgress  = "progress"
+	kindKeepalive = "keepalive"
+	kindDone      = "done"
+)
+
+// ErrNoWorker is the error for a job that no worker took, e.g.,
+// because there are none running, or they're all busy.
+var ErrNoWorker = errors.New("no worker took the job")
+
+// Queue carries jobs between the service and the workers executing
+// them.
+type Queue struct {
+	// As for the message bus, send on an encoding connection, and
+	// receive on the raw connection to decode messages ourselves.
+	enc *nats.EncodedConn
+	raw *nats.Conn
+}
+
+func NewQueue(url string) (*Queue, error) {
+	conn, err := nats.Connect(url, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	encConn, err := nats.NewEncodedConn(conn, encoderType)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Queue{enc: encConn, raw: conn}, nil
+}
+
+func (q *Queue) Close() {
+	q.enc.Close()
+}
+
+// jobMessage is a job sent to a worker.
+type jobMessage struct {
+	Job jobs.Job
+}
+
+// workerMessage is what a worker sends back about a job: that it's
+// taken it, or is still executing it; its progress; or the job as it
+// finished, along with follow-up jobs, or an error.
+type workerMessage struct {
+	Kind      string
+	Job       *jobs.Job       `json:",omitempty"`
+	FollowUps []jobs.Job      `json:",omitempty"`
+	Message   string          `json:",omitempty"`
+	Error     *flux.BaseError `json:",omitempty"`
+}
+
+// jobError is the error from a job executed by a worker. It reads as
+// the error did there, and its cause is the helpful error made from
+// it, as the service's worker would make from the error itself.
+type jobError struct {
+	message string
+	base    *flux.BaseError
+}
+
+func (e jobError) Error() string {
+	return e.message
+}
+
+func (e jobError) Cause() error {
+	return e.base
+}
+
+// Dispatcher gives a handler which has each job executed by a worker
+// taking jobs from the queue. It can be registered with a jobs.Worker
+// for each method, in place of the handler that would execute the
+// jobs.
+func (q *Queue) Dispatcher() jobs.Handler {
+	return dispatcher{q}
+}
+
+type dispatcher struct {
+	q *Queue
+}
+
+func (d dispatcher) Handle(job *jobs.Job, updater jobs.JobUpdater) ([]jobs.Job, error) {
+	inbox := nats.NewInbox()
+	sub, err := d.q.raw.SubscribeSync(inbox)
+	if err != nil {
+		return nil, errors.Wrap(err, "subscribing to worker messages")
+	}
+	defer sub.Unsubscribe()
+	if err := d.q.enc.PublishRequest(subjectPrefix+job.Method, inbox, jobMessage{*job}); err != nil {
+		return nil, errors.Wrap(err, "sending job to workers")
+	}
+
+	accepted := false
+	for {
+		wait := workerTimeout
+		if !accepted {
+			wait = acceptTimeout
+		}
+		msg, err := sub.NextMsg(wait)
+		if err == nats.ErrTimeout {
+			if !accepted {
+				return nil, ErrNoWorker
+			}
+			return nil, fmt.Errorf("no word from the worker executing the job for %s", wait)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "waiting for worker")
+		}
+		var m workerMessage
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			return nil, errors.Wrap(err, "decoding message from worker")
+		}
+		accepted = true
+		switch m.Kind {
+		case kindProgress:
+			if m.Job != nil {
+				*job = *m.Job
+				updater.UpdateJob(*job)
+			}
+		case kindDone:
+			if m.Job != nil {
+				*job = *m.Job
+			}
+			if m.Error != nil {
+				return m.FollowUps, jobError{m.Message, m.Error}
+			}
+			return m.FollowUps, nil
+		}
+	}
+}
+
+// Worker takes jobs of one method from the queue, one at a time, and
+// executes them with a handler, as a jobs.Worker does with jobs from
+// the job store.
+type Worker struct {
+	q        *Queue
+	method   string
+	handler  jobs.Handler
+	logger   log.Logger
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+// NewWorker returns a worker taking jobs of the method given from the
+// queue. Run Work in its own goroutine to start taking jobs.
+func (q *Queue) NewWorker(method string, handler jobs.Handler, logger log.Logger) *Worker {
+	return &Worker{
+		q:        q,
+		method:   method,
+		handler:  handler,
+		logger:   logger,
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Work takes jobs from the queue, until Stop is called.
+func (w *Worker) Work() {
+	defer close(w.done)
+	for {
+		// A subscription is good for one job, so that jobs sent while
+		// this worker is busy go to other workers, rather than waiting
+		// for this one.
+		sub, err := w.q.raw.QueueSubscribeSync(subjectPrefix+w.method, workerGroup)
+		if err == nil {
+			err = sub.AutoUnsubscribe(1)
+		}
+		if err != nil {
+			w.logger.Log("err", errors.Wrap(err, "subscribing to jobs"))
+			select {
+			case <-w.stopping:
+				return
+			case <-time.After(pollingPeriod):
+				continue
+			}
+		}
+		msg, ok := w.next(sub)
+		if !ok {
+			sub.Unsubscribe()
+			return
+		}
+		w.execute(msg)
+	}
+}
+
+// next waits for a job on the subscription, until the worker is
+// stopped.
+func (w *Worker) next(sub *nats.Subscription) (*nats.Msg, bool) {
+	for {
+		select {
+		case <-w.stopping:
+			return nil, false
+		default:
+		}
+		msg, err := sub.NextMsg(pollingPeriod)
+		if err == nats.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			w.logger.Log("err", errors.Wrap(err, "waiting for job"))
+			return nil, false
+		}
+		return msg, true
+	}
+}
+
+func (w *Worker) execute(msg *nats.Msg) {
+	reply := func(m workerMessage) {
+		if err := w.q.enc.Publish(msg.Reply, m); err != nil {
+			w.logger.Log("err", errors.Wrap(err, "replying to service"))
+		}
+	}
+
+	var req jobMessage
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		err = errors.Wrap(err, "decoding job")
+		reply(workerMessage{Kind: kindDone, Message: err.Error(), Error: flux.CoverAllError(err)})
+		return
+	}
+	job := req.Job
+	logger := log.NewContext(w.logger).With("job", job.ID)
+	logger.Log("method", job.Method)
+	reply(workerMessage{Kind: kindAccepted})
+
+	cancel, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(keepaliveInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				reply(workerMessage{Kind: kindKeepalive})
+			case <-cancel:
+				return
+			}
+		}
+	}()
+
+	begin := time.Now()
+	followUps, err := w.handler.Handle(&job, progress(reply))
+	close(cancel)
+	<-done
+	logger.Log("took", time.Since(begin))
+
+	result := workerMessage{Kind: kindDone, Job: &job, FollowUps: followUps}
+	if err != nil {
+		result.Message = err.Error()
+		if helpful, ok := errors.Cause(err).(flux.HelpfulError); ok {
+			result.Error = helpful.Base()
+		} else {
+			result.Error = flux.CoverAllError(errors.Cause(err))
+		}
+	}
+	reply(result)
+}
+
+// Stop stops the worker from taking any more jobs, waiting for the
+// job it's executing, if any, to finish.
+func (w *Worker) Stop(timeout time.Duration) error {
+	close(w.stopping)
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout waiting for worker to shut down")
+	}
+}
+
+// progress sends the updates a handler makes to a job back to the
+// service, which records them.
+type progress func(workerMessage)
+
+func (p progress) UpdateJob(job jobs.Job) error {
+	p(workerMessage{Kind: kindProgress, Job: &job})
+	return nil
+}
+
+func (p progress) Heartbeat(jobs.JobID) error {
+	p(workerMessage{Kind: kindKeepalive})
+	return nil
+}
@@ -0,0 +1,87 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/jobs"
+)
+
+func TestWorkerMessageRoundTrip(t *testing.T) {
+	job := jobs.Job{
+		Instance: "instance",
+		ID:       "job-1",
+		Queue:    jobs.ReleaseJob,
+		Method:   jobs.ReleaseJob,
+		Params: jobs.ReleaseJobParams{
+			ReleaseSpec: flux.ReleaseSpec{
+				ServiceSpecs: []flux.ServiceSpec{"default/helloworld"},
+				ImageSpec:    flux.ImageSpecLatest,
+				Kind:         flux.ReleaseKindExecute,
+			},
+			Cause: flux.ReleaseCause{User: "fred"},
+		},
+		Result: flux.ReleaseResult{
+			"default/helloworld": flux.ServiceResult{Status: flux.ReleaseStatusSuccess},
+		},
+		Status: "Executing...",
+	}
+	followUp := jobs.Job{Queue: jobs.AutomatedInstanceJob, Method: jobs.AutomatedInstanceJob, Params: jobs.AutomatedInstanceJobParams{InstanceID: "instance"}}
+	sent := workerMessage{
+		Kind:      kindDone,
+		Job:       &job,
+		FollowUps: []jobs.Job{followUp},
+	}
+	bytes, err := json.Marshal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got workerMessage
+	if err := json.Unmarshal(bytes, &got); err != nil {
+		t.Fatal(err)
+	}
+	// The params and result come back as the types for the method,
+	// as the service would have them
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("expected\n%#v\ngot\n%#v", sent, got)
+	}
+}
+
+func TestJobError(t *testing.T) {
+	// What the worker would send for a job that failed
+	failed := pkgerrors.Wrap(flux.Missing{BaseError: &flux.BaseError{
+		Help: "There's no such service.",
+		Err:  errors.New("service not found"),
+	}}, "releasing")
+	bytes, err := json.Marshal(workerMessage{
+		Kind:    kindDone,
+		Message: failed.Error(),
+		Error:   pkgerrors.Cause(failed).(flux.HelpfulError).Base(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m workerMessage
+	if err := json.Unmarshal(bytes, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	// The error reads as it did for the worker, and has the helpful
+	// error as its cause, as the service's worker expects
+	err = jobError{m.Message, m.Error}
+	if err.Error() != "releasing: service not found" {
+		t.Errorf("expected the error to read as it did, got %q", err.Error())
+	}
+	helpful, ok := pkgerrors.Cause(err).(flux.HelpfulError)
+	if !ok {
+		t.Fatalf("expected a helpful error as the cause, got %T", pkgerrors.Cause(err))
+	}
+	if helpful.Base().Help != "There's no such service." {
+		t.Errorf("expected the help to be kept, got %q", helpful.Base().Help)
+	}
+}
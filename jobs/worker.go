@@ -2,6 +2,7 @@ package jobs
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -30,6 +31,7 @@ type Worker struct {
 	logger   log.Logger
 	queues   []string
 	stopping chan struct{}
+	stopOnce sync.Once
 	done     chan struct{}
 }
 
@@ -104,6 +106,8 @@ func (w *Worker) Work() {
 			status := fmt.Sprintf("Failed: %s", err)
 			job.Status = status
 			job.Log = append(job.Log, status)
+			job.Output = append(job.Output, status)
+			job.Conflict = flux.ConflictOf(err)
 			// Find the underlying, "helpful" error. We get the base
 			// error because we don't care about dispatching on the
 			// kind of error, just the help message.
@@ -133,9 +137,11 @@ func (w *Worker) Work() {
 	}
 }
 
-// Close stops the worker from processing any more jobs
+// Close stops the worker from processing any more jobs, waiting for
+// the job underway, if any, to finish. It's safe to call more than
+// once.
 func (w *Worker) Stop(timeout time.Duration) error {
-	close(w.stopping)
+	w.stopOnce.Do(func() { close(w.stopping) })
 	select {
 	case <-w.done:
 		return nil
@@ -0,0 +1,145 @@
+// Package layout says how a config repo is laid out: which files
+// define each service, and where the definition of a new resource
+// should be written.
+//
+// There are two layouts built in, Namespaces (the default) and Flat.
+// Others can be added with Register, by a build of fluxsvc that
+// imports them; e.g.,
+//
+//	func init() {
+//		layout.Register("teams", teamsLayout{})
+//	}
+//
+// and then used by giving their name as `git.layout` in the instance
+// config.
+package layout
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// RepoLayout is a way of laying out the resource definitions in a
+// config repo.
+type RepoLayout interface {
+	// Manifests gives the files under the directory given (the config
+	// repo path, in a clone) that define each service.
+	Manifests(dir string) (map[flux.ServiceID][]string, error)
+	// Path gives the file, relative to the config repo path, in which
+	// the definition of a new resource should be written.
+	Path(Resource) string
+}
+
+// Resource is what's known of a resource that's to be written to the
+// config repo.
+type Resource struct {
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+// The layouts built in.
+const (
+	// Namespaces has a directory for each namespace, with a file for
+	// each resource in it, and the files for the namespaces
+	// themselves at the top. It's how `fluxctl save` writes a repo.
+	Namespaces = "namespaces"
+	// Flat has a file for each resource, all at the top.
+	Flat = "flat"
+)
+
+var (
+	mu      sync.RWMutex
+	layouts = map[string]RepoLayout{
+		Namespaces: namespacesLayout{},
+		Flat:       flatLayout{},
+	}
+)
+
+// Register makes a layout available by the name given. It panics if
+// the name is already taken, since that's a mistake in the build.
+func Register(name string, layout RepoLayout) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := layouts[name]; ok || name == "" {
+		panic(fmt.Sprintf("layout %q registered twice", name))
+	}
+	layouts[name] = layout
+}
+
+// Get gives the layout with the name given. A blank name is the
+// default, Namespaces.
+func Get(name string) (RepoLayout, error) {
+	if name == "" {
+		name = Namespaces
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	layout, ok := layouts[name]
+	if !ok {
+		var names []string
+		for name := range layouts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown repo layout %q; expected one of %s", name, strings.Join(names, ", "))
+	}
+	return layout, nil
+}
+
+// Both of the built-in layouts find definitions wherever they are
+// under the directory, so that a repo laid out some other way still
+// works.
+
+type namespacesLayout struct{}
+
+func (namespacesLayout) Manifests(dir string) (map[flux.ServiceID][]string, error) {
+	return kubernetes.FindDefinedServices(dir)
+}
+
+func (namespacesLayout) Path(r Resource) string {
+	if r.Kind == "Namespace" {
+		return fileName(r.Name, r.Kind)
+	}
+	return filepath.Join(r.Namespace, fileName(r.Name, r.Kind))
+}
+
+type flatLayout struct{}
+
+func (flatLayout) Manifests(dir string) (map[flux.ServiceID][]string, error) {
+	return kubernetes.FindDefinedServices(dir)
+}
+
+func (flatLayout) Path(r Resource) string {
+	if r.Kind == "Namespace" || r.Namespace == "" || r.Namespace == "default" {
+		return fileName(r.Name, r.Kind)
+	}
+	return fileName(r.Namespace+"-"+r.Name, r.Kind)
+}
+
+// fileName gives the file name for a resource, e.g.,
+// "helloworld-dep.yaml".
+func fileName(name, kind string) string {
+	return fmt.Sprintf("%s-%s.yaml", name, abbreviateKind(kind))
+}
+
+func abbreviateKind(kind string) string {
+	switch kind {
+	case "Namespace":
+		return "ns"
+	case "Service":
+		return "svc"
+	case "ReplicationController":
+		return "rc"
+	case "Deployment":
+		return "dep"
+	default:
+		return kind
+	}
+}
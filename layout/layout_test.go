@@ -0,0 +1,60 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestPath(t *testing.T) {
+	for _, c := range []struct {
+		layout   string
+		resource Resource
+		expected string
+	}{
+		{Namespaces, Resource{Kind: "Namespace", Name: "dev"}, "dev-ns.yaml"},
+		{Namespaces, Resource{Namespace: "dev", Kind: "Deployment", Name: "helloworld"}, "dev/helloworld-dep.yaml"},
+		{Namespaces, Resource{Namespace: "default", Kind: "ConfigMap", Name: "settings"}, "default/settings-ConfigMap.yaml"},
+		{Flat, Resource{Kind: "Namespace", Name: "dev"}, "dev-ns.yaml"},
+		{Flat, Resource{Namespace: "default", Kind: "Service", Name: "helloworld"}, "helloworld-svc.yaml"},
+		{Flat, Resource{Namespace: "dev", Kind: "Deployment", Name: "helloworld"}, "dev-helloworld-dep.yaml"},
+	} {
+		layout, err := Get(c.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := layout.Path(c.resource); path != c.expected {
+			t.Errorf("%s: expected %+v at %q, got %q", c.layout, c.resource, c.expected, path)
+		}
+	}
+}
+
+type fixedLayout string
+
+func (fixedLayout) Manifests(string) (map[flux.ServiceID][]string, error) { return nil, nil }
+func (l fixedLayout) Path(Resource) string                                { return string(l) }
+
+func TestRegister(t *testing.T) {
+	if _, err := Get("fixed"); err == nil {
+		t.Fatal("expected an error getting a layout that isn't registered")
+	}
+	Register("fixed", fixedLayout("all.yaml"))
+	layout, err := Get("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := layout.Path(Resource{Kind: "Deployment", Name: "helloworld"}); path != "all.yaml" {
+		t.Errorf("expected the registered layout, got path %q", path)
+	}
+
+	if layout, err := Get(""); err != nil || layout != (namespacesLayout{}) {
+		t.Errorf("expected the default layout to be %s, got %v (%v)", Namespaces, layout, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	Register(Flat, fixedLayout("all.yaml"))
+}
@@ -0,0 +1,305 @@
+// Package leader elects one of several replicas of a process as the
+// leader, so that only it does what mustn't be done twice at once;
+// e.g., only one fluxd applies changes to a cluster.
+//
+// The leader holds a lease, kept in a record that all the replicas
+// can see, which it renews every so often. If it fails to renew the
+// lease in time, it stops leading; once the lease has run out, any of
+// the others can take it. This is the same scheme Kubernetes uses to
+// elect the leader of its own controllers.
+package leader
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for the events recorded when leadership changes.
+const (
+	ReasonBecameLeader   = "BecameLeader"
+	ReasonStoppedLeading = "StoppedLeading"
+)
+
+var (
+	// ErrNoRecord is returned by Lock.Get when there's no record yet.
+	ErrNoRecord = errors.New("no leader election record")
+	// ErrConflict is returned by Lock.Create and Lock.Update when
+	// someone else has changed the record in the meantime.
+	ErrConflict = errors.New("leader election record changed by another replica")
+)
+
+var isLeader = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+	Namespace: "flux",
+	Subsystem: "leader",
+	Name:      "is_leader",
+	Help:      "Whether this replica is the leader (1) or on standby (0).",
+}, []string{})
+
+// Record is who holds the lease, and since when.
+type Record struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	LeaderTransitions    int       `json:"leaderTransitions"`
+}
+
+// same says whether the record is the same as another; the times
+// are compared as instants, since they may have been through JSON.
+func (r Record) same(other Record) bool {
+	return r.HolderIdentity == other.HolderIdentity &&
+		r.LeaseDurationSeconds == other.LeaseDurationSeconds &&
+		r.AcquireTime.Equal(other.AcquireTime) &&
+		r.RenewTime.Equal(other.RenewTime) &&
+		r.LeaderTransitions == other.LeaderTransitions
+}
+
+// Lock is where the record is kept. Updates must fail with
+// ErrConflict if the record has changed since it was last got, so
+// that two replicas can't both think they've taken the lease.
+type Lock interface {
+	// Get gives the record, or ErrNoRecord if there isn't one.
+	Get() (Record, error)
+	// Create makes the record, when there wasn't one.
+	Create(Record) error
+	// Update replaces the record last got.
+	Update(Record) error
+	// Describe says what the lock is, for logging.
+	Describe() string
+}
+
+// EventRecorder records changes of leadership, somewhere that people
+// looking at the cluster will see them.
+type EventRecorder interface {
+	Event(reason, message string) error
+}
+
+// Config says how an election is run. The lease must last longer
+// than the leader is given to renew it, which must be longer than
+// how long it waits between tries.
+type Config struct {
+	Lock     Lock
+	Identity string
+	// LeaseDuration is how long a lease lasts after it's last
+	// renewed, as seen by the replicas waiting to take it.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps trying to renew the
+	// lease before it gives up leading.
+	RenewDeadline time.Duration
+	// RetryPeriod is how long to wait between tries, at taking the
+	// lease or renewing it.
+	RetryPeriod time.Duration
+	// Events, if not nil, records changes of leadership.
+	Events EventRecorder
+	Logger log.Logger
+}
+
+// Elector takes part in an election, on behalf of this replica.
+type Elector struct {
+	config Config
+	now    func() time.Time
+
+	// The record as last seen, and when it was seen, by this
+	// replica's clock; leases are timed by the clock of whoever is
+	// waiting for them, so the replicas' clocks needn't agree.
+	observed     Record
+	observedTime time.Time
+
+	mu      sync.Mutex
+	leading bool
+}
+
+func NewElector(config Config) (*Elector, error) {
+	switch {
+	case config.Lock == nil:
+		return nil, errors.New("leader election needs a lock")
+	case config.Identity == "":
+		return nil, errors.New("leader election needs an identity for this replica")
+	case config.RetryPeriod <= 0:
+		return nil, fmt.Errorf("leader election retry period must be more than zero, got %s", config.RetryPeriod)
+	case config.RenewDeadline <= config.RetryPeriod:
+		return nil, fmt.Errorf("leader election renew deadline (%s) must be longer than the retry period (%s)", config.RenewDeadline, config.RetryPeriod)
+	case config.LeaseDuration <= config.RenewDeadline:
+		return nil, fmt.Errorf("leader election lease duration (%s) must be longer than the renew deadline (%s)", config.LeaseDuration, config.RenewDeadline)
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	return &Elector{config: config, now: time.Now}, nil
+}
+
+// IsLeader says whether this replica is leading.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// Run campaigns for the lease until stop is closed. Each time this
+// replica takes the lease, started is called; once it loses the
+// lease, stopped is called, and it campaigns again. If it's leading
+// when stop is closed, it calls stopped, then gives up the lease so
+// another replica can take over straight away.
+func (e *Elector) Run(stop <-chan struct{}, started, stopped func()) {
+	for {
+		if !e.acquire(stop) {
+			return
+		}
+		e.setLeading(true)
+		e.config.Logger.Log("leader", e.config.Identity, "lock", e.config.Lock.Describe())
+		e.event(ReasonBecameLeader, fmt.Sprintf("%s became leader", e.config.Identity))
+		started()
+
+		stopping := e.renew(stop)
+		e.setLeading(false)
+		stopped()
+		e.config.Logger.Log("stopped-leading", e.config.Identity)
+		e.event(ReasonStoppedLeading, fmt.Sprintf("%s stopped leading", e.config.Identity))
+		if stopping {
+			e.release()
+			return
+		}
+	}
+}
+
+// acquire tries to take the lease every retry period, until it does
+// (giving true) or stop is closed (giving false).
+func (e *Elector) acquire(stop <-chan struct{}) bool {
+	for {
+		if e.tryAcquireOrRenew() {
+			return true
+		}
+		select {
+		case <-stop:
+			return false
+		case <-time.After(e.config.RetryPeriod):
+		}
+	}
+}
+
+// renew renews the lease every retry period, until it's not been
+// renewed for the renew deadline or another replica has taken it
+// (giving false), or stop is closed (giving true).
+func (e *Elector) renew(stop <-chan struct{}) bool {
+	renewed := e.now()
+	for {
+		select {
+		case <-stop:
+			return true
+		case <-time.After(e.config.RetryPeriod):
+		}
+		switch {
+		case e.tryAcquireOrRenew():
+			renewed = e.now()
+		case e.observed.HolderIdentity != e.config.Identity,
+			e.now().Sub(renewed) >= e.config.RenewDeadline:
+			return false
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it's free or has run out, or
+// renews it if it's this replica's already. It says whether this
+// replica holds the lease afterwards.
+func (e *Elector) tryAcquireOrRenew() bool {
+	now := e.now()
+	record := Record{
+		HolderIdentity:       e.config.Identity,
+		LeaseDurationSeconds: int(e.config.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	current, err := e.config.Lock.Get()
+	switch {
+	case err == ErrNoRecord:
+		if err := e.config.Lock.Create(record); err != nil {
+			e.logError("creating", err)
+			return false
+		}
+		e.observe(record, now)
+		return true
+	case err != nil:
+		e.logError("getting", err)
+		return false
+	}
+
+	if !current.same(e.observed) {
+		if current.HolderIdentity != e.observed.HolderIdentity {
+			e.config.Logger.Log("observed-leader", current.HolderIdentity)
+		}
+		e.observe(current, now)
+	}
+	held := current.HolderIdentity != "" && current.HolderIdentity != e.config.Identity
+	if held && e.observedTime.Add(e.config.LeaseDuration).After(now) {
+		return false
+	}
+
+	if current.HolderIdentity == e.config.Identity {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	if err := e.config.Lock.Update(record); err != nil {
+		e.logError("updating", err)
+		return false
+	}
+	e.observe(record, now)
+	return true
+}
+
+// release gives up the lease, by making it run out straight away.
+func (e *Elector) release() {
+	if e.observed.HolderIdentity != e.config.Identity {
+		return
+	}
+	record := e.observed
+	record.HolderIdentity = ""
+	record.RenewTime = e.now()
+	if err := e.config.Lock.Update(record); err != nil {
+		e.logError("releasing", err)
+		return
+	}
+	e.observe(record, e.now())
+}
+
+func (e *Elector) observe(record Record, at time.Time) {
+	e.observed = record
+	e.observedTime = at
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.mu.Lock()
+	e.leading = leading
+	e.mu.Unlock()
+	if leading {
+		isLeader.Set(1)
+	} else {
+		isLeader.Set(0)
+	}
+}
+
+func (e *Elector) event(reason, message string) {
+	if e.config.Events == nil {
+		return
+	}
+	if err := e.config.Events.Event(reason, message); err != nil {
+		e.config.Logger.Log("event", reason, "err", err)
+	}
+}
+
+// logError logs errors other than conflicts, which are expected now
+// and then when replicas race for the lease.
+func (e *Elector) logError(doing string, err error) {
+	if err == ErrConflict {
+		return
+	}
+	e.config.Logger.Log("lock", e.config.Lock.Describe(), "doing", doing, "err", err)
+}
@@ -0,0 +1,239 @@
+package leader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memLock keeps the record in memory, versioned as the API server
+// would, so that updates from a stale read conflict.
+type memLock struct {
+	mu      sync.Mutex
+	record  *Record
+	version int
+	broken  bool // fail every call, as if unreachable
+
+	// what each replica last read, by identity
+	read map[string]int
+}
+
+type replicaLock struct {
+	*memLock
+	identity string
+}
+
+func (l replicaLock) Get() (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.broken {
+		return Record{}, errors.New("unreachable")
+	}
+	if l.record == nil {
+		return Record{}, ErrNoRecord
+	}
+	l.read[l.identity] = l.version
+	return *l.record, nil
+}
+
+func (l replicaLock) Create(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.broken {
+		return errors.New("unreachable")
+	}
+	if l.record != nil {
+		return ErrConflict
+	}
+	l.record = &r
+	l.version++
+	l.read[l.identity] = l.version
+	return nil
+}
+
+func (l replicaLock) Update(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.broken {
+		return errors.New("unreachable")
+	}
+	if l.read[l.identity] != l.version {
+		return ErrConflict
+	}
+	l.record = &r
+	l.version++
+	l.read[l.identity] = l.version
+	return nil
+}
+
+func (l replicaLock) Describe() string {
+	return "memory"
+}
+
+func (l *memLock) setBroken(broken bool) {
+	l.mu.Lock()
+	l.broken = broken
+	l.mu.Unlock()
+}
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) Event(reason, message string) error {
+	r.mu.Lock()
+	r.events = append(r.events, reason+": "+message)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *eventRecorder) all() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+type replica struct {
+	elector *Elector
+	stop    chan struct{}
+	done    chan struct{}
+	leading chan bool
+}
+
+func startReplica(t *testing.T, lock *memLock, identity string, events EventRecorder) *replica {
+	e, err := NewElector(Config{
+		Lock:          replicaLock{lock, identity},
+		Identity:      identity,
+		LeaseDuration: 400 * time.Millisecond,
+		RenewDeadline: 200 * time.Millisecond,
+		RetryPeriod:   20 * time.Millisecond,
+		Events:        events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &replica{
+		elector: e,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		leading: make(chan bool, 10),
+	}
+	go func() {
+		e.Run(r.stop, func() { r.leading <- true }, func() { r.leading <- false })
+		close(r.done)
+	}()
+	return r
+}
+
+func expectLeading(t *testing.T, r *replica, leading bool, within time.Duration) {
+	select {
+	case got := <-r.leading:
+		if got != leading {
+			t.Fatalf("expected leading to be %v, got %v", leading, got)
+		}
+	case <-time.After(within):
+		t.Fatalf("expected leading to be %v within %s", leading, within)
+	}
+}
+
+func expectNoChange(t *testing.T, r *replica, during time.Duration) {
+	select {
+	case got := <-r.leading:
+		t.Fatalf("expected no change in leadership, got leading %v", got)
+	case <-time.After(during):
+	}
+}
+
+func TestElection(t *testing.T) {
+	lock := &memLock{read: map[string]int{}}
+	events := &eventRecorder{}
+
+	a := startReplica(t, lock, "a", events)
+	expectLeading(t, a, true, time.Second)
+	b := startReplica(t, lock, "b", events)
+	// The leader keeps renewing, well past the lease duration
+	expectNoChange(t, b, time.Second)
+	if !a.elector.IsLeader() || b.elector.IsLeader() {
+		t.Fatal("expected only a to be leading")
+	}
+
+	// Stopping the leader gives up the lease, so the standby takes
+	// over without waiting for it to run out
+	stopped := time.Now()
+	close(a.stop)
+	expectLeading(t, a, false, time.Second)
+	<-a.done
+	expectLeading(t, b, true, time.Second)
+	if took := time.Since(stopped); took >= 400*time.Millisecond {
+		t.Errorf("expected b to take over before the lease ran out, took %s", took)
+	}
+
+	close(b.stop)
+	expectLeading(t, b, false, time.Second)
+	<-b.done
+
+	expected := []string{
+		"BecameLeader: a became leader",
+		"StoppedLeading: a stopped leading",
+		"BecameLeader: b became leader",
+		"StoppedLeading: b stopped leading",
+	}
+	got := events.all()
+	if len(got) != len(expected) {
+		t.Fatalf("expected events %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected events %q, got %q", expected, got)
+			break
+		}
+	}
+	if lock.record.LeaderTransitions != 1 {
+		t.Errorf("expected one transition of leadership, got %d", lock.record.LeaderTransitions)
+	}
+}
+
+func TestElectionLeaderCannotRenew(t *testing.T) {
+	lock := &memLock{read: map[string]int{}}
+	a := startReplica(t, lock, "a", nil)
+	defer close(a.stop)
+	expectLeading(t, a, true, time.Second)
+
+	// The leader gives up once it's failed to renew for the deadline,
+	// before the lease runs out
+	lock.setBroken(true)
+	broken := time.Now()
+	expectLeading(t, a, false, time.Second)
+	if took := time.Since(broken); took >= 400*time.Millisecond {
+		t.Errorf("expected a to stop leading before the lease ran out, took %s", took)
+	}
+
+	// Once the lock is reachable again, one replica or the other
+	// takes the lease
+	b := startReplica(t, lock, "b", nil)
+	defer close(b.stop)
+	lock.setBroken(false)
+	select {
+	case <-a.leading:
+	case <-b.leading:
+	case <-time.After(time.Second):
+		t.Fatal("expected a replica to take the lease once it was reachable again")
+	}
+}
+
+func TestNewElector(t *testing.T) {
+	lock := replicaLock{&memLock{read: map[string]int{}}, "a"}
+	for _, config := range []Config{
+		{Identity: "a", LeaseDuration: 3 * time.Second, RenewDeadline: 2 * time.Second, RetryPeriod: time.Second},
+		{Lock: lock, LeaseDuration: 3 * time.Second, RenewDeadline: 2 * time.Second, RetryPeriod: time.Second},
+		{Lock: lock, Identity: "a", LeaseDuration: 3 * time.Second, RenewDeadline: 2 * time.Second},
+		{Lock: lock, Identity: "a", LeaseDuration: 3 * time.Second, RenewDeadline: time.Second, RetryPeriod: time.Second},
+		{Lock: lock, Identity: "a", LeaseDuration: 2 * time.Second, RenewDeadline: 2 * time.Second, RetryPeriod: time.Second},
+	} {
+		if _, err := NewElector(config); err == nil {
+			t.Errorf("expected %+v to be refused", config)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package flux
+
+// The names of the checks made when linting the resource definitions
+// in a config repo.
+const (
+	LintMissingLimits      = "missing-limits"
+	LintDeprecatedVersion  = "deprecated-api-version"
+	LintInvalidSelector    = "invalid-selector"
+	LintUnparseableFile    = "unparseable"
+	LintDeprecatedResource = "deprecated-resource"
+)
+
+// LintReport is the result of linting the resource definitions at a
+// particular revision of the config repo.
+type LintReport struct {
+	Revision string        `json:"revision"`
+	Problems []LintProblem `json:"problems"`
+}
+
+// LintProblem is a single thing found wrong with a resource
+// definition. File is relative to the config repo path.
+type LintProblem struct {
+	File    string `json:"file"`
+	Kind    string `json:"kind,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
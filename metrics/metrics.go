@@ -9,6 +9,8 @@ const (
 	LabelMethod  = "method"
 	LabelSuccess = "success"
 
+	LabelInstanceID = "instance_id"
+
 	// Labels for release metrics
 	LabelAction      = "action"
 	LabelReleaseType = "release_type"
@@ -7,7 +7,7 @@ import (
 
 // Release performs post-release notifications for an instance
 func Release(cfg instance.Config, r flux.Release, releaseError error) error {
-	if r.Spec.Kind != flux.ReleaseKindExecute {
+	if r.Spec.Kind == flux.ReleaseKindPlan {
 		return nil
 	}
 
@@ -19,3 +19,12 @@ func Release(cfg instance.Config, r flux.Release, releaseError error) error {
 	}
 	return err
 }
+
+// Warning sends a warning about an instance, e.g., that something
+// seems to be broken, to wherever its notifications go.
+func Warning(cfg instance.Config, text string) error {
+	if cfg.Settings.Slack.HookURL == "" {
+		return nil
+	}
+	return notify(cfg.Settings.Slack, text)
+}
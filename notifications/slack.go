@@ -14,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/webhook"
 )
 
 const (
@@ -61,10 +62,16 @@ func notify(config flux.NotifierConfig, text string) error {
 		return errors.Wrap(err, "encoding Slack POST request")
 	}
 
-	req, err := http.NewRequest("POST", config.HookURL, buf)
+	body := buf.Bytes()
+	req, err := http.NewRequest("POST", config.HookURL, bytes.NewReader(body))
 	if err != nil {
 		return errors.Wrap(err, "constructing Slack HTTP request")
 	}
+	if config.Secret != "" {
+		if err := webhook.Sign(req, body, config.Secret); err != nil {
+			return errors.Wrap(err, "signing Slack HTTP request")
+		}
+	}
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "executing HTTP POST to Slack")
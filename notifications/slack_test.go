@@ -8,8 +8,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/webhook"
 )
 
 func TestSlackNotifier(t *testing.T) {
@@ -122,3 +124,23 @@ func TestSlackNotifierErrorHandling(t *testing.T) {
 		t.Fatalf("Expected error back: %q, got %q", expected, err.Error())
 	}
 }
+
+func TestSlackNotifierSigned(t *testing.T) {
+	verifier := webhook.NewVerifier("s3cr3t", time.Minute)
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = verifier.Verify(r)
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	if err := slackNotifyRelease(flux.NotifierConfig{
+		HookURL: server.URL,
+		Secret:  "s3cr3t",
+	}, exampleRelease(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	if verifyErr != nil {
+		t.Errorf("expected the request to verify, got %v", verifyErr)
+	}
+}
@@ -0,0 +1,249 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+// A daemon whose connection is flapping (e.g., it's being restarted
+// over and over, or its network is poor) makes every call to it wait
+// for the RPC timeout before failing. To save API calls from waiting
+// on it, each instance has a circuit breaker: once a number of calls
+// in a row have failed to reach the daemon, calls fail straight away
+// for a while. After that, one call is let through to try again; if
+// it gets to the daemon, calls go through as usual, and if not, the
+// breaker stays open for another while. A daemon connecting afresh
+// closes the breaker.
+//
+// Only failures to reach the daemon count; errors the daemon itself
+// reports (ClusterError) show it's there.
+
+// BreakerOpen is the error for calls not made because the breaker is
+// open. It's given as is by BreakerBus.Ping, and as an
+// UnavailableError from platform calls.
+type BreakerOpen struct {
+	Failures int
+	Until    time.Time
+}
+
+func (e BreakerOpen) Error() string {
+	return fmt.Sprintf("daemon unavailable: %d calls in a row failed to reach it; not trying again until %s", e.Failures, e.Until.UTC().Format(time.RFC3339))
+}
+
+// BreakerOpenFrom gives the BreakerOpen error that err is, or
+// has been made from.
+func BreakerOpenFrom(err error) (BreakerOpen, bool) {
+	err = errors.Cause(err)
+	if p, ok := err.(flux.UserConfigProblem); ok && p.BaseError != nil {
+		err = p.Err
+	}
+	open, ok := err.(BreakerOpen)
+	return open, ok
+}
+
+// IsUnavailable says whether the error is from failing to reach the
+// daemon.
+func IsUnavailable(err error) bool {
+	switch err := errors.Cause(err).(type) {
+	case flux.UserConfigProblem:
+		return err.BaseError != nil && err.Help == unavailableHelp
+	case FatalError:
+		return true
+	}
+	return false
+}
+
+// BreakerBus is a MessageBus with a circuit breaker for each
+// instance's daemon.
+type BreakerBus struct {
+	MessageBus
+	// failures is how many calls in a row must fail to reach the
+	// daemon for the breaker to open; cooldown is how long it stays
+	// open before a call is let through to try again.
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	breakers map[flux.InstanceID]*breaker
+}
+
+func NewBreakerBus(bus MessageBus, failures int, cooldown time.Duration) *BreakerBus {
+	return &BreakerBus{
+		MessageBus: bus,
+		failures:   failures,
+		cooldown:   cooldown,
+		now:        time.Now,
+		breakers:   map[flux.InstanceID]*breaker{},
+	}
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	trying    bool // whether a call is trying again, having been let through
+}
+
+func (b *BreakerBus) breakerFor(inst flux.InstanceID) *breaker {
+	br, ok := b.breakers[inst]
+	if !ok {
+		br = &breaker{}
+		b.breakers[inst] = br
+	}
+	return br
+}
+
+// allow says whether a call to the daemon may be made, returning
+// BreakerOpen if not.
+func (b *BreakerBus) allow(inst flux.InstanceID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.breakerFor(inst)
+	if br.failures < b.failures {
+		return nil
+	}
+	if br.trying || b.now().Before(br.openUntil) {
+		return BreakerOpen{Failures: br.failures, Until: br.openUntil}
+	}
+	br.trying = true
+	return nil
+}
+
+// record records the outcome of a call to the daemon.
+func (b *BreakerBus) record(inst flux.InstanceID, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.breakerFor(inst)
+	br.trying = false
+	if !IsUnavailable(err) {
+		br.failures = 0
+		return
+	}
+	br.failures++
+	if br.failures >= b.failures {
+		if br.failures == b.failures {
+			breakerTrips.Add(1)
+		}
+		br.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Ping gives BreakerOpen if the instance's breaker is open, without
+// asking the message bus. Pings don't count towards opening the
+// breaker, since a daemon can be present on the bus but not answering.
+func (b *BreakerBus) Ping(inst flux.InstanceID) error {
+	b.mu.Lock()
+	br, ok := b.breakers[inst]
+	if ok && br.failures >= b.failures && b.now().Before(br.openUntil) {
+		open := BreakerOpen{Failures: br.failures, Until: br.openUntil}
+		b.mu.Unlock()
+		return open
+	}
+	b.mu.Unlock()
+	return b.MessageBus.Ping(inst)
+}
+
+// Subscribe closes the breaker for the instance, since its daemon has
+// connected afresh, then subscribes the daemon as usual.
+func (b *BreakerBus) Subscribe(inst flux.InstanceID, p Platform, done chan<- error) {
+	b.mu.Lock()
+	delete(b.breakers, inst)
+	b.mu.Unlock()
+	b.MessageBus.Subscribe(inst, p, done)
+}
+
+func (b *BreakerBus) Connect(inst flux.InstanceID) (Platform, error) {
+	p, err := b.MessageBus.Connect(inst)
+	if err != nil {
+		return nil, err
+	}
+	return &breakerPlatform{p: p, bus: b, inst: inst}, nil
+}
+
+type breakerPlatform struct {
+	p    Platform
+	bus  *BreakerBus
+	inst flux.InstanceID
+}
+
+func (b *breakerPlatform) WithTrace(ctx context.Context) Platform {
+	return &breakerPlatform{p: WithTrace(ctx, b.p), bus: b.bus, inst: b.inst}
+}
+
+// call makes the call given if the breaker allows it, and records
+// the outcome.
+func (b *breakerPlatform) call(f func() error) error {
+	if err := b.bus.allow(b.inst); err != nil {
+		return UnavailableError(err)
+	}
+	err := f()
+	b.bus.record(b.inst, err)
+	return err
+}
+
+func (b *breakerPlatform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) (ss []Service, err error) {
+	err = b.call(func() (err error) {
+		ss, err = b.p.AllServices(maybeNamespace, ignored)
+		return err
+	})
+	return ss, err
+}
+
+func (b *breakerPlatform) SomeServices(ids []flux.ServiceID) (ss []Service, err error) {
+	err = b.call(func() (err error) {
+		ss, err = b.p.SomeServices(ids)
+		return err
+	})
+	return ss, err
+}
+
+func (b *breakerPlatform) Apply(defs []ServiceDefinition) error {
+	return b.call(func() error { return b.p.Apply(defs) })
+}
+
+func (b *breakerPlatform) Ping() error {
+	return b.call(b.p.Ping)
+}
+
+func (b *breakerPlatform) Version() (v string, err error) {
+	err = b.call(func() (err error) {
+		v, err = b.p.Version()
+		return err
+	})
+	return v, err
+}
+
+func (b *breakerPlatform) Export() (config []byte, err error) {
+	err = b.call(func() (err error) {
+		config, err = b.p.Export()
+		return err
+	})
+	return config, err
+}
+
+func (b *breakerPlatform) ExportTo(w io.Writer) error {
+	return b.call(func() error { return b.p.ExportTo(w) })
+}
+
+func (b *breakerPlatform) Sync(def SyncDef) error {
+	return b.call(func() error { return b.p.Sync(def) })
+}
+
+func (b *breakerPlatform) Trace(d time.Duration) error {
+	return b.call(func() error { return b.p.Trace(d) })
+}
+
+func (b *breakerPlatform) RegistryCredentials() (creds map[string]flux.Auth, err error) {
+	err = b.call(func() (err error) {
+		creds, err = b.p.RegistryCredentials()
+		return err
+	})
+	return creds, err
+}
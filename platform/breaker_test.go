@@ -0,0 +1,98 @@
+package platform
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestBreaker(t *testing.T) {
+	inst := flux.InstanceID("instance")
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	bus := NewBreakerBus(NewStandaloneMessageBus(BusMetricsImpl), 2, time.Minute)
+	bus.now = func() time.Time { return now }
+
+	p := &MockPlatform{VersionError: UnavailableError(errors.New("timeout"))}
+	done := make(chan error, 1)
+	bus.Subscribe(inst, p, done)
+	remote, err := bus.Connect(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from the daemon don't count
+	p.VersionError = ClusterError(errors.New("no such namespace"))
+	remote.Version()
+	p.VersionError = UnavailableError(errors.New("timeout"))
+	if _, err := remote.Version(); !IsUnavailable(err) {
+		t.Fatalf("expected the daemon's error, got %v", err)
+	}
+	if _, ok := BreakerOpenFrom(err); ok {
+		t.Fatal("expected the breaker not to be open after one failure")
+	}
+	remote.Version()
+
+	// Now open
+	p.VersionError = nil
+	_, err = remote.Version()
+	open, ok := BreakerOpenFrom(err)
+	if !ok {
+		t.Fatalf("expected the breaker to be open, got %v", err)
+	}
+	if !IsUnavailable(err) {
+		t.Error("expected the breaker's error to be an UnavailableError")
+	}
+	if !open.Until.Equal(now.Add(time.Minute)) || open.Failures != 2 {
+		t.Errorf("unexpected breaker error %+v", open)
+	}
+	if _, ok := bus.Ping(inst).(BreakerOpen); !ok {
+		t.Error("expected ping to say the breaker is open")
+	}
+
+	// After the cooldown, a call is let through, and since it
+	// succeeds, the breaker closes
+	now = now.Add(time.Minute)
+	if _, err := remote.Version(); err != nil {
+		t.Fatalf("expected a call to be let through, got %v", err)
+	}
+	if err := bus.Ping(inst); err != nil {
+		t.Errorf("expected ping to get through, got %v", err)
+	}
+}
+
+func TestBreakerReopens(t *testing.T) {
+	inst := flux.InstanceID("instance")
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	bus := NewBreakerBus(NewStandaloneMessageBus(BusMetricsImpl), 1, time.Minute)
+	bus.now = func() time.Time { return now }
+
+	// No daemon connected, so calls fail to reach it
+	remote, err := bus.Connect(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.Ping()
+	if _, ok := BreakerOpenFrom(remote.Ping()); !ok {
+		t.Fatal("expected the breaker to be open")
+	}
+
+	// The call let through after the cooldown fails too
+	now = now.Add(time.Minute)
+	if err := remote.Ping(); !IsUnavailable(err) {
+		t.Fatalf("expected the call to be let through and fail, got %v", err)
+	}
+	if open, ok := BreakerOpenFrom(remote.Ping()); !ok || !open.Until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the breaker to be open for another minute, got %v", open)
+	}
+
+	// A daemon connecting closes it
+	bus.Subscribe(inst, &MockPlatform{}, make(chan error, 1))
+	if remote, err = bus.Connect(inst); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Ping(); err != nil {
+		t.Errorf("expected the breaker to be closed, got %v", err)
+	}
+}
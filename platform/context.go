@@ -0,0 +1,181 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+// contextPlatform wraps a platform so that calls to it return when a
+// context is done, whether or not the call underneath has.
+type contextPlatform struct {
+	ctx context.Context
+	p   Platform
+}
+
+// WithContext gives a platform whose calls return the context's error
+// once it's done (e.g., when the request being served runs out of
+// time), so that a daemon that's stuck doesn't hold up the caller.
+// The call underneath carries on until it returns by itself; its
+// result is thrown away.
+func WithContext(ctx context.Context, p Platform) Platform {
+	p = WithTrace(ctx, p)
+	if ctx.Done() == nil {
+		// Never done, so never gives up
+		return p
+	}
+	return &contextPlatform{ctx: ctx, p: p}
+}
+
+// tracer is for platforms that can take the trace a call is part of
+// further, e.g., to the daemon; and for those that wrap platforms, so
+// they can pass it on.
+type tracer interface {
+	WithTrace(ctx context.Context) Platform
+}
+
+// WithTrace gives a platform whose calls carry the trace in the
+// context, if the platform can carry it; otherwise, the platform as
+// is.
+func WithTrace(ctx context.Context, p Platform) Platform {
+	if t, ok := p.(tracer); ok {
+		return t.WithTrace(ctx)
+	}
+	return p
+}
+
+// call runs f, returning its error, or the context's if the context
+// is done first. Results set by f must only be read if call returns
+// nil, or an error from f.
+func (c *contextPlatform) call(f func() error) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- f()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c *contextPlatform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) ([]Service, error) {
+	var res []Service
+	if err := c.call(func() (err error) {
+		res, err = c.p.AllServices(maybeNamespace, ignored)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *contextPlatform) SomeServices(ids []flux.ServiceID) ([]Service, error) {
+	var res []Service
+	if err := c.call(func() (err error) {
+		res, err = c.p.SomeServices(ids)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *contextPlatform) Apply(defs []ServiceDefinition) error {
+	return c.call(func() error {
+		return c.p.Apply(defs)
+	})
+}
+
+func (c *contextPlatform) Ping() error {
+	return c.call(c.p.Ping)
+}
+
+func (c *contextPlatform) Version() (string, error) {
+	var res string
+	if err := c.call(func() (err error) {
+		res, err = c.p.Version()
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+func (c *contextPlatform) Export() ([]byte, error) {
+	var res []byte
+	if err := c.call(func() (err error) {
+		res, err = c.p.Export()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *contextPlatform) Sync(def SyncDef) error {
+	return c.call(func() error {
+		return c.p.Sync(def)
+	})
+}
+
+func (c *contextPlatform) Trace(d time.Duration) error {
+	return c.call(func() error {
+		return c.p.Trace(d)
+	})
+}
+
+func (c *contextPlatform) RegistryCredentials() (map[string]flux.Auth, error) {
+	var res map[string]flux.Auth
+	if err := c.call(func() (err error) {
+		res, err = c.p.RegistryCredentials()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExportTo stops the export being written to w once the context is
+// done, since the caller will have moved on.
+func (c *contextPlatform) ExportTo(w io.Writer) error {
+	cw := &cutoffWriter{w: w}
+	err := c.call(func() error {
+		return c.p.ExportTo(cw)
+	})
+	cw.cutoff()
+	return err
+}
+
+var errCutOff = errors.New("gave up waiting for the export")
+
+// cutoffWriter passes writes on until it's cut off, waiting for a
+// write underway to finish.
+type cutoffWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	cut bool
+}
+
+func (w *cutoffWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cut {
+		return 0, errCutOff
+	}
+	return w.w.Write(p)
+}
+
+func (w *cutoffWriter) cutoff() {
+	w.mu.Lock()
+	w.cut = true
+	w.mu.Unlock()
+}
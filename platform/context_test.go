@@ -0,0 +1,73 @@
+package platform
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// stuckPlatform doesn't answer until it's let go.
+type stuckPlatform struct {
+	MockPlatform
+	release chan struct{}
+}
+
+func (p *stuckPlatform) Version() (string, error) {
+	<-p.release
+	return "1.0", nil
+}
+
+func (p *stuckPlatform) ExportTo(w io.Writer) error {
+	<-p.release
+	_, err := w.Write([]byte("too late"))
+	return err
+}
+
+func TestWithContext(t *testing.T) {
+	stuck := &stuckPlatform{release: make(chan struct{})}
+	defer close(stuck.release)
+
+	if p := WithContext(context.Background(), stuck); p != Platform(stuck) {
+		t.Errorf("expected a context that's never done to leave the platform as is")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p := WithContext(ctx, stuck)
+	if _, err := p.Version(); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	// Once the context is done, calls don't even start
+	if err := p.Ping(); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.ExportTo(&buf); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestWithContextAnswered(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	p := WithContext(ctx, &MockPlatform{VersionAnswer: "1.0"})
+	if v, err := p.Version(); err != nil || v != "1.0" {
+		t.Errorf("expected the platform's answer, got %q, %v", v, err)
+	}
+}
+
+func TestCutoffWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &cutoffWriter{w: &buf}
+	w.Write([]byte("before"))
+	w.cutoff()
+	if _, err := w.Write([]byte("after")); err != errCutOff {
+		t.Errorf("expected writes after the cutoff to fail, got %v", err)
+	}
+	if buf.String() != "before" {
+		t.Errorf("expected only what was written before the cutoff, got %q", buf.String())
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"github.com/weaveworks/flux"
 )
 
-func UnavailableError(err error) error {
-	return flux.UserConfigProblem{&flux.BaseError{
-		Help: `Cannot contact fluxd
+// unavailableHelp is the help for UnavailableError, by which it's
+// recognised.
+const unavailableHelp = `Cannot contact fluxd
 
 To service this request, we need to ask the agent running in your
 cluster (fluxd) for some information. But we can't connect to it at
@@ -29,8 +29,12 @@ If you are still stuck, please log an issue:
 
     https://github.com/weaveworks/flux/issues
 
-`,
-		Err: err,
+`
+
+func UnavailableError(err error) error {
+	return flux.UserConfigProblem{&flux.BaseError{
+		Help: unavailableHelp,
+		Err:  err,
 	}}
 }
 
@@ -0,0 +1,126 @@
+package platform
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+// InFlight wraps a platform, keeping count of the calls underway to
+// it, so that the connection to a daemon can be closed between calls
+// rather than in the middle of one.
+type InFlight struct {
+	p     Platform
+	calls *callCount
+}
+
+type callCount struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{} // closed when n next gets to zero
+}
+
+func NewInFlight(p Platform) *InFlight {
+	return &InFlight{p: p, calls: &callCount{}}
+}
+
+// Idle gives a channel that's closed once there are no calls
+// underway; straight away, if there are none now. More calls may be
+// made after.
+func (f *InFlight) Idle() <-chan struct{} {
+	c := f.calls
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	return c.idle
+}
+
+func (c *callCount) begin() {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+}
+
+func (c *callCount) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n--
+	if c.n == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+}
+
+func (f *InFlight) WithTrace(ctx context.Context) Platform {
+	return &InFlight{p: WithTrace(ctx, f.p), calls: f.calls}
+}
+
+func (f *InFlight) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) ([]Service, error) {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.AllServices(maybeNamespace, ignored)
+}
+
+func (f *InFlight) SomeServices(ids []flux.ServiceID) ([]Service, error) {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.SomeServices(ids)
+}
+
+func (f *InFlight) Apply(defs []ServiceDefinition) error {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Apply(defs)
+}
+
+func (f *InFlight) Ping() error {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Ping()
+}
+
+func (f *InFlight) Version() (string, error) {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Version()
+}
+
+func (f *InFlight) Export() ([]byte, error) {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Export()
+}
+
+func (f *InFlight) ExportTo(w io.Writer) error {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.ExportTo(w)
+}
+
+func (f *InFlight) Sync(def SyncDef) error {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Sync(def)
+}
+
+func (f *InFlight) Trace(d time.Duration) error {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.Trace(d)
+}
+
+func (f *InFlight) RegistryCredentials() (map[string]flux.Auth, error) {
+	f.calls.begin()
+	defer f.calls.end()
+	return f.p.RegistryCredentials()
+}
@@ -0,0 +1,51 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+	PlatformTestBattery(t, func(p Platform) Platform {
+		return NewInFlight(p)
+	})
+}
+
+func TestInFlightIdle(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p := NewInFlight(&blockingPlatform{MockPlatform{}, started, release})
+
+	select {
+	case <-p.Idle():
+	default:
+		t.Fatal("expected to be idle before any calls")
+	}
+
+	go p.Ping()
+	<-started
+	idle := p.Idle()
+	select {
+	case <-idle:
+		t.Fatal("expected not to be idle with a call underway")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("expected to be idle once the call had returned")
+	}
+}
+
+type blockingPlatform struct {
+	MockPlatform
+	started, release chan struct{}
+}
+
+func (p *blockingPlatform) Ping() error {
+	close(p.started)
+	<-p.release
+	return nil
+}
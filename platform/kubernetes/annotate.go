@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Annotate sets annotations in the metadata of a resource definition,
+// adding the annotations (and the field itself) where they're not
+// there already, and replacing the values of those that are. Unlike
+// the other updates, it may add lines, so it's for definitions that
+// are to be applied, rather than those kept in files.
+func Annotate(def []byte, annotations map[string]string) ([]byte, error) {
+	if len(annotations) == 0 {
+		return def, nil
+	}
+	newline := "\n"
+	str := string(def)
+	if strings.Contains(str, "\r\n") {
+		newline = "\r\n"
+		str = strings.Replace(str, "\r\n", "\n", -1)
+	}
+	lines := strings.Split(str, "\n")
+
+	metadata := -1
+	for _, i := range keyLines(lines, "metadata") {
+		if leadingSpaces(lines[i]) == 0 {
+			metadata = i
+			break
+		}
+	}
+	if metadata < 0 {
+		return nil, errors.New("no metadata in definition")
+	}
+
+	// Find the annotations field, and the existing annotations
+	fieldIndent, field := -1, -1
+	entryIndent, lastEntry := -1, -1
+	entries := map[string]int{}
+	for i := metadata + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := leadingSpaces(lines[i])
+		if fieldIndent < 0 {
+			fieldIndent = indent
+		}
+		if indent < fieldIndent || indent == 0 {
+			break
+		}
+		if indent == fieldIndent {
+			if field >= 0 {
+				break
+			}
+			k, v, ok := splitField(trimmed)
+			if !ok || k != "annotations" {
+				continue
+			}
+			switch v {
+			case "":
+			case "{}":
+				lines[i] = strings.Repeat(" ", indent) + "annotations:"
+			default:
+				return nil, errors.New("annotations are not given as a block, so cannot be updated")
+			}
+			field = i
+			continue
+		}
+		if field < 0 {
+			continue
+		}
+		if entryIndent < 0 {
+			entryIndent = indent
+		}
+		if indent == entryIndent {
+			if k, _, ok := splitField(trimmed); ok {
+				entries[k] = i
+			}
+		}
+		lastEntry = i
+	}
+	if fieldIndent <= 0 {
+		return nil, errors.New("no fields in metadata of definition")
+	}
+	if field < 0 {
+		lines = insertLines(lines, metadata+1, strings.Repeat(" ", fieldIndent)+"annotations:")
+		field = metadata + 1
+	}
+	if entryIndent < 0 {
+		entryIndent = fieldIndent * 2
+	}
+	if lastEntry < 0 {
+		lastEntry = field
+	}
+
+	var keys []string
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line := strings.Repeat(" ", entryIndent) + quoteString(k) + ": " + quoteString(annotations[k])
+		if i, ok := entries[k]; ok {
+			lines[i] = line
+			continue
+		}
+		lastEntry++
+		lines = insertLines(lines, lastEntry, line)
+	}
+	return []byte(strings.Join(lines, newline)), nil
+}
+
+func insertLines(lines []string, at int, add ...string) []string {
+	return append(lines[:at], append(add, lines[at:]...)...)
+}
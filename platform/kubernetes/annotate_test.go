@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	annotations := map[string]string{
+		"flux.weave.works/release-id":   "abc123",
+		"flux.weave.works/release-user": "Bob <bob@example.com>",
+	}
+	for _, c := range []struct {
+		name, def, expected string
+	}{
+		{
+			name: "no annotations",
+			def: `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  replicas: 2
+`,
+			expected: `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  annotations:
+    flux.weave.works/release-id: abc123
+    flux.weave.works/release-user: "Bob <bob@example.com>"
+  name: helloworld
+spec:
+  replicas: 2
+`,
+		},
+		{
+			name: "existing annotations",
+			def: `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+    name: helloworld
+    annotations:
+        owner: team-a
+        flux.weave.works/release-id: old
+    labels:
+        app: helloworld
+spec:
+    template:
+        metadata:
+            annotations:
+                other: thing
+`,
+			expected: `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+    name: helloworld
+    annotations:
+        owner: team-a
+        flux.weave.works/release-id: abc123
+        flux.weave.works/release-user: "Bob <bob@example.com>"
+    labels:
+        app: helloworld
+spec:
+    template:
+        metadata:
+            annotations:
+                other: thing
+`,
+		},
+		{
+			name: "empty annotations",
+			def:  "metadata:\r\n  annotations: {}\r\n  name: helloworld\r\n",
+			expected: "metadata:\r\n  annotations:\r\n    flux.weave.works/release-id: abc123\r\n" +
+				"    flux.weave.works/release-user: \"Bob <bob@example.com>\"\r\n  name: helloworld\r\n",
+		},
+	} {
+		out, err := Annotate([]byte(c.def), annotations)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if string(out) != c.expected {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", c.name, c.expected, out)
+		}
+	}
+
+	for _, def := range []string{
+		"kind: Deployment\nspec:\n  replicas: 1\n",
+		"metadata:\n  name: helloworld\n  annotations: {owner: team-a}\n",
+	} {
+		if _, err := Annotate([]byte(def), annotations); err == nil {
+			t.Errorf("expected error annotating:\n%s", def)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// CopyOfAnnotation marks the copy of a workload made for a
+	// blue/green release, with the name of the original.
+	CopyOfAnnotation = "flux.weave.works/copy-of"
+	// CopyLabel is given to the pods of the copy, so they can be told
+	// apart from those of the original.
+	CopyLabel = "flux.weave.works/copy"
+	// CopyStatusKey is the key in a service's metadata for the status
+	// of the copy of its workload, if there is one.
+	CopyStatusKey = "copy_status"
+
+	copySuffix = "-green"
+	copyLabel  = "green"
+)
+
+// BlueGreenCopy makes, from the definition of a deployment or
+// replication controller, the definition of a copy to run alongside
+// it: it has a name of its own, one replica, and the CopyLabel on its
+// pods (and in its selector, if it has one). Since the pods otherwise
+// have the same labels, the service for the original sends them
+// traffic too.
+//
+// The definition is rewritten, so comments and formatting aren't
+// kept; it's not meant to be edited by people.
+func BlueGreenCopy(def []byte) ([]byte, error) {
+	var obj yaml.MapSlice
+	if err := yaml.Unmarshal(def, &obj); err != nil {
+		return nil, err
+	}
+	kind, _ := mapValue(obj, "kind").(string)
+	if kind != "Deployment" && kind != "ReplicationController" {
+		return nil, fmt.Errorf("can't copy a %s for a blue/green release; only deployments and replication controllers", kind)
+	}
+
+	metadata, ok := mapValue(obj, "metadata").(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("no metadata in definition")
+	}
+	name, _ := mapValue(metadata, "name").(string)
+	if name == "" {
+		return nil, errors.New("no name in definition")
+	}
+	metadata = setMapValue(metadata, "name", name+copySuffix)
+	annotations, _ := mapValue(metadata, "annotations").(yaml.MapSlice)
+	metadata = setMapValue(metadata, "annotations", setMapValue(annotations, CopyOfAnnotation, name))
+	obj = setMapValue(obj, "metadata", metadata)
+
+	spec, ok := mapValue(obj, "spec").(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("no spec in definition")
+	}
+	spec = setMapValue(spec, "replicas", 1)
+
+	template, ok := mapValue(spec, "template").(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("no pod template in definition")
+	}
+	templateMetadata, _ := mapValue(template, "metadata").(yaml.MapSlice)
+	labels, _ := mapValue(templateMetadata, "labels").(yaml.MapSlice)
+	templateMetadata = setMapValue(templateMetadata, "labels", setMapValue(labels, CopyLabel, copyLabel))
+	spec = setMapValue(spec, "template", setMapValue(template, "metadata", templateMetadata))
+
+	// Without a selector, one is made from the template labels, which
+	// now include the label.
+	if selector, ok := mapValue(spec, "selector").(yaml.MapSlice); ok {
+		if kind == "Deployment" {
+			matchLabels, _ := mapValue(selector, "matchLabels").(yaml.MapSlice)
+			selector = setMapValue(selector, "matchLabels", setMapValue(matchLabels, CopyLabel, copyLabel))
+		} else {
+			selector = setMapValue(selector, CopyLabel, copyLabel)
+		}
+		spec = setMapValue(spec, "selector", selector)
+	}
+	obj = setMapValue(obj, "spec", spec)
+
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("# Copy of %s for a blue/green release. Flux removes it when\n# the release finishes; it's not to be edited.\n", name)
+	return append([]byte(header), out...), nil
+}
+
+// IsBlueGreenCopy says whether the definition is of a copy made by
+// BlueGreenCopy.
+func IsBlueGreenCopy(def []byte) bool {
+	var obj struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(def, &obj); err != nil {
+		return false
+	}
+	_, ok := obj.Metadata.Annotations[CopyOfAnnotation]
+	return ok
+}
+
+func mapValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// setMapValue sets the value for the key, adding it at the end if
+// it's not there already, and returns the (possibly new) slice.
+func setMapValue(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range m {
+		if m[i].Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
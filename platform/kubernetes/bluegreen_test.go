@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+	apiext "k8s.io/client-go/1.5/pkg/apis/extensions/v1beta1"
+)
+
+const blueGreenDeployment = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+  namespace: default
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      name: helloworld
+  template:
+    metadata:
+      labels:
+        name: helloworld
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:v2
+`
+
+func TestBlueGreenCopy(t *testing.T) {
+	def, err := BlueGreenCopy([]byte(blueGreenDeployment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsBlueGreenCopy(def) {
+		t.Error("expected the copy to be recognised as one")
+	}
+	if IsBlueGreenCopy([]byte(blueGreenDeployment)) {
+		t.Error("expected the original not to be recognised as a copy")
+	}
+
+	var copied struct {
+		Metadata struct {
+			Name        string
+			Namespace   string
+			Annotations map[string]string
+		}
+		Spec struct {
+			Replicas int
+			Selector struct {
+				MatchLabels map[string]string `yaml:"matchLabels"`
+			}
+			Template struct {
+				Metadata struct {
+					Labels map[string]string
+				}
+			}
+		}
+	}
+	if err := yaml.Unmarshal(def, &copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.Metadata.Name != "helloworld-green" || copied.Metadata.Namespace != "default" {
+		t.Errorf("expected default/helloworld-green, got %s/%s", copied.Metadata.Namespace, copied.Metadata.Name)
+	}
+	if copied.Metadata.Annotations[CopyOfAnnotation] != "helloworld" {
+		t.Errorf("expected the copy to be annotated with the original, got %v", copied.Metadata.Annotations)
+	}
+	if copied.Spec.Replicas != 1 {
+		t.Errorf("expected one replica, got %d", copied.Spec.Replicas)
+	}
+	for _, labels := range []map[string]string{copied.Spec.Selector.MatchLabels, copied.Spec.Template.Metadata.Labels} {
+		if labels["name"] != "helloworld" || labels[CopyLabel] != copyLabel {
+			t.Errorf("expected the original labels and the copy label, got %v", labels)
+		}
+	}
+
+	images, err := ContainerImages(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images["greeter"] != "quay.io/weaveworks/helloworld:v2" {
+		t.Errorf("expected the copy to have the same image, got %v", images)
+	}
+
+	if _, err := BlueGreenCopy([]byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: helloworld\n")); err == nil {
+		t.Error("expected an error copying a service")
+	}
+}
+
+func TestMatchControllerIgnoresCopy(t *testing.T) {
+	labels := map[string]string{"name": "helloworld"}
+	copyLabels := map[string]string{"name": "helloworld", CopyLabel: copyLabel}
+	original := podController{Deployment: &apiext.Deployment{Spec: apiext.DeploymentSpec{Template: v1.PodTemplateSpec{ObjectMeta: v1.ObjectMeta{Labels: labels}}}}}
+	copied := podController{Deployment: &apiext.Deployment{Spec: apiext.DeploymentSpec{Template: v1.PodTemplateSpec{ObjectMeta: v1.ObjectMeta{Labels: copyLabels}}}}}
+	service := &v1.Service{Spec: v1.ServiceSpec{Selector: labels}}
+
+	pc, err := matchController(service, []podController{original, copied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Deployment != original.Deployment {
+		t.Error("expected the original to be matched, not the copy")
+	}
+}
@@ -3,12 +3,16 @@ package kubernetes
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/pkg/errors"
+
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform"
 )
 
 // FindDefinedServices finds all the services defined under the
@@ -57,6 +61,55 @@ func FindDefinedServices(path string) (map[flux.ServiceID][]string, error) {
 	return services, nil
 }
 
+// SyncDefFromFiles makes a sync definition that applies every
+// resource defined in the files under the directory given. Namespaces
+// are applied first, so that resources in them can be created. Each
+// resource is labelled `namespace:kind/name` (with `<cluster>` for
+// the namespace of Namespaces).
+func SyncDefFromFiles(path string) (platform.SyncDef, error) {
+	var namespaces, others []platform.SyncAction
+	err := filepath.Walk(path, func(target string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(target); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		def, err := ioutil.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		for _, doc := range documentSeparator.Split(string(def), -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			obj, err := definitionObj([]byte(doc))
+			if err != nil {
+				return errors.Wrapf(err, "parsing %s", target)
+			}
+			if obj.Kind == "" {
+				continue
+			}
+			if obj.Kind == "Namespace" {
+				namespaces = append(namespaces, platform.SyncAction{
+					ResourceID: "<cluster>:namespace/" + obj.Metadata.Name,
+					Apply:      platform.ResourceDef(doc),
+				})
+				continue
+			}
+			others = append(others, platform.SyncAction{
+				ResourceID: fmt.Sprintf("%s:%s/%s", namespaceOrDefault(obj), strings.ToLower(obj.Kind), obj.Metadata.Name),
+				Apply:      platform.ResourceDef(doc),
+			})
+		}
+		return nil
+	})
+	return platform.SyncDef{Actions: append(namespaces, others...)}, err
+}
+
 func findBinary(name string) (string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -1,6 +1,8 @@
 package kubernetes
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -24,3 +26,49 @@ func TestDefinedServices(t *testing.T) {
 		t.Errorf("Got unexpected result: %#v", services)
 	}
 }
+
+func TestSyncDefFromFiles(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	if err := testfiles.WriteTestFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	extra := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: extra-config
+  namespace: extra
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: extra
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "extra.yml"), []byte(extra), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := SyncDefFromFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, action := range def.Actions {
+		ids = append(ids, action.ResourceID)
+	}
+	expected := []string{
+		"<cluster>:namespace/extra",
+		"extra:configmap/extra-config",
+		"default:deployment/helloworld",
+		"default:service/helloworld",
+		"default:deployment/locked-service",
+		"default:service/locked-service",
+		"default:deployment/test-service",
+		"default:service/test-service",
+	}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("expected resources:\n%#v\ngot:\n%#v", expected, ids)
+	}
+}
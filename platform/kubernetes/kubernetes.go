@@ -7,6 +7,9 @@ package kubernetes
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"sort"
+	"time"
 
 	k8syaml "github.com/ghodss/yaml"
 	"github.com/go-kit/kit/log"
@@ -86,29 +89,38 @@ type Applier interface {
 // Cluster is a handle to a Kubernetes API server.
 // (Typically, this code is deployed into the same cluster.)
 type Cluster struct {
-	config  *rest.Config
-	client  extendedClient
-	applier Applier
-	actionc chan func()
-	version string // string response for the version command.
-	logger  log.Logger
+	config     *rest.Config
+	client     extendedClient
+	applier    Applier
+	namespaces NamespacePolicy
+	// substitutions, if not nil, has the values of variables in
+	// resources synced
+	substitutions *Substitutions
+	// pullSecretNamespaces are the namespaces in which to look for
+	// registry credentials in image pull secrets
+	pullSecretNamespaces []string
+	actionc              chan func()
+	version              string // string response for the version command.
+	logger               log.Logger
 }
 
 // NewCluster returns a usable cluster. Host should be of the form
-// "http://hostname:8080".
-func NewCluster(config *rest.Config, applier Applier, version string, logger log.Logger) (*Cluster, error) {
+// "http://hostname:8080". The namespace policy says what to do about
+// resources synced into namespaces that don't exist.
+func NewCluster(config *rest.Config, applier Applier, namespaces NamespacePolicy, version string, logger log.Logger) (*Cluster, error) {
 	client, err := k8sclient.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Cluster{
-		config:  config,
-		client:  extendedClient{client.Discovery(), client.Core(), client.Extensions()},
-		applier: applier,
-		actionc: make(chan func()),
-		version: version,
-		logger:  logger,
+		config:     config,
+		client:     extendedClient{client.Discovery(), client.Core(), client.Extensions()},
+		applier:    applier,
+		namespaces: namespaces,
+		actionc:    make(chan func()),
+		version:    version,
+		logger:     logger,
 	}
 	go c.loop()
 	return c, nil
@@ -208,6 +220,7 @@ func (c *Cluster) makeService(ns string, service *v1.Service, controllers []podC
 		ID:       id,
 		IP:       service.Spec.ClusterIP,
 		Metadata: metadataForService(service),
+		Labels:   service.Labels,
 	}
 
 	pc, err := matchController(service, controllers)
@@ -218,6 +231,13 @@ func (c *Cluster) makeService(ns string, service *v1.Service, controllers []podC
 		svc.Containers = platform.ContainersOrExcuse{Containers: pc.templateContainers()}
 		svc.Status = pc.status()
 	}
+	// The copy of the workload made for a blue/green release, if
+	// there is one, is matched by the selector as well.
+	for _, c := range controllers {
+		if c.isCopy() && len(service.Spec.Selector) > 0 && c.matchedBy(service.Spec.Selector) {
+			svc.Metadata[CopyStatusKey] = c.status()
+		}
+	}
 
 	return svc
 }
@@ -255,7 +275,9 @@ func (c *Cluster) podControllersInNamespace(namespace string) (res []podControll
 	return res, nil
 }
 
-// Find the pod controller (deployment or replication controller) that matches the service
+// Find the pod controller (deployment or replication controller) that
+// matches the service. Copies made for blue/green releases don't
+// count.
 func matchController(service *v1.Service, controllers []podController) (podController, error) {
 	selector := service.Spec.Selector
 	if len(selector) == 0 {
@@ -264,7 +286,7 @@ func matchController(service *v1.Service, controllers []podController) (podContr
 
 	var matching []podController
 	for _, c := range controllers {
-		if c.matchedBy(selector) {
+		if !c.isCopy() && c.matchedBy(selector) {
 			matching = append(matching, c)
 		}
 	}
@@ -320,6 +342,13 @@ func (p podController) matchedBy(selector map[string]string) bool {
 	return true
 }
 
+// isCopy says whether the pod controller is a copy made for a
+// blue/green release.
+func (p podController) isCopy() bool {
+	_, ok := p.templateLabels()[CopyLabel]
+	return ok
+}
+
 // Determine a status for the service by looking at the rollout status
 // for the deployment or replication controller.
 func (p podController) status() string {
@@ -359,8 +388,14 @@ func (c *Cluster) Sync(spec platform.SyncDef) error {
 	errc := make(chan error)
 	logger := log.NewContext(c.logger).With("method", "Sync")
 	c.actionc <- func() {
-		errs := platform.SyncError{}
-		for _, action := range spec.Actions {
+		actions, errs := c.substitute(spec.Actions)
+		for id, err := range c.checkNamespaces(logger, actions) {
+			errs[id] = err
+		}
+		for _, action := range actions {
+			if _, failed := errs[action.ResourceID]; failed {
+				continue
+			}
 			if len(action.Delete) > 0 {
 				obj, err := definitionObj(action.Delete)
 				if err == nil {
@@ -384,6 +419,7 @@ func (c *Cluster) Sync(spec platform.SyncDef) error {
 		}
 		if len(errs) > 0 {
 			errc <- errs
+			return
 		}
 		errc <- nil
 	}
@@ -426,61 +462,127 @@ func (c *Cluster) Version() (string, error) {
 	return c.version, nil
 }
 
+// Trace is not supported by the cluster itself; fluxd wraps it with
+// platform.Traced, which supplies trace mode.
+func (c *Cluster) Trace(time.Duration) error {
+	return errors.New("trace mode is not supported without platform.Traced")
+}
+
 func (c *Cluster) Export() ([]byte, error) {
+	var config bytes.Buffer
+	if err := c.ExportTo(&config); err != nil {
+		return nil, err
+	}
+	return config.Bytes(), nil
+}
+
+// ExportTo writes each resource as soon as it's been got, so that
+// the export of a big cluster can be sent as it goes.
+func (c *Cluster) ExportTo(w io.Writer) error {
+	// Each resource is marshalled into the buffer, then written out
 	var config bytes.Buffer
 	list, err := c.client.Namespaces().List(api.ListOptions{})
 	if err != nil {
-		return nil, errors.Wrap(err, "getting namespaces")
+		return errors.Wrap(err, "getting namespaces")
 	}
+	// Everything is exported in order of name, so that exporting the
+	// same resources gives the same result.
+	sortByName(len(list.Items), func(i int) string { return list.Items[i].Name }, func(i, j int) {
+		list.Items[i], list.Items[j] = list.Items[j], list.Items[i]
+	})
 	for _, ns := range list.Items {
 		err := appendYAML(&config, "v1", "Namespace", ns)
 		if err != nil {
-			return nil, errors.Wrap(err, "marshalling namespace to YAML")
+			return errors.Wrap(err, "marshalling namespace to YAML")
+		}
+		if _, err := config.WriteTo(w); err != nil {
+			return err
 		}
 
 		deployments, err := c.client.Deployments(ns.Name).List(api.ListOptions{})
 		if err != nil {
-			return nil, errors.Wrap(err, "getting deployments")
+			return errors.Wrap(err, "getting deployments")
 		}
+		sortByName(len(deployments.Items), func(i int) string { return deployments.Items[i].Name }, func(i, j int) {
+			deployments.Items[i], deployments.Items[j] = deployments.Items[j], deployments.Items[i]
+		})
 		for _, deployment := range deployments.Items {
 			if isAddon(&deployment) {
 				continue
 			}
 			err := appendYAML(&config, "extensions/v1beta1", "Deployment", deployment)
 			if err != nil {
-				return nil, errors.Wrap(err, "marshalling deployment to YAML")
+				return errors.Wrap(err, "marshalling deployment to YAML")
+			}
+			if _, err := config.WriteTo(w); err != nil {
+				return err
 			}
 		}
 
 		rcs, err := c.client.ReplicationControllers(ns.Name).List(api.ListOptions{})
 		if err != nil {
-			return nil, errors.Wrap(err, "getting replication controllers")
+			return errors.Wrap(err, "getting replication controllers")
 		}
+		sortByName(len(rcs.Items), func(i int) string { return rcs.Items[i].Name }, func(i, j int) {
+			rcs.Items[i], rcs.Items[j] = rcs.Items[j], rcs.Items[i]
+		})
 		for _, rc := range rcs.Items {
 			if isAddon(&rc) {
 				continue
 			}
 			err := appendYAML(&config, "v1", "ReplicationController", rc)
 			if err != nil {
-				return nil, errors.Wrap(err, "marshalling replication controller to YAML")
+				return errors.Wrap(err, "marshalling replication controller to YAML")
+			}
+			if _, err := config.WriteTo(w); err != nil {
+				return err
 			}
 		}
 
 		services, err := c.client.Services(ns.Name).List(api.ListOptions{})
 		if err != nil {
-			return nil, errors.Wrap(err, "getting services")
+			return errors.Wrap(err, "getting services")
 		}
+		sortByName(len(services.Items), func(i int) string { return services.Items[i].Name }, func(i, j int) {
+			services.Items[i], services.Items[j] = services.Items[j], services.Items[i]
+		})
 		for _, service := range services.Items {
 			if isAddon(&service) {
 				continue
 			}
 			err := appendYAML(&config, "v1", "Service", service)
 			if err != nil {
-				return nil, errors.Wrap(err, "marshalling service to YAML")
+				return errors.Wrap(err, "marshalling service to YAML")
+			}
+			if _, err := config.WriteTo(w); err != nil {
+				return err
 			}
 		}
 	}
-	return config.Bytes(), nil
+	return nil
+}
+
+// sortByName sorts the n items of a list by name, given a way to get
+// the name of, and to swap, items.
+func sortByName(n int, name func(int) string, swap func(i, j int)) {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = name(i)
+	}
+	sort.Sort(byName{names, swap})
+}
+
+// byName sorts names, swapping the items they're for along with them.
+type byName struct {
+	names []string
+	swap  func(i, j int)
+}
+
+func (b byName) Len() int           { return len(b.names) }
+func (b byName) Less(i, j int) bool { return b.names[i] < b.names[j] }
+func (b byName) Swap(i, j int) {
+	b.names[i], b.names[j] = b.names[j], b.names[i]
+	b.swap(i, j)
 }
 
 // kind & apiVersion must be passed separately as the object's TypeMeta is not populated
@@ -9,6 +9,10 @@ import (
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
 	"k8s.io/client-go/1.5/rest"
 
 	"github.com/weaveworks/flux/platform"
@@ -48,15 +52,44 @@ metadata:
 // ---
 
 func setup(t *testing.T) (platform.Platform, *mockApplier) {
+	kube, applier := setupWithNamespaces(t, NamespaceIgnore)
+	return kube, applier
+}
+
+func setupWithNamespaces(t *testing.T, policy NamespacePolicy) (*Cluster, *mockApplier) {
 	restClientConfig := &rest.Config{}
 	applier := &mockApplier{}
-	kube, err := NewCluster(restClientConfig, applier, "test-version", log.NewNopLogger())
+	kube, err := NewCluster(restClientConfig, applier, policy, "test-version", log.NewNopLogger())
 	if err != nil {
 		t.Fatal(err)
 	}
 	return kube, applier
 }
 
+// Just enough of the core API to look up namespaces.
+type mockCore struct {
+	v1core.CoreInterface
+	namespaces []string
+}
+
+func (m mockCore) Namespaces() v1core.NamespaceInterface {
+	return mockNamespaces{names: m.namespaces}
+}
+
+type mockNamespaces struct {
+	v1core.NamespaceInterface
+	names []string
+}
+
+func (m mockNamespaces) Get(name string) (*v1.Namespace, error) {
+	for _, n := range m.names {
+		if n == name {
+			return &v1.Namespace{ObjectMeta: v1.ObjectMeta{Name: name}}, nil
+		}
+	}
+	return nil, k8serrors.NewNotFound(unversioned.GroupResource{Resource: "namespaces"}, name)
+}
+
 func TestSyncNop(t *testing.T) {
 	kube, mock := setup(t)
 	if err := kube.Sync(platform.SyncDef{}); err != nil {
@@ -147,3 +180,92 @@ func TestSkipOnError(t *testing.T) {
 		t.Errorf("expected commands:\n%#v\ngot:\n%#v", expected, mock.commands)
 	}
 }
+
+func namespacedDef(kind, name, namespace string) []byte {
+	return []byte(`---
+kind: ` + kind + `
+metadata:
+  name: ` + name + `
+  namespace: ` + namespace + `
+`)
+}
+
+func TestSyncMissingNamespaces(t *testing.T) {
+	def := platform.SyncDef{
+		Actions: []platform.SyncAction{
+			platform.SyncAction{
+				ResourceID: "existing",
+				Apply:      namespacedDef("Deployment", "existing", "test-ns"),
+			},
+			platform.SyncAction{
+				ResourceID: "new-namespace",
+				Apply:      namespacedDef("Namespace", "defined-ns", ""),
+			},
+			platform.SyncAction{
+				ResourceID: "defined",
+				Apply:      namespacedDef("Deployment", "defined", "defined-ns"),
+			},
+			platform.SyncAction{
+				ResourceID: "missing",
+				Apply:      namespacedDef("Deployment", "missing", "missing-ns"),
+			},
+		},
+	}
+
+	// Failing leaves out the resources in the missing namespace
+	kube, mock := setupWithNamespaces(t, NamespaceFail)
+	kube.client.CoreInterface = mockCore{namespaces: []string{"test-ns"}}
+	err := kube.Sync(def)
+	syncErr, ok := err.(platform.SyncError)
+	if !ok {
+		t.Fatalf("expected sync error, got %#v", err)
+	}
+	if len(syncErr) != 1 || syncErr["missing"] == nil {
+		t.Errorf("expected error for resource %q only, got %#v", "missing", syncErr)
+	}
+	expected := []command{
+		command{"apply", "existing"},
+		command{"apply", "defined-ns"},
+		command{"apply", "defined"},
+	}
+	if !reflect.DeepEqual(expected, mock.commands) {
+		t.Errorf("expected commands:\n%#v\ngot:\n%#v", expected, mock.commands)
+	}
+
+	// Creating applies the namespace first
+	kube, mock = setupWithNamespaces(t, NamespaceCreate)
+	kube.client.CoreInterface = mockCore{namespaces: []string{"test-ns"}}
+	if err := kube.Sync(def); err != nil {
+		t.Fatal(err)
+	}
+	expected = []command{
+		command{"apply", "missing-ns"},
+		command{"apply", "existing"},
+		command{"apply", "defined-ns"},
+		command{"apply", "defined"},
+		command{"apply", "missing"},
+	}
+	if !reflect.DeepEqual(expected, mock.commands) {
+		t.Errorf("expected commands:\n%#v\ngot:\n%#v", expected, mock.commands)
+	}
+}
+
+func TestSortByName(t *testing.T) {
+	items := []v1.Service{}
+	for _, name := range []string{"memcached", "helloworld", "fluxsvc", "kubernetes"} {
+		var s v1.Service
+		s.Name = name
+		items = append(items, s)
+	}
+	sortByName(len(items), func(i int) string { return items[i].Name }, func(i, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+	var names []string
+	for _, s := range items {
+		names = append(names, s.Name)
+	}
+	expected := []string{"fluxsvc", "helloworld", "kubernetes", "memcached"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
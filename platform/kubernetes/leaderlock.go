@@ -0,0 +1,167 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	k8sclient "k8s.io/client-go/1.5/kubernetes"
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+	rest "k8s.io/client-go/1.5/rest"
+
+	"github.com/weaveworks/flux/leader"
+)
+
+// LeaderAnnotation is the annotation on the ConfigMap used as a lock,
+// in which the leader election record is kept.
+const LeaderAnnotation = "flux.weave.works/leader"
+
+// ConfigMapLock keeps the leader election record in an annotation on
+// a ConfigMap, and records changes of leadership as events about the
+// ConfigMap. The API server refuses updates to the ConfigMap based on
+// an old version of it, which is what makes it a lock.
+type ConfigMapLock struct {
+	client    v1core.CoreInterface
+	namespace string
+	name      string
+	identity  string
+
+	// the ConfigMap as last got, which updates are based on
+	configMap *v1.ConfigMap
+}
+
+// NewConfigMapLock returns a lock using the ConfigMap `name` in
+// `namespace`, which is created if it doesn't exist; the identity is
+// that of this replica, given as the source of events.
+func NewConfigMapLock(config *rest.Config, namespace, name, identity string) (*ConfigMapLock, error) {
+	client, err := k8sclient.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newConfigMapLock(client.Core(), namespace, name, identity), nil
+}
+
+func newConfigMapLock(client v1core.CoreInterface, namespace, name, identity string) *ConfigMapLock {
+	return &ConfigMapLock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+	}
+}
+
+func (l *ConfigMapLock) Get() (leader.Record, error) {
+	var record leader.Record
+	configMap, err := l.client.ConfigMaps(l.namespace).Get(l.name)
+	if k8serrors.IsNotFound(err) {
+		l.configMap = nil
+		return record, leader.ErrNoRecord
+	}
+	if err != nil {
+		return record, err
+	}
+	l.configMap = configMap
+	value, ok := configMap.Annotations[LeaderAnnotation]
+	if !ok {
+		return record, leader.ErrNoRecord
+	}
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return record, errors.Wrapf(err, "parsing annotation %s on %s", LeaderAnnotation, l.Describe())
+	}
+	return record, nil
+}
+
+// Create creates the ConfigMap with the record, or if the ConfigMap
+// exists but without a record, adds the record to it.
+func (l *ConfigMapLock) Create(record leader.Record) error {
+	if l.configMap != nil {
+		return l.Update(record)
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	configMap, err := l.client.ConfigMaps(l.namespace).Create(&v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:   l.namespace,
+			Name:        l.name,
+			Annotations: map[string]string{LeaderAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return lockError(err)
+	}
+	l.configMap = configMap
+	return nil
+}
+
+// Update replaces the record in the ConfigMap as last got.
+func (l *ConfigMapLock) Update(record leader.Record) error {
+	if l.configMap == nil {
+		return errors.New("updating leader election record before getting it")
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	configMap := *l.configMap
+	configMap.Annotations = map[string]string{}
+	for k, v := range l.configMap.Annotations {
+		configMap.Annotations[k] = v
+	}
+	configMap.Annotations[LeaderAnnotation] = string(value)
+	updated, err := l.client.ConfigMaps(l.namespace).Update(&configMap)
+	if err != nil {
+		return lockError(err)
+	}
+	l.configMap = updated
+	return nil
+}
+
+func (l *ConfigMapLock) Describe() string {
+	return fmt.Sprintf("configmap %s/%s", l.namespace, l.name)
+}
+
+// Event records an event about the ConfigMap, from fluxd on this
+// replica, so changes of leadership show up with `kubectl describe`.
+func (l *ConfigMapLock) Event(reason, message string) error {
+	now := unversioned.Now()
+	ref := v1.ObjectReference{
+		Kind:      "ConfigMap",
+		Namespace: l.namespace,
+		Name:      l.name,
+	}
+	if l.configMap != nil {
+		ref.UID = l.configMap.UID
+		ref.ResourceVersion = l.configMap.ResourceVersion
+	}
+	_, err := l.client.Events(l.namespace).Create(&v1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: l.namespace,
+			// Events are named for what they're about, made unique
+			// with the time
+			Name: fmt.Sprintf("%s.%x", l.name, now.UnixNano()),
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: "fluxd", Host: l.identity},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeNormal,
+	})
+	return err
+}
+
+// lockError translates the errors that mean another replica got
+// there first.
+func lockError(err error) error {
+	if k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err) {
+		return leader.ErrConflict
+	}
+	return err
+}
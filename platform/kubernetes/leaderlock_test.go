@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux/leader"
+)
+
+// mockLockCore keeps ConfigMaps as the API server does, refusing
+// updates made from an old version.
+type mockLockCore struct {
+	v1core.CoreInterface
+	configMaps map[string]v1.ConfigMap
+	events     []v1.Event
+}
+
+// The interfaces are embedded only to be satisfied; the lock calls
+// just the methods defined here.
+type mockLockConfigMaps struct {
+	v1core.ConfigMapInterface
+	*mockLockCore
+}
+
+type mockLockEvents struct {
+	v1core.EventInterface
+	*mockLockCore
+}
+
+func (m *mockLockCore) ConfigMaps(namespace string) v1core.ConfigMapInterface {
+	return mockLockConfigMaps{mockLockCore: m}
+}
+
+func (m *mockLockCore) Events(namespace string) v1core.EventInterface {
+	return mockLockEvents{mockLockCore: m}
+}
+
+func (m mockLockConfigMaps) Get(name string) (*v1.ConfigMap, error) {
+	configMap, ok := m.configMaps[name]
+	if !ok {
+		return nil, k8serrors.NewNotFound(unversioned.GroupResource{Resource: "configmaps"}, name)
+	}
+	return &configMap, nil
+}
+
+func (m mockLockConfigMaps) Create(configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	if _, ok := m.configMaps[configMap.Name]; ok {
+		return nil, k8serrors.NewAlreadyExists(unversioned.GroupResource{Resource: "configmaps"}, configMap.Name)
+	}
+	created := *configMap
+	created.ResourceVersion = "1"
+	m.configMaps[configMap.Name] = created
+	return &created, nil
+}
+
+func (m mockLockConfigMaps) Update(configMap *v1.ConfigMap) (*v1.ConfigMap, error) {
+	current, ok := m.configMaps[configMap.Name]
+	if !ok {
+		return nil, k8serrors.NewNotFound(unversioned.GroupResource{Resource: "configmaps"}, configMap.Name)
+	}
+	if current.ResourceVersion != configMap.ResourceVersion {
+		return nil, k8serrors.NewConflict(unversioned.GroupResource{Resource: "configmaps"}, configMap.Name, nil)
+	}
+	version, _ := strconv.Atoi(current.ResourceVersion)
+	updated := *configMap
+	updated.ResourceVersion = strconv.Itoa(version + 1)
+	m.configMaps[configMap.Name] = updated
+	return &updated, nil
+}
+
+func (m mockLockEvents) Create(event *v1.Event) (*v1.Event, error) {
+	m.events = append(m.events, *event)
+	return event, nil
+}
+
+func TestConfigMapLock(t *testing.T) {
+	core := &mockLockCore{configMaps: map[string]v1.ConfigMap{}}
+	a := newConfigMapLock(core, "flux", "fluxd", "a")
+	b := newConfigMapLock(core, "flux", "fluxd", "b")
+
+	if _, err := a.Get(); err != leader.ErrNoRecord {
+		t.Fatalf("expected no record before there's a ConfigMap, got %v", err)
+	}
+	if _, err := b.Get(); err != leader.ErrNoRecord {
+		t.Fatalf("expected no record before there's a ConfigMap, got %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	record := leader.Record{HolderIdentity: "a", LeaseDurationSeconds: 15, AcquireTime: now, RenewTime: now}
+	if err := a.Create(record); err != nil {
+		t.Fatal(err)
+	}
+	// b was too late to create it
+	if err := b.Create(leader.Record{HolderIdentity: "b"}); err != leader.ErrConflict {
+		t.Fatalf("expected a conflict creating the ConfigMap a second time, got %v", err)
+	}
+
+	got, err := b.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HolderIdentity != "a" || !got.RenewTime.Equal(now) {
+		t.Errorf("expected the record created, got %+v", got)
+	}
+
+	// a renews, so b's update, based on what it got before, conflicts
+	record.RenewTime = now.Add(time.Second)
+	if err := a.Update(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Update(leader.Record{HolderIdentity: "b"}); err != leader.ErrConflict {
+		t.Fatalf("expected a conflict updating from an old version, got %v", err)
+	}
+
+	if err := a.Event(leader.ReasonBecameLeader, "a became leader"); err != nil {
+		t.Fatal(err)
+	}
+	if len(core.events) != 1 {
+		t.Fatalf("expected an event, got %+v", core.events)
+	}
+	event := core.events[0]
+	if event.InvolvedObject.Kind != "ConfigMap" || event.InvolvedObject.Name != "fluxd" ||
+		event.Reason != leader.ReasonBecameLeader || event.Source.Host != "a" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestConfigMapLockExistingConfigMap(t *testing.T) {
+	// A ConfigMap made beforehand (e.g., along with the RBAC rules
+	// for it) keeps its other annotations
+	core := &mockLockCore{configMaps: map[string]v1.ConfigMap{
+		"fluxd": {ObjectMeta: v1.ObjectMeta{Name: "fluxd", ResourceVersion: "1", Annotations: map[string]string{"owner": "ops"}}},
+	}}
+	lock := newConfigMapLock(core, "flux", "fluxd", "a")
+	if _, err := lock.Get(); err != leader.ErrNoRecord {
+		t.Fatalf("expected no record on a ConfigMap without the annotation, got %v", err)
+	}
+	if err := lock.Create(leader.Record{HolderIdentity: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	annotations := core.configMaps["fluxd"].Annotations
+	if annotations["owner"] != "ops" || annotations[LeaderAnnotation] == "" {
+		t.Errorf("expected the record alongside the other annotations, got %v", annotations)
+	}
+}
@@ -0,0 +1,201 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+)
+
+// deprecatedVersions maps kind and apiVersion of resources to the
+// apiVersion that should be used instead.
+var deprecatedVersions = map[string]map[string]string{
+	"Job": {
+		"extensions/v1beta1": "batch/v1",
+	},
+	"HorizontalPodAutoscaler": {
+		"extensions/v1beta1": "autoscaling/v1",
+	},
+}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+type lintContainer struct {
+	Name      string `yaml:"name"`
+	Image     string `yaml:"image"`
+	Resources struct {
+		Limits map[string]interface{} `yaml:"limits"`
+	} `yaml:"resources"`
+}
+
+type lintObject struct {
+	Version  string `yaml:"apiVersion"`
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Selector map[string]interface{} `yaml:"selector"`
+		Template struct {
+			Metadata struct {
+				Labels map[string]string `yaml:"labels"`
+			} `yaml:"metadata"`
+			Spec struct {
+				Containers []lintContainer `yaml:"containers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// Lint checks the resource definitions under the directory given for
+// common mistakes: containers without resource limits, deprecated
+// apiVersions, and selectors that won't select anything. Files that
+// aren't YAML are ignored.
+func Lint(path string) ([]flux.LintProblem, error) {
+	problems := []flux.LintProblem{}
+	if err := filepath.Walk(path, func(target string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(target); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		def, err := ioutil.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, target)
+		if err != nil {
+			rel = target
+		}
+		problems = append(problems, lintFile(rel, def)...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+// ContainerImages gives the image used by each container in the pod
+// template of a resource definition.
+func ContainerImages(def []byte) (map[string]string, error) {
+	var obj lintObject
+	if err := yaml.Unmarshal(def, &obj); err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	for _, c := range obj.Spec.Template.Spec.Containers {
+		images[c.Name] = c.Image
+	}
+	return images, nil
+}
+
+func lintFile(file string, def []byte) []flux.LintProblem {
+	var problems []flux.LintProblem
+	for _, doc := range documentSeparator.Split(string(def), -1) {
+		var obj lintObject
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			problems = append(problems, flux.LintProblem{
+				File:    file,
+				Rule:    flux.LintUnparseableFile,
+				Message: err.Error(),
+			})
+			continue
+		}
+		if obj.Kind == "" {
+			continue
+		}
+		for _, p := range lintObj(obj) {
+			p.File, p.Kind, p.Name = file, obj.Kind, obj.Metadata.Name
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+func lintObj(obj lintObject) (problems []flux.LintProblem) {
+	if replacement, ok := deprecatedVersions[obj.Kind][obj.Version]; ok {
+		problems = append(problems, flux.LintProblem{
+			Rule:    flux.LintDeprecatedVersion,
+			Message: fmt.Sprintf("apiVersion %s is deprecated for %s; use %s", obj.Version, obj.Kind, replacement),
+		})
+	}
+
+	switch obj.Kind {
+	case "ReplicationController":
+		problems = append(problems, flux.LintProblem{
+			Rule:    flux.LintDeprecatedResource,
+			Message: "replication controllers cannot be updated by flux; use a Deployment instead",
+		})
+		problems = append(problems, lintContainers(obj)...)
+		problems = append(problems, lintSelector(stringMap(obj.Spec.Selector), obj.Spec.Template.Metadata.Labels)...)
+	case "Deployment":
+		problems = append(problems, lintContainers(obj)...)
+		if obj.Spec.Selector != nil {
+			matchLabels, _ := obj.Spec.Selector["matchLabels"].(map[interface{}]interface{})
+			if matchLabels != nil {
+				problems = append(problems, lintSelector(stringMap(matchLabels), obj.Spec.Template.Metadata.Labels)...)
+			}
+		}
+	case "Service":
+		// A service without a selector isn't a mistake: it may be
+		// headless, with endpoints given by hand, or an ExternalName
+		// service.
+	}
+	return problems
+}
+
+func lintContainers(obj lintObject) (problems []flux.LintProblem) {
+	for _, c := range obj.Spec.Template.Spec.Containers {
+		if len(c.Resources.Limits) == 0 {
+			problems = append(problems, flux.LintProblem{
+				Rule:    flux.LintMissingLimits,
+				Message: fmt.Sprintf("container %q has no resource limits", c.Name),
+			})
+		}
+	}
+	return problems
+}
+
+// lintSelector checks that every label in the selector is present,
+// with the same value, in the labels of the pod template.
+func lintSelector(selector, labels map[string]string) (problems []flux.LintProblem) {
+	var keys []string
+	for k := range selector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := labels[k]; !ok || v != selector[k] {
+			problems = append(problems, flux.LintProblem{
+				Rule:    flux.LintInvalidSelector,
+				Message: fmt.Sprintf("selector %s=%s does not match the pod template labels", k, selector[k]),
+			})
+		}
+	}
+	return problems
+}
+
+func stringMap(m interface{}) map[string]string {
+	res := map[string]string{}
+	switch m := m.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			res[k] = fmt.Sprint(v)
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			res[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
+)
+
+const lintDeployment = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  selector:
+    matchLabels:
+      name: hello
+  template:
+    metadata:
+      labels:
+        name: helloworld
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+      - name: sidecar
+        image: quay.io/weaveworks/sidecar:master-a000002
+        resources:
+          limits:
+            memory: 64Mi
+`
+
+const lintJobAndService = `apiVersion: extensions/v1beta1
+kind: Job
+metadata:
+  name: migrate
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: helloworld
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: database
+spec:
+  type: ExternalName
+  externalName: db.example.com
+`
+
+func TestLint(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	for name, content := range map[string]string{
+		"deployment.yaml": lintDeployment,
+		"other.yml":       lintJobAndService,
+		"README.md":       "kind: Deployment",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := Lint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ file, name, rule string }{
+		{"deployment.yaml", "helloworld", flux.LintMissingLimits},
+		{"deployment.yaml", "helloworld", flux.LintInvalidSelector},
+		{"other.yml", "migrate", flux.LintDeprecatedVersion},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %+v", len(expected), len(problems), problems)
+	}
+	for i, e := range expected {
+		p := problems[i]
+		if p.File != e.file || p.Name != e.name || p.Rule != e.rule {
+			t.Errorf("problem %d: expected %+v, got %+v", i, e, p)
+		}
+	}
+}
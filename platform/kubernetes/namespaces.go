@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+
+	"github.com/weaveworks/flux/platform"
+)
+
+// NamespacePolicy says what to do when resources being synced are in
+// a namespace that exists neither in the cluster, nor among the
+// resources being synced.
+type NamespacePolicy string
+
+const (
+	// Don't check; just apply the resources, and let those fail.
+	NamespaceIgnore NamespacePolicy = ""
+	// Create the namespace before applying the resources in it.
+	NamespaceCreate NamespacePolicy = "create"
+	// Fail the resources in the namespace, without applying them.
+	NamespaceFail NamespacePolicy = "fail"
+)
+
+func ParseNamespacePolicy(s string) (NamespacePolicy, error) {
+	switch p := NamespacePolicy(s); p {
+	case NamespaceIgnore, NamespaceCreate, NamespaceFail:
+		return p, nil
+	}
+	return NamespaceIgnore, fmt.Errorf("invalid namespace policy %q; expected %q or %q", s, NamespaceCreate, NamespaceFail)
+}
+
+// checkNamespaces finds the namespaces of the resources to be applied
+// that are missing, and, according to the policy, creates them or
+// gives an error for each resource in them. Namespaces defined in the
+// sync itself are assumed to be applied before the resources in them.
+func (c *Cluster) checkNamespaces(logger log.Logger, actions []platform.SyncAction) platform.SyncError {
+	errs := platform.SyncError{}
+	if c.namespaces == NamespaceIgnore {
+		return errs
+	}
+
+	defined := map[string]bool{}
+	resources := map[string][]string{}
+	var namespaces []string
+	for _, action := range actions {
+		if len(action.Apply) == 0 {
+			continue
+		}
+		obj, err := definitionObj(action.Apply)
+		if err != nil {
+			// This will be reported when it's applied
+			continue
+		}
+		if obj.Kind == "Namespace" {
+			defined[obj.Metadata.Name] = true
+			continue
+		}
+		ns := namespaceOrDefault(obj)
+		if _, ok := resources[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+		resources[ns] = append(resources[ns], action.ResourceID)
+	}
+
+	var missing []string
+	for _, ns := range namespaces {
+		if defined[ns] {
+			continue
+		}
+		_, err := c.client.Namespaces().Get(ns)
+		switch {
+		case k8serrors.IsNotFound(err):
+			missing = append(missing, ns)
+		case err != nil:
+			for _, id := range resources[ns] {
+				errs[id] = errors.Wrapf(err, "checking namespace %q", ns)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return errs
+	}
+
+	logger.Log("missing-namespaces", strings.Join(missing, ", "), "policy", c.namespaces)
+	for _, ns := range missing {
+		var err error
+		switch c.namespaces {
+		case NamespaceCreate:
+			if err = c.applier.Apply(logger, namespaceObj(ns)); err != nil {
+				err = errors.Wrapf(err, "creating namespace %q", ns)
+			}
+		default:
+			err = fmt.Errorf("namespace %q does not exist in the cluster or the resources synced (missing namespaces: %s)", ns, strings.Join(missing, ", "))
+		}
+		if err != nil {
+			for _, id := range resources[ns] {
+				errs[id] = err
+			}
+		}
+	}
+	return errs
+}
+
+func namespaceObj(name string) *apiObject {
+	obj := &apiObject{
+		bytes: []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: ` + name + `
+`),
+		Version: "v1",
+		Kind:    "Namespace",
+	}
+	obj.Metadata.Name = name
+	return obj
+}
@@ -0,0 +1,164 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	api "k8s.io/client-go/1.5/pkg/api"
+	"k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux"
+)
+
+// AllNamespaces, given as the namespaces for image pull secrets, says
+// to look in every namespace.
+const AllNamespaces = "*"
+
+// SetPullSecretNamespaces says to look for registry credentials in the
+// image pull secrets of the workloads and service accounts in the
+// namespaces given (or in every namespace, if AllNamespaces is among
+// them). Until it's called, no credentials are given.
+func (c *Cluster) SetPullSecretNamespaces(namespaces []string) {
+	c.actionc <- func() {
+		c.pullSecretNamespaces = namespaces
+	}
+}
+
+// RegistryCredentials gives the credentials in the image pull secrets
+// used by the deployments, replication controllers, and service
+// accounts in the namespaces allowed. Where more than one secret has
+// credentials for a registry, any one of them may be given.
+func (c *Cluster) RegistryCredentials() (map[string]flux.Auth, error) {
+	namespacesc := make(chan []string)
+	c.actionc <- func() {
+		namespacesc <- c.pullSecretNamespaces
+	}
+	namespaces := <-namespacesc
+	for _, ns := range namespaces {
+		if ns == AllNamespaces {
+			list, err := c.client.Namespaces().List(api.ListOptions{})
+			if err != nil {
+				return nil, errors.Wrap(err, "getting namespaces")
+			}
+			namespaces = nil
+			for _, ns := range list.Items {
+				namespaces = append(namespaces, ns.Name)
+			}
+			break
+		}
+	}
+
+	auths := map[string]flux.Auth{}
+	for _, ns := range namespaces {
+		names, err := c.pullSecretsInNamespace(ns)
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding image pull secrets in namespace %s", ns)
+		}
+		secrets := c.client.Secrets(ns)
+		for name := range names {
+			secret, err := secrets.Get(name)
+			if err != nil {
+				// It may be missing, or flux not allowed to see it;
+				// either way, pods using it won't get its images
+				// either.
+				c.logger.Log("secret", ns+"/"+name, "err", err)
+				continue
+			}
+			found, err := credentialsFromSecret(secret)
+			if err != nil {
+				c.logger.Log("secret", ns+"/"+name, "err", err)
+				continue
+			}
+			for host, auth := range found {
+				auths[host] = auth
+			}
+		}
+	}
+	return auths, nil
+}
+
+// pullSecretsInNamespace gives the names of the image pull secrets
+// used by the pod controllers and service accounts in the namespace.
+func (c *Cluster) pullSecretsInNamespace(namespace string) (map[string]bool, error) {
+	names := map[string]bool{}
+	controllers, err := c.podControllersInNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, controller := range controllers {
+		var refs []v1.LocalObjectReference
+		switch {
+		case controller.Deployment != nil:
+			refs = controller.Deployment.Spec.Template.Spec.ImagePullSecrets
+		case controller.ReplicationController != nil && controller.ReplicationController.Spec.Template != nil:
+			refs = controller.ReplicationController.Spec.Template.Spec.ImagePullSecrets
+		}
+		for _, ref := range refs {
+			names[ref.Name] = true
+		}
+	}
+
+	accounts, err := c.client.ServiceAccounts(namespace).List(api.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "collecting service accounts")
+	}
+	for _, account := range accounts.Items {
+		for _, ref := range account.ImagePullSecrets {
+			names[ref.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// The type of secrets in the newer docker config format, and the key
+// of the config in them. The vendored client-go predates them.
+const (
+	secretTypeDockerConfigJSON = v1.SecretType("kubernetes.io/dockerconfigjson")
+	dockerConfigJSONKey        = ".dockerconfigjson"
+)
+
+// dockerConfigEntry is the credentials for one registry, as in a
+// docker config file.
+type dockerConfigEntry struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// credentialsFromSecret gives the credentials in an image pull
+// secret, in either the old (.dockercfg) or the new
+// (.dockerconfigjson) format. Secrets of any other type have none.
+func credentialsFromSecret(secret *v1.Secret) (map[string]flux.Auth, error) {
+	var entries map[string]dockerConfigEntry
+	switch secret.Type {
+	case v1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigKey], &entries); err != nil {
+			return nil, errors.Wrap(err, "decoding docker config")
+		}
+	case secretTypeDockerConfigJSON:
+		var config struct {
+			Auths map[string]dockerConfigEntry `json:"auths"`
+		}
+		if err := json.Unmarshal(secret.Data[dockerConfigJSONKey], &config); err != nil {
+			return nil, errors.Wrap(err, "decoding docker config")
+		}
+		entries = config.Auths
+	default:
+		return nil, nil
+	}
+
+	auths := map[string]flux.Auth{}
+	for host, entry := range entries {
+		auth := entry.Auth
+		if auth == "" {
+			if entry.Username == "" {
+				continue
+			}
+			auth = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", entry.Username, entry.Password)))
+		}
+		auths[host] = flux.Auth{Auth: auth}
+	}
+	return auths, nil
+}
@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestCredentialsFromSecret(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		secret   v1.Secret
+		expected map[string]flux.Auth
+	}{
+		{
+			name: "dockerconfigjson",
+			secret: v1.Secret{
+				Type: secretTypeDockerConfigJSON,
+				Data: map[string][]byte{dockerConfigJSONKey: []byte(`{"auths":{"quay.io":{"auth":"dXNlcjpwYXNzd29yZA=="}}}`)},
+			},
+			expected: map[string]flux.Auth{"quay.io": {Auth: "dXNlcjpwYXNzd29yZA=="}},
+		},
+		{
+			name: "dockercfg with username and password",
+			secret: v1.Secret{
+				Type: v1.SecretTypeDockercfg,
+				Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"https://index.docker.io/v1/":{"username":"user","password":"password"},"empty.example.com":{}}`)},
+			},
+			expected: map[string]flux.Auth{"https://index.docker.io/v1/": {Auth: "dXNlcjpwYXNzd29yZA=="}},
+		},
+		{
+			name: "opaque",
+			secret: v1.Secret{
+				Type: "Opaque",
+				Data: map[string][]byte{"password": []byte("hunter2")},
+			},
+		},
+	} {
+		auths, err := credentialsFromSecret(&c.secret)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if len(auths) != len(c.expected) || (len(c.expected) > 0 && !reflect.DeepEqual(auths, c.expected)) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, auths)
+		}
+	}
+
+	if _, err := credentialsFromSecret(&v1.Secret{
+		Type: secretTypeDockerConfigJSON,
+		Data: map[string][]byte{dockerConfigJSONKey: []byte("not json")},
+	}); err == nil {
+		t.Error("expected an error for a secret that can't be decoded")
+	}
+}
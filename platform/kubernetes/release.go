@@ -6,6 +6,7 @@ import (
 	"io"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -14,13 +15,44 @@ import (
 )
 
 func NewKubectl(exe string, config *rest.Config, stdout, stderr io.Writer) *Kubectl {
-	return &Kubectl{exe, config, stdout, stderr}
+	return &Kubectl{
+		exe:    exe,
+		config: config,
+		stdout: stdout,
+		stderr: stderr,
+	}
 }
 
 type Kubectl struct {
 	exe            string
 	config         *rest.Config
 	stdout, stderr io.Writer
+
+	// If fieldManager is set, resources are applied server-side, as
+	// that manager, unless the cluster (or kubectl) turns out not to
+	// support it.
+	fieldManager string
+	mu           sync.Mutex
+	clientSide   bool
+}
+
+// ServerSideApply makes the applier use server-side apply, so that
+// the fields flux sets are recorded as managed by fieldManager, and
+// changing fields managed by something else is reported as a
+// conflict. If server-side apply isn't supported, it falls back to
+// client-side apply.
+func (c *Kubectl) ServerSideApply(fieldManager string) {
+	c.fieldManager = fieldManager
+}
+
+// ApplyConflict is the error when applying a resource would change
+// fields that are managed by something other than flux.
+type ApplyConflict struct {
+	Output string
+}
+
+func (e ApplyConflict) Error() string {
+	return "fields are managed by another controller: " + e.Output
 }
 
 func (c *Kubectl) connectArgs() []string {
@@ -86,5 +118,49 @@ func (c *Kubectl) Delete(logger log.Logger, obj *apiObject) error {
 }
 
 func (c *Kubectl) Apply(logger log.Logger, obj *apiObject) error {
+	if c.fieldManager != "" && !c.isClientSide() {
+		err := c.doCommand(logger, obj.bytes, "--namespace", namespaceOrDefault(obj), "apply", "--server-side", "--field-manager="+c.fieldManager, "-f", "-")
+		switch {
+		case err == nil:
+			return nil
+		case isApplyConflict(err):
+			return ApplyConflict{Output: strings.TrimSpace(errors.Cause(err).Error())}
+		case !serverSideUnsupported(err):
+			return err
+		}
+		logger.Log("info", "server-side apply is not supported; falling back to client-side apply")
+		c.mu.Lock()
+		c.clientSide = true
+		c.mu.Unlock()
+	}
 	return c.doCommand(logger, obj.bytes, "--namespace", namespaceOrDefault(obj), "apply", "-f", "-")
 }
+
+func (c *Kubectl) isClientSide() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientSide
+}
+
+// serverSideUnsupported says whether the error from kubectl is
+// because it, or the API server, is too old for server-side apply.
+func serverSideUnsupported(err error) bool {
+	msg := err.Error()
+	for _, s := range []string{
+		"unknown flag: --server-side",
+		"unknown flag: --field-manager",
+		"the body of the request was in an unknown format",
+		"415 Unsupported Media Type",
+		"does not support server-side apply",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isApplyConflict(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "Apply failed with") && strings.Contains(msg, "conflict")
+}
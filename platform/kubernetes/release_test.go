@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	rest "k8s.io/client-go/1.5/rest"
+)
+
+// fakeKubectl writes a script that records its arguments, and
+// exits with the output given when they include the flag given.
+func fakeKubectl(t *testing.T, dir, failFlag, output string) string {
+	exe := filepath.Join(dir, "kubectl")
+	script := `#!/bin/sh
+echo "$@" >> ` + filepath.Join(dir, "args") + `
+for arg in "$@"; do
+  if [ "$arg" = "` + failFlag + `" ]; then
+    echo "` + output + `" >&2
+    exit 1
+  fi
+done
+`
+	if err := ioutil.WriteFile(exe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return exe
+}
+
+func TestServerSideApply(t *testing.T) {
+	for _, c := range []struct {
+		output   string
+		calls    int
+		conflict bool
+		fails    bool
+	}{
+		{"error: unknown flag: --server-side", 2, false, false},
+		{"Apply failed with 1 conflict: conflict with \\\"kube-controller-manager\\\": .spec.replicas", 1, true, true},
+		{"error: something else", 1, false, true},
+	} {
+		dir, err := ioutil.TempDir("", "flux-kubectl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		kubectl := NewKubectl(fakeKubectl(t, dir, "--server-side", c.output), &rest.Config{}, ioutil.Discard, ioutil.Discard)
+		kubectl.ServerSideApply("flux")
+		obj, err := definitionObj([]byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: foo\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = kubectl.Apply(log.NewNopLogger(), obj)
+		if (err != nil) != c.fails {
+			t.Errorf("%q: expected failure=%v, got %v", c.output, c.fails, err)
+		}
+		if _, ok := err.(ApplyConflict); ok != c.conflict {
+			t.Errorf("%q: expected conflict=%v, got %v", c.output, c.conflict, err)
+		}
+
+		args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := strings.Split(strings.TrimSpace(string(args)), "\n")
+		if len(calls) != c.calls {
+			t.Fatalf("%q: expected %d calls to kubectl, got %v", c.output, c.calls, calls)
+		}
+		if !strings.Contains(calls[0], "--field-manager=flux") {
+			t.Errorf("%q: expected first apply to be server-side, got %q", c.output, calls[0])
+		}
+		if c.calls > 1 && strings.Contains(calls[1], "--server-side") {
+			t.Errorf("%q: expected fallback to client-side apply, got %q", c.output, calls[1])
+		}
+	}
+}
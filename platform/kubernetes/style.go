@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// manifestStyle records the incidental formatting of a resource
+// definition file -- byte order mark, line endings, and indentation
+// -- so that we can edit the file in a canonical form, then put it
+// back the way it was found. Otherwise we make noisy diffs for files
+// written on other platforms or with other editors.
+type manifestStyle struct {
+	bom     bool
+	crlf    []bool   // whether each line ended with CRLF, rather than LF
+	indents []string // the original indentation of each line
+}
+
+// normalise strips the BOM and carriage returns from def, and
+// re-indents it with two spaces per level, which is what the updater
+// expects.
+func (s *manifestStyle) normalise(def []byte) string {
+	if bytes.HasPrefix(def, utf8BOM) {
+		s.bom = true
+		def = def[len(utf8BOM):]
+	}
+
+	lines := strings.Split(string(def), "\n")
+	s.crlf = make([]bool, len(lines))
+	// The last line has no line ending, so any carriage return is
+	// part of it.
+	for i := range lines[:len(lines)-1] {
+		if strings.HasSuffix(lines[i], "\r") {
+			s.crlf[i] = true
+			lines[i] = strings.TrimSuffix(lines[i], "\r")
+		}
+	}
+
+	unit := indentUnit(lines)
+	s.indents = make([]string, len(lines))
+	for i, line := range lines {
+		indent := leadingSpaces(line)
+		s.indents[i] = line[:indent]
+		if unit > 2 {
+			// Keep any odd extra indentation (e.g., of fields
+			// following a list item's dash) as it is.
+			indent = indent/unit*2 + indent%unit
+			lines[i] = strings.Repeat(" ", indent) + strings.TrimLeft(line, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// restore puts the formatting recorded by normalise back into an
+// edited definition, which must have the same number of lines.
+func (s *manifestStyle) restore(def string) ([]byte, error) {
+	lines := strings.Split(def, "\n")
+	if len(lines) != len(s.indents) {
+		return nil, errors.New("update changed the number of lines in the file, so cannot restore its indentation")
+	}
+
+	var buf bytes.Buffer
+	if s.bom {
+		buf.Write(utf8BOM)
+	}
+	for i, line := range lines {
+		if i > 0 {
+			if s.crlf[i-1] {
+				buf.WriteString("\r\n")
+			} else {
+				buf.WriteString("\n")
+			}
+		}
+		buf.WriteString(s.indents[i] + strings.TrimLeft(line, " "))
+	}
+	return buf.Bytes(), nil
+}
+
+// indentUnit guesses the number of spaces used per level of
+// indentation, as the smallest indentation of any line with content.
+func indentUnit(lines []string) int {
+	unit := 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if n := leadingSpaces(line); n > 0 && (unit == 0 || n < unit) {
+			unit = n
+		}
+	}
+	return unit
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
@@ -0,0 +1,158 @@
+package kubernetes
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/platform"
+)
+
+// Substitutions are the values of the variables (written `${NAME}`)
+// in resource definitions, which are put in their place when the
+// resources are applied; so that one config repo can serve several
+// clusters, each with a few values of its own (e.g., its name).
+//
+// Only variables are substituted; there are no expressions, defaults,
+// or the like. A variable without a value is an error for the
+// resource it's in, rather than being left blank, and values can't
+// have line breaks, so they can't add to the structure of a resource.
+// `$${` is written as `${`, for definitions that need it as it is.
+type Substitutions struct {
+	// Vars are values given directly, e.g., with flags to fluxd.
+	Vars map[string]string
+	// ConfigMap, if not blank, is `namespace/name` of a ConfigMap,
+	// whose data are also values. It's read each time resources are
+	// applied, so values can be changed without restarting fluxd.
+	// Values in Vars take precedence.
+	ConfigMap string
+}
+
+var (
+	variableRE     = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+	variableNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ParseVars parses values given as `NAME=value`.
+func ParseVars(defs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, def := range defs {
+		i := strings.Index(def, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid variable %q; expected NAME=value", def)
+		}
+		name, value := def[:i], def[i+1:]
+		if !variableNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// ParseConfigMap checks a ConfigMap is given as `namespace/name`.
+func ParseConfigMap(s string) (namespace, name string, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid ConfigMap %q; expected namespace/name", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SetSubstitutions says to substitute values for variables in the
+// resources synced from now on.
+func (c *Cluster) SetSubstitutions(s Substitutions) {
+	// Set among the other actions, so it doesn't change under a sync
+	c.actionc <- func() {
+		c.substitutions = &s
+	}
+}
+
+// substitute renders the definitions in the actions given. Actions
+// that can't be rendered are left out, and given an error instead.
+func (c *Cluster) substitute(actions []platform.SyncAction) ([]platform.SyncAction, platform.SyncError) {
+	errs := platform.SyncError{}
+	if c.substitutions == nil {
+		return actions, errs
+	}
+	vars, err := c.substitutionVars()
+	if err != nil {
+		for _, action := range actions {
+			errs[action.ResourceID] = err
+		}
+		return nil, errs
+	}
+
+	var rendered []platform.SyncAction
+	for _, action := range actions {
+		var err error
+		if len(action.Delete) > 0 {
+			action.Delete, err = substitute(action.Delete, vars)
+		}
+		if err == nil && len(action.Apply) > 0 {
+			action.Apply, err = substitute(action.Apply, vars)
+		}
+		if err != nil {
+			errs[action.ResourceID] = err
+			continue
+		}
+		rendered = append(rendered, action)
+	}
+	return rendered, errs
+}
+
+func (c *Cluster) substitutionVars() (map[string]string, error) {
+	vars := map[string]string{}
+	if c.substitutions.ConfigMap != "" {
+		namespace, name, err := ParseConfigMap(c.substitutions.ConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		configMap, err := c.client.ConfigMaps(namespace).Get(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting values of variables from ConfigMap %s", c.substitutions.ConfigMap)
+		}
+		for k, v := range configMap.Data {
+			vars[k] = v
+		}
+	}
+	for k, v := range c.substitutions.Vars {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// substitute puts the values given in place of the variables in the
+// definition.
+func substitute(def []byte, vars map[string]string) ([]byte, error) {
+	var err error
+	rendered := variableRE.ReplaceAllStringFunc(string(def), func(v string) string {
+		if err != nil {
+			return v
+		}
+		if strings.HasPrefix(v, "$$") {
+			return v[1:]
+		}
+		name := v[2 : len(v)-1]
+		if !variableNameRE.MatchString(name) {
+			err = fmt.Errorf("invalid variable name %q", name)
+			return v
+		}
+		value, ok := vars[name]
+		if !ok {
+			err = fmt.Errorf("variable %s has no value", name)
+			return v
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			err = fmt.Errorf("value of variable %s has a line break", name)
+			return v
+		}
+		return value
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(rendered), nil
+}
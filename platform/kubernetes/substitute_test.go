@@ -0,0 +1,130 @@
+package kubernetes
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux/platform"
+)
+
+func TestSubstitute(t *testing.T) {
+	vars := map[string]string{"CLUSTER_NAME": "prod", "replicas": "3", "multi": "a\nb"}
+	for _, c := range []struct {
+		def, expected string
+	}{
+		{"name: helloworld", "name: helloworld"},
+		{"name: helloworld-${CLUSTER_NAME}", "name: helloworld-prod"},
+		{"name: ${CLUSTER_NAME}\nreplicas: ${replicas}\n", "name: prod\nreplicas: 3\n"},
+		{"args: [\"echo $${HOME} $HOME\"]", "args: [\"echo ${HOME} $HOME\"]"},
+	} {
+		got, err := substitute([]byte(c.def), vars)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c.def, err)
+			continue
+		}
+		if string(got) != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.def, c.expected, got)
+		}
+	}
+	for _, def := range []string{
+		"name: ${UNDEFINED}",
+		"name: ${}",
+		"name: ${CLUSTER-NAME}",
+		"name: ${multi}",
+	} {
+		if _, err := substitute([]byte(def), vars); err == nil {
+			t.Errorf("%q: expected an error", def)
+		}
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"CLUSTER_NAME=prod", "EMPTY=", "URL=http://example.com/?a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"CLUSTER_NAME": "prod", "EMPTY": "", "URL": "http://example.com/?a=b"}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("expected %v, got %v", expected, vars)
+	}
+	for _, def := range []string{"CLUSTER_NAME", "=prod", "CLUSTER-NAME=prod"} {
+		if _, err := ParseVars([]string{def}); err == nil {
+			t.Errorf("%q: expected an error", def)
+		}
+	}
+}
+
+// Just enough of the core API to look up a ConfigMap.
+type mockConfigMaps struct {
+	v1core.CoreInterface
+	v1core.ConfigMapInterface
+	data map[string]string
+}
+
+func (m mockConfigMaps) ConfigMaps(namespace string) v1core.ConfigMapInterface {
+	return m
+}
+
+func (m mockConfigMaps) Get(name string) (*v1.ConfigMap, error) {
+	if m.data == nil {
+		return nil, errors.New("not found")
+	}
+	return &v1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: name}, Data: m.data}, nil
+}
+
+func TestSyncSubstitutions(t *testing.T) {
+	def := platform.SyncDef{
+		Actions: []platform.SyncAction{
+			{ResourceID: "plain", Apply: deploymentDef("plain")},
+			{ResourceID: "cluster", Apply: deploymentDef("helloworld-${CLUSTER_NAME}")},
+			{ResourceID: "region", Apply: deploymentDef("helloworld-${REGION}")},
+		},
+	}
+
+	// Values from flags win over those from the ConfigMap; resources
+	// with variables lacking values aren't applied
+	kube, mock := setupWithNamespaces(t, NamespaceIgnore)
+	kube.client.CoreInterface = mockConfigMaps{data: map[string]string{"CLUSTER_NAME": "dev"}}
+	kube.SetSubstitutions(Substitutions{Vars: map[string]string{"CLUSTER_NAME": "prod"}, ConfigMap: "flux/values"})
+	err := kube.Sync(def)
+	syncErr, ok := err.(platform.SyncError)
+	if !ok || len(syncErr) != 1 || syncErr["region"] == nil {
+		t.Errorf("expected an error for resource %q only, got %#v", "region", err)
+	}
+	expected := []command{
+		{"apply", "plain"},
+		{"apply", "helloworld-prod"},
+	}
+	if !reflect.DeepEqual(expected, mock.commands) {
+		t.Errorf("expected commands:\n%#v\ngot:\n%#v", expected, mock.commands)
+	}
+
+	// The ConfigMap is read each time
+	mock.commands = nil
+	kube.client.CoreInterface = mockConfigMaps{data: map[string]string{"REGION": "eu"}}
+	if err := kube.Sync(def); err != nil {
+		t.Fatal(err)
+	}
+	expected = []command{
+		{"apply", "plain"},
+		{"apply", "helloworld-prod"},
+		{"apply", "helloworld-eu"},
+	}
+	if !reflect.DeepEqual(expected, mock.commands) {
+		t.Errorf("expected commands:\n%#v\ngot:\n%#v", expected, mock.commands)
+	}
+
+	// Without the ConfigMap, nothing is applied
+	mock.commands = nil
+	kube.client.CoreInterface = mockConfigMaps{}
+	if err, ok := kube.Sync(def).(platform.SyncError); !ok || len(err) != 3 {
+		t.Errorf("expected an error for each resource, got %#v", err)
+	}
+	if len(mock.commands) > 0 {
+		t.Errorf("expected no commands run, got %#v", mock.commands)
+	}
+}
@@ -19,8 +19,13 @@ import (
 // This function has many additional requirements that are likely in flux. Read
 // the source to learn about them.
 func UpdatePodController(def []byte, newImageID flux.ImageID, trace io.Writer) ([]byte, error) {
+	// The updater works on canonical YAML; remember how this file
+	// differs, so the result can be written back the same way.
+	var style manifestStyle
+	normalised := style.normalise(def)
+
 	// Sanity check
-	obj, err := definitionObj(def)
+	obj, err := definitionObj([]byte(normalised))
 	if err != nil {
 		return nil, err
 	}
@@ -34,8 +39,10 @@ func UpdatePodController(def []byte, newImageID flux.ImageID, trace io.Writer) (
 	}
 
 	var buf bytes.Buffer
-	err = tryUpdate(string(def), newImageID, trace, &buf)
-	return buf.Bytes(), err
+	if err = tryUpdate(normalised, newImageID, trace, &buf); err != nil {
+		return nil, err
+	}
+	return style.restore(buf.String())
 }
 
 // Attempt to update an RC or Deployment config. This makes several assumptions
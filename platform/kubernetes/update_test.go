@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/weaveworks/flux"
@@ -34,6 +35,65 @@ func TestUpdates(t *testing.T) {
 	}
 }
 
+// windowsStyle re-indents a definition with four spaces per level,
+// and gives it CRLF line endings and a byte order mark.
+func windowsStyle(def string) []byte {
+	lines := strings.Split(def, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		lines[i] = strings.Repeat(" ", 2*(len(line)-len(trimmed))) + trimmed
+	}
+	return append([]byte("\xef\xbb\xbf"), strings.Join(lines, "\r\n")...)
+}
+
+func TestUpdatePreservesStyle(t *testing.T) {
+	id, err := flux.ParseImageID(case1image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	out, err := UpdatePodController(windowsStyle(case1), id, &trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := windowsStyle(case1out); !bytes.Equal(out, expected) {
+		t.Fatalf("Did not get expected result:\n\n%q\n\nInstead got:\n\n%q", expected, out)
+	}
+}
+
+// mixedLineEndings gives every other line of a definition a CRLF
+// line ending, as when a file has been edited on different platforms.
+func mixedLineEndings(def string) []byte {
+	lines := strings.Split(def, "\n")
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i > 0 {
+			if i%2 == 1 {
+				buf.WriteString("\r\n")
+			} else {
+				buf.WriteString("\n")
+			}
+		}
+		buf.WriteString(line)
+	}
+	return buf.Bytes()
+}
+
+func TestUpdatePreservesMixedLineEndings(t *testing.T) {
+	id, err := flux.ParseImageID(case1image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	out, err := UpdatePodController(mixedLineEndings(case1), id, &trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := mixedLineEndings(case1out); !bytes.Equal(out, expected) {
+		t.Fatalf("Did not get expected result:\n\n%q\n\nInstead got:\n\n%q", expected, out)
+	}
+}
+
 // Unusual but still valid indentation between containers: and the
 // next line
 const case1 = `---
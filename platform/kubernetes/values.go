@@ -0,0 +1,304 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// UpdateEnv sets the value of an environment variable in the
+// containers of a Deployment (or any resource with a pod template).
+// If container is blank, the variable is set in every container that
+// has it; otherwise, only in the container named. It reports whether
+// anything was changed. Variables are only changed, not added, and
+// variables that take their value from elsewhere (`valueFrom`) are an
+// error.
+func UpdateEnv(def []byte, container, name, value string) ([]byte, bool, error) {
+	var style manifestStyle
+	lines := strings.Split(style.normalise(def), "\n")
+
+	var changed bool
+	for _, key := range keyLines(lines, "containers") {
+		for _, c := range listItems(lines, key) {
+			if container != "" {
+				if _, n := itemField(lines, c, "name"); n != container {
+					continue
+				}
+			}
+			envKey, _ := itemField(lines, c, "env")
+			if envKey < 0 {
+				continue
+			}
+			for _, env := range listItems(lines, envKey) {
+				if _, n := itemField(lines, env, "name"); n != name {
+					continue
+				}
+				if from, _ := itemField(lines, env, "valueFrom"); from >= 0 {
+					return nil, false, fmt.Errorf("environment variable %s takes its value from elsewhere (valueFrom), so cannot be set", name)
+				}
+				v, old := itemField(lines, env, "value")
+				if v < 0 {
+					return nil, false, fmt.Errorf("environment variable %s has no value to update", name)
+				}
+				if old == value {
+					continue
+				}
+				lines[v] = setField(lines[v], value)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return def, false, nil
+	}
+	updated, err := style.restore(strings.Join(lines, "\n"))
+	return updated, err == nil, err
+}
+
+// UpdateConfigMapData sets the value of a data key in a ConfigMap
+// definition, and reports whether it was changed. Keys are only
+// changed, not added; and only values on a single line can be
+// changed.
+func UpdateConfigMapData(def []byte, key, value string) ([]byte, bool, error) {
+	var style manifestStyle
+	lines := strings.Split(style.normalise(def), "\n")
+
+	var changed bool
+	for _, data := range keyLines(lines, "data") {
+		if leadingSpaces(lines[data]) != 0 {
+			continue
+		}
+		for i := data + 1; i < len(lines); i++ {
+			trimmed := strings.TrimSpace(lines[i])
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			if leadingSpaces(lines[i]) == 0 {
+				break
+			}
+			k, old, ok := splitField(trimmed)
+			if !ok || k != key {
+				continue
+			}
+			if strings.HasPrefix(old, "|") || strings.HasPrefix(old, ">") {
+				return nil, false, fmt.Errorf("ConfigMap key %s has a multi-line value, which cannot be updated", key)
+			}
+			if old == value {
+				continue
+			}
+			lines[i] = setField(lines[i], value)
+			changed = true
+		}
+	}
+	if !changed {
+		return def, false, nil
+	}
+	updated, err := style.restore(strings.Join(lines, "\n"))
+	return updated, err == nil, err
+}
+
+// FindConfigMap finds the file defining the ConfigMap given, under
+// the directory given, or returns blank if there is none. Only files
+// defining a single resource are considered.
+func FindConfigMap(path, namespace, name string) (string, error) {
+	var found string
+	err := filepath.Walk(path, func(target string, info os.FileInfo, err error) error {
+		if err != nil || found != "" {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(target); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		def, err := ioutil.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		var docs int
+		for _, doc := range documentSeparator.Split(string(def), -1) {
+			if strings.TrimSpace(doc) != "" {
+				docs++
+			}
+		}
+		if docs != 1 {
+			return nil
+		}
+		var obj struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal(def, &obj); err != nil {
+			return nil
+		}
+		ns := obj.Metadata.Namespace
+		if ns == "" {
+			ns = "default"
+		}
+		if obj.Kind == "ConfigMap" && obj.Metadata.Name == name && ns == namespace {
+			found = target
+		}
+		return nil
+	})
+	return found, err
+}
+
+var fieldRE = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s:#'"][^:#]*?):(?:\s+(.*))?$`)
+
+// splitField splits a line (without indentation or list dash) like
+// `key: value` into the key and value, without quotes.
+func splitField(s string) (string, string, bool) {
+	m := fieldRE.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", false
+	}
+	return unquote(m[1]), unquote(stripComment(m[2])), true
+}
+
+func stripComment(s string) string {
+	if i := strings.Index(s, " #"); i >= 0 && !strings.HasPrefix(s, `"`) && !strings.HasPrefix(s, `'`) {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+// setField replaces the value of the field on the line given,
+// keeping the indentation, any list dash, and the key as written.
+func setField(line, value string) string {
+	i := leadingSpaces(line)
+	if strings.HasPrefix(line[i:], "- ") {
+		i += 2
+	}
+	m := fieldRE.FindStringSubmatch(line[i:])
+	return line[:i] + m[1] + ": " + quoteString(value)
+}
+
+var plainString = regexp.MustCompile(`^[a-zA-Z_/][\w./@-]*$`)
+
+// quoteString quotes a value so that it's read as a string, unless it
+// obviously will be anyway.
+func quoteString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null":
+		return strconv.Quote(s)
+	}
+	if plainString.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// keyLines gives the lines that have the key given, with a block
+// value (i.e., nothing following on the line).
+func keyLines(lines []string, key string) []int {
+	var found []int
+	for i, line := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		if k, v, ok := splitField(trimmed); ok && k == key && v == "" {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// listItems gives the first and (exclusive) last lines of each item
+// in the block sequence which is the value of the key on line `key`.
+// The items may be indented the same as the key, or further.
+func listItems(lines []string, key int) [][2]int {
+	keyIndent := leadingSpaces(lines[key])
+	if strings.HasPrefix(strings.TrimSpace(lines[key]), "- ") {
+		keyIndent += 2
+	}
+	var items [][2]int
+	itemIndent, end := -1, len(lines)
+	for i := key + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := leadingSpaces(lines[i])
+		dash := strings.HasPrefix(trimmed, "- ")
+		if itemIndent < 0 {
+			if indent < keyIndent || !dash {
+				end = i
+				break
+			}
+			itemIndent = indent
+		}
+		if indent < itemIndent || indent == itemIndent && !dash {
+			end = i
+			break
+		}
+		if indent == itemIndent {
+			if len(items) > 0 {
+				items[len(items)-1][1] = i
+			}
+			items = append(items, [2]int{i, end})
+		}
+	}
+	if len(items) > 0 {
+		items[len(items)-1][1] = end
+	}
+	return items
+}
+
+// itemField finds a field of a list item (which may be on the same
+// line as the dash), and gives its line and value; or -1 if it's not
+// there.
+func itemField(lines []string, item [2]int, field string) (int, string) {
+	fieldIndent := leadingSpaces(lines[item[0]]) + 2
+	for i := item[0]; i < item[1]; i++ {
+		line := lines[i]
+		if i == item[0] {
+			line = strings.Repeat(" ", fieldIndent) + strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		}
+		if leadingSpaces(line) != fieldIndent {
+			continue
+		}
+		if k, v, ok := splitField(strings.TrimSpace(line)); ok && k == field {
+			return i, v
+		}
+	}
+	return -1, ""
+}
+
+// ContainerImageLines gives, for each container of the pod template
+// in the definition, the (zero-based) line on which its image is
+// given.
+func ContainerImageLines(def []byte) map[string]int {
+	var style manifestStyle
+	lines := strings.Split(style.normalise(def), "\n")
+
+	images := map[string]int{}
+	for _, key := range keyLines(lines, "containers") {
+		for _, c := range listItems(lines, key) {
+			_, name := itemField(lines, c, "name")
+			if image, _ := itemField(lines, c, "image"); image >= 0 {
+				images[name] = image
+			}
+		}
+	}
+	return images
+}
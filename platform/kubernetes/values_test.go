@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
+)
+
+const envDeployment = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+        env:
+        - name: GREETING
+          value: hello # the greeting
+        - name: SECRET
+          valueFrom:
+            secretKeyRef:
+              name: greeter
+              key: secret
+      - name: sidecar
+        image: quay.io/weaveworks/sidecar:master-a000002
+        env:
+          - name: GREETING
+            value: "hi"
+`
+
+func TestUpdateEnv(t *testing.T) {
+	for _, c := range []struct {
+		container, value string
+		changed          bool
+		expected         string
+	}{
+		{"", "hello", true, `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+        env:
+        - name: GREETING
+          value: hello # the greeting
+        - name: SECRET
+          valueFrom:
+            secretKeyRef:
+              name: greeter
+              key: secret
+      - name: sidecar
+        image: quay.io/weaveworks/sidecar:master-a000002
+        env:
+          - name: GREETING
+            value: hello
+`},
+		{"greeter", "good day", true, `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+        env:
+        - name: GREETING
+          value: "good day"
+        - name: SECRET
+          valueFrom:
+            secretKeyRef:
+              name: greeter
+              key: secret
+      - name: sidecar
+        image: quay.io/weaveworks/sidecar:master-a000002
+        env:
+          - name: GREETING
+            value: "hi"
+`},
+		{"sidecar", "hi", false, envDeployment},
+		{"nosuchcontainer", "hi", false, envDeployment},
+	} {
+		out, changed, err := UpdateEnv([]byte(envDeployment), c.container, "GREETING", c.value)
+		if err != nil {
+			t.Fatalf("container %q: %v", c.container, err)
+		}
+		if changed != c.changed {
+			t.Errorf("container %q: expected changed=%v, got %v", c.container, c.changed, changed)
+		}
+		if string(out) != c.expected {
+			t.Errorf("container %q: expected:\n%s\ngot:\n%s", c.container, c.expected, string(out))
+		}
+	}
+
+	if _, _, err := UpdateEnv([]byte(envDeployment), "", "SECRET", "guess"); err == nil {
+		t.Error("expected error setting a variable with valueFrom")
+	}
+}
+
+func TestContainerImageLines(t *testing.T) {
+	lines := ContainerImageLines([]byte(envDeployment))
+	expected := map[string]int{"greeter": 10, "sidecar": 20}
+	if !reflect.DeepEqual(expected, lines) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
+
+const configMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: greeter-config
+  namespace: extra
+data:
+  log.level: info
+  greeting: "hello"
+  banner: |
+    Welcome
+`
+
+func TestUpdateConfigMapData(t *testing.T) {
+	out, changed, err := UpdateConfigMapData([]byte(configMap), "log.level", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: greeter-config
+  namespace: extra
+data:
+  log.level: debug
+  greeting: "hello"
+  banner: |
+    Welcome
+`
+	if !changed || string(out) != expected {
+		t.Errorf("expected (changed) :\n%s\ngot (changed=%v):\n%s", expected, changed, string(out))
+	}
+
+	if _, changed, _ = UpdateConfigMapData([]byte(configMap), "greeting", "hello"); changed {
+		t.Error("expected no change when the value is the same")
+	}
+	if _, changed, _ = UpdateConfigMapData([]byte(configMap), "missing", "value"); changed {
+		t.Error("expected no change when the key is not present")
+	}
+	if _, _, err = UpdateConfigMapData([]byte(configMap), "banner", "Hi"); err == nil {
+		t.Error("expected error updating a multi-line value")
+	}
+}
+
+func TestFindConfigMap(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	if err := testfiles.WriteTestFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "greeter-config.yaml")
+	if err := ioutil.WriteFile(path, []byte(configMap), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := FindConfigMap(dir, "extra", "greeter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != path {
+		t.Errorf("expected to find %q, got %q", path, found)
+	}
+	found, err = FindConfigMap(dir, "default", "greeter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != "" {
+		t.Errorf("expected not to find ConfigMap in another namespace, got %q", found)
+	}
+}
@@ -1,7 +1,9 @@
 package platform
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
@@ -20,6 +22,12 @@ var (
 		Help:      "Request duration in seconds.",
 		Buckets:   stdprometheus.DefBuckets,
 	}, []string{fluxmetrics.LabelMethod, fluxmetrics.LabelSuccess})
+	breakerTrips = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "platform",
+		Name:      "breaker_trips_total",
+		Help:      "Count of times calls to a daemon have been stopped because too many in a row failed to reach it.",
+	}, []string{})
 )
 
 type instrumentedPlatform struct {
@@ -30,6 +38,10 @@ func Instrument(p Platform) Platform {
 	return &instrumentedPlatform{p}
 }
 
+func (i *instrumentedPlatform) WithTrace(ctx context.Context) Platform {
+	return &instrumentedPlatform{WithTrace(ctx, i.p)}
+}
+
 func (i *instrumentedPlatform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) (svcs []Service, err error) {
 	defer func(begin time.Time) {
 		requestDuration.With(
@@ -90,6 +102,16 @@ func (i *instrumentedPlatform) Export() (config []byte, err error) {
 	return i.p.Export()
 }
 
+func (i *instrumentedPlatform) ExportTo(w io.Writer) (err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "ExportTo",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.p.ExportTo(w)
+}
+
 func (i *instrumentedPlatform) Sync(spec SyncDef) (err error) {
 	defer func(begin time.Time) {
 		requestDuration.With(
@@ -100,6 +122,26 @@ func (i *instrumentedPlatform) Sync(spec SyncDef) (err error) {
 	return i.p.Sync(spec)
 }
 
+func (i *instrumentedPlatform) RegistryCredentials() (auths map[string]flux.Auth, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "RegistryCredentials",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.p.RegistryCredentials()
+}
+
+func (i *instrumentedPlatform) Trace(d time.Duration) (err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "Trace",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.p.Trace(d)
+}
+
 // BusMetrics has metrics for messages buses.
 type BusMetrics struct {
 	KickCount metrics.Counter
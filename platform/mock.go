@@ -1,10 +1,14 @@
 package platform
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/flux"
 )
@@ -31,6 +35,12 @@ type MockPlatform struct {
 
 	SyncArgTest func(SyncDef) error
 	SyncError   error
+
+	TraceArgTest func(time.Duration) error
+	TraceError   error
+
+	RegistryCredentialsAnswer map[string]flux.Auth
+	RegistryCredentialsError  error
 }
 
 func (p *MockPlatform) AllServices(ns string, ss flux.ServiceIDSet) ([]Service, error) {
@@ -72,6 +82,14 @@ func (p *MockPlatform) Export() ([]byte, error) {
 	return p.ExportAnswer, p.ExportError
 }
 
+func (p *MockPlatform) ExportTo(w io.Writer) error {
+	if p.ExportError != nil {
+		return p.ExportError
+	}
+	_, err := w.Write(p.ExportAnswer)
+	return err
+}
+
 func (p *MockPlatform) Sync(def SyncDef) error {
 	if p.SyncArgTest != nil {
 		if err := p.SyncArgTest(def); err != nil {
@@ -81,6 +99,19 @@ func (p *MockPlatform) Sync(def SyncDef) error {
 	return p.SyncError
 }
 
+func (p *MockPlatform) Trace(d time.Duration) error {
+	if p.TraceArgTest != nil {
+		if err := p.TraceArgTest(d); err != nil {
+			return err
+		}
+	}
+	return p.TraceError
+}
+
+func (p *MockPlatform) RegistryCredentials() (map[string]flux.Auth, error) {
+	return p.RegistryCredentialsAnswer, p.RegistryCredentialsError
+}
+
 // -- battery of tests for a platform mechanism
 
 func PlatformTestBattery(t *testing.T, wrap func(mock Platform) Platform) {
@@ -129,6 +160,8 @@ func PlatformTestBattery(t *testing.T, wrap func(mock Platform) Platform) {
 		},
 	}
 
+	traceDuration := 10 * time.Minute
+
 	mock := &MockPlatform{
 		AllServicesArgTest: func(ns string, ss flux.ServiceIDSet) error {
 			if !(ns == namespace &&
@@ -162,6 +195,17 @@ func PlatformTestBattery(t *testing.T, wrap func(mock Platform) Platform) {
 			return nil
 		},
 		SyncError: nil,
+
+		TraceArgTest: func(d time.Duration) error {
+			if d != traceDuration {
+				return fmt.Errorf("did not get expected trace duration, got %s", d)
+			}
+			return nil
+		},
+
+		RegistryCredentialsAnswer: map[string]flux.Auth{
+			"quay.io": {Auth: "dXNlcjpwYXNzd29yZA=="},
+		},
 	}
 
 	// OK, here we go
@@ -224,4 +268,38 @@ func PlatformTestBattery(t *testing.T, wrap func(mock Platform) Platform) {
 	if !reflect.DeepEqual(flux.UnderlyingError(err), syncErrors) {
 		t.Errorf("expected SyncError, got %+v: %s", err, err.Error())
 	}
+
+	if err := client.Trace(traceDuration); err != nil {
+		t.Error(err)
+	}
+	mock.TraceError = errors.New("no tracing here")
+	if err := client.Trace(traceDuration); err == nil {
+		t.Error("expected error from Trace, got nil")
+	}
+
+	auths, err := client.RegistryCredentials()
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(auths, mock.RegistryCredentialsAnswer) {
+		t.Errorf("expected registry credentials %+v, got %+v", mock.RegistryCredentialsAnswer, auths)
+	}
+	mock.RegistryCredentialsError = errors.New("no secrets here")
+	if _, err := client.RegistryCredentials(); err == nil {
+		t.Error("expected error from RegistryCredentials, got nil")
+	}
+
+	// Big enough to come in more than one piece, where it's sent in
+	// pieces
+	mock.ExportAnswer = bytes.Repeat([]byte("---\nkind: Service\nmetadata:\n  name: frobnicator\n"), 10000)
+	var exported bytes.Buffer
+	if err := client.ExportTo(&exported); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(exported.Bytes(), mock.ExportAnswer) {
+		t.Errorf("expected export of %d bytes, got %d bytes", len(mock.ExportAnswer), exported.Len())
+	}
+	mock.ExportError = errors.New("export failed")
+	if err := client.ExportTo(ioutil.Discard); err == nil {
+		t.Error("expected error from ExportTo, got nil")
+	}
 }
@@ -5,7 +5,9 @@ package platform
 
 import (
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -25,6 +27,18 @@ type PlatformV5 interface {
 	// Additional methods accumulate here as we develop V5
 	Export() ([]byte, error)
 	Sync(SyncDef) error
+	// Trace puts the daemon in trace mode for the duration given (or
+	// takes it out, if the duration is zero), during which it logs
+	// each operation it's asked to do, and how long it took.
+	Trace(time.Duration) error
+	// ExportTo writes what Export gives, as it's generated, so that
+	// a big export needn't be held in memory, or sent in one message.
+	ExportTo(io.Writer) error
+	// RegistryCredentials gives credentials for image registries that
+	// the platform has (e.g., in image pull secrets), keyed by
+	// registry host, as in the auths of an instance's registry
+	// config.
+	RegistryCredentials() (map[string]flux.Auth, error)
 }
 
 // Platform is the interface various platforms fulfill, e.g.
@@ -75,6 +89,7 @@ type Service struct {
 	ID       flux.ServiceID
 	IP       string
 	Metadata map[string]string // a grab bag of goodies, likely platform-specific
+	Labels   map[string]string // for selecting services, e.g., in a release
 	Status   string            // A status summary for display
 
 	Containers ContainersOrExcuse
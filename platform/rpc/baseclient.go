@@ -1,6 +1,9 @@
 package rpc
 
 import (
+	"io"
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
@@ -35,6 +38,18 @@ func (bc baseClient) Export() ([]byte, error) {
 	return nil, platform.UpgradeNeededError(errors.New("Export method not implemented"))
 }
 
+func (bc baseClient) ExportTo(io.Writer) error {
+	return platform.UpgradeNeededError(errors.New("Export method not implemented"))
+}
+
 func (bc baseClient) Sync(platform.SyncDef) error {
 	return platform.UpgradeNeededError(errors.New("Sync method not implemented"))
 }
+
+func (bc baseClient) Trace(time.Duration) error {
+	return platform.UpgradeNeededError(errors.New("Trace method not implemented"))
+}
+
+func (bc baseClient) RegistryCredentials() (map[string]flux.Auth, error) {
+	return nil, platform.UpgradeNeededError(errors.New("RegistryCredentials method not implemented"))
+}
@@ -1,10 +1,10 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/platform"
@@ -15,13 +15,28 @@ import (
 type RPCClientV4 struct {
 	*baseClient
 	client *rpc.Client
+	ctx    context.Context
 }
 
 var _ platform.PlatformV4 = &RPCClientV4{}
 
 // NewClient creates a new rpc-backed implementation of the platform.
 func NewClientV4(conn io.ReadWriteCloser) *RPCClientV4 {
-	return &RPCClientV4{&baseClient{}, jsonrpc.NewClient(conn)}
+	return &RPCClientV4{&baseClient{}, rpc.NewClientWithCodec(newClientCodec(conn)), nil}
+}
+
+// WithTrace gives a client whose calls carry the trace in the
+// context, so the daemon's side of them is part of the trace.
+func (p *RPCClientV4) WithTrace(ctx context.Context) platform.Platform {
+	return p.withTrace(ctx)
+}
+
+func (p *RPCClientV4) withTrace(ctx context.Context) *RPCClientV4 {
+	return &RPCClientV4{p.baseClient, p.client, ctx}
+}
+
+func (p *RPCClientV4) call(method string, args, reply interface{}) error {
+	return p.client.Call(method, traceArgs(p.ctx, args), reply)
 }
 
 // AllServicesRequest is the request datastructure for AllServices
@@ -33,14 +48,14 @@ type AllServicesRequestV4 struct {
 // AllServices asks the remote platform to list all services.
 func (p *RPCClientV4) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) ([]platform.Service, error) {
 	var s []platform.Service
-	err := p.client.Call("RPCServer.AllServices", AllServicesRequestV4{maybeNamespace, ignored}, &s)
+	err := p.call("RPCServer.AllServices", AllServicesRequestV4{maybeNamespace, ignored}, &s)
 	return s, CategoriseRPCError(err)
 }
 
 // SomeServices asks the remote platform about some specific set of services.
 func (p *RPCClientV4) SomeServices(ids []flux.ServiceID) ([]platform.Service, error) {
 	var s []platform.Service
-	err := p.client.Call("RPCServer.SomeServices", ids, &s)
+	err := p.call("RPCServer.SomeServices", ids, &s)
 	return s, CategoriseRPCError(err)
 }
 
@@ -49,7 +64,7 @@ func (p *RPCClientV4) Apply(defs []platform.ServiceDefinition) error {
 	var applyErrors ApplyResult
 	// TODO: This is still calling "Regrade" for backwards compatibility with old
 	// fluxds. Change this to "Apply" when we do a major version release.
-	if err := p.client.Call("RPCServer.Regrade", defs, &applyErrors); err != nil {
+	if err := p.call("RPCServer.Regrade", defs, &applyErrors); err != nil {
 		return CategoriseRPCError(err)
 	}
 	if len(applyErrors) > 0 {
@@ -64,14 +79,14 @@ func (p *RPCClientV4) Apply(defs []platform.ServiceDefinition) error {
 
 // Ping is used to check if the remote platform is available.
 func (p *RPCClientV4) Ping() error {
-	err := p.client.Call("RPCServer.Ping", struct{}{}, nil)
+	err := p.call("RPCServer.Ping", struct{}{}, nil)
 	return CategoriseRPCError(err)
 }
 
 // Version is used to check if the remote platform is available
 func (p *RPCClientV4) Version() (string, error) {
 	var version string
-	err := p.client.Call("RPCServer.Version", struct{}{}, &version)
+	err := p.call("RPCServer.Version", struct{}{}, &version)
 	if _, ok := err.(rpc.ServerError); !ok && err != nil {
 		return "", platform.FatalError{err}
 	} else if err != nil && err.Error() == "rpc: can't find method RPCServer.Version" {
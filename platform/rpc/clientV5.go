@@ -1,9 +1,12 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"io"
+	"time"
 
+	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/platform"
 )
 
@@ -20,16 +23,33 @@ func NewClientV5(conn io.ReadWriteCloser) *RPCClientV5 {
 	return &RPCClientV5{NewClientV4(conn)}
 }
 
+// WithTrace gives a client whose calls carry the trace in the
+// context, so the daemon's side of them is part of the trace.
+func (p *RPCClientV5) WithTrace(ctx context.Context) platform.Platform {
+	return &RPCClientV5{p.RPCClientV4.withTrace(ctx)}
+}
+
 // Export is used to get service configuration in platform-specific format
 func (p *RPCClientV5) Export() ([]byte, error) {
 	var config []byte
-	err := p.client.Call("RPCServer.Export", struct{}{}, &config)
+	err := p.call("RPCServer.Export", struct{}{}, &config)
 	return config, CategoriseRPCError(err)
 }
 
+// ExportTo writes the export, which comes in one reply from daemons
+// connected by RPC.
+func (p *RPCClientV5) ExportTo(w io.Writer) error {
+	config, err := p.Export()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(config)
+	return err
+}
+
 func (p *RPCClientV5) Sync(spec platform.SyncDef) error {
 	var result SyncResult
-	if err := p.client.Call("RPCServer.Sync", spec, &result); err != nil {
+	if err := p.call("RPCServer.Sync", spec, &result); err != nil {
 		return CategoriseRPCError(err)
 	}
 	if len(result) > 0 {
@@ -41,3 +61,19 @@ func (p *RPCClientV5) Sync(spec platform.SyncDef) error {
 	}
 	return nil
 }
+
+// Trace puts the daemon in trace mode. Daemons from before trace mode
+// existed don't have the method, so they get a (non-fatal) error.
+func (p *RPCClientV5) Trace(d time.Duration) error {
+	err := p.call("RPCServer.Trace", d, &struct{}{})
+	return CategoriseRPCError(err)
+}
+
+// RegistryCredentials gets the credentials the daemon has found for
+// image registries. Daemons from before it did so don't have the
+// method, so they get a (non-fatal) error.
+func (p *RPCClientV5) RegistryCredentials() (map[string]flux.Auth, error) {
+	var auths map[string]flux.Auth
+	err := p.call("RPCServer.RegistryCredentials", struct{}{}, &auths)
+	return auths, CategoriseRPCError(err)
+}
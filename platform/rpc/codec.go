@@ -0,0 +1,239 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"sync"
+
+	"github.com/weaveworks/flux/tracing"
+)
+
+// These codecs are JSON-RPC 1.0, as in net/rpc/jsonrpc, with the
+// addition of the trace a call is part of, if any, in a "trace"
+// field alongside the method and params. Daemons from before then
+// ignore the field, and clients from before then don't send it, so
+// either side can be older than the other.
+
+// tracedArgs are the arguments for a call that's part of a trace.
+type tracedArgs struct {
+	trace tracing.SpanContext
+	args  interface{}
+}
+
+// traceArgs gives the arguments to send for a call, carrying the
+// trace in the context, if there is one.
+func traceArgs(ctx context.Context, args interface{}) interface{} {
+	if ctx == nil {
+		return args
+	}
+	if trace, ok := tracing.FromContext(ctx); ok {
+		return tracedArgs{trace: trace, args: args}
+	}
+	return args
+}
+
+type clientCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+
+	req  clientRequest
+	resp clientResponse
+
+	mu      sync.Mutex // protects pending
+	pending map[uint64]string
+}
+
+func newClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: map[uint64]string{},
+	}
+}
+
+type clientRequest struct {
+	Method string               `json:"method"`
+	Params [1]interface{}       `json:"params"`
+	ID     uint64               `json:"id"`
+	Trace  *tracing.SpanContext `json:"trace,omitempty"`
+}
+
+type clientResponse struct {
+	ID     uint64           `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+// WriteRequest is only called by one goroutine at a time, so it's
+// fine to reuse the request.
+func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mu.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mu.Unlock()
+
+	c.req.Method = r.ServiceMethod
+	c.req.ID = r.Seq
+	c.req.Trace = nil
+	if traced, ok := param.(tracedArgs); ok {
+		c.req.Trace = &traced.trace
+		param = traced.args
+	}
+	c.req.Params[0] = param
+	return c.enc.Encode(&c.req)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp = clientResponse{}
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	r.ServiceMethod = c.pending[c.resp.ID]
+	delete(c.pending, c.resp.ID)
+	c.mu.Unlock()
+
+	r.Error = ""
+	r.Seq = c.resp.ID
+	if c.resp.Error != nil || c.resp.Result == nil {
+		msg, ok := c.resp.Error.(string)
+		if !ok {
+			return fmt.Errorf("invalid error %v", c.resp.Error)
+		}
+		if msg == "" {
+			msg = "unspecified error"
+		}
+		r.Error = msg
+	}
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *clientCodec) Close() error {
+	return c.c.Close()
+}
+
+var errMissingParams = errors.New("jsonrpc: request body missing params")
+
+// serverCodec gives each call that's part of a trace a span, from
+// when it's read until its response is written.
+type serverCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+
+	req serverRequest
+
+	// Request IDs can be any JSON value, whereas net/rpc wants a
+	// sequence number; so we give it one, and keep the ID (and span,
+	// if any) until the response is written.
+	mu      sync.Mutex // protects seq, pending
+	seq     uint64
+	pending map[uint64]pendingRequest
+}
+
+type pendingRequest struct {
+	id   *json.RawMessage
+	span *tracing.Span
+}
+
+func newServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: map[uint64]pendingRequest{},
+	}
+}
+
+type serverRequest struct {
+	Method string               `json:"method"`
+	Params *json.RawMessage     `json:"params"`
+	ID     *json.RawMessage     `json:"id"`
+	Trace  *tracing.SpanContext `json:"trace"`
+}
+
+type serverResponse struct {
+	ID     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req = serverRequest{}
+	if err := c.dec.Decode(&c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+
+	pending := pendingRequest{id: c.req.ID}
+	if c.req.Trace != nil && !c.req.Trace.IsZero() {
+		pending.span, _ = tracing.StartSpan(tracing.ContextWithSpan(context.Background(), *c.req.Trace), "rpc."+c.req.Method)
+	}
+
+	c.mu.Lock()
+	c.seq++
+	c.pending[c.seq] = pending
+	r.Seq = c.seq
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	if c.req.Params == nil {
+		return errMissingParams
+	}
+	// Params are an array holding the single argument
+	params := [1]interface{}{x}
+	return json.Unmarshal(*c.req.Params, &params)
+}
+
+var null = json.RawMessage([]byte("null"))
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mu.Lock()
+	pending, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mu.Unlock()
+	if !ok {
+		return errors.New("invalid sequence number in response")
+	}
+
+	id := pending.id
+	if id == nil {
+		// An invalid request, so no ID
+		id = &null
+	}
+	resp := serverResponse{ID: id}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = r.Error
+	}
+	if pending.span != nil {
+		if r.Error != "" {
+			pending.span.SetError(errors.New(r.Error))
+		}
+		pending.span.Finish()
+	}
+	return c.enc.Encode(resp)
+}
+
+func (c *serverCodec) Close() error {
+	return c.c.Close()
+}
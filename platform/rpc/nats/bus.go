@@ -1,7 +1,10 @@
 package nats
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -24,15 +27,24 @@ const (
 	defaultApplyTimeout = 20 * time.Minute
 	presenceTick        = 50 * time.Millisecond
 	encoderType         = nats.JSON_ENCODER
-
-	methodKick         = ".Platform.Kick"
-	methodPing         = ".Platform.Ping"
-	methodVersion      = ".Platform.Version"
-	methodAllServices  = ".Platform.AllServices"
-	methodSomeServices = ".Platform.SomeServices"
-	methodApply        = ".Platform.Apply"
-	methodExport       = ".Platform.Export"
-	methodSync         = ".Platform.Sync"
+	// An export is sent in chunks of about this size, well under the
+	// limit on the size of a message (1MB by default), even once
+	// they're encoded.
+	exportChunkSize = 64 * 1024
+	// How long to wait for each chunk of an export, after the first
+	exportChunkTimeout = time.Minute
+
+	methodKick                = ".Platform.Kick"
+	methodPing                = ".Platform.Ping"
+	methodVersion             = ".Platform.Version"
+	methodAllServices         = ".Platform.AllServices"
+	methodSomeServices        = ".Platform.SomeServices"
+	methodApply               = ".Platform.Apply"
+	methodExport              = ".Platform.Export"
+	methodExportTo            = ".Platform.ExportTo"
+	methodSync                = ".Platform.Sync"
+	methodRegistryCredentials = ".Platform.RegistryCredentials"
+	methodTrace               = ".Platform.Trace"
 )
 
 var applyTimeout = defaultApplyTimeout
@@ -143,11 +155,33 @@ type ExportResponse struct {
 	ErrorResponse
 }
 
+// ExportChunk is a piece of an export, sent as soon as it's been
+// generated. The first, which is empty, is sent straight away, to say
+// the export has begun; the last says whether it all went well. Seq
+// counts from zero, so that a lost chunk is noticed.
+type ExportChunk struct {
+	Seq  int
+	Data []byte
+	Last bool
+	ErrorResponse
+}
+
 type SyncResponse struct {
 	Result fluxrpc.SyncResult
 	ErrorResponse
 }
 
+type TraceResponse struct {
+	ErrorResponse
+}
+
+type registryCredentials struct{}
+
+type RegistryCredentialsResponse struct {
+	Auths map[string]flux.Auth
+	ErrorResponse
+}
+
 func extractError(resp ErrorResponse) error {
 	if resp.Error != "" {
 		if resp.Fatal {
@@ -257,6 +291,60 @@ func (r *natsPlatform) Export() ([]byte, error) {
 	return response.Config, extractError(response.ErrorResponse)
 }
 
+// ExportTo asks for the export in chunks, which are written as they
+// arrive, so neither the limit on the size of a message nor the
+// timeout for a request limits how big an export can be; only the
+// time between chunks is limited. Daemons from before chunked exports
+// don't answer, so if nothing comes back, the export is asked for in
+// one go instead.
+func (r *natsPlatform) ExportTo(w io.Writer) error {
+	inbox := nats.NewInbox()
+	sub, err := r.conn.Conn.SubscribeSync(inbox)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	if err := r.conn.PublishRequest(r.instance+methodExportTo, inbox, export{}); err != nil {
+		return err
+	}
+
+	wait := timeout
+	for seq := 0; ; seq++ {
+		msg, err := sub.NextMsg(wait)
+		if err == nats.ErrTimeout && seq == 0 {
+			config, err := r.Export()
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(config)
+			return err
+		}
+		if err != nil {
+			if err == nats.ErrTimeout {
+				err = platform.UnavailableError(err)
+			}
+			return err
+		}
+		wait = exportChunkTimeout
+
+		var chunk ExportChunk
+		if err := r.conn.Enc.Decode(msg.Subject, msg.Data, &chunk); err != nil {
+			return err
+		}
+		if chunk.Seq != seq {
+			return platform.UnavailableError(fmt.Errorf("chunk %d of export went missing", seq))
+		}
+		if len(chunk.Data) > 0 {
+			if _, err := w.Write(chunk.Data); err != nil {
+				return err
+			}
+		}
+		if chunk.Last {
+			return extractError(chunk.ErrorResponse)
+		}
+	}
+}
+
 func (r *natsPlatform) Sync(spec platform.SyncDef) error {
 	var response SyncResponse
 	// I use the applyTimeout here to be conservative; just applying
@@ -278,8 +366,63 @@ func (r *natsPlatform) Sync(spec platform.SyncDef) error {
 	return extractError(response.ErrorResponse)
 }
 
+func (r *natsPlatform) Trace(d time.Duration) error {
+	var response TraceResponse
+	if err := r.conn.Request(r.instance+methodTrace, d, &response, timeout); err != nil {
+		if err == nats.ErrTimeout {
+			err = platform.UnavailableError(err)
+		}
+		return err
+	}
+	return extractError(response.ErrorResponse)
+}
+
+func (r *natsPlatform) RegistryCredentials() (map[string]flux.Auth, error) {
+	var response RegistryCredentialsResponse
+	if err := r.conn.Request(r.instance+methodRegistryCredentials, registryCredentials{}, &response, timeout); err != nil {
+		if err == nats.ErrTimeout {
+			err = platform.UnavailableError(err)
+		}
+		return nil, err
+	}
+	return response.Auths, extractError(response.ErrorResponse)
+}
+
 // --- end Platform implementation
 
+// chunkWriter sends what's written to it in chunks, for ExportTo.
+type chunkWriter struct {
+	publish func(ExportChunk) error
+	seq     int
+	buf     bytes.Buffer
+}
+
+// begin sends the first, empty, chunk.
+func (c *chunkWriter) begin() error {
+	return c.send(ExportChunk{})
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf.Write(p)
+	for c.buf.Len() >= exportChunkSize {
+		if err := c.send(ExportChunk{Data: c.buf.Next(exportChunkSize)}); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// close sends what's left, along with the error, if there was one.
+func (c *chunkWriter) close(err error) error {
+	return c.send(ExportChunk{Data: c.buf.Bytes(), Last: true, ErrorResponse: makeErrorResponse(err)})
+}
+
+func (c *chunkWriter) send(chunk ExportChunk) error {
+	chunk.Seq = c.seq
+	c.seq++
+	return c.publish(chunk)
+}
+
 // Connect returns a platform.Platform implementation that can be used
 // to talk to a particular instance.
 func (n *NATS) Connect(instID flux.InstanceID) (platform.Platform, error) {
@@ -385,6 +528,21 @@ func (n *NATS) Subscribe(instID flux.InstanceID, remote platform.Platform, done
 				bytes, err = remote.Export()
 			}
 			n.enc.Publish(request.Reply, ExportResponse{bytes, makeErrorResponse(err)})
+		case strings.HasSuffix(request.Subject, methodExportTo):
+			var req export
+			chunks := &chunkWriter{publish: func(chunk ExportChunk) error {
+				return n.enc.Publish(request.Reply, chunk)
+			}}
+			err = encoder.Decode(request.Subject, request.Data, &req)
+			if err == nil {
+				err = chunks.begin()
+			}
+			if err == nil {
+				err = remote.ExportTo(chunks)
+			}
+			if closeErr := chunks.close(err); err == nil {
+				err = closeErr
+			}
 		case strings.HasSuffix(request.Subject, methodSync):
 			var def platform.SyncDef
 			err = encoder.Decode(request.Subject, request.Data, &def)
@@ -403,6 +561,23 @@ func (n *NATS) Subscribe(instID flux.InstanceID, remote platform.Platform, done
 				response.ErrorResponse = makeErrorResponse(err)
 			}
 			n.enc.Publish(request.Reply, response)
+		case strings.HasSuffix(request.Subject, methodTrace):
+			var d time.Duration
+			err = encoder.Decode(request.Subject, request.Data, &d)
+			if err == nil {
+				err = remote.Trace(d)
+			}
+			n.enc.Publish(request.Reply, TraceResponse{makeErrorResponse(err)})
+		case strings.HasSuffix(request.Subject, methodRegistryCredentials):
+			var (
+				req   registryCredentials
+				auths map[string]flux.Auth
+			)
+			err = encoder.Decode(request.Subject, request.Data, &req)
+			if err == nil {
+				auths, err = remote.RegistryCredentials()
+			}
+			n.enc.Publish(request.Reply, RegistryCredentialsResponse{auths, makeErrorResponse(err)})
 		default:
 			err = errors.New("unknown message: " + request.Subject)
 		}
@@ -150,3 +150,54 @@ func TestNewConnectionKicks(t *testing.T) {
 		t.Errorf("expected no error from second connection, but got %q", err)
 	}
 }
+
+func TestChunkWriter(t *testing.T) {
+	var chunks []ExportChunk
+	w := &chunkWriter{publish: func(chunk ExportChunk) error {
+		// The data is only good until publish returns
+		chunk.Data = append([]byte(nil), chunk.Data...)
+		chunks = append(chunks, chunk)
+		return nil
+	}}
+	if err := w.begin(); err != nil {
+		t.Fatal(err)
+	}
+	config := make([]byte, exportChunkSize*2+10)
+	for i := range config {
+		config[i] = byte(i)
+	}
+	// Written in odd sizes, as it might be
+	for written := 0; written < len(config); written += 1000 {
+		end := written + 1000
+		if end > len(config) {
+			end = len(config)
+		}
+		w.Write(config[written:end])
+	}
+	if err := w.close(errors.New("went wrong")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 4 {
+		t.Fatalf("expected an empty chunk, two full chunks and the rest, got %d chunks", len(chunks))
+	}
+	var got []byte
+	for i, chunk := range chunks {
+		if chunk.Seq != i {
+			t.Errorf("expected chunk %d to have Seq %d, got %d", i, i, chunk.Seq)
+		}
+		if chunk.Last != (i == len(chunks)-1) {
+			t.Errorf("expected only the last chunk to be last, but chunk %d has Last %v", i, chunk.Last)
+		}
+		got = append(got, chunk.Data...)
+	}
+	if len(chunks[0].Data) != 0 {
+		t.Errorf("expected first chunk to be empty, got %d bytes", len(chunks[0].Data))
+	}
+	if string(got) != string(config) {
+		t.Errorf("expected chunks to make up what was written")
+	}
+	if chunks[3].Error != "went wrong" {
+		t.Errorf("expected error in last chunk, got %q", chunks[3].Error)
+	}
+}
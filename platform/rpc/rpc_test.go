@@ -1,12 +1,16 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net/rpc/jsonrpc"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/tracing"
 )
 
 func pipes() (io.ReadWriteCloser, io.ReadWriteCloser) {
@@ -35,6 +39,68 @@ func TestRPC(t *testing.T) {
 	platform.PlatformTestBattery(t, wrap)
 }
 
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Report(s *tracing.Span) {
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+}
+
+func TestTracedRPC(t *testing.T) {
+	spans := &spanRecorder{}
+	tracing.SetReporter(spans)
+	defer tracing.SetReporter(nil)
+
+	clientConn, serverConn := pipes()
+	server, err := NewServer(&platform.MockPlatform{VersionAnswer: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeConn(serverConn)
+	client := NewClientV5(clientConn)
+
+	if _, err := client.Version(); err != nil {
+		t.Fatal(err)
+	}
+	if len(spans.spans) != 0 {
+		t.Fatalf("expected no span for a call outside a trace, got %d", len(spans.spans))
+	}
+
+	span, ctx := tracing.StartSpan(context.Background(), "request")
+	if _, err := platform.WithTrace(ctx, client).Version(); err != nil {
+		t.Fatal(err)
+	}
+	spans.mu.Lock()
+	defer spans.mu.Unlock()
+	if len(spans.spans) != 1 {
+		t.Fatalf("expected a span for the daemon's side of the call, got %d", len(spans.spans))
+	}
+	if got := spans.spans[0]; got.Operation != "rpc.RPCServer.Version" || got.TraceID != span.TraceID || got.ParentID != span.SpanID {
+		t.Errorf("expected the call to continue the trace, got %+v", got)
+	}
+}
+
+// Daemons from before traces were passed on use the plain JSON-RPC
+// codec, which must put up with the trace.
+func TestTracedRPCToOldDaemon(t *testing.T) {
+	clientConn, serverConn := pipes()
+	server, err := NewServer(&platform.MockPlatform{VersionAnswer: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.server.ServeCodec(jsonrpc.NewServerCodec(serverConn))
+	client := NewClientV5(clientConn)
+
+	_, ctx := tracing.StartSpan(context.Background(), "request")
+	if v, err := platform.WithTrace(ctx, client).Version(); err != nil || v != "1.0" {
+		t.Errorf("expected the daemon's answer, got %q, %v", v, err)
+	}
+}
+
 // ---
 
 type poorReader struct{}
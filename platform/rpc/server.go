@@ -3,7 +3,7 @@ package rpc
 import (
 	"io"
 	"net/rpc"
-	"net/rpc/jsonrpc"
+	"time"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/platform"
@@ -33,7 +33,7 @@ func NewServer(p platform.Platform) (*Server, error) {
 }
 
 func (c *Server) ServeConn(conn io.ReadWriteCloser) {
-	c.server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	c.server.ServeCodec(newServerCodec(conn))
 }
 
 type RPCServer struct {
@@ -110,3 +110,13 @@ func (p *RPCServer) Sync(spec platform.SyncDef, syncResult *SyncResult) error {
 	*syncResult = result
 	return err
 }
+
+func (p *RPCServer) Trace(d time.Duration, _ *struct{}) error {
+	return p.p.Trace(d)
+}
+
+func (p *RPCServer) RegistryCredentials(_ struct{}, resp *map[string]flux.Auth) error {
+	v, err := p.p.RegistryCredentials()
+	*resp = v
+	return err
+}
@@ -2,7 +2,9 @@ package platform
 
 import (
 	"errors"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/weaveworks/flux"
 )
@@ -185,6 +187,15 @@ func (p *removeablePlatform) Export() (config []byte, err error) {
 	return p.remote.Export()
 }
 
+func (p *removeablePlatform) ExportTo(w io.Writer) (err error) {
+	defer func() {
+		if _, ok := err.(FatalError); ok {
+			p.closeWithError(err)
+		}
+	}()
+	return p.remote.ExportTo(w)
+}
+
 func (p *removeablePlatform) Sync(spec SyncDef) (err error) {
 	defer func() {
 		if _, ok := err.(FatalError); ok {
@@ -194,6 +205,24 @@ func (p *removeablePlatform) Sync(spec SyncDef) (err error) {
 	return p.remote.Sync(spec)
 }
 
+func (p *removeablePlatform) RegistryCredentials() (auths map[string]flux.Auth, err error) {
+	defer func() {
+		if _, ok := err.(FatalError); ok {
+			p.closeWithError(err)
+		}
+	}()
+	return p.remote.RegistryCredentials()
+}
+
+func (p *removeablePlatform) Trace(d time.Duration) (err error) {
+	defer func() {
+		if _, ok := err.(FatalError); ok {
+			p.closeWithError(err)
+		}
+	}()
+	return p.remote.Trace(d)
+}
+
 // disconnectedPlatform is a stub implementation used when the
 // platform is known to be missing.
 
@@ -223,6 +252,18 @@ func (p disconnectedPlatform) Export() ([]byte, error) {
 	return nil, errNotSubscribed
 }
 
+func (p disconnectedPlatform) ExportTo(io.Writer) error {
+	return errNotSubscribed
+}
+
 func (p disconnectedPlatform) Sync(_ SyncDef) error {
 	return errNotSubscribed
 }
+
+func (p disconnectedPlatform) Trace(_ time.Duration) error {
+	return errNotSubscribed
+}
+
+func (p disconnectedPlatform) RegistryCredentials() (map[string]flux.Auth, error) {
+	return nil, errNotSubscribed
+}
@@ -0,0 +1,173 @@
+package platform
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+)
+
+const (
+	// MaxTraceDuration is the longest trace mode can be turned on for
+	// at a time, so a daemon left tracing goes back to normal by
+	// itself.
+	MaxTraceDuration = time.Hour
+	// traceLinesPerSecond limits how much is logged in trace mode, so
+	// a busy daemon doesn't flood its logs. Lines over the limit are
+	// dropped, and counted.
+	traceLinesPerSecond = 20
+)
+
+// tracedPlatform wraps a platform so that it can be put in trace
+// mode, during which each call to it is logged along with how long it
+// took. Trace mode is turned on and off by calling Trace on the
+// wrapper; the wrapped platform's Trace is never called.
+type tracedPlatform struct {
+	p      Platform
+	logger log.Logger
+	now    func() time.Time
+
+	sync.Mutex
+	until   time.Time
+	second  time.Time
+	lines   int
+	dropped int
+}
+
+// Traced gives a platform that can be put in trace mode, logging to
+// the logger given.
+func Traced(p Platform, logger log.Logger) Platform {
+	return &tracedPlatform{p: p, logger: logger, now: time.Now}
+}
+
+// Trace turns trace mode on for the duration given, up to
+// MaxTraceDuration, or off if the duration is zero. Turning it on
+// while it's already on starts the duration again from now.
+func (t *tracedPlatform) Trace(d time.Duration) error {
+	if d > MaxTraceDuration {
+		d = MaxTraceDuration
+	}
+	t.Lock()
+	defer t.Unlock()
+	if d <= 0 {
+		t.until = time.Time{}
+		t.logger.Log("trace", "off")
+		return nil
+	}
+	t.until = t.now().Add(d)
+	t.logger.Log("trace", "on", "until", t.until.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// trace logs a call, if trace mode is on and the rate limit allows.
+func (t *tracedPlatform) trace(method string, begin time.Time, err error, keyvals ...interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	if t.until.IsZero() {
+		return
+	}
+	now := t.now()
+	if now.After(t.until) {
+		t.until = time.Time{}
+		t.logger.Log("trace", "off", "reason", "expired")
+		return
+	}
+
+	if second := now.Truncate(time.Second); !second.Equal(t.second) {
+		if t.dropped > 0 {
+			t.logger.Log("trace", "dropped", "lines", t.dropped)
+		}
+		t.second, t.lines, t.dropped = second, 0, 0
+	}
+	if t.lines >= traceLinesPerSecond {
+		t.dropped++
+		return
+	}
+	t.lines++
+
+	keyvals = append([]interface{}{"trace", method}, keyvals...)
+	keyvals = append(keyvals, "took", now.Sub(begin).String())
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	t.logger.Log(keyvals...)
+}
+
+func (t *tracedPlatform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) (ss []Service, err error) {
+	defer func(begin time.Time) {
+		t.trace("AllServices", begin, err, "namespace", maybeNamespace, "services", len(ss))
+	}(t.now())
+	return t.p.AllServices(maybeNamespace, ignored)
+}
+
+func (t *tracedPlatform) SomeServices(ids []flux.ServiceID) (ss []Service, err error) {
+	defer func(begin time.Time) {
+		t.trace("SomeServices", begin, err, "requested", len(ids), "services", len(ss))
+	}(t.now())
+	return t.p.SomeServices(ids)
+}
+
+func (t *tracedPlatform) Apply(defs []ServiceDefinition) (err error) {
+	defer func(begin time.Time) {
+		t.trace("Apply", begin, err, "definitions", len(defs))
+	}(t.now())
+	return t.p.Apply(defs)
+}
+
+func (t *tracedPlatform) Ping() (err error) {
+	defer func(begin time.Time) {
+		t.trace("Ping", begin, err)
+	}(t.now())
+	return t.p.Ping()
+}
+
+func (t *tracedPlatform) Version() (v string, err error) {
+	defer func(begin time.Time) {
+		t.trace("Version", begin, err, "version", v)
+	}(t.now())
+	return t.p.Version()
+}
+
+func (t *tracedPlatform) Export() (config []byte, err error) {
+	defer func(begin time.Time) {
+		t.trace("Export", begin, err, "bytes", len(config))
+	}(t.now())
+	return t.p.Export()
+}
+
+func (t *tracedPlatform) ExportTo(w io.Writer) (err error) {
+	counter := &countingWriter{w: w}
+	defer func(begin time.Time) {
+		t.trace("ExportTo", begin, err, "bytes", counter.n)
+	}(t.now())
+	return t.p.ExportTo(counter)
+}
+
+func (t *tracedPlatform) RegistryCredentials() (auths map[string]flux.Auth, err error) {
+	defer func(begin time.Time) {
+		t.trace("RegistryCredentials", begin, err, "registries", len(auths))
+	}(t.now())
+	return t.p.RegistryCredentials()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+func (t *tracedPlatform) Sync(def SyncDef) (err error) {
+	defer func(begin time.Time) {
+		t.trace("Sync", begin, err, "actions", len(def.Actions))
+	}(t.now())
+	return t.p.Sync(def)
+}
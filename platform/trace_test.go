@@ -0,0 +1,78 @@
+package platform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestTracedPlatform(t *testing.T) {
+	var lines [][]interface{}
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		lines = append(lines, keyvals)
+		return nil
+	})
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := Traced(&MockPlatform{}, logger).(*tracedPlatform)
+	p.now = func() time.Time { return now }
+
+	traced := func() (n int) {
+		for _, l := range lines {
+			if l[1] == "Ping" {
+				n++
+			}
+		}
+		return n
+	}
+
+	p.Ping()
+	if len(lines) != 0 {
+		t.Fatalf("expected nothing logged outside trace mode, got %v", lines)
+	}
+
+	if err := p.Trace(2 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !p.until.Equal(now.Add(MaxTraceDuration)) {
+		t.Errorf("expected trace mode to be limited to %s, got until %s", MaxTraceDuration, p.until)
+	}
+
+	p.Ping()
+	if traced() != 1 {
+		t.Fatalf("expected a call to be logged in trace mode, got %v", lines)
+	}
+
+	for i := 0; i < 2*traceLinesPerSecond; i++ {
+		p.Ping()
+	}
+	if n := traced(); n != traceLinesPerSecond {
+		t.Errorf("expected %d lines logged in a second, got %d", traceLinesPerSecond, n)
+	}
+	now = now.Add(time.Second)
+	p.Ping()
+	var dropped interface{}
+	for _, l := range lines {
+		if l[1] == "dropped" {
+			dropped = l[3]
+		}
+	}
+	if dropped != traceLinesPerSecond+1 {
+		t.Errorf("expected %d dropped lines to be reported, got %v", traceLinesPerSecond+1, dropped)
+	}
+
+	now = now.Add(MaxTraceDuration)
+	before := traced()
+	p.Ping()
+	p.Ping()
+	if traced() != before {
+		t.Error("expected trace mode to expire")
+	}
+
+	p.Trace(time.Minute)
+	p.Trace(0)
+	p.Ping()
+	if traced() != before {
+		t.Error("expected trace mode to be turned off")
+	}
+}
@@ -1,7 +1,6 @@
 package registry
 
 import (
-	"encoding/json"
 	"strings"
 	"time"
 
@@ -9,6 +8,8 @@ import (
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
 )
 
 type Cache struct {
@@ -33,6 +34,55 @@ func NewCache(creds Credentials, cache MemcacheClient, expiry time.Duration, log
 	}
 }
 
+// Pass through. Tags can be moved, so the digest for a tag can't be
+// cached.
+func (c *Cache) ManifestDigest(repository, reference string) (string, error) {
+	return c.next.ManifestDigest(repository, reference)
+}
+
+// Pass through. Like the digest, the manifest list for a tag changes
+// when the tag is moved.
+func (c *Cache) ManifestList(repository, reference string) ([]flux.ImagePlatform, error) {
+	return c.next.ManifestList(repository, reference)
+}
+
+// ImageCreated is looked up by digest, so it never changes, and the
+// cache items don't expire.
+func (c *Cache) ImageCreated(repository, digest string) (time.Time, error) {
+	key := strings.Join([]string{
+		"registrycreatedbydigestv1",
+		repository,
+		digest,
+	}, "|")
+	cacheItem, err := c.Client.Get(key)
+	if err == nil {
+		var created time.Time
+		if err := created.UnmarshalText(cacheItem.Value); err == nil {
+			return created, nil
+		}
+		c.logger.Log("err", errors.Wrap(err, "decoding created time from memcache"))
+	} else if err != memcache.ErrCacheMiss {
+		c.logger.Log("err", errors.Wrap(err, "fetching created time from memcache"))
+	}
+
+	created, err := c.next.ImageCreated(repository, digest)
+	if err != nil {
+		return created, err
+	}
+	val, err := created.MarshalText()
+	if err != nil {
+		c.logger.Log("err", errors.Wrap(err, "serializing created time to store in memcache"))
+		return created, nil
+	}
+	if err := c.Client.Set(&memcache.Item{
+		Key:   key,
+		Value: val,
+	}); err != nil {
+		c.logger.Log("err", errors.Wrap(err, "storing created time in memcache"))
+	}
+	return created, nil
+}
+
 func (c *Cache) Manifest(repository, reference string) ([]schema1.History, error) {
 	// Don't cache latest. There are probably some other frequently changing tags
 	// we shouldn't cache here as well.
@@ -47,7 +97,7 @@ func (c *Cache) Manifest(repository, reference string) ([]schema1.History, error
 
 	// Try the cache
 	key := strings.Join([]string{
-		"registryhistoryv1", // Just to version in case we need to change format later.
+		"registryhistoryv2", // The format version; v1 was JSON.
 		// Just the username here means we won't invalidate the cache when user
 		// changes password, but that should be rare. And, it also means we're not
 		// putting user passwords in plaintext into memcache.
@@ -58,12 +108,14 @@ func (c *Cache) Manifest(repository, reference string) ([]schema1.History, error
 	cacheItem, err := c.Client.Get(key)
 	if err == nil {
 		// Return the cache item
-		var history []schema1.History
-		if err := json.Unmarshal(cacheItem.Value, &history); err == nil {
-			return history, nil
-		} else {
-			c.logger.Log("err", err.Error)
+		entry, err := decodeManifestEntry(cacheItem.Value)
+		if err == nil {
+			var history []schema1.History
+			if history, err = entry.History(); err == nil {
+				return history, nil
+			}
 		}
+		c.logger.Log("err", errors.Wrap(err, "decoding tag from memcache"))
 	} else if err != memcache.ErrCacheMiss {
 		c.logger.Log("err", errors.Wrap(err, "Fetching tag from memcache"))
 	}
@@ -72,7 +124,7 @@ func (c *Cache) Manifest(repository, reference string) ([]schema1.History, error
 	history, err := c.next.Manifest(repository, reference)
 	if err == nil {
 		// Store positive responses in the cache
-		val, err := json.Marshal(history)
+		val, err := encodeManifestEntry(history)
 		if err != nil {
 			c.logger.Log("err", errors.Wrap(err, "serializing tag to store in memcache"))
 			return history, nil
@@ -89,8 +141,3 @@ func (c *Cache) Manifest(repository, reference string) ([]schema1.History, error
 
 	return history, err
 }
-
-// Pass through. Not caching tags.
-func (c *Cache) Tags(repository string) ([]string, error) {
-	return c.next.Tags(repository)
-}
@@ -74,7 +74,7 @@ func TestCache(t *testing.T) {
 
 	// It should cache on the way through
 	_, err = mc.Get(strings.Join([]string{
-		"registryhistoryv1",
+		"registryhistoryv2",
 		"", // no username
 		"weaveworks/foorepo",
 		"tag1",
@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema1"
+)
+
+// Manifest history is kept in memcache in a compact binary form,
+// rather than as JSON, since there's an entry for every tag of every
+// image, and some repos have tens of thousands of tags. An entry is
+//
+//	version  byte (manifestEntryVersion)
+//	created  varint, Unix nanoseconds of the topmost layer's created
+//	         time (zero if it has none)
+//	count    uvarint, the number of history items
+//	topmost  uvarint length, then the topmost item's v1Compatibility
+//	rest     the remaining items' v1Compatibility, each as a uvarint
+//	         length then the bytes, all deflated
+//
+// The created time and topmost item are what's looked at; the rest
+// are inflated only if asked for.
+const manifestEntryVersion = 1
+
+var errShortManifestEntry = errors.New("manifest cache entry is truncated")
+
+// manifestEntry is a decoded cache entry. The items under the topmost
+// are decoded when History is first called.
+type manifestEntry struct {
+	created time.Time
+	count   int
+	topmost string
+	rest    []byte
+	history []schema1.History
+}
+
+func encodeManifestEntry(history []schema1.History) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(manifestEntryVersion)
+
+	var created int64
+	if len(history) > 0 {
+		var topmost struct {
+			Created time.Time `json:"created"`
+		}
+		if err := json.Unmarshal([]byte(history[0].V1Compatibility), &topmost); err == nil && !topmost.Created.IsZero() {
+			created = topmost.Created.UnixNano()
+		}
+	}
+	writeVarint(&buf, created)
+	writeUvarint(&buf, uint64(len(history)))
+	if len(history) == 0 {
+		return buf.Bytes(), nil
+	}
+	writeString(&buf, history[0].V1Compatibility)
+
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	var rest bytes.Buffer
+	for _, h := range history[1:] {
+		writeString(&rest, h.V1Compatibility)
+	}
+	if _, err := w.Write(rest.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeManifestEntry decodes the fields of an entry that are always
+// wanted, and keeps the rest (which shares the memory of data) for
+// later.
+func decodeManifestEntry(data []byte) (*manifestEntry, error) {
+	if len(data) == 0 {
+		return nil, errShortManifestEntry
+	}
+	if data[0] != manifestEntryVersion {
+		return nil, fmt.Errorf("manifest cache entry has version %d; expected %d", data[0], manifestEntryVersion)
+	}
+	r := bytes.NewReader(data[1:])
+	created, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, errShortManifestEntry
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errShortManifestEntry
+	}
+	e := &manifestEntry{count: int(count)}
+	if created != 0 {
+		e.created = time.Unix(0, created).UTC()
+	}
+	if count == 0 {
+		return e, nil
+	}
+	if e.topmost, err = readString(r); err != nil {
+		return nil, err
+	}
+	e.rest = data[len(data)-r.Len():]
+	return e, nil
+}
+
+// Created gives the created time of the topmost layer, or the zero
+// time if it doesn't have one.
+func (e *manifestEntry) Created() time.Time {
+	return e.created
+}
+
+// History gives all the history items, topmost first, as they were
+// encoded.
+func (e *manifestEntry) History() ([]schema1.History, error) {
+	if e.history != nil || e.count == 0 {
+		return e.history, nil
+	}
+	rest, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(e.rest)))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]schema1.History, 0, e.count)
+	history = append(history, schema1.History{V1Compatibility: e.topmost})
+	r := bytes.NewReader(rest)
+	for len(history) < e.count {
+		s, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, schema1.History{V1Compatibility: s})
+	}
+	e.history = history
+	return history, nil
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", errShortManifestEntry
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), nil
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/manifest/schema1"
+)
+
+func TestManifestEntryRoundTrip(t *testing.T) {
+	created := time.Date(2017, 3, 1, 12, 30, 0, 0, time.UTC)
+	history := []schema1.History{
+		{V1Compatibility: `{"id":"abc","created":"2017-03-01T12:30:00Z","config":{"Env":["PATH=/bin"]}}`},
+		{V1Compatibility: `{"id":"def","created":"2017-02-01T00:00:00Z"}`},
+		{V1Compatibility: `{"id":"ghi"}`},
+	}
+	data, err := encodeManifestEntry(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asJSON, _ := json.Marshal(history)
+	if len(data) >= len(asJSON) {
+		t.Errorf("expected the entry (%d bytes) to be smaller than JSON (%d bytes)", len(data), len(asJSON))
+	}
+
+	entry, err := decodeManifestEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Created().Equal(created) {
+		t.Errorf("expected created time %s, got %s", created, entry.Created())
+	}
+	if entry.history != nil {
+		t.Error("expected the history not to be decoded until asked for")
+	}
+	got, err := entry.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history, got) {
+		t.Errorf("expected %v, got %v", history, got)
+	}
+}
+
+func TestManifestEntryEmpty(t *testing.T) {
+	data, err := encodeManifestEntry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := decodeManifestEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Created().IsZero() {
+		t.Errorf("expected no created time, got %s", entry.Created())
+	}
+	if history, err := entry.History(); err != nil || len(history) != 0 {
+		t.Errorf("expected no history, got %v (%v)", history, err)
+	}
+}
+
+func TestManifestEntryInvalid(t *testing.T) {
+	data, err := encodeManifestEntry([]schema1.History{{V1Compatibility: `{"id":"abc"}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string][]byte{
+		"empty":     {},
+		"JSON":      []byte(`[{"v1Compatibility":"{}"}]`),
+		"truncated": data[:len(data)-8],
+	} {
+		if _, err := decodeManifestEntry(bad); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"net/http"
+
+	"github.com/weaveworks/flux"
+)
+
+type headerRoundTripper struct {
+	roundTripper http.RoundTripper
+	userAgent    string
+	headers      map[string]string
+}
+
+// HeaderRoundTripper is a http.RoundTripper which sets the user agent
+// and adds the headers configured, e.g., for a proxy which wants
+// them. If there are none, it returns the RoundTripper given.
+func HeaderRoundTripper(r http.RoundTripper, c flux.HTTPConfig) http.RoundTripper {
+	if c.UserAgent == "" && len(c.Headers) == 0 {
+		return r
+	}
+	return &headerRoundTripper{
+		roundTripper: r,
+		userAgent:    c.UserAgent,
+		headers:      c.Headers,
+	}
+}
+
+func (c *headerRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	// A RoundTripper mustn't change the request it's given, so set
+	// the headers on a copy
+	req := *r
+	req.Header = http.Header{}
+	for k, vs := range r.Header {
+		req.Header[k] = vs
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return c.roundTripper.RoundTrip(&req)
+}
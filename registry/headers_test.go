@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestHeaderRoundTripper(t *testing.T) {
+	var got *http.Request
+	rt := HeaderRoundTripper(roundtripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}), flux.HTTPConfig{
+		UserAgent: "flux-test",
+		Headers:   map[string]string{"X-Proxy-Team": "deploy"},
+	})
+
+	req, err := http.NewRequest("GET", "https://registry.example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"User-Agent":    "flux-test",
+		"X-Proxy-Team":  "deploy",
+		"Authorization": "Bearer token",
+	} {
+		if got.Header.Get(k) != v {
+			t.Errorf("expected %s: %q, got %q", k, v, got.Header.Get(k))
+		}
+	}
+	// The request given shouldn't be changed
+	if req.Header.Get("X-Proxy-Team") != "" {
+		t.Error("expected the original request to be left alone")
+	}
+}
@@ -1,8 +1,18 @@
 package registry
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
 	dockerregistry "github.com/heroku/docker-registry-client/registry"
+
+	"github.com/weaveworks/flux"
 )
 
 type herokuWrapper struct {
@@ -24,3 +34,76 @@ func (h herokuWrapper) Manifest(repository, reference string) ([]schema1.History
 	}
 	return result, err
 }
+
+func (h herokuWrapper) ManifestDigest(repository, reference string) (string, error) {
+	digest, err := h.Registry.ManifestDigest(repository, reference)
+	return string(digest), err
+}
+
+// ManifestList gives the platforms of a multi-arch image. The
+// dockerregistry library doesn't know about manifest lists, so we ask
+// for one ourselves; if the registry gives us something else, the
+// image isn't multi-arch, and there are no platforms.
+func (h herokuWrapper) ManifestList(repository, reference string) ([]flux.ImagePlatform, error) {
+	var list manifestlist.ManifestList
+	ok, err := h.getJSON(fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), manifestlist.MediaTypeManifestList, &list)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var platforms []flux.ImagePlatform
+	for _, m := range list.Manifests {
+		platforms = append(platforms, flux.ImagePlatform{
+			OS:           m.Platform.OS,
+			Architecture: m.Platform.Architecture,
+			Variant:      m.Platform.Variant,
+			Digest:       string(m.Digest),
+		})
+	}
+	return platforms, nil
+}
+
+// ImageCreated gives the creation time of the image with the
+// (schema2) manifest digest given, from its config.
+func (h herokuWrapper) ImageCreated(repository, digest string) (time.Time, error) {
+	var manifest schema2.Manifest
+	ok, err := h.getJSON(fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), schema2.MediaTypeManifest, &manifest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("manifest %s of %s is not a schema2 manifest", digest, repository)
+	}
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	if _, err := h.getJSON(fmt.Sprintf("/v2/%s/blobs/%s", repository, manifest.Config.Digest), "", &config); err != nil {
+		return time.Time{}, err
+	}
+	return config.Created, nil
+}
+
+// getJSON decodes the response to a GET of the registry path given.
+// If a media type is given, it's asked for, and the result is false
+// (and nothing is decoded) if the response has a different one.
+func (h herokuWrapper) getJSON(path, mediaType string, into interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(h.URL, "/")+path, nil)
+	if err != nil {
+		return false, err
+	}
+	if mediaType != "" {
+		req.Header.Set("Accept", mediaType)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	contentType := strings.TrimSpace(strings.SplitN(resp.Header.Get("Content-Type"), ";", 2)[0])
+	if mediaType != "" && contentType != mediaType {
+		return false, nil
+	}
+	return true, json.NewDecoder(resp.Body).Decode(into)
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+// CreatedIndex records when each tag in a repository was created, so
+// that the images in a repository can be sorted without fetching the
+// manifest for every tag each time. A tag that is missing from the
+// index has not been seen yet; a tag with a zero time has been seen,
+// but has no known creation time.
+type CreatedIndex interface {
+	Get(repository Repository) (map[string]time.Time, error)
+	Set(repository Repository, created map[string]time.Time) error
+}
+
+type memcacheCreatedIndex struct {
+	client MemcacheClient
+	arch   string
+}
+
+// NewMemcacheCreatedIndex returns a CreatedIndex that keeps the index
+// for each repository as a single memcache item. Items don't expire,
+// since the creation time of a tag doesn't change; they may still be
+// evicted, in which case the index is rebuilt from the registry.
+// Multi-arch images have a creation time per architecture, so there's
+// an index for each architecture.
+func NewMemcacheCreatedIndex(client MemcacheClient, arch string) CreatedIndex {
+	return &memcacheCreatedIndex{client: client, arch: arch}
+}
+
+func (i *memcacheCreatedIndex) key(repository Repository) string {
+	parts := []string{
+		"registrycreatedv1", // Just to version in case we need to change format later.
+		repository.String(),
+	}
+	// The default architecture keeps the key it had before there was
+	// more than one.
+	if i.arch != "" && i.arch != flux.DefaultArchitecture {
+		parts = append(parts, i.arch)
+	}
+	return strings.Join(parts, "|")
+}
+
+func (i *memcacheCreatedIndex) Get(repository Repository) (map[string]time.Time, error) {
+	created := map[string]time.Time{}
+	item, err := i.client.Get(i.key(repository))
+	if err == memcache.ErrCacheMiss {
+		return created, nil
+	} else if err != nil {
+		return created, errors.Wrap(err, "fetching created index from memcache")
+	}
+	if err := json.Unmarshal(item.Value, &created); err != nil {
+		return map[string]time.Time{}, errors.Wrap(err, "deserializing created index")
+	}
+	return created, nil
+}
+
+func (i *memcacheCreatedIndex) Set(repository Repository, created map[string]time.Time) error {
+	val, err := json.Marshal(created)
+	if err != nil {
+		return errors.Wrap(err, "serializing created index")
+	}
+	if err := i.client.Set(&memcache.Item{
+		Key:   i.key(repository),
+		Value: val,
+	}); err != nil {
+		return errors.Wrap(err, "storing created index in memcache")
+	}
+	return nil
+}
@@ -14,8 +14,16 @@ import (
 const (
 	LabelRequestKind = "kind"
 
-	RequestKindTags     = "tags"
-	RequestKindMetadata = "metadata"
+	RequestKindTags      = "tags"
+	RequestKindMetadata  = "metadata"
+	RequestKindDigest    = "digest"
+	RequestKindPlatforms = "platforms"
+
+	LabelTagListResult = "result"
+
+	TagListNotModified = "not_modified" // the registry said so
+	TagListUnchanged   = "unchanged"    // the registry sent the same tags
+	TagListChanged     = "changed"
 )
 
 var (
@@ -32,6 +40,12 @@ var (
 		Name:      "request_duration_seconds",
 		Help:      "Duration of HTTP requests made in the course of fetching Image metadata",
 	}, []string{LabelRequestKind, fluxmetrics.LabelSuccess})
+	tagListFetches = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "tag_list_fetches_total",
+		Help:      "Count of tag list fetches, by whether the tag list had changed.",
+	}, []string{LabelTagListResult})
 	memcacheRequestDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
 		Namespace: "flux",
 		Subsystem: "memcache",
@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"time"
+
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/pkg/errors"
 
@@ -16,6 +18,9 @@ type mockRemote struct {
 	img  flux.Image
 	tags []string
 	err  error
+	// Platforms and created times by digest, for multi-arch images
+	platforms []flux.ImagePlatform
+	created   map[string]time.Time
 }
 
 func NewMockRemote(img flux.Image, tags []string, err error) Remote {
@@ -37,6 +42,34 @@ func (r *mockRemote) Manifest(repository Repository, tag string) (flux.Image, er
 	return r.img, r.err
 }
 
+func (r *mockRemote) Digest(repository Repository, tag string) (string, error) {
+	return "", r.err
+}
+
+// NewMockMultiArchRemote gives a remote for which every tag is a
+// multi-arch image with the platforms given; each platform's creation
+// time is looked up by its digest.
+func NewMockMultiArchRemote(img flux.Image, tags []string, platforms []flux.ImagePlatform, created map[string]time.Time) Remote {
+	return &mockRemote{
+		img:       img,
+		tags:      tags,
+		platforms: platforms,
+		created:   created,
+	}
+}
+
+func (r *mockRemote) Platforms(repository Repository, tag string) ([]flux.ImagePlatform, error) {
+	return r.platforms, r.err
+}
+
+func (r *mockRemote) Created(repository Repository, digest string) (time.Time, error) {
+	created, ok := r.created[digest]
+	if !ok {
+		return time.Time{}, errors.New("Mock has no image with digest " + digest)
+	}
+	return created, r.err
+}
+
 func (r *mockRemote) Cancel() {
 }
 
@@ -56,6 +89,18 @@ func (m *mockDockerClient) Manifest(repository, reference string) ([]schema1.His
 	return m.manifest(repository, reference)
 }
 
+func (m *mockDockerClient) ManifestDigest(repository, reference string) (string, error) {
+	return "", nil
+}
+
+func (m *mockDockerClient) ManifestList(repository, reference string) ([]flux.ImagePlatform, error) {
+	return nil, nil
+}
+
+func (m *mockDockerClient) ImageCreated(repository, digest string) (time.Time, error) {
+	return time.Time{}, errors.New("Mock has no images by digest")
+}
+
 func (m *mockDockerClient) Tags(repository string) ([]string, error) {
 	return m.tags(repository)
 }
@@ -109,3 +154,17 @@ func (m *mockRegistry) GetImage(repository Repository, tag string) (flux.Image,
 	}
 	return flux.Image{}, errors.New("not found")
 }
+
+func (m *mockRegistry) GetImagePlatforms(repository Repository, tag string) ([]flux.ImagePlatform, error) {
+	if _, err := m.GetImage(repository, tag); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (m *mockRegistry) GetImageDigest(repository Repository, tag string) (string, error) {
+	if _, err := m.GetImage(repository, tag); err != nil {
+		return "", err
+	}
+	return "", nil
+}
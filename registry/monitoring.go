@@ -39,6 +39,24 @@ func (m *instrumentedRegistry) GetImage(repository Repository, tag string) (res
 	return
 }
 
+func (m *instrumentedRegistry) GetImageDigest(repository Repository, tag string) (res string, err error) {
+	start := time.Now()
+	res, err = m.next.GetImageDigest(repository, tag)
+	fetchDuration.With(
+		fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	return
+}
+
+func (m *instrumentedRegistry) GetImagePlatforms(repository Repository, tag string) (res []flux.ImagePlatform, err error) {
+	start := time.Now()
+	res, err = m.next.GetImagePlatforms(repository, tag)
+	fetchDuration.With(
+		fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	return
+}
+
 type InstrumentedRemote Remote
 
 type instrumentedRemote struct {
@@ -71,6 +89,36 @@ func (m *instrumentedRemote) Tags(repository Repository) (res []string, err erro
 	return
 }
 
+func (m *instrumentedRemote) Digest(repository Repository, tag string) (res string, err error) {
+	start := time.Now()
+	res, err = m.next.Digest(repository, tag)
+	requestDuration.With(
+		LabelRequestKind, RequestKindDigest,
+		fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	return
+}
+
+func (m *instrumentedRemote) Platforms(repository Repository, tag string) (res []flux.ImagePlatform, err error) {
+	start := time.Now()
+	res, err = m.next.Platforms(repository, tag)
+	requestDuration.With(
+		LabelRequestKind, RequestKindPlatforms,
+		fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	return
+}
+
+func (m *instrumentedRemote) Created(repository Repository, digest string) (res time.Time, err error) {
+	start := time.Now()
+	res, err = m.next.Created(repository, digest)
+	requestDuration.With(
+		LabelRequestKind, RequestKindMetadata,
+		fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	return
+}
+
 func (m *instrumentedRemote) Cancel() {
 	m.next.Cancel()
 }
@@ -19,17 +19,32 @@ const (
 type Registry interface {
 	GetRepository(repository Repository) ([]flux.Image, error)
 	GetImage(repository Repository, tag string) (flux.Image, error)
+	GetImageDigest(repository Repository, tag string) (string, error)
+	GetImagePlatforms(repository Repository, tag string) ([]flux.ImagePlatform, error)
 }
 
 type registry struct {
 	factory RemoteClientFactory
+	index   CreatedIndex
+	arch    string
 	Logger  log.Logger
 }
 
 // NewClient creates a new registry registry, to use when fetching repositories.
 func NewRegistry(c RemoteClientFactory, l log.Logger) Registry {
+	return NewIndexedRegistry(c, nil, flux.DefaultArchitecture, l)
+}
+
+// NewIndexedRegistry creates a registry which keeps the creation time
+// of each tag in the index given, and only fetches manifests for tags
+// it hasn't seen before. If the index is nil, every manifest is
+// fetched each time. The creation times of multi-arch images are
+// those of the images for the architecture given.
+func NewIndexedRegistry(c RemoteClientFactory, index CreatedIndex, arch string, l log.Logger) Registry {
 	return &registry{
 		factory: c,
+		index:   index,
+		arch:    arch,
 		Logger:  l,
 	}
 }
@@ -59,7 +74,10 @@ func (reg *registry) GetRepository(img Repository) (_ []flux.Image, err error) {
 	// `library/nats`. We need that to fetch the tags etc. However, we
 	// want the results to use the *actual* name of the images to be
 	// as supplied, e.g., `nats`.
-	return reg.tagsToRepository(rem, img, tags)
+	if reg.index == nil {
+		return reg.tagsToRepository(rem, img, tags)
+	}
+	return reg.indexedTagsToRepository(rem, img, tags)
 }
 
 // Get a single Image from the registry if it exists
@@ -68,7 +86,53 @@ func (reg *registry) GetImage(img Repository, tag string) (_ flux.Image, err err
 	if err != nil {
 		return
 	}
-	return rem.Manifest(img, tag)
+	return reg.manifest(rem, img, tag)
+}
+
+// Get the content digest of an image, e.g., "sha256:abc..."
+func (reg *registry) GetImageDigest(img Repository, tag string) (string, error) {
+	rem, err := reg.newRemote(img)
+	if err != nil {
+		return "", err
+	}
+	return rem.Digest(img, tag)
+}
+
+// Get the platforms of a multi-arch image; or none, if the image
+// isn't multi-arch.
+func (reg *registry) GetImagePlatforms(img Repository, tag string) ([]flux.ImagePlatform, error) {
+	rem, err := reg.newRemote(img)
+	if err != nil {
+		return nil, err
+	}
+	return rem.Platforms(img, tag)
+}
+
+// manifest gets the image for a tag. The manifest the registry gives
+// for a multi-arch image (when asked for a plain manifest) is that of
+// the amd64 image; so for other architectures, we look for the
+// platform's image in the manifest list, and use its creation time.
+func (reg *registry) manifest(rem Remote, img Repository, tag string) (flux.Image, error) {
+	if reg.arch == "" || reg.arch == flux.DefaultArchitecture {
+		return rem.Manifest(img, tag)
+	}
+	platforms, err := rem.Platforms(img, tag)
+	if err != nil {
+		return flux.Image{}, err
+	}
+	platform, ok := flux.PlatformFor(platforms, reg.arch)
+	if !ok {
+		return rem.Manifest(img, tag)
+	}
+	image := img.ToImage(tag)
+	created, err := rem.Created(img, platform.Digest)
+	if err != nil {
+		return image, err
+	}
+	if !created.IsZero() {
+		image.CreatedAt = &created
+	}
+	return image, nil
 }
 
 func (reg *registry) newRemote(img Repository) (rem Remote, err error) {
@@ -81,10 +145,80 @@ func (reg *registry) newRemote(img Repository) (rem Remote, err error) {
 }
 
 func (reg *registry) tagsToRepository(remote Remote, repository Repository, tags []string) ([]flux.Image, error) {
+	fetched, err := reg.fetchManifests(remote, repository, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]flux.Image, 0, len(fetched))
+	for _, image := range fetched {
+		images = append(images, image)
+	}
+
+	sort.Sort(byCreatedDesc(images))
+	return images, nil
+}
+
+// indexedTagsToRepository fetches manifests only for the tags not
+// already in the index (and for `latest`, which moves), and uses the
+// index for the rest.
+func (reg *registry) indexedTagsToRepository(remote Remote, repository Repository, tags []string) ([]flux.Image, error) {
+	created, err := reg.index.Get(repository)
+	if err != nil {
+		reg.Logger.Log("err", err)
+	}
+
+	var toFetch []string
+	for _, tag := range tags {
+		if _, ok := created[tag]; !ok || tag == "latest" {
+			toFetch = append(toFetch, tag)
+		}
+	}
+	fetched, err := reg.fetchManifests(remote, repository, toFetch)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := make(map[string]time.Time, len(tags))
+	images := make([]flux.Image, 0, len(tags))
+	for _, tag := range tags {
+		image, ok := fetched[tag]
+		if !ok {
+			createdAt := created[tag]
+			image = repository.ToImage(tag)
+			if !createdAt.IsZero() {
+				image.CreatedAt = &createdAt
+			}
+		}
+		if tag != "latest" {
+			var createdAt time.Time
+			if image.CreatedAt != nil {
+				createdAt = *image.CreatedAt
+			}
+			updated[tag] = createdAt
+		}
+		images = append(images, image)
+	}
+
+	// Only write back if we learnt something new, or tags have gone
+	if len(toFetch) > 0 || len(updated) != len(created) {
+		if err := reg.index.Set(repository, updated); err != nil {
+			reg.Logger.Log("err", err)
+		}
+	}
+
+	sort.Sort(byCreatedDesc(images))
+	return images, nil
+}
+
+// fetchManifests gets the image for each of the tags given, keyed by
+// tag.
+func (reg *registry) fetchManifests(remote Remote, repository Repository, tags []string) (map[string]flux.Image, error) {
 	// one way or another, we'll be finishing all requests
 	defer remote.Cancel()
 
 	type result struct {
+		tag   string
 		image flux.Image
 		err   error
 	}
@@ -95,11 +229,11 @@ func (reg *registry) tagsToRepository(remote Remote, repository Repository, tags
 	for i := 0; i < maxConcurrency; i++ {
 		go func() {
 			for tag := range toFetch {
-				image, err := remote.Manifest(repository, tag)
+				image, err := reg.manifest(remote, repository, tag)
 				if err != nil {
 					reg.Logger.Log("registry-metadata-err", err)
 				}
-				fetched <- result{image, err}
+				fetched <- result{tag, image, err}
 			}
 		}()
 	}
@@ -108,16 +242,14 @@ func (reg *registry) tagsToRepository(remote Remote, repository Repository, tags
 	}
 	close(toFetch)
 
-	images := make([]flux.Image, cap(fetched))
+	images := make(map[string]flux.Image, cap(fetched))
 	for i := 0; i < cap(fetched); i++ {
 		res := <-fetched
 		if res.err != nil {
 			return nil, res.err
 		}
-		images[i] = res.image
+		images[res.tag] = res.image
 	}
-
-	sort.Sort(byCreatedDesc(images))
 	return images, nil
 }
 
@@ -3,6 +3,7 @@ package registry
 import (
 	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -110,3 +111,104 @@ func TestRegistry_OrderByCreationDate(t *testing.T) {
 		}
 	}
 }
+
+type mapIndex map[string]map[string]time.Time
+
+func (m mapIndex) Get(repository Repository) (map[string]time.Time, error) {
+	created := map[string]time.Time{}
+	for tag, t := range m[repository.String()] {
+		created[tag] = t
+	}
+	return created, nil
+}
+
+func (m mapIndex) Set(repository Repository, created map[string]time.Time) error {
+	m[repository.String()] = created
+	return nil
+}
+
+type countingRemote struct {
+	tags []string
+	// Manifest is called from several goroutines at once
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (r *countingRemote) Tags(repository Repository) ([]string, error) {
+	return r.tags, nil
+}
+
+func (r *countingRemote) Manifest(repository Repository, tag string) (flux.Image, error) {
+	r.mu.Lock()
+	r.fetched = append(r.fetched, tag)
+	r.mu.Unlock()
+	createdAt := testTime.Add(time.Duration(len(tag)) * time.Second)
+	return flux.ParseImage(repository.ToImage(tag).String(), &createdAt)
+}
+
+func (r *countingRemote) Digest(repository Repository, tag string) (string, error) {
+	return "", nil
+}
+
+func (r *countingRemote) Platforms(repository Repository, tag string) ([]flux.ImagePlatform, error) {
+	return nil, nil
+}
+
+func (r *countingRemote) Created(repository Repository, digest string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (r *countingRemote) Cancel() {}
+
+func TestRegistry_GetRepositoryIndexed(t *testing.T) {
+	r := &countingRemote{tags: []string{"a", "bb"}}
+	reg := NewIndexedRegistry(NewMockRemoteFactory(r, nil), mapIndex{}, flux.DefaultArchitecture, log.NewNopLogger())
+
+	imgs, err := reg.GetRepository(testRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 || len(r.fetched) != 2 {
+		t.Fatalf("expected both tags fetched, got %v", r.fetched)
+	}
+
+	// A new tag is the only one fetched, and the order comes from the index
+	r.tags = append(r.tags, "ccc")
+	r.fetched = nil
+	imgs, err = reg.GetRepository(testRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.fetched) != 1 || r.fetched[0] != "ccc" {
+		t.Fatalf("expected only the new tag to be fetched, got %v", r.fetched)
+	}
+	for i, tag := range []string{"ccc", "bb", "a"} {
+		if imgs[i].Tag != tag || imgs[i].CreatedAt == nil {
+			t.Fatalf("expected %s at position %d with a created time, got %v", tag, i, imgs[i])
+		}
+	}
+}
+
+func TestRegistry_MultiArchCreated(t *testing.T) {
+	amdCreated, armCreated := testTime, testTime.Add(time.Hour)
+	amdImage, _ := flux.ParseImage(testImageStr, &amdCreated)
+	r := NewMockMultiArchRemote(amdImage, []string{testTags[0]}, []flux.ImagePlatform{
+		{OS: "linux", Architecture: "amd64", Digest: "sha256:aaa"},
+		{OS: "linux", Architecture: "arm64", Digest: "sha256:bbb"},
+	}, map[string]time.Time{"sha256:bbb": armCreated})
+
+	for arch, expected := range map[string]time.Time{
+		flux.DefaultArchitecture: amdCreated,
+		"arm64":                  armCreated,
+		"ppc64le":                amdCreated, // no image for the arch, so as for amd64
+	} {
+		reg := NewIndexedRegistry(NewMockRemoteFactory(r, nil), nil, arch, log.NewNopLogger())
+		image, err := reg.GetImage(testRepository, testTags[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.CreatedAt == nil || !image.CreatedAt.Equal(expected) {
+			t.Errorf("%s: expected created time %s, got %v", arch, expected, image.CreatedAt)
+		}
+	}
+}
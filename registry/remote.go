@@ -14,6 +14,13 @@ import (
 type Remote interface {
 	Tags(repository Repository) ([]string, error)
 	Manifest(repository Repository, tag string) (flux.Image, error)
+	Digest(repository Repository, tag string) (string, error)
+	// Platforms gives the images for each platform, if the tag
+	// refers to a multi-arch image; otherwise, none.
+	Platforms(repository Repository, tag string) ([]flux.ImagePlatform, error)
+	// Created gives the creation time of the image with the digest
+	// given, e.g., one of the platforms of a multi-arch image.
+	Created(repository Repository, digest string) (time.Time, error)
 	Cancel()
 }
 
@@ -63,6 +70,20 @@ func (rc *remote) Manifest(repository Repository, tag string) (img flux.Image, e
 	return
 }
 
+// Digest gives the content digest of the manifest for the tag, which
+// identifies the image exactly, even if the tag is later moved.
+func (rc *remote) Digest(repository Repository, tag string) (string, error) {
+	return rc.client.ManifestDigest(repository.NamespaceImage(), tag)
+}
+
+func (rc *remote) Platforms(repository Repository, tag string) ([]flux.ImagePlatform, error) {
+	return rc.client.ManifestList(repository.NamespaceImage(), tag)
+}
+
+func (rc *remote) Created(repository Repository, digest string) (time.Time, error) {
+	return rc.client.ImageCreated(repository.NamespaceImage(), digest)
+}
+
 func (rc *remote) Cancel() {
 	rc.cancel()
 }
@@ -71,4 +92,7 @@ func (rc *remote) Cancel() {
 type dockerRegistryInterface interface {
 	Tags(repository string) ([]string, error)
 	Manifest(repository, reference string) ([]schema1.History, error)
+	ManifestDigest(repository, reference string) (string, error)
+	ManifestList(repository, reference string) ([]flux.ImagePlatform, error)
+	ImageCreated(repository, digest string) (time.Time, error)
 }
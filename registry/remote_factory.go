@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"time"
+
+	"github.com/weaveworks/flux"
 )
 
 type creds struct {
@@ -27,9 +29,10 @@ type RemoteClientFactory interface {
 	CreateFor(host string) (Remote, error)
 }
 
-func NewRemoteClientFactory(c Credentials, l log.Logger, mc MemcacheClient, ce time.Duration) RemoteClientFactory {
+func NewRemoteClientFactory(c Credentials, h flux.HTTPConfig, l log.Logger, mc MemcacheClient, ce time.Duration) RemoteClientFactory {
 	return &remoteClientFactory{
 		creds:          c,
+		http:           h,
 		Logger:         l,
 		MemcacheClient: mc,
 		CacheExpiry:    ce,
@@ -38,6 +41,7 @@ func NewRemoteClientFactory(c Credentials, l log.Logger, mc MemcacheClient, ce t
 
 type remoteClientFactory struct {
 	creds          Credentials
+	http           flux.HTTPConfig
 	Logger         log.Logger
 	MemcacheClient MemcacheClient
 	CacheExpiry    time.Duration
@@ -69,8 +73,10 @@ func (f *remoteClientFactory) newRegistryClient(host string) (client dockerRegis
 	// Add a timeout to the request
 	ctx, cancel = context.WithTimeout(ctx, requestTimeout)
 
-	// Use the wrapper to fix headers for quay.io, and remember bearer tokens
-	var transport http.RoundTripper = &wwwAuthenticateFixer{transport: http.DefaultTransport}
+	// Use the wrapper to fix headers for quay.io, and remember bearer
+	// tokens; underneath, add any headers configured, so they go on
+	// the requests for tokens too
+	var transport http.RoundTripper = &wwwAuthenticateFixer{transport: HeaderRoundTripper(http.DefaultTransport, f.http)}
 	// Now the auth-handling wrappers that come with the library
 	transport = dockerregistry.WrapTransport(transport, httphost, auth.username, auth.password)
 	// Add the backoff mechanism so we don't DOS registries
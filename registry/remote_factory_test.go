@@ -15,7 +15,7 @@ import (
 // It will fail if there is not internet connection
 func TestRemoteFactory_CreateForDockerHub(t *testing.T) {
 	// No credentials required for public Image
-	fact := NewRemoteClientFactory(Credentials{}, log.NewNopLogger(), nil, time.Second)
+	fact := NewRemoteClientFactory(Credentials{}, flux.HTTPConfig{}, log.NewNopLogger(), nil, time.Second)
 	img, err := flux.ParseImage("alpine:latest", nil)
 	testRepository = RepositoryFromImage(img)
 	if err != nil {
@@ -36,7 +36,7 @@ func TestRemoteFactory_CreateForDockerHub(t *testing.T) {
 }
 
 func TestRemoteFactory_InvalidHost(t *testing.T) {
-	fact := NewRemoteClientFactory(Credentials{}, log.NewNopLogger(), nil, time.Second)
+	fact := NewRemoteClientFactory(Credentials{}, flux.HTTPConfig{}, log.NewNopLogger(), nil, time.Second)
 	img, err := flux.ParseImage("invalid.host/library/alpine:latest", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+)
+
+// Validators the registry gave for a tag list, which can be sent back
+// to ask for the tag list only if it has changed.
+type tagListValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// conditionalTagLister is implemented by registry clients that can
+// make conditional requests for tags.
+type conditionalTagLister interface {
+	// TagsIfChanged returns the tags for the repository, and the
+	// validators for them, unless the registry says they have not
+	// changed since the validators given, in which case changed is
+	// false.
+	TagsIfChanged(repository string, since tagListValidators) (tags []string, now tagListValidators, changed bool, err error)
+}
+
+var nextLinkRE = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+
+func (h herokuWrapper) TagsIfChanged(repository string, since tagListValidators) ([]string, tagListValidators, bool, error) {
+	u := fmt.Sprintf("%s/v2/%s/tags/list", strings.TrimSuffix(h.URL, "/"), repository)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, since, false, err
+	}
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
+	}
+
+	var tags []string
+	var now tagListValidators
+	for first := true; ; first = false {
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			return nil, since, false, err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			return nil, since, false, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, since, false, fmt.Errorf("fetching tags for %s: %s", repository, resp.Status)
+		}
+		// We only ask conditionally for the first page, so it's
+		// its validators we keep.
+		if first {
+			now = tagListValidators{
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, since, false, err
+		}
+		tags = append(tags, page.Tags...)
+
+		// Follow pagination, if the registry uses it
+		m := nextLinkRE.FindStringSubmatch(resp.Header.Get("Link"))
+		if m == nil {
+			break
+		}
+		next, err := req.URL.Parse(m[1])
+		if err != nil {
+			return nil, since, false, err
+		}
+		if req, err = http.NewRequest("GET", next.String(), nil); err != nil {
+			return nil, since, false, err
+		}
+	}
+	return tags, now, true, nil
+}
+
+type cachedTagList struct {
+	Validators tagListValidators `json:"validators"`
+	Digest     string            `json:"digest"`
+	Tags       []string          `json:"tags"`
+}
+
+// Tags uses conditional requests, if the registry client can make
+// them, so that unchanged tag lists are not downloaded again.
+// Registries that don't support conditional requests still send the
+// whole list; but if it's the same as before, it isn't stored again.
+func (c *Cache) Tags(repository string) ([]string, error) {
+	conditional, ok := c.next.(conditionalTagLister)
+	if !ok {
+		return c.next.Tags(repository)
+	}
+	repo, err := ParseRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+	creds := c.creds.credsFor(repo.Host())
+
+	key := strings.Join([]string{
+		"registrytagsv1",
+		creds.username,
+		repository,
+	}, "|")
+	var cached cachedTagList
+	cacheItem, err := c.Client.Get(key)
+	if err == nil {
+		if err := json.Unmarshal(cacheItem.Value, &cached); err != nil {
+			c.logger.Log("err", errors.Wrap(err, "decoding tags from memcache"))
+			cached = cachedTagList{}
+		}
+	} else if err != memcache.ErrCacheMiss {
+		c.logger.Log("err", errors.Wrap(err, "fetching tags from memcache"))
+	}
+
+	tags, validators, changed, err := conditional.TagsIfChanged(repository, cached.Validators)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		tagListFetches.With(LabelTagListResult, TagListNotModified).Add(1)
+		return cached.Tags, nil
+	}
+
+	digest := tagListDigest(tags)
+	if digest == cached.Digest && validators == cached.Validators {
+		tagListFetches.With(LabelTagListResult, TagListUnchanged).Add(1)
+		return tags, nil
+	}
+	tagListFetches.With(LabelTagListResult, TagListChanged).Add(1)
+
+	val, err := json.Marshal(cachedTagList{
+		Validators: validators,
+		Digest:     digest,
+		Tags:       tags,
+	})
+	if err != nil {
+		c.logger.Log("err", errors.Wrap(err, "serializing tags to store in memcache"))
+		return tags, nil
+	}
+	// No expiry: the entry is revalidated every time it's used.
+	if err := c.Client.Set(&memcache.Item{
+		Key:   key,
+		Value: val,
+	}); err != nil {
+		c.logger.Log("err", errors.Wrap(err, "storing tags in memcache"))
+	}
+	return tags, nil
+}
+
+// tagListDigest gives a digest of the tags, regardless of their order,
+// to tell when a tag list has changed for registries that don't
+// support conditional requests.
+func tagListDigest(tags []string) string {
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
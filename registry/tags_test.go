@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	dockerregistry "github.com/heroku/docker-registry-client/registry"
+)
+
+func TestTagsIfChanged(t *testing.T) {
+	const etag = `"tags-v1"`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v2/weaveworks/foorepo/tags/list" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Link", `</v2/weaveworks/foorepo/tags/list?n=2&last=b>; rel="next"`)
+			fmt.Fprint(w, `{"name": "weaveworks/foorepo", "tags": ["a", "b"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"name": "weaveworks/foorepo", "tags": ["c"]}`)
+	}))
+	defer server.Close()
+
+	client := herokuWrapper{&dockerregistry.Registry{
+		URL:    server.URL,
+		Client: http.DefaultClient,
+	}}
+
+	tags, validators, changed, err := client.TagsIfChanged("weaveworks/foorepo", tagListValidators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("expected tags to be reported as changed on first fetch")
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected tags %v from all pages, got %v", expected, tags)
+	}
+	if validators.ETag != etag {
+		t.Fatalf("expected ETag %s, got %q", etag, validators.ETag)
+	}
+
+	requests = 0
+	_, _, changed, err = client.TagsIfChanged("weaveworks/foorepo", validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("expected tags to be reported as not changed")
+	}
+	if requests != 1 {
+		t.Errorf("expected a single request when not modified, got %d", requests)
+	}
+}
+
+func TestTagListDigest(t *testing.T) {
+	if tagListDigest([]string{"a", "b"}) != tagListDigest([]string{"b", "a"}) {
+		t.Error("expected digest not to depend on the order of tags")
+	}
+	if tagListDigest([]string{"a", "b"}) == tagListDigest([]string{"a", "c"}) {
+		t.Error("expected digest to differ for different tags")
+	}
+}
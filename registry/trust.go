@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+)
+
+const (
+	dockerHubHost   = "index.docker.io"
+	dockerHubNotary = "https://notary.docker.io"
+)
+
+// Notary checks image signatures made with Docker Content Trust. The
+// tags of a repository that have been signed, and the digest each was
+// signed for, are kept by a Notary server as TUF "targets"
+// metadata. We believe the metadata if it is signed by one of the keys
+// configured for the registry.
+type Notary struct {
+	trust  map[string]flux.Trust
+	keys   map[string][]*ecdsa.PublicKey
+	creds  Credentials
+	client *http.Client
+}
+
+// NewNotary constructs a Notary for the registries with trust
+// configured. It returns an error if any of the keys given can't be
+// used.
+func NewNotary(config flux.UnsafeInstanceConfig, creds Credentials) (*Notary, error) {
+	keys := map[string][]*ecdsa.PublicKey{}
+	for host, trust := range config.Registry.Trust {
+		if len(trust.Keys) == 0 {
+			return nil, fmt.Errorf("no keys given for trusting %s", host)
+		}
+		if trust.Server == "" && host != dockerHubHost {
+			return nil, fmt.Errorf("no Notary server given for trusting %s", host)
+		}
+		for i, k := range trust.Keys {
+			key, err := parseTrustKey(k)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d for %s", i, host)
+			}
+			keys[host] = append(keys[host], key)
+		}
+	}
+	return &Notary{
+		trust: config.Registry.Trust,
+		keys:  keys,
+		creds: creds,
+		client: &http.Client{
+			Transport: HeaderRoundTripper(http.DefaultTransport, config.HTTP),
+			Timeout:   requestTimeout,
+		},
+	}, nil
+}
+
+func parseTrustKey(s string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("expected a PEM-encoded public key or certificate")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	case "PUBLIC KEY":
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("only ECDSA keys are supported")
+	}
+	return key, nil
+}
+
+// Trusts says whether images from the registry are to be checked for
+// signatures.
+func (n *Notary) Trusts(host string) bool {
+	_, ok := n.trust[host]
+	return ok
+}
+
+// SignedOnly says whether only signed images from the registry may be
+// released by automation.
+func (n *Notary) SignedOnly(host string) bool {
+	return n.trust[host].SignedOnly
+}
+
+type signedTargets struct {
+	// Kept as it arrived, since that is what was signed
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []struct {
+		KeyID  string `json:"keyid"`
+		Method string `json:"method"`
+		Sig    string `json:"sig"`
+	} `json:"signatures"`
+}
+
+type targets struct {
+	Expires time.Time `json:"expires"`
+	Targets map[string]struct {
+		Hashes map[string]string `json:"hashes"`
+	} `json:"targets"`
+}
+
+// SignedTargets gives the digest each tag of the repository was signed
+// for, if the signatures are from a trusted key. A tag that isn't
+// signed is absent.
+func (n *Notary) SignedTargets(repository Repository) (map[string]string, error) {
+	host := repository.Host()
+	if !n.Trusts(host) {
+		return nil, fmt.Errorf("no trust configured for %s", host)
+	}
+
+	body, err := n.fetchTargets(repository)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching signatures for %s", repository)
+	}
+	if body == nil {
+		// Nothing has been signed
+		return map[string]string{}, nil
+	}
+	var st signedTargets
+	if err := json.Unmarshal(body, &st); err != nil {
+		return nil, errors.Wrapf(err, "decoding signatures for %s", repository)
+	}
+	if !n.verify(host, st) {
+		return nil, fmt.Errorf("signatures for %s are not signed by a trusted key", repository)
+	}
+
+	var ts targets
+	if err := json.Unmarshal(st.Signed, &ts); err != nil {
+		return nil, errors.Wrapf(err, "decoding signatures for %s", repository)
+	}
+	if time.Now().After(ts.Expires) {
+		return nil, fmt.Errorf("signatures for %s expired at %s", repository, ts.Expires)
+	}
+
+	digests := map[string]string{}
+	for tag, target := range ts.Targets {
+		hash, err := base64.StdEncoding.DecodeString(target.Hashes["sha256"])
+		if err != nil || len(hash) != sha256.Size {
+			continue
+		}
+		digests[tag] = "sha256:" + hex.EncodeToString(hash)
+	}
+	return digests, nil
+}
+
+// verify checks that at least one of the signatures is from a key
+// trusted for the registry. Notary's ECDSA signatures are the two
+// numbers r and s, each padded to the size of the curve, one after
+// the other.
+func (n *Notary) verify(host string, st signedTargets) bool {
+	hash := sha256.Sum256(st.Signed)
+	for _, sig := range st.Signatures {
+		if sig.Method != "ecdsa" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err != nil || len(b) == 0 || len(b)%2 != 0 {
+			continue
+		}
+		r := new(big.Int).SetBytes(b[:len(b)/2])
+		s := new(big.Int).SetBytes(b[len(b)/2:])
+		for _, key := range n.keys[host] {
+			if ecdsa.Verify(key, hash[:], r, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gun gives the name Notary knows the repository by.
+func gun(repository Repository) string {
+	host := repository.Host()
+	if host == dockerHubHost {
+		host = "docker.io"
+	}
+	return host + "/" + repository.NamespaceImage()
+}
+
+func (n *Notary) fetchTargets(repository Repository) ([]byte, error) {
+	server := n.trust[repository.Host()].Server
+	if server == "" {
+		server = dockerHubNotary
+	}
+	u := strings.TrimSuffix(server, "/") + "/v2/" + gun(repository) + "/_trust/tuf/targets.json"
+
+	resp, err := n.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Notary servers usually want a token, even to read signatures
+	// of public repositories.
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := n.token(repository, resp.Header.Get("Www-Authenticate"))
+		if err != nil {
+			return nil, errors.Wrap(err, "getting token")
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if resp, err = n.client.Do(req); err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected response from %s: %s", server, resp.Status)
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// token gets a token from the auth server in the challenge given,
+// using the credentials for the registry if there are any.
+func (n *Notary) token(repository Repository, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unexpected challenge %q", challenge)
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm in challenge %q", challenge)
+	}
+
+	query := url.Values{}
+	query.Set("service", params["service"])
+	query.Set("scope", "repository:"+gun(repository)+":pull")
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if cred := n.creds.credsFor(repository.Host()); cred.username != "" {
+		req.SetBasicAuth(cred.username, cred.password)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response from %s: %s", params["realm"], resp.Status)
+	}
+	var t struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	return t.Token, nil
+}
@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+func publicKeyPEM(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signTargets makes targets metadata for a tag, signed as Notary does.
+func signTargets(t *testing.T, key *ecdsa.PrivateKey, tag string, hash []byte, expires time.Time) string {
+	signed := fmt.Sprintf(`{"_type":"Targets","expires":%q,"targets":{%q:{"hashes":{"sha256":%q},"length":528}},"version":1}`,
+		expires.Format(time.RFC3339), tag, base64.StdEncoding.EncodeToString(hash))
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):], rb)
+	copy(sig[64-len(sb):], sb)
+	return fmt.Sprintf(`{"signed":%s,"signatures":[{"keyid":"abc","method":"ecdsa","sig":%q}]}`,
+		signed, base64.StdEncoding.EncodeToString(sig))
+}
+
+func TestNotary_SignedTargets(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte("manifest"))
+
+	targets := map[string]string{
+		"/v2/quay.io/test/signed/_trust/tuf/targets.json":  signTargets(t, key, "v1", hash[:], time.Now().Add(time.Hour)),
+		"/v2/quay.io/test/other/_trust/tuf/targets.json":   signTargets(t, other, "v1", hash[:], time.Now().Add(time.Hour)),
+		"/v2/quay.io/test/expired/_trust/tuf/targets.json": signTargets(t, key, "v1", hash[:], time.Now().Add(-time.Hour)),
+	}
+	// The token endpoint is on the same server, and doesn't need a token
+	server := httptest.NewServer(tokenHandler{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="notary"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if body, ok := targets[r.URL.Path]; ok {
+			fmt.Fprint(w, body)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})})
+	defer server.Close()
+
+	var config flux.UnsafeInstanceConfig
+	config.Registry.Trust = map[string]flux.Trust{
+		"quay.io": {Server: server.URL, Keys: []string{publicKeyPEM(t, key)}},
+	}
+	notary, err := NewNotary(config, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, _ := ParseRepository("quay.io/test/signed")
+	signed, err := notary.SignedTargets(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "sha256:" + hex.EncodeToString(hash[:]); signed["v1"] != expected {
+		t.Errorf("expected v1 to be signed for %s, got %v", expected, signed)
+	}
+
+	repo, _ = ParseRepository("quay.io/test/unsigned")
+	if signed, err = notary.SignedTargets(repo); err != nil || len(signed) != 0 {
+		t.Errorf("expected nothing signed, got %v, %v", signed, err)
+	}
+
+	for _, name := range []string{"quay.io/test/other", "quay.io/test/expired"} {
+		repo, _ = ParseRepository(name)
+		if _, err = notary.SignedTargets(repo); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	repo, _ = ParseRepository("gcr.io/test/signed")
+	if _, err = notary.SignedTargets(repo); err == nil {
+		t.Error("expected error for registry without trust")
+	}
+}
+
+type tokenHandler struct {
+	next http.Handler
+}
+
+func (h tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		fmt.Fprint(w, `{"token":"sekret"}`)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func TestNewNotary_InvalidKey(t *testing.T) {
+	var config flux.UnsafeInstanceConfig
+	config.Registry.Trust = map[string]flux.Trust{
+		"quay.io": {Server: "https://notary.example.com", Keys: []string{"not a key"}},
+	}
+	if _, err := NewNotary(config, Credentials{}); err == nil {
+		t.Error("expected error for invalid key")
+	}
+	config.Registry.Trust = map[string]flux.Trust{
+		"quay.io": {Server: "https://notary.example.com"},
+	}
+	if _, err := NewNotary(config, Credentials{}); err == nil {
+		t.Error("expected error for no keys")
+	}
+}
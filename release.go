@@ -1,19 +1,27 @@
 package flux
 
 import (
+	"context"
 	"sort"
+	"strings"
 	"time"
 
 	"fmt"
 	"github.com/weaveworks/flux/guid"
 )
 
-// ReleaseKind says whether a release is to be planned only, or planned then executed
+// ReleaseKind says whether a release is to be planned only, or planned
+// then executed; and if executed, whether it must update all the
+// services it targets or none of them, or whether it's done blue/green
+// (with a copy of each service's workload, which is switched to once
+// it's ready).
 type ReleaseKind string
 
 const (
-	ReleaseKindPlan    ReleaseKind = "plan"
-	ReleaseKindExecute             = "execute"
+	ReleaseKindPlan      ReleaseKind = "plan"
+	ReleaseKindExecute               = "execute"
+	ReleaseKindAtomic                = "atomic"
+	ReleaseKindBlueGreen             = "bluegreen"
 )
 
 func ParseReleaseKind(s string) (ReleaseKind, error) {
@@ -22,6 +30,10 @@ func ParseReleaseKind(s string) (ReleaseKind, error) {
 		return ReleaseKindPlan, nil
 	case string(ReleaseKindExecute):
 		return ReleaseKindExecute, nil
+	case string(ReleaseKindAtomic):
+		return ReleaseKindAtomic, nil
+	case string(ReleaseKindBlueGreen):
+		return ReleaseKindBlueGreen, nil
 	default:
 		return "", ErrInvalidReleaseKind
 	}
@@ -48,10 +60,32 @@ func NewReleaseID() ReleaseID {
 type ReleaseCause struct {
 	Message string
 	User    string
+	// Authenticated is true if the user was taken from the
+	// credentials the release was asked for with, rather than given
+	// by the client; only then can the user be checked against
+	// CODEOWNERS.
+	Authenticated bool `json:",omitempty"`
 }
 
+// UserAutomated is the user of releases made by automation. It can't
+// be given in requests to the API.
 const UserAutomated = "<automated>"
 
+type userKey struct{}
+
+// WithUser gives a context carrying the user whose credentials a
+// request was made with.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext gives the user whose credentials a request was made
+// with, if they are known.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey{}).(string)
+	return user, ok && user != ""
+}
+
 // Release describes a release
 type Release struct {
 	ID        ReleaseID            `json:"id"`
@@ -66,6 +100,14 @@ type Release struct {
 	Cause  ReleaseCause  `json:"cause"`
 	Spec   ReleaseSpec   `json:"spec"`
 	Result ReleaseResult `json:"result"`
+
+	// Revision is the commit in the config repo made for this
+	// release, if there was one.
+	Revision string `json:"revision,omitempty"`
+	// PullRequest is the URL of the pull request opened with the
+	// changes made by this release, if the instance proposes changes
+	// that way, rather than pushing them.
+	PullRequest string `json:"pullRequest,omitempty"`
 }
 
 // NB: these get sent from fluxctl, so we have to maintain the json format of
@@ -75,6 +117,135 @@ type ReleaseSpec struct {
 	ImageSpec    ImageSpec
 	Kind         ReleaseKind
 	Excludes     []ServiceID
+	ValueUpdates []ValueUpdate `json:",omitempty"`
+}
+
+// ValueKind says where a value changed by a release lives.
+type ValueKind string
+
+const (
+	// ValueKindEnv is an environment variable of a service's
+	// containers.
+	ValueKindEnv ValueKind = "env"
+	// ValueKindConfigMap is a data key of a ConfigMap in a service's
+	// namespace.
+	ValueKindConfigMap ValueKind = "configmap"
+)
+
+// ValueUpdate is a change to a value in the resource definitions
+// other than an image, made by a release along with (or instead of)
+// image updates.
+type ValueUpdate struct {
+	Kind      ValueKind
+	Container string `json:",omitempty"` // for env, if blank, any container with the variable
+	ConfigMap string `json:",omitempty"` // for configmap, the name of the ConfigMap
+	Key       string
+	Value     string
+}
+
+// ParseValueUpdate parses an update of the kind given, in the form
+// `[container:]NAME=value` for environment variables, or
+// `configmap:KEY=value` for ConfigMap data.
+func ParseValueUpdate(kind ValueKind, s string) (ValueUpdate, error) {
+	eq := strings.Index(s, "=")
+	if eq < 1 {
+		return ValueUpdate{}, fmt.Errorf("invalid value update %q; expected NAME=value", s)
+	}
+	u := ValueUpdate{Kind: kind, Key: s[:eq], Value: s[eq+1:]}
+	if colon := strings.Index(u.Key, ":"); colon >= 0 {
+		u.Container, u.Key = u.Key[:colon], u.Key[colon+1:]
+	}
+	switch kind {
+	case ValueKindEnv:
+	case ValueKindConfigMap:
+		if u.Container == "" {
+			return ValueUpdate{}, fmt.Errorf("invalid ConfigMap update %q; expected configmap:KEY=value", s)
+		}
+		u.ConfigMap, u.Container = u.Container, ""
+	default:
+		return ValueUpdate{}, ErrInvalidValueKind
+	}
+	if u.Key == "" {
+		return ValueUpdate{}, fmt.Errorf("invalid value update %q; no name given", s)
+	}
+	return u, nil
+}
+
+func (u ValueUpdate) String() string {
+	switch {
+	case u.Kind == ValueKindConfigMap:
+		return fmt.Sprintf("%s:%s=%s", u.ConfigMap, u.Key, u.Value)
+	case u.Container != "":
+		return fmt.Sprintf("%s:%s=%s", u.Container, u.Key, u.Value)
+	default:
+		return fmt.Sprintf("%s=%s", u.Key, u.Value)
+	}
+}
+
+// ReleaseProblem is something that would stop a release, or part of
+// it, from going ahead. Field names the part of the spec at fault;
+// Service, if not empty, is the service affected.
+type ReleaseProblem struct {
+	Field   string    `json:"field"`
+	Service ServiceID `json:"service,omitempty"`
+	Message string    `json:"message"`
+}
+
+func (p ReleaseProblem) String() string {
+	if p.Service != "" {
+		return fmt.Sprintf("%s: %s: %s", p.Field, p.Service, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Field, p.Message)
+}
+
+// Problems checks that each part of the spec is well-formed, without
+// looking at the services or images it refers to.
+func (s ReleaseSpec) Problems() []ReleaseProblem {
+	var problems []ReleaseProblem
+	problem := func(field, format string, args ...interface{}) {
+		problems = append(problems, ReleaseProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.ServiceSpecs) == 0 {
+		problem("ServiceSpecs", "no services given")
+	}
+	for _, spec := range s.ServiceSpecs {
+		if _, err := ParseServiceSpec(string(spec)); err != nil {
+			problem("ServiceSpecs", "%q: %s", spec, err)
+		}
+	}
+	if _, err := ParseImageSpec(string(s.ImageSpec)); err != nil {
+		problem("ImageSpec", "%q: %s", s.ImageSpec, err)
+	}
+	if _, err := ParseReleaseKind(string(s.Kind)); err != nil {
+		problem("Kind", "%q: %s", s.Kind, err)
+	}
+	for _, id := range s.Excludes {
+		if _, err := ParseServiceID(string(id)); err != nil {
+			problem("Excludes", "%q: %s", id, err)
+		}
+	}
+	for _, u := range s.ValueUpdates {
+		switch {
+		case u.Kind != ValueKindEnv && u.Kind != ValueKindConfigMap:
+			problem("ValueUpdates", "%q: %s", u, ErrInvalidValueKind)
+		case u.Key == "":
+			problem("ValueUpdates", "%q: no name given", u)
+		case u.Kind == ValueKindConfigMap && u.ConfigMap == "":
+			problem("ValueUpdates", "%q: no ConfigMap given", u)
+		}
+	}
+	// A blue/green release runs a copy of each workload with new
+	// images; there's nothing to copy for other changes.
+	if s.Kind == ReleaseKindBlueGreen {
+		if s.ImageSpec == ImageSpecNone {
+			problem("Kind", "a blue/green release must update images")
+		}
+		if len(s.ValueUpdates) > 0 {
+			problem("ValueUpdates", "values can't be changed in a blue/green release")
+		}
+	}
+	return problems
 }
 
 // ReleaseType gives a one-word description of the release, mainly
@@ -83,6 +254,8 @@ func (s ReleaseSpec) ReleaseType() string {
 	switch {
 	case s.ImageSpec == ImageSpecLatest:
 		return "latest_images"
+	case s.ImageSpec == ImageSpecNone && len(s.ValueUpdates) > 0:
+		return "values"
 	case s.ImageSpec == ImageSpecNone:
 		return "config_only"
 	default:
@@ -131,6 +304,7 @@ type ServiceResult struct {
 	Status       ServiceReleaseStatus // summary of what happened, e.g., "incomplete", "ignored", "success"
 	Error        string               `json:",omitempty"` // error if there was one finding the service (e.g., it doesn't exist in repo)
 	PerContainer []ContainerUpdate    // what happened with each container
+	Values       []ValueUpdate        `json:",omitempty"` // values changed, other than images
 }
 
 func (fr ServiceResult) Msg(id ServiceID) string {
@@ -142,3 +316,15 @@ type ContainerUpdate struct {
 	Current   ImageID
 	Target    ImageID
 }
+
+// ServiceVersion is a period during which a container of a service ran
+// a particular image, as released by flux.
+type ServiceVersion struct {
+	Container string     `json:"container"`
+	Image     ImageID    `json:"image"`
+	Since     time.Time  `json:"since"`
+	Until     *time.Time `json:"until,omitempty"` // nil if it's still running
+	ReleaseID ReleaseID  `json:"releaseID"`
+	Revision  string     `json:"revision,omitempty"`
+	User      string     `json:"user,omitempty"`
+}
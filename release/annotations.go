@@ -0,0 +1,65 @@
+package release
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// Annotations put on each workload a release changes, so that tools
+// looking at the cluster (and `kubectl describe`) can see the last
+// release flux made of it, without asking flux.
+const (
+	releaseIDAnnotation             = "flux.weave.works/release-id"
+	releaseTimeAnnotation           = "flux.weave.works/release-time"
+	releaseUserAnnotation           = "flux.weave.works/release-user"
+	releaseRevisionAnnotation       = "flux.weave.works/release-revision"
+	releasePullRequestAnnotation    = "flux.weave.works/release-pull-request"
+	releasePreviousImagesAnnotation = "flux.weave.works/release-previous-images"
+)
+
+// releaseAnnotations gives the annotations for a workload changed by
+// a release. The previous images are given as `container=image`,
+// separated by commas.
+func releaseAnnotations(id flux.ReleaseID, now time.Time, cause flux.ReleaseCause, revision, pullRequest string, update *ServiceUpdate) map[string]string {
+	annotations := map[string]string{
+		releaseIDAnnotation:   string(id),
+		releaseTimeAnnotation: now.UTC().Format(time.RFC3339),
+	}
+	if cause.User != "" {
+		annotations[releaseUserAnnotation] = cause.User
+	}
+	if revision != "" {
+		annotations[releaseRevisionAnnotation] = revision
+	}
+	if pullRequest != "" {
+		annotations[releasePullRequestAnnotation] = pullRequest
+	}
+	var previous []string
+	for _, c := range update.Updates {
+		previous = append(previous, c.Container+"="+c.Current.String())
+	}
+	if len(previous) > 0 {
+		sort.Strings(previous)
+		annotations[releasePreviousImagesAnnotation] = strings.Join(previous, ",")
+	}
+	return annotations
+}
+
+// annotateUpdates puts the release annotations in the definitions to
+// be applied; the files in the repo are left as they are. A
+// definition that can't be annotated is applied without them.
+func annotateUpdates(updates []*ServiceUpdate, id flux.ReleaseID, cause flux.ReleaseCause, revision, pullRequest string, logf statusFn) {
+	now := time.Now()
+	for _, update := range updates {
+		def, err := kubernetes.Annotate(update.ManifestBytes, releaseAnnotations(id, now, cause, revision, pullRequest, update))
+		if err != nil {
+			logf("%s: not annotating with the release: %s", update.ServiceID, err)
+			continue
+		}
+		update.ManifestBytes = def
+	}
+}
@@ -0,0 +1,49 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestReleaseAnnotations(t *testing.T) {
+	now := time.Date(2017, 3, 2, 10, 14, 7, 0, time.UTC)
+	update := &ServiceUpdate{
+		Updates: []flux.ContainerUpdate{
+			{Container: "sidecar", Current: mustParseImageID(t, "quay.io/weaveworks/sidecar:v1")},
+			{Container: "greeter", Current: mustParseImageID(t, "quay.io/weaveworks/helloworld:v2")},
+		},
+	}
+	got := releaseAnnotations("release-1", now, flux.ReleaseCause{User: "bob"}, "a1b2c3", "", update)
+	expected := map[string]string{
+		releaseIDAnnotation:             "release-1",
+		releaseTimeAnnotation:           "2017-03-02T10:14:07Z",
+		releaseUserAnnotation:           "bob",
+		releaseRevisionAnnotation:       "a1b2c3",
+		releasePreviousImagesAnnotation: "greeter=quay.io/weaveworks/helloworld:v2,sidecar=quay.io/weaveworks/sidecar:v1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Nothing unknown is recorded
+	got = releaseAnnotations("release-2", now, flux.ReleaseCause{}, "", "https://github.com/example/config/pull/1", &ServiceUpdate{})
+	expected = map[string]string{
+		releaseIDAnnotation:          "release-2",
+		releaseTimeAnnotation:        "2017-03-02T10:14:07Z",
+		releasePullRequestAnnotation: "https://github.com/example/config/pull/1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func mustParseImageID(t *testing.T, s string) flux.ImageID {
+	id, err := flux.ParseImageID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
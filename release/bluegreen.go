@@ -0,0 +1,447 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// A blue/green release goes in phases, each a commit to the config
+// repo: first a copy of each workload, with the new images, is added
+// alongside the original; then, once the copies are ready, the
+// originals are updated and the copies removed. If the copies aren't
+// ready in time, they're removed and the originals left alone.
+const (
+	blueGreenCheckInterval = 30 * time.Second
+	blueGreenTimeout       = 10 * time.Minute
+	// If removing the copies from the config repo fails, it's tried
+	// again, up to this many times.
+	blueGreenAbortAttempts = 5
+)
+
+// ErrBlueGreenPullRequest is the error for a blue/green release when
+// changes are to be proposed in pull requests.
+var ErrBlueGreenPullRequest = errors.New("blue/green releases push to the branch, so can't be made while changes are proposed in pull requests")
+
+// startBlueGreen commits and applies a copy of each workload to be
+// updated, and gives the job that waits for the copies to be ready.
+func startBlueGreen(rc *ReleaseContext, job *jobs.Job, updates []*ServiceUpdate, results flux.ReleaseResult, logStatus, logOutput statusFn, report resultFn) ([]jobs.Job, error) {
+	params := job.Params.(jobs.ReleaseJobParams)
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Settings.Git.PullRequest.ProposeAll() {
+		return nil, ErrBlueGreenPullRequest
+	}
+
+	logStatus("Pushing copies of services.")
+	var (
+		copies []jobs.BlueGreenCopy
+		defs   []platform.ServiceDefinition
+		added  []string
+	)
+	for _, update := range updates {
+		def, err := kubernetes.BlueGreenCopy(update.ManifestBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "copying %s", update.ServiceID)
+		}
+		copyPath := blueGreenCopyPath(update.ManifestPath)
+		if err := ioutil.WriteFile(copyPath, def, 0666); err != nil {
+			return nil, err
+		}
+		manifestRel, err := filepath.Rel(rc.WorkingDir, update.ManifestPath)
+		if err != nil {
+			return nil, err
+		}
+		copyRel, err := filepath.Rel(rc.WorkingDir, copyPath)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, copyRel)
+		copies = append(copies, jobs.BlueGreenCopy{
+			ServiceID:    update.ServiceID,
+			ManifestPath: manifestRel,
+			CopyPath:     copyRel,
+			Updates:      update.Updates,
+		})
+		defs = append(defs, platform.ServiceDefinition{
+			ServiceID:     update.ServiceID,
+			NewDefinition: def,
+		})
+	}
+	if err := rc.Instance.ConfigRepo().Add(rc.WorkingDir, added...); err != nil {
+		return nil, err
+	}
+	spec := params.Spec()
+	if err := rc.CommitAndPushAs("Blue/green: start "+commitMessageFromReleaseSpec(&spec), commitAuthor(params.Cause)); err != nil {
+		return nil, err
+	}
+	revision, err := rc.HeadRevision()
+	if err != nil {
+		rc.Instance.Log("err", err)
+	}
+	logOutput("git commit and push to branch %q: revision %s", rc.Instance.ConfigRepo().Branch, revision)
+
+	// If the copies can't be applied, the next job removes them
+	// straight away.
+	logStatus("Starting copies of services.")
+	deadline := time.Now().Add(blueGreenTimeout)
+	applyErr := rc.Instance.PlatformApply(defs)
+	if applyErr != nil {
+		logOutput("apply copies: %s", applyErr)
+		deadline = time.Now()
+	}
+	report(results)
+
+	if err := logBlueGreenEvent(rc.Instance, flux.ReleaseID(job.ID), copies, flux.BlueGreenStarted, revision, applyErr); err != nil {
+		rc.Instance.Log("err", errors.Wrap(err, "logging event"))
+	}
+	logStatus("Waiting for copies of services to be ready.")
+	return []jobs.Job{{
+		Queue:       jobs.ReleaseJob,
+		Method:      jobs.BlueGreenJob,
+		Priority:    job.Priority,
+		ScheduledAt: time.Now().Add(blueGreenCheckInterval),
+		Params: jobs.BlueGreenJobParams{
+			ReleaseJobParams: params,
+			ReleaseID:        flux.ReleaseID(job.ID),
+			Copies:           copies,
+			Deadline:         deadline,
+		},
+	}}, nil
+}
+
+// blueGreen checks on the copies started by a blue/green release, and
+// once they're all ready, switches over to the new images; or, if
+// they're not ready by the deadline, removes them. Until then, it
+// schedules itself to check again. If the config repo can't be
+// changed, the release is called off, and the job to remove the
+// copies is given along with the error.
+func (r *Releaser) blueGreen(job *jobs.Job, logStatus statusFn, report resultFn) ([]jobs.Job, error) {
+	params := job.Params.(jobs.BlueGreenJobParams)
+	logOutput := func(format string, args ...interface{}) {
+		job.Output = append(job.Output, "  "+fmt.Sprintf(format, args...))
+	}
+
+	inst, err := r.instancer.Get(job.Instance)
+	if err != nil {
+		return nil, err
+	}
+	inst.Logger = log.NewContext(inst.Logger).With("release-id", string(params.ReleaseID))
+
+	// Once the release is being called off, there's no need to check
+	// on the copies; they're to be removed regardless.
+	if params.AbortReason == "" {
+		logStatus("Checking copies of services.")
+		ready, err := blueGreenReady(inst, params.Copies, logOutput)
+		if err != nil {
+			return nil, err
+		}
+		if !ready {
+			if time.Now().Before(params.Deadline) {
+				logStatus("Copies of services are not ready yet; checking again later.")
+				return []jobs.Job{{
+					Queue:       job.Queue,
+					Method:      job.Method,
+					Priority:    job.Priority,
+					ScheduledAt: time.Now().Add(blueGreenCheckInterval),
+					Params:      params,
+				}}, nil
+			}
+			var ids []string
+			for _, c := range params.Copies {
+				ids = append(ids, string(c.ServiceID))
+			}
+			params.AbortReason = fmt.Sprintf("copies of %s were not ready by %s", strings.Join(ids, ", "), params.Deadline.Format(time.RFC822))
+		}
+	}
+
+	rc := NewReleaseContext(inst)
+	defer rc.Clean()
+	logStatus("Cloning git repository.")
+	if err = rc.CloneRepo(); git.KindOf(err) == git.NetworkTimeout {
+		logStatus("Timed out cloning git repository; trying again.")
+		err = rc.CloneRepo()
+	}
+	if err != nil {
+		if params.AbortReason == "" {
+			params.AbortReason = fmt.Sprintf("cloning git repository to switch over failed: %s", err)
+		}
+		return retryBlueGreenAbort(job, params), err
+	}
+
+	if params.AbortReason != "" {
+		return abortBlueGreen(rc, job, params, logStatus, logOutput)
+	}
+	return switchBlueGreen(rc, job, params, logStatus, logOutput, report)
+}
+
+// retryBlueGreenAbort gives the job that has another go at removing
+// the copies of a blue/green release, or nothing, if it's been tried
+// enough times already.
+func retryBlueGreenAbort(job *jobs.Job, params jobs.BlueGreenJobParams) []jobs.Job {
+	if params.AbortAttempts >= blueGreenAbortAttempts {
+		return nil
+	}
+	params.AbortAttempts++
+	return []jobs.Job{{
+		Queue:       job.Queue,
+		Method:      job.Method,
+		Priority:    job.Priority,
+		ScheduledAt: time.Now().Add(blueGreenCheckInterval),
+		Params:      params,
+	}}
+}
+
+// blueGreenReady says whether the copies of all the services are
+// ready.
+func blueGreenReady(inst *instance.Instance, copies []jobs.BlueGreenCopy, logOutput statusFn) (bool, error) {
+	var ids []flux.ServiceID
+	for _, c := range copies {
+		ids = append(ids, c.ServiceID)
+	}
+	services, err := inst.GetServices(ids)
+	if err != nil {
+		return false, err
+	}
+	statuses := map[flux.ServiceID]string{}
+	for _, service := range services {
+		statuses[service.ID] = service.Metadata[kubernetes.CopyStatusKey]
+	}
+	ready := true
+	for _, id := range ids {
+		status, ok := statuses[id]
+		if !ok {
+			status = "not found"
+		}
+		logOutput("%s: copy is %s", id, status)
+		if status != kubernetes.StatusReady {
+			ready = false
+		}
+	}
+	return ready, nil
+}
+
+// switchBlueGreen updates each service's definition with the images of
+// its copy, removes the copies, commits and applies the change, then
+// deletes the copies from the cluster. It's recorded as the release.
+// If the change can't be committed, the release is called off.
+func switchBlueGreen(rc *ReleaseContext, job *jobs.Job, params jobs.BlueGreenJobParams, logStatus, logOutput statusFn, report resultFn) ([]jobs.Job, error) {
+	results := flux.ReleaseResult{}
+	var updates []*ServiceUpdate
+	for _, c := range params.Copies {
+		path := filepath.Join(rc.WorkingDir, c.ManifestPath)
+		def, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range c.Updates {
+			if def, err = updatePodController(def, u.Target); err != nil {
+				return nil, errors.Wrapf(err, "updating %s", c.ServiceID)
+			}
+		}
+		updates = append(updates, &ServiceUpdate{
+			ServiceID:     c.ServiceID,
+			ManifestPath:  path,
+			ManifestBytes: def,
+			Updates:       c.Updates,
+		})
+		results[c.ServiceID] = flux.ServiceResult{
+			Status:       flux.ReleaseStatusPending,
+			PerContainer: c.Updates,
+		}
+	}
+	report(results)
+
+	logStatus("Pushing changes.")
+	if err := writeUpdates(updates); err != nil {
+		return nil, err
+	}
+	copyDefs, err := removeBlueGreenCopies(rc, params.Copies)
+	if err != nil {
+		params.AbortReason = fmt.Sprintf("removing copies to switch over failed: %s", err)
+		return retryBlueGreenAbort(job, params), err
+	}
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Settings.Git.Lockfile {
+		if err := rc.UpdateLockfile(updates); err != nil {
+			return nil, err
+		}
+	}
+	spec := params.Spec()
+	// If the job is being retried, the switch may have been pushed
+	// already.
+	err = rc.CommitAndPushAs("Blue/green: switch to "+commitMessageFromReleaseSpec(&spec), commitAuthor(params.Cause))
+	if err != nil && err != git.ErrNoChanges {
+		params.AbortReason = fmt.Sprintf("pushing the switch over failed: %s", err)
+		return retryBlueGreenAbort(job, params), err
+	}
+	revision, err := rc.HeadRevision()
+	if err != nil {
+		rc.Instance.Log("err", err)
+	}
+	logOutput("git commit and push to branch %q: revision %s", rc.Instance.ConfigRepo().Branch, revision)
+
+	annotateUpdates(updates, params.ReleaseID, params.Cause, revision, "", logOutput)
+	logStatus("Applying changes.")
+	applyErr := applyChanges(rc.Instance, updates, results)
+	for _, update := range updates {
+		result := results[update.ServiceID]
+		if result.Error != "" {
+			logOutput("apply %s: %s: %s", update.ServiceID, result.Status, result.Error)
+		} else {
+			logOutput("apply %s: %s", update.ServiceID, result.Status)
+		}
+	}
+	// Leave the copies running if the originals didn't take the
+	// update, so there's still something serving the new images.
+	if applyErr == nil {
+		logStatus("Deleting copies of services.")
+		if err := deleteBlueGreenCopies(rc.Instance, copyDefs); err != nil {
+			logOutput("delete copies: %s", err)
+		}
+	}
+	report(results)
+
+	if err := logBlueGreenEvent(rc.Instance, params.ReleaseID, params.Copies, flux.BlueGreenSwitched, revision, applyErr); err != nil {
+		rc.Instance.Log("err", errors.Wrap(err, "logging event"))
+	}
+
+	status := flux.ReleaseStatusSuccess
+	if applyErr != nil {
+		status = flux.ReleaseStatusFailed
+	}
+	release := flux.Release{
+		ID:        params.ReleaseID,
+		CreatedAt: job.Submitted,
+		StartedAt: job.Claimed,
+		EndedAt:   time.Now().UTC(),
+		Done:      true,
+		Priority:  job.Priority,
+		Status:    status,
+		Log:       job.Log,
+		Cause:     params.Cause,
+		Spec:      spec,
+		Result:    results,
+		Revision:  revision,
+	}
+	logStatus("Sending notifications.")
+	notifyErr := sendNotifications(rc.Instance, applyErr, release)
+	return nil, logEvent(rc.Instance, notifyErr, release)
+}
+
+// abortBlueGreen removes the copies from the repo and the cluster,
+// leaving the services as they were. If the copies can't be removed
+// from the repo, it gives the job to try again.
+func abortBlueGreen(rc *ReleaseContext, job *jobs.Job, params jobs.BlueGreenJobParams, logStatus, logOutput statusFn) ([]jobs.Job, error) {
+	abortErr := errors.New(params.AbortReason)
+
+	logStatus("Removing copies of services.")
+	copyDefs, err := removeBlueGreenCopies(rc, params.Copies)
+	if err != nil {
+		return retryBlueGreenAbort(job, params), err
+	}
+	err = rc.CommitAndPushAs(fmt.Sprintf("Blue/green: abort release %s", params.ReleaseID), commitAuthor(params.Cause))
+	if err != nil && err != git.ErrNoChanges {
+		return retryBlueGreenAbort(job, params), err
+	}
+	revision, err := rc.HeadRevision()
+	if err != nil {
+		rc.Instance.Log("err", err)
+	}
+	logOutput("git commit and push to branch %q: revision %s", rc.Instance.ConfigRepo().Branch, revision)
+
+	logStatus("Deleting copies of services.")
+	if err := deleteBlueGreenCopies(rc.Instance, copyDefs); err != nil {
+		logOutput("delete copies: %s", err)
+	}
+	if err := logBlueGreenEvent(rc.Instance, params.ReleaseID, params.Copies, flux.BlueGreenAborted, revision, abortErr); err != nil {
+		rc.Instance.Log("err", errors.Wrap(err, "logging event"))
+	}
+	return nil, abortErr
+}
+
+// removeBlueGreenCopies removes the files for the copies from the
+// working clone, and gives their definitions so they can be deleted
+// from the cluster. A copy that's already gone is passed over, so it
+// can be done again if the job is retried.
+func removeBlueGreenCopies(rc *ReleaseContext, copies []jobs.BlueGreenCopy) ([]platform.ServiceDefinition, error) {
+	var defs []platform.ServiceDefinition
+	for _, c := range copies {
+		path := filepath.Join(rc.WorkingDir, c.CopyPath)
+		def, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		defs = append(defs, platform.ServiceDefinition{ServiceID: c.ServiceID, NewDefinition: def})
+	}
+	return defs, nil
+}
+
+func deleteBlueGreenCopies(inst *instance.Instance, defs []platform.ServiceDefinition) error {
+	sync := platform.SyncDef{}
+	for _, def := range defs {
+		sync.Actions = append(sync.Actions, platform.SyncAction{
+			ResourceID: def.ServiceID.String(),
+			Delete:     def.NewDefinition,
+		})
+	}
+	return inst.PlatformSync(sync)
+}
+
+func logBlueGreenEvent(inst *instance.Instance, id flux.ReleaseID, copies []jobs.BlueGreenCopy, phase, revision string, phaseErr error) error {
+	var ids []flux.ServiceID
+	for _, c := range copies {
+		ids = append(ids, c.ServiceID)
+	}
+	metadata := flux.BlueGreenEventMetadata{
+		ReleaseID: id,
+		Phase:     phase,
+		Revision:  revision,
+	}
+	logLevel := flux.LogLevelInfo
+	if phaseErr != nil {
+		metadata.Error = phaseErr.Error()
+		logLevel = flux.LogLevelError
+	}
+	now := time.Now().UTC()
+	return inst.LogEvent(flux.Event{
+		ServiceIDs: ids,
+		Type:       flux.EventBlueGreen,
+		StartedAt:  now,
+		EndedAt:    now,
+		LogLevel:   logLevel,
+		Metadata:   metadata,
+	})
+}
+
+// blueGreenCopyPath gives the file for the copy of the workload
+// defined in the file given, e.g., "helloworld-dep-green.yaml" for
+// "helloworld-dep.yaml".
+func blueGreenCopyPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-green" + ext
+}
@@ -0,0 +1,215 @@
+package release
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// blueGreenPlatform records what's applied to and deleted from the
+// cluster during a blue/green release.
+type blueGreenPlatform struct {
+	platform.MockPlatform
+	applied []platform.ServiceDefinition
+	deleted []string
+}
+
+func newBlueGreenPlatform() *blueGreenPlatform {
+	p := &blueGreenPlatform{}
+	p.AllServicesAnswer = allSvcs
+	p.SomeServicesAnswer = []platform.Service{hwSvc}
+	p.ApplyArgTest = func(defs []platform.ServiceDefinition) error {
+		p.applied = append(p.applied, defs...)
+		return nil
+	}
+	p.SyncArgTest = func(def platform.SyncDef) error {
+		for _, action := range def.Actions {
+			if action.Delete != nil {
+				p.deleted = append(p.deleted, action.ResourceID)
+			}
+		}
+		return nil
+	}
+	return p
+}
+
+// copyStatus has the platform report the copy of helloworld as having
+// the status given.
+func (p *blueGreenPlatform) copyStatus(status string) {
+	svc := hwSvc
+	svc.Metadata = map[string]string{kubernetes.CopyStatusKey: status}
+	p.SomeServicesAnswer = []platform.Service{svc}
+}
+
+// startTestBlueGreen releases the latest helloworld image blue/green, and
+// gives the job that checks on the copy.
+func startTestBlueGreen(t *testing.T, releaser *Releaser) jobs.Job {
+	job := &jobs.Job{
+		ID: "blue-green",
+		Params: jobs.ReleaseJobParams{
+			ReleaseSpec: flux.ReleaseSpec{
+				ServiceSpecs: []flux.ServiceSpec{hwSvcSpec},
+				ImageSpec:    flux.ImageSpecLatest,
+				Kind:         flux.ReleaseKindBlueGreen,
+				Excludes:     []flux.ServiceID{},
+			},
+		},
+	}
+	next, err := releaser.release(flux.InstanceID("doesn't matter"), job, func(string, ...interface{}) {}, func(flux.ReleaseResult) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next) != 1 || next[0].Method != jobs.BlueGreenJob {
+		t.Fatalf("expected a job to check on the copy, got %#v", next)
+	}
+	return next[0]
+}
+
+func checkTestBlueGreen(releaser *Releaser, job jobs.Job, results *flux.ReleaseResult) ([]jobs.Job, error) {
+	return releaser.blueGreen(&job, func(string, ...interface{}) {}, func(r flux.ReleaseResult) {
+		*results = r
+	})
+}
+
+func Test_BlueGreen(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		copyStatus  string
+		expire      bool
+		applyErr    error
+		expectErr   bool
+		expectApply bool
+		expectGone  bool
+		expectState flux.ServiceReleaseStatus
+	}{
+		{
+			name:        "ready",
+			copyStatus:  kubernetes.StatusReady,
+			expectApply: true,
+			expectGone:  true,
+			expectState: flux.ReleaseStatusSuccess,
+		},
+		{
+			name:       "expired",
+			copyStatus: kubernetes.StatusUpdating,
+			expire:     true,
+			expectErr:  true,
+			expectGone: true,
+		},
+		{
+			// The copy is left running, since the original didn't
+			// take the new image
+			name:        "failed apply",
+			copyStatus:  kubernetes.StatusReady,
+			applyErr:    errors.New("apply failed"),
+			expectApply: true,
+			expectState: flux.ReleaseStatusFailed,
+		},
+	} {
+		p := newBlueGreenPlatform()
+		releaser, cleanup := setup(t, instance.Instance{
+			Platform: p,
+			Registry: mockRegistry,
+		})
+		defer cleanup()
+
+		job := startTestBlueGreen(t, releaser)
+		if len(p.applied) != 1 || !strings.Contains(string(p.applied[0].NewDefinition), "helloworld-green") {
+			t.Fatalf("%s: expected the copy to be applied, got %v", c.name, p.applied)
+		}
+		p.applied = nil
+
+		// Not ready yet, so it should check again later
+		p.copyStatus(kubernetes.StatusUpdating)
+		var results flux.ReleaseResult
+		next, err := checkTestBlueGreen(releaser, job, &results)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if len(next) != 1 || len(p.applied) != 0 || len(p.deleted) != 0 {
+			t.Fatalf("%s: expected only to check again, got %#v", c.name, next)
+		}
+
+		job = next[0]
+		if c.expire {
+			params := job.Params.(jobs.BlueGreenJobParams)
+			params.Deadline = time.Now().Add(-time.Minute)
+			job.Params = params
+		}
+		p.copyStatus(c.copyStatus)
+		p.ApplyError = c.applyErr
+		next, err = checkTestBlueGreen(releaser, job, &results)
+		if (err != nil) != c.expectErr {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.expectErr, err)
+		}
+		if len(next) != 0 {
+			t.Errorf("%s: expected no follow-up jobs, got %#v", c.name, next)
+		}
+		applied := len(p.applied) == 1 && strings.Contains(string(p.applied[0].NewDefinition), newImageID.String())
+		if applied != c.expectApply {
+			t.Errorf("%s: expected new image applied to be %v, got %v", c.name, c.expectApply, p.applied)
+		}
+		gone := len(p.deleted) == 1 && p.deleted[0] == hwSvcID.String()
+		if gone != c.expectGone {
+			t.Errorf("%s: expected copy deleted to be %v, got %v", c.name, c.expectGone, p.deleted)
+		}
+		if c.expectState != "" && results[hwSvcID].Status != c.expectState {
+			t.Errorf("%s: expected release status %s, got %s", c.name, c.expectState, results[hwSvcID].Status)
+		}
+	}
+}
+
+func Test_BlueGreenRetriesAbort(t *testing.T) {
+	p := newBlueGreenPlatform()
+	releaser, cleanup := setup(t, instance.Instance{
+		Platform: p,
+		Registry: mockRegistry,
+	})
+	defer cleanup()
+	job := startTestBlueGreen(t, releaser)
+
+	// Break the config repo, so it can't be cloned to switch over
+	inst, _ := releaser.instancer.Get(flux.InstanceID("doesn't matter"))
+	url := inst.Repo.URL
+	inst.Repo.URL = "/does/not/exist"
+	p.copyStatus(kubernetes.StatusReady)
+	var results flux.ReleaseResult
+	next, err := checkTestBlueGreen(releaser, job, &results)
+	if err == nil {
+		t.Fatal("expected an error cloning the repo")
+	}
+	if len(next) != 1 {
+		t.Fatalf("expected a job to abort the release, got %#v", next)
+	}
+	params := next[0].Params.(jobs.BlueGreenJobParams)
+	if params.AbortReason == "" || params.AbortAttempts != 1 {
+		t.Fatalf("expected the release to be called off, got %+v", params)
+	}
+
+	// Once it's fixed, the abort goes through, even though the copy
+	// is ready
+	inst.Repo.URL = url
+	next, err = checkTestBlueGreen(releaser, next[0], &results)
+	if err == nil || err.Error() != params.AbortReason {
+		t.Errorf("expected the release to be aborted with %q, got %v", params.AbortReason, err)
+	}
+	if len(next) != 0 {
+		t.Errorf("expected no follow-up jobs, got %#v", next)
+	}
+	if len(p.applied) != 1 || len(p.deleted) != 1 {
+		t.Errorf("expected only the copy to be applied then deleted, got %v applied, %v deleted", p.applied, p.deleted)
+	}
+
+	// It gives up eventually
+	params.AbortAttempts = blueGreenAbortAttempts
+	if next := retryBlueGreenAbort(&job, params); len(next) != 0 {
+		t.Errorf("expected no more tries after %d, got %#v", blueGreenAbortAttempts, next)
+	}
+}
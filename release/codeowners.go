@@ -0,0 +1,233 @@
+package release
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/weaveworks/flux"
+)
+
+// The places GitHub looks for a CODEOWNERS file, in the order it
+// looks.
+var codeOwnersFiles = []string{"CODEOWNERS", ".github/CODEOWNERS", "docs/CODEOWNERS"}
+
+// CodeOwners are the rules from a CODEOWNERS file, saying who owns
+// which files in the config repo. As with GitHub, the last rule to
+// match a file says who owns it.
+type CodeOwners []codeOwnersRule
+
+type codeOwnersRule struct {
+	pattern string
+	owners  []string
+}
+
+// ParseCodeOwners reads rules in the format GitHub uses: each line is
+// a gitignore-style pattern followed by the owners of matching files,
+// given as @user, @org/team, or an email address.
+func ParseCodeOwners(r io.Reader) (CodeOwners, error) {
+	var rules CodeOwners
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := codeOwnersRule{pattern: fields[0]}
+		if len(fields) > 1 {
+			rule.owners = fields[1:]
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Owners gives the owners of the file at the path given, relative to
+// the top of the repo. A file with no owners may be changed by
+// anyone.
+func (c CodeOwners) Owners(file string) []string {
+	var owners []string
+	for _, rule := range c {
+		if matchCodeOwnersPattern(rule.pattern, file) {
+			owners = rule.owners
+		}
+	}
+	return owners
+}
+
+// CanChange says whether the user can change the file at the path
+// given. Users are matched to owners by username or email address;
+// since we can't tell who is in a team, team owners match nobody.
+func (c CodeOwners) CanChange(user, file string) bool {
+	owners := c.Owners(file)
+	if len(owners) == 0 {
+		return true
+	}
+	names := userNames(user)
+	for _, owner := range owners {
+		for _, name := range names {
+			if strings.EqualFold(strings.TrimPrefix(owner, "@"), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userNames gives the names a release user may be known by in a
+// CODEOWNERS file. The user may be a username, an email address, or
+// both in the form `Name <email>`.
+func userNames(user string) []string {
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return nil
+	}
+	if i := strings.Index(user, "<"); i >= 0 && strings.HasSuffix(user, ">") {
+		return []string{strings.TrimSpace(user[:i]), user[i+1 : len(user)-1]}
+	}
+	return []string{strings.TrimPrefix(user, "@")}
+}
+
+// matchCodeOwnersPattern matches a file against a pattern as git does
+// for .gitignore: a pattern with a slash (other than at the end) is
+// relative to the top of the repo, and otherwise may match at any
+// depth; `**` matches any number of directories; and a pattern that
+// matches a directory matches everything in it.
+func matchCodeOwnersPattern(pattern, file string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(file, "/"))
+}
+
+func matchSegments(pattern, file []string) bool {
+	if len(pattern) == 0 {
+		// Anything left is inside the directory matched
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(file); i++ {
+			if matchSegments(pattern[1:], file[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(file) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], file[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], file[1:])
+}
+
+// CodeOwners reads the CODEOWNERS file from the working clone, if
+// there is one. If not, it returns nil.
+func (rc *ReleaseContext) CodeOwners() (CodeOwners, error) {
+	for _, name := range codeOwnersFiles {
+		f, err := os.Open(filepath.Join(rc.WorkingDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseCodeOwners(f)
+	}
+	return nil, nil
+}
+
+// checkOwners makes sure the user who asked for the release owns the
+// files it changes, if the config repo has a CODEOWNERS file saying
+// who owns them. Automated releases aren't made by anyone, so aren't
+// checked. A user that wasn't taken from the request's credentials
+// could be anyone, so its releases are refused.
+func checkOwners(rc *ReleaseContext, updates []*ServiceUpdate, cause flux.ReleaseCause) error {
+	if cause.User == flux.UserAutomated {
+		return nil
+	}
+	owners, err := rc.CodeOwners()
+	if err != nil {
+		return err
+	}
+	if owners == nil {
+		return nil
+	}
+	if !cause.Authenticated {
+		return NotAuthenticatedError(cause.User)
+	}
+
+	var denied []string
+	for _, update := range updates {
+		paths := []string{update.ManifestPath}
+		for _, configMap := range update.ConfigMaps {
+			paths = append(paths, configMap.Path)
+		}
+		for _, p := range paths {
+			rel, err := filepath.Rel(rc.WorkingDir, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !owners.CanChange(cause.User, rel) {
+				denied = append(denied, fmt.Sprintf("%s (owned by %s)", rel, strings.Join(owners.Owners(rel), " ")))
+			}
+		}
+	}
+	if len(denied) > 0 {
+		return NotOwnerError(cause.User, denied)
+	}
+	return nil
+}
+
+func NotOwnerError(user string, denied []string) error {
+	who := user
+	if who == "" {
+		who = "a release with no user"
+	}
+	return flux.UserConfigProblem{BaseError: &flux.BaseError{
+		Help: `Release not permitted by CODEOWNERS
+
+The config repo has a CODEOWNERS file, and the release would change
+files that are not owned by the user who asked for it:
+
+    ` + strings.Join(denied, "\n    ") + `
+
+Ask one of the owners to make the release. If you are an owner, check
+that the user of your API token is your username or email address as
+it appears in CODEOWNERS; owners that are teams cannot be checked, so
+name the members instead.
+`,
+		Err: fmt.Errorf("%s may not change %s, according to CODEOWNERS", who, strings.Join(denied, ", ")),
+	}}
+}
+
+// NotAuthenticatedError is for releases, in a config repo with a
+// CODEOWNERS file, by a user that wasn't taken from the credentials
+// the release was asked for with.
+func NotAuthenticatedError(user string) error {
+	who := user
+	if who == "" {
+		who = "a release with no user"
+	}
+	return flux.UserConfigProblem{BaseError: &flux.BaseError{
+		Help: `Release not permitted by CODEOWNERS
+
+The config repo has a CODEOWNERS file, so releases must be made by
+a user the service knows from their credentials. A user given with the
+release (e.g., with fluxctl release --user) can't be checked.
+
+Please use an API token that names your user.
+`,
+		Err: fmt.Errorf("%s is not known from the credentials given, so can't be checked against CODEOWNERS", who),
+	}}
+}
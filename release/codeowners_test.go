@@ -0,0 +1,104 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+const codeOwners = `# Everything is owned by the platform team
+*                   @example/platform
+*.yaml              @alice
+/prod/              @bob carol@example.com
+prod/**/config.yaml @dave
+docs/
+`
+
+func TestCodeOwners(t *testing.T) {
+	owners, err := ParseCodeOwners(strings.NewReader(codeOwners))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string][]string{
+		"README":                      {"@example/platform"},
+		"staging/helloworld.yaml":     {"@alice"},
+		"prod/helloworld.yaml":        {"@bob", "carol@example.com"},
+		"prod/config.yaml":            {"@dave"},
+		"prod/greeter/a/config.yaml":  {"@dave"},
+		"staging/prod/helloworld.yml": {"@example/platform"},
+		"docs/index.md":               nil,
+	} {
+		if got := owners.Owners(file); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected owners %v, got %v", file, expected, got)
+		}
+	}
+
+	for _, c := range []struct {
+		user, file string
+		ok         bool
+	}{
+		{"alice", "staging/helloworld.yaml", true},
+		{"@Alice", "staging/helloworld.yaml", true},
+		{"bob", "staging/helloworld.yaml", false},
+		{"Carol <carol@example.com>", "prod/helloworld.yaml", true},
+		{"alice", "prod/helloworld.yaml", false},
+		{"", "prod/helloworld.yaml", false},
+		{"platform", "README", false},
+		{"", "docs/index.md", true},
+	} {
+		if got := owners.CanChange(c.user, c.file); got != c.ok {
+			t.Errorf("%q changing %s: expected %v, got %v", c.user, c.file, c.ok, got)
+		}
+	}
+}
+
+func TestCheckOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-codeowners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rc := &ReleaseContext{WorkingDir: dir}
+	updates := []*ServiceUpdate{{
+		ServiceID:    flux.ServiceID("default/helloworld"),
+		ManifestPath: filepath.Join(dir, "prod", "helloworld.yaml"),
+	}}
+	alice := flux.ReleaseCause{User: "alice", Authenticated: true}
+
+	// No CODEOWNERS, so anyone can release
+	if err := checkOwners(rc, updates, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, ".github"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte(codeOwners), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	err = checkOwners(rc, updates, alice)
+	if _, ok := err.(flux.UserConfigProblem); !ok {
+		t.Fatalf("expected user config problem, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "prod/helloworld.yaml") {
+		t.Errorf("expected error to name the file, got %q", err.Error())
+	}
+	if err := checkOwners(rc, updates, flux.ReleaseCause{User: "bob", Authenticated: true}); err != nil {
+		t.Errorf("expected bob to be allowed, got %v", err)
+	}
+	// Anyone could say they're bob
+	if err := checkOwners(rc, updates, flux.ReleaseCause{User: "bob"}); err == nil {
+		t.Error("expected a release by bob, not known from credentials, to be refused")
+	}
+	if err := checkOwners(rc, updates, flux.ReleaseCause{User: flux.UserAutomated}); err != nil {
+		t.Errorf("expected automated release to be allowed, got %v", err)
+	}
+}
@@ -5,32 +5,49 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/pkg/errors"
+
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/layout"
+	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/platform/kubernetes"
+	"github.com/weaveworks/flux/release/plan"
 )
 
 const (
 	Locked         = "locked"
 	NotIncluded    = "not included"
+	NotAutomated   = "not automated"
 	Excluded       = "excluded"
 	DifferentImage = "a different image"
-	NotInCluster   = "not running in cluster"
+	NotInCluster   = plan.NotInCluster
 	NotInRepo      = "not found in repository"
-	ImageNotFound  = "cannot find one or more images"
-	ImageUpToDate  = "image(s) up to date"
+	ImageNotFound  = plan.ImageNotFound
+	ImageUpToDate  = plan.ImageUpToDate
+	SameContent    = plan.SameContent
+	NotInNamespace = "not in a namespace managed by flux"
+	Aborted        = "aborted, since other services cannot be updated"
+	ProposedOnly   = "proposed in a pull request, since the branch refused the change"
+	NoArchitecture = plan.NoArchitecture
 )
 
 type ReleaseContext struct {
 	Instance   *instance.Instance
 	WorkingDir string
+	// Manifests, if not nil, remembers the files defining each
+	// service for the trees seen before.
+	Manifests *ManifestCache
 }
 
 func NewReleaseContext(inst *instance.Instance) *ReleaseContext {
 	return &ReleaseContext{
-		Instance: inst,
+		Instance:  inst,
+		Manifests: DefaultManifestCache,
 	}
 }
 
@@ -49,18 +66,110 @@ func (rc *ReleaseContext) CommitAndPush(msg string) error {
 	return rc.Instance.ConfigRepo().CommitAndPush(rc.WorkingDir, msg)
 }
 
+func (rc *ReleaseContext) CommitAndPushAs(msg, author string) error {
+	return rc.Instance.ConfigRepo().CommitAndPushAs(rc.WorkingDir, msg, author)
+}
+
+func (rc *ReleaseContext) HeadRevision() (string, error) {
+	return rc.Instance.ConfigRepo().HeadRevision(rc.WorkingDir)
+}
+
 func (rc *ReleaseContext) RepoPath() string {
 	return filepath.Join(rc.WorkingDir, rc.Instance.ConfigRepo().Path)
 }
 
-func (rc *ReleaseContext) PushChanges(updates []*ServiceUpdate, spec *flux.ReleaseSpec) error {
-	err := writeUpdates(updates)
+// SyncRepoConfig reads the parts of the instance config kept in the
+// config repo, if the instance is set up to keep them there, and
+// records them in the instance config.
+func (rc *ReleaseContext) SyncRepoConfig() error {
+	conf, err := rc.Instance.GetConfig()
 	if err != nil {
 		return err
 	}
+	if !conf.Settings.Git.RepoConfig {
+		return nil
+	}
+	repoConf, err := instance.ReadRepoConfig(rc.RepoPath())
+	if err != nil || repoConf == nil {
+		return err
+	}
+	return rc.Instance.UpdateConfig(func(conf instance.Config) (instance.Config, error) {
+		return repoConf.Apply(conf), nil
+	})
+}
+
+// Lint checks the resource definitions in the working clone, and
+// reports any problems against the revision checked out.
+func (rc *ReleaseContext) Lint() (flux.LintReport, error) {
+	revision, err := rc.HeadRevision()
+	if err != nil {
+		return flux.LintReport{}, err
+	}
+	problems, err := kubernetes.Lint(rc.RepoPath())
+	if err != nil {
+		return flux.LintReport{}, err
+	}
+	return flux.LintReport{
+		Revision: revision,
+		Problems: problems,
+	}, nil
+}
+
+// PushChanges writes the updates to the working clone, and commits and
+// pushes them. If the instance is set up to propose changes in pull
+// requests, they are pushed to a branch for the release instead, and a
+// pull request is opened; its URL is returned.
+func (rc *ReleaseContext) PushChanges(updates []*ServiceUpdate, spec *flux.ReleaseSpec, cause flux.ReleaseCause, id flux.ReleaseID) (pullRequest string, err error) {
+	err = writeUpdates(updates)
+	if err != nil {
+		return "", err
+	}
+
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	if conf.Settings.Git.Lockfile {
+		if err := rc.UpdateLockfile(updates); err != nil {
+			return "", err
+		}
+	}
 
 	commitMsg := commitMessageFromReleaseSpec(spec)
-	return rc.CommitAndPush(commitMsg)
+	if !conf.Settings.Git.PullRequest.ProposeAll() {
+		return "", rc.CommitAndPushAs(commitMsg, commitAuthor(cause))
+	}
+
+	branch := pullRequestBranch(id)
+	if err := rc.Instance.ConfigRepo().CommitAndPushToBranch(rc.WorkingDir, commitMsg, commitAuthor(cause), branch); err != nil {
+		return "", err
+	}
+	body := fmt.Sprintf("Opened by flux for release %s", id)
+	if cause.User != "" {
+		body += fmt.Sprintf(", by %s", cause.User)
+	}
+	if cause.Message != "" {
+		body += fmt.Sprintf(": %s", cause.Message)
+	}
+	pullRequest, err = openPullRequest(conf.Settings.Git, branch, commitMsg, body+".")
+	return pullRequest, errors.Wrap(err, "opening pull request")
+}
+
+// commitAuthor gives the git author for a release made by the user in
+// the cause, or blank if it should be attributed to flux itself. Git
+// wants an email address, which we may not have; an empty one will do.
+func commitAuthor(cause flux.ReleaseCause) string {
+	user := strings.TrimSpace(cause.User)
+	switch {
+	case user == "" || user == flux.UserAutomated:
+		return ""
+	case strings.Contains(user, "<") && strings.HasSuffix(user, ">"):
+		return user
+	case strings.Contains(user, "@"):
+		return fmt.Sprintf("%s <%s>", user, user)
+	default:
+		return fmt.Sprintf("%s <>", user)
+	}
 }
 
 func writeUpdates(updates []*ServiceUpdate) error {
@@ -72,13 +181,21 @@ func writeUpdates(updates []*ServiceUpdate) error {
 		if err = ioutil.WriteFile(update.ManifestPath, update.ManifestBytes, fi.Mode()); err != nil {
 			return err
 		}
+		for _, configMap := range update.ConfigMaps {
+			if fi, err = os.Stat(configMap.Path); err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(configMap.Path, configMap.Bytes, fi.Mode()); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 func (rc *ReleaseContext) Clean() {
 	if rc.WorkingDir != "" {
-		os.RemoveAll(rc.WorkingDir)
+		git.Clean(rc.WorkingDir)
 	}
 }
 
@@ -108,52 +225,18 @@ func (rc *ReleaseContext) SelectServices(results flux.ReleaseResult, logStatus s
 		return nil, err
 	}
 
-	// Compare defined vs running
+	filter := func(id flux.ServiceID, s platform.Service) flux.ServiceResult {
+		update := *definedMap[id]
+		update.Service = s
+		return update.filter(filters...)
+	}
 	var updates []*ServiceUpdate
-	for _, s := range services {
-		logStatus("Found service %s", s.ID)
+	for _, s := range plan.Select(ids, services, filter, results, plan.Logf(logStatus)) {
 		update := definedMap[s.ID]
 		update.Service = s
 		updates = append(updates, update)
-		delete(definedMap, s.ID)
-	}
-
-	// Filter both updates ...
-	var filteredUpdates []*ServiceUpdate
-	for _, s := range updates {
-		fr := s.filter(filters...)
-		if fr.Error != "" {
-			logStatus(fr.Msg(s.ServiceID))
-		}
-		results[s.ServiceID] = fr
-		if fr.Status == flux.ReleaseStatusPending || fr.Status == flux.ReleaseStatusSuccess || fr.Status == "" {
-			filteredUpdates = append(filteredUpdates, s)
-		}
 	}
-
-	// ... and missing services
-	filteredDefined := map[flux.ServiceID]*ServiceUpdate{}
-	for k, s := range definedMap {
-		fr := s.filter(filters...)
-		if fr.Error != "" {
-			logStatus(fr.Msg(s.ServiceID))
-		}
-		results[s.ServiceID] = fr
-		if fr.Status != flux.ReleaseStatusIgnored {
-			filteredDefined[k] = s
-		}
-	}
-
-	// Mark anything left over as skipped
-	for id, _ := range filteredDefined {
-		logStatus("Skipping service %s as it is not in the running system", id)
-		results[id] = flux.ServiceResult{
-			Status: flux.ReleaseStatusSkipped,
-			Error:  NotInCluster,
-		}
-	}
-
-	return filteredUpdates, nil
+	return updates, nil
 }
 
 func (s *ServiceUpdate) filter(filters ...ServiceFilter) flux.ServiceResult {
@@ -166,25 +249,47 @@ func (s *ServiceUpdate) filter(filters ...ServiceFilter) flux.ServiceResult {
 	return flux.ServiceResult{}
 }
 
+// FindDefinedServices finds the definition of each service in the
+// working clone, according to the instance's repo layout.
 func (rc *ReleaseContext) FindDefinedServices() ([]*ServiceUpdate, error) {
-	services, err := kubernetes.FindDefinedServices(rc.RepoPath())
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	repoLayout, err := layout.Get(conf.Settings.Git.Layout)
+	if err != nil {
+		return nil, err
+	}
+	// If the tree can't be had, the files are looked at afresh.
+	tree, _ := rc.Instance.ConfigRepo().ConfigTree(rc.WorkingDir)
+	services, err := rc.Manifests.Manifests(conf.Settings.Git.Layout, repoLayout, tree, rc.RepoPath())
 	if err != nil {
 		return nil, err
 	}
 
 	var defined []*ServiceUpdate
 	for id, paths := range services {
-		switch len(paths) {
-		case 1:
-			def, err := ioutil.ReadFile(paths[0])
+		// The copies made for blue/green releases aren't the
+		// definition of the service, though they match it.
+		var found []*ServiceUpdate
+		for _, path := range paths {
+			def, err := ioutil.ReadFile(path)
 			if err != nil {
 				return nil, err
 			}
-			defined = append(defined, &ServiceUpdate{
+			if kubernetes.IsBlueGreenCopy(def) {
+				continue
+			}
+			found = append(found, &ServiceUpdate{
 				ServiceID:     id,
-				ManifestPath:  paths[0],
+				ManifestPath:  path,
 				ManifestBytes: def,
 			})
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			defined = append(defined, found[0])
 		default:
 			return nil, fmt.Errorf("multiple resource files found for service %s: %s", id, strings.Join(paths, ", "))
 		}
@@ -192,6 +297,59 @@ func (rc *ReleaseContext) FindDefinedServices() ([]*ServiceUpdate, error) {
 	return defined, nil
 }
 
+// ImageChanges finds, using git blame on the service's resource
+// definition, the commit that last changed the image of each of its
+// containers.
+func (rc *ReleaseContext) ImageChanges(service flux.ServiceID) ([]flux.ServiceChange, error) {
+	defined, err := rc.FindDefinedServices()
+	if err != nil {
+		return nil, err
+	}
+	var update *ServiceUpdate
+	for _, u := range defined {
+		if u.ServiceID == service {
+			update = u
+		}
+	}
+	if update == nil {
+		return nil, fmt.Errorf("no resource definition file found for service %s", service)
+	}
+
+	blame, err := rc.Instance.ConfigRepo().Blame(rc.WorkingDir, update.ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := kubernetes.ContainerImages(update.ManifestBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []flux.ServiceChange
+	for container, i := range kubernetes.ContainerImageLines(update.ManifestBytes) {
+		if i >= len(blame) {
+			continue
+		}
+		line := blame[i]
+		changes = append(changes, flux.ServiceChange{
+			Kind:     flux.ServiceChangeImage,
+			Name:     container,
+			Value:    images[container],
+			Time:     line.Time,
+			User:     line.Author,
+			Revision: line.Revision,
+		})
+	}
+	sort.Sort(changesByName(changes))
+	return changes, nil
+}
+
+type changesByName []flux.ServiceChange
+
+func (cs changesByName) Len() int           { return len(cs) }
+func (cs changesByName) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+func (cs changesByName) Less(i, j int) bool { return cs[i].Name < cs[j].Name }
+
 type ServiceFilter interface {
 	Filter(ServiceUpdate) flux.ServiceResult
 }
@@ -255,6 +413,24 @@ func (f *IncludeFilter) Filter(u ServiceUpdate) flux.ServiceResult {
 	}
 }
 
+// AutomatedFilter ignores services other than those given, which are
+// the automated services (and any named explicitly in the release).
+type AutomatedFilter struct {
+	IDs []flux.ServiceID
+}
+
+func (f *AutomatedFilter) Filter(u ServiceUpdate) flux.ServiceResult {
+	for _, id := range f.IDs {
+		if u.ServiceID == id {
+			return flux.ServiceResult{}
+		}
+	}
+	return flux.ServiceResult{
+		Status: flux.ReleaseStatusIgnored,
+		Error:  NotAutomated,
+	}
+}
+
 type LockedFilter struct {
 	IDs []flux.ServiceID
 }
@@ -270,3 +446,21 @@ func (f *LockedFilter) Filter(u ServiceUpdate) flux.ServiceResult {
 	}
 	return flux.ServiceResult{}
 }
+
+// NamespaceFilter ignores services outside the namespaces given.
+type NamespaceFilter struct {
+	Namespaces []string
+}
+
+func (f *NamespaceFilter) Filter(u ServiceUpdate) flux.ServiceResult {
+	namespace, _ := u.ServiceID.Components()
+	for _, ns := range f.Namespaces {
+		if namespace == ns {
+			return flux.ServiceResult{}
+		}
+	}
+	return flux.ServiceResult{
+		Status: flux.ReleaseStatusIgnored,
+		Error:  NotInNamespace,
+	}
+}
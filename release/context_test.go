@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/instance"
 	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
@@ -83,3 +84,17 @@ func execCommand(cmd string, args ...string) error {
 	c.Stdout = ioutil.Discard
 	return c.Run()
 }
+
+func TestCommitAuthor(t *testing.T) {
+	for user, expected := range map[string]string{
+		"":                        "",
+		flux.UserAutomated:        "",
+		"jane":                    "jane <>",
+		"jane@example.com":        "jane@example.com <jane@example.com>",
+		"Jane <jane@example.com>": "Jane <jane@example.com>",
+	} {
+		if got := commitAuthor(flux.ReleaseCause{User: user}); got != expected {
+			t.Errorf("for user %q, expected author %q, got %q", user, expected, got)
+		}
+	}
+}
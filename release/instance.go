@@ -21,6 +21,18 @@ func LockedServices(config instance.Config) flux.ServiceIDSet {
 	return idSet
 }
 
+func AutomatedServices(config instance.Config) flux.ServiceIDSet {
+	ids := []flux.ServiceID{}
+	for id, s := range config.Services {
+		if s.Automated {
+			ids = append(ids, id)
+		}
+	}
+	idSet := flux.ServiceIDSet{}
+	idSet.Add(ids)
+	return idSet
+}
+
 // CollectAvailableImages is a convenient shim to
 // `instance.CollectAvailableImages`.
 func CollectAvailableImages(inst *instance.Instance, updateable []*ServiceUpdate) (instance.ImageMap, error) {
@@ -41,6 +53,13 @@ func applyChanges(inst *instance.Instance, updates []*ServiceUpdate, results flu
 	var asyncDefs []platform.ServiceDefinition
 
 	for _, update := range updates {
+		// ConfigMaps go first, so they're there for the service to use.
+		for _, configMap := range update.ConfigMaps {
+			defs = append(defs, platform.ServiceDefinition{
+				ServiceID:     update.ServiceID,
+				NewDefinition: configMap.Bytes,
+			})
+		}
 		_, serviceName := update.ServiceID.Components()
 		switch serviceName {
 		case FluxServiceName, FluxDaemonName:
@@ -61,6 +80,7 @@ func applyChanges(inst *instance.Instance, updates []*ServiceUpdate, results flu
 			Status:       flux.ReleaseStatusSuccess,
 			Error:        result.Error,
 			PerContainer: result.PerContainer,
+			Values:       result.Values,
 		}
 	}
 
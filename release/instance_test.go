@@ -34,3 +34,31 @@ func TestLockedServices(t *testing.T) {
 		t.Error("service3 not locked but reported as locked")
 	}
 }
+
+func TestAutomatedServices(t *testing.T) {
+	conf := instance.Config{
+		Services: map[flux.ServiceID]instance.ServiceConfig{
+			flux.ServiceID("service1"): instance.ServiceConfig{
+				Locked: true,
+			},
+			flux.ServiceID("service2"): instance.ServiceConfig{
+				Locked:    true,
+				Automated: true,
+			},
+			flux.ServiceID("service3"): instance.ServiceConfig{
+				Automated: true,
+			},
+		},
+	}
+
+	automated := AutomatedServices(conf)
+	if automated.Contains(flux.ServiceID("service1")) {
+		t.Error("service1 not automated but reported as automated")
+	}
+	if !automated.Contains(flux.ServiceID("service2")) {
+		t.Error("service2 automated in config but not reported as automated")
+	}
+	if !automated.Contains(flux.ServiceID("service3")) {
+		t.Error("service3 automated in config but not reported as automated")
+	}
+}
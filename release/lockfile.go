@@ -0,0 +1,131 @@
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// LockfileName is the name of the file, alongside the resource
+// definitions, that records the exact image released to each
+// container, if the instance is configured to keep one.
+const LockfileName = "flux.lock"
+
+// Lockfile maps each service released, and each of its containers, to
+// the image released, as `name:tag@digest`.
+type Lockfile struct {
+	Services map[flux.ServiceID]map[string]string `json:"services"`
+}
+
+// readLockfile reads the lockfile at path; if there's no lockfile,
+// it returns an empty one and false.
+func readLockfile(path string) (Lockfile, bool, error) {
+	lock := Lockfile{Services: map[flux.ServiceID]map[string]string{}}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, false, nil
+	}
+	if err != nil {
+		return lock, false, err
+	}
+	if err := json.Unmarshal(bytes, &lock); err != nil {
+		return lock, true, fmt.Errorf("decoding %s: %s", LockfileName, err)
+	}
+	if lock.Services == nil {
+		lock.Services = map[flux.ServiceID]map[string]string{}
+	}
+	return lock, true, nil
+}
+
+// update records the targets of the updates, looking up the digest of
+// each image.
+func (l Lockfile) update(updates []*ServiceUpdate, digest func(flux.ImageID) (string, error)) error {
+	for _, u := range updates {
+		for _, c := range u.Updates {
+			d, err := digest(c.Target)
+			if err != nil {
+				return fmt.Errorf("getting digest of %s: %s", c.Target, err)
+			}
+			ref := c.Target.String()
+			if d != "" {
+				ref = ref + "@" + d
+			}
+			if l.Services[u.ServiceID] == nil {
+				l.Services[u.ServiceID] = map[string]string{}
+			}
+			l.Services[u.ServiceID][c.Container] = ref
+		}
+	}
+	return nil
+}
+
+// problems reports containers whose image in the resource definition
+// doesn't match the image recorded in the lockfile; e.g., because the
+// definition was edited outside of flux.
+func (l Lockfile) problems(services []*ServiceUpdate) []string {
+	var problems []string
+	for _, s := range services {
+		locked, ok := l.Services[s.ServiceID]
+		if !ok {
+			continue
+		}
+		images, err := kubernetes.ContainerImages(s.ManifestBytes)
+		if err != nil {
+			continue
+		}
+		var containers []string
+		for container := range locked {
+			containers = append(containers, container)
+		}
+		sort.Strings(containers)
+		for _, container := range containers {
+			lockedImage := strings.SplitN(locked[container], "@", 2)[0]
+			if image := images[container]; image != lockedImage {
+				problems = append(problems, fmt.Sprintf("%s: container %s uses %s, but %s records %s", s.ServiceID, container, image, LockfileName, lockedImage))
+			}
+		}
+	}
+	return problems
+}
+
+func (rc *ReleaseContext) lockfilePath() string {
+	return filepath.Join(rc.RepoPath(), LockfileName)
+}
+
+// VerifyLockfile checks the services against the lockfile, if there
+// is one, and reports any that don't match it.
+func (rc *ReleaseContext) VerifyLockfile(services []*ServiceUpdate) ([]string, error) {
+	lock, exists, err := readLockfile(rc.lockfilePath())
+	if err != nil || !exists {
+		return nil, err
+	}
+	return lock.problems(services), nil
+}
+
+// UpdateLockfile records the images released in the lockfile, and
+// stages it to be committed.
+func (rc *ReleaseContext) UpdateLockfile(updates []*ServiceUpdate) error {
+	path := rc.lockfilePath()
+	lock, _, err := readLockfile(path)
+	if err != nil {
+		return err
+	}
+	if err := lock.update(updates, rc.Instance.ImageDigest); err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(bytes, '\n'), 0666); err != nil {
+		return err
+	}
+	return rc.Instance.ConfigRepo().Add(rc.WorkingDir, filepath.Join(rc.Instance.ConfigRepo().Path, LockfileName))
+}
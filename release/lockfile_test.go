@@ -0,0 +1,68 @@
+package release
+
+import (
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+const lockfileDeployment = `---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: helloworld
+spec:
+  template:
+    metadata:
+      labels:
+        name: helloworld
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+      - name: sidecar
+        image: quay.io/weaveworks/sidecar:master-a000002
+`
+
+func TestLockfile(t *testing.T) {
+	target, err := flux.ParseImageID("quay.io/weaveworks/helloworld:master-a000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := flux.ServiceID("default/helloworld")
+	lock := Lockfile{Services: map[flux.ServiceID]map[string]string{}}
+	err = lock.update([]*ServiceUpdate{{
+		ServiceID: service,
+		Updates: []flux.ContainerUpdate{{
+			Container: "greeter",
+			Target:    target,
+		}},
+	}}, func(id flux.ImageID) (string, error) {
+		return "sha256:abc", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "quay.io/weaveworks/helloworld:master-a000002@sha256:abc"
+	if got := lock.Services[service]["greeter"]; got != expected {
+		t.Fatalf("expected %q in lockfile, got %q", expected, got)
+	}
+
+	// The definition still has the old image, so it doesn't match
+	problems := lock.problems([]*ServiceUpdate{{
+		ServiceID:     service,
+		ManifestBytes: []byte(lockfileDeployment),
+	}})
+	if len(problems) != 1 {
+		t.Fatalf("expected one problem, got %v", problems)
+	}
+
+	lock.Services[service]["greeter"] = "quay.io/weaveworks/helloworld:master-a000001@sha256:def"
+	problems = lock.problems([]*ServiceUpdate{{
+		ServiceID:     service,
+		ManifestBytes: []byte(lockfileDeployment),
+	}})
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
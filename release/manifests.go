@@ -0,0 +1,136 @@
+package release
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/layout"
+)
+
+// Finding which files define each service means parsing every file
+// in the config repo, which is slow for big repos, and done for each
+// release, automated release and look at a service's history -- often
+// for the same commit. Since a git tree's hash changes with what's in
+// it, what was found for one clone can be used for another of the same
+// tree; and, since the hash says nothing about where the repo's from,
+// for another instance's clone too.
+
+// DefaultManifestCacheSize is how many trees the default cache
+// remembers.
+const DefaultManifestCacheSize = 256
+
+// DefaultManifestCache is the cache used by release contexts, unless
+// given another.
+var DefaultManifestCache = NewManifestCache(DefaultManifestCacheSize)
+
+// ManifestCache remembers which files define each service, for the
+// trees (and layouts) most recently used.
+type ManifestCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[manifestKey]*list.Element
+	recent  *list.List // of *manifestEntry, most recently used first
+}
+
+type manifestKey struct {
+	layout string
+	tree   string
+}
+
+type manifestEntry struct {
+	key      manifestKey
+	services map[flux.ServiceID][]string // relative to the config path
+}
+
+// NewManifestCache makes a cache that remembers up to size trees.
+func NewManifestCache(size int) *ManifestCache {
+	return &ManifestCache{
+		size:    size,
+		entries: map[manifestKey]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// Manifests gives the files under dir, the config path in a clone of
+// the tree given, that define each service according to the layout
+// named. If the tree has been seen before, the files are those found
+// then; otherwise they're found, and remembered for next time. A blank
+// tree (e.g., because the clone has changes) is never remembered.
+func (c *ManifestCache) Manifests(layoutName string, repoLayout layout.RepoLayout, tree, dir string) (map[flux.ServiceID][]string, error) {
+	if c == nil || tree == "" {
+		return repoLayout.Manifests(dir)
+	}
+	key := manifestKey{layoutName, tree}
+	if relative, ok := c.get(key); ok {
+		manifestCacheRequests.With(LabelManifestCacheResult, ManifestCacheHit).Add(1)
+		return resolvePaths(dir, relative), nil
+	}
+	manifestCacheRequests.With(LabelManifestCacheResult, ManifestCacheMiss).Add(1)
+
+	services, err := repoLayout.Manifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	relative := map[flux.ServiceID][]string{}
+	for id, paths := range services {
+		for _, path := range paths {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				// Not under the directory, so it can't be
+				// found in another clone; don't remember any.
+				return services, nil
+			}
+			relative[id] = append(relative[id], rel)
+		}
+	}
+	c.put(key, relative)
+	return services, nil
+}
+
+// Len gives the number of trees remembered.
+func (c *ManifestCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+func (c *ManifestCache) get(key manifestKey) (map[flux.ServiceID][]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.recent.MoveToFront(e)
+	return e.Value.(*manifestEntry).services, true
+}
+
+func (c *ManifestCache) put(key manifestKey, services map[flux.ServiceID][]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.recent.PushFront(&manifestEntry{key, services})
+	for c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*manifestEntry).key)
+	}
+}
+
+// resolvePaths gives the paths remembered as they are under dir. It
+// makes a new map, so that callers can't change what's remembered.
+func resolvePaths(dir string, relative map[flux.ServiceID][]string) map[flux.ServiceID][]string {
+	services := make(map[flux.ServiceID][]string, len(relative))
+	for id, paths := range relative {
+		for _, path := range paths {
+			services[id] = append(services[id], filepath.Join(dir, path))
+		}
+	}
+	return services
+}
@@ -0,0 +1,82 @@
+package release
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/layout"
+)
+
+// countingLayout finds the same file for a service under any
+// directory, and counts how many times it's asked.
+type countingLayout struct {
+	layout.RepoLayout
+	calls int
+}
+
+func (l *countingLayout) Manifests(dir string) (map[flux.ServiceID][]string, error) {
+	l.calls++
+	return map[flux.ServiceID][]string{
+		"default/helloworld": {filepath.Join(dir, "default", "helloworld-dep.yaml")},
+	}, nil
+}
+
+func TestManifestCache(t *testing.T) {
+	cache := NewManifestCache(2)
+	repoLayout := &countingLayout{}
+
+	// Another clone of a tree seen gets the files found for the first,
+	// under its own directory
+	if _, err := cache.Manifests("", repoLayout, "tree1", "/clone1"); err != nil {
+		t.Fatal(err)
+	}
+	services, err := cache.Manifests("", repoLayout, "tree1", "/clone2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoLayout.calls != 1 {
+		t.Errorf("expected the files to be found once for a tree, but they were found %d times", repoLayout.calls)
+	}
+	expected := map[flux.ServiceID][]string{
+		"default/helloworld": {filepath.Join("/clone2", "default", "helloworld-dep.yaml")},
+	}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+
+	// A clone with changes (so no tree) is always looked at, as is
+	// another layout
+	for i := 0; i < 2; i++ {
+		cache.Manifests("", repoLayout, "", "/clone3")
+	}
+	cache.Manifests("flat", repoLayout, "tree1", "/clone1")
+	if repoLayout.calls != 4 {
+		t.Errorf("expected 4 lookups, got %d", repoLayout.calls)
+	}
+
+	// The least recently used tree is forgotten to make room
+	cache.Manifests("", repoLayout, "tree1", "/clone1")
+	cache.Manifests("", repoLayout, "tree2", "/clone1")
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 trees remembered, got %d", cache.Len())
+	}
+	calls := repoLayout.calls
+	cache.Manifests("", repoLayout, "tree1", "/clone1")
+	if repoLayout.calls != calls {
+		t.Error("expected the recently used tree to be remembered")
+	}
+	cache.Manifests("flat", repoLayout, "tree1", "/clone1")
+	if repoLayout.calls != calls+1 {
+		t.Error("expected the least recently used tree to be forgotten")
+	}
+
+	// Without a cache, the files are always looked at
+	var none *ManifestCache
+	calls = repoLayout.calls
+	none.Manifests("", repoLayout, "tree1", "/clone1")
+	if repoLayout.calls != calls+1 {
+		t.Error("expected a nil cache to look at the files")
+	}
+}
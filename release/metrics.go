@@ -1,10 +1,21 @@
 package release
 
 import (
+	"context"
+
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/prometheus"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
 	fluxmetrics "github.com/weaveworks/flux/metrics"
+	"github.com/weaveworks/flux/tracing"
+)
+
+const (
+	LabelManifestCacheResult = "result"
+
+	ManifestCacheHit  = "hit"
+	ManifestCacheMiss = "miss"
 )
 
 var (
@@ -22,8 +33,30 @@ var (
 		Help:      "Duration in seconds of each stage of a release, including dry-runs.",
 		Buckets:   stdprometheus.DefBuckets,
 	}, []string{fluxmetrics.LabelStage})
+	manifestCacheRequests = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "fluxsvc",
+		Name:      "manifest_cache_requests_total",
+		Help:      "Count of lookups of the files defining each service, by whether they'd already been found for the tree.",
+	}, []string{LabelManifestCacheResult})
 )
 
-func NewStageTimer(stage string) *metrics.Timer {
-	return metrics.NewTimer(stageDuration.With(fluxmetrics.LabelStage, stage))
+// StageTimer times a stage of a release, both as a metric and as a
+// span in the release's trace.
+type StageTimer struct {
+	*tracing.Span
+	timer *metrics.Timer
+}
+
+func NewStageTimer(ctx context.Context, stage string) *StageTimer {
+	span, _ := tracing.StartSpan(ctx, "release."+stage)
+	return &StageTimer{
+		Span:  span,
+		timer: metrics.NewTimer(stageDuration.With(fluxmetrics.LabelStage, stage)),
+	}
+}
+
+func (t *StageTimer) ObserveDuration() {
+	t.timer.ObserveDuration()
+	t.Span.Finish()
 }
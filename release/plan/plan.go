@@ -0,0 +1,209 @@
+// Package plan calculates releases: which services a release applies
+// to, which of their containers are to be updated and to what, and
+// how their resource definitions change as a result.
+//
+// It works only from what it's given -- the services defined and
+// running, the images available, and a way of editing a definition --
+// so it needs no daemon, no clone of a config repo, and no particular
+// platform. The releaser in the package above uses it; so can any
+// other tool that wants to know what a release would do.
+package plan
+
+import (
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform"
+)
+
+// Reasons a service is left out of a release, as given in its result.
+const (
+	NotInCluster   = "not running in cluster"
+	ImageNotFound  = "cannot find one or more images"
+	ImageUpToDate  = "image(s) up to date"
+	SameContent    = "same content as running image(s)"
+	NoArchitecture = "image has no build for the cluster's architecture"
+	NotUsingImage  = "does not use image(s)"
+)
+
+// Logf is given a message for each decision made; it can be nil.
+type Logf func(format string, args ...interface{})
+
+func (f Logf) log(format string, args ...interface{}) {
+	if f != nil {
+		f(format, args...)
+	}
+}
+
+// A Filter says whether a service is to be released. A result with
+// no error means it is; otherwise, the result is recorded as the
+// reason it isn't.
+type Filter func(id flux.ServiceID, service platform.Service) flux.ServiceResult
+
+// Select matches the services defined (e.g., in a config repo) with
+// those running, and returns the running services the filter lets
+// through, in the order given. Each service filtered out is given a
+// result saying why; services defined but not running are skipped,
+// unless the filter would have ignored them anyway. Running services
+// that aren't defined aren't considered at all.
+func Select(defined []flux.ServiceID, running []platform.Service, filter Filter, results flux.ReleaseResult, logf Logf) []platform.Service {
+	notRunning := map[flux.ServiceID]bool{}
+	for _, id := range defined {
+		notRunning[id] = true
+	}
+
+	var selected []platform.Service
+	for _, s := range running {
+		if !notRunning[s.ID] {
+			continue
+		}
+		delete(notRunning, s.ID)
+		logf.log("Found service %s", s.ID)
+		fr := filter(s.ID, s)
+		if fr.Error != "" {
+			logf.log(fr.Msg(s.ID))
+		}
+		results[s.ID] = fr
+		if fr.Status == flux.ReleaseStatusPending || fr.Status == flux.ReleaseStatusSuccess || fr.Status == "" {
+			selected = append(selected, s)
+		}
+	}
+
+	for _, id := range defined {
+		if !notRunning[id] {
+			continue
+		}
+		fr := filter(id, platform.Service{ID: id})
+		if fr.Error != "" {
+			logf.log(fr.Msg(id))
+		}
+		if fr.Status == flux.ReleaseStatusIgnored {
+			results[id] = fr
+			continue
+		}
+		logf.log("Skipping service %s as it is not in the running system", id)
+		results[id] = flux.ServiceResult{
+			Status: flux.ReleaseStatusSkipped,
+			Error:  NotInCluster,
+		}
+	}
+	return selected
+}
+
+// Images gives the image to release for each image repository, or
+// nil if there isn't one. instance.ImageMap is an example.
+type Images interface {
+	LatestImage(repo string) *flux.ImageDescription
+}
+
+// An Editor changes a resource definition to use the image given,
+// e.g., kubernetes.UpdatePodController.
+type Editor func(def []byte, image flux.ImageID) ([]byte, error)
+
+// Checks can keep a container from being updated to an image that is
+// otherwise newer. Either check can be nil, meaning it always passes.
+type Checks struct {
+	// SameContent says whether the images are known to have the same
+	// content, in which case there's no reason to release.
+	SameContent func(current, target flux.ImageID) bool
+	// LacksArchitecture says whether the image is multi-arch, but
+	// has no build for Architecture.
+	LacksArchitecture func(image flux.ImageID) bool
+	Architecture      string
+}
+
+// ImageUpdates works out which of the service's containers are to be
+// updated to the images given, and edits the service's resource
+// definition, def, to match. It returns the edited definition, and the
+// service's result: pending, with the updates per container, if
+// there are any; otherwise failed, skipped or ignored, and why. It
+// returns an error only if an image or the definition can't be made
+// sense of.
+func ImageUpdates(service platform.Service, def []byte, images Images, checks Checks, edit Editor, logf Logf) ([]byte, flux.ServiceResult, error) {
+	containers, err := service.ContainersOrError()
+	if err != nil {
+		logf.log("Failing service %s: %s", service.ID, err.Error())
+		return def, flux.ServiceResult{
+			Status: flux.ReleaseStatusFailed,
+			Error:  err.Error(),
+		}, nil
+	}
+
+	// If at least one container used an image in question, we say
+	// we're skipping it rather than ignoring it. This is mainly
+	// for the purpose of filtering the output.
+	ignoredOrSkipped := flux.ReleaseStatusIgnored
+	skippedReason := ImageUpToDate
+	var containerUpdates []flux.ContainerUpdate
+
+	for _, container := range containers {
+		currentImageID, err := flux.ParseImageID(container.Image)
+		if err != nil {
+			// We may hope never to find a malformed image ID, but
+			// anything is possible.
+			return nil, flux.ServiceResult{}, err
+		}
+
+		latestImage := images.LatestImage(currentImageID.Repository())
+		if latestImage == nil {
+			ignoredOrSkipped = flux.ReleaseStatusUnknown
+			continue
+		}
+
+		if currentImageID == latestImage.ID {
+			ignoredOrSkipped = flux.ReleaseStatusSkipped
+			continue
+		}
+
+		if checks.SameContent != nil && checks.SameContent(currentImageID, latestImage.ID) {
+			logf.log("Not updating %s container %s: %s has the same content as %s", service.ID, container.Name, latestImage.ID.Tag, currentImageID)
+			ignoredOrSkipped = flux.ReleaseStatusSkipped
+			skippedReason = SameContent
+			continue
+		}
+
+		if checks.LacksArchitecture != nil && checks.LacksArchitecture(latestImage.ID) {
+			logf.log("Not updating %s container %s: %s has no image for %s", service.ID, container.Name, latestImage.ID, checks.Architecture)
+			ignoredOrSkipped = flux.ReleaseStatusSkipped
+			skippedReason = NoArchitecture
+			continue
+		}
+
+		def, err = edit(def, latestImage.ID)
+		if err != nil {
+			logf.log("Failed on service %s: %s", service.ID, err.Error())
+			return nil, flux.ServiceResult{}, err
+		}
+
+		logf.log("Will update %s container %s: %s -> %s", service.ID, container.Name, currentImageID, latestImage.ID.Tag)
+		containerUpdates = append(containerUpdates, flux.ContainerUpdate{
+			Container: container.Name,
+			Current:   currentImageID,
+			Target:    latestImage.ID,
+		})
+	}
+
+	switch {
+	case len(containerUpdates) > 0:
+		return def, flux.ServiceResult{
+			Status:       flux.ReleaseStatusPending,
+			PerContainer: containerUpdates,
+		}, nil
+	case ignoredOrSkipped == flux.ReleaseStatusSkipped:
+		logf.log("Skipping service %s, %s", service.ID, skippedReason)
+		return def, flux.ServiceResult{
+			Status: flux.ReleaseStatusSkipped,
+			Error:  skippedReason,
+		}, nil
+	case ignoredOrSkipped == flux.ReleaseStatusUnknown:
+		logf.log("Ignoring service %s, cannot find image(s)", service.ID)
+		return def, flux.ServiceResult{
+			Status: flux.ReleaseStatusSkipped,
+			Error:  ImageNotFound,
+		}, nil
+	default:
+		logf.log("Ignoring service %s, does not use image(s) in question", service.ID)
+		return def, flux.ServiceResult{
+			Status: flux.ReleaseStatusIgnored,
+			Error:  NotUsingImage,
+		}, nil
+	}
+}
@@ -0,0 +1,149 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform"
+)
+
+func mustParseImageID(t *testing.T, s string) flux.ImageID {
+	id, err := flux.ParseImageID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func service(id, image string) platform.Service {
+	return platform.Service{
+		ID: flux.ServiceID(id),
+		Containers: platform.ContainersOrExcuse{
+			Containers: []platform.Container{{Name: "app", Image: image}},
+		},
+	}
+}
+
+func TestSelect(t *testing.T) {
+	defined := []flux.ServiceID{"default/a", "default/b", "default/c", "default/d"}
+	running := []platform.Service{
+		service("default/a", "quay.io/weaveworks/a:1"),
+		service("default/b", "quay.io/weaveworks/b:1"),
+		service("default/x", "quay.io/weaveworks/x:1"),
+	}
+	filter := func(id flux.ServiceID, _ platform.Service) flux.ServiceResult {
+		switch id {
+		case "default/b":
+			return flux.ServiceResult{Status: flux.ReleaseStatusSkipped, Error: "locked"}
+		case "default/d":
+			return flux.ServiceResult{Status: flux.ReleaseStatusIgnored, Error: "excluded"}
+		}
+		return flux.ServiceResult{}
+	}
+
+	results := flux.ReleaseResult{}
+	selected := Select(defined, running, filter, results, nil)
+	if len(selected) != 1 || selected[0].ID != "default/a" {
+		t.Fatalf("expected only default/a to be selected, got %+v", selected)
+	}
+	expected := flux.ReleaseResult{
+		"default/a": flux.ServiceResult{},
+		"default/b": flux.ServiceResult{Status: flux.ReleaseStatusSkipped, Error: "locked"},
+		"default/c": flux.ServiceResult{Status: flux.ReleaseStatusSkipped, Error: NotInCluster},
+		"default/d": flux.ServiceResult{Status: flux.ReleaseStatusIgnored, Error: "excluded"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected results %+v, got %+v", expected, results)
+	}
+}
+
+type images map[string]flux.ImageID
+
+func (m images) LatestImage(repo string) *flux.ImageDescription {
+	if id, ok := m[repo]; ok {
+		return &flux.ImageDescription{ID: id}
+	}
+	return nil
+}
+
+// edit records the image in the definition, so it can be seen to
+// have been edited.
+func edit(def []byte, image flux.ImageID) ([]byte, error) {
+	return append(def, []byte(image.String()+"\n")...), nil
+}
+
+func TestImageUpdates(t *testing.T) {
+	current := "quay.io/weaveworks/helloworld:master-a000001"
+	next := mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000002")
+	available := images{next.Repository(): next}
+	svc := service("default/helloworld", current)
+
+	def, result, err := ImageUpdates(svc, []byte("def\n"), available, Checks{}, edit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != flux.ReleaseStatusPending {
+		t.Fatalf("expected pending, got %+v", result)
+	}
+	expected := []flux.ContainerUpdate{{
+		Container: "app",
+		Current:   mustParseImageID(t, current),
+		Target:    next,
+	}}
+	if !reflect.DeepEqual(result.PerContainer, expected) {
+		t.Errorf("expected updates %+v, got %+v", expected, result.PerContainer)
+	}
+	if !bytes.Equal(def, []byte("def\n"+next.String()+"\n")) {
+		t.Errorf("expected the definition to be edited, got %q", def)
+	}
+
+	for _, c := range []struct {
+		name    string
+		service platform.Service
+		images  images
+		checks  Checks
+		status  flux.ServiceReleaseStatus
+		reason  string
+	}{
+		{"up to date", service("default/helloworld", next.String()), available, Checks{}, flux.ReleaseStatusSkipped, ImageUpToDate},
+		{"not found", svc, images{}, Checks{}, flux.ReleaseStatusSkipped, ImageNotFound},
+		{"no images", platform.Service{ID: "default/helloworld"}, available, Checks{}, flux.ReleaseStatusIgnored, NotUsingImage},
+		{"same content", svc, available, Checks{
+			SameContent: func(_, _ flux.ImageID) bool { return true },
+		}, flux.ReleaseStatusSkipped, SameContent},
+		{"no architecture", svc, available, Checks{
+			LacksArchitecture: func(flux.ImageID) bool { return true },
+			Architecture:      "arm",
+		}, flux.ReleaseStatusSkipped, NoArchitecture},
+		{"no containers", platform.Service{
+			ID:         "default/helloworld",
+			Containers: platform.ContainersOrExcuse{Excuse: "no pod controller"},
+		}, available, Checks{}, flux.ReleaseStatusFailed, "no pod controller"},
+	} {
+		def, result, err := ImageUpdates(c.service, []byte("def\n"), c.images, c.checks, edit, nil)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if result.Status != c.status || result.Error != c.reason {
+			t.Errorf("%s: expected %s (%s), got %+v", c.name, c.status, c.reason, result)
+		}
+		if string(def) != "def\n" {
+			t.Errorf("%s: expected the definition to be left alone, got %q", c.name, def)
+		}
+	}
+}
+
+func TestImageUpdatesEditError(t *testing.T) {
+	next := mustParseImageID(t, "quay.io/weaveworks/helloworld:master-a000002")
+	svc := service("default/helloworld", "quay.io/weaveworks/helloworld:master-a000001")
+	failing := func([]byte, flux.ImageID) ([]byte, error) {
+		return nil, errors.New("no such container")
+	}
+	if _, _, err := ImageUpdates(svc, nil, images{next.Repository(): next}, Checks{}, failing, nil); err == nil {
+		t.Error("expected an error from failing to edit the definition")
+	}
+}
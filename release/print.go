@@ -27,6 +27,9 @@ func PrintResults(out io.Writer, results flux.ReleaseResult, verbose bool) {
 		for _, update := range result.PerContainer {
 			extraLines = append(extraLines, fmt.Sprintf("%s: %s -> %s", update.Container, update.Current.FullID(), update.Target.Tag))
 		}
+		for _, value := range result.Values {
+			extraLines = append(extraLines, fmt.Sprintf("%s %s", value.Kind, value))
+		}
 
 		var inline string
 		if len(extraLines) > 0 {
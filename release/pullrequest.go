@@ -0,0 +1,133 @@
+package release
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/integrations/github"
+	"github.com/weaveworks/flux/integrations/gitlab"
+)
+
+// pullRequestBranch is the branch to which the changes made by a
+// release are pushed, when they're proposed in a pull request.
+func pullRequestBranch(id flux.ReleaseID) string {
+	return "flux-release-" + string(id)
+}
+
+// refusedBranch is the branch to which the changes made by an
+// automated release are pushed, when the config repo's branch refuses
+// them. It's named for the image released, so that the update is
+// proposed once, however many times automation tries it.
+func refusedBranch(spec *flux.ReleaseSpec) string {
+	return "flux-automated-" + strings.NewReplacer(":", "-", "@", "-").Replace(string(spec.ImageSpec))
+}
+
+// ProposeRefused opens a pull request with the changes made by an
+// automated release whose push to the branch was refused, if the
+// config says to, so that the update isn't lost; it returns the branch
+// pushed to, and the pull request's URL. Otherwise it returns an
+// error: pushErr, or, if the changes were proposed already, pushErr
+// saying where.
+func (rc *ReleaseContext) ProposeRefused(pushErr error, spec *flux.ReleaseSpec, cause flux.ReleaseCause, id flux.ReleaseID) (branch, pullRequest string, err error) {
+	if cause.User != flux.UserAutomated || git.KindOf(pushErr) != git.PushRejected {
+		return "", "", pushErr
+	}
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return "", "", err
+	}
+	if c := conf.Settings.Git.PullRequest; c.Provider == "" || !c.OnlyRefused {
+		return "", "", pushErr
+	}
+
+	branch = refusedBranch(spec)
+	switch err := rc.Instance.ConfigRepo().PushToBranch(rc.WorkingDir, branch); {
+	case err == git.ErrBranchExisted:
+		return "", "", errors.Wrapf(pushErr, "changes already proposed from branch %q", branch)
+	case err != nil:
+		return "", "", errors.Wrapf(err, "pushing to branch %q", branch)
+	}
+	body := fmt.Sprintf("Opened by flux for automated release %s, since the branch refused the change:\n\n```\n%s\n```\n", id, strings.TrimSpace(pushErr.Error()))
+	pullRequest, err = openPullRequest(conf.Settings.Git, branch, commitMessageFromReleaseSpec(spec), body)
+	return branch, pullRequest, errors.Wrap(err, "opening pull request")
+}
+
+// openPullRequest opens a pull request (or merge request, on GitLab)
+// to merge branch into the config repo's branch, with the provider
+// given in the config, and returns its URL.
+func openPullRequest(conf flux.GitConfig, branch, title, body string) (string, error) {
+	host, path, err := splitRepoURL(conf.URL)
+	if err != nil {
+		return "", err
+	}
+	token := conf.PullRequest.Token
+	if token == "" {
+		token = conf.Token
+	}
+	if token == "" {
+		return "", errors.New("no token given for opening pull requests")
+	}
+	base := conf.Branch
+	if base == "" {
+		base = "master"
+	}
+
+	switch conf.PullRequest.Provider {
+	case flux.PullRequestGithub:
+		parts := strings.Split(path, "/")
+		if len(parts) != 2 {
+			return "", fmt.Errorf("expected a GitHub repository of the form owner/repo, got %q", path)
+		}
+		client := github.NewGithubClient(token)
+		if host != "github.com" {
+			if client, err = github.NewGithubEnterpriseClient(token, "https://"+host+"/api/v3/"); err != nil {
+				return "", err
+			}
+		}
+		return client.OpenPullRequest(parts[0], parts[1], branch, base, title, body)
+	case flux.PullRequestGitlab:
+		return gitlab.NewGitlabClient(token, "https://"+host).OpenMergeRequest(path, branch, base, title, body)
+	default:
+		return "", fmt.Errorf("unknown pull request provider %q; expected %q or %q", conf.PullRequest.Provider, flux.PullRequestGithub, flux.PullRequestGitlab)
+	}
+}
+
+// splitRepoURL gives the host and the path (without any .git suffix)
+// of a git URL, either a URL proper (e.g.,
+// "https://github.com/myorg/conf.git") or scp-like (e.g.,
+// "git@github.com:myorg/conf").
+func splitRepoURL(repoURL string) (host, path string, err error) {
+	if strings.Contains(repoURL, "://") {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", "", err
+		}
+		host, path = u.Host, u.Path
+		// A port for SSH has nothing to do with the API
+		if u.Scheme == "ssh" {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+		}
+	} else {
+		i := strings.Index(repoURL, ":")
+		if i < 0 {
+			return "", "", fmt.Errorf("cannot find the host in git URL %q", repoURL)
+		}
+		host, path = repoURL[:i], repoURL[i+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return "", "", fmt.Errorf("cannot find the host and path in git URL %q", repoURL)
+	}
+	return host, path, nil
+}
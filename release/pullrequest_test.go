@@ -0,0 +1,105 @@
+package release
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
+)
+
+func TestSplitRepoURL(t *testing.T) {
+	for _, c := range []struct {
+		url, host, path string
+	}{
+		{"git@github.com:myorg/conf", "github.com", "myorg/conf"},
+		{"git@github.com:myorg/conf.git", "github.com", "myorg/conf"},
+		{"https://github.com/myorg/conf.git", "github.com", "myorg/conf"},
+		{"ssh://git@gitlab.example.com:2222/group/sub/conf.git", "gitlab.example.com", "group/sub/conf"},
+		{"https://gitlab.example.com:8443/group/conf", "gitlab.example.com:8443", "group/conf"},
+	} {
+		host, path, err := splitRepoURL(c.url)
+		if err != nil {
+			t.Errorf("%s: %v", c.url, err)
+			continue
+		}
+		if host != c.host || path != c.path {
+			t.Errorf("%s: expected %q and %q, got %q and %q", c.url, c.host, c.path, host, path)
+		}
+	}
+
+	for _, url := range []string{"", "conf", "https://github.com/"} {
+		if _, _, err := splitRepoURL(url); err == nil {
+			t.Errorf("expected an error for %q", url)
+		}
+	}
+}
+
+func TestProposeRefused(t *testing.T) {
+	repo, cleanup := setupRepo(t)
+	defer cleanup()
+	// Pushes to master are refused, as for a protected branch
+	refuse := `#!/bin/sh
+while read old new ref; do
+  if [ "$ref" = refs/heads/master ]; then
+    exit 1
+  fi
+done
+`
+	if err := ioutil.WriteFile(filepath.Join(repo.URL, "hooks", "pre-receive"), []byte(refuse), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	config := instance.Config{}
+	config.Settings.Git.PullRequest = flux.PullRequestConfig{Provider: flux.PullRequestGithub, OnlyRefused: true}
+	inst := &instance.Instance{Repo: repo, Config: &instance.MockConfigurer{Config: config}}
+	rc := NewReleaseContext(inst)
+	defer rc.Clean()
+	if err := rc.CloneRepo(); err != nil {
+		t.Fatal(err)
+	}
+	for name := range testfiles.Files {
+		if err := os.Remove(filepath.Join(rc.WorkingDir, name)); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	pushErr := rc.CommitAndPush("Removed file")
+	if git.KindOf(pushErr) != git.PushRejected {
+		t.Fatalf("expected the push to be refused, got %v", pushErr)
+	}
+
+	spec := &flux.ReleaseSpec{ImageSpec: "quay.io/weaveworks/helloworld:master-a000002"}
+	automated := flux.ReleaseCause{User: flux.UserAutomated}
+
+	// Only automated releases are proposed
+	if _, _, err := rc.ProposeRefused(pushErr, spec, flux.ReleaseCause{User: "alice"}, "1"); err != pushErr {
+		t.Errorf("expected the push error for a release by a user, got %v", err)
+	}
+	other := errors.New("something else")
+	if _, _, err := rc.ProposeRefused(other, spec, automated, "1"); err != other {
+		t.Errorf("expected an error other than a refusal as it is, got %v", err)
+	}
+
+	// The branch is pushed; the pull request can't be opened, since
+	// the repo isn't on a git host
+	_, _, err := rc.ProposeRefused(pushErr, spec, automated, "1")
+	if err == nil || !strings.Contains(err.Error(), "opening pull request") {
+		t.Errorf("expected an error opening the pull request, got %v", err)
+	}
+	// Once proposed, the same update isn't proposed again
+	_, _, err = rc.ProposeRefused(pushErr, spec, automated, "2")
+	if err == nil || !strings.Contains(err.Error(), "already proposed") {
+		t.Errorf("expected the changes to have been proposed already, got %v", err)
+	}
+	assertBranch := "flux-automated-quay.io/weaveworks/helloworld-master-a000002"
+	if branch := refusedBranch(spec); branch != assertBranch {
+		t.Errorf("expected branch %q, got %q", assertBranch, branch)
+	}
+}
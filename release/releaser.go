@@ -1,36 +1,45 @@
 package release
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/metrics"
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/instance"
 	"github.com/weaveworks/flux/jobs"
 	fluxmetrics "github.com/weaveworks/flux/metrics"
 	"github.com/weaveworks/flux/notifications"
 	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/platform/kubernetes"
+	"github.com/weaveworks/flux/release/plan"
+	"github.com/weaveworks/flux/tracing"
 )
 
 const FluxServiceName = "fluxsvc"
 const FluxDaemonName = "fluxd"
 
 type Releaser struct {
-	instancer instance.Instancer
+	instancer    instance.Instancer
+	lintWarnings bool
 }
 
+// NewReleaser constructs a Releaser. If lintWarnings is true, each
+// release lints the config repo and records a warning event if there
+// are problems.
 func NewReleaser(
 	instancer instance.Instancer,
+	lintWarnings bool,
 ) *Releaser {
 	return &Releaser{
-		instancer: instancer,
+		instancer:    instancer,
+		lintWarnings: lintWarnings,
 	}
 }
 
@@ -40,6 +49,8 @@ type ServiceUpdate struct {
 	ManifestPath  string
 	ManifestBytes []byte
 	Updates       []flux.ContainerUpdate
+	Values        []flux.ValueUpdate
+	ConfigMaps    []*ConfigMapUpdate // applied before the service
 }
 
 // These represent the side-effects that calculating and applying the
@@ -53,6 +64,7 @@ func (r *Releaser) Handle(job *jobs.Job, updater jobs.JobUpdater) ([]jobs.Job, e
 		status := fmt.Sprintf(format, args...)
 		job.Status = status
 		job.Log = append(job.Log, status)
+		job.Output = append(job.Output, status)
 		updater.UpdateJob(*job)
 	}
 	updateResult := func(result flux.ReleaseResult) {
@@ -60,6 +72,10 @@ func (r *Releaser) Handle(job *jobs.Job, updater jobs.JobUpdater) ([]jobs.Job, e
 		updater.UpdateJob(*job)
 	}
 
+	if job.Method == jobs.BlueGreenJob {
+		return r.blueGreen(job, logStatus, updateResult)
+	}
+
 	// The job gets handed down through methods just so it can be used
 	// to construct a Release for the (possible) notification, which
 	// is a bit awkward; but we can factor it out once we have a less
@@ -70,6 +86,21 @@ func (r *Releaser) Handle(job *jobs.Job, updater jobs.JobUpdater) ([]jobs.Job, e
 
 func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus statusFn, report resultFn) (_ []jobs.Job, err error) {
 	spec := job.Params.(jobs.ReleaseJobParams).Spec()
+
+	// The release is part of the trace of the request that asked for
+	// it, if there was one.
+	ctx := context.Background()
+	if trace := job.Params.(jobs.ReleaseJobParams).Trace; trace != nil {
+		ctx = tracing.ContextWithSpan(ctx, *trace)
+	}
+	span, ctx := tracing.StartSpan(ctx, "release")
+	span.SetTag("instance", instanceID)
+	span.SetTag("release_id", job.ID)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+
 	defer func(started time.Time) {
 		releaseDuration.With(
 			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
@@ -78,6 +109,12 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 		).Observe(time.Since(started).Seconds())
 	}(time.Now())
 
+	// Detail for debugging goes in the job's output, which is saved
+	// along with the next status.
+	logOutput := func(format string, args ...interface{}) {
+		job.Output = append(job.Output, "  "+fmt.Sprintf(format, args...))
+	}
+
 	logStatus("Calculating updates for release.")
 	inst, err := r.instancer.Get(instanceID)
 	if err != nil {
@@ -85,26 +122,67 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 	}
 
 	inst.Logger = log.NewContext(inst.Logger).With("release-id", string(job.ID))
+	inst.Platform = platform.WithTrace(ctx, inst.Platform)
+
+	// Automated releases queued before automation was paused don't go
+	// ahead while it's paused.
+	if job.Params.(jobs.ReleaseJobParams).Cause.User == flux.UserAutomated {
+		config, err := inst.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		if config.Paused != nil {
+			logStatus("Automation is paused; not releasing.")
+			return nil, nil
+		}
+	}
 
 	// We time each stage of this process, and expose as metrics.
-	var timer *metrics.Timer
+	var timer *StageTimer
 
 	// Preparation: we always need the repository
 	rc := NewReleaseContext(inst)
 	defer rc.Clean()
 	logStatus("Cloning git repository.")
-	timer = NewStageTimer("clone_repository")
-	if err = rc.CloneRepo(); err != nil {
-		return nil, err
+	timer = NewStageTimer(ctx, "clone_repository")
+	timer.SetTag("repo", inst.ConfigRepo().URL)
+	timer.SetTag("branch", inst.ConfigRepo().Branch)
+	// A timeout is often a passing problem, so is worth another go;
+	// other problems (e.g., with the key) won't go away by themselves.
+	if err = rc.CloneRepo(); git.KindOf(err) == git.NetworkTimeout {
+		logStatus("Timed out cloning git repository; trying again.")
+		err = rc.CloneRepo()
 	}
+	timer.SetError(err)
 	timer.ObserveDuration()
+	if err != nil {
+		return nil, err
+	}
+	repo := inst.ConfigRepo()
+	if rev, err := rc.HeadRevision(); err == nil {
+		logOutput("git clone %s (branch %q): at revision %s", repo.URL, repo.Branch, rev)
+	}
+	if err = rc.SyncRepoConfig(); err != nil {
+		return nil, errors.Wrap(err, "reading config from the repo")
+	}
+
+	if r.lintWarnings {
+		logStatus("Linting resource definitions.")
+		timer = NewStageTimer(ctx, "lint")
+		// Lint problems are there to be fixed by people; they don't
+		// stop the release.
+		if err := logLintEvent(rc); err != nil {
+			inst.Log("err", errors.Wrap(err, "linting resource definitions"))
+		}
+		timer.ObserveDuration()
+	}
 
 	// From here in, we collect the results of the calculations.
 	results := flux.ReleaseResult{}
 
 	// Figure out the services involved.
 	logStatus("Finding defined services.")
-	timer = NewStageTimer("select_services")
+	timer = NewStageTimer(ctx, "select_services")
 	var updates []*ServiceUpdate
 	updates, err = selectServices(rc, &spec, results, logStatus)
 	timer.ObserveDuration()
@@ -113,6 +191,16 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 	}
 	logStatus("Found %d services.", len(updates))
 
+	// Changes made to the definitions outside of flux will show up
+	// as a mismatch with the lockfile, if there is one.
+	problems, err := rc.VerifyLockfile(updates)
+	if err != nil {
+		logStatus("Warning: could not verify %s: %s", LockfileName, err)
+	}
+	for _, problem := range problems {
+		logStatus("Warning: %s", problem)
+	}
+
 	// If the request was for a specific service and the service was
 	// not in the cluster, then add a result with a skipped status
 	for _, v := range spec.ServiceSpecs {
@@ -133,11 +221,14 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 	report(results)
 
 	// Look up images, and calculate updates, if we've been asked to
+	candidates := updates
 	if spec.ImageSpec != flux.ImageSpecNone {
 		logStatus("Looking up images.")
-		timer = NewStageTimer("lookup_images")
+		timer = NewStageTimer(ctx, "lookup_images")
+		timer.SetTag("services", len(updates))
 		// Figure out how the services are to be updated.
-		updates, err = calculateImageUpdates(rc.Instance, updates, &spec, results, logStatus)
+		updates, err = calculateImageUpdates(rc.Instance, updates, &spec, job.Params.(jobs.ReleaseJobParams).Cause, results, logStatus)
+		timer.SetError(err)
 		timer.ObserveDuration()
 		if err != nil {
 			return nil, err
@@ -145,6 +236,37 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 		report(results)
 	}
 
+	// Change other values, if we've been asked to
+	if len(spec.ValueUpdates) > 0 {
+		logStatus("Updating values.")
+		timer = NewStageTimer(ctx, "update_values")
+		var imageUpdates []*ServiceUpdate
+		if spec.ImageSpec != flux.ImageSpecNone {
+			imageUpdates = updates
+		}
+		updates, err = calculateValueUpdates(rc, candidates, imageUpdates, &spec, results, logStatus)
+		timer.ObserveDuration()
+		if err != nil {
+			return nil, err
+		}
+		report(results)
+	}
+
+	// An atomic release updates all the services it targets, or none
+	// of them.
+	if spec.Kind == flux.ReleaseKindAtomic {
+		if blocked := atomicBlockers(results); len(blocked) > 0 {
+			for _, update := range updates {
+				results[update.ServiceID] = flux.ServiceResult{
+					Status: flux.ReleaseStatusSkipped,
+					Error:  Aborted,
+				}
+			}
+			report(results)
+			return nil, fmt.Errorf("atomic release aborted; cannot update %s", strings.Join(blocked, ", "))
+		}
+	}
+
 	// At this point we may have filtered the updates we can do down
 	// to nothing. Check and exit early if so.
 	if len(updates) == 0 {
@@ -152,25 +274,100 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 		return nil, nil
 	}
 
+	// Only the owners of the files being changed may change them, if
+	// the config repo says who the owners are. A dry run fails too,
+	// so it can be seen that the release won't be allowed.
+	if spec.ImageSpec != flux.ImageSpecNone || len(spec.ValueUpdates) > 0 {
+		if err = checkOwners(rc, updates, job.Params.(jobs.ReleaseJobParams).Cause); err != nil {
+			return nil, err
+		}
+	}
+
 	// If it's a dry run, we're done.
 	if spec.Kind == flux.ReleaseKindPlan {
 		return nil, nil
 	}
 
-	if spec.ImageSpec != flux.ImageSpecNone {
+	// A blue/green release carries on in a job of its own, once the
+	// copies are started.
+	if spec.Kind == flux.ReleaseKindBlueGreen {
+		return startBlueGreen(rc, job, updates, results, logStatus, logOutput, report)
+	}
+
+	var revision, pullRequest, proposedBranch string
+	if spec.ImageSpec != flux.ImageSpecNone || len(spec.ValueUpdates) > 0 {
 		logStatus("Pushing changes.")
-		timer = NewStageTimer("push_changes")
-		err = rc.PushChanges(updates, &spec)
+		timer = NewStageTimer(ctx, "push_changes")
+		timer.SetTag("branch", repo.Branch)
+		cause := job.Params.(jobs.ReleaseJobParams).Cause
+		pullRequest, err = rc.PushChanges(updates, &spec, cause, flux.ReleaseID(job.ID))
+		if err != nil {
+			proposedBranch, pullRequest, err = rc.ProposeRefused(err, &spec, cause, flux.ReleaseID(job.ID))
+		}
+		timer.SetError(err)
 		timer.ObserveDuration()
 		if err != nil {
 			return nil, err
 		}
+		// With a pull request, the commit isn't on the branch (yet),
+		// so it's the pull request that's recorded.
+		if pullRequest == "" {
+			if revision, err = rc.HeadRevision(); err != nil {
+				// Not fatal; we just won't be able to say which
+				// commit this release made.
+				inst.Log("err", err)
+			}
+		}
+		for _, update := range updates {
+			for _, c := range update.Updates {
+				logOutput("%s: container %s: %s -> %s", update.ServiceID, c.Container, c.Current, c.Target)
+			}
+			for _, v := range update.Values {
+				logOutput("%s: %s %s", update.ServiceID, v.Kind, v)
+			}
+		}
+		if proposedBranch != "" {
+			logOutput("git push refused; pushed to branch %q instead: pull request %s", proposedBranch, pullRequest)
+		} else if pullRequest != "" {
+			logOutput("git commit and push to branch %q: pull request %s", pullRequestBranch(flux.ReleaseID(job.ID)), pullRequest)
+		} else {
+			logOutput("git commit and push to branch %q: revision %s", repo.Branch, revision)
+		}
 	}
 
-	logStatus("Applying changes.")
-	timer = NewStageTimer("apply_changes")
-	applyErr := applyChanges(rc.Instance, updates, results)
-	timer.ObserveDuration()
+	// What's applied is recorded on the workloads; if nothing was
+	// committed, that's what's at the head of the branch.
+	annotationRevision := revision
+	if annotationRevision == "" && pullRequest == "" {
+		annotationRevision, _ = rc.HeadRevision()
+	}
+	var applyErr error
+	if proposedBranch != "" {
+		// The branch wouldn't have the changes, so they wait for the
+		// pull request to be merged, to be synced from there.
+		for _, update := range updates {
+			result := results[update.ServiceID]
+			result.Status = flux.ReleaseStatusSkipped
+			result.Error = ProposedOnly
+			results[update.ServiceID] = result
+		}
+	} else {
+		annotateUpdates(updates, flux.ReleaseID(job.ID), job.Params.(jobs.ReleaseJobParams).Cause, annotationRevision, pullRequest, logOutput)
+
+		logStatus("Applying changes.")
+		timer = NewStageTimer(ctx, "apply_changes")
+		applyErr = applyChanges(rc.Instance, updates, results)
+		timer.SetError(applyErr)
+		timer.ObserveDuration()
+	}
+	for _, update := range updates {
+		result := results[update.ServiceID]
+		if result.Error != "" {
+			logOutput("apply %s: %s: %s", update.ServiceID, result.Status, result.Error)
+		} else {
+			logOutput("apply %s: %s", update.ServiceID, result.Status)
+		}
+	}
 
 	status := flux.ReleaseStatusSuccess
 	if applyErr != nil {
@@ -188,19 +385,21 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 		Status:   status,
 		Log:      job.Log,
 
-		Cause:  job.Params.(jobs.ReleaseJobParams).Cause,
-		Spec:   job.Params.(jobs.ReleaseJobParams).Spec(),
-		Result: results,
+		Cause:       job.Params.(jobs.ReleaseJobParams).Cause,
+		Spec:        job.Params.(jobs.ReleaseJobParams).Spec(),
+		Result:      results,
+		Revision:    revision,
+		PullRequest: pullRequest,
 	}
 
 	// Report on success or failure of the application above.
 	logStatus("Sending notifications.")
-	timer = NewStageTimer("send_notifications")
+	timer = NewStageTimer(ctx, "send_notifications")
 	notifyErr := sendNotifications(rc.Instance, applyErr, release)
 	timer.ObserveDuration()
 
 	// Log the event into the history
-	timer = NewStageTimer("log_event")
+	timer = NewStageTimer(ctx, "log_event")
 	err = logEvent(rc.Instance, notifyErr, release)
 	timer.ObserveDuration()
 
@@ -209,6 +408,22 @@ func (r *Releaser) release(instanceID flux.InstanceID, job *jobs.Job, logStatus
 	return nil, err
 }
 
+// atomicBlockers gives the services targeted by a release that cannot
+// be updated, each with the reason why. Services that are already up
+// to date, or running the same content, don't stop an atomic release.
+func atomicBlockers(results flux.ReleaseResult) []string {
+	var blocked []string
+	for _, id := range results.ServiceIDs() {
+		result := results[flux.ServiceID(id)]
+		switch {
+		case result.Status == flux.ReleaseStatusFailed,
+			result.Status == flux.ReleaseStatusSkipped && result.Error != ImageUpToDate && result.Error != SameContent:
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", id, result.Error))
+		}
+	}
+	return blocked
+}
+
 // `logEvent` expects the result of applying updates, and records an event in
 // the history about the release taking place. It returns the origin error if
 // that was non-nil, otherwise the result of the attempted logging.
@@ -220,10 +435,12 @@ func logEvent(inst *instance.Instance, executeErr error, release flux.Release) e
 		logLevel = flux.LogLevelError
 	}
 
+	// In order, so the event is the same however many times it's
+	// written
 	var serviceIDs []flux.ServiceID
-	for k, v := range release.Result {
-		if v.Status != flux.ReleaseStatusIgnored {
-			serviceIDs = append(serviceIDs, flux.ServiceID(k))
+	for _, id := range release.Result.ServiceIDs() {
+		if release.Result[flux.ServiceID(id)].Status != flux.ReleaseStatusIgnored {
+			serviceIDs = append(serviceIDs, flux.ServiceID(id))
 		}
 	}
 
@@ -246,6 +463,26 @@ func logEvent(inst *instance.Instance, executeErr error, release flux.Release) e
 	return executeErr
 }
 
+// `logLintEvent` lints the cloned config repo, and records a warning
+// event in the history if there are problems.
+func logLintEvent(rc *ReleaseContext) error {
+	report, err := rc.Lint()
+	if err != nil {
+		return err
+	}
+	if len(report.Problems) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	return rc.Instance.LogEvent(flux.Event{
+		Type:      flux.EventLint,
+		StartedAt: now,
+		EndedAt:   now,
+		LogLevel:  flux.LogLevelWarn,
+		Metadata:  report,
+	})
+}
+
 // `sendNotifications` expects the result of applying updates, and
 // sends notifications indicating success or failure. It returns the
 // origin error if that was non-nil, otherwise the result of the
@@ -302,20 +539,51 @@ func filters(spec *flux.ReleaseSpec, rc *ReleaseContext) ([]ServiceFilter, error
 		filtList = append(filtList, imgFilt)
 	}
 
+	// We need the config for the automated and locked filters
+	conf, err := rc.Instance.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	// Service filter
 	ids := []flux.ServiceID{}
+	var automated, selected bool
 	for _, s := range spec.ServiceSpecs {
 		if s == flux.ServiceSpecAll {
 			ids = []flux.ServiceID{} // "<all>" Overrides any other filters
+			automated, selected = false, false
 			break
 		}
+		if s == flux.ServiceSpecAutomated {
+			automated = true
+			continue
+		}
+		if selector, ok := s.AsSelector(); ok {
+			// The selector is resolved to the services with the
+			// labels now; if there are none, nothing is released.
+			services, err := rc.Instance.GetServicesMatching(selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, service := range services {
+				ids = append(ids, service.ID)
+			}
+			selected = true
+			continue
+		}
 		id, err := flux.ParseServiceID(string(s))
 		if err != nil {
 			return nil, err
 		}
 		ids = append(ids, id)
 	}
-	if len(ids) > 0 {
+	switch {
+	case automated:
+		// Services named alongside "<all automated>" are released
+		// whether automated or not.
+		autoFilt := &AutomatedFilter{append(AutomatedServices(conf).ToSlice(), ids...)}
+		filtList = append(filtList, autoFilt)
+	case len(ids) > 0 || selected:
 		incFilt := &IncludeFilter{ids}
 		filtList = append(filtList, incFilt)
 	}
@@ -326,13 +594,13 @@ func filters(spec *flux.ReleaseSpec, rc *ReleaseContext) ([]ServiceFilter, error
 		filtList = append(filtList, exFilt)
 	}
 
-	// Locked filter
-	// - Get config
-	conf, err := rc.Instance.GetConfig()
-	if err != nil {
-		return nil, err
+	// Namespace filter
+	if len(conf.Settings.Namespaces) > 0 {
+		filtList = append(filtList, &NamespaceFilter{conf.Settings.Namespaces})
 	}
 
+	// Locked filter
+
 	// - Get locked services from config
 	lockedSet := LockedServices(conf)
 	lockFilt := &LockedFilter{lockedSet.ToSlice()}
@@ -346,7 +614,7 @@ func filters(spec *flux.ReleaseSpec, rc *ReleaseContext) ([]ServiceFilter, error
 // however we do want to see if we *can* do the replacements, because
 // if not, it indicates there's likely some problem with the running
 // system vs the definitions given in the repo.)
-func calculateImageUpdates(inst *instance.Instance, candidates []*ServiceUpdate, spec *flux.ReleaseSpec, results flux.ReleaseResult, logStatus statusFn) ([]*ServiceUpdate, error) {
+func calculateImageUpdates(inst *instance.Instance, candidates []*ServiceUpdate, spec *flux.ReleaseSpec, cause flux.ReleaseCause, results flux.ReleaseResult, logStatus statusFn) ([]*ServiceUpdate, error) {
 	// Compile an `ImageMap` of all relevant images
 	var images instance.ImageMap
 	var err error
@@ -368,89 +636,107 @@ func calculateImageUpdates(inst *instance.Instance, candidates []*ServiceUpdate,
 		return nil, err
 	}
 
+	// A new tag for an image that's already running is no reason to
+	// release; we tell by comparing digests.
+	digests := newDigestCache(inst.ImageDigest)
+	checks := plan.Checks{SameContent: digests.same}
+
+	// Automated releases don't go ahead with a multi-arch image that
+	// has no image for the cluster's architecture; people releasing by
+	// hand can judge for themselves.
+	if cause.User == flux.UserAutomated {
+		config, err := inst.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		platforms := newPlatformCache(inst.ImagePlatforms, config.Settings.ClusterArchitecture())
+		checks.LacksArchitecture = platforms.lacksArchitecture
+		checks.Architecture = platforms.arch
+	}
+
 	// Look through all the services' containers to see which have an
 	// image that could be updated.
 	var updates []*ServiceUpdate
 	for _, update := range candidates {
-		containers, err := update.Service.ContainersOrError()
+		def, result, err := plan.ImageUpdates(update.Service, update.ManifestBytes, images, checks, updatePodController, plan.Logf(logStatus))
 		if err != nil {
-			logStatus("Failing service %s: %s", update.ServiceID, err.Error())
-			results[update.ServiceID] = flux.ServiceResult{
-				Status: flux.ReleaseStatusFailed,
-				Error:  err.Error(),
-			}
-			continue
+			return nil, err
+		}
+		results[update.ServiceID] = result
+		if result.Status == flux.ReleaseStatusPending {
+			update.ManifestBytes = def
+			update.Updates = result.PerContainer
+			updates = append(updates, update)
 		}
+	}
 
-		// If at least one container used an image in question, we say
-		// we're skipping it rather than ignoring it. This is mainly
-		// for the purpose of filtering the output.
-		ignoredOrSkipped := flux.ReleaseStatusIgnored
-		var containerUpdates []flux.ContainerUpdate
+	return updates, nil
+}
 
-		for _, container := range containers {
-			currentImageID, err := flux.ParseImageID(container.Image)
-			if err != nil {
-				// We may hope never to find a malformed image ID, but
-				// anything is possible.
-				return nil, err
-			}
+func updatePodController(def []byte, image flux.ImageID) ([]byte, error) {
+	return kubernetes.UpdatePodController(def, image, ioutil.Discard)
+}
 
-			latestImage := images.LatestImage(currentImageID.Repository())
-			if latestImage == nil {
-				ignoredOrSkipped = flux.ReleaseStatusUnknown
-				continue
-			}
+// digestCache looks up the content digests of images, remembering
+// them for the length of a release.
+type digestCache struct {
+	lookup  func(flux.ImageID) (string, error)
+	digests map[flux.ImageID]string
+}
 
-			if currentImageID == latestImage.ID {
-				ignoredOrSkipped = flux.ReleaseStatusSkipped
-				continue
-			}
+func newDigestCache(lookup func(flux.ImageID) (string, error)) *digestCache {
+	return &digestCache{lookup: lookup, digests: map[flux.ImageID]string{}}
+}
 
-			update.ManifestBytes, err = kubernetes.UpdatePodController(update.ManifestBytes, latestImage.ID, ioutil.Discard)
-			if err != nil {
-				logStatus("Failed on service %s: %s", update.ServiceID, err.Error())
-				return nil, err
-			}
+func (c *digestCache) digest(id flux.ImageID) string {
+	if d, ok := c.digests[id]; ok {
+		return d
+	}
+	// If we can't find out, the image is treated as different
+	// content, as it would be without digests.
+	d, err := c.lookup(id)
+	if err != nil {
+		d = ""
+	}
+	c.digests[id] = d
+	return d
+}
 
-			logStatus("Will update %s container %s: %s -> %s", update.ServiceID, container.Name, currentImageID, latestImage.ID.Tag)
-			containerUpdates = append(containerUpdates, flux.ContainerUpdate{
-				Container: container.Name,
-				Current:   currentImageID,
-				Target:    latestImage.ID,
-			})
-		}
+// same says whether the images are known to have the same content.
+// The digest of the current image is that of its tag now, which
+// will be what's running unless the tag has been moved since.
+func (c *digestCache) same(current, target flux.ImageID) bool {
+	d := c.digest(current)
+	return d != "" && d == c.digest(target)
+}
 
-		switch {
-		case len(containerUpdates) > 0:
-			update.Updates = containerUpdates
-			updates = append(updates, update)
-			results[update.ServiceID] = flux.ServiceResult{
-				Status:       flux.ReleaseStatusPending,
-				PerContainer: containerUpdates,
-			}
-		case ignoredOrSkipped == flux.ReleaseStatusSkipped:
-			logStatus("Skipping service %s, images are up to date", update.ServiceID)
-			results[update.ServiceID] = flux.ServiceResult{
-				Status: flux.ReleaseStatusSkipped,
-				Error:  ImageUpToDate,
-			}
-		case ignoredOrSkipped == flux.ReleaseStatusIgnored:
-			logStatus("Ignoring service %s, does not use image(s) in question", update.ServiceID)
-			results[update.ServiceID] = flux.ServiceResult{
-				Status: flux.ReleaseStatusIgnored,
-				Error:  "does not use image(s)",
-			}
-		case ignoredOrSkipped == flux.ReleaseStatusUnknown:
-			logStatus("Ignoring service %s, cannot find image(s)", update.ServiceID)
-			results[update.ServiceID] = flux.ServiceResult{
-				Status: flux.ReleaseStatusSkipped,
-				Error:  ImageNotFound,
-			}
-		}
-	}
+// platformCache looks up the platforms of multi-arch images,
+// remembering them for the length of a release.
+type platformCache struct {
+	lookup    func(flux.ImageID) ([]flux.ImagePlatform, error)
+	arch      string
+	platforms map[flux.ImageID][]flux.ImagePlatform
+}
 
-	return updates, nil
+func newPlatformCache(lookup func(flux.ImageID) ([]flux.ImagePlatform, error), arch string) *platformCache {
+	return &platformCache{lookup: lookup, arch: arch, platforms: map[flux.ImageID][]flux.ImagePlatform{}}
+}
+
+// lacksArchitecture says whether the image is multi-arch, and has no
+// image for the cluster's architecture. Images that aren't multi-arch
+// (or whose platforms can't be found out) are given the benefit of
+// the doubt.
+func (c *platformCache) lacksArchitecture(id flux.ImageID) bool {
+	platforms, ok := c.platforms[id]
+	if !ok {
+		platforms, _ = c.lookup(id)
+		c.platforms[id] = platforms
+	}
+	if len(platforms) == 0 {
+		return false
+	}
+	_, ok = flux.PlatformFor(platforms, c.arch)
+	return !ok
 }
 
 func commitMessageFromReleaseSpec(spec *flux.ReleaseSpec) string {
@@ -459,5 +745,16 @@ func commitMessageFromReleaseSpec(spec *flux.ReleaseSpec) string {
 	for _, s := range spec.ServiceSpecs {
 		services = append(services, strings.Trim(s.String(), "<>"))
 	}
-	return fmt.Sprintf("Release %s to %s", image, strings.Join(services, ", "))
+	var values []string
+	for _, v := range spec.ValueUpdates {
+		values = append(values, v.String())
+	}
+	switch {
+	case len(values) == 0:
+		return fmt.Sprintf("Release %s to %s", image, strings.Join(services, ", "))
+	case spec.ImageSpec == flux.ImageSpecNone:
+		return fmt.Sprintf("Set %s in %s", strings.Join(values, ", "), strings.Join(services, ", "))
+	default:
+		return fmt.Sprintf("Release %s to %s, and set %s", image, strings.Join(services, ", "), strings.Join(values, ", "))
+	}
 }
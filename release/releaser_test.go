@@ -1,6 +1,7 @@
 package release
 
 import (
+	"errors"
 	"io/ioutil"
 	"reflect"
 	"testing"
@@ -107,7 +108,7 @@ func setup(t *testing.T, mocks instance.Instance) (*Releaser, func()) {
 	mocks.Logger = log.NewNopLogger()
 
 	instancer := &instance.MockInstancer{&mocks, nil}
-	return NewReleaser(instancer), cleanup
+	return NewReleaser(instancer, false), cleanup
 }
 
 func Test_FilterLogic(t *testing.T) {
@@ -513,3 +514,182 @@ func Test_LogEvent(t *testing.T) {
 		t.Fatalf("Expecting single service to be reported as altered but got %v services", len(event1.ServiceIDs))
 	}
 }
+
+func Test_LogEventServiceOrder(t *testing.T) {
+	result := flux.ReleaseResult{}
+	var expected []flux.ServiceID
+	for _, id := range []string{"default/a", "default/b", "default/c", "default/d", "kube-system/e"} {
+		result[flux.ServiceID(id)] = flux.ServiceResult{Status: flux.ReleaseStatusSuccess}
+		expected = append(expected, flux.ServiceID(id))
+	}
+	for i := 0; i < 10; i++ {
+		mockEventWriter := mockEventWriter{}
+		inst := instance.Instance{
+			EventWriter: &mockEventWriter,
+		}
+		if err := logEvent(&inst, nil, flux.Release{Result: result}); err != nil {
+			t.Fatal(err)
+		}
+		if got := mockEventWriter.events[0].ServiceIDs; !reflect.DeepEqual(expected, got) {
+			t.Fatalf("expected services in order %v, got %v", expected, got)
+		}
+	}
+}
+
+func Test_AtomicBlockers(t *testing.T) {
+	results := flux.ReleaseResult{
+		flux.ServiceID("default/helloworld"): flux.ServiceResult{
+			Status: flux.ReleaseStatusPending,
+		},
+		flux.ServiceID("default/uptodate"): flux.ServiceResult{
+			Status: flux.ReleaseStatusSkipped,
+			Error:  ImageUpToDate,
+		},
+		flux.ServiceID("default/other"): flux.ServiceResult{
+			Status: flux.ReleaseStatusIgnored,
+			Error:  NotIncluded,
+		},
+	}
+	if blocked := atomicBlockers(results); len(blocked) != 0 {
+		t.Errorf("expected nothing to block the release, got %v", blocked)
+	}
+
+	results[flux.ServiceID("default/locked-service")] = flux.ServiceResult{
+		Status: flux.ReleaseStatusSkipped,
+		Error:  Locked,
+	}
+	results[flux.ServiceID("default/test-service")] = flux.ServiceResult{
+		Status: flux.ReleaseStatusFailed,
+		Error:  "invalid definition",
+	}
+	expected := []string{
+		"default/locked-service (" + Locked + ")",
+		"default/test-service (invalid definition)",
+	}
+	if blocked := atomicBlockers(results); !reflect.DeepEqual(expected, blocked) {
+		t.Errorf("expected %v, got %v", expected, blocked)
+	}
+}
+
+func Test_DigestCache(t *testing.T) {
+	lookups := 0
+	digests := newDigestCache(func(id flux.ImageID) (string, error) {
+		lookups++
+		switch id.Tag {
+		case "v1", "stable":
+			return "sha256:aaa", nil
+		case "v2":
+			return "sha256:bbb", nil
+		case "unknown":
+			return "", errors.New("not found")
+		}
+		return "", nil
+	})
+	image := func(tag string) flux.ImageID {
+		return flux.ImageID{Host: "quay.io", Namespace: "weaveworks", Image: "helloworld", Tag: tag}
+	}
+
+	if !digests.same(image("v1"), image("stable")) {
+		t.Error("expected v1 and stable to have the same content")
+	}
+	if digests.same(image("v1"), image("v2")) {
+		t.Error("expected v1 and v2 to have different content")
+	}
+	if digests.same(image("unknown"), image("unknown")) {
+		t.Error("expected images whose digests can't be found not to be the same")
+	}
+	if digests.same(image("nodigest"), image("nodigest")) {
+		t.Error("expected images without digests not to be the same")
+	}
+	if lookups != 5 {
+		t.Errorf("expected each digest to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func Test_PlatformCache(t *testing.T) {
+	lookups := 0
+	platforms := newPlatformCache(func(id flux.ImageID) ([]flux.ImagePlatform, error) {
+		lookups++
+		switch id.Tag {
+		case "multi":
+			return []flux.ImagePlatform{
+				{OS: "linux", Architecture: "amd64", Digest: "sha256:aaa"},
+				{OS: "linux", Architecture: "arm64", Digest: "sha256:bbb"},
+			}, nil
+		case "amd64-only":
+			return []flux.ImagePlatform{
+				{OS: "linux", Architecture: "amd64", Digest: "sha256:aaa"},
+				{OS: "windows", Architecture: "arm64", Digest: "sha256:ccc"},
+			}, nil
+		case "unknown":
+			return nil, errors.New("not found")
+		}
+		return nil, nil
+	}, "arm64")
+	image := func(tag string) flux.ImageID {
+		return flux.ImageID{Host: "quay.io", Namespace: "weaveworks", Image: "helloworld", Tag: tag}
+	}
+
+	for tag, lacks := range map[string]bool{
+		"multi":      false,
+		"amd64-only": true,
+		"single":     false,
+		"unknown":    false,
+	} {
+		if platforms.lacksArchitecture(image(tag)) != lacks {
+			t.Errorf("%s: expected lacksArchitecture to be %v", tag, lacks)
+		}
+	}
+	platforms.lacksArchitecture(image("amd64-only"))
+	if lookups != 4 {
+		t.Errorf("expected each image to be looked up once, got %d lookups", lookups)
+	}
+}
+
+func Test_SelectorFilters(t *testing.T) {
+	frontend := hwSvc
+	frontend.Labels = map[string]string{"app": "frontend", "tier": "web"}
+	rc := NewReleaseContext(&instance.Instance{
+		Platform: &platform.MockPlatform{
+			AllServicesAnswer: []platform.Service{frontend, lockedSvc, testScv},
+		},
+		Config: &instance.MockConfigurer{Config: instance.MakeConfig()},
+	})
+
+	for _, c := range []struct {
+		selector string
+		included []flux.ServiceID
+	}{
+		{"selector:app=frontend", []flux.ServiceID{hwSvcID}},
+		{"selector:app=frontend,tier=web", []flux.ServiceID{hwSvcID}},
+		{"selector:app=backend", nil},
+	} {
+		spec, err := flux.ParseServiceSpec(c.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filts, err := filters(&flux.ReleaseSpec{
+			ServiceSpecs: []flux.ServiceSpec{spec},
+			ImageSpec:    flux.ImageSpecLatest,
+		}, rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var included []flux.ServiceID
+		for _, svc := range allSvcs {
+			excluded := false
+			for _, f := range filts {
+				if f.Filter(ServiceUpdate{ServiceID: svc.ID}).Error != "" {
+					excluded = true
+				}
+			}
+			if !excluded {
+				included = append(included, svc.ID)
+			}
+		}
+		if !reflect.DeepEqual(c.included, included) {
+			t.Errorf("%s: expected %v to be included, got %v", c.selector, c.included, included)
+		}
+	}
+}
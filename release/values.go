@@ -0,0 +1,135 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform/kubernetes"
+)
+
+// ConfigMapUpdate is a ConfigMap definition changed by a release,
+// which is applied along with the service(s) that it's released to.
+type ConfigMapUpdate struct {
+	Path  string
+	Bytes []byte
+}
+
+// calculateValueUpdates makes the value updates in the spec to each
+// of the candidate services. It returns the services that are to be
+// released: those given as already updated (e.g., with new images),
+// and those with values changed.
+func calculateValueUpdates(rc *ReleaseContext, candidates, updated []*ServiceUpdate, spec *flux.ReleaseSpec, results flux.ReleaseResult, logStatus statusFn) ([]*ServiceUpdate, error) {
+	isUpdated := map[flux.ServiceID]bool{}
+	for _, u := range updated {
+		isUpdated[u.ServiceID] = true
+	}
+	// A ConfigMap may be updated for more than one service in its
+	// namespace, so keep them all in one place.
+	configMaps := map[string]*ConfigMapUpdate{}
+
+	var updates []*ServiceUpdate
+	for _, update := range candidates {
+		if results[update.ServiceID].Status == flux.ReleaseStatusFailed {
+			continue
+		}
+		namespace, _ := update.ServiceID.Components()
+		var (
+			values   []flux.ValueUpdate
+			failed   error
+			manifest = update.ManifestBytes
+			changed  bool
+			// The ConfigMaps changed for this service, by path,
+			// which are merged into the others only if all its
+			// values can be updated
+			changedMaps  = map[string][]byte{}
+			changedPaths []string
+		)
+		for _, v := range spec.ValueUpdates {
+			switch v.Kind {
+			case flux.ValueKindEnv:
+				manifest, changed, failed = kubernetes.UpdateEnv(manifest, v.Container, v.Key, v.Value)
+			case flux.ValueKindConfigMap:
+				var configPath string
+				if configPath, failed = kubernetes.FindConfigMap(rc.RepoPath(), namespace, v.ConfigMap); failed != nil {
+					break
+				}
+				if configPath == "" {
+					failed = fmt.Errorf("no ConfigMap %s found in namespace %s", v.ConfigMap, namespace)
+					break
+				}
+				bytes, ok := changedMaps[configPath]
+				if !ok {
+					if configMap := configMaps[configPath]; configMap != nil {
+						bytes = configMap.Bytes
+					} else if bytes, failed = ioutil.ReadFile(configPath); failed != nil {
+						return nil, failed
+					}
+				}
+				var newBytes []byte
+				if newBytes, changed, failed = kubernetes.UpdateConfigMapData(bytes, v.Key, v.Value); changed {
+					if _, ok := changedMaps[configPath]; !ok {
+						changedPaths = append(changedPaths, configPath)
+					}
+					changedMaps[configPath] = newBytes
+				}
+			default:
+				changed, failed = false, flux.ErrInvalidValueKind
+			}
+			if failed != nil {
+				break
+			}
+			if changed {
+				values = append(values, v)
+			}
+		}
+
+		if failed != nil {
+			logStatus("Failing service %s: %s", update.ServiceID, failed.Error())
+			results[update.ServiceID] = flux.ServiceResult{
+				Status: flux.ReleaseStatusFailed,
+				Error:  failed.Error(),
+			}
+			continue
+		}
+
+		if len(values) == 0 {
+			if isUpdated[update.ServiceID] {
+				updates = append(updates, update)
+			} else if _, ok := results[update.ServiceID]; !ok || spec.ImageSpec == flux.ImageSpecNone {
+				results[update.ServiceID] = flux.ServiceResult{
+					Status: flux.ReleaseStatusSkipped,
+					Error:  "no values to update",
+				}
+			}
+			continue
+		}
+
+		var changes []string
+		for _, v := range values {
+			changes = append(changes, v.String())
+		}
+		logStatus("Will update %s: %s", update.ServiceID, strings.Join(changes, ", "))
+		update.ManifestBytes = manifest
+		update.Values = values
+		update.ConfigMaps = nil
+		for _, path := range changedPaths {
+			configMap := configMaps[path]
+			if configMap == nil {
+				configMap = &ConfigMapUpdate{Path: path}
+				configMaps[path] = configMap
+			}
+			configMap.Bytes = changedMaps[path]
+			update.ConfigMaps = append(update.ConfigMaps, configMap)
+		}
+		updates = append(updates, update)
+		result := results[update.ServiceID]
+		results[update.ServiceID] = flux.ServiceResult{
+			Status:       flux.ReleaseStatusPending,
+			PerContainer: result.PerContainer,
+			Values:       values,
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,103 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/instance"
+)
+
+const valuesConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: default
+data:
+  LEVEL: info
+`
+
+const valuesDeployment = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: %s
+  namespace: default
+spec:
+  template:
+    spec:
+      containers:
+      - name: greeter
+        image: quay.io/weaveworks/helloworld:master-a000001
+        env:
+        - name: GREETING
+          %s
+`
+
+func valuesService(name, greeting string) *ServiceUpdate {
+	return &ServiceUpdate{
+		ServiceID:     flux.MakeServiceID("default", name),
+		ManifestBytes: []byte(strings.Replace(strings.Replace(valuesDeployment, "%s", name, 1), "%s", greeting, 1)),
+	}
+}
+
+func TestCalculateValueUpdates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "settings.yaml"), []byte(valuesConfigMap), 0666); err != nil {
+		t.Fatal(err)
+	}
+	rc := &ReleaseContext{Instance: &instance.Instance{Repo: git.Repo{}}, WorkingDir: dir}
+	noop := func(string, ...interface{}) {}
+
+	// The first service can't have its environment variable set, so
+	// the ConfigMap value it would have set is left for the second.
+	broken := valuesService("broken", "valueFrom: {secretKeyRef: {name: greeter, key: greeting}}")
+	ok := valuesService("ok", "value: hello")
+	spec := &flux.ReleaseSpec{
+		ImageSpec: flux.ImageSpecNone,
+		ValueUpdates: []flux.ValueUpdate{
+			{Kind: flux.ValueKindConfigMap, ConfigMap: "settings", Key: "LEVEL", Value: "debug"},
+			{Kind: flux.ValueKindEnv, Key: "GREETING", Value: "hi"},
+		},
+	}
+	results := flux.ReleaseResult{}
+	updates, err := calculateValueUpdates(rc, []*ServiceUpdate{broken, ok}, nil, spec, results, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[broken.ServiceID].Status != flux.ReleaseStatusFailed {
+		t.Errorf("expected %s to fail, got %+v", broken.ServiceID, results[broken.ServiceID])
+	}
+	if len(updates) != 1 || updates[0] != ok {
+		t.Fatalf("expected only %s to be updated, got %+v", ok.ServiceID, updates)
+	}
+	if len(ok.Values) != 2 {
+		t.Errorf("expected both values to be updated for %s, got %+v", ok.ServiceID, ok.Values)
+	}
+	if len(ok.ConfigMaps) != 1 || !strings.Contains(string(ok.ConfigMaps[0].Bytes), "LEVEL: debug") {
+		t.Errorf("expected the ConfigMap to be updated along with %s, got %+v", ok.ServiceID, ok.ConfigMaps)
+	}
+
+	// A ConfigMap that can't be found is an error
+	spec.ValueUpdates = []flux.ValueUpdate{
+		{Kind: flux.ValueKindConfigMap, ConfigMap: "nosuchmap", Key: "LEVEL", Value: "debug"},
+	}
+	results = flux.ReleaseResult{}
+	updates, err = calculateValueUpdates(rc, []*ServiceUpdate{valuesService("ok", "value: hello")}, nil, spec, results, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("expected no updates, got %+v", updates)
+	}
+	if result := results[ok.ServiceID]; result.Status != flux.ReleaseStatusFailed || !strings.Contains(result.Error, "nosuchmap") {
+		t.Errorf("expected %s to fail for want of the ConfigMap, got %+v", ok.ServiceID, result)
+	}
+}
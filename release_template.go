@@ -0,0 +1,93 @@
+package flux
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// ReleaseTemplate is a release spec saved under a name, so that a
+// release done again and again (e.g., of all the frontend services to
+// their latest images) can be done by name. Message is the message
+// given as the cause of each release from the template; it's a Go
+// template, given the ReleaseTemplateCause.
+type ReleaseTemplate struct {
+	Name    string      `json:"name"`
+	Spec    ReleaseSpec `json:"spec"`
+	Message string      `json:"message,omitempty"`
+}
+
+// ReleaseTemplateCause is what a template's message is given: the
+// template's name, who's releasing from it, and when.
+type ReleaseTemplateCause struct {
+	Name string
+	User string
+	Time time.Time
+}
+
+// Template names go in URLs, so they're kept plain.
+var releaseTemplateName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Problems gives the reasons the template can't be saved: its name
+// isn't suitable, its message isn't a valid template, or its spec has
+// problems.
+func (t ReleaseTemplate) Problems() []ReleaseProblem {
+	var problems []ReleaseProblem
+	if !releaseTemplateName.MatchString(t.Name) {
+		problems = append(problems, ReleaseProblem{
+			Field:   "Name",
+			Message: fmt.Sprintf("template name %q must be lowercase letters, digits and dashes", t.Name),
+		})
+	}
+	if _, err := template.New(t.Name).Parse(t.Message); err != nil {
+		problems = append(problems, ReleaseProblem{
+			Field:   "Message",
+			Message: fmt.Sprintf("invalid message template: %s", err),
+		})
+	}
+	return append(problems, t.Spec.Problems()...)
+}
+
+// Cause gives the cause of a release from the template, by the user
+// given, at the time given.
+func (t ReleaseTemplate) Cause(user string, now time.Time) (ReleaseCause, error) {
+	tmpl, err := template.New(t.Name).Parse(t.Message)
+	if err != nil {
+		return ReleaseCause{}, err
+	}
+	var message bytes.Buffer
+	if err := tmpl.Execute(&message, ReleaseTemplateCause{Name: t.Name, User: user, Time: now}); err != nil {
+		return ReleaseCause{}, err
+	}
+	return ReleaseCause{User: user, Message: message.String()}, nil
+}
+
+// NoSuchReleaseTemplate is the error for asking for a template that
+// hasn't been saved.
+func NoSuchReleaseTemplate(name string) error {
+	return Missing{BaseError: &BaseError{
+		Help: fmt.Sprintf(`There is no release template called %q.
+
+You can list the release templates with
+
+    fluxctl list-release-templates
+`, name),
+		Err: fmt.Errorf("no release template %q", name),
+	}}
+}
+
+// InvalidReleaseTemplate is the error for trying to save a template
+// with problems.
+func InvalidReleaseTemplate(problems []ReleaseProblem) error {
+	var help bytes.Buffer
+	help.WriteString("The release template can't be saved, because of these problems:\n\n")
+	for _, p := range problems {
+		fmt.Fprintf(&help, "    %s\n", p)
+	}
+	return UserConfigProblem{BaseError: &BaseError{
+		Help: help.String(),
+		Err:  fmt.Errorf("release template has %d problem(s)", len(problems)),
+	}}
+}
@@ -0,0 +1,49 @@
+package flux
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseTemplateProblems(t *testing.T) {
+	spec := ReleaseSpec{
+		ServiceSpecs: []ServiceSpec{"selector:tier=frontend"},
+		ImageSpec:    ImageSpecLatest,
+		Kind:         ReleaseKindExecute,
+	}
+	for _, example := range []struct {
+		template ReleaseTemplate
+		problems []string
+	}{
+		{ReleaseTemplate{Name: "frontend", Spec: spec, Message: "Weekly release by {{.User}}"}, nil},
+		{ReleaseTemplate{Name: "Front End", Spec: spec}, []string{"Name"}},
+		{ReleaseTemplate{Name: "frontend-", Spec: spec}, []string{"Name"}},
+		{ReleaseTemplate{Name: "frontend", Spec: spec, Message: "{{.User"}, []string{"Message"}},
+		{ReleaseTemplate{Name: "frontend", Spec: ReleaseSpec{ImageSpec: ImageSpecLatest, Kind: ReleaseKindExecute}}, []string{"ServiceSpecs"}},
+	} {
+		problems := example.template.Problems()
+		if len(problems) != len(example.problems) {
+			t.Errorf("%+v: expected problems with %v, got %v", example.template, example.problems, problems)
+			continue
+		}
+		for i, p := range problems {
+			if p.Field != example.problems[i] {
+				t.Errorf("%+v: expected problems with %v, got %v", example.template, example.problems, problems)
+			}
+		}
+	}
+}
+
+func TestReleaseTemplateCause(t *testing.T) {
+	template := ReleaseTemplate{
+		Name:    "frontend",
+		Message: "{{.Name}} release by {{.User}} on {{.Time.Format \"2006-01-02\"}}",
+	}
+	cause, err := template.Cause("alice", time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cause.User != "alice" || cause.Message != "frontend release by alice on 2017-03-01" {
+		t.Errorf("unexpected cause %+v", cause)
+	}
+}
@@ -0,0 +1,97 @@
+package flux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseValueUpdate(t *testing.T) {
+	for _, c := range []struct {
+		kind     ValueKind
+		input    string
+		expected ValueUpdate
+	}{
+		{ValueKindEnv, "LOG_LEVEL=debug", ValueUpdate{Kind: ValueKindEnv, Key: "LOG_LEVEL", Value: "debug"}},
+		{ValueKindEnv, "app:URL=http://example.com/?a=b", ValueUpdate{Kind: ValueKindEnv, Container: "app", Key: "URL", Value: "http://example.com/?a=b"}},
+		{ValueKindEnv, "EMPTY=", ValueUpdate{Kind: ValueKindEnv, Key: "EMPTY"}},
+		{ValueKindConfigMap, "settings:timeout=30s", ValueUpdate{Kind: ValueKindConfigMap, ConfigMap: "settings", Key: "timeout", Value: "30s"}},
+	} {
+		u, err := ParseValueUpdate(c.kind, c.input)
+		if err != nil {
+			t.Errorf("%q: %s", c.input, err)
+			continue
+		}
+		if u != c.expected {
+			t.Errorf("%q: expected %+v, got %+v", c.input, c.expected, u)
+		}
+		if u.String() != c.input {
+			t.Errorf("%q: round trip gave %q", c.input, u.String())
+		}
+	}
+
+	for _, c := range []struct {
+		kind  ValueKind
+		input string
+	}{
+		{ValueKindEnv, "NOEQUALS"},
+		{ValueKindEnv, "=value"},
+		{ValueKindEnv, "app:=value"},
+		{ValueKindConfigMap, "timeout=30s"},
+		{ValueKind("secret"), "password=hunter2"},
+	} {
+		if _, err := ParseValueUpdate(c.kind, c.input); err == nil {
+			t.Errorf("%q: expected error", c.input)
+		}
+	}
+}
+
+func TestReleaseSpecProblems(t *testing.T) {
+	valid := ReleaseSpec{
+		ServiceSpecs: []ServiceSpec{"default/helloworld", ServiceSpecAll},
+		ImageSpec:    "quay.io/weaveworks/helloworld:v2",
+		Kind:         ReleaseKindExecute,
+		Excludes:     []ServiceID{"default/other"},
+		ValueUpdates: []ValueUpdate{{Kind: ValueKindEnv, Key: "LOG_LEVEL", Value: "debug"}},
+	}
+	if problems := valid.Problems(); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	invalid := ReleaseSpec{
+		ServiceSpecs: []ServiceSpec{"helloworld"},
+		ImageSpec:    "quay.io/weaveworks/helloworld",
+		Kind:         "sometime",
+		Excludes:     []ServiceID{"other"},
+		ValueUpdates: []ValueUpdate{{Kind: ValueKindConfigMap, Key: "log.level"}},
+	}
+	var fields []string
+	for _, p := range invalid.Problems() {
+		fields = append(fields, p.Field)
+	}
+	expected := []string{"ServiceSpecs", "ImageSpec", "Kind", "Excludes", "ValueUpdates"}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Errorf("expected problems with %v, got %v", expected, fields)
+	}
+}
+
+func TestBlueGreenReleaseSpecProblems(t *testing.T) {
+	spec := ReleaseSpec{
+		ServiceSpecs: []ServiceSpec{"default/helloworld"},
+		ImageSpec:    ImageSpecLatest,
+		Kind:         ReleaseKindBlueGreen,
+	}
+	if problems := spec.Problems(); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	spec.ImageSpec = ImageSpecNone
+	spec.ValueUpdates = []ValueUpdate{{Kind: ValueKindEnv, Key: "LOG_LEVEL", Value: "debug"}}
+	var fields []string
+	for _, p := range spec.Problems() {
+		fields = append(fields, p.Field)
+	}
+	expected := []string{"Kind", "ValueUpdates"}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Errorf("expected problems with %v, got %v", expected, fields)
+	}
+}
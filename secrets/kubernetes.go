@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux"
+)
+
+// The annotation recording which instance a Kubernetes secret belongs
+// to, since instance IDs can't always be used in resource names.
+const instanceAnnotation = "flux.weave.works/instance"
+
+// Kubernetes keeps the secrets for each instance in a Kubernetes
+// Secret, one data item per secret.
+type Kubernetes struct {
+	client v1core.SecretInterface
+}
+
+// NewKubernetes makes a store that uses secrets in a namespace, e.g.,
+// `clientset.Core().Secrets(namespace)`.
+func NewKubernetes(client v1core.SecretInterface) *Kubernetes {
+	return &Kubernetes{client: client}
+}
+
+func secretName(inst flux.InstanceID) string {
+	sum := sha256.Sum256([]byte(inst))
+	return "flux-instance-" + hex.EncodeToString(sum[:10])
+}
+
+func (k *Kubernetes) Get(inst flux.InstanceID, name string) ([]byte, error) {
+	secret, err := k.client.Get(secretName(inst))
+	if k8serrors.IsNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (k *Kubernetes) Set(inst flux.InstanceID, name string, value []byte) error {
+	secret, err := k.client.Get(secretName(inst))
+	if k8serrors.IsNotFound(err) {
+		_, err = k.client.Create(&v1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        secretName(inst),
+				Annotations: map[string]string{instanceAnnotation: string(inst)},
+			},
+			Data: map[string][]byte{name: value},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[name] = value
+	_, err = k.client.Update(secret)
+	return err
+}
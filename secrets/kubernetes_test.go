@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"testing"
+
+	v1core "k8s.io/client-go/1.5/kubernetes/typed/core/v1"
+	k8serrors "k8s.io/client-go/1.5/pkg/api/errors"
+	"k8s.io/client-go/1.5/pkg/api/unversioned"
+	v1 "k8s.io/client-go/1.5/pkg/api/v1"
+
+	"github.com/weaveworks/flux"
+)
+
+// fakeSecrets keeps Kubernetes secrets in memory.
+type fakeSecrets struct {
+	v1core.SecretInterface
+	secrets map[string]v1.Secret
+}
+
+func (f *fakeSecrets) Get(name string) (*v1.Secret, error) {
+	secret, ok := f.secrets[name]
+	if !ok {
+		return nil, k8serrors.NewNotFound(unversioned.GroupResource{Resource: "secrets"}, name)
+	}
+	return &secret, nil
+}
+
+func (f *fakeSecrets) Create(secret *v1.Secret) (*v1.Secret, error) {
+	if _, ok := f.secrets[secret.Name]; ok {
+		return nil, k8serrors.NewAlreadyExists(unversioned.GroupResource{Resource: "secrets"}, secret.Name)
+	}
+	f.secrets[secret.Name] = *secret
+	return secret, nil
+}
+
+func (f *fakeSecrets) Update(secret *v1.Secret) (*v1.Secret, error) {
+	if _, ok := f.secrets[secret.Name]; !ok {
+		return nil, k8serrors.NewNotFound(unversioned.GroupResource{Resource: "secrets"}, secret.Name)
+	}
+	f.secrets[secret.Name] = *secret
+	return secret, nil
+}
+
+func TestKubernetes(t *testing.T) {
+	client := &fakeSecrets{secrets: map[string]v1.Secret{}}
+	store := NewKubernetes(client)
+	inst := flux.InstanceID("<default-instance>")
+
+	if _, err := store.Get(inst, DeployKey); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.Set(inst, DeployKey, []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	// A second secret goes in the same Kubernetes secret
+	if err := store.Set(inst, DeployKeyPassphrase, []byte("open sesame")); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.secrets) != 1 {
+		t.Fatalf("expected one Kubernetes secret for the instance, got %d", len(client.secrets))
+	}
+	for _, secret := range client.secrets {
+		if secret.Annotations[instanceAnnotation] != string(inst) {
+			t.Errorf("expected the secret to be annotated with the instance, got %v", secret.Annotations)
+		}
+	}
+
+	for name, expected := range map[string]string{
+		DeployKey:           "private key",
+		DeployKeyPassphrase: "open sesame",
+	} {
+		value, err := store.Get(inst, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, string(value))
+		}
+	}
+
+	if err := store.Set(inst, DeployKey, []byte("another key")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.Get(inst, DeployKey); string(value) != "another key" {
+		t.Errorf("expected the secret to be replaced, got %q", string(value))
+	}
+	if _, err := store.Get(flux.InstanceID("other"), DeployKey); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for another instance, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+// Package secrets provides places other than the instance config to
+// keep secrets, like the private key used to access the config repo.
+package secrets
+
+import (
+	"errors"
+
+	"github.com/weaveworks/flux"
+)
+
+// DeployKey is the name of the secret holding the private key used to
+// access an instance's config repo.
+const DeployKey = "deploy-key"
+
+// DeployKeyPassphrase is the name of the secret holding the
+// passphrase for the deploy key, if it's encrypted.
+const DeployKeyPassphrase = "deploy-key-passphrase"
+
+// ErrNotFound is returned when there is no such secret stored for an
+// instance.
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps named secrets for each instance.
+type Store interface {
+	Get(inst flux.InstanceID, name string) ([]byte, error)
+	Set(inst flux.InstanceID, name string, value []byte) error
+}
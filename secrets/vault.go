@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/weaveworks/flux"
+)
+
+// Vault keeps secrets in a HashiCorp Vault generic (key/value)
+// backend, at <path>/<instance>/<name>.
+type Vault struct {
+	addr   string
+	token  string
+	path   string
+	client *http.Client
+}
+
+func NewVault(client *http.Client, addr, token, path string) *Vault {
+	return &Vault{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		path:   strings.Trim(path, "/"),
+		client: client,
+	}
+}
+
+type vaultSecret struct {
+	Value string `json:"value"`
+}
+
+func (v *Vault) url(inst flux.InstanceID, name string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s", v.addr, v.path, url.QueryEscape(string(inst)), name)
+}
+
+func (v *Vault) do(method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return v.client.Do(req)
+}
+
+func (v *Vault) Get(inst flux.InstanceID, name string) ([]byte, error) {
+	resp, err := v.do("GET", v.url(inst, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("reading secret %s from vault: %s", name, resp.Status)
+	}
+
+	var secret struct {
+		Data vaultSecret `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding secret %s from vault: %s", name, err)
+	}
+	return []byte(secret.Data.Value), nil
+}
+
+func (v *Vault) Set(inst flux.InstanceID, name string, value []byte) error {
+	body, err := json.Marshal(vaultSecret{Value: string(value)})
+	if err != nil {
+		return err
+	}
+	resp, err := v.do("PUT", v.url(inst, name), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("writing secret %s to vault: %s", name, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+// A fake of the Vault generic backend, which checks the token.
+func fakeVault(t *testing.T, token string) *httptest.Server {
+	stored := map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			value, ok := stored[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]string{"value": value},
+			})
+		case "PUT":
+			var secret vaultSecret
+			if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+				t.Error(err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			stored[r.URL.Path] = secret.Value
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestVault(t *testing.T) {
+	server := fakeVault(t, "s3cr3t")
+	defer server.Close()
+
+	vault := NewVault(http.DefaultClient, server.URL, "s3cr3t", "/secret/flux/")
+	inst := flux.InstanceID("<default-instance>")
+
+	if _, err := vault.Get(inst, DeployKey); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := vault.Set(inst, DeployKey, []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := vault.Get(inst, DeployKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "private key" {
+		t.Fatalf("expected %q, got %q", "private key", string(value))
+	}
+	if _, err := vault.Get(flux.InstanceID("other"), DeployKey); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for another instance, got %v", err)
+	}
+
+	wrongToken := NewVault(http.DefaultClient, server.URL, "guess", "secret/flux")
+	if _, err := wrongToken.Get(inst, DeployKey); err == nil || err == ErrNotFound {
+		t.Fatalf("expected an error using the wrong token, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/platform"
+)
+
+const (
+	// DefaultInstancesLimit is the size of a page of instances, if
+	// the query doesn't say.
+	DefaultInstancesLimit = 100
+	// recentErrorsWindow is how far back errors in an instance's
+	// history count as recent; and recentEventsLimit how many of its
+	// most recent events are looked through for them.
+	recentErrorsWindow = 24 * time.Hour
+	recentEventsLimit  = 100
+)
+
+// Instances gives operators of a multi-tenant deployment a view across
+// all the instances, so they can find those in trouble. It's not part
+// of the API, which is always for one instance.
+type Instances struct {
+	config     instance.DB
+	messageBus platform.MessageBus
+	jobs       jobs.JobStore
+	history    history.DB // may be nil, in which case events aren't summarised
+	maxLookups chan struct{}
+}
+
+func NewInstances(config instance.DB, messageBus platform.MessageBus, jobs jobs.JobStore, history history.DB) *Instances {
+	return &Instances{
+		config:     config,
+		messageBus: messageBus,
+		jobs:       jobs,
+		history:    history,
+		maxLookups: make(chan struct{}, 8),
+	}
+}
+
+// ListInstances gives a summary of each instance that matches the
+// query, in order of ID, a page at a time.
+func (s *Instances) ListInstances(q flux.InstanceQuery) (flux.InstanceList, error) {
+	configs, err := s.config.All()
+	if err != nil {
+		return flux.InstanceList{}, errors.Wrap(err, "getting instances")
+	}
+	failed, err := s.jobs.CountFailedJobs()
+	if err != nil {
+		return flux.InstanceList{}, err
+	}
+
+	var ids []string
+	for _, c := range configs {
+		if strings.Contains(string(c.ID), q.Match) {
+			ids = append(ids, string(c.ID))
+		}
+	}
+	sort.Strings(ids)
+
+	summaries := make([]flux.InstanceSummary, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id flux.InstanceID) {
+			defer wg.Done()
+			s.maxLookups <- struct{}{}
+			defer func() { <-s.maxLookups }()
+			summaries[i], errs[i] = s.summarise(id, failed[id])
+		}(i, flux.InstanceID(id))
+	}
+	wg.Wait()
+
+	list := flux.InstanceList{Instances: []flux.InstanceSummary{}}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultInstancesLimit
+	}
+	for i, summary := range summaries {
+		if errs[i] != nil {
+			return flux.InstanceList{}, errs[i]
+		}
+		if q.Connected != nil && summary.Connected != *q.Connected {
+			continue
+		}
+		if q.Troubled && !summary.Troubled() {
+			continue
+		}
+		if list.Total >= q.Offset && len(list.Instances) < limit {
+			list.Instances = append(list.Instances, summary)
+		}
+		list.Total++
+	}
+	return list, nil
+}
+
+func (s *Instances) summarise(id flux.InstanceID, failedJobs int) (flux.InstanceSummary, error) {
+	summary := flux.InstanceSummary{
+		ID:         id,
+		Connected:  s.messageBus.Ping(id) == nil,
+		FailedJobs: failedJobs,
+	}
+	if s.history == nil {
+		return summary, nil
+	}
+
+	now := time.Now().UTC()
+	events, err := s.history.AllEvents(id, now, recentEventsLimit)
+	if err != nil {
+		return summary, errors.Wrapf(err, "getting history of %s", id)
+	}
+	// Events come most recently recorded first. When they were
+	// recorded is by our clock, so it can be compared with now.
+	if len(events) > 0 {
+		last := events[0].ReceivedAt
+		summary.LastEvent = &last
+	}
+	for _, e := range events {
+		if e.ReceivedAt.Before(now.Add(-recentErrorsWindow)) {
+			break
+		}
+		if e.LogLevel == flux.LogLevelError {
+			summary.RecentErrors++
+		}
+	}
+	return summary, nil
+}
@@ -12,4 +12,10 @@ var (
 		Name:      "connected_daemons_count",
 		Help:      "Gauge of the current number of connected daemons",
 	}, []string{})
+	syncNotifications = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "fluxsvc",
+		Name:      "sync_notifications_total",
+		Help:      "Count of notifications that the config repo has changed, and whether they were coalesced with an earlier one",
+	}, []string{"coalesced"})
 )
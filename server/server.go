@@ -1,7 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"path"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -10,11 +14,17 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/automator"
+	"github.com/weaveworks/flux/checkpoint"
 	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/history"
 	"github.com/weaveworks/flux/instance"
 	"github.com/weaveworks/flux/jobs"
+	"github.com/weaveworks/flux/layout"
 	"github.com/weaveworks/flux/platform"
 	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/release"
+	"github.com/weaveworks/flux/tracing"
 )
 
 const (
@@ -31,6 +41,10 @@ type Server struct {
 	config      instance.DB
 	messageBus  platform.MessageBus
 	jobs        jobs.JobStore
+	checker     *checkpoint.Checker // may be nil, if checking is disabled
+	history     history.DB          // for history across instances; may be nil
+	groups      history.Groups      // which instances may see each other's history
+	syncWindow  time.Duration       // notifications within this window are coalesced
 	logger      log.Logger
 	maxPlatform chan struct{} // semaphore for concurrent calls to the platform
 	connected   int32
@@ -42,6 +56,10 @@ func New(
 	config instance.DB,
 	messageBus platform.MessageBus,
 	jobs jobs.JobStore,
+	checker *checkpoint.Checker,
+	history history.DB,
+	groups history.Groups,
+	syncWindow time.Duration,
 	logger log.Logger,
 ) *Server {
 	connectedDaemons.Set(0)
@@ -51,6 +69,10 @@ func New(
 		config:      config,
 		messageBus:  messageBus,
 		jobs:        jobs,
+		checker:     checker,
+		history:     history,
+		groups:      groups,
+		syncWindow:  syncWindow,
 		logger:      logger,
 		maxPlatform: make(chan struct{}, 8),
 	}
@@ -62,8 +84,8 @@ func New(
 // same reason: let's not add abstraction until it's merged, or nearly so, and
 // it's clear where the abstraction should exist.
 
-func (s *Server) Status(inst flux.InstanceID) (res flux.Status, err error) {
-	helper, err := s.instancer.Get(inst)
+func (s *Server) Status(ctx context.Context, inst flux.InstanceID) (res flux.Status, err error) {
+	helper, err := s.instance(ctx, inst)
 	if err != nil {
 		return res, errors.Wrapf(err, "getting instance")
 	}
@@ -73,21 +95,82 @@ func (s *Server) Status(inst flux.InstanceID) (res flux.Status, err error) {
 		return res, errors.Wrapf(err, "getting config for %s", inst)
 	}
 	res.Git.Configured = config.Settings.Git.URL != "" && config.Settings.Git.Key != ""
+	res.Git.ReadOnly = config.Settings.Git.ReadOnly
 
 	if _, err := helper.ConfigRepo().Clone(); err != nil {
 		// Remove \r, so it prints as a yaml block
 		res.Git.Error = strings.Replace(err.Error(), "\r", "", -1)
+		res.Git.ErrorKind = string(git.KindOf(err))
+	}
+	if p := config.BranchProtection; p != nil && p.Branch == helper.ConfigRepo().Branch {
+		res.Git.Protection = p.Conflicts
+	}
+	if config.LastSync != nil {
+		res.Git.LastSync = config.LastSync
+		res.Git.SinceLastSync = (time.Since(config.LastSync.At) / time.Second * time.Second).String()
+	}
+	if config.Paused != nil {
+		res.Automation.Paused = true
+		res.Automation.Pause = config.Paused
 	}
 
-	res.Fluxsvc = flux.FluxsvcStatus{Version: s.version}
+	res.Fluxsvc = flux.FluxsvcStatus{
+		Version:    s.version,
+		Checkpoint: s.checker.Latest(),
+	}
 	res.Fluxd.Version, err = helper.Version()
 	res.Fluxd.Connected = (err == nil)
+	if open, ok := platform.BreakerOpenFrom(err); ok {
+		res.Fluxd.Unavailable = true
+		res.Fluxd.UnavailableUntil = &open.Until
+	}
 
 	return res, nil
 }
 
-func (s *Server) ListServices(inst flux.InstanceID, namespace string) (res []flux.ServiceStatus, err error) {
-	helper, err := s.instancer.Get(inst)
+// Announce records the result of a version check as an event for
+// every instance, so it shows up in each instance's history.
+func (s *Server) Announce(status flux.CheckpointStatus) {
+	configs, err := s.config.All()
+	if err != nil {
+		s.logger.Log("err", errors.Wrap(err, "getting all instances for announcement"))
+		return
+	}
+
+	level := flux.LogLevelInfo
+	var messages []string
+	if status.Outdated {
+		messages = append(messages, fmt.Sprintf("A newer version of flux (%s) is available.", status.LatestVersion))
+	}
+	for _, alert := range status.Alerts {
+		if alert.Level == flux.LogLevelWarn || alert.Level == flux.LogLevelError {
+			level = flux.LogLevelWarn
+		}
+		messages = append(messages, alert.Message)
+	}
+
+	now := time.Now().UTC()
+	for _, c := range configs {
+		inst, err := s.instancer.Get(c.ID)
+		if err != nil {
+			s.logger.Log("instance", c.ID, "err", err)
+			continue
+		}
+		if err := inst.LogEvent(flux.Event{
+			Type:      flux.EventCheckpoint,
+			StartedAt: now,
+			EndedAt:   now,
+			LogLevel:  level,
+			Message:   strings.Join(messages, " "),
+			Metadata:  status,
+		}); err != nil {
+			s.logger.Log("instance", c.ID, "err", err)
+		}
+	}
+}
+
+func (s *Server) ListServices(ctx context.Context, inst flux.InstanceID, namespace string) (res []flux.ServiceStatus, err error) {
+	helper, err := s.instance(ctx, inst)
 	if err != nil {
 		return nil, errors.Wrapf(err, "getting instance")
 	}
@@ -112,6 +195,10 @@ func (s *Server) ListServices(inst flux.InstanceID, namespace string) (res []flu
 			Status:     service.Status,
 			Automated:  config.Services[service.ID].Automated,
 			Locked:     config.Services[service.ID].Locked,
+
+			MinReleaseInterval: config.Services[service.ID].MinReleaseInterval,
+			TagFilter:          config.Services[service.ID].TagFilter,
+			Labels:             service.Labels,
 		})
 	}
 	return res, nil
@@ -131,8 +218,11 @@ func containers2containers(cs []platform.Container) []flux.Container {
 	return res
 }
 
-func (s *Server) ListImages(inst flux.InstanceID, spec flux.ServiceSpec) (res []flux.ImageStatus, err error) {
-	helper, err := s.instancer.Get(inst)
+// ListImages returns the images for the services selected by spec. If
+// any filters are given, only services meeting all of them are
+// included.
+func (s *Server) ListImages(ctx context.Context, inst flux.InstanceID, spec flux.ServiceSpec, only []flux.ImageStatusFilter) (res []flux.ImageStatus, err error) {
+	helper, err := s.instance(ctx, inst)
 	if err != nil {
 		return nil, errors.Wrapf(err, "getting instance")
 	}
@@ -140,20 +230,61 @@ func (s *Server) ListImages(inst flux.InstanceID, spec flux.ServiceSpec) (res []
 	var services []platform.Service
 	if spec == flux.ServiceSpecAll {
 		services, err = helper.GetAllServices("")
+	} else if selector, ok := spec.AsSelector(); ok {
+		services, err = helper.GetServicesMatching(selector)
 	} else {
-		id, err := spec.AsID()
+		var id flux.ServiceID
+		id, err = spec.AsID()
 		if err != nil {
 			return nil, errors.Wrap(err, "treating service spec as ID")
 		}
 		services, err = helper.GetServices([]flux.ServiceID{id})
 	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting services from platform")
+	}
 
 	images, err := helper.CollectAvailableImages(services)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting images for services")
 	}
+	for repo, available := range images {
+		// Not being able to check signatures shouldn't stop us
+		// listing the images.
+		if err := helper.CheckSignatures(repo, available); err != nil {
+			helper.Log("err", errors.Wrapf(err, "checking signatures for %s", repo))
+		}
+		if err := helper.CheckPlatforms(repo, available); err != nil {
+			helper.Log("err", errors.Wrapf(err, "checking platforms for %s", repo))
+		}
+	}
+
+	var config instance.Config
+	if len(only) > 0 {
+		config, err = helper.GetConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting config for %s", inst)
+		}
+	}
+
+	// Whether a service is stale depends on the images automation
+	// would release, so leave out unsigned images as it does, without
+	// losing them from the listing.
+	releasable := images
+	if includesFilter(only, flux.ImageStatusStale) {
+		releasable = instance.ImageMap{}
+		for repo, available := range images {
+			releasable[repo] = available
+		}
+		if err := helper.OnlySigned(releasable); err != nil {
+			return nil, errors.Wrap(err, "checking image signatures")
+		}
+	}
 
 	for _, service := range services {
+		if !matchesImageStatusFilters(service, config.Services[service.ID], releasable, only) {
+			continue
+		}
 		containers := containersWithAvailable(service, images)
 		res = append(res, flux.ImageStatus{
 			ID:         service.ID,
@@ -164,6 +295,51 @@ func (s *Server) ListImages(inst flux.InstanceID, spec flux.ServiceSpec) (res []
 	return res, nil
 }
 
+func matchesImageStatusFilters(service platform.Service, conf instance.ServiceConfig, images instance.ImageMap, only []flux.ImageStatusFilter) bool {
+	for _, f := range only {
+		switch f {
+		case flux.ImageStatusAutomated:
+			if !conf.Automated {
+				return false
+			}
+		case flux.ImageStatusLocked:
+			if !conf.Locked {
+				return false
+			}
+		case flux.ImageStatusStale:
+			if !isStale(service, conf, images) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func includesFilter(only []flux.ImageStatusFilter, filter flux.ImageStatusFilter) bool {
+	for _, f := range only {
+		if f == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// isStale says whether any of the service's containers is running an
+// image other than the latest that automation would release to it,
+// i.e., the latest matching the service's tag filter.
+func isStale(service platform.Service, conf instance.ServiceConfig, images instance.ImageMap) bool {
+	for _, c := range service.ContainersOrNil() {
+		id, err := flux.ParseImageID(c.Image)
+		if err != nil {
+			continue
+		}
+		if latest := images.LatestImageMatching(id.Repository(), conf.TagFilter); latest != nil && latest.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
 func containersWithAvailable(service platform.Service, images instance.ImageMap) (res []flux.Container) {
 	for _, c := range service.ContainersOrNil() {
 		id, _ := flux.ParseImageID(c.Image)
@@ -180,45 +356,172 @@ func containersWithAvailable(service platform.Service, images instance.ImageMap)
 	return res
 }
 
-func (s *Server) History(inst flux.InstanceID, spec flux.ServiceSpec, before time.Time, limit int64) (res []flux.HistoryEntry, err error) {
-	helper, err := s.instancer.Get(inst)
+// History gives a page of the history of the service, or of every
+// service, along with the total number of entries of the types asked
+// for.
+func (s *Server) History(ctx context.Context, inst flux.InstanceID, spec flux.ServiceSpec, query flux.HistoryQuery) (flux.HistoryPage, error) {
+	if problems := query.Problems(); len(problems) > 0 {
+		return flux.HistoryPage{}, flux.InvalidHistoryQuery(problems)
+	}
+	helper, err := s.instance(ctx, inst)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting instance")
+		return flux.HistoryPage{}, errors.Wrapf(err, "getting instance")
 	}
 
-	var events []flux.Event
-	if spec == flux.ServiceSpecAll {
-		events, err = helper.AllEvents(before, limit)
-		if err != nil {
-			return nil, errors.Wrap(err, "fetching all history events")
-		}
-	} else {
+	q := history.EventQuery{
+		Before: query.Before,
+		Types:  query.Types,
+		Limit:  query.Limit,
+	}
+	if spec != flux.ServiceSpecAll {
 		id, err := flux.ParseServiceID(string(spec))
 		if err != nil {
-			return nil, errors.Wrapf(err, "parsing service ID from spec %s", spec)
+			return flux.HistoryPage{}, errors.Wrapf(err, "parsing service ID from spec %s", spec)
 		}
+		q.Service = id
+	}
+	// The total is of every page, so it's counted before the cursors
+	// are used.
+	total, err := helper.CountEvents(q)
+	if err != nil {
+		return flux.HistoryPage{}, errors.Wrapf(err, "counting history events for %s", spec)
+	}
+	// These have been checked above
+	if query.BeforeCursor != "" {
+		q.BeforeSequence, _ = flux.ParseHistoryCursor(query.BeforeCursor)
+	}
+	if query.AfterCursor != "" {
+		q.AfterSequence, _ = flux.ParseHistoryCursor(query.AfterCursor)
+	}
+	events, err := helper.Events(q)
+	if err != nil {
+		return flux.HistoryPage{}, errors.Wrapf(err, "fetching history events for %s", spec)
+	}
+	return flux.HistoryPage{Entries: historyEntries(events), Total: total}, nil
+}
 
-		events, err = helper.EventsForService(id, before, limit)
-		if err != nil {
-			return nil, errors.Wrapf(err, "fetching history events for %s", id)
-		}
+// HistoryAllClusters gives the history of a service in every instance
+// grouped with the instance given, so that (for example) the releases
+// of a service to each cluster of an organisation can be compared.
+// Since it shows events from other instances, the groups are given
+// when running the service; an instance in no group can't use it.
+func (s *Server) HistoryAllClusters(ctx context.Context, inst flux.InstanceID, service flux.ServiceID, before time.Time, limit int64) ([]flux.HistoryEntry, error) {
+	peers := s.groups.Peers(inst)
+	if s.history == nil || len(peers) == 0 {
+		return nil, errors.New("history across clusters is not enabled for this instance")
+	}
+	if _, err := s.instance(ctx, inst); err != nil {
+		return nil, errors.Wrapf(err, "getting instance")
 	}
+	events, err := s.history.EventsForServiceInstances(peers, service, before, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching history events for %s", service)
+	}
+	return historyEntries(events), nil
+}
 
-	res = make([]flux.HistoryEntry, len(events))
+func historyEntries(events []flux.Event) []flux.HistoryEntry {
+	res := make([]flux.HistoryEntry, len(events))
 	for i, event := range events {
 		res[i] = flux.HistoryEntry{
-			Stamp: &events[i].StartedAt,
-			Type:  "v0",
-			Data:  event.String(),
-			Event: &events[i],
+			Stamp:   &events[i].StartedAt,
+			Type:    "v0",
+			Data:    event.String(),
+			Cluster: event.Cluster,
+			Event:   &events[i],
+		}
+		if event.Sequence > 0 {
+			res[i].Cursor = flux.HistoryCursor(event.Sequence)
 		}
 	}
+	return res
+}
 
-	return res, nil
+// ReleaseHistory gives the images the service has run, derived from
+// the releases in its history.
+func (s *Server) ReleaseHistory(ctx context.Context, inst flux.InstanceID, service flux.ServiceID) ([]flux.ServiceVersion, error) {
+	helper, err := s.instance(ctx, inst)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting instance")
+	}
+
+	events, err := helper.EventsForService(service, time.Now().UTC(), -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching history events for %s", service)
+	}
+	return history.ServiceVersions(service, events), nil
 }
 
-func (s *Server) Automate(instID flux.InstanceID, service flux.ServiceID) error {
-	inst, err := s.instancer.Get(instID)
+// ServiceChanges says who last changed each policy of the service, and
+// the image of each of its containers. Policy changes come from the
+// events recorded for the service; image changes from git blame on
+// its resource definition, attributed to the releases that made the
+// commits where there were any.
+func (s *Server) ServiceChanges(ctx context.Context, instID flux.InstanceID, service flux.ServiceID) ([]flux.ServiceChange, error) {
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting instance")
+	}
+
+	events, err := inst.EventsForService(service, time.Now().UTC(), -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching history events for %s", service)
+	}
+
+	rc := release.NewReleaseContext(inst)
+	defer rc.Clean()
+	if err := rc.CloneRepo(); err != nil {
+		return nil, errors.Wrap(err, "cloning config repo")
+	}
+	images, err := rc.ImageChanges(service)
+	if err != nil {
+		return nil, errors.Wrapf(err, "finding image changes for %s", service)
+	}
+	history.AttributeToReleases(images, events)
+	return append(history.PolicyChanges(events), images...), nil
+}
+
+// Lint reports problems with the resource definitions at the head of
+// the instance's config repo.
+func (s *Server) Lint(ctx context.Context, instID flux.InstanceID) (flux.LintReport, error) {
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return flux.LintReport{}, errors.Wrapf(err, "getting instance")
+	}
+
+	rc := release.NewReleaseContext(inst)
+	defer rc.Clean()
+	if err := rc.CloneRepo(); err != nil {
+		return flux.LintReport{}, errors.Wrap(err, "cloning config repo")
+	}
+	report, err := rc.Lint()
+	if err != nil {
+		return flux.LintReport{}, errors.Wrap(err, "linting resource definitions")
+	}
+	return report, nil
+}
+
+// SyncNotify tells flux that the config repo has changed, so
+// automated services should be checked now rather than waiting for the
+// next cycle. A burst of notifications results in a single check; but
+// a notification while the check is running queues another, since the
+// running check may not see the change.
+func (s *Server) SyncNotify(ctx context.Context, inst flux.InstanceID) error {
+	_, err := s.jobs.PutJobUnlessQueued(inst, automator.SyncNotifyJob(inst, time.Now(), s.syncWindow))
+	switch err {
+	case nil:
+		syncNotifications.With("coalesced", "false").Add(1)
+		return nil
+	case jobs.ErrJobAlreadyQueued:
+		syncNotifications.With("coalesced", "true").Add(1)
+		return nil
+	default:
+		return errors.Wrap(err, "queueing sync job")
+	}
+}
+
+func (s *Server) Automate(ctx context.Context, instID flux.InstanceID, service flux.ServiceID) error {
+	inst, err := s.instance(ctx, instID)
 	if err != nil {
 		return err
 	}
@@ -235,8 +538,8 @@ func (s *Server) Automate(instID flux.InstanceID, service flux.ServiceID) error
 	return recordAutomated(inst, service, true)
 }
 
-func (s *Server) Deautomate(instID flux.InstanceID, service flux.ServiceID) error {
-	inst, err := s.instancer.Get(instID)
+func (s *Server) Deautomate(ctx context.Context, instID flux.InstanceID, service flux.ServiceID) error {
+	inst, err := s.instance(ctx, instID)
 	if err != nil {
 		return err
 	}
@@ -267,8 +570,8 @@ func recordAutomated(inst *instance.Instance, service flux.ServiceID, automated
 	})
 }
 
-func (s *Server) Lock(instID flux.InstanceID, service flux.ServiceID) error {
-	inst, err := s.instancer.Get(instID)
+func (s *Server) Lock(ctx context.Context, instID flux.InstanceID, service flux.ServiceID) error {
+	inst, err := s.instance(ctx, instID)
 	if err != nil {
 		return err
 	}
@@ -285,8 +588,8 @@ func (s *Server) Lock(instID flux.InstanceID, service flux.ServiceID) error {
 	return recordLock(inst, service, true)
 }
 
-func (s *Server) Unlock(instID flux.InstanceID, service flux.ServiceID) error {
-	inst, err := s.instancer.Get(instID)
+func (s *Server) Unlock(ctx context.Context, instID flux.InstanceID, service flux.ServiceID) error {
+	inst, err := s.instance(ctx, instID)
 	if err != nil {
 		return err
 	}
@@ -320,7 +623,174 @@ func recordLock(inst *instance.Instance, service flux.ServiceID, locked bool) er
 	return nil
 }
 
-func (s *Server) PostRelease(inst flux.InstanceID, params jobs.ReleaseJobParams) (jobs.JobID, error) {
+// SetMinReleaseInterval limits how often automation will release the
+// service; an interval of zero removes the limit.
+func (s *Server) SetMinReleaseInterval(ctx context.Context, instID flux.InstanceID, service flux.ServiceID, interval time.Duration) error {
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Minimum release interval removed: %s", service)
+	if interval > 0 {
+		message = fmt.Sprintf("Minimum release interval set to %s: %s", interval, service)
+	}
+	now := time.Now().UTC()
+	if err := inst.LogEvent(flux.Event{
+		ServiceIDs: []flux.ServiceID{service},
+		Type:       flux.EventThrottle,
+		StartedAt:  now,
+		EndedAt:    now,
+		LogLevel:   flux.LogLevelInfo,
+		Message:    message,
+	}); err != nil {
+		return err
+	}
+	return inst.UpdateConfig(func(conf instance.Config) (instance.Config, error) {
+		if serviceConf, found := conf.Services[service]; found {
+			serviceConf.MinReleaseInterval = interval
+			conf.Services[service] = serviceConf
+		} else if interval > 0 {
+			conf.Services[service] = instance.ServiceConfig{
+				MinReleaseInterval: interval,
+			}
+		}
+		return conf, nil
+	})
+}
+
+// SetTagFilter restricts the images automation will release to the
+// service to those with tags matching the pattern given; a blank
+// pattern removes the restriction.
+func (s *Server) SetTagFilter(ctx context.Context, instID flux.InstanceID, service flux.ServiceID, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.Wrapf(err, "tag filter %q", pattern)
+	}
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Tag filter removed: %s", service)
+	if pattern != "" {
+		message = fmt.Sprintf("Tag filter set to %q: %s", pattern, service)
+	}
+	now := time.Now().UTC()
+	if err := inst.LogEvent(flux.Event{
+		ServiceIDs: []flux.ServiceID{service},
+		Type:       flux.EventTagFilter,
+		StartedAt:  now,
+		EndedAt:    now,
+		LogLevel:   flux.LogLevelInfo,
+		Message:    message,
+	}); err != nil {
+		return err
+	}
+	return inst.UpdateConfig(func(conf instance.Config) (instance.Config, error) {
+		if serviceConf, found := conf.Services[service]; found {
+			serviceConf.TagFilter = pattern
+			conf.Services[service] = serviceConf
+		} else if pattern != "" {
+			conf.Services[service] = instance.ServiceConfig{
+				TagFilter: pattern,
+			}
+		}
+		return conf, nil
+	})
+}
+
+// Pause stops all automation of the instance, straight away, until
+// it's resumed. Automated releases already queued don't go ahead.
+func (s *Server) Pause(ctx context.Context, instID flux.InstanceID, user, reason string) error {
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return err
+	}
+	message := "Automation paused"
+	if user != "" {
+		message += fmt.Sprintf(" by %s", user)
+	}
+	if reason != "" {
+		message += fmt.Sprintf(": %s", reason)
+	}
+	now := time.Now().UTC()
+	if err := inst.LogEvent(flux.Event{
+		Type:      flux.EventPause,
+		StartedAt: now,
+		EndedAt:   now,
+		LogLevel:  flux.LogLevelWarn,
+		Message:   message,
+	}); err != nil {
+		return err
+	}
+	return inst.UpdateConfig(func(conf instance.Config) (instance.Config, error) {
+		conf.Paused = &flux.Pause{
+			User:   user,
+			Reason: reason,
+			Since:  now,
+		}
+		return conf, nil
+	})
+}
+
+// Resume lets automation of the instance carry on, if it was paused.
+func (s *Server) Resume(ctx context.Context, instID flux.InstanceID, user string) error {
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return err
+	}
+	config, err := inst.GetConfig()
+	if err != nil {
+		return err
+	}
+	if config.Paused == nil {
+		return nil
+	}
+	message := "Automation resumed"
+	if user != "" {
+		message += fmt.Sprintf(" by %s", user)
+	}
+	now := time.Now().UTC()
+	if err := inst.LogEvent(flux.Event{
+		Type:      flux.EventResume,
+		StartedAt: now,
+		EndedAt:   now,
+		LogLevel:  flux.LogLevelInfo,
+		Message:   message,
+	}); err != nil {
+		return err
+	}
+	return inst.UpdateConfig(func(conf instance.Config) (instance.Config, error) {
+		conf.Paused = nil
+		return conf, nil
+	})
+}
+
+// errUserAutomated is for releases asked for as automation, which
+// would otherwise skip the checks made of a person's releases.
+var errUserAutomated = flux.UserConfigProblem{BaseError: &flux.BaseError{
+	Help: `Release not permitted
+
+The release was asked for as ` + flux.UserAutomated + `, which is reserved for
+releases made by automation. Please give your own username.
+`,
+	Err: fmt.Errorf("releases cannot be asked for as %s", flux.UserAutomated),
+}}
+
+// PostRelease queues a release. The user of a release is the one the
+// request's credentials belong to, if that's known; otherwise it's as
+// given, and not to be trusted.
+func (s *Server) PostRelease(ctx context.Context, inst flux.InstanceID, params jobs.ReleaseJobParams) (jobs.JobID, error) {
+	if params.Cause.User == flux.UserAutomated {
+		return "", errUserAutomated
+	}
+	params.Cause.Authenticated = false
+	if user, ok := flux.UserFromContext(ctx); ok {
+		params.Cause.User = user
+		params.Cause.Authenticated = true
+	}
+	params.Trace = nil
+	if trace, ok := tracing.FromContext(ctx); ok {
+		params.Trace = &trace
+	}
 	return s.jobs.PutJob(inst, jobs.Job{
 		Queue:    jobs.ReleaseJob,
 		Method:   jobs.ReleaseJob,
@@ -329,7 +799,222 @@ func (s *Server) PostRelease(inst flux.InstanceID, params jobs.ReleaseJobParams)
 	})
 }
 
-func (s *Server) GetRelease(inst flux.InstanceID, id jobs.JobID) (jobs.Job, error) {
+// ListReleaseTemplates gives the instance's release templates, in
+// order of name.
+func (s *Server) ListReleaseTemplates(ctx context.Context, instID flux.InstanceID) ([]flux.ReleaseTemplate, error) {
+	config, err := s.config.GetConfig(instID)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range config.ReleaseTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	templates := []flux.ReleaseTemplate{}
+	for _, name := range names {
+		templates = append(templates, config.ReleaseTemplates[name])
+	}
+	return templates, nil
+}
+
+// SetReleaseTemplate saves the template, replacing any of the same
+// name.
+func (s *Server) SetReleaseTemplate(ctx context.Context, instID flux.InstanceID, template flux.ReleaseTemplate) error {
+	if problems := template.Problems(); len(problems) > 0 {
+		return flux.InvalidReleaseTemplate(problems)
+	}
+	return s.config.UpdateConfig(instID, func(config instance.Config) (instance.Config, error) {
+		if config.ReleaseTemplates == nil {
+			config.ReleaseTemplates = map[string]flux.ReleaseTemplate{}
+		}
+		config.ReleaseTemplates[template.Name] = template
+		return config, nil
+	})
+}
+
+func (s *Server) DeleteReleaseTemplate(ctx context.Context, instID flux.InstanceID, name string) error {
+	return s.config.UpdateConfig(instID, func(config instance.Config) (instance.Config, error) {
+		if _, ok := config.ReleaseTemplates[name]; !ok {
+			return config, flux.NoSuchReleaseTemplate(name)
+		}
+		delete(config.ReleaseTemplates, name)
+		return config, nil
+	})
+}
+
+// PostReleaseFromTemplate queues a release of the template's spec,
+// with the cause made from its message.
+func (s *Server) PostReleaseFromTemplate(ctx context.Context, instID flux.InstanceID, name, user string) (jobs.JobID, error) {
+	config, err := s.config.GetConfig(instID)
+	if err != nil {
+		return "", err
+	}
+	template, ok := config.ReleaseTemplates[name]
+	if !ok {
+		return "", flux.NoSuchReleaseTemplate(name)
+	}
+	if user == flux.UserAutomated {
+		return "", errUserAutomated
+	}
+	if authenticated, ok := flux.UserFromContext(ctx); ok {
+		user = authenticated
+	}
+	cause, err := template.Cause(user, time.Now().UTC())
+	if err != nil {
+		return "", errors.Wrapf(err, "making the message from release template %q", name)
+	}
+	return s.PostRelease(ctx, instID, jobs.ReleaseJobParams{
+		ReleaseSpec: template.Spec,
+		Cause:       cause,
+	})
+}
+
+// ValidateRelease checks a release spec without running the release:
+// that it's well-formed, that the services it names are running and
+// not locked, and that the image it asks for exists and is used by
+// those services. It doesn't look at the config repo, so a release
+// that passes may still fail, e.g., if a service isn't defined there.
+func (s *Server) ValidateRelease(ctx context.Context, instID flux.InstanceID, spec flux.ReleaseSpec) ([]flux.ReleaseProblem, error) {
+	if problems := spec.Problems(); len(problems) > 0 {
+		return problems, nil
+	}
+
+	inst, err := s.instance(ctx, instID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting instance")
+	}
+	config, err := inst.GetConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config for %s", instID)
+	}
+
+	var problems []flux.ReleaseProblem
+	excluded := flux.ServiceIDSet{}
+	excluded.Add(spec.Excludes)
+	var ids []flux.ServiceID
+	for _, serviceSpec := range spec.ServiceSpecs {
+		switch serviceSpec {
+		case flux.ServiceSpecAll:
+		case flux.ServiceSpecAutomated:
+			var automated bool
+			for _, conf := range config.Services {
+				automated = automated || conf.Policy() == flux.PolicyAutomated
+			}
+			if !automated {
+				problems = append(problems, flux.ReleaseProblem{Field: "ServiceSpecs", Message: "no services are automated"})
+			}
+		default:
+			specIDs := []flux.ServiceID{}
+			if selector, ok := serviceSpec.AsSelector(); ok {
+				matching, err := inst.GetServicesMatching(selector)
+				if err != nil {
+					return nil, errors.Wrap(err, "fetching services")
+				}
+				if len(matching) == 0 {
+					problems = append(problems, flux.ReleaseProblem{Field: "ServiceSpecs", Message: fmt.Sprintf("no services match %s", serviceSpec)})
+				}
+				for _, service := range matching {
+					specIDs = append(specIDs, service.ID)
+				}
+			} else {
+				id, _ := serviceSpec.AsID()
+				specIDs = append(specIDs, id)
+			}
+			for _, id := range specIDs {
+				if excluded.Contains(id) {
+					continue
+				}
+				ids = append(ids, id)
+				if config.Services[id].Locked {
+					problems = append(problems, flux.ReleaseProblem{Field: "ServiceSpecs", Service: id, Message: release.Locked})
+				}
+			}
+		}
+	}
+
+	var services []platform.Service
+	if len(ids) > 0 {
+		if services, err = inst.GetServices(ids); err != nil {
+			return nil, errors.Wrap(err, "fetching services")
+		}
+	}
+	found := flux.ServiceIDSet{}
+	for _, service := range services {
+		found.Add([]flux.ServiceID{service.ID})
+	}
+	for _, id := range ids {
+		if !found.Contains(id) {
+			problems = append(problems, flux.ReleaseProblem{Field: "ServiceSpecs", Service: id, Message: release.NotInCluster})
+		}
+	}
+
+	switch spec.ImageSpec {
+	case flux.ImageSpecNone:
+	case flux.ImageSpecLatest:
+		if _, err := inst.CollectAvailableImages(services); err != nil {
+			problems = append(problems, flux.ReleaseProblem{Field: "ImageSpec", Message: err.Error()})
+		}
+	default:
+		image, _ := spec.ImageSpec.AsID()
+		if _, err := inst.ExactImages([]flux.ImageID{image}); err != nil {
+			problems = append(problems, flux.ReleaseProblem{Field: "ImageSpec", Message: err.Error()})
+		}
+		for _, service := range services {
+			var uses bool
+			for _, container := range service.ContainersOrNil() {
+				current, err := flux.ParseImageID(container.Image)
+				uses = uses || (err == nil && current.Repository() == image.Repository())
+			}
+			if !uses {
+				problems = append(problems, flux.ReleaseProblem{Field: "ImageSpec", Service: service.ID, Message: "does not use images from " + image.Repository()})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Waiting for a release to move on is done by looking at the job this
+// often, for no longer than the maximum, however long is asked for.
+const (
+	releaseWaitInterval = 500 * time.Millisecond
+	maxReleaseWait      = time.Minute
+)
+
+// GetRelease gives the release job with the ID given. If wait is more
+// than zero, it waits up to that long for the job to be finished, or
+// to have moved on (its status or log changed), before giving it; so
+// that clients following a release can ask once per change, rather
+// than polling.
+func (s *Server) GetRelease(ctx context.Context, inst flux.InstanceID, id jobs.JobID, wait time.Duration) (jobs.Job, error) {
+	j, err := s.getRelease(inst, id)
+	if err != nil || wait <= 0 {
+		return j, err
+	}
+	if wait > maxReleaseWait {
+		wait = maxReleaseWait
+	}
+	deadline := time.Now().Add(wait)
+	for !j.Done && time.Now().Before(deadline) {
+		select {
+		case <-time.After(releaseWaitInterval):
+		case <-ctx.Done():
+			// Give what we have, rather than nothing
+			return j, nil
+		}
+		next, err := s.getRelease(inst, id)
+		if err != nil {
+			return next, err
+		}
+		if next.Done || next.Status != j.Status || len(next.Log) != len(j.Log) || next.Claimed != j.Claimed {
+			return next, nil
+		}
+		j = next
+	}
+	return j, nil
+}
+
+func (s *Server) getRelease(inst flux.InstanceID, id jobs.JobID) (jobs.Job, error) {
 	j, err := s.jobs.GetJob(inst, id)
 	if err != nil {
 		return jobs.Job{}, err
@@ -340,7 +1025,36 @@ func (s *Server) GetRelease(inst flux.InstanceID, id jobs.JobID) (jobs.Job, erro
 	return j, err
 }
 
-func (s *Server) GetConfig(instID flux.InstanceID, fingerprint string) (flux.InstanceConfig, error) {
+func (s *Server) ListFailedJobs(ctx context.Context, inst flux.InstanceID) ([]jobs.Job, error) {
+	return s.jobs.FailedJobs(inst)
+}
+
+func (s *Server) RequeueJob(ctx context.Context, inst flux.InstanceID, id jobs.JobID) error {
+	return s.jobs.RequeueJob(inst, id)
+}
+
+func (s *Server) AbandonJob(ctx context.Context, inst flux.InstanceID, id jobs.JobID) error {
+	return s.jobs.AbandonJob(inst, id)
+}
+
+// JobLog gives the detailed output of a job, or for jobs which don't
+// record any, the log of its statuses.
+func (s *Server) JobLog(ctx context.Context, inst flux.InstanceID, id jobs.JobID) ([]string, error) {
+	j, err := s.jobs.GetJob(inst, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(j.Output) == 0 {
+		return j.Log, nil
+	}
+	return j.Output, nil
+}
+
+func (s *Server) PurgeFailedJobs(ctx context.Context, inst flux.InstanceID) (int64, error) {
+	return s.jobs.PurgeFailedJobs(inst)
+}
+
+func (s *Server) GetConfig(ctx context.Context, instID flux.InstanceID, fingerprint string) (flux.InstanceConfig, error) {
 	fullConfig, err := s.config.GetConfig(instID)
 	if err != nil {
 		return flux.InstanceConfig{}, err
@@ -351,14 +1065,29 @@ func (s *Server) GetConfig(instID flux.InstanceID, fingerprint string) (flux.Ins
 	return config, nil
 }
 
-func (s *Server) SetConfig(instID flux.InstanceID, updates flux.UnsafeInstanceConfig) error {
-	if _, err := registry.CredentialsFromConfig(updates); err != nil {
-		return errors.Wrap(err, "invalid registry credentials")
+func (s *Server) SetConfig(ctx context.Context, instID flux.InstanceID, updates flux.UnsafeInstanceConfig) error {
+	if err := checkConfig(updates); err != nil {
+		return err
 	}
 	return s.config.UpdateConfig(instID, applyConfigUpdates(updates))
 }
 
-func (s *Server) PatchConfig(instID flux.InstanceID, patch flux.ConfigPatch) error {
+// ValidateConfig checks a config as SetConfig would, and, if the
+// instancer can, that the config repo can be cloned with it; but
+// doesn't save it.
+func (s *Server) ValidateConfig(ctx context.Context, instID flux.InstanceID, config flux.UnsafeInstanceConfig) error {
+	if err := checkConfig(config); err != nil {
+		return err
+	}
+	if checker, ok := s.instancer.(instance.RepoChecker); ok {
+		if err := checker.CheckRepo(instID, config); err != nil {
+			return errors.Wrap(err, "checking git repo")
+		}
+	}
+	return nil
+}
+
+func (s *Server) PatchConfig(ctx context.Context, instID flux.InstanceID, patch flux.ConfigPatch) error {
 	fullConfig, err := s.config.GetConfig(instID)
 	if err != nil {
 		return errors.Wrap(err, "unable to get config")
@@ -369,12 +1098,40 @@ func (s *Server) PatchConfig(instID flux.InstanceID, patch flux.ConfigPatch) err
 		return errors.Wrap(err, "unable to apply patch")
 	}
 
-	if _, err := registry.CredentialsFromConfig(patchedConfig); err != nil {
-		return errors.Wrap(err, "invalid registry credentials")
+	if err := checkConfig(patchedConfig); err != nil {
+		return err
 	}
 	return s.config.UpdateConfig(instID, applyConfigUpdates(patchedConfig))
 }
 
+// checkConfig checks the parts of a config that can be checked
+// without going anywhere.
+func checkConfig(config flux.UnsafeInstanceConfig) error {
+	if _, err := registry.CredentialsFromConfig(config); err != nil {
+		return errors.Wrap(err, "invalid registry credentials")
+	}
+	if _, err := registry.NewNotary(config, registry.Credentials{}); err != nil {
+		return errors.Wrap(err, "invalid registry trust")
+	}
+	if err := git.CheckKnownHosts(config.Git.KnownHosts); err != nil {
+		return errors.Wrap(err, "invalid git known hosts")
+	}
+	if err := git.CheckSSHOptions(instance.GitSSHOptions(config.Git.SSH)); err != nil {
+		return errors.Wrap(err, "invalid git SSH options")
+	}
+	// A passphrase already kept in the secret store shows as a
+	// placeholder, and is checked when the key is used.
+	if config.Git.KeyPassphrase == "" || config.Git.HasKeyPassphrase() {
+		if err := git.CheckKeyPassphrase(config.Git.Key, config.Git.KeyPassphrase); err != nil {
+			return err
+		}
+	}
+	if _, err := layout.Get(config.Git.Layout); err != nil {
+		return errors.Wrap(err, "invalid git layout")
+	}
+	return nil
+}
+
 func applyConfigUpdates(updates flux.UnsafeInstanceConfig) instance.UpdateFunc {
 	return func(config instance.Config) (instance.Config, error) {
 		config.Settings = updates
@@ -382,7 +1139,7 @@ func applyConfigUpdates(updates flux.UnsafeInstanceConfig) instance.UpdateFunc {
 	}
 }
 
-func (s *Server) GenerateDeployKey(instID flux.InstanceID) error {
+func (s *Server) GenerateDeployKey(ctx context.Context, instID flux.InstanceID) error {
 	// Generate new key
 	unsafePrivateKey, err := git.NewKeyGenerator().Generate()
 	if err != nil {
@@ -390,16 +1147,27 @@ func (s *Server) GenerateDeployKey(instID flux.InstanceID) error {
 	}
 
 	// Get current config
-	cfg, err := s.GetConfig(instID, "")
+	cfg, err := s.GetConfig(ctx, instID, "")
 	if err != nil {
 		return err
 	}
 	cfg.Git.Key = string(unsafePrivateKey)
+	// The generated key isn't encrypted
+	cfg.Git.KeyPassphrase = ""
 
 	// Set new config
 	return s.config.UpdateConfig(instID, applyConfigUpdates(flux.UnsafeInstanceConfig(cfg)))
 }
 
+// SetBranchProtection records the result of checking the protection
+// of the config branch, so it can be reported in the status.
+func (s *Server) SetBranchProtection(ctx context.Context, instID flux.InstanceID, protection flux.BranchProtection) error {
+	return s.config.UpdateConfig(instID, func(config instance.Config) (instance.Config, error) {
+		config.BranchProtection = &protection
+		return config, nil
+	})
+}
+
 // RegisterDaemon handles a daemon connection. It blocks until the
 // daemon is disconnected.
 //
@@ -433,8 +1201,8 @@ func (s *Server) RegisterDaemon(instID flux.InstanceID, platform platform.Platfo
 	return err
 }
 
-func (s *Server) Export(inst flux.InstanceID) (res []byte, err error) {
-	helper, err := s.instancer.Get(inst)
+func (s *Server) Export(ctx context.Context, inst flux.InstanceID) (res []byte, err error) {
+	helper, err := s.instance(ctx, inst)
 	if err != nil {
 		return res, errors.Wrapf(err, "getting instance")
 	}
@@ -447,6 +1215,44 @@ func (s *Server) Export(inst flux.InstanceID) (res []byte, err error) {
 	return res, nil
 }
 
+// ExportTo writes the export as the daemon sends it, rather than all
+// at once.
+func (s *Server) ExportTo(ctx context.Context, inst flux.InstanceID, w io.Writer) error {
+	helper, err := s.instance(ctx, inst)
+	if err != nil {
+		return errors.Wrapf(err, "getting instance")
+	}
+	if err := helper.ExportTo(w); err != nil {
+		return errors.Wrapf(err, "exporting %s", inst)
+	}
+	return nil
+}
+
+// Trace puts the daemon for the instance in trace mode for the
+// duration given, or takes it out of trace mode if the duration is
+// zero.
+func (s *Server) Trace(ctx context.Context, inst flux.InstanceID, duration time.Duration) error {
+	helper, err := s.instance(ctx, inst)
+	if err != nil {
+		return errors.Wrapf(err, "getting instance")
+	}
+	if err := helper.Platform.Trace(duration); err != nil {
+		return errors.Wrapf(err, "setting trace mode for %s", inst)
+	}
+	return nil
+}
+
+// instance gets the instance, with calls to its daemon given up once
+// the context is done.
+func (s *Server) instance(ctx context.Context, instID flux.InstanceID) (*instance.Instance, error) {
+	inst, err := s.instancer.Get(instID)
+	if err != nil {
+		return nil, err
+	}
+	inst.Platform = platform.WithContext(ctx, inst.Platform)
+	return inst, nil
+}
+
 func (s *Server) instrumentPlatform(instID flux.InstanceID, p platform.Platform) platform.Platform {
 	return &loggingPlatform{
 		platform.Instrument(p),
@@ -454,8 +1260,17 @@ func (s *Server) instrumentPlatform(instID flux.InstanceID, p platform.Platform)
 	}
 }
 
-func (s *Server) IsDaemonConnected(instID flux.InstanceID) error {
-	return s.messageBus.Ping(instID)
+func (s *Server) IsDaemonConnected(ctx context.Context, instID flux.InstanceID) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.messageBus.Ping(instID)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 type loggingPlatform struct {
@@ -463,6 +1278,10 @@ type loggingPlatform struct {
 	logger   log.Logger
 }
 
+func (p *loggingPlatform) WithTrace(ctx context.Context) platform.Platform {
+	return &loggingPlatform{platform.WithTrace(ctx, p.platform), p.logger}
+}
+
 func (p *loggingPlatform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) (ss []platform.Service, err error) {
 	defer func() {
 		if err != nil {
@@ -518,6 +1337,15 @@ func (p *loggingPlatform) Export() (config []byte, err error) {
 	return p.platform.Export()
 }
 
+func (p *loggingPlatform) ExportTo(w io.Writer) (err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "ExportTo", "error", err)
+		}
+	}()
+	return p.platform.ExportTo(w)
+}
+
 func (p *loggingPlatform) Sync(def platform.SyncDef) (err error) {
 	defer func() {
 		if err != nil {
@@ -526,3 +1354,22 @@ func (p *loggingPlatform) Sync(def platform.SyncDef) (err error) {
 	}()
 	return p.platform.Sync(def)
 }
+
+func (p *loggingPlatform) RegistryCredentials() (auths map[string]flux.Auth, err error) {
+	defer func() {
+		if err != nil {
+			// Omit the credentials, which are secret
+			p.logger.Log("method", "RegistryCredentials", "error", err)
+		}
+	}()
+	return p.platform.RegistryCredentials()
+}
+
+func (p *loggingPlatform) Trace(d time.Duration) (err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "Trace", "error", err)
+		}
+	}()
+	return p.platform.Trace(d)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/platform"
+)
+
+func TestIsStale(t *testing.T) {
+	var images []flux.ImageDescription
+	for _, s := range []string{"owner/repo:latest", "owner/repo:debug", "owner/repo:master-a000002", "owner/repo:master-a000001"} {
+		id, _ := flux.ParseImageID(s)
+		images = append(images, flux.ImageDescription{ID: id})
+	}
+	m := instance.ImageMap{"owner/repo": images}
+
+	for _, c := range []struct {
+		running   string
+		tagFilter string
+		expected  bool
+	}{
+		{"owner/repo:master-a000001", "", true},
+		{"owner/repo:debug", "", false},
+		// The newest tag, debug, isn't one automation would release
+		{"owner/repo:master-a000002", "master-*", false},
+		{"owner/repo:master-a000001", "master-*", true},
+		// Nothing matches, so there's nothing to be behind
+		{"owner/repo:master-a000001", "v*", false},
+	} {
+		service := platform.Service{
+			ID: flux.ServiceID("default/helloworld"),
+			Containers: platform.ContainersOrExcuse{
+				Containers: []platform.Container{{Name: "helloworld", Image: c.running}},
+			},
+		}
+		conf := instance.ServiceConfig{TagFilter: c.tagFilter}
+		if stale := isStale(service, conf, m); stale != c.expected {
+			t.Errorf("running %s with tag filter %q: expected stale to be %v, got %v", c.running, c.tagFilter, c.expected, stale)
+		}
+	}
+}
@@ -11,17 +11,23 @@ import (
 )
 
 const (
-	ServiceSpecAll  = ServiceSpec("<all>")
-	ImageSpecLatest = ImageSpec("<all latest>")
-	ImageSpecNone   = ImageSpec("<no updates>")
-	PolicyNone      = Policy("")
-	PolicyLocked    = Policy("locked")
-	PolicyAutomated = Policy("automated")
+	ServiceSpecAll       = ServiceSpec("<all>")
+	ServiceSpecAutomated = ServiceSpec("<all automated>")
+	ImageSpecLatest      = ImageSpec("<all latest>")
+	ImageSpecNone        = ImageSpec("<no updates>")
+	PolicyNone           = Policy("")
+	PolicyLocked         = Policy("locked")
+	PolicyAutomated      = Policy("automated")
+
+	// PolicyMinReleaseInterval is not exclusive of the others; it
+	// limits how often automation will release a service.
+	PolicyMinReleaseInterval = Policy("min-release-interval")
 )
 
 var (
 	ErrInvalidServiceID   = errors.New("invalid service ID")
 	ErrInvalidReleaseKind = errors.New("invalid release kind")
+	ErrInvalidValueKind   = errors.New("invalid kind of value to update")
 )
 
 type Token string
@@ -155,11 +161,25 @@ func (ids ServiceIDs) Intersection(others ServiceIDSet) ServiceIDSet {
 	return set.Intersection(others)
 }
 
-type ServiceSpec string // ServiceID or "<all>"
+type ServiceSpec string // ServiceID, "<all>", "<all automated>" or "selector:<labels>"
+
+// ServiceSpecSelectorPrefix starts a service spec that selects the
+// services with the labels given, e.g., `selector:app=frontend,tier=web`.
+const ServiceSpecSelectorPrefix = "selector:"
 
 func ParseServiceSpec(s string) (ServiceSpec, error) {
-	if s == string(ServiceSpecAll) {
+	switch s {
+	case string(ServiceSpecAll):
 		return ServiceSpecAll, nil
+	case string(ServiceSpecAutomated):
+		return ServiceSpecAutomated, nil
+	}
+	if strings.HasPrefix(s, ServiceSpecSelectorPrefix) {
+		selector, err := ParseLabelSelector(strings.TrimPrefix(s, ServiceSpecSelectorPrefix))
+		if err != nil {
+			return "", errors.Wrap(err, "invalid service spec")
+		}
+		return ServiceSpecSelector(selector), nil
 	}
 	id, err := ParseServiceID(s)
 	if err != nil {
@@ -172,10 +192,69 @@ func (s ServiceSpec) AsID() (ServiceID, error) {
 	return ParseServiceID(string(s))
 }
 
+// ServiceSpecSelector gives the spec for the services matching the
+// selector.
+func ServiceSpecSelector(selector LabelSelector) ServiceSpec {
+	return ServiceSpec(ServiceSpecSelectorPrefix + selector.String())
+}
+
+// AsSelector gives the label selector of a spec, and whether it is
+// one.
+func (s ServiceSpec) AsSelector() (LabelSelector, bool) {
+	if !strings.HasPrefix(string(s), ServiceSpecSelectorPrefix) {
+		return nil, false
+	}
+	selector, err := ParseLabelSelector(strings.TrimPrefix(string(s), ServiceSpecSelectorPrefix))
+	return selector, err == nil
+}
+
 func (s ServiceSpec) String() string {
 	return string(s)
 }
 
+// LabelSelector selects services by their labels: a service is
+// selected if it has every one of the labels with the value given.
+type LabelSelector map[string]string
+
+// ParseLabelSelector parses selectors of the form
+// `key=value,key=value`.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	selector := LabelSelector{}
+	for _, term := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(term), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label selector term %q, expected key=value", term)
+		}
+		if _, dup := selector[kv[0]]; dup {
+			return nil, fmt.Errorf("label %q given more than once in selector", kv[0])
+		}
+		selector[kv[0]] = kv[1]
+	}
+	return selector, nil
+}
+
+// Matches says whether the labels given have all those in the
+// selector.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for k, v := range s {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// String gives the selector in the form it's parsed from, with the
+// labels in order.
+func (s LabelSelector) String() string {
+	var terms []string
+	for k, v := range s {
+		terms = append(terms, k+"="+v)
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, ",")
+}
+
 // ImageSpec is an ImageID, or "<all latest>" (update all containers
 // to the latest available), or "<no updates>" (do not update any
 // images)
@@ -212,6 +291,31 @@ type ImageStatus struct {
 	Containers []Container
 }
 
+// ImageStatusFilter is a condition a service must meet to be included
+// when listing images.
+type ImageStatusFilter string
+
+const (
+	ImageStatusAutomated = ImageStatusFilter("automated")
+	ImageStatusLocked    = ImageStatusFilter("locked")
+	// ImageStatusStale is for services with at least one container not
+	// running the latest image automation would release to it.
+	ImageStatusStale = ImageStatusFilter("stale")
+)
+
+func ParseImageStatusFilter(s string) (ImageStatusFilter, error) {
+	for _, f := range []ImageStatusFilter{
+		ImageStatusAutomated,
+		ImageStatusLocked,
+		ImageStatusStale,
+	} {
+		if s == string(f) {
+			return f, nil
+		}
+	}
+	return "", errors.Errorf("invalid filter %q; expected one of automated, locked, stale", s)
+}
+
 // Policy is an string, denoting the current deployment policy of a service,
 // e.g. automated, or locked.
 type Policy string
@@ -228,12 +332,39 @@ func ParsePolicy(s string) Policy {
 	return PolicyNone
 }
 
+// ServiceChange is the last change to some aspect of a service -- one
+// of its policies, or the image used by one of its containers -- with
+// who made it, or which release, as far as is known.
+type ServiceChange struct {
+	Kind      string    `json:"kind"` // ServiceChangePolicy or ServiceChangeImage
+	Name      string    `json:"name"` // the policy, or the container
+	Value     string    `json:"value"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"`
+	Revision  string    `json:"revision,omitempty"`
+	ReleaseID ReleaseID `json:"releaseID,omitempty"`
+	EventID   EventID   `json:"eventID,omitempty"`
+}
+
+const (
+	ServiceChangePolicy = "policy"
+	ServiceChangeImage  = "image"
+)
+
 type ServiceStatus struct {
 	ID         ServiceID
 	Containers []Container
 	Status     string
 	Automated  bool
 	Locked     bool
+	// MinReleaseInterval, if not zero, is the shortest time between
+	// automated releases of the service.
+	MinReleaseInterval time.Duration `json:",omitempty"`
+	// TagFilter, if not blank, is the pattern an image's tag must
+	// match for automation to release it.
+	TagFilter string `json:",omitempty"`
+	// Labels are those of the service, which a selector may match.
+	Labels map[string]string `json:",omitempty"`
 }
 
 func (s ServiceStatus) Policies() string {
@@ -245,6 +376,9 @@ func (s ServiceStatus) Policies() string {
 		ps = append(ps, string(PolicyLocked))
 	}
 	sort.Strings(ps)
+	if s.MinReleaseInterval > 0 {
+		ps = append(ps, fmt.Sprintf("%s=%s", PolicyMinReleaseInterval, s.MinReleaseInterval))
+	}
 	return strings.Join(ps, ",")
 }
 
@@ -257,14 +391,25 @@ type Container struct {
 type ImageDescription struct {
 	ID        ImageID
 	CreatedAt *time.Time `json:",omitempty"`
+	// Digest and Signed are only filled in for images from
+	// registries with trust configured.
+	Digest string `json:",omitempty"`
+	Signed *bool  `json:",omitempty"`
+	// Platforms are filled in for multi-arch images, with the digest
+	// of the image for each platform.
+	Platforms []ImagePlatform `json:",omitempty"`
 }
 
 // Ask me for more details.
 type HistoryEntry struct {
-	Stamp *time.Time `json:",omitempty"`
-	Type  string
-	Data  string
-	Event *Event `json:",omitempty"`
+	Stamp   *time.Time `json:",omitempty"`
+	Type    string
+	Data    string
+	Cluster string `json:",omitempty"`
+	Event   *Event `json:",omitempty"`
+	// Cursor marks the entry's place in the history, so that the
+	// entries before or after it can be asked for (see HistoryQuery).
+	Cursor string `json:",omitempty"`
 }
 
 // TODO: How similar should this be to the `get-config` result?
@@ -272,18 +417,128 @@ type Status struct {
 	Fluxsvc FluxsvcStatus `json:"fluxsvc" yaml:"fluxsvc"`
 	Fluxd   FluxdStatus   `json:"fluxd" yaml:"fluxd"`
 	Git     GitStatus     `json:"git" yaml:"git"`
+	// Automation says whether automation is paused for the instance
+	Automation AutomationStatus `json:"automation" yaml:"automation"`
 }
 
 type FluxsvcStatus struct {
-	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Version    string            `json:"version,omitempty" yaml:"version,omitempty"`
+	Checkpoint *CheckpointStatus `json:"checkpoint,omitempty" yaml:"checkpoint,omitempty"`
+}
+
+// CheckpointStatus is the result of checking for newer versions of
+// flux, and for advisories about the running version.
+type CheckpointStatus struct {
+	LatestVersion string            `json:"latestVersion,omitempty" yaml:"latestVersion,omitempty"`
+	Outdated      bool              `json:"outdated" yaml:"outdated"`
+	Alerts        []CheckpointAlert `json:"alerts,omitempty" yaml:"alerts,omitempty"`
+	CheckedAt     time.Time         `json:"checkedAt" yaml:"checkedAt"`
+}
+
+type CheckpointAlert struct {
+	Level   string `json:"level" yaml:"level"`
+	Message string `json:"message" yaml:"message"`
+	URL     string `json:"url,omitempty" yaml:"url,omitempty"`
 }
 
 type FluxdStatus struct {
 	Connected bool   `json:"connected" yaml:"connected"`
 	Version   string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Unavailable says that calls to the daemon have been failing to
+	// reach it, so they fail straight away until UnavailableUntil
+	// rather than waiting to time out.
+	Unavailable      bool       `json:"unavailable,omitempty" yaml:"unavailable,omitempty"`
+	UnavailableUntil *time.Time `json:"unavailableUntil,omitempty" yaml:"unavailableUntil,omitempty"`
 }
 
 type GitStatus struct {
 	Configured bool   `json:"configured" yaml:"configured"`
 	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+	// ErrorKind is the class of the error, if it's one flux knows:
+	// e.g., "auth-failed", or "network-timeout" (see git.ErrorKind).
+	ErrorKind string `json:"errorKind,omitempty" yaml:"errorKind,omitempty"`
+	// Protection lists the ways the config branch's protection on
+	// GitHub will stop flux pushing to it, if any are known.
+	Protection []string `json:"protection,omitempty" yaml:"protection,omitempty"`
+	// LastSync is when automation last read the config repo
+	// successfully, if it has, and SinceLastSync how long ago that
+	// was. If it was long ago, something may be broken.
+	LastSync      *LastSync `json:"lastSync,omitempty" yaml:"lastSync,omitempty"`
+	SinceLastSync string    `json:"sinceLastSync,omitempty" yaml:"sinceLastSync,omitempty"`
+	// ReadOnly says flux has been told not to write to the repo, so
+	// can't make releases.
+	ReadOnly bool `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+}
+
+// LastSync is when the config repo was last read successfully, and
+// the revision it was at.
+type LastSync struct {
+	Revision string    `json:"revision" yaml:"revision"`
+	At       time.Time `json:"at" yaml:"at"`
+}
+
+type AutomationStatus struct {
+	Paused bool `json:"paused" yaml:"paused"`
+	// Pause is who paused automation, when, and why, if it's paused.
+	Pause *Pause `json:"pause,omitempty" yaml:"pause,omitempty"`
+}
+
+// Pause is a stop put on all automation of an instance -- automated
+// releases, and syncing config from the repo -- e.g., while an
+// incident is dealt with. It lasts until automation is resumed.
+type Pause struct {
+	User   string    `json:"user,omitempty" yaml:"user,omitempty"`
+	Reason string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Since  time.Time `json:"since" yaml:"since"`
+}
+
+// InstanceSummary is an operator's view of an instance, for finding
+// those in trouble.
+type InstanceSummary struct {
+	ID        InstanceID `json:"id"`
+	Connected bool       `json:"connected"`
+	// LastEvent is when the most recent event (e.g., a release) in
+	// the instance's history was recorded, if there's been one.
+	LastEvent *time.Time `json:"lastEvent,omitempty"`
+	// FailedJobs is the number of failed jobs not yet purged, and
+	// RecentErrors the number of events logged at error level in
+	// the last day.
+	FailedJobs   int `json:"failedJobs"`
+	RecentErrors int `json:"recentErrors"`
+}
+
+// Troubled says whether the instance has had failures an operator
+// may want to look at.
+func (s InstanceSummary) Troubled() bool {
+	return s.FailedJobs > 0 || s.RecentErrors > 0
+}
+
+// InstanceQuery says which instances to list, and which page of them.
+type InstanceQuery struct {
+	// Match, if not blank, is a substring of the IDs of instances
+	// to list.
+	Match string
+	// Connected, if not nil, lists only instances whose daemon is
+	// (or isn't) connected.
+	Connected *bool
+	// Troubled lists only troubled instances.
+	Troubled bool
+	// Offset and Limit select a page of the instances, in order of
+	// ID.
+	Offset, Limit int
+}
+
+// InstanceList is a page of instances, along with how many instances
+// there are in all the pages.
+type InstanceList struct {
+	Total     int               `json:"total"`
+	Instances []InstanceSummary `json:"instances"`
+}
+
+// BranchProtection is the result of checking the protection of the
+// config repo's branch on GitHub, when setting up the integration.
+type BranchProtection struct {
+	Branch    string    `json:"branch"`
+	Conflicts []string  `json:"conflicts,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
 }
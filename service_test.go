@@ -23,3 +23,47 @@ func parseSpec(t *testing.T, image string, expectError bool) {
 		t.Fatalf("Expected string spec %q but got %q", image, string(spec))
 	}
 }
+
+func TestParseServiceSpecSelector(t *testing.T) {
+	for _, c := range []struct {
+		in, out string // out is empty when in is invalid
+	}{
+		{"selector:app=frontend", "selector:app=frontend"},
+		{"selector:tier=web, app=frontend", "selector:app=frontend,tier=web"},
+		{"selector:app.kubernetes.io/name=web", "selector:app.kubernetes.io/name=web"},
+		{"selector:app=", "selector:app="},
+		{"selector:", ""},
+		{"selector:app", ""},
+		{"selector:=web", ""},
+		{"selector:app=a,app=b", ""},
+	} {
+		spec, err := ParseServiceSpec(c.in)
+		if c.out == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", c.in, spec)
+			}
+			continue
+		}
+		if err != nil || string(spec) != c.out {
+			t.Errorf("%q: expected %q, got %q, %v", c.in, c.out, spec, err)
+			continue
+		}
+		if _, ok := spec.AsSelector(); !ok {
+			t.Errorf("%q: expected spec to be a selector", c.in)
+		}
+	}
+
+	selector, _ := ParseLabelSelector("app=frontend,tier=web")
+	if !selector.Matches(map[string]string{"app": "frontend", "tier": "web", "version": "2"}) {
+		t.Error("expected selector to match labels with extras")
+	}
+	if selector.Matches(map[string]string{"app": "frontend", "tier": "db"}) {
+		t.Error("expected selector not to match a different value")
+	}
+	if selector.Matches(nil) {
+		t.Error("expected selector not to match no labels")
+	}
+	if _, ok := ServiceSpec("default/helloworld").AsSelector(); ok {
+		t.Error("expected a service ID not to be a selector")
+	}
+}
@@ -0,0 +1,142 @@
+package testsupport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/git"
+)
+
+const gitBranch = "master"
+
+// GitServer serves a config repo, to clone from and push to. It's a
+// bare repo in a temporary directory, rather than wholly in memory,
+// since flux uses the git binary to clone; but it needs no network,
+// and goes away with Close.
+type GitServer struct {
+	dir string
+}
+
+// NewGitServer starts a config repo with a commit of the files given,
+// by path.
+func NewGitServer(files map[string]string) (*GitServer, error) {
+	dir, err := ioutil.TempDir("", "flux-testsupport")
+	if err != nil {
+		return nil, err
+	}
+	s := &GitServer{dir: dir}
+	if _, err := gitCommand("", "init", "--bare", s.url()); err == nil {
+		_, err = gitCommand(s.url(), "symbolic-ref", "HEAD", "refs/heads/"+gitBranch)
+	}
+	if err == nil {
+		err = s.Commit("Initial revision", files)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *GitServer) url() string {
+	return filepath.Join(s.dir, "repo.git")
+}
+
+// Repo gives the config repo, as given to flux.
+func (s *GitServer) Repo() git.Repo {
+	return git.Repo{
+		URL:    s.url(),
+		Branch: gitBranch,
+	}
+}
+
+// Commit commits the files given, as someone other than flux would,
+// and pushes the commit. Files not given are left as they are, but
+// for those to remove.
+func (s *GitServer) Commit(message string, files map[string]string, remove ...string) error {
+	working, err := ioutil.TempDir(s.dir, "working")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(working)
+	if _, err := gitCommand("", "clone", "--quiet", s.url(), working); err != nil {
+		return err
+	}
+	for path, content := range files {
+		path = filepath.Join(working, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			return err
+		}
+	}
+	for _, path := range remove {
+		if err := os.Remove(filepath.Join(working, path)); err != nil {
+			return err
+		}
+	}
+	for _, args := range [][]string{
+		{"add", "--all"},
+		{"-c", "user.name=testsupport", "-c", "user.email=testsupport@example.com", "commit", "--quiet", "--allow-empty", "-m", message},
+		{"push", "--quiet", "origin", "HEAD:refs/heads/" + gitBranch},
+	} {
+		if _, err := gitCommand(working, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revision gives the commit at the head of the branch.
+func (s *GitServer) Revision() (string, error) {
+	rev, err := gitCommand(s.url(), "rev-parse", gitBranch)
+	return strings.TrimSpace(rev), err
+}
+
+// File gives the content of a file, as of the head of the branch.
+func (s *GitServer) File(path string) (string, error) {
+	return gitCommand(s.url(), "show", gitBranch+":"+path)
+}
+
+// Messages gives the messages of the commits on the branch, most
+// recent first.
+func (s *GitServer) Messages() ([]string, error) {
+	out, err := gitCommand(s.url(), "log", "--format=%B%x00", gitBranch)
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, message := range strings.Split(out, "\x00") {
+		if message = strings.TrimSpace(message); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Close removes the repo.
+func (s *GitServer) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// gitCommand runs git in the directory given, if any, and gives what
+// it printed.
+func gitCommand(dir string, args ...string) (string, error) {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	c := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
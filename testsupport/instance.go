@@ -0,0 +1,71 @@
+package testsupport
+
+import (
+	"sync"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/history"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/jobs"
+)
+
+// NewInstance gives an instance that uses the fakes given, with the
+// config given, for a releaser or an automator to work on. Its config
+// is kept in memory, so a test can change it (e.g., to lock a
+// service) through inst.Config.
+func NewInstance(p *Platform, r *Registry, g *GitServer, config instance.Config) *instance.Instance {
+	events := history.NewMock()
+	return instance.New(p, r, &instance.MockConfigurer{Config: config}, g.Repo(), log.NewNopLogger(), events, events)
+}
+
+// Instancer gives the instance given, whichever instance is asked for.
+func Instancer(inst *instance.Instance) instance.Instancer {
+	return &instance.MockInstancer{Instance: inst}
+}
+
+// JobUpdater records the updates made to jobs as they're run, e.g.,
+// by a releaser's Handle.
+type JobUpdater struct {
+	mu      sync.Mutex
+	updates []jobs.Job
+}
+
+func (u *JobUpdater) UpdateJob(job jobs.Job) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.updates = append(u.updates, job)
+	return nil
+}
+
+func (u *JobUpdater) Heartbeat(jobs.JobID) error {
+	return nil
+}
+
+// Updates gives the updates made so far, in order.
+func (u *JobUpdater) Updates() []jobs.Job {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]jobs.Job(nil), u.updates...)
+}
+
+// Last gives the job as last updated, and so its latest status and
+// result.
+func (u *JobUpdater) Last() (jobs.Job, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.updates) == 0 {
+		return jobs.Job{}, false
+	}
+	return u.updates[len(u.updates)-1], true
+}
+
+// ReleaseJob gives a job to release as the spec given says.
+func ReleaseJob(inst flux.InstanceID, spec flux.ReleaseSpec) *jobs.Job {
+	return &jobs.Job{
+		Instance: inst,
+		Method:   jobs.ReleaseJob,
+		Params:   jobs.ReleaseJobParams{ReleaseSpec: spec},
+	}
+}
@@ -0,0 +1,344 @@
+// Package testsupport has fakes of what flux works with from outside
+// -- the platform, the config repo, and image registries -- for
+// writing end-to-end tests of releases and syncs that run in a few
+// seconds, without a cluster or a registry.
+//
+// The fakes keep their state in memory (the config repo in a
+// temporary directory), and can be inspected and changed by the test
+// while flux is using them; e.g., a test can push an image to the
+// registry, run an automated release, then look at what was
+// committed to the repo and applied to the platform.
+//
+// Releases find the services defined in the repo with kubeservice,
+// so as for flux's own tests, cmd/fluxsvc/kubeservice must be on the
+// PATH.
+package testsupport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/platform"
+)
+
+// Platform is a fake platform.Platform, holding services in memory.
+// Applying or syncing a definition of a deployment changes the images
+// of the service of the same name, as it would in a cluster, so what
+// was released can be seen in the services the platform gives after.
+// Calls can be made to fail with Script and FailApply.
+type Platform struct {
+	mu          sync.Mutex
+	services    map[flux.ServiceID]platform.Service
+	definitions map[flux.ServiceID][]byte
+	script      map[string][]error
+	applyErrors map[flux.ServiceID]error
+	applied     []platform.ServiceDefinition
+	synced      []platform.SyncDef
+	traces      []time.Duration
+	credentials map[string]flux.Auth
+}
+
+var _ platform.Platform = &Platform{}
+
+// NewPlatform gives a platform with the services given.
+func NewPlatform(services ...platform.Service) *Platform {
+	p := &Platform{
+		services:    map[flux.ServiceID]platform.Service{},
+		definitions: map[flux.ServiceID][]byte{},
+		script:      map[string][]error{},
+		applyErrors: map[flux.ServiceID]error{},
+	}
+	for _, s := range services {
+		p.services[s.ID] = s
+	}
+	return p
+}
+
+// Script makes the next calls to the method named (e.g., "Sync")
+// give the errors given, in turn; a nil error lets that call go
+// ahead. Once they've all been given, calls go ahead as usual.
+func (p *Platform) Script(method string, errs ...error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.script[method] = append(p.script[method], errs...)
+}
+
+// FailApply makes applying a definition of the service given fail
+// with the error given, or if it's nil, succeed again. Other services
+// applied at the same time are still applied.
+func (p *Platform) FailApply(id flux.ServiceID, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.applyErrors, id)
+		return
+	}
+	p.applyErrors[id] = err
+}
+
+// SetRegistryCredentials sets the credentials the platform gives, as
+// though from image pull secrets.
+func (p *Platform) SetRegistryCredentials(credentials map[string]flux.Auth) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.credentials = credentials
+}
+
+// Service gives the service as it is now.
+func (p *Platform) Service(id flux.ServiceID) (platform.Service, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.services[id]
+	return s, ok
+}
+
+// Applied gives the definitions applied so far, in order.
+func (p *Platform) Applied() []platform.ServiceDefinition {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]platform.ServiceDefinition(nil), p.applied...)
+}
+
+// Synced gives the syncs done so far, in order.
+func (p *Platform) Synced() []platform.SyncDef {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]platform.SyncDef(nil), p.synced...)
+}
+
+// scripted gives the next error scripted for the method; the lock
+// must be held.
+func (p *Platform) scripted(method string) error {
+	errs := p.script[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	p.script[method] = errs[1:]
+	return errs[0]
+}
+
+func (p *Platform) AllServices(maybeNamespace string, ignored flux.ServiceIDSet) ([]platform.Service, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("AllServices"); err != nil {
+		return nil, err
+	}
+	var services []platform.Service
+	for _, id := range p.serviceIDs() {
+		namespace, _ := id.Components()
+		if (maybeNamespace == "" || namespace == maybeNamespace) && !ignored.Contains(id) {
+			services = append(services, p.services[id])
+		}
+	}
+	return services, nil
+}
+
+func (p *Platform) SomeServices(ids []flux.ServiceID) ([]platform.Service, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("SomeServices"); err != nil {
+		return nil, err
+	}
+	var services []platform.Service
+	for _, id := range ids {
+		if s, ok := p.services[id]; ok {
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
+
+func (p *Platform) Apply(defs []platform.ServiceDefinition) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("Apply"); err != nil {
+		return err
+	}
+	errs := platform.ApplyError{}
+	for _, def := range defs {
+		if err := p.applyErrors[def.ServiceID]; err != nil {
+			errs[def.ServiceID] = err
+			continue
+		}
+		if _, ok := p.services[def.ServiceID]; !ok {
+			errs[def.ServiceID] = platform.ErrNoMatchingService
+			continue
+		}
+		if err := p.apply(def.ServiceID, def.NewDefinition); err != nil {
+			errs[def.ServiceID] = err
+			continue
+		}
+		p.applied = append(p.applied, def)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *Platform) Sync(def platform.SyncDef) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("Sync"); err != nil {
+		return err
+	}
+	p.synced = append(p.synced, def)
+	errs := platform.SyncError{}
+	for _, action := range def.Actions {
+		var err error
+		switch {
+		case len(action.Delete) > 0:
+			var id flux.ServiceID
+			if id, _, err = parseDefinition(action.Delete); err == nil {
+				delete(p.services, id)
+				delete(p.definitions, id)
+			}
+		case len(action.Apply) > 0:
+			var id flux.ServiceID
+			if id, _, err = parseDefinition(action.Apply); err == nil {
+				if _, ok := p.services[id]; !ok {
+					p.services[id] = platform.Service{ID: id}
+				}
+				err = p.apply(id, action.Apply)
+			}
+		}
+		if err != nil {
+			errs[action.ResourceID] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// apply gives the service the containers in the definition; the lock
+// must be held.
+func (p *Platform) apply(id flux.ServiceID, def []byte) error {
+	_, containers, err := parseDefinition(def)
+	if err != nil {
+		return err
+	}
+	s := p.services[id]
+	if containers != nil {
+		s.Containers = platform.ContainersOrExcuse{Containers: containers}
+	}
+	p.services[id] = s
+	p.definitions[id] = def
+	return nil
+}
+
+func (p *Platform) Ping() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scripted("Ping")
+}
+
+func (p *Platform) Version() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return "testsupport", p.scripted("Version")
+}
+
+// Export gives the definitions applied or synced so far, the latest
+// for each service.
+func (p *Platform) Export() ([]byte, error) {
+	var buf bytes.Buffer
+	err := p.ExportTo(&buf)
+	return buf.Bytes(), err
+}
+
+func (p *Platform) ExportTo(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("Export"); err != nil {
+		return err
+	}
+	for _, id := range p.serviceIDs() {
+		def, ok := p.definitions[id]
+		if !ok {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Platform) Trace(d time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.scripted("Trace"); err != nil {
+		return err
+	}
+	p.traces = append(p.traces, d)
+	return nil
+}
+
+func (p *Platform) RegistryCredentials() (map[string]flux.Auth, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.credentials, p.scripted("RegistryCredentials")
+}
+
+// serviceIDs gives the IDs of the services, in order; the lock must
+// be held.
+func (p *Platform) serviceIDs() []flux.ServiceID {
+	var ids []string
+	for id := range p.services {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	serviceIDs := make([]flux.ServiceID, len(ids))
+	for i, id := range ids {
+		serviceIDs[i] = flux.ServiceID(id)
+	}
+	return serviceIDs
+}
+
+type definition struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Name  string `yaml:"name"`
+					Image string `yaml:"image"`
+				} `yaml:"containers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// parseDefinition gives the ID of the service a definition is for,
+// and, if it's a pod controller, its containers.
+func parseDefinition(def []byte) (flux.ServiceID, []platform.Container, error) {
+	var d definition
+	if err := yaml.Unmarshal(def, &d); err != nil {
+		return "", nil, err
+	}
+	if d.Metadata.Name == "" {
+		return "", nil, fmt.Errorf("definition of %s has no name", d.Kind)
+	}
+	namespace := d.Metadata.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	var containers []platform.Container
+	for _, c := range d.Spec.Template.Spec.Containers {
+		containers = append(containers, platform.Container{Name: c.Name, Image: c.Image})
+	}
+	return flux.MakeServiceID(namespace, d.Metadata.Name), containers, nil
+}
@@ -0,0 +1,131 @@
+package testsupport
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/registry"
+)
+
+// Registry is a fake registry.Registry, holding images in memory.
+// Images are pushed to it by the test, as a CI system would push
+// them, and it gives them newest first, like a real registry.
+type Registry struct {
+	mu     sync.Mutex
+	images map[string][]registryImage // by repository
+	err    error
+}
+
+type registryImage struct {
+	flux.Image
+	digest    string
+	platforms []flux.ImagePlatform
+}
+
+var _ registry.Registry = &Registry{}
+
+func NewRegistry() *Registry {
+	return &Registry{images: map[string][]registryImage{}}
+}
+
+// Push adds the image given, e.g., "quay.io/weaveworks/helloworld:v2",
+// created at the time given; pushing a tag again replaces the image
+// it's for. The image is given a digest made from its name and
+// creation time, so images pushed under two tags at once share one.
+func (r *Registry) Push(image string, created time.Time) error {
+	return r.PushMultiArch(image, created, nil)
+}
+
+// PushMultiArch adds a multi-arch image, for the platforms given.
+func (r *Registry) PushMultiArch(image string, created time.Time, platforms []flux.ImagePlatform) error {
+	id, err := flux.ParseImageID(image)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo := id.HostNamespaceImage()
+	img := registryImage{
+		Image:     flux.Image{ImageID: id, CreatedAt: &created},
+		digest:    fmt.Sprintf("sha256:%x", created.UnixNano()),
+		platforms: platforms,
+	}
+	images := r.images[repo]
+	for i := range images {
+		if images[i].ImageID.Tag == id.Tag {
+			images = append(images[:i], images[i+1:]...)
+			break
+		}
+	}
+	r.images[repo] = append(images, img)
+	return nil
+}
+
+// SetError makes every query fail with the error given, e.g., to
+// test what happens when the registry can't be reached; nil makes
+// them succeed again.
+func (r *Registry) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+func (r *Registry) GetRepository(repository registry.Repository) ([]flux.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	images := r.images[repository.String()]
+	result := make([]flux.Image, len(images))
+	for i := range images {
+		result[i] = images[i].Image
+	}
+	sort.Sort(byCreatedDesc(result))
+	return result, nil
+}
+
+func (r *Registry) GetImage(repository registry.Repository, tag string) (flux.Image, error) {
+	img, err := r.image(repository, tag)
+	return img.Image, err
+}
+
+func (r *Registry) GetImageDigest(repository registry.Repository, tag string) (string, error) {
+	img, err := r.image(repository, tag)
+	return img.digest, err
+}
+
+func (r *Registry) GetImagePlatforms(repository registry.Repository, tag string) ([]flux.ImagePlatform, error) {
+	img, err := r.image(repository, tag)
+	return img.platforms, err
+}
+
+func (r *Registry) image(repository registry.Repository, tag string) (registryImage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return registryImage{}, r.err
+	}
+	for _, img := range r.images[repository.String()] {
+		if img.ImageID.Tag == tag {
+			return img, nil
+		}
+	}
+	return registryImage{}, errors.Errorf("image %s:%s not found", repository, tag)
+}
+
+type byCreatedDesc []flux.Image
+
+func (is byCreatedDesc) Len() int      { return len(is) }
+func (is byCreatedDesc) Swap(i, j int) { is[i], is[j] = is[j], is[i] }
+func (is byCreatedDesc) Less(i, j int) bool {
+	if is[i].CreatedAt.Equal(*is[j].CreatedAt) {
+		return is[i].ImageID.String() < is[j].ImageID.String()
+	}
+	return is[i].CreatedAt.After(*is[j].CreatedAt)
+}
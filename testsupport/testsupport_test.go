@@ -0,0 +1,206 @@
+package testsupport_test
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/embedded"
+	"github.com/weaveworks/flux/instance"
+	"github.com/weaveworks/flux/platform"
+	"github.com/weaveworks/flux/platform/kubernetes/testfiles"
+	"github.com/weaveworks/flux/registry"
+	"github.com/weaveworks/flux/release"
+	"github.com/weaveworks/flux/testsupport"
+)
+
+const (
+	helloworld   = flux.ServiceID("default/helloworld")
+	oldImage     = "quay.io/weaveworks/helloworld:master-a000001"
+	newImage     = "quay.io/weaveworks/helloworld:master-a000002"
+	latestImage  = "quay.io/weaveworks/helloworld:latest"
+	deployment   = "helloworld-deploy.yaml"
+	sidecarImage = "quay.io/weaveworks/sidecar:master-a000002"
+)
+
+// Releases find the services defined in the repo with kubeservice
+// (from cmd/fluxsvc), which must be on the PATH.
+func needKubeservice(t *testing.T) {
+	if _, err := exec.LookPath("kubeservice"); err != nil {
+		t.Skip("kubeservice is not on the PATH")
+	}
+}
+
+func releaseSpec() flux.ReleaseSpec {
+	return flux.ReleaseSpec{
+		ServiceSpecs: []flux.ServiceSpec{flux.ServiceSpec(helloworld)},
+		ImageSpec:    flux.ImageSpecLatest,
+		Kind:         flux.ReleaseKindExecute,
+	}
+}
+
+// A sync, then a release of a new image, then a sync, all through an
+// embedded daemon, as fluxd would do them.
+func TestSyncAndRelease(t *testing.T) {
+	needKubeservice(t)
+	repo, err := testsupport.NewGitServer(testfiles.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	cluster := testsupport.NewPlatform()
+	images := testsupport.NewRegistry()
+
+	d, err := embedded.New(embedded.Config{Platform: cluster, Repo: repo.Repo(), Registry: images})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, err := d.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s, ok := cluster.Service(helloworld)
+	if !ok || len(s.ContainersOrNil()) != 2 || s.ContainersOrNil()[0].Image != oldImage {
+		t.Fatalf("expected %s synced with image %s, got %+v", helloworld, oldImage, s)
+	}
+
+	if err := images.Push(newImage, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.Release(releaseSpec(), flux.ReleaseCause{User: "jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[helloworld].Status != flux.ReleaseStatusSuccess {
+		t.Errorf("expected the release to succeed, got %+v", result)
+	}
+	def, err := repo.File(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(def, newImage) || !strings.Contains(def, sidecarImage) {
+		t.Errorf("expected %s committed, and the sidecar left as it was, got:\n%s", newImage, def)
+	}
+	if s, _ := cluster.Service(helloworld); s.ContainersOrNil()[0].Image != newImage {
+		t.Errorf("expected %s applied, got %+v", newImage, s)
+	}
+
+	// Someone else changes the repo; the next sync applies it
+	if err := repo.Commit("Roll back", map[string]string{deployment: testfiles.Files[deployment]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := cluster.Service(helloworld); s.ContainersOrNil()[0].Image != oldImage {
+		t.Errorf("expected %s synced, got %+v", oldImage, s)
+	}
+	messages, err := repo.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[0] != "Roll back" || messages[2] != "Initial revision" {
+		t.Errorf("unexpected commits: %q", messages)
+	}
+}
+
+// A release through a releaser, as fluxsvc would run the job, with
+// the platform failing to apply.
+func TestReleaseJob(t *testing.T) {
+	needKubeservice(t)
+	repo, err := testsupport.NewGitServer(testfiles.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	cluster := testsupport.NewPlatform(platform.Service{
+		ID: helloworld,
+		Containers: platform.ContainersOrExcuse{Containers: []platform.Container{
+			{Name: "helloworld", Image: oldImage},
+			{Name: "sidecar", Image: sidecarImage},
+		}},
+	})
+	images := testsupport.NewRegistry()
+	if err := images.Push(newImage, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	inst := testsupport.NewInstance(cluster, images, repo, instance.Config{})
+	releaser := release.NewReleaser(testsupport.Instancer(inst), false)
+
+	cluster.FailApply(helloworld, errors.New("quota exceeded"))
+	updates := &testsupport.JobUpdater{}
+	if _, err := releaser.Handle(testsupport.ReleaseJob("test", releaseSpec()), updates); err == nil {
+		t.Error("expected the release to fail")
+	}
+	job, ok := updates.Last()
+	if !ok {
+		t.Fatal("expected updates to the job")
+	}
+	result, _ := job.Result.(flux.ReleaseResult)
+	if result[helloworld].Status != flux.ReleaseStatusFailed {
+		t.Errorf("expected the service to have failed, got %+v", result)
+	}
+	if len(cluster.Applied()) != 0 {
+		t.Errorf("expected nothing applied, got %+v", cluster.Applied())
+	}
+}
+
+func TestPlatformScript(t *testing.T) {
+	p := testsupport.NewPlatform(platform.Service{ID: helloworld})
+	unreachable := errors.New("unreachable")
+	p.Script("AllServices", unreachable, nil, unreachable)
+	for i, expected := range []error{unreachable, nil, unreachable, nil} {
+		if _, err := p.AllServices("", nil); err != expected {
+			t.Errorf("call %d: expected %v, got %v", i, expected, err)
+		}
+	}
+
+	err := p.Sync(platform.SyncDef{Actions: []platform.SyncAction{
+		{ResourceID: "bad", Apply: []byte("kind: Deployment\n")},
+	}})
+	if syncErr, ok := err.(platform.SyncError); !ok || syncErr["bad"] == nil {
+		t.Errorf("expected an error for the definition without a name, got %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := testsupport.NewRegistry()
+	now := time.Now()
+	for image, created := range map[string]time.Time{
+		oldImage:    now.Add(-time.Hour),
+		newImage:    now,
+		latestImage: now.Add(-2 * time.Hour),
+	} {
+		if err := r.Push(image, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repository, err := registry.ParseRepository(oldImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := r.GetRepository(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, img := range imgs {
+		got = append(got, img.ImageID.String())
+	}
+	expected := []string{newImage, oldImage, latestImage}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected images newest first %q, got %q", expected, got)
+	}
+
+	if _, err := r.GetImage(repository, "nope"); err == nil {
+		t.Error("expected an error for a tag not pushed")
+	}
+	r.SetError(errors.New("unreachable"))
+	if _, err := r.GetRepository(repository); err == nil {
+		t.Error("expected the error set")
+	}
+}
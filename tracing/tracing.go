@@ -0,0 +1,210 @@
+// Package tracing follows requests from the API through fluxsvc, its
+// jobs, and the daemon, as a tree of timed spans sharing a trace ID.
+//
+// It's in the manner of OpenTracing, without the dependency: spans
+// are carried in contexts, and passed between processes in the B3
+// headers that Zipkin and tracers compatible with it use, so a trace
+// started by a caller is continued here. Finished spans go to the
+// Reporter, if one is set; e.g., to be logged.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// The B3 headers, in which spans are passed over HTTP.
+const (
+	TraceIDHeader = "X-B3-TraceId"
+	SpanIDHeader  = "X-B3-SpanId"
+)
+
+// SpanContext identifies a span, so others can be started as its
+// children, whether here or in another process.
+type SpanContext struct {
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+// IsZero says whether the span context identifies no span.
+func (c SpanContext) IsZero() bool {
+	return c.TraceID == "" || c.SpanID == ""
+}
+
+// Span is an operation being timed, as part of a trace.
+type Span struct {
+	SpanContext
+	ParentID  string
+	Operation string
+	Start     time.Time
+	Duration  time.Duration
+
+	mu       sync.Mutex
+	tags     map[string]string
+	finished bool
+}
+
+// SetTag annotates the span, e.g., with what it's operating on.
+func (s *Span) SetTag(key string, value interface{}) {
+	s.mu.Lock()
+	s.tags[key] = fmt.Sprint(value)
+	s.mu.Unlock()
+}
+
+// SetError tags the span with the error, if there is one.
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetTag("error", err)
+	}
+}
+
+// Tags gives the span's tags.
+func (s *Span) Tags() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Finish ends the span, and reports it. Only the first call does
+// anything, so it's fine to finish a span in a defer as well as when
+// it's done.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.Duration = time.Since(s.Start)
+	s.mu.Unlock()
+
+	if r := getReporter(); r != nil {
+		r.Report(s)
+	}
+}
+
+type contextKey struct{}
+
+// ContextWithSpan gives a context carrying the span given, so spans
+// started from it are the span's children. It's for continuing a
+// trace from another process; spans started here are already in the
+// context StartSpan gives.
+func ContextWithSpan(ctx context.Context, c SpanContext) context.Context {
+	if c.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext gives the span the context carries, if any.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	c, ok := ctx.Value(contextKey{}).(SpanContext)
+	return c, ok
+}
+
+// StartSpan starts a span as the child of the one in the context, or
+// in a new trace if there isn't one, and gives a context carrying it.
+func StartSpan(ctx context.Context, operation string) (*Span, context.Context) {
+	s := &Span{
+		Operation: operation,
+		Start:     time.Now(),
+		tags:      map[string]string{},
+	}
+	s.SpanID = newID()
+	if parent, ok := FromContext(ctx); ok {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		s.TraceID = s.SpanID
+	}
+	return s, context.WithValue(ctx, contextKey{}, s.SpanContext)
+}
+
+// Inject puts the span the context carries, if any, in the headers.
+func Inject(ctx context.Context, h http.Header) {
+	if c, ok := FromContext(ctx); ok {
+		h.Set(TraceIDHeader, c.TraceID)
+		h.Set(SpanIDHeader, c.SpanID)
+	}
+}
+
+// Extract gives the span in the headers; it's zero if there isn't
+// one.
+func Extract(h http.Header) SpanContext {
+	return SpanContext{
+		TraceID: h.Get(TraceIDHeader),
+		SpanID:  h.Get(SpanIDHeader),
+	}
+}
+
+var (
+	idMu  sync.Mutex
+	idGen = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// newID gives a random 64-bit ID, in hex, as in B3.
+func newID() string {
+	idMu.Lock()
+	id := idGen.Int63()
+	idMu.Unlock()
+	return fmt.Sprintf("%016x", id)
+}
+
+// Reporter is given each span that's finished.
+type Reporter interface {
+	Report(*Span)
+}
+
+var (
+	reporterMu sync.RWMutex
+	reporter   Reporter
+)
+
+// SetReporter sets where finished spans go; nil to drop them.
+func SetReporter(r Reporter) {
+	reporterMu.Lock()
+	reporter = r
+	reporterMu.Unlock()
+}
+
+func getReporter() Reporter {
+	reporterMu.RLock()
+	defer reporterMu.RUnlock()
+	return reporter
+}
+
+// LogReporter logs each span, with its tags, so that a trace can be
+// put together by searching the logs for its ID.
+type LogReporter struct {
+	Logger log.Logger
+}
+
+func (r LogReporter) Report(s *Span) {
+	keyvals := []interface{}{
+		"trace_id", s.TraceID,
+		"span_id", s.SpanID,
+		"parent_id", s.ParentID,
+		"operation", s.Operation,
+		"took", s.Duration.String(),
+	}
+	tags := s.Tags()
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		keyvals = append(keyvals, k, tags[k])
+	}
+	r.Logger.Log(keyvals...)
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type recorder []*Span
+
+func (r *recorder) Report(s *Span) {
+	*r = append(*r, s)
+}
+
+func TestStartSpan(t *testing.T) {
+	var spans recorder
+	SetReporter(&spans)
+	defer SetReporter(nil)
+
+	root, ctx := StartSpan(context.Background(), "request")
+	if root.TraceID == "" || root.ParentID != "" {
+		t.Errorf("expected a new trace, got trace %q, parent %q", root.TraceID, root.ParentID)
+	}
+	child, _ := StartSpan(ctx, "clone")
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("expected a child of %+v, got trace %q, parent %q", root.SpanContext, child.TraceID, child.ParentID)
+	}
+	child.SetError(errors.New("timed out"))
+	child.Finish()
+	child.Finish()
+	root.Finish()
+
+	if len(spans) != 2 || spans[0] != child || spans[1] != root {
+		t.Fatalf("expected each span reported once, as it finished; got %v", spans)
+	}
+	if tags := child.Tags(); tags["error"] != "timed out" {
+		t.Errorf("expected the error tagged, got %v", tags)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	h := http.Header{}
+	Inject(context.Background(), h)
+	if c := Extract(h); !c.IsZero() {
+		t.Errorf("expected no span without one in the context, got %+v", c)
+	}
+
+	span, ctx := StartSpan(context.Background(), "request")
+	Inject(ctx, h)
+	if c := Extract(h); c != span.SpanContext {
+		t.Errorf("expected %+v, got %+v", span.SpanContext, c)
+	}
+
+	// Continued in another process
+	remote, _ := StartSpan(ContextWithSpan(context.Background(), Extract(h)), "rpc")
+	if remote.TraceID != span.TraceID || remote.ParentID != span.SpanID {
+		t.Errorf("expected the trace continued, got trace %q, parent %q", remote.TraceID, remote.ParentID)
+	}
+}
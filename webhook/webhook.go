@@ -0,0 +1,149 @@
+// Package webhook signs the requests flux makes to webhooks (e.g.,
+// release notifications), and verifies them, for those receiving
+// them.
+//
+// A request is signed with a secret shared by flux and the receiver.
+// It has three headers:
+//
+//	X-Flux-Timestamp  when the request was signed, in seconds since
+//	                  the epoch
+//	X-Flux-Nonce      a random string, different for each request
+//	X-Flux-Signature  "sha256=" then the hex-encoded HMAC-SHA256, with
+//	                  the secret, of the timestamp, a ".", the nonce,
+//	                  a ".", and the body
+//
+// A receiver can check the signature and the timestamp, and refuse a
+// nonce it's seen before, so that a request can be neither forged,
+// nor captured and sent again. Verifier does all three:
+//
+//	v := webhook.NewVerifier(secret, 5*time.Minute)
+//	http.Handle("/flux", v.Handler(myHandler))
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Flux-Timestamp"
+	NonceHeader     = "X-Flux-Nonce"
+	SignatureHeader = "X-Flux-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrUnsigned     = errors.New("request is not signed")
+	ErrBadSignature = errors.New("request signature does not match")
+	ErrExpired      = errors.New("request timestamp is too old, or in the future")
+	ErrReplayed     = errors.New("request nonce has been seen before")
+)
+
+// Sign signs the request, whose body is given, with the secret, by
+// setting the headers described above.
+func Sign(req *http.Request, body []byte, secret string) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(random)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, signature(secret, timestamp, nonce, body))
+	return nil
+}
+
+func signature(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.%s.", timestamp, nonce)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks the signatures of requests, and that each is
+// recent, and hasn't been seen before.
+type Verifier struct {
+	secret string
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // when each nonce seen can be forgotten
+}
+
+// NewVerifier returns a Verifier for requests signed with the secret
+// given, and signed within maxAge (either side) of being verified.
+func NewVerifier(secret string, maxAge time.Duration) *Verifier {
+	return &Verifier{
+		secret: secret,
+		maxAge: maxAge,
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Verify reads the body of the request, and returns it if the request
+// is signed, recent, and not seen before; otherwise, it returns an
+// error saying why not. The request's body is replaced, so it can be
+// read again.
+func (v *Verifier) Verify(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	timestamp, nonce, sig := r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader), r.Header.Get(SignatureHeader)
+	if timestamp == "" || nonce == "" || sig == "" {
+		return nil, ErrUnsigned
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(v.secret, timestamp, nonce, body))) {
+		return nil, ErrBadSignature
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrExpired
+	}
+	now := time.Now()
+	signed := time.Unix(secs, 0)
+	if signed.Before(now.Add(-v.maxAge)) || signed.After(now.Add(v.maxAge)) {
+		return nil, ErrExpired
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, forget := range v.nonces {
+		if now.After(forget) {
+			delete(v.nonces, n)
+		}
+	}
+	if _, seen := v.nonces[nonce]; seen {
+		return nil, ErrReplayed
+	}
+	// Once the timestamp is too old, the request would be refused
+	// anyway, so the nonce needn't be remembered.
+	v.nonces[nonce] = signed.Add(v.maxAge)
+	return body, nil
+}
+
+// Handler passes on to next only requests that verify, and responds
+// to the others with 401 Unauthorized.
+func (v *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, body, secret string) *http.Request {
+	req, err := http.NewRequest("POST", "http://example.com/hook", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(req, []byte(body), secret); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestVerify(t *testing.T) {
+	v := NewVerifier("s3cr3t", time.Minute)
+
+	req := signedRequest(t, `{"text":"Release done"}`, "s3cr3t")
+	body, err := v.Verify(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"text":"Release done"}` {
+		t.Errorf("expected the body, got %q", body)
+	}
+
+	// The same request again is a replay
+	if _, err := v.Verify(req); err != ErrReplayed {
+		t.Errorf("expected %v, got %v", ErrReplayed, err)
+	}
+
+	for name, c := range map[string]struct {
+		req      *http.Request
+		expected error
+	}{
+		"wrong secret": {signedRequest(t, "{}", "guess"), ErrBadSignature},
+		"unsigned":     {httptest.NewRequest("POST", "/hook", bytes.NewBufferString("{}")), ErrUnsigned},
+	} {
+		if _, err := v.Verify(c.req); err != c.expected {
+			t.Errorf("%s: expected %v, got %v", name, c.expected, err)
+		}
+	}
+
+	// Changing the body, or the timestamp, spoils the signature
+	tampered := signedRequest(t, `{"text":"Release done"}`, "s3cr3t")
+	tampered.Body = httptest.NewRequest("POST", "/hook", bytes.NewBufferString(`{"text":"Release failed"}`)).Body
+	if _, err := v.Verify(tampered); err != ErrBadSignature {
+		t.Errorf("tampered body: expected %v, got %v", ErrBadSignature, err)
+	}
+	old := signedRequest(t, "{}", "s3cr3t")
+	old.Header.Set(TimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if _, err := v.Verify(old); err != ErrBadSignature {
+		t.Errorf("changed timestamp: expected %v, got %v", ErrBadSignature, err)
+	}
+
+	// A request signed too long ago is refused, even if its
+	// signature is good
+	stale := signedRequest(t, "{}", "s3cr3t")
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	stale.Header.Set(TimestampHeader, timestamp)
+	stale.Header.Set(SignatureHeader, signature("s3cr3t", timestamp, stale.Header.Get(NonceHeader), []byte("{}")))
+	if _, err := v.Verify(stale); err != ErrExpired {
+		t.Errorf("stale: expected %v, got %v", ErrExpired, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var got string
+	h := NewVerifier("s3cr3t", time.Minute).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(r.Body)
+		got = buf.String()
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, signedRequest(t, "{}", "s3cr3t"))
+	if rec.Code != http.StatusOK || got != "{}" {
+		t.Errorf("expected the signed request to be handled, got %d, body %q", rec.Code, got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, signedRequest(t, "{}", "guess"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for a bad signature, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}